@@ -0,0 +1,298 @@
+package access
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/ratelimit"
+)
+
+// Anonymous is the identity of requests that carry no known API key.
+const Anonymous = "anonymous"
+
+// Key maps an API key to an identity and a rate tier.
+type Key struct {
+	Key      string `json:"key"`
+	Identity string `json:"identity"`
+	Tier     string `json:"tier,omitempty"`
+}
+
+// Window restricts a rule to certain days and a time-of-day range. Start and
+// End use "15:04" format; a window whose End is before its Start spans
+// midnight.
+type Window struct {
+	Days     []string `json:"days,omitempty"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Location string   `json:"location,omitempty"`
+}
+
+// Rule is a single policy entry. Empty match fields match everything. Rules
+// are evaluated in order and the first match decides.
+type Rule struct {
+	Name       string   `json:"name"`
+	Identities []string `json:"identities,omitempty"`
+	Tiers      []string `json:"tiers,omitempty"`
+	Methods    string   `json:"methods,omitempty"` // "read", "write" or empty
+	PathPrefix string   `json:"path_prefix,omitempty"`
+	Window     *Window  `json:"window,omitempty"`
+
+	Deny  bool    `json:"deny,omitempty"`
+	Rate  float64 `json:"rate,omitempty"` // requests per second per identity, 0 for unlimited
+	Burst int     `json:"burst,omitempty"`
+}
+
+type Policy struct {
+	Keys  []Key  `json:"keys"`
+	Rules []Rule `json:"rules"`
+}
+
+// Decision is the outcome of evaluating a request against the policy.
+type Decision struct {
+	Identity string
+	Rule     string
+	Allowed  bool
+	Limited  bool
+	// RateLimit is the state of the rule's rate limit, if it has one.
+	RateLimit *ratelimit.Status
+}
+
+type compiledRule struct {
+	Rule
+	days     map[time.Weekday]bool
+	start    int
+	end      int
+	location *time.Location
+	limiter  *ratelimit.Limiter
+}
+
+// Engine evaluates requests against a Policy.
+type Engine struct {
+	keys  map[string]Key
+	rules []*compiledRule
+}
+
+func LoadPolicy(path string) (*Engine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %v", err)
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %v", err)
+	}
+	return NewEngine(p)
+}
+
+func NewEngine(p Policy) (*Engine, error) {
+	e := &Engine{keys: make(map[string]Key)}
+	for _, k := range p.Keys {
+		e.keys[k.Key] = k
+	}
+
+	for _, r := range p.Rules {
+		if r.Methods != "" && r.Methods != "read" && r.Methods != "write" {
+			return nil, fmt.Errorf("rule %s: unknown method class %q", r.Name, r.Methods)
+		}
+		cr := &compiledRule{Rule: r}
+		if r.Window != nil {
+			if err := cr.compileWindow(); err != nil {
+				return nil, fmt.Errorf("rule %s: %v", r.Name, err)
+			}
+		}
+		if r.Rate > 0 {
+			cr.limiter = ratelimit.New(r.Rate, r.Burst)
+		}
+		e.rules = append(e.rules, cr)
+	}
+	return e, nil
+}
+
+func (cr *compiledRule) compileWindow() error {
+	w := cr.Window
+	var err error
+	cr.location = time.UTC
+	if w.Location != "" {
+		if cr.location, err = time.LoadLocation(w.Location); err != nil {
+			return err
+		}
+	}
+	if cr.start, err = minuteOfDay(w.Start); err != nil {
+		return err
+	}
+	if cr.end, err = minuteOfDay(w.End); err != nil {
+		return err
+	}
+	if len(w.Days) > 0 {
+		cr.days = make(map[time.Weekday]bool)
+		for _, d := range w.Days {
+			day, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return fmt.Errorf("unknown day %q", d)
+			}
+			cr.days[day] = true
+		}
+	}
+	return nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func minuteOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Identify returns the API key entry presented by r, from the X-API-Key
+// header or a bearer token.
+func (e *Engine) Identify(r *http.Request) Key {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if k, ok := e.keys[key]; ok && key != "" {
+		return k
+	}
+	return Key{Identity: Anonymous}
+}
+
+// Evaluate decides whether r may proceed at time now. Requests matching no
+// rule are allowed.
+func (e *Engine) Evaluate(r *http.Request, now time.Time) Decision {
+	k := e.Identify(r)
+	d := Decision{Identity: k.Identity, Allowed: true}
+
+	for _, rule := range e.rules {
+		if !rule.matches(r, k, now) {
+			continue
+		}
+		d.Rule = rule.Name
+		if rule.Deny {
+			d.Allowed = false
+			return d
+		}
+		if rule.limiter != nil {
+			status := rule.limiter.Take(limitKey(r, k))
+			d.RateLimit = &status
+			if !status.Allowed {
+				d.Allowed = false
+				d.Limited = true
+			}
+		}
+		return d
+	}
+	return d
+}
+
+// Middleware rejects denied requests with 403 and rate limited ones with 429,
+// and sets RateLimit headers on requests matching a rule with a rate limit.
+func (e *Engine) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := e.Evaluate(r, time.Now())
+		if d.RateLimit != nil {
+			d.RateLimit.SetHeaders(w.Header())
+		}
+		if d.Limited {
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		if !d.Allowed {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Snapshot returns the rate limit buckets of every rule, by rule name.
+func (e *Engine) Snapshot() map[string]map[string]ratelimit.Bucket {
+	snap := make(map[string]map[string]ratelimit.Bucket)
+	for _, rule := range e.rules {
+		if rule.limiter != nil {
+			snap[rule.Name] = rule.limiter.Snapshot()
+		}
+	}
+	return snap
+}
+
+// Restore adds buckets saved by Snapshot to the rules of the same name.
+// Buckets of rules no longer in the policy are dropped.
+func (e *Engine) Restore(snap map[string]map[string]ratelimit.Bucket) {
+	for _, rule := range e.rules {
+		if rule.limiter != nil {
+			rule.limiter.Restore(snap[rule.Name])
+		}
+	}
+}
+
+func (cr *compiledRule) matches(r *http.Request, k Key, now time.Time) bool {
+	if len(cr.Identities) > 0 && !slices.Contains(cr.Identities, k.Identity) {
+		return false
+	}
+	if len(cr.Tiers) > 0 && !slices.Contains(cr.Tiers, k.Tier) {
+		return false
+	}
+	if cr.Methods != "" && cr.Methods != MethodClass(r.Method) {
+		return false
+	}
+	if !strings.HasPrefix(r.URL.Path, cr.PathPrefix) {
+		return false
+	}
+	if cr.Window != nil && !cr.inWindow(now) {
+		return false
+	}
+	return true
+}
+
+func (cr *compiledRule) inWindow(now time.Time) bool {
+	now = now.In(cr.location)
+	minute := now.Hour()*60 + now.Minute()
+
+	day := now.Weekday()
+	var in bool
+	if cr.start <= cr.end {
+		in = minute >= cr.start && minute < cr.end
+	} else {
+		in = minute >= cr.start || minute < cr.end
+		// The early morning part of an overnight window belongs to the
+		// previous day's schedule.
+		if minute < cr.end {
+			day = (day + 6) % 7
+		}
+	}
+	return in && (cr.days == nil || cr.days[day])
+}
+
+// MethodClass classifies safe methods as "read" and everything else as
+// "write".
+func MethodClass(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return "read"
+	}
+	return "write"
+}
+
+// limitKey buckets anonymous clients by address and everyone else by identity.
+func limitKey(r *http.Request, k Key) string {
+	if k.Identity != Anonymous {
+		return k.Identity
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,127 @@
+package access
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testEngine(t *testing.T) *Engine {
+	e, err := NewEngine(Policy{
+		Keys: []Key{
+			{Key: "gold-key", Identity: "alice", Tier: "gold"},
+			{Key: "free-key", Identity: "bob", Tier: "free"},
+		},
+		Rules: []Rule{
+			{Name: "no-anonymous-writes", Identities: []string{Anonymous}, Methods: "write", Deny: true},
+			{Name: "office-hours-admin", PathPrefix: "/admin", Deny: true,
+				Window: &Window{Days: []string{"sat", "sun"}, Start: "00:00", End: "23:59"}},
+			{Name: "free-writes", Tiers: []string{"free"}, Methods: "write", Rate: 1, Burst: 1},
+			{Name: "free-reads", Tiers: []string{"free"}, Methods: "read", Rate: 1, Burst: 3},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	return e
+}
+
+func request(method, path, key string) *http.Request {
+	r := httptest.NewRequest(method, path, nil)
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	return r
+}
+
+func TestEvaluate(t *testing.T) {
+	e := testEngine(t)
+	monday := time.Date(2025, 3, 3, 12, 0, 0, 0, time.UTC)
+	saturday := time.Date(2025, 3, 8, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		now     time.Time
+		allowed bool
+		rule    string
+	}{
+		{"Anonymous read", request("GET", "/", ""), monday, true, ""},
+		{"Anonymous write", request("POST", "/", ""), monday, false, "no-anonymous-writes"},
+		{"Keyed write", request("POST", "/", "gold-key"), monday, true, ""},
+		{"Admin on weekday", request("GET", "/admin", "gold-key"), monday, true, ""},
+		{"Admin on weekend", request("GET", "/admin", "gold-key"), saturday, false, "office-hours-admin"},
+		{"Unknown key is anonymous", request("POST", "/", "bogus"), monday, false, "no-anonymous-writes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := e.Evaluate(tt.req, tt.now)
+			if d.Allowed != tt.allowed || d.Rule != tt.rule {
+				t.Errorf("Unexpected decision: %+v", d)
+			}
+		})
+	}
+}
+
+func TestMethodClassTiers(t *testing.T) {
+	e := testEngine(t)
+	now := time.Date(2025, 3, 3, 12, 0, 0, 0, time.UTC)
+
+	if !e.Evaluate(request("POST", "/", "free-key"), now).Allowed {
+		t.Fatal("Expected first write to be allowed")
+	}
+	if d := e.Evaluate(request("POST", "/", "free-key"), now); !d.Limited {
+		t.Errorf("Expected second write to be limited: %+v", d)
+	}
+	for i := 0; i < 3; i++ {
+		if !e.Evaluate(request("GET", "/", "free-key"), now).Allowed {
+			t.Errorf("Expected read %d to be allowed", i)
+		}
+	}
+}
+
+func TestOvernightWindow(t *testing.T) {
+	e, err := NewEngine(Policy{Rules: []Rule{
+		{Name: "friday-night", Deny: true, Window: &Window{Days: []string{"fri"}, Start: "22:00", End: "02:00"}},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	fridayLate := time.Date(2025, 3, 7, 23, 0, 0, 0, time.UTC)
+	saturdayEarly := time.Date(2025, 3, 8, 1, 0, 0, 0, time.UTC)
+	saturdayLate := time.Date(2025, 3, 8, 23, 0, 0, 0, time.UTC)
+
+	for now, allowed := range map[time.Time]bool{fridayLate: false, saturdayEarly: false, saturdayLate: true} {
+		if d := e.Evaluate(request("GET", "/", ""), now); d.Allowed != allowed {
+			t.Errorf("At %v expected allowed=%v", now, allowed)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := testEngine(t).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request("DELETE", "/", ""))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %v", w.Code)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	e := testEngine(t)
+	monday := time.Date(2025, 3, 3, 12, 0, 0, 0, time.UTC)
+	e.Evaluate(request("POST", "/", "free-key"), monday)
+
+	restarted := testEngine(t)
+	restarted.Restore(e.Snapshot())
+	if d := restarted.Evaluate(request("POST", "/", "free-key"), monday); !d.Limited {
+		t.Error("Expected the restored rule to still limit bob")
+	}
+	if d := restarted.Evaluate(request("GET", "/", "free-key"), monday); d.Limited {
+		t.Error("Expected other rules to keep full buckets")
+	}
+}
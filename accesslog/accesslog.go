@@ -0,0 +1,185 @@
+// Package accesslog writes per-request access log lines with sampling and
+// redaction of credentials.
+package accesslog
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/classify"
+)
+
+const redacted = "REDACTED"
+
+// Headers and query parameters that are always redacted.
+var (
+	sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+	sensitiveParams  = []string{"token", "access_token", "id_token", "refresh_token", "api_key", "apikey", "key", "password", "secret", "signature", "sig", "code"}
+)
+
+// Config describes how a service's requests are logged.
+type Config struct {
+	// SampleRate logs one in SampleRate successful requests; errors (status
+	// 400 and above) are always logged. 0 or 1 logs everything.
+	SampleRate int `json:"sample_rate,omitempty"`
+	// Headers are request headers to include in each line.
+	Headers []string `json:"headers,omitempty"`
+	// RedactHeaders and RedactParams extend the built-in lists of headers
+	// and query parameters whose values are never logged.
+	RedactHeaders []string `json:"redact_headers,omitempty"`
+	RedactParams  []string `json:"redact_params,omitempty"`
+}
+
+// Logger logs requests for one service.
+type Logger struct {
+	name    string
+	cfg     Config
+	out     *log.Logger
+	headers map[string]bool
+	params  map[string]bool
+	count   atomic.Uint64
+}
+
+func New(name string, cfg Config, out *log.Logger) *Logger {
+	if out == nil {
+		out = log.Default()
+	}
+	l := &Logger{
+		name:    name,
+		cfg:     cfg,
+		out:     out,
+		headers: make(map[string]bool),
+		params:  make(map[string]bool),
+	}
+	for _, h := range append(sensitiveHeaders, cfg.RedactHeaders...) {
+		l.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, p := range append(sensitiveParams, cfg.RedactParams...) {
+		l.params[strings.ToLower(p)] = true
+	}
+	return l
+}
+
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		if sw.status < 400 && !l.sample() {
+			return
+		}
+		l.out.Print(l.line(r, sw.status, sw.bytes, time.Since(start)))
+	})
+}
+
+func (l *Logger) sample() bool {
+	if l.cfg.SampleRate <= 1 {
+		return true
+	}
+	return l.count.Add(1)%uint64(l.cfg.SampleRate) == 1
+}
+
+func (l *Logger) line(r *http.Request, status int, bytes int64, d time.Duration) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "service=%s remote=%s method=%s host=%s uri=%q status=%d bytes=%d duration=%s",
+		l.name, r.RemoteAddr, r.Method, r.Host, l.RedactURL(r.URL), status, bytes, d.Round(time.Microsecond))
+	if tags := r.Header.Get(classify.Header); tags != "" {
+		fmt.Fprintf(&b, " tags=%s", tags)
+	}
+	if ref := r.Referer(); ref != "" {
+		if u, err := url.Parse(ref); err == nil {
+			ref = l.RedactURL(u)
+		}
+		fmt.Fprintf(&b, " referer=%q", ref)
+	}
+	for _, name := range l.cfg.Headers {
+		if v := r.Header.Values(name); len(v) > 0 {
+			fmt.Fprintf(&b, " %s=%q", strings.ToLower(name), l.RedactHeader(name, strings.Join(v, ", ")))
+		}
+	}
+	return b.String()
+}
+
+// RedactURL returns u as a request URI with secret query values replaced.
+func (l *Logger) RedactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	parts := strings.Split(u.RawQuery, "&")
+	for i, part := range parts {
+		name, _, hasValue := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if hasValue && l.params[strings.ToLower(name)] {
+			parts[i] = part[:strings.IndexByte(part, '=')+1] + redacted
+		}
+	}
+	redactedURL := *u
+	redactedURL.RawQuery = strings.Join(parts, "&")
+	return redactedURL.RequestURI()
+}
+
+// RedactHeader returns the loggable form of a header value: cookies keep
+// their names, credentials keep their scheme.
+func (l *Logger) RedactHeader(name, value string) string {
+	name = http.CanonicalHeaderKey(name)
+	if !l.headers[name] {
+		return value
+	}
+	switch name {
+	case "Cookie":
+		cookies := strings.Split(value, ";")
+		for i, c := range cookies {
+			cookieName, _, _ := strings.Cut(strings.TrimSpace(c), "=")
+			cookies[i] = cookieName + "=" + redacted
+		}
+		return strings.Join(cookies, "; ")
+	case "Set-Cookie":
+		cookieName, _, _ := strings.Cut(value, "=")
+		return cookieName + "=" + redacted
+	case "Authorization", "Proxy-Authorization":
+		if scheme, _, ok := strings.Cut(value, " "); ok {
+			return scheme + " " + redacted
+		}
+	}
+	return redacted
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status >= 200 {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
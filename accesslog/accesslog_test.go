@@ -0,0 +1,80 @@
+package accesslog
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSampling(t *testing.T) {
+	var buf bytes.Buffer
+	l := New("api", Config{SampleRate: 3}, log.New(&buf, "", 0))
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+
+	for i := 0; i < 6; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ok", nil))
+	}
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fail", nil))
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	ok, failed := 0, 0
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, "status=200"):
+			ok++
+		case strings.Contains(line, "status=502"):
+			failed++
+		}
+	}
+	if ok != 2 || failed != 2 {
+		t.Errorf("Expected 2 sampled successes and 2 errors, got %d and %d:\n%s", ok, failed, buf.String())
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	l := New("api", Config{
+		Headers:       []string{"Authorization", "Cookie", "X-Session", "User-Agent"},
+		RedactHeaders: []string{"X-Session"},
+		RedactParams:  []string{"otp"},
+	}, log.New(&buf, "", 0))
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest("GET", "/cb?user=amy&access_token=abc123&OTP=999&next=%2Fhome", nil)
+	r.Header.Set("Authorization", "Bearer abc123")
+	r.Header.Set("Cookie", "session=s3cret; theme=dark")
+	r.Header.Set("X-Session", "s3cret")
+	r.Header.Set("User-Agent", "curl/8")
+	r.Header.Set("Referer", "https://example.com/login?password=hunter2")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := buf.String()
+	for _, secret := range []string{"abc123", "s3cret", "999", "dark", "hunter2"} {
+		if strings.Contains(line, secret) {
+			t.Errorf("Log line leaks %q: %s", secret, line)
+		}
+	}
+	for _, kept := range []string{"user=amy", "next=%2Fhome", "Bearer REDACTED", "session=REDACTED; theme=REDACTED", "curl/8", "password=REDACTED"} {
+		if !strings.Contains(line, kept) {
+			t.Errorf("Expected log line to contain %q: %s", kept, line)
+		}
+	}
+}
+
+func TestRedactURLWithoutQuery(t *testing.T) {
+	l := New("api", Config{}, nil)
+	u, _ := url.Parse("/plain/path")
+	if got := l.RedactURL(u); got != "/plain/path" {
+		t.Errorf("Expected path unchanged, got %s", got)
+	}
+}
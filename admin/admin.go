@@ -0,0 +1,31 @@
+// Package admin holds helpers shared by the admin API handlers of the other
+// packages.
+package admin
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+//go:embed openapi.json
+var openAPI []byte
+
+// OpenAPIHandler serves the OpenAPI document describing the admin API. It
+// must be kept in step with the handlers it describes.
+func OpenAPIHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPI)
+	})
+}
@@ -0,0 +1,29 @@
+package admin
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	var doc struct {
+		OpenAPI string                               `json:"openapi"`
+		Paths   map[string]map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPI, &doc); err != nil {
+		t.Fatalf("Invalid OpenAPI document: %v", err)
+	}
+	seen := make(map[string]bool)
+	for path, methods := range doc.Paths {
+		for method, op := range methods {
+			id, _ := op["operationId"].(string)
+			if id == "" {
+				t.Errorf("%s %s has no operationId", method, path)
+			}
+			if seen[id] {
+				t.Errorf("Duplicate operationId %s", id)
+			}
+			seen[id] = true
+		}
+	}
+}
@@ -0,0 +1,667 @@
+// Package client is a typed client for the admin API described by
+// admin/openapi.json.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/classify"
+	"github.com/kirtansoni/reverse-proxy-go/clients"
+	"github.com/kirtansoni/reverse-proxy-go/errorpages"
+	"github.com/kirtansoni/reverse-proxy-go/events"
+	"github.com/kirtansoni/reverse-proxy-go/har"
+	"github.com/kirtansoni/reverse-proxy-go/listener"
+	"github.com/kirtansoni/reverse-proxy-go/proxy"
+	"github.com/kirtansoni/reverse-proxy-go/shortlinks"
+	"github.com/kirtansoni/reverse-proxy-go/ssl"
+)
+
+// Error is a non-2xx response from the admin API.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("admin API returned %d: %s", e.StatusCode, e.Message)
+}
+
+type Client struct {
+	base string
+	http *http.Client
+}
+
+// New returns a client for the admin API at baseURL, e.g.
+// "http://127.0.0.1:8081". A nil httpClient uses http.DefaultClient.
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{base: strings.TrimSuffix(baseURL, "/"), http: httpClient}
+}
+
+// do sends a request and decodes a JSON response into out, if not nil.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(data))
+		}
+		return &Error{StatusCode: res.StatusCode, Message: e.Error}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
+
+func id(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+func revisionQuery(name string, rev int) url.Values {
+	return url.Values{name: {strconv.Itoa(rev)}}
+}
+
+// State returns the current route table in declarative form.
+func (c *Client) State(ctx context.Context) (proxy.State, error) {
+	var state proxy.State
+	return state, c.do(ctx, http.MethodGet, "/state", nil, nil, &state)
+}
+
+// ApplyState reconciles the proxy to state. With dryRun the returned plan
+// lists the changes without applying them.
+func (c *Client) ApplyState(ctx context.Context, state proxy.State, dryRun bool) (proxy.Plan, error) {
+	var q url.Values
+	if dryRun {
+		q = url.Values{"dry_run": {"1"}}
+	}
+	var plan proxy.Plan
+	return plan, c.do(ctx, http.MethodPut, "/state", q, state, &plan)
+}
+
+// Transact applies the operations of tx together: all of them or, if one
+// fails, none. With dryRun the returned plan lists the changes without
+// applying them.
+func (c *Client) Transact(ctx context.Context, tx proxy.Transaction, dryRun bool) (proxy.Plan, error) {
+	var q url.Values
+	if dryRun {
+		q = url.Values{"dry_run": {"1"}}
+	}
+	var plan proxy.Plan
+	return plan, c.do(ctx, http.MethodPost, "/transaction", q, tx, &plan)
+}
+
+// ApplyValidatedState is ApplyState after probing the backends the change
+// newly routes to, within timeout (0 for the server's default). Nothing is
+// changed unless all of them answer; the error then names the first failure.
+func (c *Client) ApplyValidatedState(ctx context.Context, state proxy.State, dryRun bool, timeout time.Duration) (proxy.Plan, error) {
+	q := url.Values{"validate": {"1"}}
+	if dryRun {
+		q.Set("dry_run", "1")
+	}
+	if timeout > 0 {
+		q.Set("timeout", timeout.String())
+	}
+	var plan proxy.Plan
+	return plan, c.do(ctx, http.MethodPut, "/state", q, state, &plan)
+}
+
+// Revisions lists the kept route tables, oldest first.
+func (c *Client) Revisions(ctx context.Context) ([]proxy.Revision, error) {
+	var revs []proxy.Revision
+	return revs, c.do(ctx, http.MethodGet, "/config/", nil, nil, &revs)
+}
+
+// Diff returns the route changes between two revisions. Revision IDs of 0
+// or less count back from the current revision.
+func (c *Client) Diff(ctx context.Context, from, to int) ([]proxy.Change, error) {
+	q := revisionQuery("from", from)
+	q.Set("to", strconv.Itoa(to))
+	var changes []proxy.Change
+	return changes, c.do(ctx, http.MethodGet, "/config/diff", q, nil, &changes)
+}
+
+// Rollback restores a revision, returning the new current revision.
+func (c *Client) Rollback(ctx context.Context, to int) (proxy.Revision, error) {
+	var rev proxy.Revision
+	return rev, c.do(ctx, http.MethodPost, "/config/rollback", revisionQuery("to", to), nil, &rev)
+}
+
+// Canary returns the route change being verified.
+func (c *Client) Canary(ctx context.Context) (proxy.CanaryStatus, error) {
+	var status proxy.CanaryStatus
+	return status, c.do(ctx, http.MethodGet, "/config/canary", nil, nil, &status)
+}
+
+// CommitCanary accepts the route change being verified.
+func (c *Client) CommitCanary(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/config/canary/commit", nil, nil, nil)
+}
+
+// Reloads returns the outcomes of the last route table reloads, oldest
+// first.
+func (c *Client) Reloads(ctx context.Context) ([]proxy.Reload, error) {
+	var reloads []proxy.Reload
+	return reloads, c.do(ctx, http.MethodGet, "/config/reloads", nil, nil, &reloads)
+}
+
+// Changelog returns the changes made to a service, oldest first.
+func (c *Client) Changelog(ctx context.Context, service string) ([]proxy.ServiceChange, error) {
+	var changes []proxy.ServiceChange
+	return changes, c.do(ctx, http.MethodGet, "/config/changelog/"+url.PathEscape(service), nil, nil, &changes)
+}
+
+// Routes returns the live and registered routes.
+func (c *Client) Routes(ctx context.Context) (proxy.RouteStats, error) {
+	var stats proxy.RouteStats
+	return stats, c.do(ctx, http.MethodGet, "/config/routes", nil, nil, &stats)
+}
+
+// Load returns the saturation of a service.
+func (c *Client) Load(ctx context.Context, service string) (proxy.Load, error) {
+	var load proxy.Load
+	return load, c.do(ctx, http.MethodGet, "/scaling/"+url.PathEscape(service), nil, nil, &load)
+}
+
+// HeaderStats returns the request header shape of every service.
+func (c *Client) HeaderStats(ctx context.Context) ([]proxy.HeaderStats, error) {
+	var stats []proxy.HeaderStats
+	return stats, c.do(ctx, http.MethodGet, "/headers/", nil, nil, &stats)
+}
+
+// ClassificationCounts returns the requests seen for every combination of
+// classification tags.
+func (c *Client) ClassificationCounts(ctx context.Context) ([]classify.Count, error) {
+	var counts []classify.Count
+	return counts, c.do(ctx, http.MethodGet, "/classify/", nil, nil, &counts)
+}
+
+// DNSFailures returns the upstream host names failing to resolve.
+func (c *Client) DNSFailures(ctx context.Context) (proxy.DNSStats, error) {
+	var stats proxy.DNSStats
+	return stats, c.do(ctx, http.MethodGet, "/dns/", nil, nil, &stats)
+}
+
+// ForgetDNSFailures makes the next request to every failing upstream host
+// name look it up again.
+func (c *Client) ForgetDNSFailures(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/dns/", nil, nil, nil)
+}
+
+// StickySessions returns the sticky session counts of every service.
+func (c *Client) StickySessions(ctx context.Context) ([]proxy.StickyServiceStats, error) {
+	var stats []proxy.StickyServiceStats
+	return stats, c.do(ctx, http.MethodGet, "/sticky/", nil, nil, &stats)
+}
+
+// StickySession returns the backend a session of service is pinned to.
+func (c *Client) StickySession(ctx context.Context, service, session string) (proxy.StickySession, error) {
+	var s proxy.StickySession
+	return s, c.do(ctx, http.MethodGet, "/sticky/"+url.PathEscape(service)+"/"+url.PathEscape(session), nil, nil, &s)
+}
+
+// FlushStickySessions forgets a session of service, or all of its sessions
+// if session is empty.
+func (c *Client) FlushStickySessions(ctx context.Context, service, session string) error {
+	path := "/sticky/" + url.PathEscape(service)
+	if session != "" {
+		path += "/" + url.PathEscape(session)
+	}
+	return c.do(ctx, http.MethodDelete, path, nil, nil, nil)
+}
+
+// Listeners returns the counts of every listener.
+func (c *Client) Listeners(ctx context.Context) ([]listener.ListenerStats, error) {
+	var stats []listener.ListenerStats
+	return stats, c.do(ctx, http.MethodGet, "/listeners/", nil, nil, &stats)
+}
+
+// ReloadListeners applies the -listeners file again, returning the names
+// of the listeners added and removed.
+func (c *Client) ReloadListeners(ctx context.Context) (added, removed []string, err error) {
+	var out struct {
+		Added   []string `json:"added"`
+		Removed []string `json:"removed"`
+	}
+	err = c.do(ctx, http.MethodPost, "/listeners/reload", nil, nil, &out)
+	return out.Added, out.Removed, err
+}
+
+// CheckResults returns the latest result of every synthetic check.
+func (c *Client) CheckResults(ctx context.Context) ([]proxy.CheckResult, error) {
+	var results []proxy.CheckResult
+	return results, c.do(ctx, http.MethodGet, "/checks/", nil, nil, &results)
+}
+
+// CheckHistory returns the recent runs of the synthetic checks, optionally
+// only those of service and of check.
+func (c *Client) CheckHistory(ctx context.Context, service, check string) ([]proxy.CheckHistory, error) {
+	query := url.Values{}
+	if service != "" {
+		query.Set("service", service)
+	}
+	if check != "" {
+		query.Set("check", check)
+	}
+	var histories []proxy.CheckHistory
+	return histories, c.do(ctx, http.MethodGet, "/checks/history", query, nil, &histories)
+}
+
+// Evaluate reports which service, middleware and upstream URL req would
+// get, without sending it.
+func (c *Client) Evaluate(ctx context.Context, req proxy.EvalRequest) (proxy.Evaluation, error) {
+	var eval proxy.Evaluation
+	return eval, c.do(ctx, http.MethodPost, "/evaluate", nil, req, &eval)
+}
+
+// Provision routes a host to a new service and obtains its certificate,
+// waiting up to timeout (the server's default when zero). Provisioning is
+// not Ready when the backend or certificate is not ready yet; calling it
+// again reports the current status.
+func (c *Client) Provision(ctx context.Context, req proxy.ProvisionRequest, timeout time.Duration) (proxy.Provisioning, error) {
+	var query url.Values
+	if timeout > 0 {
+		query = url.Values{"timeout": {timeout.String()}}
+	}
+	var p proxy.Provisioning
+	return p, c.do(ctx, http.MethodPost, "/provision/", query, req, &p)
+}
+
+// RouteGraph returns the routing graph of the proxy.
+func (c *Client) RouteGraph(ctx context.Context) (proxy.RouteGraph, error) {
+	var g proxy.RouteGraph
+	return g, c.do(ctx, http.MethodGet, "/graph/", nil, nil, &g)
+}
+
+// Profile returns where sampled requests spent their time.
+func (c *Client) Profile(ctx context.Context) (proxy.ProfileReport, error) {
+	var report proxy.ProfileReport
+	return report, c.do(ctx, http.MethodGet, "/profile/", nil, nil, &report)
+}
+
+// PurgeCache drops the cached responses req selects.
+func (c *Client) PurgeCache(ctx context.Context, req proxy.PurgeRequest) (proxy.PurgeResult, error) {
+	var res proxy.PurgeResult
+	return res, c.do(ctx, http.MethodPost, "/cache/purge/", nil, req, &res)
+}
+
+// SetProfileSampleRate profiles the fraction rate of requests; 0 stops.
+func (c *Client) SetProfileSampleRate(ctx context.Context, rate float64) (proxy.ProfileReport, error) {
+	var report proxy.ProfileReport
+	query := url.Values{"rate": {strconv.FormatFloat(rate, 'f', -1, 64)}}
+	return report, c.do(ctx, http.MethodPost, "/profile/", query, nil, &report)
+}
+
+// MiddlewareChain returns the default middleware chain and the chain each
+// service runs.
+func (c *Client) MiddlewareChain(ctx context.Context) (proxy.ChainStatus, error) {
+	var status proxy.ChainStatus
+	return status, c.do(ctx, http.MethodGet, "/middleware/", nil, nil, &status)
+}
+
+// Events streams lifecycle events of the given types, or all events, until
+// ctx is done or the connection drops; the channel is then closed.
+func (c *Client) Events(ctx context.Context, types ...events.Type) (<-chan events.Event, error) {
+	u := c.base + "/events/"
+	if len(types) > 0 {
+		names := make([]string, len(types))
+		for i, t := range types {
+			names[i] = string(t)
+		}
+		u += "?" + url.Values{"type": {strings.Join(names, ",")}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		var e struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(data))
+		}
+		return nil, &Error{StatusCode: res.StatusCode, Message: e.Error}
+	}
+	ch := make(chan events.Event)
+	go func() {
+		defer close(ch)
+		defer res.Body.Close()
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var e events.Event
+			if json.Unmarshal([]byte(data), &e) != nil {
+				continue
+			}
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Requests lists in-flight requests.
+func (c *Client) Requests(ctx context.Context) ([]proxy.ActiveRequest, error) {
+	var reqs []proxy.ActiveRequest
+	return reqs, c.do(ctx, http.MethodGet, "/requests/", nil, nil, &reqs)
+}
+
+// CancelRequest cancels an in-flight request.
+func (c *Client) CancelRequest(ctx context.Context, requestID uint64) error {
+	return c.do(ctx, http.MethodDelete, "/requests/"+id(requestID), nil, nil, nil)
+}
+
+// CloseConnection closes the client connection of an in-flight request.
+func (c *Client) CloseConnection(ctx context.Context, requestID uint64) error {
+	return c.do(ctx, http.MethodDelete, "/requests/"+id(requestID)+"/conn", nil, nil, nil)
+}
+
+// Transfers lists in-flight large responses.
+func (c *Client) Transfers(ctx context.Context) ([]proxy.Transfer, error) {
+	var transfers []proxy.Transfer
+	return transfers, c.do(ctx, http.MethodGet, "/transfers/", nil, nil, &transfers)
+}
+
+// AbortTransfer aborts a large response.
+func (c *Client) AbortTransfer(ctx context.Context, transferID uint64) error {
+	return c.do(ctx, http.MethodDelete, "/transfers/"+id(transferID), nil, nil, nil)
+}
+
+// IssueOverride signs an override routing a developer's requests for the
+// given services (by name) to other backends, valid for ttl (e.g. "8h";
+// empty for the default).
+func (c *Client) IssueOverride(ctx context.Context, developer string, routes map[string]string, ttl string) (proxy.IssuedOverride, error) {
+	body := map[string]any{"developer": developer, "routes": routes, "ttl": ttl}
+	var issued proxy.IssuedOverride
+	return issued, c.do(ctx, http.MethodPost, "/overrides/", nil, body, &issued)
+}
+
+// RevokeOverrides invalidates every override token issued to a developer.
+func (c *Client) RevokeOverrides(ctx context.Context, developer string) error {
+	return c.do(ctx, http.MethodDelete, "/overrides/"+url.PathEscape(developer), nil, nil, nil)
+}
+
+// Flags returns the feature flags a user gets; an empty user is anonymous.
+func (c *Client) Flags(ctx context.Context, user string) (map[string]string, error) {
+	var q url.Values
+	if user != "" {
+		q = url.Values{"user": {user}}
+	}
+	var flags map[string]string
+	return flags, c.do(ctx, http.MethodGet, "/flags/", q, nil, &flags)
+}
+
+// Clients returns per-IP activity, busiest first.
+func (c *Client) Clients(ctx context.Context) ([]clients.Client, error) {
+	var list []clients.Client
+	return list, c.do(ctx, http.MethodGet, "/clients/", nil, nil, &list)
+}
+
+// TarpitStats returns the requests from banned IPs held in the tarpit.
+func (c *Client) TarpitStats(ctx context.Context) (clients.TarpitStats, error) {
+	var stats clients.TarpitStats
+	return stats, c.do(ctx, http.MethodGet, "/clients/tarpit", nil, nil, &stats)
+}
+
+// Bans lists the bans in effect.
+func (c *Client) Bans(ctx context.Context) ([]clients.Ban, error) {
+	var bans []clients.Ban
+	return bans, c.do(ctx, http.MethodGet, "/clients/bans", nil, nil, &bans)
+}
+
+// Ban bans an IP. An empty duration (e.g. "1h") bans permanently.
+func (c *Client) Ban(ctx context.Context, ip, duration, reason string) (clients.Ban, error) {
+	body := map[string]string{"ip": ip, "duration": duration, "reason": reason}
+	var ban clients.Ban
+	return ban, c.do(ctx, http.MethodPost, "/clients/bans", nil, body, &ban)
+}
+
+// Unban lifts a ban.
+func (c *Client) Unban(ctx context.Context, ip string) error {
+	return c.do(ctx, http.MethodDelete, "/clients/bans/"+url.PathEscape(ip), nil, nil, nil)
+}
+
+// Recordings lists traffic recordings, newest first.
+func (c *Client) Recordings(ctx context.Context) ([]har.Recording, error) {
+	var list []har.Recording
+	return list, c.do(ctx, http.MethodGet, "/har/", nil, nil, &list)
+}
+
+// StartRecording starts recording the traffic matching opts for duration
+// (e.g. "5m"; empty for the default).
+func (c *Client) StartRecording(ctx context.Context, opts har.Options, duration string) (har.Recording, error) {
+	body := struct {
+		har.Options
+		Duration string `json:"duration,omitempty"`
+	}{opts, duration}
+	var rec har.Recording
+	return rec, c.do(ctx, http.MethodPost, "/har/", nil, body, &rec)
+}
+
+// StopRecording stops a recording and writes its HAR file.
+func (c *Client) StopRecording(ctx context.Context, recordingID uint64) (har.Recording, error) {
+	var rec har.Recording
+	return rec, c.do(ctx, http.MethodPost, "/har/"+id(recordingID)+"/stop", nil, nil, &rec)
+}
+
+// RecordingHAR downloads a stopped recording.
+func (c *Client) RecordingHAR(ctx context.Context, recordingID uint64) (*har.Log, error) {
+	var log har.Log
+	return &log, c.do(ctx, http.MethodGet, "/har/"+id(recordingID), nil, nil, &log)
+}
+
+// DeleteRecording stops a recording and deletes its HAR file.
+func (c *Client) DeleteRecording(ctx context.Context, recordingID uint64) error {
+	return c.do(ctx, http.MethodDelete, "/har/"+id(recordingID), nil, nil, nil)
+}
+
+// ShortLinks returns every short link.
+func (c *Client) ShortLinks(ctx context.Context) ([]shortlinks.Link, error) {
+	var links []shortlinks.Link
+	return links, c.do(ctx, http.MethodGet, "/links/", nil, nil, &links)
+}
+
+// SetShortLink adds or replaces the short link with link's code.
+func (c *Client) SetShortLink(ctx context.Context, link shortlinks.Link) (shortlinks.Link, error) {
+	var out shortlinks.Link
+	return out, c.do(ctx, http.MethodPut, "/links/"+url.PathEscape(link.Code), nil, link, &out)
+}
+
+// DeleteShortLink removes a short link.
+func (c *Client) DeleteShortLink(ctx context.Context, code string) error {
+	return c.do(ctx, http.MethodDelete, "/links/"+url.PathEscape(code), nil, nil, nil)
+}
+
+// ACMEStats returns the Let's Encrypt operations of every domain.
+func (c *Client) ACMEStats(ctx context.Context) ([]ssl.ACMEStats, error) {
+	var stats []ssl.ACMEStats
+	return stats, c.do(ctx, http.MethodGet, "/acme/", nil, nil, &stats)
+}
+
+// RenewACMECertificate forces a new Let's Encrypt certificate for domain and
+// returns its statistics, with the new expiry.
+func (c *Client) RenewACMECertificate(ctx context.Context, domain string) (ssl.ACMEStats, error) {
+	var stats ssl.ACMEStats
+	return stats, c.do(ctx, http.MethodPost, "/acme/renew", url.Values{"domain": {domain}}, nil, &stats)
+}
+
+// PauseACME stops certificate issuance and renewal for domain, e.g. during a
+// DNS migration.
+func (c *Client) PauseACME(ctx context.Context, domain, reason string) (ssl.ACMEStats, error) {
+	var stats ssl.ACMEStats
+	query := url.Values{"domain": {domain}}
+	if reason != "" {
+		query.Set("reason", reason)
+	}
+	return stats, c.do(ctx, http.MethodPost, "/acme/pause", query, nil, &stats)
+}
+
+// DNS01Certificates returns the certificates obtained through DNS-01
+// challenges.
+func (c *Client) DNS01Certificates(ctx context.Context) ([]ssl.DNS01Stats, error) {
+	var stats []ssl.DNS01Stats
+	return stats, c.do(ctx, http.MethodGet, "/acme/dns01/", nil, nil, &stats)
+}
+
+// RenewDNS01 issues a new DNS-01 certificate for domain right away.
+func (c *Client) RenewDNS01(ctx context.Context, domain string) (ssl.DNS01Stats, error) {
+	var stats ssl.DNS01Stats
+	return stats, c.do(ctx, http.MethodPost, "/acme/dns01/renew", url.Values{"domain": {domain}}, nil, &stats)
+}
+
+// ResumeACME allows certificate issuance for a paused domain again.
+func (c *Client) ResumeACME(ctx context.Context, domain string) (ssl.ACMEStats, error) {
+	var stats ssl.ACMEStats
+	return stats, c.do(ctx, http.MethodPost, "/acme/resume", url.Values{"domain": {domain}}, nil, &stats)
+}
+
+// H2Stats returns the HTTP/2 connection and stream counts, with the
+// connections closed for abuse.
+func (c *Client) H2Stats(ctx context.Context) (listener.H2Stats, error) {
+	var stats listener.H2Stats
+	return stats, c.do(ctx, http.MethodGet, "/h2/", nil, nil, &stats)
+}
+
+// SmugglingSelfTest sends known request smuggling vectors to the proxy's
+// HTTPS listener and returns how each was handled.
+func (c *Client) SmugglingSelfTest(ctx context.Context) (listener.SmugglingReport, error) {
+	var report listener.SmugglingReport
+	return report, c.do(ctx, http.MethodPost, "/http1/selftest", nil, nil, &report)
+}
+
+// Connections returns the connection and traffic counts of every listener.
+func (c *Client) Connections(ctx context.Context) ([]listener.ReaperStats, error) {
+	var stats []listener.ReaperStats
+	return stats, c.do(ctx, http.MethodGet, "/conns/", nil, nil, &stats)
+}
+
+// TLSStats returns the certificate lookups of TLS handshakes and the expiry
+// of the certificates served.
+func (c *Client) TLSStats(ctx context.Context) (ssl.TLSStats, error) {
+	var stats ssl.TLSStats
+	return stats, c.do(ctx, http.MethodGet, "/tls/", nil, nil, &stats)
+}
+
+// HandshakeErrors returns the failed TLS handshakes by reason and source IP.
+func (c *Client) HandshakeErrors(ctx context.Context) (ssl.HandshakeReport, error) {
+	var report ssl.HandshakeReport
+	return report, c.do(ctx, http.MethodGet, "/tls-errors/", nil, nil, &report)
+}
+
+// ResetHandshakeErrors resets the handshake failure counts.
+func (c *Client) ResetHandshakeErrors(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/tls-errors/", nil, nil, nil)
+}
+
+// CertCacheUsage returns the disk usage of the Let's Encrypt certificate
+// cache.
+func (c *Client) CertCacheUsage(ctx context.Context) (ssl.CacheUsage, error) {
+	var usage ssl.CacheUsage
+	return usage, c.do(ctx, http.MethodGet, "/acme/cache/", nil, nil, &usage)
+}
+
+// PruneCertCache deletes cached certificates of domains no longer served
+// and returns those domains; with dryRun it only lists them.
+func (c *Client) PruneCertCache(ctx context.Context, dryRun bool) ([]string, error) {
+	var query url.Values
+	if dryRun {
+		query = url.Values{"dry_run": {"1"}}
+	}
+	var res struct {
+		Pruned []string `json:"pruned"`
+	}
+	return res.Pruned, c.do(ctx, http.MethodPost, "/acme/cache/prune", query, nil, &res)
+}
+
+// Certificates lists the loaded static certificates.
+func (c *Client) Certificates(ctx context.Context) ([]ssl.CertInfo, error) {
+	var certs []ssl.CertInfo
+	return certs, c.do(ctx, http.MethodGet, "/certs/", nil, nil, &certs)
+}
+
+// RenewCertificate reloads the certificate files of domain.
+func (c *Client) RenewCertificate(ctx context.Context, domain string) ([]ssl.CertInfo, error) {
+	var certs []ssl.CertInfo
+	return certs, c.do(ctx, http.MethodPost, "/certs/renew", url.Values{"domain": {domain}}, nil, &certs)
+}
+
+// InstallCertificate replaces a certificate and key with the given PEM pair.
+func (c *Client) InstallCertificate(ctx context.Context, certPEM, keyPEM string) ([]ssl.CertInfo, error) {
+	body := map[string]string{"cert": certPEM, "key": keyPEM}
+	var certs []ssl.CertInfo
+	return certs, c.do(ctx, http.MethodPost, "/certs/rollkey", nil, body, &certs)
+}
+
+// Maintenance returns the maintenance window in progress, or nil.
+func (c *Client) Maintenance(ctx context.Context) (*errorpages.Maintenance, error) {
+	var m *errorpages.Maintenance
+	return m, c.do(ctx, http.MethodGet, "/errors/maintenance", nil, nil, &m)
+}
+
+// StartMaintenance answers every request with the maintenance page. A
+// retryAfter such as "30m" is sent to clients as Retry-After.
+func (c *Client) StartMaintenance(ctx context.Context, retryAfter, message string) (errorpages.Maintenance, error) {
+	body := map[string]string{"retry_after": retryAfter, "message": message}
+	var m errorpages.Maintenance
+	return m, c.do(ctx, http.MethodPut, "/errors/maintenance", nil, body, &m)
+}
+
+// EndMaintenance ends maintenance mode.
+func (c *Client) EndMaintenance(ctx context.Context) error {
+	return c.do(ctx, http.MethodDelete, "/errors/maintenance", nil, nil, nil)
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/clients"
+	"github.com/kirtansoni/reverse-proxy-go/proxy"
+)
+
+func TestClient(t *testing.T) {
+	runtimeMux := proxy.NewRuntimeMux()
+	tracker, _ := clients.New("")
+	mux := http.NewServeMux()
+	mux.Handle("/config/", http.StripPrefix("/config", runtimeMux.AdminHandler()))
+	mux.Handle("/scaling/", http.StripPrefix("/scaling", runtimeMux.LoadHandler()))
+	mux.Handle("/clients/", http.StripPrefix("/clients", tracker.AdminHandler()))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	c := New(server.URL+"/", nil)
+
+	service, _ := proxy.NewService("api", "/api/", "http://127.0.0.1:1")
+	runtimeMux.AddProxy(service)
+
+	changes, err := c.Diff(ctx, -1, 0)
+	if err != nil || len(changes) != 1 || changes[0].Op != "added" {
+		t.Fatalf("Unexpected diff: %+v, %v", changes, err)
+	}
+	rev, err := c.Rollback(ctx, -1)
+	if err != nil || len(rev.Services) != 0 {
+		t.Fatalf("Unexpected rollback: %+v, %v", rev, err)
+	}
+	revs, err := c.Revisions(ctx)
+	if err != nil || len(revs) != 3 || revs[1].Services["/api/"].Url != "http://127.0.0.1:1" {
+		t.Fatalf("Unexpected revisions: %+v, %v", revs, err)
+	}
+
+	if _, err := c.Ban(ctx, "10.0.0.1", "1h", "test"); err != nil {
+		t.Fatalf("Ban failed: %v", err)
+	}
+	bans, err := c.Bans(ctx)
+	if err != nil || len(bans) != 1 || bans[0].Until == nil {
+		t.Fatalf("Unexpected bans: %+v, %v", bans, err)
+	}
+	if err := c.Unban(ctx, "10.0.0.1"); err != nil {
+		t.Fatalf("Unban failed: %v", err)
+	}
+
+	var apiErr *Error
+	err = c.Unban(ctx, "10.0.0.1")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "IP is not banned" {
+		t.Errorf("Expected typed 404 error, got %v", err)
+	}
+	if _, err := c.Load(ctx, "missing"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown service, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/secrets"
+)
+
+// ErrUnprotected is returned for admin listeners reachable from other hosts
+// when neither a client CA nor a token protects them.
+var ErrUnprotected = errors.New("admin API is reachable from other hosts without a client CA or token")
+
+// Remote protects admin listeners that are not loopback only: they require
+// a client certificate signed by ClientCA, the Token bearer token, or both.
+type Remote struct {
+	// ClientCA is a PEM file of the CAs whose client certificates are
+	// accepted.
+	ClientCA string
+	// Token is the bearer token, as a secrets reference such as
+	// file:<path> or env:<name>.
+	Token string
+	// Plaintext is set when the listeners serve HTTP without TLS, where
+	// client certificates cannot be asked for.
+	Plaintext bool
+}
+
+// Check returns ErrUnprotected if addr accepts connections from other hosts
+// and r protects them with neither a client CA nor a token.
+func (r Remote) Check(addr string) error {
+	if LoopbackOnly(addr) || r.ClientCA != "" || r.Token != "" {
+		return nil
+	}
+	return ErrUnprotected
+}
+
+// Protect returns handler and tlsConfig as listeners that are not loopback
+// only must serve them, or ErrUnprotected when r sets neither a client CA
+// nor a token, as such listeners must not serve at all.
+func (r Remote) Protect(handler http.Handler, tlsConfig *tls.Config) (http.Handler, *tls.Config, error) {
+	if r.ClientCA == "" && r.Token == "" {
+		return nil, nil, ErrUnprotected
+	}
+	cfg := tlsConfig.Clone()
+	if r.ClientCA != "" {
+		if r.Plaintext {
+			return nil, nil, errors.New("a client CA needs TLS")
+		}
+		data, err := os.ReadFile(r.ClientCA)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, nil, fmt.Errorf("no certificates found in %s", r.ClientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if r.Token != "" {
+		source, err := secrets.Parse(r.Token)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid token: %v", err)
+		}
+		handler = RequireToken(secrets.NewCache(source, time.Minute), handler)
+	}
+	return handler, cfg, nil
+}
+
+// RequireToken answers requests without token as a bearer Authorization
+// header with 401 Unauthorized.
+func RequireToken(token *secrets.Cache, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want, err := token.Get(r.Context())
+		if err == nil && want == "" {
+			err = errors.New("token is empty")
+		}
+		if err != nil {
+			log.Printf("Admin token unavailable: %v", err)
+			WriteError(w, http.StatusServiceUnavailable, errors.New("admin token unavailable"))
+			return
+		}
+		got, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !bearer || !tokenMatches(got, want) {
+			WriteError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tokenMatches compares got with want in time independent of where they
+// differ, so response times do not reveal the token.
+func tokenMatches(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// LoopbackOnly reports whether addr only accepts connections from the host
+// itself.
+func LoopbackOnly(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// TLSConfig returns base serving the certificate of name, if set, on every
+// handshake, so clients connecting by IP address or through another host
+// name still get the admin certificate.
+func TLSConfig(base *tls.Config, name string) *tls.Config {
+	cfg := base.Clone()
+	if name == "" || cfg.GetCertificate == nil {
+		return cfg
+	}
+	getCertificate := cfg.GetCertificate
+	cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		named := *hello
+		named.ServerName = name
+		return getCertificate(&named)
+	}
+	return cfg
+}
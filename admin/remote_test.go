@@ -0,0 +1,192 @@
+package admin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/ssl/ssltest"
+)
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestRemoteCheck(t *testing.T) {
+	for addr, want := range map[string]error{
+		"127.0.0.1:9000": nil,
+		"[::1]:9000":     nil,
+		"localhost:9000": nil,
+		":9000":          ErrUnprotected,
+		"0.0.0.0:9000":   ErrUnprotected,
+		"10.0.0.5:9000":  ErrUnprotected,
+	} {
+		if err := (Remote{}).Check(addr); err != want {
+			t.Errorf("%s: expected %v, got %v", addr, want, err)
+		}
+		if err := (Remote{Token: "env:ADMIN_TOKEN"}).Check(addr); err != nil {
+			t.Errorf("%s: expected a token to allow it, got %v", addr, err)
+		}
+	}
+	if _, _, err := (Remote{}).Protect(ok, &tls.Config{}); !errors.Is(err, ErrUnprotected) {
+		t.Errorf("Expected ErrUnprotected, got %v", err)
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "s3cret")
+	handler, _, err := Remote{Token: "env:ADMIN_TOKEN"}.Protect(ok, &tls.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]int{
+		"":                   http.StatusUnauthorized,
+		"Bearer wrong":       http.StatusUnauthorized,
+		"Bearer s3cre":       http.StatusUnauthorized,
+		"Bearer s3cret2":     http.StatusUnauthorized,
+		"s3cret":             http.StatusUnauthorized,
+		"Basic czNjcmV0Og==": http.StatusUnauthorized,
+		"Bearer s3cret":      http.StatusOK,
+	} {
+		r := httptest.NewRequest("GET", "/routes", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("Authorization %q: expected status %d, got %d", header, want, w.Code)
+		}
+	}
+}
+
+func TestTokenMatchesInConstantTime(t *testing.T) {
+	// With an early-exit comparison, a guess wrong in its first byte is
+	// rejected hundreds of times faster than one wrong in its last byte.
+	want := strings.Repeat("a", 1<<16)
+	early := "b" + want[1:]
+	late := want[:len(want)-1] + "b"
+	fastest := func(got string) time.Duration {
+		best := time.Duration(1<<63 - 1)
+		for range 20 {
+			start := time.Now()
+			for range 50 {
+				if tokenMatches(got, want) {
+					t.Fatal("Expected a wrong token not to match")
+				}
+			}
+			best = min(best, time.Since(start))
+		}
+		return best
+	}
+	if e, l := fastest(early), fastest(late); l > 4*e || e > 4*l {
+		t.Errorf("Comparison time depends on where the token differs: %v vs %v", e, l)
+	}
+	if !tokenMatches(want, want) {
+		t.Error("Expected the token to match itself")
+	}
+}
+
+// testCA returns a new CA certificate and its key.
+func testCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Admin CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// clientCert returns a client certificate signed by ca.
+func clientCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "operator"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestRemoteClientCA(t *testing.T) {
+	ca, caKey := testCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0600)
+	other, otherKey := testCA(t)
+
+	if _, _, err := (Remote{ClientCA: caFile, Plaintext: true}).Protect(ok, &tls.Config{}); err == nil {
+		t.Error("Expected a client CA without TLS to be rejected")
+	}
+
+	certFile, keyFile := ssltest.TempCert(t, "admin.test")
+	serverCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, cfg, err := Remote{ClientCA: caFile}.Protect(ok, &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS = cfg
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	for name, tc := range map[string]struct {
+		certs []tls.Certificate
+		ok    bool
+	}{
+		"signed by the CA":  {[]tls.Certificate{clientCert(t, ca, caKey)}, true},
+		"signed by another": {[]tls.Certificate{clientCert(t, other, otherKey)}, false},
+		"no certificate":    {nil, false},
+	} {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       tc.certs,
+		}}}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: expected success %v, got %v", name, tc.ok, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Package checkpoint periodically saves in-memory state, such as rate limit
+// counters, to a file or another storage.Store and restores it on start. The
+// proxy is meant to be crash-only: state is written atomically at every
+// checkpoint, so a crash loses at most one interval and never leaves a
+// half-written file.
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+)
+
+type file struct {
+	Saved time.Time                  `json:"saved"`
+	State map[string]json.RawMessage `json:"state"`
+}
+
+// Checkpointer saves the state of registered sources to a store.
+type Checkpointer struct {
+	store storage.Store
+	key   string
+
+	mu      sync.Mutex
+	saved   map[string]json.RawMessage
+	sources map[string]func() any
+}
+
+// New returns a checkpointer writing to path, loading the state saved there.
+func New(path string) (*Checkpointer, error) {
+	return NewWithStore(storage.NewDir(filepath.Dir(path)), filepath.Base(path))
+}
+
+// NewWithStore returns a checkpointer writing to store under key, loading
+// the state saved there. Instances sharing a store need keys of their own.
+func NewWithStore(store storage.Store, key string) (*Checkpointer, error) {
+	c := &Checkpointer{
+		store:   store,
+		key:     key,
+		saved:   make(map[string]json.RawMessage),
+		sources: make(map[string]func() any),
+	}
+	data, err := store.Get(context.Background(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint: %v", err)
+	}
+	if f.State != nil {
+		c.saved = f.State
+	}
+	return c, nil
+}
+
+// Register adds the state returned by snapshot to checkpoints under name,
+// first passing any state saved under that name to restore.
+func Register[T any](c *Checkpointer, name string, snapshot func() T, restore func(T)) error {
+	c.mu.Lock()
+	data, ok := c.saved[name]
+	c.sources[name] = func() any { return snapshot() }
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	var state T
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to restore %s: %v", name, err)
+	}
+	restore(state)
+	return nil
+}
+
+// Save writes a checkpoint. State saved under names no longer registered is
+// dropped.
+func (c *Checkpointer) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f := file{Saved: time.Now(), State: make(map[string]json.RawMessage, len(c.sources))}
+	for name, snapshot := range c.sources {
+		data, err := json.Marshal(snapshot())
+		if err != nil {
+			return fmt.Errorf("failed to save %s: %v", name, err)
+		}
+		f.State[name] = data
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err := c.store.Put(context.Background(), c.key, data); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %v", err)
+	}
+	return nil
+}
+
+// Run saves a checkpoint every interval until ctx is done.
+func (c *Checkpointer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Save(); err != nil {
+				log.Printf("Checkpoint failed: %v", err)
+			}
+		}
+	}
+}
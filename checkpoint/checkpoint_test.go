@@ -0,0 +1,43 @@
+package checkpoint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveAndRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	c, err := New(path)
+	if err != nil {
+		t.Fatalf("Failed to create checkpointer: %v", err)
+	}
+	counts := map[string]int{"a": 1}
+	restored := false
+	if err := Register(c, "counts", func() map[string]int { return counts }, func(map[string]int) { restored = true }); err != nil || restored {
+		t.Fatal("Expected nothing to restore from a missing file")
+	}
+	counts["b"] = 2
+	if err := c.Save(); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	c, err = New(path)
+	if err != nil {
+		t.Fatalf("Failed to load checkpoint: %v", err)
+	}
+	var got map[string]int
+	Register(c, "counts", func() map[string]int { return got }, func(m map[string]int) { got = m })
+	if got["a"] != 1 || got["b"] != 2 {
+		t.Errorf("Unexpected restored state: %v", got)
+	}
+	if err := Register(c, "other", func() int { return 0 }, func(int) { t.Error("Expected no state for an unknown name") }); err != nil {
+		t.Error(err)
+	}
+
+	os.WriteFile(path, []byte(`{"state":{"counts":"not a map"}}`), 0600)
+	c, _ = New(path)
+	if err := Register(c, "counts", func() map[string]int { return nil }, func(map[string]int) {}); err == nil {
+		t.Error("Expected mismatched state to be reported")
+	}
+}
@@ -0,0 +1,32 @@
+package classify
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// AdminHandler serves the request counts by tags:
+//
+//	GET /          counts as JSON
+//	GET /metrics   the same in Prometheus text format
+func (c *Classifier) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, c.Counts())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		b.WriteString("# HELP proxy_requests_classified_total Requests by client, kind and device.\n")
+		b.WriteString("# TYPE proxy_requests_classified_total counter\n")
+		for _, count := range c.Counts() {
+			fmt.Fprintf(&b, "proxy_requests_classified_total{client=%q,kind=%q,device=%q} %d\n",
+				count.Client, count.Kind, count.Device, count.Requests)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(b.String()))
+	})
+	return mux
+}
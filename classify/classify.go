@@ -0,0 +1,193 @@
+// Package classify tags each request as bot or human, api or web and mobile
+// or desktop, for access logs, metrics and the backends.
+package classify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kirtansoni/reverse-proxy-go/hostcheck"
+)
+
+// Header carries the tags of a request to the backends, e.g. "human,web,mobile".
+// A client-sent one is replaced.
+const Header = "X-Request-Tags"
+
+// Tag values. Each dimension has two, keeping metrics labels bounded.
+const (
+	Bot     = "bot"
+	Human   = "human"
+	API     = "api"
+	Web     = "web"
+	Mobile  = "mobile"
+	Desktop = "desktop"
+)
+
+// Tags are the classification of one request.
+type Tags struct {
+	Client string `json:"client"`
+	Kind   string `json:"kind"`
+	Device string `json:"device"`
+}
+
+func (t Tags) String() string {
+	return t.Client + "," + t.Kind + "," + t.Device
+}
+
+// Built-in rules, extended by Rules. User agents are matched by lower case
+// substring.
+var (
+	defaultBotAgents = []string{"bot", "crawl", "spider", "slurp", "curl/", "wget/", "python-requests", "python-urllib",
+		"go-http-client", "java/", "libwww", "httpie", "scrapy", "headlesschrome", "facebookexternalhit", "preview"}
+	defaultMobileAgents = []string{"mobi", "android", "iphone", "ipad", "ipod"}
+	defaultAPIPaths     = []string{"/api/", "/graphql", "/v1/", "/v2/"}
+)
+
+// Rules extend the built-in classification.
+type Rules struct {
+	// BotAgents and MobileAgents are user agent substrings, matched
+	// case-insensitively. An empty user agent is a bot.
+	BotAgents    []string `json:"bot_agents,omitempty"`
+	MobileAgents []string `json:"mobile_agents,omitempty"`
+	// APIPaths are path prefixes and APIHosts host names of API requests.
+	// Requests sending or asking only for JSON, gRPC or protobuf are API
+	// requests too.
+	APIPaths []string `json:"api_paths,omitempty"`
+	APIHosts []string `json:"api_hosts,omitempty"`
+}
+
+// Classifier tags requests and counts them by tags.
+type Classifier struct {
+	bots, mobiles, apiPaths []string
+	apiHosts                map[string]bool
+	// counts is indexed by client, kind and device bits.
+	counts [8]atomic.Int64
+}
+
+func New(rules Rules) *Classifier {
+	c := &Classifier{apiHosts: make(map[string]bool)}
+	for _, a := range append(defaultBotAgents, rules.BotAgents...) {
+		c.bots = append(c.bots, strings.ToLower(a))
+	}
+	for _, a := range append(defaultMobileAgents, rules.MobileAgents...) {
+		c.mobiles = append(c.mobiles, strings.ToLower(a))
+	}
+	c.apiPaths = append(append([]string{}, defaultAPIPaths...), rules.APIPaths...)
+	for _, h := range rules.APIHosts {
+		c.apiHosts[hostcheck.Normalize(h)] = true
+	}
+	return c
+}
+
+// Load reads Rules from a JSON file.
+func Load(path string) (*Classifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read classification rules: %v", err)
+	}
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse classification rules: %v", err)
+	}
+	return New(rules), nil
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify returns the tags of r.
+func (c *Classifier) Classify(r *http.Request) Tags {
+	ua := strings.ToLower(r.UserAgent())
+	t := Tags{Client: Human, Kind: Web, Device: Desktop}
+	if ua == "" || containsAny(ua, c.bots) {
+		t.Client = Bot
+	}
+	if containsAny(ua, c.mobiles) {
+		t.Device = Mobile
+	}
+	if c.api(r) {
+		t.Kind = API
+	}
+	return t
+}
+
+func (c *Classifier) api(r *http.Request) bool {
+	if c.apiHosts[hostcheck.Normalize(r.Host)] {
+		return true
+	}
+	for _, prefix := range c.apiPaths {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	contentType := r.Header.Get("Content-Type")
+	if strings.Contains(contentType, "json") || strings.HasPrefix(contentType, "application/grpc") || strings.Contains(contentType, "protobuf") {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return accept != "" && !strings.Contains(accept, "html") && !strings.Contains(accept, "*/*") &&
+		(strings.Contains(accept, "json") || strings.Contains(accept, "protobuf"))
+}
+
+func index(t Tags) int {
+	i := 0
+	if t.Client == Bot {
+		i |= 4
+	}
+	if t.Kind == API {
+		i |= 2
+	}
+	if t.Device == Mobile {
+		i |= 1
+	}
+	return i
+}
+
+func tagsOf(i int) Tags {
+	t := Tags{Client: Human, Kind: Web, Device: Desktop}
+	if i&4 != 0 {
+		t.Client = Bot
+	}
+	if i&2 != 0 {
+		t.Kind = API
+	}
+	if i&1 != 0 {
+		t.Device = Mobile
+	}
+	return t
+}
+
+// Middleware tags every request in Header and counts it.
+func (c *Classifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := c.Classify(r)
+		c.counts[index(t)].Add(1)
+		r.Header.Set(Header, t.String())
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Count is the number of requests with some tags.
+type Count struct {
+	Tags
+	Requests int64 `json:"requests"`
+}
+
+// Counts returns the requests seen for every combination of tags.
+func (c *Classifier) Counts() []Count {
+	counts := make([]Count, len(c.counts))
+	for i := range c.counts {
+		counts[i] = Count{tagsOf(i), c.counts[i].Load()}
+	}
+	return counts
+}
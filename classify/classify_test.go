@@ -0,0 +1,57 @@
+package classify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	c := New(Rules{BotAgents: []string{"UptimeRobot"}, APIHosts: []string{"api.example.com"}})
+	for _, tc := range []struct {
+		host, path, ua, accept, contentType string
+		want                                Tags
+	}{
+		{"example.com", "/", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0", "text/html", "", Tags{Human, Web, Desktop}},
+		{"example.com", "/", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148", "text/html", "", Tags{Human, Web, Mobile}},
+		{"example.com", "/", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "*/*", "", Tags{Bot, Web, Desktop}},
+		{"example.com", "/", "Mozilla/5.0+(compatible; UptimeRobot/2.0)", "", "", Tags{Bot, Web, Desktop}},
+		{"example.com", "/", "", "", "", Tags{Bot, Web, Desktop}},
+		{"example.com", "/api/orders", "curl/8.4.0", "*/*", "", Tags{Bot, API, Desktop}},
+		{"example.com", "/orders", "Mozilla/5.0 (Linux; Android 14) Mobile", "application/json", "", Tags{Human, API, Mobile}},
+		{"example.com", "/orders", "Mozilla/5.0 (X11; Linux x86_64)", "*/*", "application/json", Tags{Human, API, Desktop}},
+		{"API.example.com:443", "/orders", "Mozilla/5.0 (X11; Linux x86_64)", "", "", Tags{Human, API, Desktop}},
+	} {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		r.Host = tc.host
+		r.Header.Set("User-Agent", tc.ua)
+		r.Header.Set("Accept", tc.accept)
+		r.Header.Set("Content-Type", tc.contentType)
+		if got := c.Classify(r); got != tc.want {
+			t.Errorf("%s %s %q: got %v, want %v", tc.host, tc.path, tc.ua, got, tc.want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	c := New(Rules{})
+	var tags string
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tags = r.Header.Get(Header)
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("User-Agent", "Mozilla/5.0 (iPad; CPU OS 17_0)")
+	r.Header.Set(Header, "human,web,desktop")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if tags != "human,web,mobile" {
+		t.Errorf("Expected the client's header to be replaced, got %q", tags)
+	}
+
+	w := httptest.NewRecorder()
+	c.AdminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `proxy_requests_classified_total{client="human",kind="web",device="mobile"} 1`) ||
+		strings.Count(w.Body.String(), "proxy_requests_classified_total{") != 8 {
+		t.Errorf("Unexpected metrics:\n%s", w.Body)
+	}
+}
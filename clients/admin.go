@@ -0,0 +1,78 @@
+package clients
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// AdminHandler serves per-IP activity and ban management:
+//
+//	GET    /            recently seen IPs, busiest first
+//	GET    /bans        bans in effect
+//	POST   /bans        {"ip": "...", "duration": "1h", "reason": "..."}; no duration bans permanently
+//	DELETE /bans/{ip}   lift a ban
+//	GET    /tarpit      requests from banned IPs held in the tarpit
+func (t *Tracker) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, t.Clients())
+	})
+	mux.HandleFunc("GET /bans", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, t.Bans())
+	})
+	mux.HandleFunc("POST /bans", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			IP       string `json:"ip"`
+			Duration string `json:"duration"`
+			Reason   string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		var d time.Duration
+		if req.Duration != "" {
+			var err error
+			if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 {
+				admin.WriteError(w, http.StatusBadRequest, errors.New("invalid duration"))
+				return
+			}
+		}
+		b, err := t.Ban(req.IP, d, req.Reason)
+		if err != nil {
+			admin.WriteError(w, errorStatus(err), err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusCreated, b)
+	})
+	mux.HandleFunc("GET /tarpit", func(w http.ResponseWriter, r *http.Request) {
+		if t.Tarpit == nil {
+			admin.WriteError(w, http.StatusNotFound, errors.New("tarpit is disabled"))
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, t.Tarpit.Stats())
+	})
+	mux.HandleFunc("DELETE /bans/{ip}", func(w http.ResponseWriter, r *http.Request) {
+		found, err := t.Unban(r.PathValue("ip"))
+		switch {
+		case err != nil:
+			admin.WriteError(w, errorStatus(err), err)
+		case !found:
+			admin.WriteError(w, http.StatusNotFound, errors.New("IP is not banned"))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	return mux
+}
+
+func errorStatus(err error) int {
+	if errors.Is(err, errInvalidIP) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,435 @@
+// Package clients tracks connections and requests per client IP and enforces
+// temporary or permanent bans.
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/ratelimit"
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+)
+
+var errInvalidIP = errors.New("invalid IP")
+
+// idleExpiry is how long an IP without connections is remembered.
+const idleExpiry = 10 * time.Minute
+
+// Ban blocks an IP until a time, or forever when Until is nil.
+type Ban struct {
+	IP      string     `json:"ip"`
+	Reason  string     `json:"reason,omitempty"`
+	Created time.Time  `json:"created"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+func (b Ban) active(now time.Time) bool {
+	return b.Until == nil || now.Before(*b.Until)
+}
+
+// Client is the activity of a single IP.
+type Client struct {
+	IP                string    `json:"ip"`
+	Connections       int       `json:"connections"`
+	Requests          int64     `json:"requests"`
+	RequestsPerMinute float64   `json:"requests_per_minute"`
+	Limited           int64     `json:"limited"`
+	LastSeen          time.Time `json:"last_seen"`
+	Banned            bool      `json:"banned"`
+}
+
+type client struct {
+	conns    int
+	requests int64
+	limited  int64
+	lastSeen time.Time
+	// Requests in the current and previous minute, for a sliding rate.
+	minute int64
+	cur    int64
+	prev   int64
+}
+
+// Tracker records per-IP activity and rejects banned IPs. Bans are saved to
+// a store as JSON so they survive restarts.
+type Tracker struct {
+	// Limiter, if set, limits requests per IP; excess requests get 429.
+	Limiter *ratelimit.Limiter
+	// Tarpit, if set, holds requests from banned IPs instead of refusing
+	// them.
+	Tarpit *Tarpit
+
+	mu        sync.Mutex
+	store     storage.Store
+	key       string
+	clients   map[string]*client
+	bans      map[string]Ban
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// New returns a tracker persisting bans to path, loading any saved bans. An
+// empty path keeps bans in memory only.
+func New(path string) (*Tracker, error) {
+	if path == "" {
+		return NewWithStore(nil, "")
+	}
+	return NewWithStore(storage.NewDir(filepath.Dir(path)), filepath.Base(path))
+}
+
+// NewWithStore returns a tracker persisting bans to store under key,
+// loading any saved bans. A nil store keeps bans in memory only.
+func NewWithStore(store storage.Store, key string) (*Tracker, error) {
+	t := &Tracker{
+		store:   store,
+		key:     key,
+		clients: make(map[string]*client),
+		bans:    make(map[string]Ban),
+		now:     time.Now,
+	}
+	if store == nil {
+		return t, nil
+	}
+
+	data, err := store.Get(context.Background(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bans: %v", err)
+	}
+	bans, err := parseBans(data)
+	if err != nil {
+		return nil, err
+	}
+	t.bans = bans
+	return t, nil
+}
+
+func parseBans(data []byte) (map[string]Ban, error) {
+	var list []Ban
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse bans: %v", err)
+	}
+	bans := make(map[string]Ban, len(list))
+	for _, b := range list {
+		bans[b.IP] = b
+	}
+	return bans, nil
+}
+
+// Follow picks up the bans other instances sharing the store save to it,
+// until ctx is done.
+func (t *Tracker) Follow(ctx context.Context) {
+	if t.store == nil {
+		return
+	}
+	for data := range t.store.Watch(ctx, t.key) {
+		if data == nil {
+			continue
+		}
+		bans, err := parseBans(data)
+		if err != nil {
+			log.Printf("Ignoring bans from the store: %v", err)
+			continue
+		}
+		t.mu.Lock()
+		t.bans = bans
+		t.mu.Unlock()
+	}
+}
+
+// ConnState counts connections per IP and closes connections from banned
+// IPs as soon as they are accepted, unless they may end up in the Tarpit.
+// It is meant to be installed as http.Server.ConnState.
+func (t *Tracker) ConnState(c net.Conn, state http.ConnState) {
+	ip := addrIP(c.RemoteAddr().String())
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch state {
+	case http.StateNew:
+		if t.banned(ip) && (t.Tarpit == nil || t.Tarpit.active.Load() >= int64(t.Tarpit.MaxConns)) {
+			c.Close()
+		}
+		t.client(ip).conns++
+	case http.StateClosed, http.StateHijacked:
+		if cl, ok := t.clients[ip]; ok && cl.conns > 0 {
+			cl.conns--
+		}
+	}
+}
+
+func (t *Tracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := addrIP(r.RemoteAddr)
+
+		t.mu.Lock()
+		banned := t.banned(ip)
+		cl := t.client(ip)
+		cl.requests++
+		cl.count(t.now())
+		t.mu.Unlock()
+
+		if banned && t.Tarpit != nil && t.Tarpit.acquire() {
+			t.Tarpit.hold(w, r)
+			return
+		}
+		if banned {
+			w.Header().Set("Connection", "close")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if t.Limiter != nil {
+			status := t.Limiter.Take(ip)
+			status.SetHeaders(w.Header())
+			if !status.Allowed {
+				t.mu.Lock()
+				cl.limited++
+				t.mu.Unlock()
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Clients returns the activity of recently seen IPs, busiest first.
+func (t *Tracker) Clients() []Client {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	list := make([]Client, 0, len(t.clients))
+	for ip, cl := range t.clients {
+		list = append(list, Client{
+			IP:                ip,
+			Connections:       cl.conns,
+			Requests:          cl.requests,
+			RequestsPerMinute: cl.rate(now),
+			Limited:           cl.limited,
+			LastSeen:          cl.lastSeen,
+			Banned:            t.banned(ip),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].RequestsPerMinute != list[j].RequestsPerMinute {
+			return list[i].RequestsPerMinute > list[j].RequestsPerMinute
+		}
+		return list[i].IP < list[j].IP
+	})
+	return list
+}
+
+// Bans returns the bans in effect.
+func (t *Tracker) Bans() []Ban {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	list := make([]Ban, 0, len(t.bans))
+	for _, b := range t.bans {
+		if b.active(now) {
+			list = append(list, b)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].IP < list[j].IP })
+	return list
+}
+
+// Ban blocks ip for d, or permanently if d is zero.
+func (t *Tracker) Ban(ip string, d time.Duration, reason string) (Ban, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Ban{}, fmt.Errorf("%w %q", errInvalidIP, ip)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := Ban{IP: addr.Unmap().String(), Reason: reason, Created: t.now()}
+	if d > 0 {
+		until := b.Created.Add(d)
+		b.Until = &until
+	}
+	t.bans[b.IP] = b
+	return b, t.save()
+}
+
+// Unban lifts a ban, reporting whether there was one.
+func (t *Tracker) Unban(ip string) (bool, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, fmt.Errorf("%w %q", errInvalidIP, ip)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := addr.Unmap().String()
+	if _, ok := t.bans[key]; !ok {
+		return false, nil
+	}
+	delete(t.bans, key)
+	return true, t.save()
+}
+
+// banned reports whether ip is banned. Callers must hold t.mu.
+func (t *Tracker) banned(ip string) bool {
+	b, ok := t.bans[ip]
+	return ok && b.active(t.now())
+}
+
+// client returns the entry for ip, creating it if needed. Callers must hold
+// t.mu.
+func (t *Tracker) client(ip string) *client {
+	now := t.now()
+	t.prune(now)
+	cl, ok := t.clients[ip]
+	if !ok {
+		cl = &client{}
+		t.clients[ip] = cl
+	}
+	cl.lastSeen = now
+	return cl
+}
+
+// prune forgets idle IPs and expired bans at most once a minute. Callers
+// must hold t.mu.
+func (t *Tracker) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < time.Minute {
+		return
+	}
+	t.lastPrune = now
+	for ip, cl := range t.clients {
+		if cl.conns == 0 && now.Sub(cl.lastSeen) > idleExpiry {
+			delete(t.clients, ip)
+		}
+	}
+	expired := false
+	for ip, b := range t.bans {
+		if !b.active(now) {
+			delete(t.bans, ip)
+			expired = true
+		}
+	}
+	if expired {
+		t.save()
+	}
+}
+
+// save writes the bans to the store. Callers must hold t.mu.
+func (t *Tracker) save() error {
+	if t.store == nil {
+		return nil
+	}
+	bans := make([]Ban, 0, len(t.bans))
+	for _, b := range t.bans {
+		bans = append(bans, b)
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+	data, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := t.store.Put(context.Background(), t.key, data); err != nil {
+		return fmt.Errorf("failed to save bans: %v", err)
+	}
+	return nil
+}
+
+// Counters is the saved state of a Tracker's per-IP counters and rate
+// limits. Connection counts and bans are not included.
+type Counters struct {
+	Clients map[string]ClientCounters   `json:"clients"`
+	Limiter map[string]ratelimit.Bucket `json:"limiter,omitempty"`
+}
+
+// ClientCounters are the saved request counters of an IP.
+type ClientCounters struct {
+	Requests int64     `json:"requests"`
+	Limited  int64     `json:"limited"`
+	LastSeen time.Time `json:"last_seen"`
+	Minute   int64     `json:"minute"`
+	Current  int64     `json:"current"`
+	Previous int64     `json:"previous"`
+}
+
+// Snapshot returns the request counters and rate limits of recently seen
+// IPs.
+func (t *Tracker) Snapshot() Counters {
+	t.mu.Lock()
+	c := Counters{Clients: make(map[string]ClientCounters, len(t.clients))}
+	for ip, cl := range t.clients {
+		c.Clients[ip] = ClientCounters{cl.requests, cl.limited, cl.lastSeen, cl.minute, cl.cur, cl.prev}
+	}
+	t.mu.Unlock()
+	if t.Limiter != nil {
+		c.Limiter = t.Limiter.Snapshot()
+	}
+	return c
+}
+
+// Restore adds counters saved by Snapshot, replacing those of IPs seen
+// since. Set Limiter first for its buckets to be restored.
+func (t *Tracker) Restore(c Counters) {
+	t.mu.Lock()
+	for ip, saved := range c.Clients {
+		cl, ok := t.clients[ip]
+		if !ok {
+			cl = &client{}
+			t.clients[ip] = cl
+		}
+		cl.requests, cl.limited, cl.lastSeen = saved.Requests, saved.Limited, saved.LastSeen
+		cl.minute, cl.cur, cl.prev = saved.Minute, saved.Current, saved.Previous
+	}
+	t.mu.Unlock()
+	if t.Limiter != nil {
+		t.Limiter.Restore(c.Limiter)
+	}
+}
+
+func (cl *client) count(now time.Time) {
+	minute := now.Unix() / 60
+	switch minute - cl.minute {
+	case 0:
+	case 1:
+		cl.prev, cl.cur = cl.cur, 0
+	default:
+		cl.prev, cl.cur = 0, 0
+	}
+	cl.minute = minute
+	cl.cur++
+}
+
+// rate estimates requests over the last sixty seconds by weighting the
+// previous minute by how much of it is still in the window.
+func (cl *client) rate(now time.Time) float64 {
+	minute := now.Unix() / 60
+	cur, prev := cl.cur, cl.prev
+	switch minute - cl.minute {
+	case 0:
+	case 1:
+		cur, prev = 0, cur
+	default:
+		return 0
+	}
+	elapsed := float64(now.Unix()%60) / 60
+	return float64(prev)*(1-elapsed) + float64(cur)
+}
+
+func addrIP(addr string) string {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return addr
+	}
+	return ap.Addr().Unmap().String()
+}
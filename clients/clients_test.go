@@ -0,0 +1,119 @@
+package clients
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/ratelimit"
+)
+
+func request(handler http.Handler, addr string) int {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = addr
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestActivityAndLimits(t *testing.T) {
+	tracker, _ := New("")
+	tracker.Limiter = ratelimit.New(0, 2)
+	handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, want := range []int{200, 200, 429} {
+		if code := request(handler, "10.0.0.1:1000"); code != want {
+			t.Errorf("Request %d: expected %d, got %d", i, want, code)
+		}
+	}
+	request(handler, "[::ffff:10.0.0.2]:1000")
+
+	list := tracker.Clients()
+	if len(list) != 2 {
+		t.Fatalf("Expected 2 clients, got %d", len(list))
+	}
+	if list[0].IP != "10.0.0.1" || list[0].Requests != 3 || list[0].Limited != 1 || list[0].RequestsPerMinute < 3 {
+		t.Errorf("Unexpected activity: %+v", list[0])
+	}
+	if list[1].IP != "10.0.0.2" {
+		t.Errorf("Expected mapped address to be unmapped, got %s", list[1].IP)
+	}
+}
+
+func TestBansPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	now := time.Now()
+	tracker, err := New(path)
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	tracker.now = func() time.Time { return now }
+	handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	admin := tracker.AdminHandler()
+
+	for _, body := range []string{
+		`{"ip": "10.0.0.1", "duration": "1h", "reason": "scraping"}`,
+		`{"ip": "10.0.0.2"}`,
+	} {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest("POST", "/bans", strings.NewReader(body)))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected ban to be created, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("POST", "/bans", strings.NewReader(`{"ip": "nope"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid IP to be rejected, got %d", w.Code)
+	}
+
+	if code := request(handler, "10.0.0.1:1000"); code != http.StatusForbidden {
+		t.Errorf("Expected banned IP to get 403, got %d", code)
+	}
+
+	// Bans survive a restart; the temporary one expires.
+	reloaded, err := New(path)
+	if err != nil {
+		t.Fatalf("Failed to reload tracker: %v", err)
+	}
+	reloaded.now = func() time.Time { return now.Add(2 * time.Hour) }
+	bans := reloaded.Bans()
+	if len(bans) != 1 || bans[0].IP != "10.0.0.2" || bans[0].Until != nil {
+		t.Fatalf("Expected only the permanent ban to remain, got %+v", bans)
+	}
+	handler = reloaded.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if code := request(handler, "10.0.0.1:1000"); code != http.StatusOK {
+		t.Errorf("Expected expired ban to be lifted, got %d", code)
+	}
+
+	w = httptest.NewRecorder()
+	reloaded.AdminHandler().ServeHTTP(w, httptest.NewRequest("DELETE", "/bans/10.0.0.2", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected unban to succeed, got %d", w.Code)
+	}
+	if code := request(handler, "10.0.0.2:1000"); code != http.StatusOK {
+		t.Errorf("Expected unbanned IP to be allowed, got %d", code)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	tracker, _ := New("")
+	tracker.Limiter = ratelimit.New(0, 2)
+	handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request(handler, "10.0.0.1:1000")
+	request(handler, "10.0.0.1:1000")
+
+	restarted, _ := New("")
+	restarted.Limiter = ratelimit.New(0, 2)
+	restarted.Restore(tracker.Snapshot())
+	handler = restarted.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if code := request(handler, "10.0.0.1:1000"); code != 429 {
+		t.Errorf("Expected the restored limit to apply, got %d", code)
+	}
+	if list := restarted.Clients(); len(list) != 1 || list[0].Requests != 3 || list[0].Limited != 1 {
+		t.Errorf("Expected restored counters, got %+v", list)
+	}
+}
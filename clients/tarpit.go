@@ -0,0 +1,81 @@
+package clients
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Tarpit answers banned clients slowly, one byte at a time, instead of
+// refusing them, so that abusive clients waste their time while costing the
+// proxy little: at most MaxConns requests are held at once, each for at most
+// Duration. Banned clients beyond that are refused as without a tarpit.
+type Tarpit struct {
+	MaxConns int
+	// Interval is the wait between bytes, 10s if zero.
+	Interval time.Duration
+	// Duration is how long a request is held, 5m if zero.
+	Duration time.Duration
+
+	active atomic.Int64
+	total  atomic.Int64
+}
+
+// TarpitStats are the requests held in a tarpit.
+type TarpitStats struct {
+	Active int64 `json:"active"`
+	Total  int64 `json:"total"`
+}
+
+func (tp *Tarpit) Stats() TarpitStats {
+	return TarpitStats{Active: tp.active.Load(), Total: tp.total.Load()}
+}
+
+// acquire reserves a place in the tarpit.
+func (tp *Tarpit) acquire() bool {
+	if tp.active.Add(1) > int64(tp.MaxConns) {
+		tp.active.Add(-1)
+		return false
+	}
+	tp.total.Add(1)
+	return true
+}
+
+// hold dribbles a never-ending page to the client until Duration has
+// passed or the client gives up, and then closes the connection.
+func (tp *Tarpit) hold(w http.ResponseWriter, r *http.Request) {
+	defer tp.active.Add(-1)
+	interval, duration := tp.Interval, tp.Duration
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	if duration <= 0 {
+		duration = 5 * time.Minute
+	}
+	rc := http.NewResponseController(w)
+	// The server's write timeout would cut the tarpit short.
+	rc.SetWriteDeadline(time.Now().Add(duration + interval))
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(http.StatusOK)
+
+	deadline := time.NewTimer(duration)
+	defer deadline.Stop()
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		if _, err := w.Write([]byte(" ")); err != nil {
+			return
+		}
+		if rc.Flush() != nil {
+			return
+		}
+		select {
+		case <-tick.C:
+		case <-deadline.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package clients
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTarpit(t *testing.T) {
+	tracker, _ := New("")
+	tracker.Tarpit = &Tarpit{MaxConns: 1, Interval: 10 * time.Millisecond, Duration: 100 * time.Millisecond}
+	tracker.Ban("127.0.0.1", 0, "abuse")
+	srv := httptest.NewUnstartedServer(tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	srv.Config.ConnState = tracker.ConnState
+	srv.Start()
+	defer srv.Close()
+
+	start := time.Now()
+	held := make(chan string)
+	go func() {
+		res, err := http.Get(srv.URL)
+		if err != nil {
+			held <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		held <- string(body)
+	}()
+	for tracker.Tarpit.Stats().Active == 0 && time.Since(start) < time.Second {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The tarpit is full, so the next request is refused.
+	if res, err := http.Get(srv.URL); err == nil {
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected a full tarpit to refuse, got %d", res.StatusCode)
+		}
+		res.Body.Close()
+	}
+
+	body := <-held
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || strings.TrimSpace(body) != "" || len(body) < 5 {
+		t.Errorf("Expected a slow trickle of bytes, got %q after %v", body, elapsed)
+	}
+	if s := tracker.Tarpit.Stats(); s.Active != 0 || s.Total != 1 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+}
@@ -0,0 +1,140 @@
+// Command proxyctl manages a running proxy through its admin API.
+//
+//	proxyctl [-admin URL] cert renew <domain>
+//	proxyctl [-admin URL] cert pause <domain> [reason]
+//	proxyctl [-admin URL] cert resume <domain>
+//	proxyctl [-admin URL] provision <name> <host> <url>
+//	proxyctl [-admin URL] check --smuggling
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin/client"
+	"github.com/kirtansoni/reverse-proxy-go/proxy"
+)
+
+func main() {
+	adminURL := flag.String("admin", "http://127.0.0.1:8081", "Admin API URL of the proxy")
+	timeout := flag.Duration("timeout", 5*time.Minute, "Time allowed for the command")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: proxyctl [flags] cert renew|pause|resume <domain> [reason]\n       proxyctl [flags] provision <name> <host> <url>\n       proxyctl [flags] check --smuggling\n\nFlags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	c := client.New(*adminURL, nil)
+
+	var err error
+	switch args := flag.Args(); {
+	case len(args) == 3 && args[0] == "cert" && args[1] == "renew":
+		err = renewCert(ctx, c, args[2])
+	case (len(args) == 3 || len(args) == 4) && args[0] == "cert" && args[1] == "pause":
+		reason := ""
+		if len(args) == 4 {
+			reason = args[3]
+		}
+		if _, err = c.PauseACME(ctx, args[2], reason); err == nil {
+			fmt.Printf("Paused certificate issuance for %s\n", args[2])
+		}
+	case len(args) == 3 && args[0] == "cert" && args[1] == "resume":
+		if _, err = c.ResumeACME(ctx, args[2]); err == nil {
+			fmt.Printf("Resumed certificate issuance for %s\n", args[2])
+		}
+	case len(args) == 4 && args[0] == "provision":
+		err = provision(ctx, c, proxy.ProvisionRequest{Name: args[1], Host: args[2], URL: args[3]})
+	case len(args) == 2 && args[0] == "check" && (args[1] == "--smuggling" || args[1] == "-smuggling"):
+		err = checkSmuggling(ctx, c)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "proxyctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// renewCert forces a new Let's Encrypt certificate for domain, or, for a
+// proxy serving static certificates, renews or reloads it.
+func renewCert(ctx context.Context, c *client.Client, domain string) error {
+	stats, err := c.RenewACMECertificate(ctx, domain)
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		certs, err := c.RenewCertificate(ctx, domain)
+		if err != nil {
+			return err
+		}
+		for _, cert := range certs {
+			if cert.Domain == domain {
+				fmt.Printf("Renewed %s, expires %s\n", domain, cert.NotAfter.Format(time.RFC3339))
+				return nil
+			}
+		}
+		return fmt.Errorf("no certificate for %s after renewal", domain)
+	}
+	if err != nil {
+		return err
+	}
+	if stats.NotAfter == nil {
+		return fmt.Errorf("renewed %s but its expiry is unknown", domain)
+	}
+	fmt.Printf("Renewed %s, expires %s\n", domain, stats.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// provision routes req.Host to a new service and reports what is not ready
+// yet.
+func provision(ctx context.Context, c *client.Client, req proxy.ProvisionRequest) error {
+	p, err := c.Provision(ctx, req, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Routed %s to %s at %s\n", req.Host, p.Service.Url, p.Service.Path)
+	if !p.Probe.OK {
+		fmt.Printf("Backend not answering: %s\n", p.Probe.Error)
+	}
+	if cert := p.Certificate; cert != nil {
+		if cert.Ready {
+			fmt.Printf("Certificate for %s expires %s\n", req.Host, cert.NotAfter.Format(time.RFC3339))
+		} else {
+			fmt.Printf("Certificate for %s not ready: %s\n", req.Host, cert.Error)
+		}
+	}
+	if !p.Ready {
+		return errors.New("provisioning incomplete; run the command again to check")
+	}
+	return nil
+}
+
+// checkSmuggling runs the proxy's request smuggling self-test and prints
+// the outcome of every vector, failing if any did not pass.
+func checkSmuggling(ctx context.Context, c *client.Client) error {
+	report, err := c.SmugglingSelfTest(ctx)
+	if err != nil {
+		return err
+	}
+	for _, res := range report.Results {
+		outcome := "PASS"
+		if !res.Pass {
+			outcome = "FAIL"
+		}
+		fmt.Printf("%s  %-22s %s: %s\n", outcome, res.Name, res.Description, res.Detail)
+	}
+	fmt.Printf("%d passed, %d failed\n", report.Passed, report.Failed)
+	if !report.Strict {
+		fmt.Println("Strict HTTP/1 parsing is off; start the proxy with -strict-http1 to refuse ambiguous requests")
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d smuggling checks failed", report.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,350 @@
+// Package errorpages replaces the bare error responses browsers get from
+// the proxy with HTML pages in the visitor's language, and serves a
+// maintenance page while maintenance mode is on.
+package errorpages
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// RequestIDHeader carries the ID shown on pages, so visitors can quote it
+// when reporting a problem.
+const RequestIDHeader = "X-Request-Id"
+
+// fileName matches page files: a status code, a status class (5xx), error
+// or maintenance, optionally followed by a language tag.
+var fileName = regexp.MustCompile(`^(\d{3}|[45]xx|error|maintenance)(?:\.([A-Za-z]{2,3}(?:-[A-Za-z0-9]{1,8})*))?\.html$`)
+
+// Data is what page templates are executed with.
+type Data struct {
+	Status     int
+	StatusText string
+	RequestID  string
+	// RetryAfter is the number of seconds until the client should retry,
+	// zero if unknown, and RetryAt the time it falls on.
+	RetryAfter int
+	RetryAt    time.Time
+	// Lang is the language of the page; empty for the default variant.
+	Lang    string
+	Path    string
+	Message string
+}
+
+// Maintenance describes a maintenance window.
+type Maintenance struct {
+	Since time.Time `json:"since"`
+	// Until, if set, is when maintenance is expected to end; it is sent as
+	// Retry-After.
+	Until   *time.Time `json:"until,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+// Pages serves the page templates of a directory.
+type Pages struct {
+	// pages maps a page name to its templates by lowercase language tag,
+	// "" being the default variant.
+	pages       map[string]map[string]*template.Template
+	maintenance atomic.Pointer[Maintenance]
+}
+
+// Load reads the pages of dir. Files are named after the status they are
+// for, such as 503.html, a status class such as 5xx.html, error.html for
+// every error, or maintenance.html, with variants per language such as
+// 503.fr.html or error.pt-BR.html.
+func Load(dir string) (*Pages, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error pages: %v", err)
+	}
+	p := &Pages{pages: make(map[string]map[string]*template.Template)}
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		tmpl, err := template.ParseFiles(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse error page %s: %v", e.Name(), err)
+		}
+		if p.pages[m[1]] == nil {
+			p.pages[m[1]] = make(map[string]*template.Template)
+		}
+		p.pages[m[1]][strings.ToLower(m[2])] = tmpl
+	}
+	if len(p.pages) == 0 {
+		return nil, fmt.Errorf("no error pages in %s", dir)
+	}
+	return p, nil
+}
+
+// lookup returns the most specific page for status in the language the
+// client prefers.
+func (p *Pages) lookup(status int, maintenance bool, acceptLanguage string) (*template.Template, string) {
+	names := []string{strconv.Itoa(status), fmt.Sprintf("%dxx", status/100), "error"}
+	if maintenance {
+		names = append([]string{"maintenance"}, names...)
+	}
+	for _, name := range names {
+		if variants, ok := p.pages[name]; ok {
+			lang := negotiate(acceptLanguage, variants)
+			return variants[lang], lang
+		}
+	}
+	return nil, ""
+}
+
+// negotiate picks the variant for an Accept-Language header: the first
+// preferred language with a variant, matching either exactly or by its
+// primary language, else the default variant.
+func negotiate(header string, variants map[string]*template.Template) string {
+	type pref struct {
+		tag string
+		q   float64
+	}
+	var prefs []pref
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			prefs = append(prefs, pref{tag, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	for _, pref := range prefs {
+		if _, ok := variants[pref.tag]; ok {
+			return pref.tag
+		}
+		primary, _, _ := strings.Cut(pref.tag, "-")
+		for lang := range variants {
+			if lang == primary || strings.HasPrefix(lang, primary+"-") {
+				return lang
+			}
+		}
+	}
+	if _, ok := variants[""]; ok {
+		return ""
+	}
+	langs := make([]string, 0, len(variants))
+	for lang := range variants {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs[0]
+}
+
+// SetMaintenance turns maintenance mode on, or off with nil.
+func (p *Pages) SetMaintenance(m *Maintenance) {
+	p.maintenance.Store(m)
+}
+
+// CurrentMaintenance returns the maintenance window in progress, if any.
+func (p *Pages) CurrentMaintenance() *Maintenance {
+	return p.maintenance.Load()
+}
+
+// Middleware answers every request with a 503 maintenance page while
+// maintenance mode is on, and replaces error responses (4xx and 5xx)
+// without a body of their own, or with a plain text one, with the matching
+// page for clients accepting HTML. Error pages of the services themselves
+// pass through.
+func (p *Pages) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m := p.maintenance.Load(); m != nil {
+			var retryAt time.Time
+			if m.Until != nil && m.Until.After(time.Now()) {
+				retryAt = *m.Until
+			}
+			p.render(w, r, http.StatusServiceUnavailable, true, retryAt, m.Message)
+			return
+		}
+		if !acceptsHTML(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&pageWriter{ResponseWriter: w, pages: p, r: r}, r)
+	})
+}
+
+func acceptsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// render writes the page for status and reports whether it wrote anything.
+// Without a page, or for clients not accepting HTML, it leaves the
+// response alone, except for maintenance, which falls back to plain text.
+func (p *Pages) render(w http.ResponseWriter, r *http.Request, status int, maintenance bool, retryAt time.Time, message string) bool {
+	data := Data{Status: status, StatusText: http.StatusText(status), Path: r.URL.Path, Message: message}
+	if !retryAt.IsZero() {
+		data.RetryAfter = max(1, int(time.Until(retryAt).Round(time.Second)/time.Second))
+		data.RetryAt = retryAt
+		w.Header().Set("Retry-After", strconv.Itoa(data.RetryAfter))
+	}
+	var tmpl *template.Template
+	if acceptsHTML(r) {
+		tmpl, data.Lang = p.lookup(status, maintenance, r.Header.Get("Accept-Language"))
+	}
+	if tmpl == nil {
+		if !maintenance {
+			return false
+		}
+		http.Error(w, data.StatusText, status)
+		return true
+	}
+	data.RequestID = r.Header.Get(RequestIDHeader)
+	if data.RequestID == "" {
+		data.RequestID = w.Header().Get(RequestIDHeader)
+	}
+	if data.RequestID == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		data.RequestID = hex.EncodeToString(b)
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		log.Printf("Failed to render the %d page: %v", status, err)
+		return false
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	h.Set(RequestIDHeader, data.RequestID)
+	h.Add("Vary", "Accept-Language")
+	if data.Lang != "" {
+		h.Set("Content-Language", data.Lang)
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body.Bytes())
+	}
+	return true
+}
+
+// pageWriter replaces an error response with a page when its header is
+// written, discarding the original body.
+type pageWriter struct {
+	http.ResponseWriter
+	pages       *Pages
+	r           *http.Request
+	wroteHeader bool
+	replaced    bool
+}
+
+func (w *pageWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+	if status >= 400 && replaceable(w.Header()) {
+		var retryAt time.Time
+		if secs, err := strconv.Atoi(w.Header().Get("Retry-After")); err == nil && secs > 0 {
+			retryAt = time.Now().Add(time.Duration(secs) * time.Second)
+		}
+		if w.replaced = w.pages.render(w.ResponseWriter, w.r, status, false, retryAt, ""); w.replaced {
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// replaceable reports whether a response has no body of its own worth
+// keeping: no content type, or the plain text of http.Error.
+func replaceable(h http.Header) bool {
+	ct := h.Get("Content-Type")
+	return ct == "" || strings.HasPrefix(ct, "text/plain")
+}
+
+func (w *pageWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *pageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *pageWriter) Flush() {
+	if !w.replaced {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+// AdminHandler manages maintenance mode:
+//
+//	GET    /maintenance   the maintenance window in progress, or null
+//	PUT    /maintenance   {"until": "...", "retry_after": "30m", "message": "..."}; starts it
+//	DELETE /maintenance   ends it
+func (p *Pages) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /maintenance", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, p.CurrentMaintenance())
+	})
+	mux.HandleFunc("PUT /maintenance", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Until      *time.Time `json:"until"`
+			RetryAfter string     `json:"retry_after"`
+			Message    string     `json:"message"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				admin.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		m := &Maintenance{Since: time.Now().UTC().Truncate(time.Second), Until: req.Until, Message: req.Message}
+		if req.RetryAfter != "" {
+			d, err := time.ParseDuration(req.RetryAfter)
+			if err != nil || d <= 0 {
+				admin.WriteError(w, http.StatusBadRequest, errors.New("retry_after must be a positive duration"))
+				return
+			}
+			until := m.Since.Add(d)
+			m.Until = &until
+		}
+		p.SetMaintenance(m)
+		admin.WriteJSON(w, http.StatusOK, m)
+	})
+	mux.HandleFunc("DELETE /maintenance", func(w http.ResponseWriter, r *http.Request) {
+		p.SetMaintenance(nil)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
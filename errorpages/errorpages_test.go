@@ -0,0 +1,117 @@
+package errorpages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadPages(t *testing.T, files map[string]string) *Pages {
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestErrorPages(t *testing.T) {
+	p := loadPages(t, map[string]string{
+		"5xx.html":         `{{.Status}} {{.RequestID}} retry in {{.RetryAfter}}`,
+		"5xx.fr.html":      `{{.Status}} réessayez dans {{.RetryAfter}}`,
+		"error.html":       `error {{.Status}} {{.Path}}`,
+		"error.pt-BR.html": `erro {{.Status}}`,
+		"maintenance.html": `maintenance: {{.Message}}`,
+	})
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/down":
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "upstream unavailable", http.StatusServiceUnavailable)
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	h := p.Middleware(backend)
+
+	tests := []struct {
+		path, accept, lang, requestID string
+		want, contentLanguage         string
+	}{
+		{"/down", "text/html", "", "abc", "503 abc retry in 30", ""},
+		{"/down", "text/html", "fr-CA, en;q=0.5", "", "503 réessayez dans 30", "fr"},
+		{"/down", "text/html", "de, fr;q=0", "abc", "503 abc retry in 30", ""},
+		{"/missing", "text/html", "pt", "", "erro 404", "pt-br"},
+		{"/missing", "text/html", "", "", "error 404 /missing", ""},
+		{"/missing", "*/*", "", "", "404 page not found\n", ""},
+		{"/json", "text/html", "", "", `{"error":"not found"}`, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Accept", tt.accept)
+		req.Header.Set("Accept-Language", tt.lang)
+		req.Header.Set(RequestIDHeader, tt.requestID)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("%s %q: expected %q, got %q", tt.path, tt.lang, tt.want, got)
+		}
+		if got := rec.Header().Get("Content-Language"); got != tt.contentLanguage {
+			t.Errorf("%s %q: expected Content-Language %q, got %q", tt.path, tt.lang, tt.contentLanguage, got)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/missing", nil)
+	req.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if id := rec.Header().Get(RequestIDHeader); len(id) != 16 || !strings.Contains(rec.Body.String(), "404") {
+		t.Errorf("Expected a generated request ID, got %q", id)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	p := loadPages(t, map[string]string{"maintenance.html": `{{.Message}} until {{.RetryAt.Format "15:04"}}`})
+	h := p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	until := time.Now().Add(10 * time.Minute)
+	p.SetMaintenance(&Maintenance{Since: time.Now(), Until: &until, Message: "Upgrading"})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "Upgrading until "+until.Format("15:04") {
+		t.Errorf("Expected the maintenance page, got %d %q", rec.Code, rec.Body.String())
+	}
+	if ra := rec.Header().Get("Retry-After"); ra != "600" {
+		t.Errorf("Expected Retry-After 600, got %q", ra)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("Expected a plain text 503 for non-HTML clients, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	p.SetMaintenance(nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("Expected requests to pass after maintenance, got %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,235 @@
+// Package events is an in-process publish/subscribe bus for lifecycle
+// events: services added, changed or removed, route tables applied, backend
+// health changes and certificates issued. Webhooks, the admin event stream
+// and embedders all subscribe to the same bus.
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// Type is the kind of an event.
+type Type string
+
+const (
+	ServiceAdded   Type = "service.added"
+	ServiceChanged Type = "service.changed"
+	ServiceRemoved Type = "service.removed"
+	// ConfigApplied follows the service events of one route table change.
+	ConfigApplied Type = "config.applied"
+	// ConfigReloaded is the outcome of reconciling the route table to a
+	// new state, applied or rejected.
+	ConfigReloaded Type = "config.reloaded"
+	// HealthChanged is a synthetic check failing or recovering.
+	HealthChanged Type = "health.changed"
+	CertIssued    Type = "cert.issued"
+	CertRenewed   Type = "cert.renewed"
+)
+
+// DefaultBuffer is the number of events a subscriber may fall behind by
+// before further events are dropped for it.
+const DefaultBuffer = 64
+
+// Event is something that happened to the proxy.
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	// Subject is what the event is about: a service name, or a domain for
+	// certificate events.
+	Subject string `json:"subject,omitempty"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// Bus delivers published events to subscribers. A nil *Bus discards
+// everything published to it.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]bool)}
+}
+
+// Publish sends e to every subscriber interested in its type, setting its
+// time if unset. It never blocks: a subscriber whose buffer is full misses
+// the event.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if len(s.types) > 0 && !s.types[e.Type] {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Subscription receives the events of a Bus on C until closed.
+type Subscription struct {
+	C <-chan Event
+
+	bus     *Bus
+	c       chan Event
+	types   map[Type]bool
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// Subscribe returns a subscription to events of the given types, or all
+// events if none are given, buffering up to buffer of them (DefaultBuffer
+// if 0).
+func (b *Bus) Subscribe(buffer int, types ...Type) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	s := &Subscription{bus: b, c: make(chan Event, buffer), types: make(map[Type]bool)}
+	s.C = s.c
+	for _, t := range types {
+		s.types[t] = true
+	}
+	if b != nil {
+		b.mu.Lock()
+		b.subs[s] = true
+		b.mu.Unlock()
+	}
+	return s
+}
+
+// Close stops the subscription and closes C.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		if s.bus != nil {
+			s.bus.mu.Lock()
+			delete(s.bus.subs, s)
+			s.bus.mu.Unlock()
+		}
+		close(s.c)
+	})
+}
+
+// Dropped returns the number of events missed because the buffer was full.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// ParseTypes parses a comma-separated list of event types; an empty list
+// means all of them.
+func ParseTypes(list string) ([]Type, error) {
+	var types []Type
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		switch Type(t) {
+		case ServiceAdded, ServiceChanged, ServiceRemoved, ConfigApplied, ConfigReloaded, HealthChanged, CertIssued, CertRenewed:
+			types = append(types, Type(t))
+		default:
+			return nil, fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	return types, nil
+}
+
+// Forward POSTs events of the given types, or all events, to webhook as
+// JSON, one at a time, until stop is called. Failed posts are logged and not
+// retried.
+func (b *Bus) Forward(webhook string, types ...Type) (stop func()) {
+	s := b.Subscribe(0, types...)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for e := range s.C {
+			if err := post(ctx, webhook, e); err != nil {
+				log.Printf("Failed to post %s event to webhook: %v", e.Type, err)
+			}
+		}
+	}()
+	return func() {
+		s.Close()
+		cancel()
+	}
+}
+
+func post(ctx context.Context, webhook string, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Handler streams events as server-sent events, one JSON Event per
+// message:
+//
+//	GET /?type=a,b   events of the given types (default: all)
+func (b *Bus) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			admin.WriteError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		types, err := ParseTypes(r.URL.Query().Get("type"))
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		s := b.Subscribe(0, types...)
+		defer s.Close()
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		rc.Flush()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e := <-s.C:
+				data, _ := json.Marshal(e)
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+					return
+				}
+				rc.Flush()
+			}
+		}
+	})
+}
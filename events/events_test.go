@@ -0,0 +1,88 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	all := bus.Subscribe(0)
+	health := bus.Subscribe(1, HealthChanged)
+
+	bus.Publish(Event{Type: ServiceAdded, Subject: "blog"})
+	bus.Publish(Event{Type: HealthChanged, Subject: "blog"})
+	bus.Publish(Event{Type: HealthChanged, Subject: "shop"})
+
+	if e := <-all.C; e.Type != ServiceAdded || e.Subject != "blog" || e.Time.IsZero() {
+		t.Errorf("Unexpected first event %+v", e)
+	}
+	if e := <-health.C; e.Type != HealthChanged || e.Subject != "blog" {
+		t.Errorf("Unexpected filtered event %+v", e)
+	}
+	if health.Dropped() != 1 || all.Dropped() != 0 {
+		t.Errorf("Expected the full buffer to drop one event, got %d and %d", health.Dropped(), all.Dropped())
+	}
+	health.Close()
+	health.Close()
+	if _, ok := <-health.C; ok {
+		t.Error("Expected a closed subscription to close its channel")
+	}
+	bus.Publish(Event{Type: HealthChanged})
+
+	var nilBus *Bus
+	nilBus.Publish(Event{Type: ServiceAdded})
+	if _, err := ParseTypes("service.added, cert.renewed"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseTypes("service.exploded"); err == nil {
+		t.Error("Expected an unknown type to be rejected")
+	}
+}
+
+func TestForwardAndStream(t *testing.T) {
+	bus := NewBus()
+	posted := make(chan Event, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		json.NewDecoder(r.Body).Decode(&e)
+		posted <- e
+	}))
+	defer webhook.Close()
+	stop := bus.Forward(webhook.URL, CertRenewed)
+	defer stop()
+
+	stream := httptest.NewServer(bus.Handler())
+	defer stream.Close()
+	resp, err := http.Get(stream.URL + "/?type=cert.renewed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	bus.Publish(Event{Type: ServiceAdded, Subject: "blog"})
+	bus.Publish(Event{Type: CertRenewed, Subject: "example.com"})
+	select {
+	case e := <-posted:
+		if e.Type != CertRenewed || e.Subject != "example.com" {
+			t.Errorf("Unexpected posted event %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the event to be posted")
+	}
+
+	r := bufio.NewReader(resp.Body)
+	line, _ := r.ReadString('\n')
+	data, _ := r.ReadString('\n')
+	if line != "event: cert.renewed\n" || !strings.Contains(data, `"subject":"example.com"`) {
+		t.Errorf("Unexpected stream %q %q", line, data)
+	}
+}
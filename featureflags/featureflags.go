@@ -0,0 +1,207 @@
+// Package featureflags evaluates feature flags per user and passes them to
+// backends as request headers, so every backend behind the proxy sees the
+// same flag state for a request.
+package featureflags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// HeaderPrefix starts the name of every flag header, e.g. X-Flag-New-Checkout
+// for the flag new-checkout. Clients cannot set headers with this prefix.
+const HeaderPrefix = "X-Flag-"
+
+// Source evaluates the flags of a user; user is empty for anonymous
+// requests. Values are passed to backends as they are.
+type Source interface {
+	Evaluate(ctx context.Context, user string) (map[string]string, error)
+}
+
+// Flag is a flag defined in a flag file.
+type Flag struct {
+	Key string `json:"key"`
+	// Enabled turns the flag on for everyone. Otherwise it is on for Users
+	// and for Rollout percent of the other known users.
+	Enabled bool     `json:"enabled,omitempty"`
+	Users   []string `json:"users,omitempty"`
+	Rollout float64  `json:"rollout,omitempty"`
+	// On and Off are the values passed when the flag is on and off,
+	// "true" and "false" by default.
+	On  string `json:"on,omitempty"`
+	Off string `json:"off,omitempty"`
+}
+
+func (f Flag) evaluate(user string) string {
+	on := f.Enabled
+	if user != "" && !on {
+		for _, u := range f.Users {
+			if u == user {
+				on = true
+			}
+		}
+		on = on || bucket(f.Key, user) < f.Rollout
+	}
+	switch {
+	case on && f.On != "":
+		return f.On
+	case on:
+		return "true"
+	case f.Off != "":
+		return f.Off
+	}
+	return "false"
+}
+
+// bucket places a user in [0, 100) for a flag, stable across restarts and
+// independent between flags.
+func bucket(key, user string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(key + "." + user))
+	return float64(h.Sum32()%10000) / 100
+}
+
+// File is a Source reading flags from a JSON file of the form
+// {"flags": [...]}. The file is reread when it changes.
+type File struct {
+	path string
+
+	mu      sync.Mutex
+	flags   []Flag
+	modTime time.Time
+	checked time.Time
+}
+
+func NewFile(path string) (*File, error) {
+	f := &File{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// reload rereads the file if it changed. Callers must hold f.mu, except
+// in NewFile.
+func (f *File) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read flags: %v", err)
+	}
+	if info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read flags: %v", err)
+	}
+	var doc struct {
+		Flags []Flag `json:"flags"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse flags: %v", err)
+	}
+	for _, flag := range doc.Flags {
+		if flag.Key == "" || strings.ContainsAny(flag.Key, " :\r\n") {
+			return fmt.Errorf("invalid flag key %q", flag.Key)
+		}
+	}
+	f.flags, f.modTime = doc.Flags, info.ModTime()
+	return nil
+}
+
+// Evaluate returns the value of every flag for user. A file that fails to
+// reload keeps its last good flags.
+func (f *File) Evaluate(ctx context.Context, user string) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var err error
+	if now := time.Now(); now.Sub(f.checked) >= time.Second {
+		f.checked = now
+		err = f.reload()
+	}
+	values := make(map[string]string, len(f.flags))
+	for _, flag := range f.flags {
+		values[flag.Key] = flag.evaluate(user)
+	}
+	return values, err
+}
+
+// Injector sets flag headers on the requests passing through its
+// middleware.
+type Injector struct {
+	Source Source
+	// User returns the user a request is evaluated for, "" if anonymous.
+	User func(*http.Request) string
+
+	mu        sync.Mutex
+	lastAlert time.Time
+}
+
+// UserHeader returns a User function reading the named header.
+func UserHeader(name string) func(*http.Request) string {
+	return func(r *http.Request) string { return r.Header.Get(name) }
+}
+
+func (in *Injector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+		for name := range r.Header {
+			if strings.HasPrefix(name, HeaderPrefix) {
+				r.Header.Del(name)
+			}
+		}
+		var user string
+		if in.User != nil {
+			user = in.User(r)
+		}
+		values, err := in.Source.Evaluate(r.Context(), user)
+		if err != nil {
+			in.logError(err)
+		}
+		for key, value := range values {
+			r.Header.Set(HeaderName(key), value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HeaderName returns the header carrying the flag key.
+func HeaderName(key string) string {
+	return textproto.CanonicalMIMEHeaderKey(HeaderPrefix + key)
+}
+
+// logError logs evaluation errors at most once a minute.
+func (in *Injector) logError(err error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if time.Since(in.lastAlert) < time.Minute {
+		return
+	}
+	in.lastAlert = time.Now()
+	log.Printf("Feature flags: %v", err)
+}
+
+// AdminHandler serves GET /?user=<user>, the flags a user would get.
+func (in *Injector) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		values, err := in.Source.Evaluate(r.Context(), r.URL.Query().Get("user"))
+		if err != nil && values == nil {
+			admin.WriteError(w, http.StatusBadGateway, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, values)
+	})
+	return mux
+}
@@ -0,0 +1,88 @@
+package featureflags
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileAndInjector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flags.json")
+	os.WriteFile(path, []byte(`{"flags": [
+		{"key": "new-checkout", "users": ["alice"], "on": "v2", "off": "v1"},
+		{"key": "dark-mode", "enabled": true},
+		{"key": "half", "rollout": 50}
+	]}`), 0600)
+	source, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load flags: %v", err)
+	}
+
+	var got http.Header
+	in := &Injector{Source: source, User: UserHeader("X-User-Id")}
+	handler := in.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r.Header }))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-User-Id", "alice")
+	r.Header.Set("X-Flag-Spoofed", "true")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got.Get("X-Flag-New-Checkout") != "v2" || got.Get("X-Flag-Dark-Mode") != "true" || got.Get("X-Flag-Spoofed") != "" {
+		t.Errorf("Unexpected flag headers: %v", got)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if got.Get("X-Flag-New-Checkout") != "v1" || got.Get("X-Flag-Half") != "false" {
+		t.Errorf("Unexpected flags for an anonymous user: %v", got)
+	}
+
+	on := 0
+	for i := 0; i < 1000; i++ {
+		if (Flag{Key: "half", Rollout: 50}).evaluate("user"+string(rune('a'+i%26))+strings.Repeat("x", i/26)) == "true" {
+			on++
+		}
+	}
+	if on < 400 || on > 600 {
+		t.Errorf("Expected about half of the users in a 50%% rollout, got %d of 1000", on)
+	}
+}
+
+func TestRemote(t *testing.T) {
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		encoded, ok := strings.CutPrefix(r.URL.Path, "/sdk/evalx/env-1/contexts/")
+		data, _ := base64.URLEncoding.DecodeString(encoded)
+		var ctx map[string]any
+		if !ok || json.Unmarshal(data, &ctx) != nil || ctx["key"] != "alice" {
+			http.Error(w, "bad context", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"new-checkout": {"value": true, "variation": 0}, "banner": {"value": "blue"}}`))
+	}))
+	defer server.Close()
+
+	remote, _ := NewRemote(server.URL, "env-1")
+	values, err := remote.Evaluate(context.Background(), "alice")
+	if err != nil || values["new-checkout"] != "true" || values["banner"] != "blue" {
+		t.Fatalf("Unexpected flags %v (%v)", values, err)
+	}
+	remote.Evaluate(context.Background(), "alice")
+	if fetches != 1 {
+		t.Errorf("Expected cached flags to be reused, got %d fetches", fetches)
+	}
+
+	remote.TTL = 0
+	server.Close()
+	values, err = remote.Evaluate(context.Background(), "alice")
+	if err == nil || values["banner"] != "blue" {
+		t.Errorf("Expected stale flags and an error while the service is down, got %v (%v)", values, err)
+	}
+	if _, err := NewRemote(server.URL, ""); err == nil {
+		t.Error("Expected a missing environment to be rejected")
+	}
+}
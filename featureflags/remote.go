@@ -0,0 +1,112 @@
+package featureflags
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCachedUsers bounds the evaluations a Remote keeps.
+const maxCachedUsers = 10000
+
+// Remote is a Source backed by the client-side evaluation endpoint of a
+// LaunchDarkly-compatible service (LaunchDarkly itself or its Relay Proxy).
+// Evaluations are cached per user for TTL; if the service is unreachable a
+// user's last evaluation is used until it comes back.
+type Remote struct {
+	// BaseURL is e.g. "https://clientsdk.launchdarkly.com".
+	BaseURL string
+	// Environment is the client-side ID of the environment.
+	Environment string
+	TTL         time.Duration
+	Client      *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedFlags
+}
+
+type cachedFlags struct {
+	values  map[string]string
+	fetched time.Time
+}
+
+func NewRemote(baseURL, environment string) (*Remote, error) {
+	if environment == "" {
+		return nil, errors.New("a LaunchDarkly-compatible flag source needs a client-side environment ID")
+	}
+	return &Remote{
+		BaseURL:     strings.TrimSuffix(baseURL, "/"),
+		Environment: environment,
+		TTL:         30 * time.Second,
+		Client:      &http.Client{Timeout: 2 * time.Second},
+		cache:       make(map[string]cachedFlags),
+	}, nil
+}
+
+func (rm *Remote) Evaluate(ctx context.Context, user string) (map[string]string, error) {
+	rm.mu.Lock()
+	cached, ok := rm.cache[user]
+	rm.mu.Unlock()
+	if ok && time.Since(cached.fetched) < rm.TTL {
+		return cached.values, nil
+	}
+
+	values, err := rm.fetch(ctx, user)
+	if err != nil {
+		// Stale values beat none.
+		return cached.values, err
+	}
+	rm.mu.Lock()
+	if len(rm.cache) >= maxCachedUsers {
+		rm.cache = make(map[string]cachedFlags)
+	}
+	rm.cache[user] = cachedFlags{values, time.Now()}
+	rm.mu.Unlock()
+	return values, nil
+}
+
+func (rm *Remote) fetch(ctx context.Context, user string) (map[string]string, error) {
+	evalContext := map[string]any{"kind": "user", "key": user}
+	if user == "" {
+		evalContext["key"] = "anonymous"
+		evalContext["anonymous"] = true
+	}
+	data, _ := json.Marshal(evalContext)
+	url := fmt.Sprintf("%s/sdk/evalx/%s/contexts/%s", rm.BaseURL, rm.Environment, base64.URLEncoding.EncodeToString(data))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := rm.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch flags: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch flags: %s", res.Status)
+	}
+
+	var flags map[string]struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&flags); err != nil {
+		return nil, fmt.Errorf("failed to parse flags: %v", err)
+	}
+	values := make(map[string]string, len(flags))
+	for key, flag := range flags {
+		var s string
+		if json.Unmarshal(flag.Value, &s) == nil {
+			values[key] = s
+		} else {
+			// Booleans, numbers and JSON variations are passed as JSON.
+			values[key] = string(flag.Value)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,377 @@
+// Package forward is an authenticated forward proxy through which trusted
+// clients reach other hosts, speaking HTTP (CONNECT tunnels and
+// absolute-URI requests) and SOCKS5 on the same port. Clients present an
+// API key of the access policy as their proxy password, and the policy's
+// rules and rate limits apply to every request and tunnel. Destinations on
+// this host or its private networks are refused unless allowed.
+package forward
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/access"
+	"github.com/kirtansoni/reverse-proxy-go/accesslog"
+)
+
+// dialTimeout bounds connecting to a destination when DialContext is not
+// set.
+const dialTimeout = 10 * time.Second
+
+// Proxy is a forward proxy for the API keys of an access policy.
+type Proxy struct {
+	// Policy identifies clients by the API key given as their password;
+	// clients without a known key are refused. Its rules decide which
+	// requests and tunnels are allowed, with "CONNECT" as the method of
+	// tunnels.
+	Policy *access.Engine
+	// Log, if set, logs every request and tunnel.
+	Log *accesslog.Logger
+	// Middleware runs around every request and tunnel, the first outermost,
+	// e.g. client bans and per-IP rate limits.
+	Middleware []func(http.Handler) http.Handler
+	// DialContext connects to destinations, given as IP addresses once
+	// they are checked; nil means a net.Dialer.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// AllowPrivate lets clients reach private networks (RFC 1918, RFC 6598
+	// shared addresses and unique local IPv6 addresses).
+	AllowPrivate bool
+	// Allow are destinations clients may reach even though they are
+	// refused by default: loopback, link-local (including cloud metadata
+	// services), unspecified and multicast addresses, the addresses of this
+	// host's interfaces, and private networks without AllowPrivate.
+	Allow []netip.Prefix
+
+	once      sync.Once
+	handler   http.Handler
+	transport *http.Transport
+	local     []netip.Addr
+}
+
+// sharedAddresses is the RFC 6598 carrier-grade NAT range, which cloud
+// providers also use for internal services.
+var sharedAddresses = netip.MustParsePrefix("100.64.0.0/10")
+
+// destinationError reports a destination the proxy refuses to connect to.
+type destinationError struct {
+	addr string
+	ip   netip.Addr
+}
+
+func (e *destinationError) Error() string {
+	return fmt.Sprintf("destination %s (%s) is not allowed", e.addr, e.ip)
+}
+
+func New(policy *access.Engine) *Proxy {
+	return &Proxy{Policy: policy}
+}
+
+// dial resolves addr and connects to the first of its addresses that
+// answers, refusing the destination if any address is not permitted, so a
+// host name cannot smuggle in a local address next to a public one.
+func (p *Proxy) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	p.init()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if !p.permitted(ip.Unmap()) {
+			return nil, &destinationError{addr, ip.Unmap()}
+		}
+	}
+	dial := p.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: dialTimeout}).DialContext
+	}
+	for _, ip := range ips {
+		var conn net.Conn
+		if conn, err = dial(ctx, network, net.JoinHostPort(ip.Unmap().String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// permitted reports whether clients may connect to ip.
+func (p *Proxy) permitted(ip netip.Addr) bool {
+	for _, prefix := range p.Allow {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	switch {
+	case ip.IsLoopback(), ip.IsLinkLocalUnicast(), ip.IsUnspecified(), ip.IsMulticast(),
+		ip.IsLinkLocalMulticast(), ip.IsInterfaceLocalMulticast(), slices.Contains(p.local, ip):
+		return false
+	case ip.IsPrivate(), sharedAddresses.Contains(ip):
+		return p.AllowPrivate
+	}
+	return true
+}
+
+// localAddrs returns the addresses of this host's interfaces, on which its
+// own listeners accept connections.
+func localAddrs() []netip.Addr {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Printf("Forward proxy cannot list local addresses: %v", err)
+		return nil
+	}
+	var local []netip.Addr
+	for _, addr := range addrs {
+		if prefix, err := netip.ParsePrefix(addr.String()); err == nil {
+			local = append(local, prefix.Addr().Unmap())
+		}
+	}
+	return local
+}
+
+func (p *Proxy) init() {
+	p.once.Do(func() {
+		p.local = localAddrs()
+		p.transport = http.DefaultTransport.(*http.Transport).Clone()
+		p.transport.Proxy = nil
+		p.transport.DialContext = p.dial
+		var h http.Handler = http.HandlerFunc(p.serve)
+		h = p.authorize(h)
+		if p.Log != nil {
+			h = p.Log.Middleware(h)
+		}
+		for i := len(p.Middleware) - 1; i >= 0; i-- {
+			h = p.Middleware[i](h)
+		}
+		p.handler = h
+	})
+}
+
+// ServeHTTP serves CONNECT tunnels and absolute-URI requests.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.init()
+	p.handler.ServeHTTP(w, r)
+}
+
+// Serve accepts connections on l until it fails or is closed, telling
+// SOCKS5 clients from HTTP ones by their first byte.
+func (p *Proxy) Serve(l net.Listener) error {
+	conns := &connListener{addr: l.Addr(), conns: make(chan net.Conn), done: make(chan struct{})}
+	server := &http.Server{Handler: p, ReadHeaderTimeout: dialTimeout}
+	go server.Serve(conns)
+	defer server.Close()
+	defer conns.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			br := bufio.NewReader(conn)
+			conn.SetReadDeadline(time.Now().Add(dialTimeout))
+			first, err := br.Peek(1)
+			conn.SetReadDeadline(time.Time{})
+			if err != nil {
+				conn.Close()
+				return
+			}
+			buffered := &bufferedConn{Conn: conn, r: br}
+			if first[0] == socksVersion {
+				p.ServeSOCKS(buffered)
+				return
+			}
+			select {
+			case conns.conns <- buffered:
+			case <-conns.done:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// credentials returns the API key a request presents: the password of its
+// Proxy-Authorization, or that given by a SOCKS5 client.
+func credentials(r *http.Request) string {
+	if key, ok := r.Context().Value(socksKey{}).(string); ok {
+		return key
+	}
+	auth, ok := strings.CutPrefix(r.Header.Get("Proxy-Authorization"), "Basic ")
+	if !ok {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth)
+	if err != nil {
+		return ""
+	}
+	_, key, _ := strings.Cut(string(decoded), ":")
+	return key
+}
+
+// authorize refuses clients without a known API key with 407 and applies
+// the policy's rules.
+func (p *Proxy) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checked := r.Clone(r.Context())
+		checked.Header.Del("Authorization")
+		checked.Header.Set("X-API-Key", credentials(r))
+		if p.Policy == nil || p.Policy.Identify(checked).Identity == access.Anonymous {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+			http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+			return
+		}
+		d := p.Policy.Evaluate(checked, time.Now())
+		if d.Limited {
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		if !d.Allowed {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		r.Header.Del("Proxy-Authorization")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() || r.URL.Scheme != "http" {
+		http.Error(w, "forward proxy requests need an absolute http:// URL", http.StatusBadRequest)
+		return
+	}
+	(&httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = pr.In.URL
+			pr.Out.Host = ""
+		},
+		Transport: p.transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Forward proxy request to %s failed: %v", r.URL.Host, err)
+			var destErr *destinationError
+			if errors.As(err, &destErr) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}).ServeHTTP(w, r)
+}
+
+// tunnel connects the client to the destination of a CONNECT request.
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	target, err := p.dial(r.Context(), "tcp", r.Host)
+	var destErr *destinationError
+	if errors.As(err, &destErr) {
+		log.Printf("Forward proxy tunnel refused: %v", err)
+		http.Error(w, "destination not allowed", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("Forward proxy tunnel to %s failed: %v", r.Host, err)
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	defer target.Close()
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "tunnels are not supported here", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	if r.Context().Value(socksKey{}) != nil {
+		err = socksReply(conn, socksSucceeded, target.LocalAddr())
+	} else {
+		_, err = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	}
+	if err != nil {
+		return
+	}
+	// Bytes the client sent after its request, such as a TLS ClientHello.
+	if n := brw.Reader.Buffered(); n > 0 {
+		pending, _ := brw.Reader.Peek(n)
+		if _, err := target.Write(pending); err != nil {
+			return
+		}
+	}
+	splice(conn, target)
+}
+
+// splice copies between a and b until both directions are done.
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copyHalf := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	<-done
+	<-done
+}
+
+// bufferedConn replays the bytes peeked from a connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// connListener hands the HTTP connections accepted by Serve to an
+// http.Server.
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	once  sync.Once
+	done  chan struct{}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,235 @@
+package forward
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929).
+const (
+	socksVersion       = 5
+	socksAuthVersion   = 1
+	socksPassword      = 2
+	socksNoMethod      = 0xff
+	socksConnect       = 1
+	socksIPv4          = 1
+	socksDomain        = 3
+	socksIPv6          = 4
+	socksSucceeded     = 0
+	socksFailure       = 1
+	socksNotAllowed    = 2
+	socksUnreachable   = 4
+	socksNoCommand     = 7
+	socksNoAddressType = 8
+)
+
+// socksKey marks SOCKS5 tunnels in the request context, holding the API
+// key the client logged in with.
+type socksKey struct{}
+
+// ServeSOCKS serves one SOCKS5 connection. Clients log in with an API key
+// as their password and may only CONNECT. The tunnel is handled as a
+// CONNECT request, so the policy, middleware and log see it as one.
+func (p *Proxy) ServeSOCKS(conn net.Conn) {
+	defer conn.Close()
+	p.init()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	br := bufio.NewReader(conn)
+	key, err := socksLogin(br, conn)
+	if err != nil {
+		return
+	}
+	target, err := socksRequest(br, conn)
+	if err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), socksKey{}, key))
+	defer cancel()
+	r := (&http.Request{
+		Method:     http.MethodConnect,
+		URL:        &url.URL{Host: target},
+		Proto:      "SOCKS/5",
+		Header:     make(http.Header),
+		Host:       target,
+		RemoteAddr: conn.RemoteAddr().String(),
+		RequestURI: target,
+	}).WithContext(ctx)
+	w := &socksWriter{conn: conn, r: br, header: make(http.Header)}
+	p.handler.ServeHTTP(w, r)
+	if !w.wrote {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// socksLogin negotiates username/password authentication and returns the
+// password.
+func socksLogin(br *bufio.Reader, conn net.Conn) (string, error) {
+	var greeting [2]byte
+	if _, err := io.ReadFull(br, greeting[:]); err != nil {
+		return "", err
+	}
+	if greeting[0] != socksVersion {
+		return "", fmt.Errorf("socks: unsupported version %d", greeting[0])
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return "", err
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == socksPassword
+	}
+	if !offered {
+		conn.Write([]byte{socksVersion, socksNoMethod})
+		return "", errors.New("socks: client does not offer password authentication")
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksPassword}); err != nil {
+		return "", err
+	}
+
+	version, err := br.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if version != socksAuthVersion {
+		return "", fmt.Errorf("socks: unsupported authentication version %d", version)
+	}
+	if _, err := readSOCKSString(br); err != nil {
+		return "", err
+	}
+	password, err := readSOCKSString(br)
+	if err != nil {
+		return "", err
+	}
+	// The key is checked with the policy's rules; a client with a wrong one
+	// is refused in the reply to its request.
+	if _, err := conn.Write([]byte{socksAuthVersion, 0}); err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+func readSOCKSString(br *bufio.Reader) (string, error) {
+	n, err := br.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// socksRequest reads a CONNECT request and returns its host:port.
+func socksRequest(br *bufio.Reader, conn net.Conn) (string, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return "", err
+	}
+	if head[0] != socksVersion {
+		return "", fmt.Errorf("socks: unsupported version %d", head[0])
+	}
+	var host string
+	switch head[3] {
+	case socksIPv4, socksIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if head[3] == socksIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(br, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksDomain:
+		name, err := readSOCKSString(br)
+		if err != nil {
+			return "", err
+		}
+		host = name
+	default:
+		socksReply(conn, socksNoAddressType, nil)
+		return "", fmt.Errorf("socks: unsupported address type %d", head[3])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(br, port[:]); err != nil {
+		return "", err
+	}
+	if head[1] != socksConnect {
+		socksReply(conn, socksNoCommand, nil)
+		return "", fmt.Errorf("socks: unsupported command %d", head[1])
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// socksReply answers a request, with the address the proxy connected from
+// if it succeeded.
+func socksReply(conn net.Conn, code byte, bound net.Addr) error {
+	reply := []byte{socksVersion, code, 0, socksIPv4, 0, 0, 0, 0, 0, 0}
+	if tcp, ok := bound.(*net.TCPAddr); ok {
+		if ip4 := tcp.IP.To4(); ip4 != nil {
+			copy(reply[4:8], ip4)
+		} else {
+			reply = append([]byte{socksVersion, code, 0, socksIPv6}, tcp.IP.To16()...)
+			reply = append(reply, 0, 0)
+		}
+		binary.BigEndian.PutUint16(reply[len(reply)-2:], uint16(tcp.Port))
+	}
+	_, err := conn.Write(reply)
+	return err
+}
+
+// socksWriter turns the status of a refused tunnel into a SOCKS5 reply and
+// hands the connection over to an accepted one.
+type socksWriter struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	header http.Header
+	wrote  bool
+}
+
+func (w *socksWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *socksWriter) WriteHeader(code int) {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	reply := byte(socksFailure)
+	switch code {
+	case http.StatusProxyAuthRequired, http.StatusForbidden, http.StatusTooManyRequests:
+		reply = socksNotAllowed
+	case http.StatusBadGateway:
+		reply = socksUnreachable
+	}
+	socksReply(w.conn, reply, nil)
+}
+
+// Write discards the body of a refusal, which SOCKS5 has no room for.
+func (w *socksWriter) Write(p []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return len(p), nil
+}
+
+func (w *socksWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.wrote {
+		return nil, nil, errors.New("socks: reply already sent")
+	}
+	w.wrote = true
+	return w.conn, bufio.NewReadWriter(w.r, bufio.NewWriter(w.conn)), nil
+}
@@ -2,9 +2,15 @@ module github.com/kirtansoni/reverse-proxy-go
 
 go 1.23.5
 
-require golang.org/x/crypto v0.34.0
+require (
+	github.com/klauspost/compress v1.17.11
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/crypto v0.34.0
+	golang.org/x/net v0.21.0
+	google.golang.org/protobuf v1.36.5
+)
 
 require (
-	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
 	golang.org/x/text v0.22.0 // indirect
 )
@@ -0,0 +1,236 @@
+package graphql
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	maxBody      = 1 << 20
+	maxPersisted = 10000
+)
+
+// Config describes the GraphQL mode of a service.
+type Config struct {
+	MaxDepth      int `json:"max_depth,omitempty"`
+	MaxComplexity int `json:"max_complexity,omitempty"`
+	// PersistedOnly rejects queries that are not in the manifest.
+	PersistedOnly bool `json:"persisted_only,omitempty"`
+	// Manifest is a JSON file mapping sha256 hashes to query documents.
+	Manifest string `json:"manifest,omitempty"`
+}
+
+// Guard enforces depth and complexity limits on GraphQL requests and
+// resolves persisted query hashes (Apollo automatic persisted queries) to
+// full documents before they reach the backend.
+type Guard struct {
+	cfg Config
+
+	mu        sync.RWMutex
+	persisted map[string]string
+	manifest  map[string]bool
+}
+
+// request is a single GraphQL request; unknown members are preserved.
+type request struct {
+	Query         string `json:"query,omitempty"`
+	OperationName string `json:"operationName,omitempty"`
+	Extensions    struct {
+		PersistedQuery struct {
+			Sha256Hash string `json:"sha256Hash"`
+		} `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+func NewGuard(cfg Config) (*Guard, error) {
+	g := &Guard{
+		cfg:       cfg,
+		persisted: make(map[string]string),
+		manifest:  make(map[string]bool),
+	}
+	if cfg.Manifest == "" {
+		return g, nil
+	}
+
+	data, err := os.ReadFile(cfg.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read persisted query manifest: %v", err)
+	}
+	var queries map[string]string
+	if err := json.Unmarshal(data, &queries); err != nil {
+		return nil, fmt.Errorf("failed to parse persisted query manifest: %v", err)
+	}
+	for hash, query := range queries {
+		if hashQuery(query) != strings.ToLower(hash) {
+			return nil, fmt.Errorf("manifest hash %s does not match its query", hash)
+		}
+		g.persisted[strings.ToLower(hash)] = query
+		g.manifest[strings.ToLower(hash)] = true
+	}
+	return g, nil
+}
+
+func (g *Guard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			g.serveGET(w, r, next)
+		case http.MethodPost:
+			g.servePOST(w, r, next)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (g *Guard) serveGET(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	q := r.URL.Query()
+	var req request
+	req.Query = q.Get("query")
+	req.OperationName = q.Get("operationName")
+	if ext := q.Get("extensions"); ext != "" {
+		json.Unmarshal([]byte(ext), &req.Extensions)
+	}
+	if req.Query == "" && req.Extensions.PersistedQuery.Sha256Hash == "" {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	query, status, err := g.check(&req)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	q.Set("query", query)
+	r.URL.RawQuery = q.Encode()
+	next.ServeHTTP(w, r)
+}
+
+func (g *Guard) servePOST(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(body) > maxBody {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body too large"))
+		return
+	}
+
+	// Batched requests are a JSON array of single requests.
+	batch := bytes.HasPrefix(bytes.TrimSpace(body), []byte("["))
+	var raws []map[string]json.RawMessage
+	if batch {
+		err = json.Unmarshal(body, &raws)
+	} else {
+		raws = make([]map[string]json.RawMessage, 1)
+		err = json.Unmarshal(body, &raws[0])
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %v", err))
+		return
+	}
+
+	for _, raw := range raws {
+		var req request
+		encoded, _ := json.Marshal(raw)
+		json.Unmarshal(encoded, &req)
+
+		query, status, err := g.check(&req)
+		if err != nil {
+			writeError(w, status, err)
+			return
+		}
+		raw["query"], _ = json.Marshal(query)
+	}
+
+	if batch {
+		body, _ = json.Marshal(raws)
+	} else {
+		body, _ = json.Marshal(raws[0])
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Del("Content-Length")
+	next.ServeHTTP(w, r)
+}
+
+// check resolves the request's query document and validates it, returning
+// the document to forward.
+func (g *Guard) check(req *request) (string, int, error) {
+	query, err := g.resolve(req)
+	if err != nil {
+		return "", http.StatusOK, err
+	}
+
+	doc, err := parse(query)
+	if err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("invalid query: %v", err)
+	}
+	depth, complexity, err := doc.measure(req.OperationName)
+	if err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("invalid query: %v", err)
+	}
+	if g.cfg.MaxDepth > 0 && depth > g.cfg.MaxDepth {
+		return "", http.StatusBadRequest, fmt.Errorf("query depth %d exceeds limit %d", depth, g.cfg.MaxDepth)
+	}
+	if g.cfg.MaxComplexity > 0 && complexity > g.cfg.MaxComplexity {
+		return "", http.StatusBadRequest, fmt.Errorf("query complexity %d exceeds limit %d", complexity, g.cfg.MaxComplexity)
+	}
+	return query, http.StatusOK, nil
+}
+
+func (g *Guard) resolve(req *request) (string, error) {
+	hash := strings.ToLower(req.Extensions.PersistedQuery.Sha256Hash)
+	if hash == "" {
+		if g.cfg.PersistedOnly {
+			return "", fmt.Errorf("PersistedQueryNotSupported")
+		}
+		return req.Query, nil
+	}
+
+	if req.Query == "" {
+		g.mu.RLock()
+		query, ok := g.persisted[hash]
+		g.mu.RUnlock()
+		if !ok {
+			return "", fmt.Errorf("PersistedQueryNotFound")
+		}
+		return query, nil
+	}
+
+	if hashQuery(req.Query) != hash {
+		return "", fmt.Errorf("provided sha does not match query")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cfg.PersistedOnly && !g.manifest[hash] {
+		return "", fmt.Errorf("PersistedQueryNotFound")
+	}
+	if _, ok := g.persisted[hash]; !ok && len(g.persisted) < maxPersisted {
+		g.persisted[hash] = req.Query
+	}
+	return req.Query, nil
+}
+
+func hashQuery(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeError responds in the GraphQL error format.
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"message": err.Error()}},
+	})
+}
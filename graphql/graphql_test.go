@@ -0,0 +1,146 @@
+package graphql
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMeasure(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		depth      int
+		complexity int
+	}{
+		{"Shorthand", `{ me { name } }`, 2, 2},
+		{"Arguments with objects", `query Q($f: Filter = {a: {b: 1}}) { users(filter: {name: "{"}) { id posts { title } } }`, 3, 4},
+		{"Fragments", `query { me { ...F } } fragment F on User { friends { name } }`, 3, 3},
+		{"Inline fragments", `{ node(id: 1) { ... on User { name } } }`, 2, 2},
+		{"Comments and block strings", "{ a # {{{\n b(s: \"\"\"}}\"\"\") }", 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parse(tt.query)
+			if err != nil {
+				t.Fatalf("Unexpected parse error: %v", err)
+			}
+			depth, complexity, err := doc.measure("")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if depth != tt.depth || complexity != tt.complexity {
+				t.Errorf("Expected depth %d complexity %d, got %d %d", tt.depth, tt.complexity, depth, complexity)
+			}
+		})
+	}
+}
+
+func TestFragmentAbuse(t *testing.T) {
+	cycle, _ := parse(`{ ...A } fragment A on Q { ...B } fragment B on Q { ...A }`)
+	if _, _, err := cycle.measure(""); err == nil {
+		t.Error("Expected error for fragment cycle")
+	}
+
+	// Each level doubles the fan-out; memoization keeps this cheap and the
+	// count capped.
+	bomb := `{ ...L0 } `
+	for i := 0; i < 60; i++ {
+		next := strconv.Itoa(i + 1)
+		bomb += "fragment L" + strconv.Itoa(i) + " on Q { a: f { ...L" + next + " } b: f { ...L" + next + " } } "
+	}
+	bomb += "fragment L60 on Q { x }"
+	doc, err := parse(bomb)
+	if err != nil {
+		t.Fatalf("Unexpected parse error: %v", err)
+	}
+	_, complexity, err := doc.measure("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if complexity != maxComplexity {
+		t.Errorf("Expected complexity to be capped, got %d", complexity)
+	}
+}
+
+func echoQuery() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(r.URL.Query().Get("query")))
+			return
+		}
+		var req map[string]any
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Write([]byte(req["query"].(string)))
+	})
+}
+
+func TestGuardLimits(t *testing.T) {
+	g, _ := NewGuard(Config{MaxDepth: 2, MaxComplexity: 3})
+	handler := g.Middleware(echoQuery())
+
+	for query, status := range map[string]int{
+		`{ me { name } }`:             http.StatusOK,
+		`{ me { friends { name } } }`: http.StatusBadRequest,
+		`{ a b c d }`:                 http.StatusBadRequest,
+		`{ me { name `:                http.StatusBadRequest,
+	} {
+		body, _ := json.Marshal(map[string]string{"query": query})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/graphql", strings.NewReader(string(body))))
+		if w.Code != status {
+			t.Errorf("%s: expected %d, got %d: %s", query, status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPersistedQueries(t *testing.T) {
+	g, _ := NewGuard(Config{})
+	handler := g.Middleware(echoQuery())
+
+	query := `{ me { name } }`
+	ext := `{"persistedQuery":{"version":1,"sha256Hash":"` + hashQuery(query) + `"}}`
+
+	get := func(params url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/graphql?"+params.Encode(), nil))
+		return w
+	}
+
+	// Unknown hash asks the client to register the query.
+	w := get(url.Values{"extensions": {ext}})
+	if !strings.Contains(w.Body.String(), "PersistedQueryNotFound") {
+		t.Errorf("Expected PersistedQueryNotFound, got %s", w.Body.String())
+	}
+
+	// Registration followed by a hash-only request forwards the full query.
+	get(url.Values{"extensions": {ext}, "query": {query}})
+	w = get(url.Values{"extensions": {ext}})
+	body, _ := io.ReadAll(w.Body)
+	if string(body) != query {
+		t.Errorf("Expected resolved query, got %s", body)
+	}
+
+	// Mismatched hashes are rejected.
+	w = get(url.Values{"extensions": {ext}, "query": {`{ other }`}})
+	if !strings.Contains(w.Body.String(), "does not match") {
+		t.Errorf("Expected hash mismatch error, got %s", w.Body.String())
+	}
+}
+
+func TestPersistedOnly(t *testing.T) {
+	g, _ := NewGuard(Config{PersistedOnly: true})
+	handler := g.Middleware(echoQuery())
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ me }"}`)))
+	if !strings.Contains(w.Body.String(), "PersistedQueryNotSupported") {
+		t.Errorf("Expected ad-hoc query to be rejected, got %s", w.Body.String())
+	}
+}
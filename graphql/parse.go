@@ -0,0 +1,368 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The parser understands just enough GraphQL to measure queries: operations,
+// fragments and selection sets. Arguments, variables and directives are
+// skipped over.
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokName
+	tokPunct
+	tokValue // strings and numbers
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "..."):
+			tokens = append(tokens, token{tokPunct, "..."})
+			i += 3
+		case strings.ContainsRune("{}()[]:=@$!|&", rune(c)):
+			tokens = append(tokens, token{tokPunct, string(c)})
+			i++
+		case strings.HasPrefix(src[i:], `"""`):
+			j := i + 3
+			for {
+				end := strings.Index(src[j:], `"""`)
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated block string")
+				}
+				j += end + 3
+				if src[j-4] != '\\' {
+					break
+				}
+			}
+			tokens = append(tokens, token{tokValue, ""})
+			i = j
+		case c == '"':
+			j := i + 1
+			for ; j < len(src) && src[j] != '"'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+				if j < len(src) && src[j] == '\n' {
+					return nil, fmt.Errorf("unterminated string")
+				}
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, token{tokValue, ""})
+			i = j + 1
+		case c == '-' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(src) && strings.IndexByte("0123456789.eE+-", src[j]) >= 0 {
+				j++
+			}
+			tokens = append(tokens, token{tokValue, src[i:j]})
+			i = j
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || (src[j] >= 'a' && src[j] <= 'z') ||
+				(src[j] >= 'A' && src[j] <= 'Z') || (src[j] >= '0' && src[j] <= '9')) {
+				j++
+			}
+			tokens = append(tokens, token{tokName, src[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+// selection is a field, fragment spread or inline fragment.
+type selection struct {
+	spread   string // fragment name for spreads
+	inline   bool
+	children []*selection
+}
+
+type document struct {
+	operations []*operation
+	fragments  map[string][]*selection
+}
+
+type operation struct {
+	name       string
+	selections []*selection
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func parse(src string) (*document, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	doc := &document{fragments: make(map[string][]*selection)}
+
+	for p.peek().kind != tokEOF {
+		t := p.peek()
+		switch {
+		case t.text == "{":
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{selections: sels})
+		case t.kind == tokName && t.text == "fragment":
+			p.next()
+			name := p.next()
+			if on := p.next(); on.text != "on" {
+				return nil, fmt.Errorf("expected 'on' in fragment %s", name.text)
+			}
+			p.next()
+			if err := p.directives(); err != nil {
+				return nil, err
+			}
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.fragments[name.text] = sels
+		case t.kind == tokName && (t.text == "query" || t.text == "mutation" || t.text == "subscription"):
+			p.next()
+			op := &operation{}
+			if p.peek().kind == tokName {
+				op.name = p.next().text
+			}
+			if p.peek().text == "(" {
+				if err := p.skipBalanced("(", ")"); err != nil {
+					return nil, err
+				}
+			}
+			if err := p.directives(); err != nil {
+				return nil, err
+			}
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			op.selections = sels
+			doc.operations = append(doc.operations, op)
+		default:
+			return nil, fmt.Errorf("unexpected %q at top level", t.text)
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("document has no operations")
+	}
+	return doc, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) selectionSet() ([]*selection, error) {
+	if t := p.next(); t.text != "{" {
+		return nil, fmt.Errorf("expected '{', got %q", t.text)
+	}
+	var sels []*selection
+	for p.peek().text != "}" {
+		if p.peek().kind == tokEOF {
+			return nil, fmt.Errorf("unterminated selection set")
+		}
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sels = append(sels, sel)
+	}
+	p.next()
+	if len(sels) == 0 {
+		return nil, fmt.Errorf("empty selection set")
+	}
+	return sels, nil
+}
+
+func (p *parser) selection() (*selection, error) {
+	if p.peek().text == "..." {
+		p.next()
+		if t := p.peek(); t.kind == tokName && t.text != "on" {
+			p.next()
+			return &selection{spread: t.text}, p.directives()
+		}
+		if p.peek().text == "on" {
+			p.next()
+			p.next()
+		}
+		if err := p.directives(); err != nil {
+			return nil, err
+		}
+		children, err := p.selectionSet()
+		return &selection{inline: true, children: children}, err
+	}
+
+	name := p.next()
+	if name.kind != tokName {
+		return nil, fmt.Errorf("expected field name, got %q", name.text)
+	}
+	if p.peek().text == ":" {
+		p.next()
+		if alias := p.next(); alias.kind != tokName {
+			return nil, fmt.Errorf("expected field name after alias")
+		}
+	}
+	if p.peek().text == "(" {
+		if err := p.skipBalanced("(", ")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.directives(); err != nil {
+		return nil, err
+	}
+
+	sel := &selection{}
+	if p.peek().text == "{" {
+		children, err := p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+		sel.children = children
+	}
+	return sel, nil
+}
+
+func (p *parser) directives() error {
+	for p.peek().text == "@" {
+		p.next()
+		p.next()
+		if p.peek().text == "(" {
+			if err := p.skipBalanced("(", ")"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *parser) skipBalanced(open, close string) error {
+	depth := 0
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tokEOF:
+			return fmt.Errorf("unbalanced %s", open)
+		case t.text == open:
+			depth++
+		case t.text == close:
+			depth--
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+}
+
+// measure returns the maximum selection depth and the number of fields of
+// the named operation (or all operations when name is empty), with fragment
+// spreads expanded.
+func (d *document) measure(name string) (depth, complexity int, err error) {
+	m := &measurer{doc: d, memo: make(map[string][2]int), visiting: make(map[string]bool)}
+	for _, op := range d.operations {
+		if name != "" && op.name != name {
+			continue
+		}
+		dep, comp, err := m.walk(op.selections)
+		if err != nil {
+			return 0, 0, err
+		}
+		depth = max(depth, dep)
+		complexity = min(complexity+comp, maxComplexity)
+	}
+	return depth, complexity, nil
+}
+
+// maxComplexity caps counts so hostile fragment fan-out cannot overflow.
+const maxComplexity = 1 << 30
+
+type measurer struct {
+	doc *document
+	// memo holds the depth and complexity of each fragment, which do not
+	// depend on where it is spread.
+	memo     map[string][2]int
+	visiting map[string]bool
+}
+
+// walk returns the depth and field count of a selection set, counting its
+// own fields as depth 1.
+func (m *measurer) walk(sels []*selection) (int, int, error) {
+	depth, complexity := 0, 0
+	for _, sel := range sels {
+		var dep, comp int
+		var err error
+		switch {
+		case sel.spread != "":
+			dep, comp, err = m.fragment(sel.spread)
+		case sel.inline:
+			dep, comp, err = m.walk(sel.children)
+		default:
+			dep, comp = 1, 1
+			if len(sel.children) > 0 {
+				dep, comp, err = m.walk(sel.children)
+				dep, comp = dep+1, comp+1
+			}
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		depth = max(depth, dep)
+		complexity = min(complexity+comp, maxComplexity)
+	}
+	return depth, complexity, nil
+}
+
+func (m *measurer) fragment(name string) (int, int, error) {
+	if r, ok := m.memo[name]; ok {
+		return r[0], r[1], nil
+	}
+	frag, ok := m.doc.fragments[name]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown fragment %s", name)
+	}
+	if m.visiting[name] {
+		return 0, 0, fmt.Errorf("fragment cycle through %s", name)
+	}
+	m.visiting[name] = true
+	dep, comp, err := m.walk(frag)
+	delete(m.visiting, name)
+	if err != nil {
+		return 0, 0, err
+	}
+	m.memo[name] = [2]int{dep, comp}
+	return dep, comp, nil
+}
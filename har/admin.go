@@ -0,0 +1,110 @@
+package har
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// AdminHandler serves recording management:
+//
+//	GET    /            recordings, newest first
+//	POST   /            {"host": "...", "path_prefix": "/api", "method": "GET", "duration": "5m", "max_entries": 1000, "max_body": 65536}
+//	POST   /{id}/stop   stop a recording and write its file
+//	GET    /{id}        download a stopped recording's HAR file
+//	DELETE /{id}        stop a recording and delete it and its file
+func (rec *Recorder) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, rec.Recordings())
+	})
+	mux.HandleFunc("POST /{$}", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Options
+			Duration string `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil || d <= 0 {
+				admin.WriteError(w, http.StatusBadRequest, errors.New("invalid duration"))
+				return
+			}
+			req.Options.Duration = d
+		}
+		recording, err := rec.Start(req.Options)
+		if errors.Is(err, ErrNoDir) {
+			admin.WriteError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusCreated, recording)
+	})
+	mux.HandleFunc("POST /{id}/stop", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := recordingID(w, r)
+		if !ok {
+			return
+		}
+		recording, err := rec.Stop(id)
+		if err != nil {
+			admin.WriteError(w, errorStatus(err), err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, recording)
+	})
+	mux.HandleFunc("GET /{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := recordingID(w, r)
+		if !ok {
+			return
+		}
+		recording, err := rec.Get(id)
+		switch {
+		case err != nil:
+			admin.WriteError(w, errorStatus(err), err)
+		case recording.Active:
+			admin.WriteError(w, http.StatusConflict, errors.New("recording is still active"))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Disposition", "attachment; filename=\"recording-"+strconv.FormatUint(id, 10)+".har\"")
+			http.ServeFile(w, r, recording.File)
+		}
+	})
+	mux.HandleFunc("DELETE /{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := recordingID(w, r)
+		if !ok {
+			return
+		}
+		if err := rec.Delete(id); err != nil {
+			admin.WriteError(w, errorStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func recordingID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		admin.WriteError(w, http.StatusBadRequest, errors.New("invalid recording ID"))
+		return 0, false
+	}
+	return id, true
+}
+
+func errorStatus(err error) int {
+	if errors.Is(err, errNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
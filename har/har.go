@@ -0,0 +1,513 @@
+// Package har records matching traffic as HTTP Archive (HAR 1.2) files, to
+// share reproducible sessions with backend developers. Credentials in
+// headers are redacted.
+package har
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// Defaults and limits of recordings.
+const (
+	DefaultDuration   = 5 * time.Minute
+	MaxDuration       = time.Hour
+	DefaultMaxEntries = 1000
+	DefaultMaxBody    = 64 << 10
+)
+
+var errNotFound = errors.New("recording not found")
+
+// ErrNoDir is returned by Start when the Recorder has no directory to write
+// recordings to.
+var ErrNoDir = errors.New("no recording directory configured")
+
+// redacted are headers whose values are never recorded.
+var redacted = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+}
+
+// Filter selects the requests recorded; empty fields match everything.
+type Filter struct {
+	Host       string `json:"host,omitempty"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+	Method     string `json:"method,omitempty"`
+}
+
+func (f Filter) matches(r *http.Request) bool {
+	return (f.Host == "" || strings.EqualFold(hostOnly(r.Host), f.Host)) &&
+		strings.HasPrefix(r.URL.Path, f.PathPrefix) &&
+		(f.Method == "" || strings.EqualFold(r.Method, f.Method))
+}
+
+func hostOnly(host string) string {
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.Contains(host[i:], "]") {
+		return host[:i]
+	}
+	return host
+}
+
+// Options bound a recording; it stops at whichever limit comes first.
+type Options struct {
+	Filter
+	Duration   time.Duration `json:"-"`
+	MaxEntries int           `json:"max_entries"`
+	// MaxBody caps the bytes kept of each request and response body.
+	MaxBody int `json:"max_body"`
+}
+
+// Recording describes a recording. File is set once it has been written.
+type Recording struct {
+	ID      uint64    `json:"id"`
+	Filter  Filter    `json:"filter"`
+	Started time.Time `json:"started"`
+	Until   time.Time `json:"until"`
+	Entries int       `json:"entries"`
+	Active  bool      `json:"active"`
+	File    string    `json:"file,omitempty"`
+}
+
+type recording struct {
+	Recording
+	opts    Options
+	entries []Entry
+	timer   *time.Timer
+}
+
+// Recorder runs recordings and writes each to a file in its directory when
+// it stops. Without a directory, recordings cannot be started.
+type Recorder struct {
+	dir string
+
+	mu         sync.Mutex
+	nextID     uint64
+	recordings map[uint64]*recording
+	// active counts running recordings so idle traffic skips the lock.
+	active atomic.Int32
+}
+
+func NewRecorder(dir string) *Recorder {
+	return &Recorder{dir: dir, recordings: make(map[uint64]*recording)}
+}
+
+// Start begins a recording, filling in defaults for zero options.
+func (rec *Recorder) Start(opts Options) (Recording, error) {
+	if rec.dir == "" {
+		return Recording{}, ErrNoDir
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = DefaultDuration
+	}
+	if opts.Duration > MaxDuration {
+		return Recording{}, fmt.Errorf("duration must be at most %s", MaxDuration)
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultMaxEntries
+	}
+	if opts.MaxBody <= 0 {
+		opts.MaxBody = DefaultMaxBody
+	}
+	if err := os.MkdirAll(rec.dir, 0700); err != nil {
+		return Recording{}, fmt.Errorf("failed to create HAR directory: %v", err)
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.nextID++
+	now := time.Now()
+	r := &recording{
+		Recording: Recording{ID: rec.nextID, Filter: opts.Filter, Started: now, Until: now.Add(opts.Duration), Active: true},
+		opts:      opts,
+	}
+	id := r.ID
+	r.timer = time.AfterFunc(opts.Duration, func() {
+		if _, err := rec.Stop(id); err != nil {
+			log.Printf("HAR recording %d: %v", id, err)
+		}
+	})
+	rec.recordings[id] = r
+	rec.active.Add(1)
+	return r.Recording, nil
+}
+
+// Stop ends a recording and writes its file. Stopping a stopped recording
+// returns it unchanged.
+func (rec *Recorder) Stop(id uint64) (Recording, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	r, ok := rec.recordings[id]
+	if !ok {
+		return Recording{}, errNotFound
+	}
+	if !r.Active {
+		return r.Recording, nil
+	}
+	return r.Recording, rec.finish(r)
+}
+
+// finish stops r and writes it. Callers must hold rec.mu.
+func (rec *Recorder) finish(r *recording) error {
+	r.Active = false
+	r.timer.Stop()
+	rec.active.Add(-1)
+
+	data, err := json.MarshalIndent(newLog(r.entries), "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(rec.dir, fmt.Sprintf("recording-%d-%s.har", r.ID, r.Started.UTC().Format("20060102T150405Z")))
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		return fmt.Errorf("failed to write HAR file: %v", err)
+	}
+	r.File = file
+	// The file has it all now.
+	r.entries = nil
+	return nil
+}
+
+// Get returns a recording.
+func (rec *Recorder) Get(id uint64) (Recording, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	r, ok := rec.recordings[id]
+	if !ok {
+		return Recording{}, errNotFound
+	}
+	return r.Recording, nil
+}
+
+// Recordings lists the recordings, newest first.
+func (rec *Recorder) Recordings() []Recording {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	list := make([]Recording, 0, len(rec.recordings))
+	for _, r := range rec.recordings {
+		list = append(list, r.Recording)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list
+}
+
+// Delete stops a recording if needed and removes it and its file.
+func (rec *Recorder) Delete(id uint64) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	r, ok := rec.recordings[id]
+	if !ok {
+		return errNotFound
+	}
+	if r.Active {
+		r.Active = false
+		r.timer.Stop()
+		rec.active.Add(-1)
+	}
+	delete(rec.recordings, id)
+	if r.File != "" {
+		os.Remove(r.File)
+	}
+	return nil
+}
+
+// add appends an entry to every active recording matching req, finishing
+// those that are full.
+func (rec *Recorder) add(req *http.Request, build func(maxBody int) Entry) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, r := range rec.recordings {
+		if !r.Active || !r.Filter.matches(req) {
+			continue
+		}
+		r.entries = append(r.entries, build(r.opts.MaxBody))
+		r.Entries++
+		if r.Entries >= r.opts.MaxEntries {
+			if err := rec.finish(r); err != nil {
+				log.Printf("HAR recording %d: %v", r.ID, err)
+			}
+		}
+	}
+}
+
+// Middleware records the requests and responses matching an active
+// recording.
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rec.active.Load() == 0 || !rec.matchesAny(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		reqBody := &capture{limit: int64(rec.maxBody())}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, reqBody), r.Body}
+		}
+		cw := &captureWriter{ResponseWriter: w, body: capture{limit: reqBody.limit}}
+		next.ServeHTTP(cw, r)
+
+		rec.add(r, func(maxBody int) Entry {
+			return newEntry(r, reqBody, cw, start, maxBody)
+		})
+	})
+}
+
+func (rec *Recorder) matchesAny(r *http.Request) bool {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for _, rc := range rec.recordings {
+		if rc.Active && rc.Filter.matches(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxBody is the largest body limit of the active recordings.
+func (rec *Recorder) maxBody() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	n := 0
+	for _, r := range rec.recordings {
+		if r.Active && r.opts.MaxBody > n {
+			n = r.opts.MaxBody
+		}
+	}
+	return n
+}
+
+// capture keeps the first limit bytes written to it.
+type capture struct {
+	buf   bytes.Buffer
+	limit int64
+	size  int64
+}
+
+func (c *capture) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	if room := c.limit - int64(c.buf.Len()); room > 0 {
+		if int64(len(p)) > room {
+			c.buf.Write(p[:room])
+		} else {
+			c.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   capture
+}
+
+func (w *captureWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.body.Write(p[:n])
+	return n, err
+}
+
+func (w *captureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Log is the root of a HAR file.
+type Log struct {
+	Log struct {
+		Version string  `json:"version"`
+		Creator Creator `json:"creator"`
+		Entries []Entry `json:"entries"`
+	} `json:"log"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Entry struct {
+	StartedDateTime time.Time      `json:"startedDateTime"`
+	Time            float64        `json:"time"`
+	Request         Request        `json:"request"`
+	Response        Response       `json:"response"`
+	Cache           struct{}       `json:"cache"`
+	Timings         map[string]int `json:"timings"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+func newLog(entries []Entry) *Log {
+	l := &Log{}
+	l.Log.Version = "1.2"
+	l.Log.Creator = Creator{Name: "reverse-proxy-go", Version: "1"}
+	l.Log.Entries = entries
+	if entries == nil {
+		l.Log.Entries = []Entry{}
+	}
+	return l
+}
+
+func headers(h http.Header) []NameValue {
+	list := []NameValue{}
+	for name, values := range h {
+		for _, v := range values {
+			if redacted[name] {
+				v = "REDACTED"
+			}
+			list = append(list, NameValue{name, v})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func newEntry(r *http.Request, reqBody *capture, w *captureWriter, start time.Time, maxBody int) Entry {
+	elapsed := float64(time.Since(start).Microseconds()) / 1000
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	query := []NameValue{}
+	for name, values := range r.URL.Query() {
+		for _, v := range values {
+			query = append(query, NameValue{name, v})
+		}
+	}
+	sort.Slice(query, func(i, j int) bool { return query[i].Name < query[j].Name })
+
+	reqHeaders := headers(r.Header)
+	reqHeaders = append(reqHeaders, NameValue{"Host", r.Host})
+	e := Entry{
+		StartedDateTime: start,
+		Time:            elapsed,
+		Request: Request{
+			Method:      r.Method,
+			URL:         scheme + "://" + r.Host + r.URL.RequestURI(),
+			HTTPVersion: r.Proto,
+			Cookies:     []NameValue{},
+			Headers:     reqHeaders,
+			QueryString: query,
+			HeadersSize: -1,
+			BodySize:    reqBody.size,
+		},
+		Timings: map[string]int{"send": 0, "wait": int(elapsed), "receive": 0},
+	}
+	if reqBody.size > 0 {
+		text, _, _ := bodyText(reqBody, maxBody)
+		e.Request.PostData = &PostData{MimeType: r.Header.Get("Content-Type"), Text: text}
+	}
+
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	text, encoding, comment := bodyText(&w.body, maxBody)
+	e.Response = Response{
+		Status:      status,
+		StatusText:  http.StatusText(status),
+		HTTPVersion: r.Proto,
+		Cookies:     []NameValue{},
+		Headers:     headers(w.Header()),
+		Content: Content{
+			Size:     w.body.size,
+			MimeType: w.Header().Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+			Comment:  comment,
+		},
+		RedirectURL: w.Header().Get("Location"),
+		HeadersSize: -1,
+		BodySize:    w.body.size,
+	}
+	return e
+}
+
+// bodyText returns up to maxBody bytes of a body as HAR text, base64
+// encoded unless it is UTF-8, with a comment if it was cut.
+func bodyText(c *capture, maxBody int) (text, encoding, comment string) {
+	b := c.buf.Bytes()
+	if len(b) > maxBody {
+		b = b[:maxBody]
+	}
+	if int64(len(b)) < c.size {
+		comment = fmt.Sprintf("truncated to %d of %d bytes", len(b), c.size)
+	}
+	if utf8.Valid(b) {
+		return string(b), "", comment
+	}
+	return base64.StdEncoding.EncodeToString(b), "base64", comment
+}
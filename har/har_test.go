@@ -0,0 +1,149 @@
+package har
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordingFiltersAndRedacts(t *testing.T) {
+	rec := NewRecorder(t.TempDir())
+	handler := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(append([]byte("echo:"), body...))
+	}))
+
+	started, err := rec.Start(Options{Filter: Filter{PathPrefix: "/api"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/api/items?x=1", strings.NewReader("hello"))
+	req.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/other", nil))
+
+	stopped, err := rec.Stop(started.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stopped.Active || stopped.Entries != 1 {
+		t.Fatalf("recording = %+v, want 1 entry and stopped", stopped)
+	}
+	data, err := os.ReadFile(stopped.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Error("HAR file contains a credential")
+	}
+	var log Log
+	if err := json.Unmarshal(data, &log); err != nil {
+		t.Fatal(err)
+	}
+	e := log.Log.Entries[0]
+	if e.Request.URL != "http://example.com/api/items?x=1" || e.Request.PostData.Text != "hello" {
+		t.Errorf("request = %+v", e.Request)
+	}
+	if e.Response.Status != http.StatusCreated || e.Response.Content.Text != "echo:hello" {
+		t.Errorf("response = %+v", e.Response)
+	}
+}
+
+func TestRecordingLimits(t *testing.T) {
+	rec := NewRecorder(t.TempDir())
+	handler := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0xff, 0xfe, 0xfd, 0xfc})
+	}))
+
+	started, err := rec.Start(Options{MaxEntries: 2, MaxBody: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	got, _ := rec.Get(started.ID)
+	if got.Active || got.Entries != 2 {
+		t.Fatalf("recording = %+v, want stopped after 2 entries", got)
+	}
+	data, _ := os.ReadFile(got.File)
+	var log Log
+	json.Unmarshal(data, &log)
+	content := log.Log.Entries[0].Response.Content
+	if content.Encoding != "base64" || content.Text != "//4=" || content.Size != 4 || content.Comment == "" {
+		t.Errorf("content = %+v, want 2 of 4 bytes in base64", content)
+	}
+
+	short, err := rec.Start(Options{Duration: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for got, _ := rec.Get(short.ID); got.Active; got, _ = rec.Get(short.ID) {
+		if time.Now().After(deadline) {
+			t.Fatal("recording did not stop after its duration")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	rec := NewRecorder(t.TempDir())
+	h := rec.AdminHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"duration": "forever"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid duration: status %d, want 400", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"path_prefix": "/api", "duration": "1m"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("start: status %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/1", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("download while active: status %d, want 409", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/1/stop", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("stop: status %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/1", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"version": "1.2"`) {
+		t.Errorf("download: status %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/1", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("delete: status %d, want 204", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("download deleted: status %d, want 404", w.Code)
+	}
+}
+
+func TestRecorderNeedsDir(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewRecorder("").AdminHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`)))
+	if w.Code != http.StatusConflict {
+		t.Errorf("start without a directory: status %d, want 409", w.Code)
+	}
+}
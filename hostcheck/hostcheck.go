@@ -0,0 +1,76 @@
+// Package hostcheck rejects requests for host names the proxy does not
+// serve. A page on an attacker's domain that is rebound to the proxy's
+// address can otherwise make browsers send it requests with the attacker's
+// Host header and read the responses, reaching services behind the proxy.
+package hostcheck
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Checker allows requests whose Host matches one of its patterns or is
+// accepted by Allow.
+type Checker struct {
+	exact    map[string]bool
+	suffixes []string
+	// Allow, if set, accepts further hosts, e.g. those routed to services.
+	Allow func(host string) bool
+	// Status is returned for other hosts; 421 Misdirected Request by
+	// default.
+	Status int
+}
+
+// New returns a Checker for the given patterns: host names, or "*.domain"
+// for any subdomain of domain.
+func New(patterns []string) (*Checker, error) {
+	c := &Checker{exact: make(map[string]bool), Status: http.StatusMisdirectedRequest}
+	for _, p := range patterns {
+		p = Normalize(strings.TrimSpace(p))
+		switch {
+		case p == "":
+			continue
+		case strings.HasPrefix(p, "*."):
+			c.suffixes = append(c.suffixes, p[1:])
+		case strings.Contains(p, "*"):
+			return nil, fmt.Errorf("invalid host pattern %q: only a leading *. is supported", p)
+		default:
+			c.exact[p] = true
+		}
+	}
+	return c, nil
+}
+
+// Normalize lowercases a host and strips any port and trailing dot.
+func Normalize(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// Allowed reports whether requests for host are served.
+func (c *Checker) Allowed(host string) bool {
+	host = Normalize(host)
+	if c.exact[host] {
+		return true
+	}
+	for _, s := range c.suffixes {
+		if strings.HasSuffix(host, s) && len(host) > len(s) {
+			return true
+		}
+	}
+	return c.Allow != nil && c.Allow(host)
+}
+
+func (c *Checker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.Allowed(r.Host) {
+			http.Error(w, "misdirected request", c.Status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,46 @@
+package hostcheck
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChecker(t *testing.T) {
+	c, err := New([]string{"Example.com", "*.apps.example.com", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Allow = func(host string) bool { return host == "routed.test" }
+
+	for host, want := range map[string]bool{
+		"example.com":            true,
+		"EXAMPLE.COM.:443":       true,
+		"a.apps.example.com":     true,
+		"a.b.apps.example.com":   true,
+		"apps.example.com":       false,
+		"evilapps.example.com":   false,
+		"routed.test:8443":       true,
+		"attacker.test":          false,
+		"127.0.0.1":              false,
+		"[::1]:443":              false,
+		"www.example.com.evil.a": false,
+	} {
+		if got := c.Allowed(host); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", host, got, want)
+		}
+	}
+
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "attacker.test"
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusMisdirectedRequest {
+		t.Errorf("Expected 421, got %d", w.Code)
+	}
+
+	if _, err := New([]string{"a.*.com"}); err == nil {
+		t.Error("Expected an inner wildcard to be rejected")
+	}
+}
@@ -0,0 +1,73 @@
+//go:build !nodocker
+
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+func init() {
+	Register("docker", func(name string) Controller { return &Docker{Container: name} })
+}
+
+// DefaultDockerSocket is used unless DOCKER_HOST names another unix socket.
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+// Docker controls a container through the Docker Engine API.
+type Docker struct {
+	Container string
+	// Socket is the Engine API socket; empty means DOCKER_HOST or
+	// DefaultDockerSocket.
+	Socket string
+}
+
+func (d *Docker) Start(ctx context.Context) error {
+	return d.post(ctx, "start")
+}
+
+func (d *Docker) Stop(ctx context.Context) error {
+	return d.post(ctx, "stop")
+}
+
+func (d *Docker) socket() string {
+	if d.Socket != "" {
+		return d.Socket
+	}
+	if host, ok := strings.CutPrefix(os.Getenv("DOCKER_HOST"), "unix://"); ok {
+		return host
+	}
+	return DefaultDockerSocket
+}
+
+func (d *Docker) post(ctx context.Context, action string) error {
+	socket := d.socket()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	defer client.CloseIdleConnections()
+	u := "http://docker/containers/" + url.PathEscape(d.Container) + "/" + action
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to %s container %s: %v", action, d.Container, err)
+	}
+	defer resp.Body.Close()
+	// 304 means the container already was in the requested state.
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to %s container %s: %s: %s", action, d.Container, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
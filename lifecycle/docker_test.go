@@ -0,0 +1,57 @@
+//go:build !nodocker
+
+package lifecycle
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDocker(t *testing.T) {
+	if c, err := Parse("docker:blog"); err != nil || c.(*Docker).Container != "blog" {
+		t.Errorf("docker: %v, %v", c, err)
+	}
+}
+
+func TestDocker(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	var calls []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/containers/blog/start":
+			w.WriteHeader(http.StatusNoContent)
+		case "/containers/blog/stop":
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			http.Error(w, `{"message": "No such container"}`, http.StatusNotFound)
+		}
+	}))
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	d := &Docker{Container: "blog", Socket: socket}
+	if err := d.Start(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if err := d.Stop(context.Background()); err != nil {
+		t.Errorf("Expected stopping a stopped container to succeed: %v", err)
+	}
+	missing := &Docker{Container: "nope", Socket: socket}
+	if err := missing.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "No such container") {
+		t.Errorf("Expected the Engine's error, got %v", err)
+	}
+	if len(calls) != 3 || calls[0] != "POST /containers/blog/start" {
+		t.Errorf("Unexpected calls: %v", calls)
+	}
+}
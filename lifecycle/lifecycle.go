@@ -0,0 +1,56 @@
+// Package lifecycle starts and stops backends run as Docker containers or
+// systemd units, so idle services can be shut down and woken on demand.
+//
+// Each kind of backend registers itself and can be left out of a build:
+// -tags nodocker drops Docker and -tags nosystemd drops systemd.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Controller starts and stops a backend. Starting a running backend and
+// stopping a stopped one succeed.
+type Controller interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+var kinds = make(map[string]func(name string) Controller)
+
+// Register makes Parse return the controller made by open for specs of the
+// form <kind>:<name>. It is meant to be called from init functions.
+func Register(kind string, open func(name string) Controller) {
+	kinds[kind] = open
+}
+
+// Kinds returns the registered kinds of backend, sorted.
+func Kinds() []string {
+	names := make([]string, 0, len(kinds))
+	for kind := range kinds {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse returns the controller for a spec of the form <kind>:<name>, such
+// as docker:<container> or systemd:<unit>.
+func Parse(spec string) (Controller, error) {
+	kind, name, _ := strings.Cut(spec, ":")
+	if name == "" {
+		return nil, fmt.Errorf("invalid backend %q, expected <kind>:<name>", spec)
+	}
+	open, ok := kinds[kind]
+	if !ok {
+		supported := strings.Join(Kinds(), ", ")
+		if supported == "" {
+			supported = "none"
+		}
+		return nil, fmt.Errorf("unknown backend kind %q, this build supports %s", kind, supported)
+	}
+	return open(name), nil
+}
@@ -0,0 +1,24 @@
+package lifecycle
+
+import (
+	"context"
+	"testing"
+)
+
+type fake struct{ name string }
+
+func (f *fake) Start(context.Context) error { return nil }
+func (f *fake) Stop(context.Context) error  { return nil }
+
+func TestParse(t *testing.T) {
+	Register("fake", func(name string) Controller { return &fake{name} })
+	defer delete(kinds, "fake")
+	if c, err := Parse("fake:blog"); err != nil || c.(*fake).name != "blog" {
+		t.Errorf("fake: %v, %v", c, err)
+	}
+	for _, spec := range []string{"fake:", "blog", "k8s:blog"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+//go:build !nosystemd
+
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	Register("systemd", func(name string) Controller { return &Systemd{Unit: name} })
+}
+
+// Systemd controls a unit with systemctl.
+type Systemd struct {
+	Unit string
+}
+
+func (s *Systemd) Start(ctx context.Context) error {
+	return s.systemctl(ctx, "start")
+}
+
+func (s *Systemd) Stop(ctx context.Context) error {
+	return s.systemctl(ctx, "stop")
+}
+
+func (s *Systemd) systemctl(ctx context.Context, action string) error {
+	out, err := exec.CommandContext(ctx, "systemctl", action, "--", s.Unit).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to %s unit %s: %v: %s", action, s.Unit, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+//go:build !nosystemd
+
+package lifecycle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSystemd(t *testing.T) {
+	if c, err := Parse("systemd:blog.service"); err != nil || c.(*Systemd).Unit != "blog.service" {
+		t.Errorf("systemd: %v, %v", c, err)
+	}
+}
+
+func TestSystemd(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$@\" >> " + log + "\n[ \"$3\" = broken.service ] && echo failed >&2 && exit 1\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(dir, "systemctl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	if err := (&Systemd{Unit: "blog.service"}).Start(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if err := (&Systemd{Unit: "broken.service"}).Stop(context.Background()); err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("Expected systemctl's error, got %v", err)
+	}
+	data, _ := os.ReadFile(log)
+	if got := string(data); got != "start -- blog.service\nstop -- broken.service\n" {
+		t.Errorf("Unexpected systemctl calls: %q", got)
+	}
+}
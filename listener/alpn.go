@@ -0,0 +1,217 @@
+package listener
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ACMETLSProto is the ALPN protocol used by the ACME TLS-ALPN-01 challenge.
+// The challenge is answered during the handshake, so such connections are
+// closed once it completes.
+const ACMETLSProto = "acme-tls/1"
+
+// ALPNRouter terminates TLS on an inner listener and dispatches each
+// connection by its negotiated ALPN protocol. Connections without a
+// registered route (h2, http/1.1, or none) are returned from Accept, so the
+// router can be handed to http.Server.Serve.
+type ALPNRouter struct {
+	inner            net.Listener
+	config           *tls.Config
+	HandshakeTimeout time.Duration
+	// OnHandshakeError, if set, is called with every connection whose
+	// handshake failed, before it is closed.
+	OnHandshakeError func(conn net.Conn, err error)
+
+	mu     sync.RWMutex
+	routes map[string]func(net.Conn)
+
+	conns     chan net.Conn
+	errs      chan error
+	start     sync.Once
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewALPNRouter(inner net.Listener, config *tls.Config) *ALPNRouter {
+	config = config.Clone()
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return &ALPNRouter{
+		inner:            inner,
+		config:           config,
+		HandshakeTimeout: 10 * time.Second,
+		routes:           make(map[string]func(net.Conn)),
+		conns:            make(chan net.Conn),
+		errs:             make(chan error, 1),
+		done:             make(chan struct{}),
+	}
+}
+
+// Handle routes connections that negotiated proto to handler, which owns the
+// connection. The protocol is advertised to clients.
+func (r *ALPNRouter) Handle(proto string, handler func(net.Conn)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes[proto] = handler
+	if !slices.Contains(r.config.NextProtos, proto) {
+		config := r.config.Clone()
+		config.NextProtos = append(config.NextProtos, proto)
+		r.config = config
+	}
+}
+
+// HandleTCP forwards the decrypted stream of connections that negotiated
+// proto to a plain TCP backend.
+func (r *ALPNRouter) HandleTCP(proto, backendAddr string) {
+	r.Handle(proto, func(conn net.Conn) {
+		ProxyTCP(conn, backendAddr)
+	})
+}
+
+func (r *ALPNRouter) Accept() (net.Conn, error) {
+	r.start.Do(func() { go r.acceptLoop() })
+
+	select {
+	case conn := <-r.conns:
+		return conn, nil
+	case err := <-r.errs:
+		return nil, err
+	case <-r.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (r *ALPNRouter) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.done)
+		err = r.inner.Close()
+	})
+	return err
+}
+
+func (r *ALPNRouter) Addr() net.Addr {
+	return r.inner.Addr()
+}
+
+func (r *ALPNRouter) acceptLoop() {
+	var delay time.Duration
+	for {
+		conn, err := r.inner.Accept()
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			// Like http.Server, wait out errors such as running out of
+			// file descriptors instead of giving up.
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-r.done:
+				return
+			}
+		}
+		if err != nil {
+			select {
+			case r.errs <- err:
+			case <-r.done:
+			}
+			return
+		}
+		delay = 0
+		go r.dispatch(conn)
+	}
+}
+
+func (r *ALPNRouter) dispatch(raw net.Conn) {
+	r.mu.RLock()
+	config := r.config
+	r.mu.RUnlock()
+
+	conn := tls.Server(raw, config)
+	raw.SetDeadline(time.Now().Add(r.HandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		// Answer plain HTTP as http.Server does.
+		var re tls.RecordHeaderError
+		if errors.As(err, &re) && re.Conn != nil && looksLikeHTTP(re.RecordHeader[:]) {
+			io.WriteString(re.Conn, "HTTP/1.0 400 Bad Request\r\n\r\nClient sent an HTTP request to an HTTPS server.\n")
+			err = errors.New("client sent an HTTP request to an HTTPS server")
+		}
+		if r.OnHandshakeError != nil {
+			r.OnHandshakeError(raw, err)
+		}
+		conn.Close()
+		return
+	}
+	raw.SetDeadline(time.Time{})
+
+	proto := conn.ConnectionState().NegotiatedProtocol
+	if proto == ACMETLSProto {
+		conn.Close()
+		return
+	}
+
+	r.mu.RLock()
+	handler, ok := r.routes[proto]
+	r.mu.RUnlock()
+	if ok {
+		handler(conn)
+		return
+	}
+
+	select {
+	case r.conns <- conn:
+	case <-r.done:
+		conn.Close()
+	}
+}
+
+func looksLikeHTTP(hdr []byte) bool {
+	switch string(hdr) {
+	case "GET /", "HEAD ", "POST ", "PUT /", "OPTIO":
+		return true
+	}
+	return false
+}
+
+// ProxyTCP copies data between conn and a new connection to backendAddr
+// until either side closes. It closes conn when done.
+func ProxyTCP(conn net.Conn, backendAddr string) {
+	defer conn.Close()
+
+	backend, err := net.DialTimeout("tcp", backendAddr, 10*time.Second)
+	if err != nil {
+		log.Printf("Failed to dial backend %s: %v", backendAddr, err)
+		return
+	}
+	defer backend.Close()
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(backend, conn)
+		closeWrite(backend)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, backend)
+		closeWrite(conn)
+		errc <- err
+	}()
+
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil && !errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
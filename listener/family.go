@@ -0,0 +1,154 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Family selects the IP versions a listener accepts.
+type Family string
+
+const (
+	// FamilyAuto listens however the host supports: a single dual-stack
+	// socket where IPv6 works, IPv4 only where it does not.
+	FamilyAuto Family = "auto"
+	FamilyIPv4 Family = "ipv4"
+	FamilyIPv6 Family = "ipv6"
+	// FamilyDual listens on IPv4 and IPv6 with a socket each, failing if
+	// either cannot be bound.
+	FamilyDual Family = "dual"
+)
+
+func ParseFamily(s string) (Family, error) {
+	switch f := Family(s); f {
+	case FamilyAuto, FamilyIPv4, FamilyIPv6, FamilyDual:
+		return f, nil
+	case "":
+		return FamilyAuto, nil
+	}
+	return "", fmt.Errorf("unknown address family %q, expected auto, ipv4, ipv6 or dual", s)
+}
+
+// Listen listens for TCP connections on addr in the given family. An IP
+// address in addr must belong to the family, and FamilyDual needs a
+// wildcard address or a host name.
+func Listen(addr string, family Family) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		is4 := ip.To4() != nil
+		switch {
+		case family == FamilyIPv4 && !is4:
+			return nil, fmt.Errorf("%s is an IPv6 address but the listener is IPv4 only", host)
+		case family == FamilyIPv6 && is4:
+			return nil, fmt.Errorf("%s is an IPv4 address but the listener is IPv6 only", host)
+		case family == FamilyDual:
+			return nil, fmt.Errorf("dual-stack listening needs a wildcard address or host name, not %s", host)
+		}
+	}
+
+	switch family {
+	case FamilyIPv4:
+		return listen("tcp4", addr)
+	case FamilyIPv6:
+		return listen("tcp6", addr)
+	case FamilyDual:
+		ln4, err := listen("tcp4", addr)
+		if err != nil {
+			return nil, err
+		}
+		// With port 0 both sockets share the port picked for IPv4.
+		if port == "0" {
+			port = strconv.Itoa(ln4.Addr().(*net.TCPAddr).Port)
+		}
+		ln6, err := listen("tcp6", net.JoinHostPort(host, port))
+		if err != nil {
+			ln4.Close()
+			return nil, err
+		}
+		return newMultiListener(ln4, ln6), nil
+	default:
+		return net.Listen("tcp", addr)
+	}
+}
+
+func listen(network, addr string) (net.Listener, error) {
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		name := map[string]string{"tcp4": "IPv4", "tcp6": "IPv6"}[network]
+		return nil, fmt.Errorf("failed to listen on %s %s: %v", name, addr, err)
+	}
+	return ln, nil
+}
+
+// multiListener accepts connections from several listeners. Addr is that
+// of the first.
+type multiListener struct {
+	listeners []net.Listener
+	accepted  chan accepted
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type accepted struct {
+	conn net.Conn
+	err  error
+}
+
+func newMultiListener(listeners ...net.Listener) *multiListener {
+	m := &multiListener{listeners: listeners, accepted: make(chan accepted), done: make(chan struct{})}
+	for _, l := range listeners {
+		go m.acceptFrom(l)
+	}
+	return m
+}
+
+func (m *multiListener) acceptFrom(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		select {
+		case m.accepted <- accepted{conn, err}:
+		case <-m.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		// Servers retry temporary errors; anything else ends the listener.
+		var ne net.Error
+		if err != nil && !(errors.As(err, &ne) && ne.Timeout()) {
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case a := <-m.accepted:
+		return a.conn, a.err
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *multiListener) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.done)
+		for _, l := range m.listeners {
+			if e := l.Close(); e != nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
@@ -0,0 +1,59 @@
+package listener
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestListenFamilyValidation(t *testing.T) {
+	for _, tt := range []struct {
+		addr   string
+		family Family
+		want   string
+	}{
+		{"[::1]:0", FamilyIPv4, "IPv4 only"},
+		{"127.0.0.1:0", FamilyIPv6, "IPv6 only"},
+		{"127.0.0.1:0", FamilyDual, "wildcard"},
+		{"no-port", FamilyAuto, "missing port"},
+	} {
+		if _, err := Listen(tt.addr, tt.family); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Listen(%q, %s): expected error containing %q, got %v", tt.addr, tt.family, tt.want, err)
+		}
+	}
+	if _, err := ParseFamily("ipv5"); err == nil {
+		t.Error("Expected unknown family to be rejected")
+	}
+}
+
+func TestListenDual(t *testing.T) {
+	if ln, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skip("IPv6 is unavailable")
+	} else {
+		ln.Close()
+	}
+	ln, err := Listen(":0", FamilyDual)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	port := strings.TrimPrefix(ln.Addr().String(), "0.0.0.0")
+
+	for _, host := range []string{"127.0.0.1", "[::1]"} {
+		conn, err := net.Dial("tcp", host+port)
+		if err != nil {
+			t.Fatalf("Failed to dial %s: %v", host, err)
+		}
+		accepted, err := ln.Accept()
+		if err != nil {
+			t.Fatalf("Failed to accept from %s: %v", host, err)
+		}
+		accepted.Close()
+		conn.Close()
+	}
+
+	ln.Close()
+	if _, err := ln.Accept(); err != net.ErrClosed {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+}
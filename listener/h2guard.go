@@ -0,0 +1,257 @@
+package listener
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"golang.org/x/net/http2"
+)
+
+// H2Limits are the per-connection stream budgets enforced by H2Guard. A
+// connection over any of them is closed. Zero disables a limit.
+type H2Limits struct {
+	// MaxConcurrentStreams is advertised to clients; streams opened beyond
+	// it are refused.
+	MaxConcurrentStreams uint32
+	// MaxRefused is how many streams beyond MaxConcurrentStreams a client
+	// may open before it counts as a flood.
+	MaxRefused int
+	// MaxStreams and MaxResets are the streams a client may open and
+	// cancel (RST_STREAM) per Window. Opening and cancelling streams in a
+	// loop (rapid reset) keeps the server busy without ever counting
+	// against MaxConcurrentStreams.
+	MaxStreams int
+	MaxResets  int
+	Window     time.Duration
+}
+
+// DefaultH2Limits leave room for browsers cancelling requests as pages
+// change while stopping floods.
+var DefaultH2Limits = H2Limits{MaxConcurrentStreams: 250, MaxRefused: 10, MaxStreams: 2000, MaxResets: 200, Window: 10 * time.Second}
+
+// H2Stats counts HTTP/2 connections and the ones closed for abuse.
+type H2Stats struct {
+	Connections int64 `json:"connections"`
+	Active      int64 `json:"active"`
+	Streams     int64 `json:"streams"`
+	Resets      int64 `json:"resets"`
+	// Closed counts connections closed by reason: rapid_reset,
+	// stream_flood and concurrent_streams.
+	Closed map[string]int64 `json:"closed"`
+}
+
+// H2Guard serves HTTP/2 for an http.Server, watching the frames clients
+// send on each connection and closing those abusing streams.
+type H2Guard struct {
+	limits H2Limits
+	h2     *http2.Server
+
+	connections, active, streams, resets atomic.Int64
+
+	mu      sync.Mutex
+	closed  map[string]int64
+	lastLog time.Time
+}
+
+func NewH2Guard(limits H2Limits) *H2Guard {
+	if limits.Window <= 0 {
+		limits.Window = DefaultH2Limits.Window
+	}
+	return &H2Guard{
+		limits: limits,
+		h2:     &http2.Server{MaxConcurrentStreams: limits.MaxConcurrentStreams},
+		closed: make(map[string]int64),
+	}
+}
+
+// Configure makes srv serve HTTP/2 through the guard. Call it before srv
+// starts serving.
+func (g *H2Guard) Configure(srv *http.Server) error {
+	if err := http2.ConfigureServer(srv, g.h2); err != nil {
+		return fmt.Errorf("failed to configure HTTP/2: %v", err)
+	}
+	srv.TLSNextProto[http2.NextProtoTLS] = g.serveConn
+	return nil
+}
+
+// serveConn is the TLSNextProto handler of an h2 connection.
+func (g *H2Guard) serveConn(hs *http.Server, c *tls.Conn, h http.Handler) {
+	g.connections.Add(1)
+	g.active.Add(1)
+	defer g.active.Add(-1)
+
+	conn := &h2Conn{Conn: c, guard: g, skip: len(http2.ClientPreface), windowStart: time.Now()}
+	// A copy of the server per connection, so errors are counted against
+	// the connection that caused them.
+	srv := *g.h2
+	srv.CountError = func(errType string) {
+		if strings.HasPrefix(errType, "over_max_streams") {
+			conn.refused++
+			if g.limits.MaxRefused > 0 && conn.refused > g.limits.MaxRefused {
+				conn.abort("concurrent_streams")
+			}
+		}
+	}
+	opts := &http2.ServeConnOpts{Handler: h, BaseConfig: hs}
+	// net/http passes its per-connection context through the handler.
+	if bc, ok := h.(interface{ BaseContext() context.Context }); ok {
+		opts.Context = bc.BaseContext()
+	}
+	srv.ServeConn(conn, opts)
+}
+
+func (g *H2Guard) closedFor(reason string, c *h2Conn) {
+	g.mu.Lock()
+	g.closed[reason]++
+	logIt := time.Since(g.lastLog) > time.Minute
+	if logIt {
+		g.lastLog = time.Now()
+	}
+	g.mu.Unlock()
+	if logIt {
+		log.Printf("Closed HTTP/2 connection from %s: %s", c.RemoteAddr(), reason)
+	}
+}
+
+// Stats returns the connection and stream counts.
+func (g *H2Guard) Stats() H2Stats {
+	g.mu.Lock()
+	closed := make(map[string]int64, len(g.closed))
+	for reason, n := range g.closed {
+		closed[reason] = n
+	}
+	g.mu.Unlock()
+	return H2Stats{
+		Connections: g.connections.Load(),
+		Active:      g.active.Load(),
+		Streams:     g.streams.Load(),
+		Resets:      g.resets.Load(),
+		Closed:      closed,
+	}
+}
+
+// AdminHandler serves the HTTP/2 statistics:
+//
+//	GET /          counts as JSON
+//	GET /metrics   the same in Prometheus text format
+func (g *H2Guard) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, g.Stats())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		s := g.Stats()
+		var b strings.Builder
+		metric := func(name, typ, help string, value int64) {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, typ, name, value)
+		}
+		metric("proxy_h2_connections_total", "counter", "HTTP/2 connections accepted.", s.Connections)
+		metric("proxy_h2_connections_active", "gauge", "Open HTTP/2 connections.", s.Active)
+		metric("proxy_h2_streams_total", "counter", "Streams opened by clients.", s.Streams)
+		metric("proxy_h2_resets_total", "counter", "Streams cancelled by clients.", s.Resets)
+		b.WriteString("# HELP proxy_h2_connections_closed_total HTTP/2 connections closed for abuse.\n")
+		b.WriteString("# TYPE proxy_h2_connections_closed_total counter\n")
+		for _, reason := range []string{"rapid_reset", "stream_flood", "concurrent_streams"} {
+			fmt.Fprintf(&b, "proxy_h2_connections_closed_total{reason=%q} %d\n", reason, s.Closed[reason])
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(b.String()))
+	})
+	return mux
+}
+
+var errH2Abuse = errors.New("connection closed for HTTP/2 abuse")
+
+// h2Conn follows the frames read from an HTTP/2 connection, counting the
+// streams the client opens and resets.
+type h2Conn struct {
+	*tls.Conn
+	guard *H2Guard
+
+	// skip is the rest of the preface or frame payload being read; header
+	// collects a frame header split across reads.
+	skip   int
+	header []byte
+
+	lastStream                  uint32
+	windowStart                 time.Time
+	windowStreams, windowResets int
+	refused                     int
+	aborted                     atomic.Bool
+}
+
+func (c *h2Conn) Read(b []byte) (int, error) {
+	if c.aborted.Load() {
+		return 0, errH2Abuse
+	}
+	n, err := c.Conn.Read(b)
+	c.inspect(b[:n])
+	if c.aborted.Load() {
+		return 0, errH2Abuse
+	}
+	return n, err
+}
+
+func (c *h2Conn) inspect(b []byte) {
+	for len(b) > 0 {
+		if c.skip > 0 {
+			n := min(c.skip, len(b))
+			c.skip -= n
+			b = b[n:]
+			continue
+		}
+		n := min(9-len(c.header), len(b))
+		c.header = append(c.header, b[:n]...)
+		b = b[n:]
+		if len(c.header) < 9 {
+			return
+		}
+		length := int(c.header[0])<<16 | int(c.header[1])<<8 | int(c.header[2])
+		stream := binary.BigEndian.Uint32(c.header[5:]) & 0x7fffffff
+		c.frame(http2.FrameType(c.header[3]), stream)
+		c.header = c.header[:0]
+		c.skip = length
+	}
+}
+
+func (c *h2Conn) frame(typ http2.FrameType, stream uint32) {
+	limits := c.guard.limits
+	if time.Since(c.windowStart) > limits.Window {
+		c.windowStart = time.Now()
+		c.windowStreams, c.windowResets = 0, 0
+	}
+	switch {
+	case typ == http2.FrameHeaders && stream > c.lastStream:
+		c.lastStream = stream
+		c.windowStreams++
+		c.guard.streams.Add(1)
+		if limits.MaxStreams > 0 && c.windowStreams > limits.MaxStreams {
+			c.abort("stream_flood")
+		}
+	case typ == http2.FrameRSTStream:
+		c.windowResets++
+		c.guard.resets.Add(1)
+		if limits.MaxResets > 0 && c.windowResets > limits.MaxResets {
+			c.abort("rapid_reset")
+		}
+	}
+}
+
+// abort closes the connection, once.
+func (c *h2Conn) abort(reason string) {
+	if c.aborted.Swap(true) {
+		return
+	}
+	c.guard.closedFor(reason, c)
+	c.Conn.Close()
+}
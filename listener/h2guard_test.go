@@ -0,0 +1,89 @@
+package listener
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+func startH2Server(t *testing.T, guard *H2Guard) string {
+	srv := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.Proto)) }),
+		TLSConfig: testTLSConfig(t),
+	}
+	if err := guard.Configure(srv); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeTLS(ln, "", "")
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func TestH2GuardServesRequests(t *testing.T) {
+	guard := NewH2Guard(DefaultH2Limits)
+	addr := startH2Server(t, guard)
+	client := &http.Client{Transport: &http2.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "HTTP/2.0" {
+			t.Fatalf("Expected an HTTP/2 response, got %q", body)
+		}
+	}
+	if s := guard.Stats(); s.Connections != 1 || s.Streams != 3 || len(s.Closed) != 0 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+}
+
+func TestH2GuardClosesRapidReset(t *testing.T) {
+	guard := NewH2Guard(H2Limits{MaxConcurrentStreams: 100, MaxResets: 20, Window: time.Minute})
+	addr := startH2Server(t, guard)
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(http2.ClientPreface))
+	framer := http2.NewFramer(conn, conn)
+	framer.WriteSettings()
+
+	var block bytes.Buffer
+	enc := hpack.NewEncoder(&block)
+	for _, f := range [][2]string{{":method", "GET"}, {":scheme", "https"}, {":authority", "localhost"}, {":path", "/"}} {
+		enc.WriteField(hpack.HeaderField{Name: f[0], Value: f[1]})
+	}
+	go func() {
+		for id := uint32(1); id < 200; id += 2 {
+			framer.WriteHeaders(http2.HeadersFrameParam{StreamID: id, BlockFragment: block.Bytes(), EndStream: true, EndHeaders: true})
+			if framer.WriteRSTStream(id, http2.ErrCodeCancel) != nil {
+				return
+			}
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// The close may surface as a reset, as the client is still writing.
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Fatalf("Expected the server to close the connection, got %v", err)
+		}
+	}
+	if s := guard.Stats(); s.Closed["rapid_reset"] != 1 || s.Resets <= 20 {
+		t.Errorf("Expected the connection to be closed for rapid reset: %+v", s)
+	}
+}
@@ -0,0 +1,488 @@
+package listener
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// HTTP1Guard checks the framing of HTTP/1 requests before net/http reads
+// them and refuses requests that servers in front of the proxy could split
+// differently, the root of request smuggling. net/http already refuses most
+// malformed requests; in strict mode the guard also refuses what it
+// tolerates: Content-Length together with Transfer-Encoding, repeated
+// Content-Length headers, Transfer-Encoding on HTTP/1.0, bare LF line
+// endings, obsolete line folding and malformed chunk lines. A refused request
+// gets 400 Bad Request and its connection is closed.
+type HTTP1Guard struct {
+	// Strict turns the checks on; without it Listener returns its listener
+	// unchanged.
+	Strict bool
+
+	maxHead  int
+	requests atomic.Int64
+
+	mu       sync.Mutex
+	rejected map[string]int64
+	lastLog  time.Time
+}
+
+// HTTP1Stats counts the requests the guard checked and refused.
+type HTTP1Stats struct {
+	Strict   bool  `json:"strict"`
+	Requests int64 `json:"requests"`
+	// Rejected counts refused requests by reason, such as
+	// content_length_with_transfer_encoding or bare_lf.
+	Rejected map[string]int64 `json:"rejected"`
+}
+
+func NewHTTP1Guard(strict bool) *HTTP1Guard {
+	return &HTTP1Guard{Strict: strict, maxHead: http.DefaultMaxHeaderBytes, rejected: make(map[string]int64)}
+}
+
+// Configure adapts srv to connections from Listener, which net/http no
+// longer recognizes as TLS: requests get their TLS state back. Call it
+// before srv starts serving.
+func (g *HTTP1Guard) Configure(srv *http.Server) {
+	if srv.MaxHeaderBytes > 0 {
+		g.maxHead = srv.MaxHeaderBytes
+	}
+	connContext := srv.ConnContext
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, c)
+		}
+		if hc, ok := c.(*http1Conn); ok {
+			if tc, ok := hc.Conn.(*tls.Conn); ok {
+				ctx = context.WithValue(ctx, tlsStateKey{}, tc)
+			}
+		}
+		return ctx
+	}
+	next := srv.Handler
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+	srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tc, ok := r.Context().Value(tlsStateKey{}).(*tls.Conn); ok && r.TLS == nil {
+			state := tc.ConnectionState()
+			r = r.WithContext(r.Context())
+			r.TLS = &state
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type tlsStateKey struct{}
+
+// Listener returns ln with the requests on its connections checked. HTTP/2
+// connections are left alone.
+func (g *HTTP1Guard) Listener(ln net.Listener) net.Listener {
+	if !g.Strict {
+		return ln
+	}
+	return &http1Listener{Listener: ln, guard: g}
+}
+
+type http1Listener struct {
+	net.Listener
+	guard *HTTP1Guard
+}
+
+func (l *http1Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := conn.(*tls.Conn); ok && tc.ConnectionState().NegotiatedProtocol == "h2" {
+		return conn, nil
+	}
+	return &http1Conn{Conn: conn, guard: l.guard, parser: http1Parser{maxHead: l.guard.maxHead}}, nil
+}
+
+func (g *HTTP1Guard) rejectedFor(reason string, c net.Conn) {
+	g.mu.Lock()
+	g.rejected[reason]++
+	logIt := time.Since(g.lastLog) > time.Minute
+	if logIt {
+		g.lastLog = time.Now()
+	}
+	g.mu.Unlock()
+	if logIt {
+		log.Printf("Refused HTTP/1 request from %s: %s", c.RemoteAddr(), reason)
+	}
+}
+
+// Stats returns the request counts.
+func (g *HTTP1Guard) Stats() HTTP1Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	rejected := make(map[string]int64, len(g.rejected))
+	for reason, n := range g.rejected {
+		rejected[reason] = n
+	}
+	return HTTP1Stats{Strict: g.Strict, Requests: g.requests.Load(), Rejected: rejected}
+}
+
+// AdminHandler serves the guard's statistics and the smuggling self-test,
+// which sends SmugglingVectors over connections from dial with host as
+// their Host header:
+//
+//	GET /           HTTP1Stats
+//	GET /metrics    the same in Prometheus text format
+//	POST /selftest  SmugglingReport
+func (g *HTTP1Guard) AdminHandler(dial func(ctx context.Context) (net.Conn, error), host string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, g.Stats())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		s := g.Stats()
+		var b strings.Builder
+		b.WriteString("# HELP proxy_http1_requests_total HTTP/1 requests checked by the strict parser.\n")
+		b.WriteString("# TYPE proxy_http1_requests_total counter\n")
+		fmt.Fprintf(&b, "proxy_http1_requests_total %d\n", s.Requests)
+		b.WriteString("# HELP proxy_http1_requests_rejected_total HTTP/1 requests refused for ambiguous framing.\n")
+		b.WriteString("# TYPE proxy_http1_requests_rejected_total counter\n")
+		for _, reason := range http1Reasons {
+			fmt.Fprintf(&b, "proxy_http1_requests_rejected_total{reason=%q} %d\n", reason, s.Rejected[reason])
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(b.String()))
+	})
+	mux.HandleFunc("POST /selftest", func(w http.ResponseWriter, r *http.Request) {
+		report := SmugglingTest(r.Context(), dial, host)
+		report.Strict = g.Strict
+		admin.WriteJSON(w, http.StatusOK, report)
+	})
+	return mux
+}
+
+var errHTTP1Rejected = errors.New("request refused for ambiguous HTTP/1 framing")
+
+// http1Conn follows the requests read from a connection, passing net/http
+// the bytes before the first one it refuses. net/http answers the read
+// error that follows with 400 Bad Request and closes the connection.
+type http1Conn struct {
+	net.Conn
+	guard    *HTTP1Guard
+	parser   http1Parser
+	rejected bool
+	// switched is set once the connection switched protocols, e.g. to
+	// WebSocket, and no longer carries HTTP/1 requests.
+	switched atomic.Bool
+}
+
+func (c *http1Conn) Read(b []byte) (int, error) {
+	if c.rejected {
+		return 0, errHTTP1Rejected
+	}
+	n, err := c.Conn.Read(b)
+	if c.switched.Load() {
+		return n, err
+	}
+	requests := c.parser.requests
+	reason, start := c.parser.feed(b[:n])
+	c.guard.requests.Add(int64(c.parser.requests - requests))
+	if reason == "" {
+		return n, err
+	}
+	c.rejected = true
+	c.guard.rejectedFor(reason, c)
+	if start > 0 {
+		return start, nil
+	}
+	return 0, errHTTP1Rejected
+}
+
+func (c *http1Conn) Write(b []byte) (int, error) {
+	if bytes.HasPrefix(b, []byte("HTTP/1.1 101 ")) {
+		c.switched.Store(true)
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *http1Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// http1Reasons are the reasons the parser refuses a request for.
+var http1Reasons = []string{
+	"invalid_request_line", "bare_lf", "bare_cr", "obs_fold", "invalid_header",
+	"content_length_with_transfer_encoding", "duplicate_content_length", "invalid_content_length",
+	"invalid_transfer_encoding", "transfer_encoding_on_http10", "invalid_chunk",
+}
+
+type http1State int
+
+const (
+	stateHead http1State = iota
+	stateBody
+	stateChunkSize
+	stateChunkData
+	stateChunkEnd
+	stateTrailer
+	// statePassthrough leaves the rest of the stream to net/http.
+	statePassthrough
+)
+
+// maxChunkLine bounds the line of a chunk size and its extensions.
+const maxChunkLine = 4096
+
+// http1Parser splits a stream of HTTP/1 requests, checking their heads and
+// chunked bodies.
+type http1Parser struct {
+	maxHead  int
+	requests int
+
+	state     http1State
+	line      []byte
+	headBytes int
+	// remaining is the rest of the body or chunk, or of the CRLF ending a
+	// chunk.
+	remaining int64
+
+	requestLine bool
+	http10      bool
+	lengths     []string
+	encodings   []string
+}
+
+// feed checks the next bytes of the stream. It returns the reason to
+// refuse the request they belong to, if any, and the offset in b at which
+// that request started, or -1 if it started earlier.
+func (p *http1Parser) feed(b []byte) (reason string, start int) {
+	start = -1
+	if p.state == stateHead && p.headBytes == 0 {
+		start = 0
+	}
+	for i := 0; i < len(b); {
+		switch p.state {
+		case statePassthrough:
+			return "", -1
+		case stateBody, stateChunkData:
+			n := int(min(p.remaining, int64(len(b)-i)))
+			p.remaining -= int64(n)
+			i += n
+			if p.remaining > 0 {
+				continue
+			}
+			if p.state == stateChunkData {
+				p.state, p.remaining = stateChunkEnd, 2
+			} else {
+				p.next()
+				start = i
+			}
+		case stateChunkEnd:
+			want := "\r\n"[2-int(p.remaining)]
+			if b[i] != want {
+				return "invalid_chunk", start
+			}
+			i++
+			if p.remaining--; p.remaining == 0 {
+				p.state = stateChunkSize
+			}
+		default:
+			j := bytes.IndexByte(b[i:], '\n')
+			end := len(b)
+			if j >= 0 {
+				end = i + j + 1
+			}
+			p.line = append(p.line, b[i:end]...)
+			if p.state != stateChunkSize {
+				p.headBytes += end - i
+			}
+			i = end
+			if j < 0 {
+				if p.state == stateChunkSize && len(p.line) > maxChunkLine {
+					return "invalid_chunk", start
+				}
+				if p.headBytes > p.maxHead+4096 {
+					// net/http answers 431 itself.
+					p.state = statePassthrough
+				}
+				return "", start
+			}
+			line := p.line
+			p.line = p.line[:0]
+			if reason := p.processLine(line); reason != "" {
+				return reason, start
+			}
+			if p.state == stateHead && p.headBytes == 0 {
+				start = i
+			}
+		}
+	}
+	return "", start
+}
+
+// next starts the following request.
+func (p *http1Parser) next() {
+	p.requests++
+	*p = http1Parser{maxHead: p.maxHead, requests: p.requests, line: p.line}
+}
+
+// processLine handles a line of a head, chunk size or trailer, ending in
+// LF.
+func (p *http1Parser) processLine(line []byte) string {
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return "bare_lf"
+	}
+	line = line[:len(line)-2]
+	if bytes.IndexByte(line, '\r') >= 0 {
+		return "bare_cr"
+	}
+	switch p.state {
+	case stateChunkSize:
+		return p.chunkSize(line)
+	case stateTrailer:
+		if len(line) == 0 {
+			p.next()
+			return ""
+		}
+		return headerField(line)
+	}
+	if !p.requestLine {
+		return p.parseRequestLine(line)
+	}
+	if len(line) == 0 {
+		return p.endHead()
+	}
+	if reason := headerField(line); reason != "" {
+		return reason
+	}
+	name, value, _ := strings.Cut(string(line), ":")
+	value = strings.Trim(value, " \t")
+	switch strings.ToLower(name) {
+	case "content-length":
+		p.lengths = append(p.lengths, value)
+	case "transfer-encoding":
+		p.encodings = append(p.encodings, value)
+	}
+	return ""
+}
+
+func (p *http1Parser) parseRequestLine(line []byte) string {
+	parts := strings.Split(string(line), " ")
+	if len(parts) != 3 || !isToken(parts[0]) || parts[1] == "" || strings.ContainsFunc(parts[1], isCTL) {
+		return "invalid_request_line"
+	}
+	switch parts[2] {
+	case "HTTP/1.1":
+	case "HTTP/1.0":
+		p.http10 = true
+	default:
+		return "invalid_request_line"
+	}
+	p.requestLine = true
+	return ""
+}
+
+// headerField checks a header or trailer field line.
+func headerField(line []byte) string {
+	if line[0] == ' ' || line[0] == '\t' {
+		return "obs_fold"
+	}
+	name, value, ok := strings.Cut(string(line), ":")
+	if !ok || !isToken(name) || strings.ContainsFunc(value, func(r rune) bool { return r != '\t' && isCTL(r) }) {
+		return "invalid_header"
+	}
+	return ""
+}
+
+// endHead decides how the body of the request is framed.
+func (p *http1Parser) endHead() string {
+	switch {
+	case len(p.encodings) > 0 && len(p.lengths) > 0:
+		return "content_length_with_transfer_encoding"
+	case len(p.encodings) > 0 && p.http10:
+		return "transfer_encoding_on_http10"
+	case len(p.encodings) > 1 || len(p.encodings) == 1 && !strings.EqualFold(p.encodings[0], "chunked"):
+		return "invalid_transfer_encoding"
+	case len(p.encodings) == 1:
+		p.state = stateChunkSize
+	case len(p.lengths) > 1:
+		return "duplicate_content_length"
+	case len(p.lengths) == 1:
+		n, ok := decimal(p.lengths[0])
+		if !ok {
+			return "invalid_content_length"
+		}
+		p.state, p.remaining = stateBody, n
+	}
+	if p.state == stateHead {
+		p.next()
+	}
+	return ""
+}
+
+// chunkSize reads the size of the next chunk: hex digits, optionally
+// followed by extensions.
+func (p *http1Parser) chunkSize(line []byte) string {
+	size, ext, _ := strings.Cut(string(line), ";")
+	size = strings.TrimRight(size, " \t")
+	if size == "" || len(size) > 15 || strings.ContainsFunc(ext, isCTL) {
+		return "invalid_chunk"
+	}
+	var n int64
+	for _, c := range size {
+		switch {
+		case c >= '0' && c <= '9':
+			n = n<<4 | int64(c-'0')
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+			n = n<<4 | int64(c|0x20-'a'+10)
+		default:
+			return "invalid_chunk"
+		}
+	}
+	if n == 0 {
+		p.state = stateTrailer
+	} else {
+		p.state, p.remaining = stateChunkData, n
+	}
+	return ""
+}
+
+func decimal(s string) (int64, bool) {
+	if s == "" || len(s) > 18 {
+		return 0, false
+	}
+	var n int64
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	return n, true
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c >= 0x7f || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isCTL(r rune) bool {
+	return r < ' ' || r == 0x7f
+}
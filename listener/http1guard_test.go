@@ -0,0 +1,118 @@
+package listener
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func startHTTP1Server(t *testing.T, guard *HTTP1Guard, tlsConfig *tls.Config) string {
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.TLS != nil {
+			w.Write([]byte("tls"))
+		}
+	})}
+	guard.Configure(srv)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	go srv.Serve(guard.Listener(ln))
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func TestSmugglingSelfTest(t *testing.T) {
+	for _, strict := range []bool{true, false} {
+		guard := NewHTTP1Guard(strict)
+		addr := startHTTP1Server(t, guard, nil)
+		dial := func(ctx context.Context) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		}
+		report := SmugglingTest(context.Background(), dial, "example.com")
+		failed := make(map[string]bool)
+		for _, res := range report.Results {
+			if !res.Pass {
+				failed[res.Name] = true
+				if strict {
+					t.Errorf("Strict: %s failed: %s %v", res.Name, res.Detail, res.Statuses)
+				}
+			}
+		}
+		if report.Passed+report.Failed != len(SmugglingVectors) || report.Failed != len(failed) {
+			t.Errorf("Unexpected counts: %+v", report)
+		}
+		if !strict {
+			for _, name := range []string{"cl_te", "bare_lf", "te_obs_fold", "te_http10"} {
+				if !failed[name] {
+					t.Errorf("Expected %s to fail without the guard", name)
+				}
+			}
+			for _, name := range []string{"control", "control_pipelined", "control_chunked"} {
+				if failed[name] {
+					t.Errorf("Expected %s to pass without the guard", name)
+				}
+			}
+			continue
+		}
+		s := guard.Stats()
+		if s.Rejected["content_length_with_transfer_encoding"] == 0 || s.Rejected["bare_lf"] == 0 || s.Rejected["invalid_chunk"] == 0 {
+			t.Errorf("Expected rejections to be counted: %+v", s)
+		}
+		if s.Requests < 4 {
+			t.Errorf("Expected the control requests to be counted: %+v", s)
+		}
+	}
+}
+
+func TestHTTP1GuardKeepsTLSState(t *testing.T) {
+	guard := NewHTTP1Guard(true)
+	addr := startHTTP1Server(t, guard, testTLSConfig(t))
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post("https://"+addr+"/", "text/plain", strings.NewReader(strings.Repeat("x", 100000)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "tls" {
+			t.Fatalf("Expected the request to keep its TLS state, got %q", body)
+		}
+	}
+	if s := guard.Stats(); s.Requests != 2 || len(s.Rejected) != 0 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+}
+
+func TestHTTP1ParserSplitsAcrossReads(t *testing.T) {
+	stream := "POST /a HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\nX-Trailer: 1\r\n\r\n" +
+		"POST /b HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /c HTTP/1.1\r\nHost: x\r\n\r\n"
+	for size := 1; size < len(stream); size++ {
+		p := http1Parser{maxHead: http.DefaultMaxHeaderBytes}
+		for i := 0; i < len(stream); i += size {
+			if reason, _ := p.feed([]byte(stream[i:min(i+size, len(stream))])); reason != "" {
+				t.Fatalf("Reads of %d bytes: unexpected %s", size, reason)
+			}
+		}
+		if p.requests != 3 || p.state != stateHead {
+			t.Fatalf("Reads of %d bytes: expected 3 requests, got %d", size, p.requests)
+		}
+	}
+
+	p := http1Parser{maxHead: http.DefaultMaxHeaderBytes}
+	valid := "GET / HTTP/1.1\r\nHost: x\r\n\r\n"
+	reason, start := p.feed([]byte(valid + "GET / HTTP/1.1\r\nHost: x\nX: y\r\n\r\n"))
+	if reason != "bare_lf" || start != len(valid) {
+		t.Errorf("Expected bare_lf in the request at %d, got %q at %d", len(valid), reason, start)
+	}
+}
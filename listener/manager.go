@@ -0,0 +1,353 @@
+package listener
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// ServeFunc serves the connections of a listener until it is closed.
+type ServeFunc func(ln *Listener) error
+
+// Spec describes a listener.
+type Spec struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+	// Server names the server of the listener's connections, as
+	// registered with Manager.Handle, e.g. "http" or "https".
+	Server string `json:"server"`
+	Family Family `json:"family,omitempty"`
+}
+
+// Manager owns the accept loops of the proxy's listeners. It binds them,
+// serves each with the server of its kind, counts accepted connections,
+// accept errors and failed TLS handshakes per listener, and adds and
+// removes listeners while running.
+//
+// Listeners added with Bind, such as those set by flags, stay for the life
+// of the process; those set with Apply are reconciled on every call, e.g.
+// when a listener file is reloaded.
+type Manager struct {
+	// Wrap, if set, wraps every listener once bound, e.g. in a Reaper.
+	Wrap func(spec Spec, ln net.Listener) net.Listener
+	// OnHandshakeError, if set, is called with every failed TLS handshake.
+	OnHandshakeError func(addr string, err error)
+	// Source, if set, returns the listeners Reload applies.
+	Source func() ([]Spec, error)
+
+	mu        sync.Mutex
+	servers   map[string]ServeFunc
+	listeners map[string]*Listener
+	errs      chan<- error
+}
+
+// Listener is a listener owned by a Manager.
+type Listener struct {
+	net.Listener
+	Spec Spec
+
+	manager *Manager
+	static  bool
+	started time.Time
+	closing atomic.Bool
+
+	accepts, acceptErrors, handshakeFailures atomic.Int64
+}
+
+func NewManager() *Manager {
+	return &Manager{servers: make(map[string]ServeFunc), listeners: make(map[string]*Listener)}
+}
+
+// Handle registers the server of listeners whose Spec.Server is name.
+func (m *Manager) Handle(name string, serve ServeFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.servers[name] = serve
+}
+
+// Bind binds a listener kept for the life of the process without serving
+// it yet, so every listener can be bound before any is served.
+func (m *Manager) Bind(spec Spec) (*Listener, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.check(spec); err != nil {
+		return nil, err
+	}
+	l, err := m.bind(spec)
+	if err != nil {
+		return nil, err
+	}
+	l.static = true
+	m.listeners[spec.Name] = l
+	return l, nil
+}
+
+// check validates spec. Callers must hold m.mu.
+func (m *Manager) check(spec Spec) error {
+	if spec.Name == "" || spec.Addr == "" {
+		return errors.New("listener name and addr are required")
+	}
+	if _, ok := m.servers[spec.Server]; !ok {
+		return fmt.Errorf("listener %s: unknown server %q", spec.Name, spec.Server)
+	}
+	if _, err := ParseFamily(string(spec.Family)); err != nil {
+		return fmt.Errorf("listener %s: %v", spec.Name, err)
+	}
+	return nil
+}
+
+func (m *Manager) bind(spec Spec) (*Listener, error) {
+	family, _ := ParseFamily(string(spec.Family))
+	ln, err := Listen(spec.Addr, family)
+	if err != nil {
+		return nil, err
+	}
+	if m.Wrap != nil {
+		ln = m.Wrap(spec, ln)
+	}
+	return &Listener{Listener: ln, Spec: spec, manager: m}, nil
+}
+
+// Start serves the bound listeners and those applied later. An error
+// serving a listener set by Bind is sent to errs, as the process cannot
+// run without it; the errors of other listeners are logged.
+func (m *Manager) Start(errs chan<- error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errs = errs
+	for _, l := range m.listeners {
+		m.serve(l)
+	}
+}
+
+// serve starts the accept loop of l. Callers must hold m.mu.
+func (m *Manager) serve(l *Listener) {
+	serve := m.servers[l.Spec.Server]
+	l.started = time.Now()
+	go func() {
+		log.Printf("Starting listener %s (%s) on %s", l.Spec.Name, l.Spec.Server, l.Addr())
+		err := serve(l)
+		switch {
+		case l.closing.Load() || errors.Is(err, http.ErrServerClosed):
+		case l.static && m.errs != nil:
+			m.errs <- err
+		default:
+			log.Printf("Listener %s on %s stopped: %v", l.Spec.Name, l.Addr(), err)
+		}
+	}()
+}
+
+// Apply makes the listeners not set by Bind match specs: new ones are bound
+// and served, removed ones closed, and changed ones replaced. Connections
+// already accepted by a closed listener are served to completion. If any
+// listener cannot be bound, nothing else changes.
+func (m *Manager) Apply(specs []Spec) (added, removed []string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	desired := make(map[string]Spec)
+	for _, spec := range specs {
+		if err := m.check(spec); err != nil {
+			return nil, nil, err
+		}
+		if _, dup := desired[spec.Name]; dup {
+			return nil, nil, fmt.Errorf("listener %s is declared twice", spec.Name)
+		}
+		if l := m.listeners[spec.Name]; l != nil && l.static {
+			return nil, nil, fmt.Errorf("listener %s is set by flags", spec.Name)
+		}
+		desired[spec.Name] = spec
+	}
+
+	var stale []*Listener
+	for name, l := range m.listeners {
+		if spec, ok := desired[name]; !l.static && (!ok || spec != l.Spec) {
+			stale = append(stale, l)
+		}
+	}
+	// A changed listener keeping its address must release it first, so
+	// it is gone even if another listener then fails to bind.
+	var fresh, released []*Listener
+	for _, spec := range specs {
+		if l := m.listeners[spec.Name]; l != nil && l.Spec == spec {
+			continue
+		}
+		if old := m.listeners[spec.Name]; old != nil && (old.Spec.Addr == spec.Addr || old.Addr().String() == spec.Addr) {
+			old.closing.Store(true)
+			old.Close()
+			released = append(released, old)
+		}
+		l, err := m.bind(spec)
+		if err != nil {
+			for _, l := range fresh {
+				l.closing.Store(true)
+				l.Close()
+			}
+			for _, l := range released {
+				delete(m.listeners, l.Spec.Name)
+			}
+			return nil, nil, fmt.Errorf("listener %s: %v", spec.Name, err)
+		}
+		fresh = append(fresh, l)
+	}
+
+	for _, l := range stale {
+		l.closing.Store(true)
+		l.Close()
+		delete(m.listeners, l.Spec.Name)
+		removed = append(removed, l.Spec.Name)
+	}
+	for _, l := range fresh {
+		m.listeners[l.Spec.Name] = l
+		added = append(added, l.Spec.Name)
+		if m.errs != nil {
+			m.serve(l)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed, nil
+}
+
+// Reload applies the listeners returned by Source.
+func (m *Manager) Reload() (added, removed []string, err error) {
+	if m.Source == nil {
+		return nil, nil, errors.New("no listener source configured")
+	}
+	specs, err := m.Source()
+	if err != nil {
+		return nil, nil, err
+	}
+	added, removed, err = m.Apply(specs)
+	if err == nil && len(added)+len(removed) > 0 {
+		log.Printf("Reloaded listeners: added %v, removed %v", added, removed)
+	}
+	return added, removed, err
+}
+
+// LoadSpecs returns a listener source reading a JSON array of Specs from
+// file.
+func LoadSpecs(file string) func() ([]Spec, error) {
+	return func() ([]Spec, error) {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read listeners: %v", err)
+		}
+		var specs []Spec
+		if err := json.Unmarshal(data, &specs); err != nil {
+			return nil, fmt.Errorf("failed to parse listeners: %v", err)
+		}
+		return specs, nil
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		if !l.closing.Load() && !errors.Is(err, net.ErrClosed) {
+			l.acceptErrors.Add(1)
+		}
+		return nil, err
+	}
+	l.accepts.Add(1)
+	return conn, nil
+}
+
+// HandshakeFailed counts a failed TLS handshake of a connection accepted
+// by l.
+func (l *Listener) HandshakeFailed(conn net.Conn, err error) {
+	l.handshakeFailures.Add(1)
+	if l.manager.OnHandshakeError != nil {
+		l.manager.OnHandshakeError(conn.RemoteAddr().String(), err)
+	}
+}
+
+// ListenerStats are the counts of one listener.
+type ListenerStats struct {
+	Spec
+	// Static is set for listeners kept for the life of the process.
+	Static            bool      `json:"static"`
+	Started           time.Time `json:"started"`
+	Accepts           int64     `json:"accepts"`
+	AcceptErrors      int64     `json:"accept_errors"`
+	HandshakeFailures int64     `json:"handshake_failures"`
+}
+
+// Stats returns the counts of every listener, by name.
+func (m *Manager) Stats() []ListenerStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make([]ListenerStats, 0, len(m.listeners))
+	for _, l := range m.listeners {
+		spec := l.Spec
+		spec.Addr = l.Addr().String()
+		stats = append(stats, ListenerStats{
+			Spec:              spec,
+			Static:            l.static,
+			Started:           l.started,
+			Accepts:           l.accepts.Load(),
+			AcceptErrors:      l.acceptErrors.Load(),
+			HandshakeFailures: l.handshakeFailures.Load(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// AdminHandler serves the listeners:
+//
+//	GET /          per-listener counts as JSON
+//	GET /metrics   the same in Prometheus text format
+//	POST /reload   apply the listeners of Source
+func (m *Manager) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, m.Stats())
+	})
+	mux.HandleFunc("POST /reload", func(w http.ResponseWriter, r *http.Request) {
+		if m.Source == nil {
+			admin.WriteError(w, http.StatusNotFound, errors.New("no listener file configured"))
+			return
+		}
+		added, removed, err := m.Reload()
+		if err != nil {
+			admin.WriteError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, map[string][]string{"added": nonNil(added), "removed": nonNil(removed)})
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		all := m.Stats()
+		var b strings.Builder
+		metric := func(name, help string, value func(ListenerStats) int64) {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+			for _, s := range all {
+				fmt.Fprintf(&b, "%s{listener=%q,server=%q,addr=%q} %d\n", name, s.Name, s.Server, s.Addr, value(s))
+			}
+		}
+		metric("proxy_listener_accepts_total", "Connections accepted.", func(s ListenerStats) int64 { return s.Accepts })
+		metric("proxy_listener_accept_errors_total", "Failed accepts.", func(s ListenerStats) int64 { return s.AcceptErrors })
+		metric("proxy_listener_tls_handshake_failures_total", "Failed TLS handshakes.", func(s ListenerStats) int64 { return s.HandshakeFailures })
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(b.String()))
+	})
+	return mux
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,153 @@
+package listener
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testManager(t *testing.T) *Manager {
+	m := NewManager()
+	m.Handle("echo", func(ln *Listener) error {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return err
+			}
+			conn.Write([]byte(ln.Spec.Name))
+			conn.Close()
+		}
+	})
+	t.Cleanup(func() {
+		m.Apply(nil)
+		for _, l := range m.listeners {
+			l.closing.Store(true)
+			l.Close()
+		}
+	})
+	return m
+}
+
+func readFrom(t *testing.T, addr string) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64)
+	n, _ := conn.Read(buf)
+	return string(buf[:n])
+}
+
+func statsOf(m *Manager, name string) (ListenerStats, bool) {
+	for _, s := range m.Stats() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return ListenerStats{}, false
+}
+
+func TestManagerServesAndCountsListeners(t *testing.T) {
+	m := testManager(t)
+	static, err := m.Bind(Spec{Name: "main", Addr: "127.0.0.1:0", Server: "echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	m.Start(errs)
+	added, _, err := m.Apply([]Spec{{Name: "extra", Addr: "127.0.0.1:0", Server: "echo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0] != "extra" {
+		t.Fatalf("Expected extra to be added, got %v", added)
+	}
+
+	if got := readFrom(t, static.Addr().String()); got != "main" {
+		t.Errorf("Expected main to serve, got %q", got)
+	}
+	extra, _ := statsOf(m, "extra")
+	for range 2 {
+		if got := readFrom(t, extra.Addr); got != "extra" {
+			t.Errorf("Expected extra to serve, got %q", got)
+		}
+	}
+	if s, _ := statsOf(m, "main"); s.Accepts != 1 || !s.Static {
+		t.Errorf("Expected 1 accept on static main, got %+v", s)
+	}
+	if s, _ := statsOf(m, "extra"); s.Accepts != 2 || s.Static {
+		t.Errorf("Expected 2 accepts on extra, got %+v", s)
+	}
+
+	rec := httptest.NewRecorder()
+	m.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `proxy_listener_accepts_total{listener="extra",server="echo",addr="` + extra.Addr + `"} 2`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("Expected %s in metrics, got:\n%s", want, rec.Body.String())
+	}
+}
+
+func TestManagerApplyReconcilesListeners(t *testing.T) {
+	m := testManager(t)
+	if _, err := m.Bind(Spec{Name: "main", Addr: "127.0.0.1:0", Server: "echo"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Start(make(chan error, 1))
+	if _, _, err := m.Apply([]Spec{{Name: "a", Addr: "127.0.0.1:0", Server: "echo"}, {Name: "b", Addr: "127.0.0.1:0", Server: "echo"}}); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := statsOf(m, "a")
+
+	// Keeping a unchanged and dropping b leaves a bound where it was.
+	_, removed, err := m.Apply([]Spec{{Name: "a", Addr: "127.0.0.1:0", Server: "echo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "b" {
+		t.Errorf("Expected b to be removed, got %v", removed)
+	}
+	if s, _ := statsOf(m, "a"); s.Addr != a.Addr {
+		t.Errorf("Expected a to stay on %s, got %s", a.Addr, s.Addr)
+	}
+	if _, ok := statsOf(m, "main"); !ok {
+		t.Error("Expected the static listener to survive Apply")
+	}
+
+	// Moving a to the address it holds replaces it in place.
+	added, removed, err := m.Apply([]Spec{{Name: "a", Addr: a.Addr, Server: "echo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || len(removed) != 1 {
+		t.Errorf("Expected a to be replaced, got added %v removed %v", added, removed)
+	}
+	if got := readFrom(t, a.Addr); got != "a" {
+		t.Errorf("Expected the new a to serve, got %q", got)
+	}
+}
+
+func TestManagerApplyRejectsInvalidListeners(t *testing.T) {
+	m := testManager(t)
+	if _, err := m.Bind(Spec{Name: "main", Addr: "127.0.0.1:0", Server: "echo"}); err != nil {
+		t.Fatal(err)
+	}
+	main, _ := statsOf(m, "main")
+	for _, specs := range [][]Spec{
+		{{Name: "x", Addr: "127.0.0.1:0", Server: "unknown"}},
+		{{Name: "main", Addr: "127.0.0.1:0", Server: "echo"}},
+		{{Name: "x", Addr: "127.0.0.1:0", Server: "echo"}, {Name: "x", Addr: "127.0.0.1:0", Server: "echo"}},
+		{{Name: "x", Addr: "127.0.0.1:0", Server: "echo"}, {Name: "y", Addr: main.Addr, Server: "echo"}},
+	} {
+		if _, _, err := m.Apply(specs); err == nil {
+			t.Errorf("Expected %+v to be rejected", specs)
+		}
+		if len(m.Stats()) != 1 {
+			t.Errorf("Expected nothing to change after rejecting %+v, got %+v", specs, m.Stats())
+		}
+	}
+}
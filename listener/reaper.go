@@ -0,0 +1,251 @@
+package listener
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// Reaper counts the traffic of a listener's connections and closes those
+// idle for longer than Idle. Unlike http.Server.IdleTimeout it also covers
+// connections net/http no longer manages, such as WebSockets, CONNECT
+// tunnels and forwarded TCP streams, and connections stuck mid-request.
+type Reaper struct {
+	// Name identifies the listener in stats and logs.
+	Name string
+	// Idle is how long a connection may go without reading or writing a
+	// byte; zero only counts traffic.
+	Idle time.Duration
+
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+
+	accepted, reaped  atomic.Int64
+	bytesIn, bytesOut atomic.Int64
+}
+
+func NewReaper(name string, idle time.Duration) *Reaper {
+	return &Reaper{Name: name, Idle: idle, conns: make(map[*trackedConn]struct{})}
+}
+
+// Listener returns ln with its connections tracked by the reaper.
+func (r *Reaper) Listener(ln net.Listener) net.Listener {
+	return &reapedListener{Listener: ln, reaper: r}
+}
+
+type reapedListener struct {
+	net.Listener
+	reaper *Reaper
+}
+
+func (l *reapedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.reaper.track(conn), nil
+}
+
+func (r *Reaper) track(conn net.Conn) net.Conn {
+	c := &trackedConn{Conn: conn, reaper: r, opened: time.Now()}
+	c.last.Store(c.opened.UnixNano())
+	r.accepted.Add(1)
+	r.mu.Lock()
+	r.conns[c] = struct{}{}
+	r.mu.Unlock()
+	return c
+}
+
+// Run closes idle connections until ctx is done.
+func (r *Reaper) Run(ctx context.Context) {
+	if r.Idle <= 0 {
+		return
+	}
+	ticker := time.NewTicker(max(r.Idle/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n := r.Reap(now); n > 0 {
+				log.Printf("Closed %d %s connections idle for over %v", n, r.Name, r.Idle)
+			}
+		}
+	}
+}
+
+// Reap closes the connections idle at now and returns how many it closed.
+func (r *Reaper) Reap(now time.Time) int {
+	if r.Idle <= 0 {
+		return 0
+	}
+	cutoff := now.Add(-r.Idle).UnixNano()
+	var idle []*trackedConn
+	r.mu.Lock()
+	for c := range r.conns {
+		if c.last.Load() < cutoff {
+			idle = append(idle, c)
+		}
+	}
+	r.mu.Unlock()
+	for _, c := range idle {
+		c.reaped.Store(true)
+		c.Close()
+	}
+	r.reaped.Add(int64(len(idle)))
+	return len(idle)
+}
+
+// ReaperStats are the connections of a listener.
+type ReaperStats struct {
+	Listener string `json:"listener"`
+	// IdleTimeout is the reaper's threshold; empty when it only counts.
+	IdleTimeout string `json:"idle_timeout,omitempty"`
+	Open        int    `json:"open"`
+	Accepted    int64  `json:"accepted"`
+	Reaped      int64  `json:"reaped"`
+	BytesIn     int64  `json:"bytes_in"`
+	BytesOut    int64  `json:"bytes_out"`
+	// Idlest are the open connections that have gone longest without
+	// traffic, at most ten.
+	Idlest []ConnActivity `json:"idlest"`
+}
+
+// ConnActivity is the traffic of one connection.
+type ConnActivity struct {
+	Remote   string    `json:"remote"`
+	Opened   time.Time `json:"opened"`
+	LastSeen time.Time `json:"last_seen"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+}
+
+// Stats returns the listener's connection counts.
+func (r *Reaper) Stats() ReaperStats {
+	s := ReaperStats{
+		Listener: r.Name,
+		Accepted: r.accepted.Load(),
+		Reaped:   r.reaped.Load(),
+		BytesIn:  r.bytesIn.Load(),
+		BytesOut: r.bytesOut.Load(),
+		Idlest:   []ConnActivity{},
+	}
+	if r.Idle > 0 {
+		s.IdleTimeout = r.Idle.String()
+	}
+	r.mu.Lock()
+	s.Open = len(r.conns)
+	for c := range r.conns {
+		s.Idlest = append(s.Idlest, ConnActivity{
+			Remote:   c.RemoteAddr().String(),
+			Opened:   c.opened,
+			LastSeen: time.Unix(0, c.last.Load()),
+			BytesIn:  c.in.Load(),
+			BytesOut: c.out.Load(),
+		})
+	}
+	r.mu.Unlock()
+	sort.Slice(s.Idlest, func(i, j int) bool { return s.Idlest[i].LastSeen.Before(s.Idlest[j].LastSeen) })
+	if len(s.Idlest) > 10 {
+		s.Idlest = s.Idlest[:10]
+	}
+	return s
+}
+
+// ReaperHandler serves the connection counts of reapers:
+//
+//	GET /          per-listener counts as JSON
+//	GET /metrics   the same in Prometheus text format
+func ReaperHandler(reapers ...*Reaper) http.Handler {
+	stats := func() []ReaperStats {
+		all := make([]ReaperStats, len(reapers))
+		for i, r := range reapers {
+			all[i] = r.Stats()
+		}
+		return all
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, stats())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		all := stats()
+		var b strings.Builder
+		metric := func(name, typ, help string, value func(ReaperStats) int64) {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+			for _, s := range all {
+				fmt.Fprintf(&b, "%s{listener=%q} %d\n", name, s.Listener, value(s))
+			}
+		}
+		metric("proxy_connections_open", "gauge", "Open connections.", func(s ReaperStats) int64 { return int64(s.Open) })
+		metric("proxy_connections_accepted_total", "counter", "Accepted connections.", func(s ReaperStats) int64 { return s.Accepted })
+		metric("proxy_connections_reaped_total", "counter", "Connections closed for being idle.", func(s ReaperStats) int64 { return s.Reaped })
+		metric("proxy_connection_bytes_in_total", "counter", "Bytes read from connections.", func(s ReaperStats) int64 { return s.BytesIn })
+		metric("proxy_connection_bytes_out_total", "counter", "Bytes written to connections.", func(s ReaperStats) int64 { return s.BytesOut })
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(b.String()))
+	})
+	return mux
+}
+
+// trackedConn records when a connection last carried traffic.
+type trackedConn struct {
+	net.Conn
+	reaper *Reaper
+	opened time.Time
+
+	// last is the time of the last read or write in Unix nanoseconds.
+	last    atomic.Int64
+	in, out atomic.Int64
+	reaped  atomic.Bool
+	close   sync.Once
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.last.Store(time.Now().UnixNano())
+		c.in.Add(int64(n))
+		c.reaper.bytesIn.Add(int64(n))
+	}
+	if err != nil && c.reaped.Load() {
+		err = fmt.Errorf("connection idle for over %v: %w", c.reaper.Idle, err)
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.last.Store(time.Now().UnixNano())
+		c.out.Add(int64(n))
+		c.reaper.bytesOut.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.close.Do(func() {
+		c.reaper.mu.Lock()
+		delete(c.reaper.conns, c)
+		c.reaper.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+func (c *trackedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package listener
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReaperClosesIdleConnections(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reaper := NewReaper("test", time.Minute)
+	ln := reaper.Listener(inner)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	idle, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	<-accepted
+	busy, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	busyServer := <-accepted
+
+	// The busy connection carries traffic just before the cutoff.
+	now := time.Now().Add(time.Minute + time.Second)
+	busy.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(busyServer, buf); err != nil {
+		t.Fatal(err)
+	}
+	busyServer.(*trackedConn).last.Store(now.Add(-time.Second).UnixNano())
+
+	if n := reaper.Reap(now); n != 1 {
+		t.Fatalf("Expected one connection to be reaped, got %d", n)
+	}
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := idle.Read(buf); err != io.EOF {
+		t.Errorf("Expected the idle connection to be closed, got %v", err)
+	}
+	s := reaper.Stats()
+	if s.Open != 1 || s.Accepted != 2 || s.Reaped != 1 || s.BytesIn != 5 || len(s.Idlest) != 1 || s.Idlest[0].BytesIn != 5 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+}
@@ -0,0 +1,241 @@
+package listener
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SmugglingVector is a request exercising a known request smuggling
+// technique, or a well-formed control a server must keep accepting.
+type SmugglingVector struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Request returns the bytes sent, for the Host header host.
+	Request func(host string) string `json:"-"`
+	// Responses is how many responses a conforming server sends, none of
+	// them 400; 0 means the request must be refused with a 4xx or 5xx
+	// status, or the connection closed, and nothing smuggled answered.
+	Responses int `json:"-"`
+}
+
+// SmugglingVectors are the requests of the smuggling self-test.
+var SmugglingVectors = []SmugglingVector{
+	{
+		Name:        "control",
+		Description: "well-formed request",
+		Request:     literal("GET / HTTP/1.1\r\nHost: {host}\r\nConnection: close\r\n\r\n"),
+		Responses:   1,
+	},
+	{
+		Name:        "control_pipelined",
+		Description: "two well-formed requests sent together",
+		Request:     literal("GET / HTTP/1.1\r\nHost: {host}\r\n\r\nGET / HTTP/1.1\r\nHost: {host}\r\nConnection: close\r\n\r\n"),
+		Responses:   2,
+	},
+	{
+		Name:        "control_chunked",
+		Description: "well-formed chunked request",
+		Request:     literal("POST / HTTP/1.1\r\nHost: {host}\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n3;ext=1\r\nabc\r\n0\r\n\r\n"),
+		Responses:   1,
+	},
+	{
+		Name:        "cl_te",
+		Description: "Content-Length covering a request after the chunked body's end (CL.TE)",
+		Request:     terminatedChunked("HTTP/1.1", "Content-Length: %d\r\nTransfer-Encoding: chunked\r\n"),
+	},
+	{
+		Name:        "te_cl",
+		Description: "chunked body holding a request past a short Content-Length (TE.CL)",
+		Request: func(host string) string {
+			return fmt.Sprintf("POST / HTTP/1.1\r\nHost: %s\r\nTransfer-Encoding: chunked\r\nContent-Length: 4\r\nConnection: close\r\n\r\n%x\r\n%s\r\n0\r\n\r\n",
+				host, len(smuggled(host)), smuggled(host))
+		},
+	},
+	{
+		Name:        "te_duplicate",
+		Description: "two Transfer-Encoding headers",
+		Request:     terminatedChunked("HTTP/1.1", "Transfer-Encoding: chunked\r\nTransfer-Encoding: identity\r\nContent-Length: %d\r\n"),
+	},
+	{
+		Name:        "te_space_before_colon",
+		Description: "whitespace between Transfer-Encoding and the colon",
+		Request:     terminatedChunked("HTTP/1.1", "Transfer-Encoding : chunked\r\nContent-Length: %d\r\n"),
+	},
+	{
+		Name:        "te_obfuscated",
+		Description: "Transfer-Encoding of xchunked",
+		Request:     terminatedChunked("HTTP/1.1", "Transfer-Encoding: xchunked\r\nContent-Length: %d\r\n"),
+	},
+	{
+		Name:        "te_list",
+		Description: "Transfer-Encoding of chunked, identity",
+		Request:     terminatedChunked("HTTP/1.1", "Transfer-Encoding: chunked, identity\r\nContent-Length: %d\r\n"),
+	},
+	{
+		Name:        "te_obs_fold",
+		Description: "Transfer-Encoding folded onto a continuation line",
+		Request:     terminatedChunked("HTTP/1.1", "X-Padding: x\r\n Transfer-Encoding: chunked\r\nContent-Length: %d\r\n"),
+	},
+	{
+		Name:        "te_http10",
+		Description: "Transfer-Encoding on an HTTP/1.0 request",
+		Request:     terminatedChunked("HTTP/1.0", "Transfer-Encoding: chunked\r\nContent-Length: %d\r\n"),
+	},
+	{
+		Name:        "bare_lf",
+		Description: "header line ending in LF without CR",
+		Request:     terminatedChunked("HTTP/1.1", "X-Padding: x\nTransfer-Encoding: chunked\r\nContent-Length: %d\r\n"),
+	},
+	{
+		Name:        "cl_duplicate",
+		Description: "two differing Content-Length headers",
+		Request: func(host string) string {
+			return fmt.Sprintf("POST / HTTP/1.1\r\nHost: %s\r\nContent-Length: 0\r\nContent-Length: %d\r\n\r\n%s", host, len(smuggled(host)), smuggled(host))
+		},
+	},
+	{
+		Name:        "cl_sign",
+		Description: "Content-Length with a plus sign",
+		Request: func(host string) string {
+			return fmt.Sprintf("POST / HTTP/1.1\r\nHost: %s\r\nContent-Length: +%d\r\n\r\n%s", host, len(smuggled(host)), smuggled(host))
+		},
+	},
+	{
+		Name:        "chunk_bare_lf",
+		Description: "chunk size line ending in LF without CR",
+		Request:     literal("POST / HTTP/1.1\r\nHost: {host}\r\nTransfer-Encoding: chunked\r\n\r\n3\nabc\r\n0\r\n\r\n" + smuggled("{host}")),
+	},
+	{
+		Name:        "chunk_size_overflow",
+		Description: "chunk size overflowing 64 bits",
+		Request:     literal("POST / HTTP/1.1\r\nHost: {host}\r\nTransfer-Encoding: chunked\r\n\r\n10000000000000003\r\nabc\r\n0\r\n\r\n" + smuggled("{host}")),
+	},
+	{
+		Name:        "header_nul",
+		Description: "NUL byte in a header value",
+		Request:     literal("GET / HTTP/1.1\r\nHost: {host}\r\nX-Padding: a\x00b\r\n\r\n" + smuggled("{host}")),
+	},
+}
+
+// smuggled is the request hidden in the body of another, answered only by
+// a server splitting the stream where the vector wants it to.
+func smuggled(host string) string {
+	return "GET /smuggled HTTP/1.1\r\nHost: " + host + "\r\nConnection: close\r\n\r\n"
+}
+
+// literal sends raw with {host} replaced.
+func literal(raw string) func(host string) string {
+	return func(host string) string {
+		return strings.ReplaceAll(raw, "{host}", host)
+	}
+}
+
+// terminatedChunked sends a POST with headers, formatted with the length
+// of its body: an empty chunked body followed by a smuggled request.
+func terminatedChunked(version, headers string) func(host string) string {
+	return func(host string) string {
+		body := "0\r\n\r\n" + smuggled(host)
+		return fmt.Sprintf("POST / %s\r\nHost: %s\r\n", version, host) + fmt.Sprintf(headers, len(body)) + "\r\n" + body
+	}
+}
+
+// SmugglingResult is how a server handled a vector.
+type SmugglingResult struct {
+	SmugglingVector
+	Pass bool `json:"pass"`
+	// Statuses are the statuses of the responses received, in order.
+	Statuses []int  `json:"statuses"`
+	Detail   string `json:"detail"`
+}
+
+// SmugglingReport is the outcome of the smuggling self-test.
+type SmugglingReport struct {
+	// Strict reports whether the tested listener checks request framing;
+	// see HTTP1Guard.
+	Strict  bool              `json:"strict"`
+	Passed  int               `json:"passed"`
+	Failed  int               `json:"failed"`
+	Results []SmugglingResult `json:"results"`
+}
+
+// smugglingTimeout bounds the wait for the responses to one vector.
+const smugglingTimeout = 3 * time.Second
+
+// SmugglingTest sends every one of SmugglingVectors on a connection of its
+// own from dial, with host as the Host header, and checks the responses.
+func SmugglingTest(ctx context.Context, dial func(ctx context.Context) (net.Conn, error), host string) SmugglingReport {
+	report := SmugglingReport{Results: []SmugglingResult{}}
+	for _, v := range SmugglingVectors {
+		res := runVector(ctx, dial, host, v)
+		if res.Pass {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report
+}
+
+func runVector(ctx context.Context, dial func(ctx context.Context) (net.Conn, error), host string, v SmugglingVector) SmugglingResult {
+	res := SmugglingResult{SmugglingVector: v, Statuses: []int{}}
+	conn, err := dial(ctx)
+	if err != nil {
+		res.Detail = fmt.Sprintf("failed to connect: %v", err)
+		return res
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(smugglingTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	if _, err := io.WriteString(conn, v.Request(host)); err != nil {
+		res.Detail = fmt.Sprintf("failed to send: %v", err)
+		return res
+	}
+	br := bufio.NewReader(conn)
+	waiting := false
+	for {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			var ne net.Error
+			waiting = errors.As(err, &ne) && ne.Timeout()
+			break
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		res.Statuses = append(res.Statuses, resp.StatusCode)
+	}
+
+	badRequest := false
+	for _, status := range res.Statuses {
+		badRequest = badRequest || status == http.StatusBadRequest
+	}
+	switch {
+	case v.Responses > 0 && badRequest:
+		res.Detail = "well-formed request refused"
+	case v.Responses > 0 && len(res.Statuses) != v.Responses:
+		res.Detail = fmt.Sprintf("expected %d responses, got %d", v.Responses, len(res.Statuses))
+	case v.Responses > 0:
+		res.Pass, res.Detail = true, "accepted"
+	case len(res.Statuses) > 1:
+		res.Detail = fmt.Sprintf("smuggled request answered: got %d responses", len(res.Statuses))
+	case len(res.Statuses) == 0 && waiting:
+		res.Detail = "no response: the server is waiting for more of the request"
+	case len(res.Statuses) == 0:
+		res.Pass, res.Detail = true, "connection closed without a response"
+	case res.Statuses[0] >= 400:
+		res.Pass, res.Detail = true, fmt.Sprintf("refused with %d", res.Statuses[0])
+	default:
+		res.Detail = fmt.Sprintf("ambiguous request accepted with %d", res.Statuses[0])
+	}
+	return res
+}
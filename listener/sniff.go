@@ -0,0 +1,170 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sync"
+	"time"
+)
+
+type Protocol int
+
+const (
+	ProtoTLS Protocol = iota
+	ProtoHTTP
+	ProtoSSH
+)
+
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "), []byte("DELETE "),
+	[]byte("PATCH "), []byte("OPTIONS "), []byte("CONNECT "), []byte("TRACE "), []byte("PRI "),
+}
+
+// Mux sniffs the first bytes of every connection accepted on an inner
+// listener and hands it to the listener registered for its protocol, so
+// SSH, plain HTTP and TLS can share a single port. Connections of an
+// unrecognised or unregistered protocol are closed.
+type Mux struct {
+	inner       net.Listener
+	ReadTimeout time.Duration
+
+	mu        sync.RWMutex
+	listeners map[Protocol]*subListener
+}
+
+func NewMux(inner net.Listener) *Mux {
+	return &Mux{
+		inner:       inner,
+		ReadTimeout: 5 * time.Second,
+		listeners:   make(map[Protocol]*subListener),
+	}
+}
+
+// Match returns a listener yielding the connections sniffed as p. The
+// returned connections replay the sniffed bytes.
+func (m *Mux) Match(p Protocol) net.Listener {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.listeners[p]; ok {
+		return l
+	}
+	l := &subListener{addr: m.inner.Addr(), conns: make(chan net.Conn), done: make(chan struct{})}
+	m.listeners[p] = l
+	return l
+}
+
+// Serve accepts connections until the inner listener fails or is closed.
+func (m *Mux) Serve() error {
+	defer m.closeAll()
+	for {
+		conn, err := m.inner.Accept()
+		if err != nil {
+			return err
+		}
+		go m.dispatch(conn)
+	}
+}
+
+func (m *Mux) Close() error {
+	return m.inner.Close()
+}
+
+func (m *Mux) dispatch(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(m.ReadTimeout))
+	br := bufio.NewReader(conn)
+	p, ok := sniff(br)
+	conn.SetReadDeadline(time.Time{})
+
+	m.mu.RLock()
+	l, registered := m.listeners[p]
+	m.mu.RUnlock()
+	if !ok || !registered {
+		conn.Close()
+		return
+	}
+	l.push(&sniffedConn{Conn: conn, r: br})
+}
+
+func sniff(br *bufio.Reader) (Protocol, bool) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return 0, false
+	}
+	// TLS records start with the handshake content type.
+	if first[0] == 0x16 {
+		return ProtoTLS, true
+	}
+
+	prefix, _ := br.Peek(8)
+	if bytes.HasPrefix(prefix, []byte("SSH-")) {
+		return ProtoSSH, true
+	}
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(prefix, method) {
+			return ProtoHTTP, true
+		}
+	}
+	return 0, false
+}
+
+// ForwardTCP accepts connections from l and proxies each to backendAddr.
+func ForwardTCP(l net.Listener, backendAddr string) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go ProxyTCP(conn, backendAddr)
+	}
+}
+
+func (m *Mux) closeAll() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, l := range m.listeners {
+		l.Close()
+	}
+}
+
+type sniffedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+type subListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *subListener) push(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *subListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *subListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *subListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,72 @@
+package listener
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	backend := echoBackend(t)
+	defer backend.Close()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	m := NewMux(inner)
+	defer m.Close()
+
+	tlsListener := tls.NewListener(m.Match(ProtoTLS), testTLSConfig(t))
+	httpListener := m.Match(ProtoHTTP)
+	go ForwardTCP(m.Match(ProtoSSH), backend.Addr().String())
+	go m.Serve()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Write([]byte("tls"))
+		} else {
+			w.Write([]byte("plain"))
+		}
+	})
+	go http.Serve(tlsListener, handler)
+	go http.Serve(httpListener, handler)
+
+	addr := inner.Addr().String()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	for scheme, want := range map[string]string{"https": "tls", "http": "plain"} {
+		resp, err := client.Get(scheme + "://" + addr)
+		if err != nil {
+			t.Fatalf("%s request failed: %v", scheme, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("Expected %s, got %s", want, body)
+		}
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("SSH-2.0-test\r\n"))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "SSH-2.0-test") {
+		t.Errorf("Expected SSH banner echoed, got %q (%v)", line, err)
+	}
+}
+
+func TestSniffUnknownProtocol(t *testing.T) {
+	if _, ok := sniff(bufio.NewReader(strings.NewReader("\x00\x01garbage"))); ok {
+		t.Error("Expected unknown protocol")
+	}
+}
@@ -2,23 +2,137 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/kirtansoni/reverse-proxy-go/access"
+	"github.com/kirtansoni/reverse-proxy-go/accesslog"
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"github.com/kirtansoni/reverse-proxy-go/checkpoint"
+	"github.com/kirtansoni/reverse-proxy-go/classify"
+	"github.com/kirtansoni/reverse-proxy-go/clients"
+	"github.com/kirtansoni/reverse-proxy-go/errorpages"
+	"github.com/kirtansoni/reverse-proxy-go/featureflags"
+	"github.com/kirtansoni/reverse-proxy-go/forward"
+	"github.com/kirtansoni/reverse-proxy-go/har"
+	"github.com/kirtansoni/reverse-proxy-go/hostcheck"
+	"github.com/kirtansoni/reverse-proxy-go/listener"
 	"github.com/kirtansoni/reverse-proxy-go/proxy"
+	"github.com/kirtansoni/reverse-proxy-go/sitefiles"
+	"github.com/kirtansoni/reverse-proxy-go/ratelimit"
+	"github.com/kirtansoni/reverse-proxy-go/secrets"
+	"github.com/kirtansoni/reverse-proxy-go/shortlinks"
+	"github.com/kirtansoni/reverse-proxy-go/shutdown"
+	"github.com/kirtansoni/reverse-proxy-go/ssl"
+	"github.com/kirtansoni/reverse-proxy-go/static"
+	"github.com/kirtansoni/reverse-proxy-go/sxg"
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 )
 
 var (
 	httpAddr  = flag.String("http", ":80", "HTTP address")
 	httpsAddr = flag.String("https", ":443", "HTTPS address")
+	httpFamily  = flag.String("http-family", "auto", "IP versions of the HTTP listener: auto, ipv4, ipv6 or dual")
+	httpsFamily = flag.String("https-family", "auto", "IP versions of the HTTPS listener: auto, ipv4, ipv6 or dual")
+	adminFamily = flag.String("admin-family", "auto", "IP versions of the admin listener: auto, ipv4, ipv6 or dual")
 	domain    = flag.String("domain", "", "Domain name (required)")
 	certDir   = flag.String("certdir", "./certs", "Directory to store Let's Encrypt certificates")
+
+	preflight   = flag.Bool("preflight", true, "Check DNS and CAA records before requesting certificates")
+	publicIPs   = flag.String("public-ip", "", "Comma-separated public addresses of this host; without it, DNS records not matching interface addresses only log a warning")
+	caaIdentity = flag.String("caa-identity", "letsencrypt.org", "CA issuer domain expected in CAA records")
+
+	tlsCert        = flag.String("tls-cert", "", "Comma-separated certificate files; serves static certificates instead of Let's Encrypt")
+	tlsKey         = flag.String("tls-key", "", "Comma-separated private key files matching -tls-cert")
+	tlsDefault     = flag.String("tls-default", "", "Domain of the certificate served for unknown ServerNames (defaults to the first certificate)")
+	sniBlock       = flag.String("sni-block", "", "Refuse TLS handshakes before any certificate lookup: missing (no server name, as when the bare IP is visited, or an IP address as one) or unknown (also names of hosts not routed or -domain)")
+	tlsStrictSNI   = flag.Bool("tls-strict-sni", false, "Fail handshakes whose ServerName matches no certificate")
+	tlsDebug       = flag.Bool("tls-debug", false, "Log and record certificate selection for each handshake")
+	alpnRoutes     = flag.String("alpn-route", "", "Comma-separated proto=host:port routes for custom ALPN protocols on the HTTPS port")
+	muxSSH         = flag.String("mux-ssh", "", "SSH backend host:port; lets SSH and plain HTTP share the HTTPS port")
+	accessPolicy   = flag.String("access-policy", "", "JSON file with API keys and access/rate limit rules")
+	idempotencyTTL = flag.Duration("idempotency-ttl", 0, "Replay responses to retried requests from the same client with the same Idempotency-Key and body for this long (0 disables)")
+	adminAddr      = flag.String("admin", "127.0.0.1:8081", "Admin API address (empty to disable)")
+	adminTLS       = flag.String("admin-tls", "auto", "TLS on admin listeners: auto (on unless the listener is loopback only), on or off")
+	adminDomain    = flag.String("admin-domain", "", "Host name of the admin certificate; with ACME it gets a certificate of its own (default: -domain, or the default -tls-cert)")
+	adminClientCA  = flag.String("admin-client-ca", "", "PEM file of the CAs whose client certificates may use admin listeners that are not loopback only")
+	adminToken     = flag.String("admin-token", "", "Bearer token admin listeners that are not loopback only require, as file:<path>, env:<name> or http://<url>")
+
+	banFile           = flag.String("ban-file", "", "File where IP bans are saved, e.g. ./bans.json (empty keeps them in memory)")
+	ipRate            = flag.Float64("ip-rate", 0, "Requests per second allowed per client IP (0 disables)")
+	ipBurst           = flag.Int("ip-burst", 20, "Burst size for -ip-rate")
+	tarpitConns       = flag.Int("tarpit-conns", 0, "Requests from banned IPs held at once, answered a byte every 10s instead of refused (0 disables)")
+	tarpitDuration    = flag.Duration("tarpit-duration", 5*time.Minute, "How long -tarpit-conns holds each request")
+	canaryWindow      = flag.Duration("canary-window", 0, "Verify route changes for this long and revert them if errors spike (0 disables)")
+	canaryErrorRate   = flag.Float64("canary-max-error-rate", 0.05, "Share of 5xx responses during -canary-window that triggers a revert")
+	canaryMinRequests = flag.Int64("canary-min-requests", 20, "Requests needed during -canary-window before a change can be reverted")
+	transferThreshold = flag.Int64("transfer-threshold", 10<<20, "Track progress of responses larger than this many bytes in the admin API (0 disables)")
+	alertHeaderCount  = flag.Int("alert-header-count", 100, "Alert on requests with more header lines than this (0 disables)")
+	alertHeaderBytes  = flag.Int("alert-header-bytes", 32<<10, "Alert on requests whose headers are larger than this many bytes (0 disables)")
+	alertURLLength    = flag.Int("alert-url-length", 8<<10, "Alert on requests whose URL is longer than this (0 disables)")
+	acmeRetry         = flag.Duration("acme-retry", time.Minute, "Wait after a failed certificate request before retrying the domain; doubles with each failure")
+	acmeRetryMax      = flag.Duration("acme-retry-max", time.Hour, "Longest wait between certificate requests for a failing domain")
+	certCacheMaxHosts = flag.Int("cert-cache-max-hosts", 0, "Most hosts given Let's Encrypt certificates; further hosts are refused (0 for no cap)")
+	acmeRenewBefore   = flag.Duration("acme-renew-before", 0, "How long before expiry Let's Encrypt certificates are renewed (0 means autocert's default of 30 days)")
+	acmeAlertAfter    = flag.Int("acme-alert-after", 3, "Consecutive certificate failures for a domain that raise an alert (0 disables)")
+	acmeDNSWebhook    = flag.String("acme-dns-webhook", "", "URL receiving the DNS-01 challenge records to create and delete as JSON POSTs, for -acme-dns-domains")
+	acmeDNSDomains    = flag.String("acme-dns-domains", "", "Comma-separated names, such as *.example.com, whose certificates are obtained through DNS-01 challenges")
+	hostCheck         = flag.Bool("host-check", false, "Reject requests whose Host is not -domain, a routed host or in -allowed-hosts (DNS rebinding protection)")
+	allowedHosts      = flag.String("allowed-hosts", "", "Comma-separated extra hosts to accept; *.example.com allows subdomains")
+	hostCheckStatus   = flag.Int("host-check-status", http.StatusMisdirectedRequest, "Status returned for rejected hosts")
+	stateFile         = flag.String("state-file", "", "File where the route table is saved and restored from on start, e.g. ./routes.json (empty disables)")
+	storeSpec         = flag.String("store", "", "Shared store for the route table, bans, checkpoints and certificates instead of local files: a directory, bolt:<file>, redis://[:password@]host:port[/db][?prefix=p] or rediss://; file flags then name the keys")
+	websocketGrace    = flag.Duration("websocket-grace", 5*time.Second, "Time WebSocket clients get to answer the close frame on shutdown or service removal")
+	featureFlags      = flag.String("feature-flags", "", "JSON flag file, or base URL of a LaunchDarkly-compatible service, whose flags are passed to backends as X-Flag-* headers")
+	featureFlagsEnv   = flag.String("feature-flags-env", "", "Client-side environment ID for a -feature-flags URL")
+	flagUserHeader    = flag.String("feature-flags-user-header", "X-User-Id", "Header identifying the user flags are evaluated for, unless the access policy knows the API key")
+	siteFiles         = flag.String("site-files", "", "JSON file of robots.txt, favicon and /.well-known/ files or redirects per host, served instead of or merged with the backends'")
+	checkpointFile    = flag.String("checkpoint-file", "", "File where rate limit counters are checkpointed and restored from on start, e.g. ./checkpoint.json (empty disables)")
+	checkpointEvery   = flag.Duration("checkpoint-interval", 30*time.Second, "Time between checkpoints of -checkpoint-file")
+	staticDir         = flag.String("static-dir", "", "Directory of static files served at / instead of the built-in page, preferring .br and .gz siblings")
+	statusPagePath    = flag.String("status-page", "", "Public path of the uptime status page, e.g. /status (empty disables uptime history)")
+	badges            = flag.Bool("badges", false, "Serve public SVG health and latency badges at /badges/<service>.svg")
+	shortLinks        = flag.String("short-links", "", "Path prefix of the built-in short link redirects, e.g. /go/ (empty disables)")
+	shortLinksFile    = flag.String("short-links-file", "./links.json", "File where short links are saved")
+	cachePurgePath    = flag.String("cache-purge-path", "", "Public path prefix of the cache purge webhook for backends, e.g. /.proxy/purge/ (empty disables)")
+	cachePurgeToken   = flag.String("cache-purge-token", "", "Bearer token -cache-purge-path requires, as file:<path>, env:<name> or http://<url>")
+	sxgCert           = flag.String("sxg-cert", "", "Certificate chain with the CanSignHttpExchanges extension; serves signed exchanges of -sxg-paths to clients that accept them")
+	sxgKey            = flag.String("sxg-key", "", "ECDSA P-256 key of -sxg-cert")
+	sxgPaths          = flag.String("sxg-paths", "/", "Comma-separated path prefixes of the built-in page or -static-dir served as signed exchanges")
+	sxgExpiry         = flag.Duration("sxg-expiry", sxg.DefaultExpiry, "Validity of exchange signatures (at most 7 days)")
+	sxgOCSP           = flag.String("sxg-ocsp", "", "DER OCSP response of -sxg-cert, instead of fetching one from its issuer daily")
+	checkHistory      = flag.Int("check-history", proxy.DefaultCheckHistory, "Runs of each synthetic check kept for GET /checks/history")
+	profileSample     = flag.Float64("profile-sample", 0, "Fraction of requests, from 0 to 1, whose time in each middleware and upstream is recorded for GET /profile/")
+	checkWebhook      = flag.String("check-webhook", "", "URL receiving failures and recoveries of synthetic checks as JSON POSTs")
+	eventsWebhook     = flag.String("events-webhook", "", "URL receiving lifecycle events (service changes, health changes, certificates) as JSON POSTs")
+	classifyRequests  = flag.Bool("classify", false, "Tag requests as bot or human, api or web and mobile or desktop in X-Request-Tags, access logs and metrics")
+	classifyRules     = flag.String("classify-rules", "", "JSON file of user agents, paths and hosts extending the built-in -classify rules")
+	countryHeader     = flag.String("country-header", proxy.DefaultCountryHeader, "Request header holding the client's country, set by a CDN or load balancer, for region routes")
+	debugTrusted      = flag.String("debug-trusted", "", "Comma-separated client networks shown the X-Proxy-Backend header of services with debug on (default loopback only)")
+	overrideKey       = flag.String("override-key", "", "File holding the key that signs developer override cookies, created if missing (empty disables overrides)")
+	harDir            = flag.String("har-dir", "", "Directory where traffic recordings started from the admin API are written as HAR files (empty disables recording)")
+	errorLogWindow    = flag.Duration("error-log-window", proxy.DefaultErrorLogWindow, "Window within which identical upstream errors of a service are logged once and then summarized with a count (0 logs every error)")
+	upstreamMesh      = flag.String("upstream-mesh", "", "SOCKS5 server of a userspace WireGuard or Tailscale node, e.g. socks5://127.0.0.1:1055, carrying upstream connections to -upstream-mesh-routes (empty disables)")
+	upstreamMeshRoutes = flag.String("upstream-mesh-routes", proxy.DefaultMeshRoutes, "Comma-separated networks and .domain suffixes of upstream hosts reached through -upstream-mesh")
+	dnsNegativeTTL    = flag.Duration("dns-negative-ttl", proxy.DefaultDNSNegativeTTL, "How long an upstream host name that failed to resolve gets 503 with Retry-After without a new lookup (0 looks up on every request)")
+	listenersFile     = flag.String("listeners", "", "JSON file of extra listeners, [{\"name\", \"addr\", \"server\": \"http|https|admin|forward\", \"family\"}], applied again on SIGHUP or POST /listeners/reload")
+	errorPages        = flag.String("error-pages", "", "Directory of HTML error and maintenance page templates, such as 503.html, 5xx.fr.html or maintenance.html, shown to browsers instead of bare error responses")
+	forwardProxy      = flag.String("forward-proxy", "", "Address of an HTTP and SOCKS5 forward proxy for clients with an -access-policy API key as their password (empty disables)")
+	forwardPrivate    = flag.Bool("forward-allow-private", false, "Let -forward-proxy clients reach private networks (RFC 1918, 100.64.0.0/10 and fc00::/7); loopback, link-local and this host's addresses stay refused")
 	
 
 
@@ -26,7 +140,14 @@ var (
 	writeTimeout    = flag.Duration("write-timeout", 10*time.Second, "Write timeout")
 	idleTimeout     = flag.Duration("idle-timeout", 120*time.Second, "Idle timeout")
 	maxHeaderBytes  = flag.Int("max-header-bytes", 1<<20, "Max header bytes")
+	h2MaxStreams    = flag.Uint("h2-max-streams", 250, "Concurrent HTTP/2 streams allowed per connection")
+	h2StreamBudget  = flag.Int("h2-stream-budget", 2000, "HTTP/2 streams a connection may open per -h2-window before it is closed (0 disables)")
+	h2MaxResets     = flag.Int("h2-max-resets", 200, "HTTP/2 streams a client may cancel per -h2-window before its connection is closed as a rapid reset attack (0 disables)")
+	h2Window        = flag.Duration("h2-window", 10*time.Second, "Window of -h2-stream-budget and -h2-max-resets")
+	strictHTTP1     = flag.Bool("strict-http1", false, "Refuse HTTP/1 requests with ambiguous framing that net/http tolerates, such as both Content-Length and Transfer-Encoding, bare LF line endings or obsolete line folding, to rule out request smuggling")
+	reapIdle        = flag.String("reap-idle", "", "Comma-separated listener=duration thresholds after which connections without traffic are closed, including WebSockets and tunnels, e.g. http=5m,https=15m,forward=1h (listeners: http, https, admin, forward; empty only counts them)")
 	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "Shutdown timeout")
+	shutdownWebhook = flag.String("shutdown-webhook", "", "URL to POST a JSON summary of the run to on shutdown")
 )
 
 func main() {
@@ -42,60 +163,497 @@ func main() {
 	}
 
 
-	proxy := proxy.NewRuntimeMux()
-	
+	runtimeMux := proxy.NewRuntimeMux()
+	runtimeMux.WebSocketGrace = *websocketGrace
+	var shared storage.Store
+	if *storeSpec != "" {
+		s, err := storage.Open(*storeSpec)
+		if err != nil {
+			log.Fatalf("Failed to open -store: %v", err)
+		}
+		shared = s
+	}
+	if *stateFile != "" {
+		runtimeMux.Store, runtimeMux.StateKey = storeFor(shared, *stateFile)
+		if shared != nil {
+			go runtimeMux.Follow(context.Background())
+		}
+	}
+	runtimeMux.CheckWebhook = *checkWebhook
+	runtimeMux.CheckHistorySize = *checkHistory
+	if err := runtimeMux.Profiler.SetSampleRate(*profileSample); err != nil {
+		log.Fatalf("Invalid -profile-sample: %v", err)
+	}
+	if *eventsWebhook != "" {
+		defer runtimeMux.Events.Forward(*eventsWebhook)()
+	}
+	runtimeMux.CountryHeader = *countryHeader
+	trusted, err := proxy.ParseTrustedNetworks(*debugTrusted)
+	if err != nil {
+		log.Fatalf("Failed to parse -debug-trusted: %v", err)
+	}
+	runtimeMux.DebugTrusted = trusted
+	if *statusPagePath != "" {
+		runtimeMux.Uptime = proxy.NewUptimeHistory()
+	}
+	runtimeMux.HeaderAlerts = &proxy.HeaderThresholds{
+		MaxCount:     *alertHeaderCount,
+		MaxBytes:     *alertHeaderBytes,
+		MaxURLLength: *alertURLLength,
+	}
+
+	namespace := proxy.NamespaceOptions{Index: true}
+	var transfers *proxy.TransferTracker
+	if *transferThreshold > 0 {
+		transfers = proxy.NewTransferTracker(*transferThreshold)
+		namespace.Middleware = append(namespace.Middleware, transfers.Middleware)
+	}
+	if *idempotencyTTL > 0 {
+		namespace.Middleware = append(namespace.Middleware, proxy.NewIdempotencyCache(*idempotencyTTL).Middleware)
+	}
+	var engine *access.Engine
+	var flagInjector *featureflags.Injector
+	if *featureFlags != "" {
+		flagInjector = setupFeatureFlags(func(r *http.Request) string {
+			if engine != nil {
+				if id := engine.Identify(r).Identity; id != access.Anonymous {
+					return id
+				}
+			}
+			return r.Header.Get(*flagUserHeader)
+		})
+		namespace.Middleware = append(namespace.Middleware, flagInjector.Middleware)
+	}
+	// Host-routed requests reach the services with their path unchanged.
+	hostOpts := namespace
+	hostOpts.Index = false
+	_, projects := runtimeMux.MountNamespace("/", hostOpts)
 
 	mux := http.NewServeMux()
-	secureHandler := securityHeadersMiddleware(mux)
+	var handler http.Handler = hostRoutingMiddleware(runtimeMux, projects, mux)
+	if *overrideKey != "" {
+		overrides, err := proxy.LoadOverrides(*overrideKey)
+		if err != nil {
+			log.Fatalf("Failed to set up overrides: %v", err)
+		}
+		runtimeMux.Overrides = overrides
+		handler = overrides.Middleware(handler)
+	}
+	if *siteFiles != "" {
+		files, err := sitefiles.Load(*siteFiles)
+		if err != nil {
+			log.Fatalf("Failed to load site files: %v", err)
+		}
+		handler = files.Middleware(handler)
+	}
+	var ipTracker *clients.Tracker
+	if *banFile != "" {
+		ipTracker, err = clients.NewWithStore(storeFor(shared, *banFile))
+	} else {
+		ipTracker, err = clients.New("")
+	}
+	if err != nil {
+		log.Fatalf("Failed to set up client tracking: %v", err)
+	}
+	if shared != nil && *banFile != "" {
+		go ipTracker.Follow(context.Background())
+	}
+	if *ipRate > 0 {
+		ipTracker.Limiter = ratelimit.New(*ipRate, *ipBurst)
+	}
+	if *tarpitConns > 0 {
+		ipTracker.Tarpit = &clients.Tarpit{MaxConns: *tarpitConns, Duration: *tarpitDuration}
+	}
+	// The chain runs in this order unless a service sets its own.
+	proxy.UpstreamErrors = proxy.NewErrorLog(*errorLogWindow)
+	proxy.UpstreamDNS = proxy.NewDNSCache(*dnsNegativeTTL)
+	if shared != nil {
+		proxy.StickySessions.Store = storage.WithPrefix(shared, "sticky/")
+	}
+	if *upstreamMesh != "" {
+		mesh, err := proxy.NewMesh(*upstreamMesh, *upstreamMeshRoutes)
+		if err != nil {
+			log.Fatalf("Invalid -upstream-mesh: %v", err)
+		}
+		proxy.UpstreamMesh = mesh
+		log.Printf("Dialing upstream hosts in %s through %s", *upstreamMeshRoutes, *upstreamMesh)
+	}
+	chain := runtimeMux.NewChain("/projects")
+	chain.Use("security-headers", securityHeadersMiddleware)
+	var pages *errorpages.Pages
+	if *errorPages != "" {
+		if pages, err = errorpages.Load(*errorPages); err != nil {
+			log.Fatalf("Failed to load error pages: %v", err)
+		}
+		chain.Use("error-pages", pages.Middleware)
+	}
+	chain.Use("clients", ipTracker.Middleware)
+	recorder := har.NewRecorder(*harDir)
+	chain.Use("har", recorder.Middleware)
+	var classifier *classify.Classifier
+	if *classifyRequests || *classifyRules != "" {
+		classifier = classify.New(classify.Rules{})
+		if *classifyRules != "" {
+			if classifier, err = classify.Load(*classifyRules); err != nil {
+				log.Fatalf("Failed to set up request classification: %v", err)
+			}
+		}
+		chain.Use("classify", classifier.Middleware)
+	}
+	if *accessPolicy != "" {
+		engine, err = access.LoadPolicy(*accessPolicy)
+		if err != nil {
+			log.Fatalf("Failed to load access policy: %v", err)
+		}
+		chain.Use("auth", engine.Middleware)
+	}
+	handler = chain.Then(handler)
+	requests := proxy.NewRequestTracker()
+	handler = requests.Middleware(handler)
+	if *hostCheck {
+		checker, err := hostcheck.New(append(strings.Split(*allowedHosts, ","), *domain))
+		if err != nil {
+			log.Fatalf("Invalid -allowed-hosts: %v", err)
+		}
+		checker.Allow = runtimeMux.ServesHost
+		checker.Status = *hostCheckStatus
+		handler = checker.Middleware(handler)
+	}
+	var checkpoints *checkpoint.Checkpointer
+	if *checkpointFile != "" {
+		checkpoints = setupCheckpoints(shared, ipTracker, engine, runtimeMux.Uptime)
+		go checkpoints.Run(context.Background(), *checkpointEvery)
+	}
 	
 
-	mux.HandleFunc("/", PortfolioHandler)
-	mux.Handle("/projects/", http.StripPrefix("/projects", proxy.GetMux()))
+	var site http.Handler
+	if *staticDir != "" {
+		site = static.New(os.DirFS(*staticDir))
+	} else {
+		site = PortfolioHandler()
+	}
+	if *sxgCert != "" {
+		site = setupSignedExchanges(mux, site)
+	}
+	mux.Handle("/", site)
+	if *statusPagePath != "" {
+		mux.Handle(*statusPagePath, runtimeMux.StatusPage())
+	}
+	if *badges {
+		mux.Handle("/badges/", http.StripPrefix("/badges", runtimeMux.BadgeHandler()))
+	}
+	var links *shortlinks.Links
+	if *shortLinks != "" {
+		links, err = shortlinks.New(storeFor(shared, *shortLinksFile))
+		if err != nil {
+			log.Fatalf("Failed to set up short links: %v", err)
+		}
+		if shared != nil {
+			go links.Follow(context.Background())
+		}
+		prefix := "/" + strings.Trim(*shortLinks, "/")
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, links))
+	}
+	if *cachePurgePath != "" {
+		source, err := secrets.Parse(*cachePurgeToken)
+		if err != nil {
+			log.Fatalf("Failed to set up the cache purge webhook: %v", err)
+		}
+		prefix := "/" + strings.Trim(*cachePurgePath, "/")
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, runtimeMux.PurgeHandler(secrets.NewCache(source, time.Minute))))
+	}
+	mux.Handle(runtimeMux.MountNamespace("/projects", namespace))
 
-	if err := setupProxies(proxy); err != nil {
-		log.Fatalf("Failed to setup proxies: %v", err)
+	restored, err := runtimeMux.Restore()
+	if err != nil {
+		log.Fatalf("Failed to restore routes: %v", err)
+	}
+	if !restored {
+		if err := setupProxies(runtimeMux); err != nil {
+			log.Fatalf("Failed to setup proxies: %v", err)
+		}
+	}
+	if *canaryWindow > 0 {
+		runtimeMux.Canary = &proxy.CanaryConfig{
+			Window:       *canaryWindow,
+			MaxErrorRate: *canaryErrorRate,
+			MinRequests:  *canaryMinRequests,
+		}
 	}
 
 
-	go proxy.CLI()
+	go runtimeMux.CLI()
+	go runtimeMux.SuspendIdle(context.Background())
+	go runtimeMux.RunChecks(context.Background())
+
+	adminMux := http.NewServeMux()
+	adminMux.Handle("GET /openapi.json", admin.OpenAPIHandler())
+	adminMux.Handle("/state", runtimeMux.StateHandler())
+	adminMux.Handle("POST /transaction", runtimeMux.TransactionHandler())
+	adminMux.Handle("/config/", http.StripPrefix("/config", runtimeMux.AdminHandler()))
+	adminMux.Handle("/scaling/", http.StripPrefix("/scaling", runtimeMux.LoadHandler()))
+	adminMux.Handle("/headers/", http.StripPrefix("/headers", runtimeMux.HeaderStatsHandler()))
+	adminMux.Handle("/checks/", http.StripPrefix("/checks", runtimeMux.ChecksHandler()))
+	adminMux.Handle("/dns/", http.StripPrefix("/dns", proxy.UpstreamDNS.AdminHandler()))
+	adminMux.Handle("/sticky/", http.StripPrefix("/sticky", proxy.StickySessions.AdminHandler()))
+	adminMux.Handle("/middleware/", http.StripPrefix("/middleware", runtimeMux.ChainHandler()))
+	adminMux.Handle("/cache/purge/", http.StripPrefix("/cache/purge", runtimeMux.PurgeHandler(nil)))
+	adminMux.Handle("/profile/", http.StripPrefix("/profile", runtimeMux.Profiler.AdminHandler()))
+	adminMux.Handle("/events/", http.StripPrefix("/events", runtimeMux.Events.Handler()))
+	adminMux.Handle("POST /evaluate", runtimeMux.EvaluateHandler("/projects"))
+	adminMux.Handle("/graph/", http.StripPrefix("/graph", runtimeMux.GraphHandler("/projects", []string{*httpsAddr})))
+	adminMux.Handle("/requests/", http.StripPrefix("/requests", requests.AdminHandler()))
+	adminMux.Handle("/clients/", http.StripPrefix("/clients", ipTracker.AdminHandler()))
+	adminMux.Handle("/har/", http.StripPrefix("/har", recorder.AdminHandler()))
+	if links != nil {
+		adminMux.Handle("/links/", http.StripPrefix("/links", links.AdminHandler()))
+	}
+	if pages != nil {
+		adminMux.Handle("/errors/", http.StripPrefix("/errors", pages.AdminHandler()))
+	}
+	if classifier != nil {
+		adminMux.Handle("/classify/", http.StripPrefix("/classify", classifier.AdminHandler()))
+	}
+	if transfers != nil {
+		adminMux.Handle("/transfers/", http.StripPrefix("/transfers", transfers.AdminHandler()))
+	}
+	if runtimeMux.Overrides != nil {
+		adminMux.Handle("/overrides/", http.StripPrefix("/overrides", runtimeMux.Overrides.AdminHandler()))
+	}
+	if flagInjector != nil {
+		adminMux.Handle("/flags/", http.StripPrefix("/flags", flagInjector.AdminHandler()))
+	}
+
+	whitelist := autocert.HostWhitelist(*domain)
+	var hostPolicy autocert.HostPolicy = func(ctx context.Context, host string) error {
+		if runtimeMux.ServesHost(host) || *adminDomain != "" && host == *adminDomain {
+			return nil
+		}
+		return whitelist(ctx, host)
+	}
+	servedHosts := hostPolicy
+	if *preflight && *tlsCert == "" {
+		hostPolicy = setupPreflight(hostPolicy)
+	}
+	certCache := &ssl.CertCache{Store: storage.NewDir(*certDir), MaxHosts: *certCacheMaxHosts}
+	if shared != nil {
+		certCache.Store = storage.WithPrefix(shared, "certs/")
+	}
+	hostPolicy = certCache.HostPolicy(hostPolicy)
 
 	certManager := &autocert.Manager{
 		Prompt:     autocert.AcceptTOS,
-		HostPolicy: autocert.HostWhitelist(*domain),
-		Cache:      autocert.DirCache(*certDir),
+		HostPolicy: hostPolicy,
+		Cache:      certCache,
 		Email:      "1kirtansoni@gmail.com", 
+		RenewBefore: *acmeRenewBefore,
+	}
+	acmeMonitor := ssl.NewACMEMonitor(certManager, &ssl.RetryPolicy{
+		Initial:    *acmeRetry,
+		Max:        *acmeRetryMax,
+		AlertAfter: *acmeAlertAfter,
+	})
+	acmeMonitor.Events = runtimeMux.Events
+	tlsMetrics := ssl.NewMetrics()
+	adminMux.Handle("/tls/", http.StripPrefix("/tls", tlsMetrics.AdminHandler()))
+
+	var issue proxy.Issuer
+	tlsConfig := acmeMonitor.TLSConfig()
+	if *tlsCert != "" {
+		tlsConfig = setupStaticCerts(adminMux, tlsMetrics)
+	} else {
+		issue = func(ctx context.Context, host string) (time.Time, error) {
+			stats, err := acmeMonitor.Obtain(ctx, host)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if stats.NotAfter == nil {
+				return time.Time{}, fmt.Errorf("certificate for %s has no expiry", host)
+			}
+			return *stats.NotAfter, nil
+		}
+		acmeMonitor.Metrics = tlsMetrics
+		tlsMetrics.Certificates = acmeMonitor.Certificates
+		adminMux.Handle("/acme/", http.StripPrefix("/acme", acmeMonitor.AdminHandler()))
+		adminMux.Handle("/acme/cache/", http.StripPrefix("/acme/cache", certCache.AdminHandler(servedHosts)))
+		if *acmeDNSDomains != "" {
+			if *acmeDNSWebhook == "" {
+				log.Fatal("-acme-dns-domains needs -acme-dns-webhook")
+			}
+			dns01, err := ssl.NewDNS01Issuer(strings.Split(*acmeDNSDomains, ","), &ssl.WebhookProvider{URL: *acmeDNSWebhook}, certManager.Cache)
+			if err != nil {
+				log.Fatalf("Invalid -acme-dns-domains: %v", err)
+			}
+			dns01.Client = &acme.Client{DirectoryURL: certManager.Client.DirectoryURL}
+			dns01.Email = certManager.Email
+			dns01.RenewBefore = *acmeRenewBefore
+			dns01.Events = runtimeMux.Events
+			tlsConfig.GetCertificate = dns01.GetCertificate(tlsConfig.GetCertificate)
+			go dns01.Run(context.Background())
+			adminMux.Handle("/acme/dns01/", http.StripPrefix("/acme/dns01", dns01.AdminHandler()))
+		}
 	}
+	adminMux.Handle("/provision/", http.StripPrefix("/provision", runtimeMux.ProvisionHandler(issue)))
+	// The admin listener shares the certificates but not the SNI policy.
+	adminBaseTLS := tlsConfig
 
-	httpServer := createHTTPServer(*httpAddr, certManager.HTTPHandler(nil))
-	httpsServer := createHTTPSServer(*httpsAddr, secureHandler, certManager)
+	if *sniBlock != "" {
+		if *sniBlock != "missing" && *sniBlock != "unknown" {
+			log.Fatalf("Invalid -sni-block %q: must be missing or unknown", *sniBlock)
+		}
+		sniPolicy := &ssl.SNIPolicy{Unknown: *sniBlock == "unknown", Allow: servedHosts}
+		tlsConfig = sniPolicy.Config(tlsConfig)
+		tlsMetrics.SNI = sniPolicy
+	}
 
-	serverErrors := make(chan error, 2)
-	go func() {
-		log.Printf("Starting HTTP server on %s", *httpAddr)
-		serverErrors <- httpServer.ListenAndServe()
-	}()
+	var conns shutdown.Conns
+	httpServer := createHTTPServer(*httpAddr, acmeMonitor.HTTPHandler(nil))
+	httpServer.ConnState = conns.Track(nil)
+	httpsServer := createHTTPSServer(*httpsAddr, handler, tlsConfig)
+	httpsServer.ConnContext = requests.ConnContext
+	httpsServer.ConnState = conns.Track(ipTracker.ConnState)
+	h2Guard := listener.NewH2Guard(listener.H2Limits{
+		MaxConcurrentStreams: uint32(*h2MaxStreams),
+		MaxRefused:           listener.DefaultH2Limits.MaxRefused,
+		MaxStreams:           *h2StreamBudget,
+		MaxResets:            *h2MaxResets,
+		Window:               *h2Window,
+	})
+	if err := h2Guard.Configure(httpsServer); err != nil {
+		log.Fatal(err)
+	}
+	adminMux.Handle("/h2/", http.StripPrefix("/h2", h2Guard.AdminHandler()))
+	http1Guard := listener.NewHTTP1Guard(*strictHTTP1)
+	http1Guard.Configure(httpServer)
+	http1Guard.Configure(httpsServer)
+	adminMux.Handle("/http1/", http.StripPrefix("/http1", http1Guard.AdminHandler(selfTestDial(*httpsAddr), *domain)))
+	handshakeErrors := ssl.NewHandshakeErrors()
+	httpsServer.ErrorLog = handshakeErrors.ErrorLog(os.Stderr)
+	adminMux.Handle("/tls-errors/", http.StripPrefix("/tls-errors", handshakeErrors.AdminHandler()))
+	adminServer := createHTTPServer(*adminAddr, adminMux)
+	adminCertName := *adminDomain
+	if adminCertName == "" && *tlsCert == "" {
+		adminCertName = *domain
+	}
+	adminServer.TLSConfig = admin.TLSConfig(adminBaseTLS, adminCertName)
+	remoteAdmin := admin.Remote{ClientCA: *adminClientCA, Token: *adminToken, Plaintext: *adminTLS == "off"}
+	// Admin listeners that are not loopback only are served by
+	// remoteAdminServer, or not at all when it is nil.
+	var remoteAdminServer *http.Server
+	if handler, cfg, err := remoteAdmin.Protect(adminMux, adminServer.TLSConfig); err == nil {
+		remoteAdminServer = createHTTPServer(*adminAddr, handler)
+		remoteAdminServer.TLSConfig = cfg
+	} else if !errors.Is(err, admin.ErrUnprotected) {
+		log.Fatalf("Failed to set up admin authentication: %v", err)
+	}
 
-	go func() {
-		log.Printf("Starting HTTPS server on %s", *httpsAddr)
-		serverErrors <- httpsServer.ListenAndServeTLS("", "")
-	}()
+	listeners := listener.NewManager()
+	listeners.OnHandshakeError = func(addr string, err error) {
+		httpsServer.ErrorLog.Printf("http: TLS handshake error from %s: %v", addr, err)
+	}
+	listeners.Handle("http", func(ln *listener.Listener) error { return httpServer.Serve(http1Guard.Listener(ln)) })
+	listeners.Handle("https", func(ln *listener.Listener) error { return serveHTTPS(httpsServer, httpServer, http1Guard, ln) })
+	servers := []string{"http", "https"}
+	if *adminAddr != "" {
+		if *adminTLS != "auto" && *adminTLS != "on" && *adminTLS != "off" {
+			log.Fatalf("Invalid -admin-tls %q: must be auto, on or off", *adminTLS)
+		}
+		if remoteAdmin.Check(*adminAddr) != nil {
+			log.Fatalf("Admin API on %s is reachable from other hosts: set -admin-client-ca or -admin-token", *adminAddr)
+		}
+		listeners.Handle("admin", func(ln *listener.Listener) error {
+			if admin.LoopbackOnly(ln.Spec.Addr) {
+				if *adminTLS == "on" {
+					return adminServer.ServeTLS(ln, "", "")
+				}
+				return adminServer.Serve(ln)
+			}
+			if remoteAdminServer == nil {
+				return fmt.Errorf("admin listener %s on %s is reachable from other hosts: set -admin-client-ca or -admin-token", ln.Spec.Name, ln.Addr())
+			}
+			if *adminTLS != "off" {
+				return remoteAdminServer.ServeTLS(ln, "", "")
+			}
+			log.Printf("WARNING: admin listener %s on %s serves plaintext HTTP", ln.Spec.Name, ln.Addr())
+			return remoteAdminServer.Serve(ln)
+		})
+		servers = append(servers, "admin")
+	}
+	if *forwardProxy != "" {
+		if engine == nil {
+			log.Fatal("-forward-proxy needs an -access-policy with the API keys of its clients")
+		}
+		fwd := forward.New(engine)
+		fwd.AllowPrivate = *forwardPrivate
+		fwd.Log = accesslog.New("forward", accesslog.Config{}, nil)
+		fwd.Middleware = []func(http.Handler) http.Handler{ipTracker.Middleware}
+		listeners.Handle("forward", func(ln *listener.Listener) error { return fwd.Serve(ln) })
+		servers = append(servers, "forward")
+	}
+	reapers, err := setupReapers(listeners, servers)
+	if err != nil {
+		log.Fatalf("Invalid -reap-idle: %v", err)
+	}
+	adminMux.Handle("/conns/", http.StripPrefix("/conns", listener.ReaperHandler(reapers...)))
+	adminMux.Handle("/listeners/", http.StripPrefix("/listeners", listeners.AdminHandler()))
+
+	// Bind every listener before serving so a bad address or a missing IP
+	// version stops the start instead of surfacing later.
+	mustListen(listeners, "http", *httpAddr, *httpFamily)
+	mustListen(listeners, "https", *httpsAddr, *httpsFamily)
+	if *adminAddr != "" {
+		mustListen(listeners, "admin", *adminAddr, *adminFamily)
+	}
+	if *forwardProxy != "" {
+		defer mustListen(listeners, "forward", *forwardProxy, "auto").Close()
+	}
+	if *listenersFile != "" {
+		listeners.Source = listener.LoadSpecs(*listenersFile)
+		if _, _, err := listeners.Reload(); err != nil {
+			log.Fatalf("Failed to start listeners from %s: %v", *listenersFile, err)
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if _, _, err := listeners.Reload(); err != nil {
+					log.Printf("Failed to reload listeners: %v", err)
+				}
+			}
+		}()
+	}
+
+	started := time.Now()
+	serverErrors := make(chan error, 4)
+	listeners.Start(serverErrors)
 
 	// Setup graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	// Wait for shutdown signal or server error
+	report := shutdown.Report{Started: started}
 	select {
 	case err := <-serverErrors:
 		log.Printf("Server error: %v", err)
-	case <-stop:
+		report.Reason = fmt.Sprintf("server error: %v", err)
+	case sig := <-stop:
 		log.Println("Shutdown signal received")
+		report.Reason = "signal: " + sig.String()
 	}
+	report.Connections = conns.Open()
+	report.InFlight = len(requests.Requests())
 
 	// Graceful shutdown
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
 	defer cancel()
 
+	// Hijacked WebSocket connections are not covered by Server.Shutdown
+	wsCtx, wsCancel := context.WithTimeout(shutdownCtx, *websocketGrace)
+	defer wsCancel()
+	wsDone := make(chan error, 1)
+	go func() { wsDone <- runtimeMux.CloseWebSockets(wsCtx) }()
+
 	// Shutdown both servers
 	if err := httpServer.Shutdown(shutdownCtx); err != nil {
 		log.Printf("HTTP server shutdown error: %v", err)
@@ -103,10 +661,96 @@ func main() {
 	if err := httpsServer.Shutdown(shutdownCtx); err != nil {
 		log.Printf("HTTPS server shutdown error: %v", err)
 	}
+	if err := adminServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Admin server shutdown error: %v", err)
+	}
+	if remoteAdminServer != nil {
+		if err := remoteAdminServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Admin server shutdown error: %v", err)
+		}
+	}
+
+	if err := <-wsDone; err != nil {
+		log.Printf("WebSocket shutdown error: %v", err)
+	}
+
+	if checkpoints != nil {
+		if err := checkpoints.Save(); err != nil {
+			log.Printf("Checkpoint failed: %v", err)
+		}
+	}
+
+	// Connections still open have outlived the shutdown timeout and are cut
+	// when the process exits.
+	report.Aborted = conns.Open()
+	report.Drained = max(0, report.Connections-report.Aborted)
+	report.Requests = requests.Served()
+	report.Services = make(map[string]int64)
+	for _, l := range runtimeMux.Load() {
+		report.Services[l.Service] = l.Requests
+	}
+	report.Stopped = time.Now()
+	report.Uptime = report.Stopped.Sub(started).Round(time.Second).String()
+	log.Printf("Shutdown report: %s", report)
+	if *shutdownWebhook != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := report.Post(ctx, *shutdownWebhook); err != nil {
+			log.Println(err)
+		}
+		cancel()
+	}
 
 	log.Println("Servers shutdown completed")
 }
 
+// setupFeatureFlags loads the -feature-flags source, evaluating flags for
+// the users returned by user.
+func setupFeatureFlags(user func(*http.Request) string) *featureflags.Injector {
+	var source featureflags.Source
+	var err error
+	if strings.HasPrefix(*featureFlags, "http://") || strings.HasPrefix(*featureFlags, "https://") {
+		source, err = featureflags.NewRemote(*featureFlags, *featureFlagsEnv)
+	} else {
+		source, err = featureflags.NewFile(*featureFlags)
+	}
+	if err != nil {
+		log.Fatalf("Failed to set up feature flags: %v", err)
+	}
+	return &featureflags.Injector{Source: source, User: user}
+}
+
+// storeFor returns where the state named by a file flag is kept: in the
+// file itself, or under its base name in the shared store if there is one.
+func storeFor(shared storage.Store, file string) (storage.Store, string) {
+	if shared != nil {
+		return shared, filepath.Base(file)
+	}
+	return storage.NewDir(filepath.Dir(file)), filepath.Base(file)
+}
+
+// setupCheckpoints restores the rate limit counters saved by the last run
+// and registers them for checkpointing.
+func setupCheckpoints(shared storage.Store, ipTracker *clients.Tracker, engine *access.Engine, uptime *proxy.UptimeHistory) *checkpoint.Checkpointer {
+	checkpoints, err := checkpoint.NewWithStore(storeFor(shared, *checkpointFile))
+	if err != nil {
+		log.Fatalf("Failed to load checkpoint: %v", err)
+	}
+	if err := checkpoint.Register(checkpoints, "clients", ipTracker.Snapshot, ipTracker.Restore); err != nil {
+		log.Printf("Starting with fresh client counters: %v", err)
+	}
+	if engine != nil {
+		if err := checkpoint.Register(checkpoints, "access", engine.Snapshot, engine.Restore); err != nil {
+			log.Printf("Starting with fresh access policy limits: %v", err)
+		}
+	}
+	if uptime != nil {
+		if err := checkpoint.Register(checkpoints, "uptime", uptime.Snapshot, uptime.Restore); err != nil {
+			log.Printf("Starting with no uptime history: %v", err)
+		}
+	}
+	return checkpoints
+}
+
 func createHTTPServer(addr string, handler http.Handler) *http.Server {
 	return &http.Server{
 		Addr:              addr,
@@ -119,7 +763,7 @@ func createHTTPServer(addr string, handler http.Handler) *http.Server {
 	}
 }
 
-func createHTTPSServer(addr string, handler http.Handler, certManager *autocert.Manager) *http.Server {
+func createHTTPSServer(addr string, handler http.Handler, tlsConfig *tls.Config) *http.Server {
 	return &http.Server{
 		Addr:              addr,
 		Handler:           handler,
@@ -128,8 +772,189 @@ func createHTTPSServer(addr string, handler http.Handler, certManager *autocert.
 		IdleTimeout:       *idleTimeout,
 		MaxHeaderBytes:    *maxHeaderBytes,
 		ReadHeaderTimeout: *readTimeout,
-		TLSConfig:        certManager.TLSConfig(),
+		TLSConfig:        tlsConfig,
+	}
+}
+
+// setupReapers has the listeners of servers tracked by reapers closing
+// connections idle for the -reap-idle thresholds, or only counting their
+// traffic. Listeners added later share the reaper of their server.
+func setupReapers(listeners *listener.Manager, servers []string) ([]*listener.Reaper, error) {
+	idle := make(map[string]time.Duration)
+	for _, entry := range strings.Split(*reapIdle, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || !slices.Contains([]string{"http", "https", "admin", "forward"}, name) {
+			return nil, fmt.Errorf("expected listener=duration with a listener of http, https, admin or forward, got %q", entry)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold for %s: %v", name, err)
+		}
+		idle[name] = d
+	}
+	var reapers []*listener.Reaper
+	byServer := make(map[string]*listener.Reaper)
+	for _, name := range servers {
+		r := listener.NewReaper(name, idle[name])
+		go r.Run(context.Background())
+		reapers = append(reapers, r)
+		byServer[name] = r
+	}
+	listeners.Wrap = func(spec listener.Spec, ln net.Listener) net.Listener {
+		return byServer[spec.Server].Listener(ln)
 	}
+	return reapers, nil
+}
+
+// mustListen binds the listener of server on addr in the IP versions named
+// by family, exiting with a clear message if it cannot.
+func mustListen(listeners *listener.Manager, server, addr, family string) *listener.Listener {
+	f, err := listener.ParseFamily(family)
+	if err != nil {
+		log.Fatalf("Invalid -%s-family: %v", server, err)
+	}
+	ln, err := listeners.Bind(listener.Spec{Name: server, Addr: addr, Server: server, Family: f})
+	if err != nil {
+		log.Fatalf("Failed to start %s listener: %v", server, err)
+	}
+	return ln
+}
+
+// serveHTTPS serves srv on ln directly unless the port is shared: with
+// -mux-ssh the port also accepts SSH (forwarded) and plain HTTP (served by
+// plain), and with ALPN routes TLS is terminated by a router that hands HTTP
+// connections to srv. HTTP/1 requests of both are checked by guard.
+func serveHTTPS(srv, plain *http.Server, guard *listener.HTTP1Guard, ln *listener.Listener) error {
+	var tlsLn net.Listener = ln
+	if *muxSSH != "" {
+		m := listener.NewMux(ln)
+		go listener.ForwardTCP(m.Match(listener.ProtoSSH), *muxSSH)
+		go plain.Serve(guard.Listener(m.Match(listener.ProtoHTTP)))
+		tlsLn = m.Match(listener.ProtoTLS)
+		go m.Serve()
+	}
+
+	// The router terminates TLS even without routes, so failed handshakes
+	// are counted against the listener.
+	router := listener.NewALPNRouter(tlsLn, srv.TLSConfig)
+	if *readTimeout > 0 {
+		router.HandshakeTimeout = *readTimeout
+	}
+	router.OnHandshakeError = ln.HandshakeFailed
+	if *alpnRoutes != "" {
+		for _, route := range strings.Split(*alpnRoutes, ",") {
+			proto, backend, ok := strings.Cut(route, "=")
+			if !ok {
+				return fmt.Errorf("invalid ALPN route %q, expected proto=host:port", route)
+			}
+			router.HandleTCP(proto, backend)
+		}
+	}
+	return srv.Serve(guard.Listener(router))
+}
+
+// selfTestDial connects to the HTTPS listener on addr over loopback, for
+// the smuggling self-test.
+func selfTestDial(addr string) func(ctx context.Context) (net.Conn, error) {
+	host, port, _ := net.SplitHostPort(addr)
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	dialer := &tls.Dialer{Config: &tls.Config{
+		ServerName: *domain,
+		NextProtos: []string{"http/1.1"},
+		// The proxy tests its own listener; its certificate is not what
+		// is checked.
+		InsecureSkipVerify: true,
+	}}
+	return func(ctx context.Context) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	}
+}
+
+// setupSignedExchanges serves the -sxg-cert chain and returns site signing
+// the -sxg-paths responses.
+func setupSignedExchanges(mux *http.ServeMux, site http.Handler) http.Handler {
+	signer, err := sxg.Load(*sxgCert, *sxgKey)
+	if err != nil {
+		log.Fatalf("Failed to set up signed exchanges: %v", err)
+	}
+	const certPath = "/.well-known/sxg-cert"
+	signer.CertURL = "https://" + *domain + certPath
+	signer.ValidityURL = "https://" + *domain + "/.well-known/sxg-validity"
+	signer.Expiry = *sxgExpiry
+	signer.Paths = strings.Split(*sxgPaths, ",")
+	if *sxgOCSP != "" {
+		der, err := os.ReadFile(*sxgOCSP)
+		if err != nil {
+			log.Fatalf("Failed to read -sxg-ocsp: %v", err)
+		}
+		signer.SetOCSP(der)
+	} else {
+		go signer.RunOCSP(context.Background(), 24*time.Hour)
+	}
+	mux.Handle(certPath, signer.CertChainHandler())
+	return signer.Middleware(site)
+}
+
+func setupStaticCerts(adminMux *http.ServeMux, metrics *ssl.Metrics) *tls.Config {
+	certFiles := strings.Split(*tlsCert, ",")
+	keyFiles := strings.Split(*tlsKey, ",")
+	if len(certFiles) != len(keyFiles) {
+		log.Fatal("-tls-cert and -tls-key must list the same number of files")
+	}
+
+	staticCerts, err := ssl.NewCertManager(certFiles[0], keyFiles[0])
+	if err != nil {
+		log.Fatalf("Failed to load TLS certificate: %v", err)
+	}
+	for i := 1; i < len(certFiles); i++ {
+		if err := staticCerts.AddCertificate(certFiles[i], keyFiles[i]); err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+	}
+
+	if *tlsDefault != "" {
+		if err := staticCerts.SetDefault(*tlsDefault); err != nil {
+			log.Fatalf("Failed to set default certificate: %v", err)
+		}
+	}
+	if *tlsStrictSNI {
+		staticCerts.SetDefault("")
+	}
+	if *tlsDebug {
+		staticCerts.EnableDebug(0)
+	}
+	staticCerts.Metrics = metrics
+	metrics.Certificates = staticCerts.Certificates
+
+	adminMux.Handle("/certs/", http.StripPrefix("/certs", staticCerts.AdminHandler()))
+	return staticCerts.GetTLSConfig()
+}
+
+func setupPreflight(next autocert.HostPolicy) autocert.HostPolicy {
+	var ips []net.IP
+	for _, s := range strings.Split(*publicIPs, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			log.Fatalf("Invalid public IP: %s", s)
+		}
+		ips = append(ips, ip)
+	}
+
+	pf := ssl.NewPreflight(*caaIdentity, ips)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := pf.Check(ctx, *domain); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return pf.HostPolicy(next)
 }
 
 func setupProxies(proxyProjects *proxy.RuntimeMux) error {
@@ -153,6 +978,18 @@ func setupProxies(proxyProjects *proxy.RuntimeMux) error {
 	return nil
 }
 
+// hostRoutingMiddleware sends requests for hosts routed to a service
+// straight to the proxy, whatever their path.
+func hostRoutingMiddleware(routes *proxy.RuntimeMux, projects, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if routes.ServesHost(r.Host) {
+			projects.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func securityHeadersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -1,8 +1,29 @@
 package main
 
-import "net/http"
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"net/http"
 
+	"github.com/kirtansoni/reverse-proxy-go/static"
+)
 
-func PortfolioHandler(w http.ResponseWriter, r *http.Request){
-	w.Write([]byte("hello"))
-}
\ No newline at end of file
+// portfolioFiles are the assets of the built-in page, compiled into the
+// binary so the root site needs no files on disk.
+//
+//go:embed portfolio
+var portfolioFiles embed.FS
+
+// PortfolioHandler serves the built-in page with fingerprinted assets.
+func PortfolioHandler() http.Handler {
+	fsys, err := fs.Sub(portfolioFiles, "portfolio")
+	if err != nil {
+		log.Fatalf("Failed to load the portfolio: %v", err)
+	}
+	h, err := static.Fingerprinted(fsys)
+	if err != nil {
+		log.Fatalf("Failed to load the portfolio: %v", err)
+	}
+	return h
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// Well-known annotation keys. Any other key is kept as a free-form note.
+const (
+	AnnotationOwner        = "owner"
+	AnnotationTicket       = "ticket"
+	AnnotationDecommission = "decommission" // date, YYYY-MM-DD
+	// AnnotationStatusPage set to "hidden" leaves a service off the
+	// public status page.
+	AnnotationStatusPage = "status"
+)
+
+// maxChangelog is how many changes are kept per service.
+const maxChangelog = 50
+
+// SetAnnotation sets a note on the service, or removes it if value is empty.
+func (s *Service) SetAnnotation(key, value string) error {
+	if key == "" {
+		return fmt.Errorf("annotation key is required")
+	}
+	if key == AnnotationDecommission && value != "" {
+		if _, err := time.Parse(time.DateOnly, value); err != nil {
+			return fmt.Errorf("decommission date must be YYYY-MM-DD: %v", err)
+		}
+	}
+	annotations := make(map[string]string, len(s.Annotations)+1)
+	for k, v := range s.Annotations {
+		annotations[k] = v
+	}
+	if value == "" {
+		delete(annotations, key)
+	} else {
+		annotations[key] = value
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	s.Annotations = annotations
+	return nil
+}
+
+// pastDecommission reports whether the service has outlived its expected
+// decommission date.
+func (s *Service) pastDecommission(now time.Time) bool {
+	date, err := time.Parse(time.DateOnly, s.Annotations[AnnotationDecommission])
+	return err == nil && now.After(date.AddDate(0, 0, 1))
+}
+
+// ServiceChange is a change to a service, with the revision it made.
+type ServiceChange struct {
+	Revision int       `json:"revision"`
+	Time     time.Time `json:"time"`
+	Reason   string    `json:"reason"`
+	Change
+}
+
+// logChanges adds the changes between two consecutive revisions to the
+// changelogs of the services involved. Callers must hold ph's lock.
+func (ph *RuntimeMux) logChanges(prev, cur Revision) {
+	for _, c := range diffServices(prev.Services, cur.Services) {
+		service := cur.Services[c.Path]
+		if service == nil {
+			service = prev.Services[c.Path]
+		}
+		log := append(ph.changelog[service.Name], ServiceChange{cur.ID, cur.Time, cur.Reason, c})
+		if len(log) > maxChangelog {
+			log = log[len(log)-maxChangelog:]
+		}
+		ph.changelog[service.Name] = log
+	}
+}
+
+// Changelog returns the changes made to the service called name, oldest
+// first, including changes from before it was removed.
+func (ph *RuntimeMux) Changelog(name string) []ServiceChange {
+	ph.RLock()
+	defer ph.RUnlock()
+	return append([]ServiceChange{}, ph.changelog[name]...)
+}
+
+// changelogHandlers adds the per-service changelog endpoint to the history
+// handler.
+func (ph *RuntimeMux) changelogHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /changelog/{service}", func(w http.ResponseWriter, r *http.Request) {
+		changes := ph.Changelog(r.PathValue("service"))
+		if len(changes) == 0 {
+			admin.WriteError(w, http.StatusNotFound, fmt.Errorf("no changes recorded for %s", r.PathValue("service")))
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, changes)
+	})
+}
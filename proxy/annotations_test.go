@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAnnotationsAndChangelog(t *testing.T) {
+	mux := NewRuntimeMux()
+	api, _ := NewService("api", "/api/", "http://localhost:1")
+	mux.AddProxy(api)
+	other, _ := NewService("other", "/other/", "http://localhost:2")
+	mux.AddProxy(other)
+
+	annotated := *api
+	if err := annotated.SetAnnotation(AnnotationDecommission, "next week"); err == nil {
+		t.Error("Expected an invalid decommission date to be rejected")
+	}
+	annotated.SetAnnotation(AnnotationOwner, "payments")
+	annotated.SetAnnotation(AnnotationDecommission, "2020-01-31")
+	mux.AddProxy(&annotated)
+	if api.Annotations != nil {
+		t.Error("Annotating a copy must not change the original")
+	}
+	if !annotated.pastDecommission(time.Now()) || annotated.pastDecommission(time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC)) {
+		t.Error("Unexpected decommission check")
+	}
+	mux.removeHandler(&annotated)
+
+	w := httptest.NewRecorder()
+	mux.AdminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/changelog/api", nil))
+	var changes []ServiceChange
+	json.Unmarshal(w.Body.Bytes(), &changes)
+	if len(changes) != 3 || changes[0].Op != "added" || changes[1].Op != "changed" || changes[2].Op != "removed" {
+		t.Fatalf("Unexpected changelog: %s", w.Body.String())
+	}
+	var before Service
+	json.Unmarshal(changes[2].Before, &before)
+	if before.Annotations[AnnotationOwner] != "payments" {
+		t.Errorf("Expected annotations in the changelog, got %s", changes[2].Before)
+	}
+
+	w = httptest.NewRecorder()
+	mux.AdminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/changelog/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown service, got %d", w.Code)
+	}
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// BackendHeader names the backend instance that served a response, as the
+// host:port dialed and a short hash of the address it resolved to, e.g.
+// "api.internal:8080; addr=3fa2c1d9". The address is hashed so instances
+// can be told apart without revealing the internal network.
+const BackendHeader = "X-Proxy-Backend"
+
+// ParseTrustedNetworks parses a comma-separated list of CIDR prefixes and
+// addresses, such as "10.0.0.0/8,192.0.2.7".
+func ParseTrustedNetworks(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted address %q: %v", s, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted network %q: %v", s, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// trusted reports whether the client of r may see debugging headers: it
+// connects from DebugTrusted, or from loopback when DebugTrusted is empty.
+func (ph *RuntimeMux) trusted(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	if len(ph.DebugTrusted) == 0 {
+		return addr.IsLoopback()
+	}
+	for _, prefix := range ph.DebugTrusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// withBackendHeader adds BackendHeader to the response of a service with
+// DebugBackend set when the client is trusted.
+func (ph *RuntimeMux) withBackendHeader(service *Service, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	if !service.DebugBackend || !ph.trusted(r) {
+		return w, r
+	}
+	bw := &backendWriter{ResponseWriter: w}
+	trace := &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			bw.mu.Lock()
+			bw.name, bw.addr = hostPort, ""
+			bw.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			bw.mu.Lock()
+			bw.addr = info.Conn.RemoteAddr().String()
+			bw.mu.Unlock()
+		},
+	}
+	return bw, r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+}
+
+// backendWriter sets BackendHeader from the last upstream connection used
+// when the response header is written.
+type backendWriter struct {
+	http.ResponseWriter
+	mu          sync.Mutex
+	name, addr  string
+	wroteHeader bool
+}
+
+func (bw *backendWriter) WriteHeader(code int) {
+	// Informational responses come before the final one, except for a
+	// protocol switch, which is the last header written.
+	if !bw.wroteHeader && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		bw.wroteHeader = true
+		if v := bw.value(); v != "" {
+			bw.Header().Set(BackendHeader, v)
+		}
+	}
+	bw.ResponseWriter.WriteHeader(code)
+}
+
+func (bw *backendWriter) Write(b []byte) (int, error) {
+	if !bw.wroteHeader {
+		bw.WriteHeader(http.StatusOK)
+	}
+	return bw.ResponseWriter.Write(b)
+}
+
+func (bw *backendWriter) Flush() {
+	if !bw.wroteHeader {
+		bw.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(bw.ResponseWriter).Flush()
+}
+
+func (bw *backendWriter) Unwrap() http.ResponseWriter {
+	return bw.ResponseWriter
+}
+
+func (bw *backendWriter) value() string {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	if bw.name == "" {
+		return ""
+	}
+	if bw.addr == "" {
+		return bw.name
+	}
+	sum := sha256.Sum256([]byte(bw.addr))
+	return bw.name + "; addr=" + hex.EncodeToString(sum[:4])
+}
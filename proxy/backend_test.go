@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBackendHeader(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	mux := NewRuntimeMux()
+	service, _ := NewService("api", "/api/", backend.URL)
+	mux.AddProxy(service)
+	serve := func(remoteAddr string) string {
+		r := httptest.NewRequest("GET", "/api/", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Header().Get(BackendHeader)
+	}
+
+	if got := serve("127.0.0.1:5000"); got != "" {
+		t.Errorf("Expected no header while debug is off, got %q", got)
+	}
+	updated := *service
+	updated.DebugBackend = true
+	mux.AddProxy(&updated)
+	got := serve("127.0.0.1:5000")
+	if !strings.HasPrefix(got, strings.TrimPrefix(backend.URL, "http://")+"; addr=") || len(got) != len(backend.URL)-len("http://")+len("; addr=")+8 {
+		t.Errorf("Unexpected backend header %q", got)
+	}
+	if got := serve("203.0.113.7:5000"); got != "" {
+		t.Errorf("Expected no header for an untrusted client, got %q", got)
+	}
+
+	trusted, err := ParseTrustedNetworks("10.0.0.0/8, 203.0.113.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.DebugTrusted = trusted
+	if serve("203.0.113.7:5000") == "" || serve("10.1.2.3:5000") == "" || serve("127.0.0.1:5000") != "" {
+		t.Error("Expected exactly the trusted networks to get the header")
+	}
+	if _, err := ParseTrustedNetworks("10.0.0.0/33"); err == nil {
+		t.Error("Expected an invalid network to be rejected")
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Badge states and their colors.
+var badgeColors = map[string]string{
+	"up":       "#16a34a",
+	"degraded": "#ca8a04",
+	"down":     "#dc2626",
+	"sleeping": "#2563eb",
+	"unknown":  "#6b7280",
+}
+
+// health returns the current state of a service: from its synthetic checks
+// if it has any, otherwise from the share of 5xx answers in the current
+// hour. Callers must hold ph's read lock.
+func (ph *RuntimeMux) health(service *Service) string {
+	if service.coldStart != nil && service.coldStart.state() == StateSleeping {
+		return "sleeping"
+	}
+	if len(service.Checks) > 0 {
+		passing, total := 0, 0
+		ph.checks.mu.Lock()
+		for _, c := range service.Checks {
+			if st := ph.checks.states[service.Name+"/"+c.Name]; st != nil && st.checked {
+				total++
+				if st.result.OK {
+					passing++
+				}
+			}
+		}
+		ph.checks.mu.Unlock()
+		switch {
+		case total == 0:
+			return "unknown"
+		case passing == total:
+			return "up"
+		case passing == 0:
+			return "down"
+		}
+		return "degraded"
+	}
+	if ph.Uptime == nil {
+		return "unknown"
+	}
+	hour := time.Now().UTC().Truncate(time.Hour)
+	ph.Uptime.mu.Lock()
+	defer ph.Uptime.mu.Unlock()
+	s := ph.Uptime.services[service.Name]
+	if s == nil || len(s.Hourly) == 0 || !s.Hourly[len(s.Hourly)-1].Start.Equal(hour) {
+		return "unknown"
+	}
+	a, ok := s.Hourly[len(s.Hourly)-1].Availability()
+	switch {
+	case !ok:
+		return "unknown"
+	case a >= 0.99:
+		return "up"
+	case a >= 0.9:
+		return "degraded"
+	}
+	return "down"
+}
+
+// p95 returns the service's p95 latency over the last minute, or over the
+// last 24 hours if it saw too few requests. Callers must hold ph's read
+// lock.
+func (ph *RuntimeMux) p95(service *Service) time.Duration {
+	latencies, _ := ph.load[service.Path].recent(time.Now())
+	if n := len(latencies); n >= minTimeoutSamples {
+		return latencies[(n*95-1)/100]
+	}
+	if ph.Uptime == nil {
+		return 0
+	}
+	sums := ph.Uptime.Summaries([]string{service.Name}, time.Now())
+	return time.Duration(sums[0].P95Ms24h) * time.Millisecond
+}
+
+func formatLatency(d time.Duration) string {
+	if d >= time.Second {
+		return fmt.Sprintf("%.1f s", d.Seconds())
+	}
+	return fmt.Sprintf("%d ms", d.Milliseconds())
+}
+
+// BadgeHandler serves GET /<service>.svg, an SVG badge showing the health
+// and p95 latency of a service, for embedding in project READMEs.
+func (ph *RuntimeMux) BadgeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".svg")
+		if !ok || r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.NotFound(w, r)
+			return
+		}
+		ph.RLock()
+		var service *Service
+		for _, s := range ph.proxyServers {
+			if s != nil && s.Name == name && s.Annotations[AnnotationStatusPage] != "hidden" {
+				service = s
+			}
+		}
+		if service == nil {
+			ph.RUnlock()
+			http.NotFound(w, r)
+			return
+		}
+		state := ph.health(service)
+		value := state
+		if p95 := ph.p95(service); p95 > 0 && state != "sleeping" && state != "down" {
+			value += " · " + formatLatency(p95)
+		}
+		ph.RUnlock()
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		// Image proxies such as GitHub's honor this, keeping badges current.
+		w.Header().Set("Cache-Control", "max-age=60, must-revalidate")
+		w.Write(badge(name, value, badgeColors[state]))
+	})
+}
+
+// badge renders a flat badge; text widths are estimated for 11px Verdana.
+func badge(label, value, color string) []byte {
+	width := func(s string) int { return len([]rune(s))*7 + 10 }
+	lw, vw := width(label), width(value)
+	label, value = html.EscapeString(label), html.EscapeString(value)
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[3]s: %[4]s">
+<title>%[3]s: %[4]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[6]d" height="20" fill="%[5]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="14">%[3]s</text><text x="%[8]d" y="14">%[4]s</text>
+</g></svg>
+`, lw+vw, lw, label, value, color, vw, lw/2, lw+vw/2))
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/proxy/proxytest"
+)
+
+func TestBadges(t *testing.T) {
+	backend := proxytest.NewFakeBackend(t, "blog")
+	mux := NewRuntimeMux()
+	mux.Uptime = NewUptimeHistory()
+	blog, _ := NewService("blog", "/blog/", backend.URL)
+	shop, _ := NewService("shop", "/shop/", backend.URL)
+	shop.SetChecks([]CheckConfig{{Name: "home", URL: "/", Status: http.StatusTeapot}})
+	quiet, _ := NewService("quiet", "/quiet/", backend.URL)
+	secret, _ := NewService("secret", "/secret/", backend.URL)
+	secret.SetAnnotation(AnnotationStatusPage, "hidden")
+	for _, s := range []*Service{blog, shop, quiet, secret} {
+		mux.AddProxy(s)
+	}
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/blog/", nil))
+	mux.checks.states["shop/home"] = &checkState{}
+	mux.runCheck(context.Background(), shop, shop.Checks[0])
+
+	badge := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		mux.BadgeHandler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code, w.Body.String()
+	}
+	if code, svg := badge("/blog.svg"); code != http.StatusOK || !strings.Contains(svg, "blog: up · ") || !strings.Contains(svg, badgeColors["up"]) {
+		t.Errorf("Unexpected blog badge %d:\n%s", code, svg)
+	}
+	if _, svg := badge("/shop.svg"); !strings.Contains(svg, "shop: down") || !strings.Contains(svg, badgeColors["down"]) {
+		t.Errorf("Expected a failing check to mark shop down:\n%s", svg)
+	}
+	if _, svg := badge("/quiet.svg"); !strings.Contains(svg, "quiet: unknown") {
+		t.Errorf("Expected a service without data to be unknown:\n%s", svg)
+	}
+	for _, path := range []string{"/secret.svg", "/missing.svg", "/blog"} {
+		if code, _ := badge(path); code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s, got %d", path, code)
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheHeader reports whether a response of a cached service was served
+// from the cache: HIT, MISS, or BYPASS for requests never cached.
+const CacheHeader = "X-Cache"
+
+// SurrogateKeyHeader is the response header in which the backend tags a
+// cached response with space-separated keys it can later purge it by; see
+// RuntimeMux.Purge. The header is not passed on to clients.
+const SurrogateKeyHeader = "Surrogate-Key"
+
+const (
+	// DefaultCacheEntries is the number of responses a service keeps when
+	// its CacheConfig sets no MaxEntries.
+	DefaultCacheEntries = 1000
+	// maxCachedBody is the largest response body kept.
+	maxCachedBody = 1 << 20
+)
+
+// cacheableStatus are the statuses whose responses are kept.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// CacheConfig keeps the service's responses to GET requests, so repeated
+// requests for shared content never reach the backend.
+type CacheConfig struct {
+	// TTLMs is how long a response is kept when the backend's
+	// Cache-Control sets no s-maxage or max-age.
+	TTLMs int64 `json:"ttl_ms"`
+	// MaxEntries caps the responses kept; 0 means DefaultCacheEntries.
+	MaxEntries int `json:"max_entries,omitempty"`
+	// Key picks the parts of a request its cached response depends on.
+	Key CacheKey `json:"key,omitempty"`
+}
+
+// CacheKey picks the parts of a request, beyond its host and path, that
+// tell its cached responses apart. Requests differing only in other headers,
+// cookies or query parameters share a response, so anything the backend
+// personalizes on must be listed. A response still varies on the headers in
+// its own Vary header.
+type CacheKey struct {
+	Headers []string `json:"headers,omitempty"`
+	Cookies []string `json:"cookies,omitempty"`
+	// Query lists the query parameters in the key; empty means all of them
+	// but IgnoreQuery.
+	Query       []string `json:"query,omitempty"`
+	IgnoreQuery []string `json:"ignore_query,omitempty"`
+}
+
+// EnableCache caches the service's responses; a TTLMs of 0 turns it off.
+//
+// Responses are not kept when they set cookies, are marked private,
+// no-store or no-cache, or vary on every header. Requests with an
+// Authorization header bypass the cache unless it is one of Key.Headers.
+func (s *Service) EnableCache(cfg CacheConfig) error {
+	if cfg.TTLMs == 0 {
+		s.Cache = nil
+		s.cache = nil
+		s.middlewares = s.without("cache")
+		s.rebuild()
+		return nil
+	}
+	if cfg.TTLMs < 0 || cfg.MaxEntries < 0 {
+		return errors.New("cache ttl_ms and max_entries must not be negative")
+	}
+	if len(cfg.Key.Query) > 0 && len(cfg.Key.IgnoreQuery) > 0 {
+		return errors.New("cache key cannot both list and ignore query parameters")
+	}
+	for _, name := range cfg.Key.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n\t") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	for _, name := range cfg.Key.Cookies {
+		if name == "" || strings.ContainsAny(name, " ;=\r\n\t") {
+			return fmt.Errorf("invalid cookie name %q", name)
+		}
+	}
+	s.Cache = &cfg
+	c := newResponseCache(cfg)
+	s.cache = c
+	s.Use("cache", c.Middleware)
+	return nil
+}
+
+type responseCache struct {
+	ttl        time.Duration
+	maxEntries int
+	headers    []string
+	cookies    []string
+	query      map[string]bool
+	ignore     map[string]bool
+	authorized bool
+
+	mu      sync.Mutex
+	entries map[string][]*cacheEntry
+	count   int
+}
+
+type cacheEntry struct {
+	// vary holds the request's values of the headers the response varies
+	// on.
+	vary   map[string]string
+	status int
+	header http.Header
+	// body is written as is on every hit, so a hit allocates the same few
+	// bytes whatever its size, and holds no pointers for the GC to scan.
+	// Keeping bodies off the heap, in an arena or mmap, would not save
+	// allocations or pause time.
+	body    []byte
+	stored  time.Time
+	expires time.Time
+	// keys are the surrogate keys the backend tagged the response with.
+	keys []string
+}
+
+func newResponseCache(cfg CacheConfig) *responseCache {
+	c := &responseCache{
+		ttl:        time.Duration(cfg.TTLMs) * time.Millisecond,
+		maxEntries: cfg.MaxEntries,
+		cookies:    cfg.Key.Cookies,
+		entries:    make(map[string][]*cacheEntry),
+	}
+	if c.maxEntries == 0 {
+		c.maxEntries = DefaultCacheEntries
+	}
+	for _, name := range cfg.Key.Headers {
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		c.headers = append(c.headers, name)
+		c.authorized = c.authorized || name == "Authorization"
+	}
+	sort.Strings(c.headers)
+	if len(cfg.Key.Query) > 0 {
+		c.query = make(map[string]bool)
+		for _, name := range cfg.Key.Query {
+			c.query[name] = true
+		}
+	}
+	c.ignore = make(map[string]bool)
+	for _, name := range cfg.Key.IgnoreQuery {
+		c.ignore[name] = true
+	}
+	return c
+}
+
+// key returns the cache key of r.
+func (c *responseCache) key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(r.Host))
+	b.WriteString(r.URL.EscapedPath())
+	query := r.URL.Query()
+	for name := range query {
+		if c.ignore[name] || c.query != nil && !c.query[name] {
+			delete(query, name)
+		}
+	}
+	if len(query) > 0 {
+		// Encode sorts the parameters, so their order does not matter.
+		b.WriteString("?" + query.Encode())
+	}
+	for _, name := range c.headers {
+		fmt.Fprintf(&b, "\nh:%s=%q", name, r.Header.Values(name))
+	}
+	for _, name := range c.cookies {
+		value := ""
+		if cookie, err := r.Cookie(name); err == nil {
+			value = cookie.Value
+		}
+		fmt.Fprintf(&b, "\nc:%s=%q", name, value)
+	}
+	return b.String()
+}
+
+func (c *responseCache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.Header.Get("Authorization") != "" && !c.authorized {
+			w.Header().Set(CacheHeader, "BYPASS")
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := c.key(r)
+		now := time.Now()
+		if e := c.lookup(key, r, now); e != nil {
+			for k, v := range e.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Age", strconv.Itoa(int(now.Sub(e.stored).Seconds())))
+			w.Header().Set(CacheHeader, "HIT")
+			w.WriteHeader(e.status)
+			w.Write(e.body)
+			return
+		}
+		w.Header().Set(CacheHeader, "MISS")
+		cw := &cacheWriter{ResponseWriter: w, before: w.Header().Clone()}
+		next.ServeHTTP(cw, r)
+		if e := cw.entry(c.ttl, r); e != nil {
+			c.store(key, e)
+		}
+	})
+}
+
+// lookup returns the fresh entry for key matching r's values of the headers
+// it varies on.
+func (c *responseCache) lookup(key string, r *http.Request, now time.Time) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries[key] {
+		if now.Before(e.expires) && e.matches(r) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (e *cacheEntry) matches(r *http.Request) bool {
+	for name, value := range e.vary {
+		if strings.Join(r.Header.Values(name), ", ") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// store keeps e under key, replacing expired entries and any with the same
+// Vary values, and evicting the oldest entries beyond maxEntries.
+func (c *responseCache) store(key string, e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := c.entries[key][:0]
+	for _, old := range c.entries[key] {
+		if e.stored.Before(old.expires) && !sameVary(old.vary, e.vary) {
+			kept = append(kept, old)
+		}
+	}
+	c.count += len(kept) - len(c.entries[key]) + 1
+	c.entries[key] = append(kept, e)
+	for c.count > c.maxEntries {
+		c.evictOldest()
+	}
+}
+
+// evictOldest drops the entry stored first. Callers must hold c.mu.
+func (c *responseCache) evictOldest() {
+	var oldestKey string
+	var oldest *cacheEntry
+	for key, entries := range c.entries {
+		for _, e := range entries {
+			if oldest == nil || e.stored.Before(oldest.stored) {
+				oldestKey, oldest = key, e
+			}
+		}
+	}
+	entries := c.entries[oldestKey]
+	for i, e := range entries {
+		if e == oldest {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(c.entries, oldestKey)
+	} else {
+		c.entries[oldestKey] = entries
+	}
+	c.count--
+}
+
+// purge drops the entries for which match returns true, given the key they
+// are stored under, and returns how many it dropped.
+func (c *responseCache) purge(match func(key string, e *cacheEntry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	purged := 0
+	for key, entries := range c.entries {
+		kept := entries[:0]
+		for _, e := range entries {
+			if match(key, e) {
+				purged++
+			} else {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == 0 {
+			delete(c.entries, key)
+		} else {
+			c.entries[key] = kept
+		}
+	}
+	c.count -= purged
+	return purged
+}
+
+func sameVary(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheWriter passes a response through while keeping a copy of it and of
+// the headers the backend set.
+type cacheWriter struct {
+	http.ResponseWriter
+	before      http.Header
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        []byte
+	overflow    bool
+	keys        []string
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		cw.status = status
+		h := cw.ResponseWriter.Header()
+		for _, v := range h.Values(SurrogateKeyHeader) {
+			cw.keys = append(cw.keys, strings.Fields(v)...)
+		}
+		h.Del(SurrogateKeyHeader)
+		// Headers added outside the cache, such as response header
+		// templates, are added again to every response.
+		cw.header = make(http.Header)
+		for k, v := range cw.ResponseWriter.Header() {
+			if k != CacheHeader && strings.Join(cw.before[k], "\n") != strings.Join(v, "\n") {
+				cw.header[k] = append([]string(nil), v...)
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.overflow {
+		if len(cw.body)+len(p) > maxCachedBody {
+			cw.overflow = true
+			cw.body = nil
+		} else {
+			cw.body = append(cw.body, p...)
+		}
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *cacheWriter) Flush() {
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// entry returns the response to r as a cache entry, or nil if it may not
+// be kept.
+func (cw *cacheWriter) entry(ttl time.Duration, r *http.Request) *cacheEntry {
+	if !cw.wroteHeader || cw.overflow || !cacheableStatus[cw.status] || r.Context().Err() != nil {
+		return nil
+	}
+	h := cw.header
+	if h.Get("Set-Cookie") != "" || h.Get("Trailer") != "" {
+		return nil
+	}
+	cc := parseCacheControl(h.Values("Cache-Control"))
+	if _, ok := cc["private"]; ok {
+		return nil
+	}
+	if _, ok := cc["no-store"]; ok {
+		return nil
+	}
+	if _, ok := cc["no-cache"]; ok {
+		return nil
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				return nil
+			}
+			ttl = time.Duration(seconds) * time.Second
+			break
+		}
+	}
+
+	e := &cacheEntry{status: cw.status, header: h, body: cw.body, stored: time.Now(), keys: cw.keys}
+	e.expires = e.stored.Add(ttl)
+	for _, v := range cw.ResponseWriter.Header().Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil
+			}
+			if name == "" {
+				continue
+			}
+			if e.vary == nil {
+				e.vary = make(map[string]string)
+			}
+			e.vary[name] = strings.Join(r.Header.Values(name), ", ")
+		}
+	}
+	return e
+}
+
+// parseCacheControl returns the directives of Cache-Control headers, with
+// their lowercase names and unquoted values.
+func parseCacheControl(values []string) map[string]string {
+	cc := make(map[string]string)
+	for _, v := range values {
+		for _, directive := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				cc[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return cc
+}
+
+// cacheKeyArgs parses CLI cache key parts, each one of header:<name>,
+// cookie:<name>, query:<name> or ignore:<name>.
+func cacheKeyArgs(args []string) (CacheKey, error) {
+	var key CacheKey
+	for _, arg := range args {
+		kind, name, ok := strings.Cut(arg, ":")
+		if !ok || name == "" {
+			return CacheKey{}, fmt.Errorf("invalid cache key part %q", arg)
+		}
+		switch kind {
+		case "header":
+			key.Headers = append(key.Headers, name)
+		case "cookie":
+			key.Cookies = append(key.Cookies, name)
+		case "query":
+			key.Query = append(key.Query, name)
+		case "ignore":
+			key.IgnoreQuery = append(key.IgnoreQuery, name)
+		default:
+			return CacheKey{}, fmt.Errorf("invalid cache key part %q", arg)
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/app/session":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "1"})
+		case "/app/private":
+			w.Header().Set("Cache-Control", "private")
+		case "/app/encoded":
+			w.Header().Set("Vary", "Accept-Encoding")
+		}
+		fmt.Fprintf(w, "%d %s lang=%s", calls, r.URL.RawQuery, r.Header.Get("Accept-Language"))
+	}))
+	defer backend.Close()
+
+	service, err := NewService("app", "/app/", backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = service.EnableCache(CacheConfig{TTLMs: 60000, Key: CacheKey{
+		Headers:     []string{"accept-language"},
+		Cookies:     []string{"theme"},
+		IgnoreQuery: []string{"utm_source"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(service)
+	defer proxy.Close()
+
+	get := func(path string, header ...string) (string, string) {
+		req, _ := http.NewRequest("GET", proxy.URL+path, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		res, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body), res.Header.Get(CacheHeader)
+	}
+
+	first, status := get("/app/page?b=2&a=1&utm_source=mail", "Accept-Language", "en")
+	if status != "MISS" {
+		t.Errorf("Expected a miss, got %s", status)
+	}
+	for _, tc := range []struct {
+		name   string
+		path   string
+		header []string
+		hit    bool
+	}{
+		{"same request", "/app/page?b=2&a=1&utm_source=mail", []string{"Accept-Language", "en"}, true},
+		{"reordered and ignored query", "/app/page?a=1&b=2", []string{"Accept-Language", "en", "Cookie", "other=x"}, true},
+		{"other key header", "/app/page?a=1&b=2", []string{"Accept-Language", "de"}, false},
+		{"other key cookie", "/app/page?a=1&b=2", []string{"Accept-Language", "en", "Cookie", "theme=dark"}, false},
+		{"other query", "/app/page?a=1&b=3", []string{"Accept-Language", "en"}, false},
+		{"authorization", "/app/page?a=1&b=2", []string{"Accept-Language", "en", "Authorization", "Bearer x"}, false},
+	} {
+		body, status := get(tc.path, tc.header...)
+		if hit := status == "HIT"; hit != tc.hit || hit && body != first {
+			t.Errorf("%s: got %s with %q", tc.name, status, body)
+		}
+	}
+
+	for _, path := range []string{"/app/session", "/app/private"} {
+		get(path)
+		if _, status := get(path); status != "MISS" {
+			t.Errorf("Expected %s not to be cached, got %s", path, status)
+		}
+	}
+
+	get("/app/encoded", "Accept-Encoding", "gzip")
+	if _, status := get("/app/encoded", "Accept-Encoding", "gzip"); status != "HIT" {
+		t.Errorf("Expected a hit for the same Accept-Encoding, got %s", status)
+	}
+	if _, status := get("/app/encoded", "Accept-Encoding", "br"); status != "MISS" {
+		t.Errorf("Expected a miss for another Accept-Encoding, got %s", status)
+	}
+
+	if err := service.EnableCache(CacheConfig{TTLMs: 1000, Key: CacheKey{Query: []string{"a"}, IgnoreQuery: []string{"b"}}}); err == nil {
+		t.Error("Expected an error for listing and ignoring query parameters")
+	}
+}
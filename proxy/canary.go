@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// CanaryConfig makes every route change provisional: for Window after the
+// change the share of 5xx responses is measured and, if it exceeds
+// MaxErrorRate over at least MinRequests requests, the last verified route
+// table is restored.
+type CanaryConfig struct {
+	Window       time.Duration
+	MaxErrorRate float64
+	MinRequests  int64
+	// OnRevert, if set, is called after an automatic revert, e.g. to alert.
+	OnRevert func(CanaryStatus)
+}
+
+// CanaryStatus describes a verification window.
+type CanaryStatus struct {
+	// Good is the revision restored if verification fails.
+	Good      int       `json:"good_revision"`
+	Candidate int       `json:"candidate_revision"`
+	Deadline  time.Time `json:"deadline"`
+	Requests  int64     `json:"requests"`
+	Errors    int64     `json:"errors"`
+	ErrorRate float64   `json:"error_rate"`
+}
+
+type canary struct {
+	good      int
+	candidate int
+	deadline  time.Time
+	timer     *time.Timer
+	requests  atomic.Int64
+	errors    atomic.Int64
+}
+
+func (c *canary) status() CanaryStatus {
+	s := CanaryStatus{
+		Good:      c.good,
+		Candidate: c.candidate,
+		Deadline:  c.deadline,
+		Requests:  c.requests.Load(),
+		Errors:    c.errors.Load(),
+	}
+	if s.Requests > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Requests)
+	}
+	return s
+}
+
+// watch starts, or restarts, the verification window after a route change.
+// Changes made during a window are verified together against the route
+// table in effect before the first of them. Callers must hold ph's lock.
+func (ph *RuntimeMux) watch() {
+	if ph.Canary == nil || ph.Canary.Window <= 0 || len(ph.history) < 2 {
+		return
+	}
+	good := ph.history[len(ph.history)-2].ID
+	if old := ph.canary.Load(); old != nil {
+		old.timer.Stop()
+		good = old.good
+	}
+	c := &canary{
+		good:      good,
+		candidate: ph.history[len(ph.history)-1].ID,
+		deadline:  time.Now().Add(ph.Canary.Window),
+	}
+	c.timer = time.AfterFunc(ph.Canary.Window, func() { ph.verify(c) })
+	ph.canary.Store(c)
+}
+
+// verify ends a verification window, reverting if the error rate was too
+// high.
+func (ph *RuntimeMux) verify(c *canary) {
+	ph.Lock()
+	defer ph.Unlock()
+	if !ph.canary.CompareAndSwap(c, nil) {
+		return
+	}
+
+	status := c.status()
+	if status.Requests < ph.Canary.MinRequests || status.ErrorRate <= ph.Canary.MaxErrorRate {
+		log.Printf("Route revision %d verified: %d errors in %d requests", status.Candidate, status.Errors, status.Requests)
+		return
+	}
+	if _, err := ph.rollback(c.good, fmt.Sprintf("canary revert of %d", c.candidate)); err != nil {
+		log.Printf("ALERT: route revision %d failed verification but could not be reverted: %v", status.Candidate, err)
+		return
+	}
+	log.Printf("ALERT: route revision %d reverted to %d: error rate %.1f%% (%d of %d requests)",
+		status.Candidate, status.Good, status.ErrorRate*100, status.Errors, status.Requests)
+	if ph.Canary.OnRevert != nil {
+		go ph.Canary.OnRevert(status)
+	}
+}
+
+// CanaryStatus returns the pending verification window, if any.
+func (ph *RuntimeMux) CanaryStatus() (CanaryStatus, bool) {
+	c := ph.canary.Load()
+	if c == nil {
+		return CanaryStatus{}, false
+	}
+	return c.status(), true
+}
+
+// CommitCanary ends the pending verification window early, keeping the
+// current routes. It reports whether there was one.
+func (ph *RuntimeMux) CommitCanary() bool {
+	ph.Lock()
+	defer ph.Unlock()
+	return ph.stopCanary()
+}
+
+// stopCanary discards the pending verification window. Callers must hold
+// ph's lock.
+func (ph *RuntimeMux) stopCanary() bool {
+	c := ph.canary.Swap(nil)
+	if c == nil {
+		return false
+	}
+	c.timer.Stop()
+	return true
+}
+
+// serveCounted serves a request, counting it against the pending
+// verification window if there is one.
+func (ph *RuntimeMux) serveCounted(service *Service, w http.ResponseWriter, r *http.Request) {
+	c := ph.canary.Load()
+	if c == nil {
+		service.ServeHTTP(w, r)
+		return
+	}
+	sw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	service.ServeHTTP(sw, r)
+	c.requests.Add(1)
+	if sw.status >= 500 {
+		c.errors.Add(1)
+	}
+}
+
+func (ph *RuntimeMux) canaryHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /canary", func(w http.ResponseWriter, r *http.Request) {
+		status, ok := ph.CanaryStatus()
+		if !ok {
+			admin.WriteError(w, http.StatusNotFound, errors.New("no change is being verified"))
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, status)
+	})
+	mux.HandleFunc("POST /canary/commit", func(w http.ResponseWriter, r *http.Request) {
+		if !ph.CommitCanary() {
+			admin.WriteError(w, http.StatusNotFound, errors.New("no change is being verified"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader && status >= 200 {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
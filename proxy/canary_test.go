@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCanaryRevert(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("good")) }))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	reverted := make(chan CanaryStatus, 1)
+	mux := NewRuntimeMux()
+	service, _ := NewService("api", "/api/", good.URL)
+	mux.AddProxy(service)
+	mux.Canary = &CanaryConfig{
+		Window:       100 * time.Millisecond,
+		MaxErrorRate: 0.5,
+		MinRequests:  5,
+		OnRevert:     func(s CanaryStatus) { reverted <- s },
+	}
+
+	broken, _ := NewService("api", "/api/", bad.URL)
+	mux.AddProxy(broken)
+	for i := 0; i < 10; i++ {
+		mux.GetMux().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/", nil))
+	}
+	if status, ok := mux.CanaryStatus(); !ok || status.Errors != 10 {
+		t.Fatalf("Expected 10 errors counted, got %+v", status)
+	}
+
+	select {
+	case status := <-reverted:
+		if status.Good != 2 || status.Candidate != 3 {
+			t.Errorf("Unexpected revert: %+v", status)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Change was not reverted")
+	}
+	w := httptest.NewRecorder()
+	mux.GetMux().ServeHTTP(w, httptest.NewRequest("GET", "/api/", nil))
+	if w.Body.String() != "good" {
+		t.Errorf("Expected good backend after revert, got %d %s", w.Code, w.Body.String())
+	}
+	if _, ok := mux.CanaryStatus(); ok {
+		t.Error("Expected the revert not to be verified itself")
+	}
+}
+
+func TestCanaryCommit(t *testing.T) {
+	mux := NewRuntimeMux()
+	mux.Canary = &CanaryConfig{Window: time.Hour}
+	service, _ := NewService("api", "/api/", "http://127.0.0.1:1")
+	mux.AddProxy(service)
+
+	w := httptest.NewRecorder()
+	mux.AdminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/canary", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected pending change, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	mux.AdminHandler().ServeHTTP(w, httptest.NewRequest("POST", "/canary/commit", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected commit to succeed, got %d", w.Code)
+	}
+	if _, ok := mux.CanaryStatus(); ok {
+		t.Error("Expected no pending change after commit")
+	}
+}
@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// MiddlewareNone as a service's only middleware turns the whole global
+// chain off for it.
+const MiddlewareNone = "none"
+
+// Chain is the global middleware in front of the services, kept by name so
+// each service can run it in its own order or skip parts of it. A service
+// without a Middleware list runs every middleware in registration order;
+// requests not routed to a service always do.
+type Chain struct {
+	ph *RuntimeMux
+	// prefix is where the service namespace is mounted, e.g. "/projects".
+	prefix string
+
+	mu    sync.RWMutex
+	names []string
+	wraps map[string]func(http.Handler) http.Handler
+	// built caches the composed handler for each order in use.
+	built map[string]http.Handler
+	next  http.Handler
+}
+
+// NewChain returns the middleware chain of the services mounted at prefix.
+// Services then only accept middleware lists naming registered middleware.
+func (ph *RuntimeMux) NewChain(prefix string) *Chain {
+	c := &Chain{
+		ph:     ph,
+		prefix: strings.TrimSuffix(prefix, "/"),
+		wraps:  make(map[string]func(http.Handler) http.Handler),
+		built:  make(map[string]http.Handler),
+	}
+	ph.Lock()
+	ph.chain = c
+	ph.Unlock()
+	return c
+}
+
+// Use appends mw to the chain under name; the first registered runs
+// outermost. Registering a name again replaces its middleware in place.
+func (c *Chain) Use(name string, mw func(http.Handler) http.Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.wraps[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.wraps[name] = mw
+	c.built = make(map[string]http.Handler)
+}
+
+// Names returns the registered middleware in default order.
+func (c *Chain) Names() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string{}, c.names...)
+}
+
+// Then returns a handler running the chain, as configured for the service
+// each request is routed to, in front of next.
+func (c *Chain) Then(next http.Handler) http.Handler {
+	c.mu.Lock()
+	c.next = next
+	c.built = make(map[string]http.Handler)
+	c.mu.Unlock()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		service := c.ph.ServiceFor(r, c.prefix)
+		c.ph.Profiler.serve(func() string {
+			if service == nil {
+				return ""
+			}
+			return service.Name
+		}, c.handler(c.Effective(service)), w, r)
+	})
+}
+
+// Effective returns the middleware service runs, outermost first.
+func (c *Chain) Effective(service *Service) []string {
+	if service == nil || service.Middleware == nil {
+		return c.Names()
+	}
+	if len(service.Middleware) == 1 && service.Middleware[0] == MiddlewareNone {
+		return []string{}
+	}
+	return append([]string{}, service.Middleware...)
+}
+
+// handler returns next wrapped in the named middleware, building it on
+// first use.
+func (c *Chain) handler(names []string) http.Handler {
+	key := strings.Join(names, ",")
+	c.mu.RLock()
+	h, ok := c.built[key]
+	c.mu.RUnlock()
+	if ok {
+		return h
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h, ok := c.built[key]; ok {
+		return h
+	}
+	h = profiled("routing", c.next)
+	for i := len(names) - 1; i >= 0; i-- {
+		if mw, ok := c.wraps[names[i]]; ok {
+			h = profiled(names[i], mw(h))
+		}
+	}
+	c.built[key] = h
+	return h
+}
+
+// check returns an error if service names middleware the chain lacks.
+func (c *Chain) check(service *Service) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, name := range service.Middleware {
+		if _, ok := c.wraps[name]; !ok && name != MiddlewareNone {
+			return fmt.Errorf("unknown middleware %s, expected one of %s", name, strings.Join(c.names, ", "))
+		}
+	}
+	return nil
+}
+
+// SetMiddleware sets the global middleware the service runs, outermost
+// first; middleware left out is skipped. nil restores the default chain and
+// []string{MiddlewareNone} skips all of it.
+func (s *Service) SetMiddleware(names []string) error {
+	if names == nil {
+		s.Middleware = nil
+		return nil
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, ", ") {
+			return fmt.Errorf("invalid middleware name %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("middleware %s is listed twice", name)
+		}
+		seen[name] = true
+	}
+	if seen[MiddlewareNone] && len(names) > 1 {
+		return fmt.Errorf("%s cannot be combined with other middleware", MiddlewareNone)
+	}
+	s.Middleware = append([]string{}, names...)
+	return nil
+}
+
+// ServiceFor returns the service r is routed to, by host name or by its
+// path below prefix, or nil.
+func (ph *RuntimeMux) ServiceFor(r *http.Request, prefix string) *Service {
+	service, _, _ := ph.route(r, prefix)
+	return service
+}
+
+// route is like ServiceFor but also returns the path the service sees and
+// whether the host name chose it.
+func (ph *RuntimeMux) route(r *http.Request, prefix string) (*Service, string, bool) {
+	ph.RLock()
+	path, ok := ph.hosts[normalizeHost(r.Host)]
+	service := ph.proxyServers[path]
+	ph.RUnlock()
+	if ok {
+		return service, r.URL.Path, true
+	}
+	rest, found := strings.CutPrefix(r.URL.Path, strings.TrimSuffix(prefix, "/"))
+	if !found || !strings.HasPrefix(rest, "/") {
+		return nil, "", false
+	}
+	stripped := *r
+	stripped.URL = &url.URL{Path: rest}
+	if _, pattern := ph.table.Load().Handler(&stripped); pattern != "" {
+		ph.RLock()
+		defer ph.RUnlock()
+		return ph.proxyServers[pattern], rest, false
+	}
+	return nil, "", false
+}
+
+// ChainStatus is the effective middleware chain of every service.
+type ChainStatus struct {
+	Default  []string            `json:"default"`
+	Services map[string][]string `json:"services"`
+}
+
+// ChainHandler reports the middleware chain of every service:
+//
+//	GET /  ChainStatus
+func (ph *RuntimeMux) ChainHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		ph.RLock()
+		c := ph.chain
+		services := make([]*Service, 0, len(ph.proxyServers))
+		for _, service := range ph.proxyServers {
+			if service != nil {
+				services = append(services, service)
+			}
+		}
+		ph.RUnlock()
+		if c == nil {
+			admin.WriteError(w, http.StatusNotFound, fmt.Errorf("no middleware chain configured"))
+			return
+		}
+		status := ChainStatus{Default: c.Names(), Services: make(map[string][]string, len(services))}
+		for _, service := range services {
+			status.Services[service.Name] = c.Effective(service)
+		}
+		admin.WriteJSON(w, http.StatusOK, status)
+	})
+	return mux
+}
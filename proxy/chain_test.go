@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/proxy/proxytest"
+)
+
+func TestChainPerService(t *testing.T) {
+	backend := proxytest.NewFakeBackend(t, "backend")
+	mux := NewRuntimeMux()
+	chain := mux.NewChain("/projects")
+	for _, name := range []string{"a", "b", "c"} {
+		chain.Use(name, func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Chain", name)
+				next.ServeHTTP(w, r)
+			})
+		})
+	}
+	handler := chain.Then(http.StripPrefix("/projects", mux))
+
+	blog, _ := NewService("blog", "/blog/", backend.URL)
+	shop, _ := NewService("shop", "/shop/", backend.URL)
+	shop.SetMiddleware([]string{"c", "a"})
+	api, _ := NewService("api", "/api/", backend.URL)
+	api.SetHosts([]string{"api.example.com"})
+	api.SetMiddleware([]string{MiddlewareNone})
+	for _, s := range []*Service{blog, shop, api} {
+		if err := mux.AddProxy(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		host, path, want string
+	}{
+		{"example.com", "/projects/blog/", "a,b,c"},
+		{"example.com", "/projects/shop/cart", "c,a"},
+		{"api.example.com", "/v1", ""},
+		{"example.com", "/", "a,b,c"},
+	} {
+		r := httptest.NewRequest("GET", tc.path, nil)
+		r.Host = tc.host
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if got := strings.Join(w.Header().Values("X-Chain"), ","); got != tc.want {
+			t.Errorf("%s%s ran %q, want %q", tc.host, tc.path, got, tc.want)
+		}
+	}
+
+	// Reordering takes effect on the next request.
+	updated := *blog
+	updated.SetMiddleware([]string{"b"})
+	mux.AddProxy(&updated)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/projects/blog/", nil))
+	if got := w.Header().Values("X-Chain"); len(got) != 1 || got[0] != "b" {
+		t.Errorf("Expected only b after reordering, got %v", got)
+	}
+
+	unknown := *blog
+	unknown.SetMiddleware([]string{"b", "gzip"})
+	if err := mux.AddProxy(&unknown); err == nil {
+		t.Error("Expected unknown middleware to be rejected")
+	}
+	if err := blog.SetMiddleware([]string{"a", MiddlewareNone}); err == nil {
+		t.Error("Expected none to be exclusive")
+	}
+
+	w = httptest.NewRecorder()
+	mux.ChainHandler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	var status ChainStatus
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if strings.Join(status.Default, ",") != "a,b,c" || strings.Join(status.Services["shop"], ",") != "c,a" || len(status.Services["api"]) != 0 {
+		t.Errorf("Unexpected chain status %s", w.Body)
+	}
+}
@@ -0,0 +1,434 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"github.com/kirtansoni/reverse-proxy-go/events"
+)
+
+const (
+	defaultCheckInterval = time.Minute
+	defaultCheckTimeout  = 10 * time.Second
+	minCheckInterval     = time.Second
+	// maxCheckBody caps the response body searched for CheckConfig.Body.
+	maxCheckBody = 1 << 20
+	// DefaultCheckHistory is how many runs of each check are kept when
+	// RuntimeMux.CheckHistorySize is not set.
+	DefaultCheckHistory = 60
+)
+
+// CheckConfig is a synthetic check the proxy runs against a service's
+// backend: a GET whose status, and optionally body, must match.
+type CheckConfig struct {
+	Name string `json:"name"`
+	// URL is a path relative to the service URL, or an absolute URL.
+	URL string `json:"url"`
+	// Status is the expected status, 200 by default.
+	Status int `json:"status,omitempty"`
+	// Body, if set, must occur in the first megabyte of the response.
+	Body       string `json:"body,omitempty"`
+	IntervalMs int64  `json:"interval_ms,omitempty"`
+	TimeoutMs  int64  `json:"timeout_ms,omitempty"`
+}
+
+func (c CheckConfig) interval() time.Duration {
+	if c.IntervalMs == 0 {
+		return defaultCheckInterval
+	}
+	return time.Duration(c.IntervalMs) * time.Millisecond
+}
+
+func (c CheckConfig) timeout() time.Duration {
+	if c.TimeoutMs == 0 {
+		return defaultCheckTimeout
+	}
+	return time.Duration(c.TimeoutMs) * time.Millisecond
+}
+
+// SetChecks replaces the service's synthetic checks.
+func (s *Service) SetChecks(checks []CheckConfig) error {
+	names := make(map[string]bool, len(checks))
+	for _, c := range checks {
+		if c.Name == "" || strings.ContainsAny(c.Name, "/ \"") {
+			return fmt.Errorf("invalid check name %q", c.Name)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicate check %s", c.Name)
+		}
+		names[c.Name] = true
+		if _, err := s.checkURL(c); err != nil {
+			return fmt.Errorf("check %s: %v", c.Name, err)
+		}
+		if c.Status != 0 && (c.Status < 100 || c.Status > 599) {
+			return fmt.Errorf("check %s: invalid status %d", c.Name, c.Status)
+		}
+		if c.IntervalMs != 0 && c.interval() < minCheckInterval || c.TimeoutMs < 0 {
+			return fmt.Errorf("check %s: interval must be at least %s and timeout positive", c.Name, minCheckInterval)
+		}
+	}
+	if len(checks) == 0 {
+		checks = nil
+	}
+	s.Checks = checks
+	return nil
+}
+
+func (s *Service) checkURL(c CheckConfig) (string, error) {
+	if strings.HasPrefix(c.URL, "/") {
+		if isUpstreamTemplate(s.Url) {
+			return "", errors.New("checks of a service with a templated url need an absolute url")
+		}
+		return strings.TrimSuffix(s.Url, "/") + c.URL, nil
+	}
+	u, err := parseHTTPURL(c.URL)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// CheckResult is the latest outcome of a synthetic check.
+type CheckResult struct {
+	Service   string    `json:"service"`
+	Check     string    `json:"check"`
+	URL       string    `json:"url"`
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	Status    int       `json:"status,omitempty"`
+	LatencyMs float64   `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	// Since is when the check started passing or failing.
+	Since    time.Time `json:"since"`
+	Passes   int64     `json:"passes"`
+	Failures int64     `json:"failures"`
+}
+
+type checkState struct {
+	next    time.Time
+	running bool
+	result  CheckResult
+	checked bool
+	// history holds the last runs, oldest first.
+	history []CheckSample
+}
+
+// CheckSample is one run of a check, as plotted in a sparkline.
+type CheckSample struct {
+	At        time.Time `json:"at"`
+	OK        bool      `json:"ok"`
+	Status    int       `json:"status,omitempty"`
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// CheckHistory is the last runs of a check with their summary, enough for
+// a dashboard to draw its health without storage of its own.
+type CheckHistory struct {
+	Service string    `json:"service"`
+	Check   string    `json:"check"`
+	URL     string    `json:"url"`
+	OK      bool      `json:"ok"`
+	Since   time.Time `json:"since"`
+	// PassRate is the share of Samples that passed, from 0 to 1.
+	PassRate     float64 `json:"pass_rate"`
+	LatencyMinMs float64 `json:"latency_min_ms"`
+	LatencyAvgMs float64 `json:"latency_avg_ms"`
+	LatencyMaxMs float64 `json:"latency_max_ms"`
+	// Samples are the last runs, oldest first.
+	Samples []CheckSample `json:"samples"`
+}
+
+// checks holds the state of every configured check by service and check
+// name.
+type checks struct {
+	mu     sync.Mutex
+	states map[string]*checkState
+}
+
+// RunChecks runs the services' synthetic checks when due until ctx is done.
+// Failures and recoveries are logged, published to Events and, if
+// CheckWebhook is set, posted there as JSON.
+func (ph *RuntimeMux) RunChecks(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			ph.runDueChecks(ctx, now)
+		}
+	}
+}
+
+func (ph *RuntimeMux) runDueChecks(ctx context.Context, now time.Time) {
+	ph.RLock()
+	type due struct {
+		service *Service
+		check   CheckConfig
+	}
+	var run []due
+	seen := make(map[string]bool)
+	ph.checks.mu.Lock()
+	for _, service := range ph.proxyServers {
+		if service == nil {
+			continue
+		}
+		for _, c := range service.Checks {
+			key := service.Name + "/" + c.Name
+			seen[key] = true
+			st := ph.checks.states[key]
+			if st == nil {
+				st = &checkState{}
+				ph.checks.states[key] = st
+			}
+			if st.running || now.Before(st.next) {
+				continue
+			}
+			st.running = true
+			st.next = now.Add(c.interval())
+			run = append(run, due{service, c})
+		}
+	}
+	for key := range ph.checks.states {
+		if !seen[key] {
+			delete(ph.checks.states, key)
+		}
+	}
+	ph.checks.mu.Unlock()
+	ph.RUnlock()
+
+	for _, d := range run {
+		go ph.runCheck(ctx, d.service, d.check)
+	}
+}
+
+func (ph *RuntimeMux) runCheck(ctx context.Context, service *Service, c CheckConfig) {
+	target, _ := service.checkURL(c)
+	res := CheckResult{Service: service.Name, Check: c.Name, URL: target, CheckedAt: time.Now()}
+	status, err := probe(ctx, target, c)
+	res.LatencyMs = float64(time.Since(res.CheckedAt).Microseconds()) / 1000
+	res.Status, res.OK = status, err == nil
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	key := service.Name + "/" + c.Name
+	ph.checks.mu.Lock()
+	st := ph.checks.states[key]
+	if st == nil {
+		// Removed while running.
+		ph.checks.mu.Unlock()
+		return
+	}
+	prev := st.result
+	changed := !st.checked && !res.OK || st.checked && prev.OK != res.OK
+	res.Passes, res.Failures, res.Since = prev.Passes, prev.Failures, prev.Since
+	if !st.checked || prev.OK != res.OK {
+		res.Since = res.CheckedAt
+	}
+	if res.OK {
+		res.Passes++
+	} else {
+		res.Failures++
+	}
+	st.result, st.checked, st.running = res, true, false
+	keep := ph.CheckHistorySize
+	if keep <= 0 {
+		keep = DefaultCheckHistory
+	}
+	st.history = append(st.history, CheckSample{At: res.CheckedAt, OK: res.OK, Status: res.Status, LatencyMs: res.LatencyMs, Error: res.Error})
+	if len(st.history) > keep {
+		st.history = append([]CheckSample(nil), st.history[len(st.history)-keep:]...)
+	}
+	ph.checks.mu.Unlock()
+
+	if ph.Uptime != nil {
+		ph.Uptime.observeCheck(res)
+	}
+	if !changed {
+		return
+	}
+	event := "check_recovered"
+	if res.OK {
+		log.Printf("Check %s of %s recovered", c.Name, service.Name)
+	} else {
+		event = "check_failed"
+		log.Printf("ALERT: check %s of %s failed: %s", c.Name, service.Name, res.Error)
+	}
+	ph.Events.Publish(events.Event{Type: events.HealthChanged, Time: res.CheckedAt, Subject: service.Name, Data: res})
+	if ph.CheckWebhook != "" {
+		go postCheckEvent(ph.CheckWebhook, event, res)
+	}
+}
+
+// probe runs one check, returning the status it got and why it failed.
+func probe(ctx context.Context, target string, c CheckConfig) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "reverse-proxy-go synthetic check")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	want := c.Status
+	if want == 0 {
+		want = http.StatusOK
+	}
+	if resp.StatusCode != want {
+		return resp.StatusCode, fmt.Errorf("status %d, expected %d", resp.StatusCode, want)
+	}
+	if c.Body != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxCheckBody))
+		if err != nil {
+			return resp.StatusCode, err
+		}
+		if !bytes.Contains(body, []byte(c.Body)) {
+			return resp.StatusCode, fmt.Errorf("body does not contain %q", c.Body)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func postCheckEvent(webhook, event string, res CheckResult) {
+	body, _ := json.Marshal(struct {
+		Event string `json:"event"`
+		CheckResult
+	}{event, res})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to post check event: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// CheckResults returns the latest result of every check that has run.
+func (ph *RuntimeMux) CheckResults() []CheckResult {
+	ph.checks.mu.Lock()
+	defer ph.checks.mu.Unlock()
+	results := []CheckResult{}
+	for _, st := range ph.checks.states {
+		if st.checked {
+			results = append(results, st.result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Service != results[j].Service {
+			return results[i].Service < results[j].Service
+		}
+		return results[i].Check < results[j].Check
+	})
+	return results
+}
+
+// CheckHistories returns the recent runs of every check that has run,
+// optionally only those of service and of check.
+func (ph *RuntimeMux) CheckHistories(service, check string) []CheckHistory {
+	ph.checks.mu.Lock()
+	defer ph.checks.mu.Unlock()
+	histories := []CheckHistory{}
+	for _, st := range ph.checks.states {
+		res := st.result
+		if !st.checked || service != "" && res.Service != service || check != "" && res.Check != check {
+			continue
+		}
+		h := CheckHistory{Service: res.Service, Check: res.Check, URL: res.URL, OK: res.OK, Since: res.Since, Samples: append([]CheckSample(nil), st.history...)}
+		passed, total := 0, 0.0
+		for i, sample := range h.Samples {
+			if sample.OK {
+				passed++
+			}
+			total += sample.LatencyMs
+			if i == 0 || sample.LatencyMs < h.LatencyMinMs {
+				h.LatencyMinMs = sample.LatencyMs
+			}
+			h.LatencyMaxMs = max(h.LatencyMaxMs, sample.LatencyMs)
+		}
+		if n := len(h.Samples); n > 0 {
+			h.PassRate = float64(passed) / float64(n)
+			h.LatencyAvgMs = total / float64(n)
+		}
+		histories = append(histories, h)
+	}
+	sort.Slice(histories, func(i, j int) bool {
+		if histories[i].Service != histories[j].Service {
+			return histories[i].Service < histories[j].Service
+		}
+		return histories[i].Check < histories[j].Check
+	})
+	return histories
+}
+
+// ChecksHandler serves synthetic check results:
+//
+//	GET /                          latest result of every check
+//	GET /history?service=&check=   recent runs of the checks for sparklines
+//	GET /metrics                   the latest results in Prometheus text format
+func (ph *RuntimeMux) ChecksHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, ph.CheckResults())
+	})
+	mux.HandleFunc("GET /history", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		admin.WriteJSON(w, http.StatusOK, ph.CheckHistories(q.Get("service"), q.Get("check")))
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		results := ph.CheckResults()
+		metric := func(name, typ, help string, value func(CheckResult) string) {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+			for _, res := range results {
+				fmt.Fprintf(&b, "%s{service=%q,check=%q} %s\n", name, res.Service, res.Check, value(res))
+			}
+		}
+		metric("proxy_check_up", "gauge", "Whether the last run of the check passed.", func(res CheckResult) string {
+			if res.OK {
+				return "1"
+			}
+			return "0"
+		})
+		metric("proxy_check_latency_seconds", "gauge", "Duration of the last run of the check.", func(res CheckResult) string {
+			return fmt.Sprint(res.LatencyMs / 1000)
+		})
+		metric("proxy_check_failures_total", "counter", "Failed runs of the check.", func(res CheckResult) string {
+			return fmt.Sprint(res.Failures)
+		})
+		metric("proxy_check_runs_total", "counter", "Runs of the check.", func(res CheckResult) string {
+			return fmt.Sprint(res.Passes + res.Failures)
+		})
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(b.String()))
+	})
+	return mux
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/proxy/proxytest"
+)
+
+func TestChecks(t *testing.T) {
+	backend := proxytest.NewFakeBackend(t, "blog")
+	healthy := true
+	backend.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte("Latest posts"))
+	}))
+	events := make(chan map[string]any, 4)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]any
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer webhook.Close()
+
+	mux := NewRuntimeMux()
+	mux.CheckWebhook = webhook.URL
+	service, _ := NewService("blog", "/blog/", backend.URL)
+	if err := service.SetChecks([]CheckConfig{{Name: "a", URL: "/"}, {Name: "a", URL: "/x"}}); err == nil {
+		t.Error("Expected duplicate check names to be rejected")
+	}
+	if err := service.SetChecks([]CheckConfig{{Name: "home", URL: "/", Body: "Latest posts"}, {Name: "about", URL: "/about", Body: "nope"}}); err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(service)
+
+	ctx := context.Background()
+	run := func(now time.Time) []CheckResult {
+		mux.runDueChecks(ctx, now)
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			results := mux.CheckResults()
+			done := len(results) == 2
+			mux.checks.mu.Lock()
+			for _, st := range mux.checks.states {
+				done = done && !st.running
+			}
+			mux.checks.mu.Unlock()
+			if done {
+				return results
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatal("Checks did not finish")
+		return nil
+	}
+
+	now := time.Now()
+	results := run(now)
+	if !results[1].OK || results[0].OK || !strings.Contains(results[0].Error, "nope") {
+		t.Errorf("Expected home to pass and about to fail, got %+v", results)
+	}
+	if e := <-events; e["event"] != "check_failed" || e["check"] != "about" {
+		t.Errorf("Expected a failure event for about, got %v", e)
+	}
+
+	healthy = false
+	results = run(now.Add(time.Minute))
+	if results[1].OK || results[1].Status != http.StatusServiceUnavailable || results[1].Failures != 1 || results[1].Passes != 1 {
+		t.Errorf("Expected home to fail with 503, got %+v", results[1])
+	}
+	if e := <-events; e["event"] != "check_failed" || e["check"] != "home" {
+		t.Errorf("Expected a failure event for home, got %v", e)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ChecksHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `proxy_check_up{service="blog",check="home"} 0`) {
+		t.Errorf("Unexpected metrics:\n%s", w.Body)
+	}
+
+	histories := mux.CheckHistories("blog", "home")
+	if len(histories) != 1 || len(histories[0].Samples) != 2 || !histories[0].Samples[0].OK || histories[0].Samples[1].OK || histories[0].PassRate != 0.5 {
+		t.Errorf("Expected home to have passed then failed, got %+v", histories)
+	}
+	mux.CheckHistorySize = 2
+	run(now.Add(2 * time.Minute))
+	if samples := mux.CheckHistories("blog", "home")[0].Samples; len(samples) != 2 || samples[0].OK || samples[1].Status != http.StatusServiceUnavailable {
+		t.Errorf("Expected the oldest run to be dropped, got %+v", samples)
+	}
+
+	updated := *service
+	updated.SetChecks(nil)
+	mux.AddProxy(&updated)
+	mux.runDueChecks(ctx, now.Add(3*time.Minute))
+	if results := mux.CheckResults(); len(results) != 0 {
+		t.Errorf("Expected removed checks to be dropped, got %+v", results)
+	}
+}
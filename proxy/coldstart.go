@@ -0,0 +1,412 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/lifecycle"
+)
+
+const (
+	// maxReplayBody is the largest request body buffered so the request can
+	// be sent again once a cold backend is up.
+	maxReplayBody = 64 << 10
+	// coldStartProbe is how often a waking backend is checked.
+	coldStartProbe = 500 * time.Millisecond
+)
+
+// ColdStartConfig holds requests while a scaled-to-zero or slow-to-wake
+// backend starts, instead of failing them with 502 at once. The backend is
+// taken to be down when a connection to it is refused; requests then wait
+// for it to come up, for at most HoldMs.
+type ColdStartConfig struct {
+	HoldMs int64 `json:"hold_ms"`
+	// WakeURL, if set, is POSTed to when the backend is found down, e.g. a
+	// webhook scaling it up from zero.
+	WakeURL string `json:"wake_url,omitempty"`
+	// Wake, if set, is the container or unit started when the backend is
+	// found down, as docker:<container> or systemd:<unit>.
+	Wake string `json:"wake,omitempty"`
+	// SuspendAfterMs, if set, stops the backend once it has served no
+	// requests for this long, through StopURL or by stopping the Wake
+	// container or unit. The next request wakes it again.
+	SuspendAfterMs int64 `json:"suspend_after_ms,omitempty"`
+	// StopURL, if set, is POSTed to when the backend is suspended.
+	StopURL string `json:"stop_url,omitempty"`
+	// HealthPath, if set, is polled while the backend starts; it is up once
+	// it answers with a status below 500. Otherwise it is up once it
+	// accepts connections.
+	HealthPath string `json:"health_path,omitempty"`
+}
+
+// EnableColdStart holds requests while the backend starts; a HoldMs of 0
+// turns it off. Requests with bodies larger than 64 KB are not held, as
+// they cannot be sent again.
+func (s *Service) EnableColdStart(cfg ColdStartConfig) error {
+	if cfg.HoldMs < 0 {
+		return errors.New("cold start hold_ms must not be negative")
+	}
+	if cfg.WakeURL != "" {
+		if _, err := parseHTTPURL(cfg.WakeURL); err != nil {
+			return fmt.Errorf("cold start wake_url: %v", err)
+		}
+	}
+	var wake lifecycle.Controller
+	if cfg.Wake != "" {
+		var err error
+		if wake, err = lifecycle.Parse(cfg.Wake); err != nil {
+			return fmt.Errorf("cold start wake: %v", err)
+		}
+	}
+	if cfg.StopURL != "" {
+		if _, err := parseHTTPURL(cfg.StopURL); err != nil {
+			return fmt.Errorf("cold start stop_url: %v", err)
+		}
+	}
+	if cfg.SuspendAfterMs < 0 {
+		return errors.New("cold start suspend_after_ms must not be negative")
+	}
+	if cfg.SuspendAfterMs > 0 && (wake == nil && cfg.StopURL == "" || wake == nil && cfg.WakeURL == "") {
+		return errors.New("suspending a backend needs wake or both stop_url and wake_url")
+	}
+	if cfg.HealthPath != "" && !strings.HasPrefix(cfg.HealthPath, "/") {
+		return fmt.Errorf("cold start health_path must start with /")
+	}
+	if cfg.HoldMs == 0 {
+		s.ColdStart, s.coldStart = nil, nil
+	} else {
+		target, err := url.Parse(s.Url)
+		if err != nil {
+			return err
+		}
+		s.ColdStart = &cfg
+		s.coldStart = newColdStart(cfg, target, wake)
+	}
+	s.resetTransport()
+	return nil
+}
+
+// coldStart tracks whether a backend is starting. It is shared by the
+// copies of a service, so a reconfigured service keeps waiting for it.
+type coldStart struct {
+	cfg     ColdStartConfig
+	target  *url.URL
+	backend lifecycle.Controller
+
+	// lastUsed is the time of the last request, in Unix nanoseconds.
+	lastUsed atomic.Int64
+
+	mu sync.Mutex
+	// ready is closed once the backend is up, or the wait for it ends;
+	// nil when it is not known to be down.
+	ready chan struct{}
+	// sleeping is set while the backend is suspended, and stopped is
+	// closed once it has been stopped.
+	sleeping bool
+	stopped  chan struct{}
+}
+
+// Cold start states reported in Load.
+const (
+	StateAwake    = "awake"
+	StateStarting = "starting"
+	StateSleeping = "sleeping"
+)
+
+func newColdStart(cfg ColdStartConfig, target *url.URL, backend lifecycle.Controller) *coldStart {
+	c := &coldStart{cfg: cfg, target: target, backend: backend}
+	c.lastUsed.Store(time.Now().UnixNano())
+	return c
+}
+
+// starting returns the channel of a wake-up in progress, or nil. The
+// backend is woken first if it is suspended.
+func (c *coldStart) starting() chan struct{} {
+	c.lastUsed.Store(time.Now().UnixNano())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sleeping {
+		c.wakeLocked()
+	}
+	return c.ready
+}
+
+// wake starts waking the backend unless that is already under way.
+func (c *coldStart) wake() chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wakeLocked()
+	return c.ready
+}
+
+func (c *coldStart) wakeLocked() {
+	if c.ready == nil {
+		c.ready = make(chan struct{})
+		go c.run(c.ready, c.stopped)
+	}
+}
+
+func (c *coldStart) state() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.ready != nil:
+		return StateStarting
+	case c.sleeping:
+		return StateSleeping
+	}
+	return StateAwake
+}
+
+// suspendIfIdle stops the backend if it is awake and has served no
+// requests for SuspendAfterMs.
+func (c *coldStart) suspendIfIdle(now time.Time, inFlight int64) {
+	idle := time.Duration(c.cfg.SuspendAfterMs) * time.Millisecond
+	if idle <= 0 || inFlight > 0 || now.Sub(time.Unix(0, c.lastUsed.Load())) < idle {
+		return
+	}
+	c.mu.Lock()
+	if c.sleeping || c.ready != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.sleeping = true
+	stopped := make(chan struct{})
+	c.stopped = stopped
+	c.mu.Unlock()
+	defer close(stopped)
+
+	log.Printf("Backend %s has been idle for %s, suspending it", c.target.Host, idle)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if c.cfg.StopURL != "" {
+		if err := callWebhook(ctx, c.cfg.StopURL); err != nil {
+			log.Printf("Failed to call stop webhook for %s: %v", c.target.Host, err)
+		}
+	}
+	if c.backend != nil {
+		if err := c.backend.Stop(ctx); err != nil {
+			log.Printf("Failed to suspend %s: %v", c.target.Host, err)
+		}
+	}
+}
+
+// run calls the wake webhook and probes the backend until it is up or the
+// hold time has passed, then releases the waiting requests.
+func (c *coldStart) run(ready, stopped chan struct{}) {
+	hold := time.Duration(c.cfg.HoldMs) * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), hold)
+	defer cancel()
+	up := false
+	defer func() {
+		c.mu.Lock()
+		c.ready = nil
+		if up {
+			c.sleeping = false
+		}
+		c.mu.Unlock()
+		close(ready)
+	}()
+
+	// A backend being suspended is started once it has stopped.
+	if stopped != nil {
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			return
+		}
+	}
+	log.Printf("Backend %s is down, holding requests for up to %s", c.target.Host, hold)
+	if c.cfg.WakeURL != "" {
+		if err := callWebhook(ctx, c.cfg.WakeURL); err != nil {
+			log.Printf("Failed to call wake webhook for %s: %v", c.target.Host, err)
+		}
+	}
+	if c.backend != nil {
+		if err := c.backend.Start(ctx); err != nil {
+			log.Printf("Failed to wake %s: %v", c.target.Host, err)
+		}
+	}
+	start := time.Now()
+	for {
+		if up = c.up(ctx); up {
+			log.Printf("Backend %s is up after %s", c.target.Host, time.Since(start).Round(time.Millisecond))
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("Backend %s did not come up within %s", c.target.Host, hold)
+			return
+		case <-time.After(coldStartProbe):
+		}
+	}
+}
+
+func callWebhook(ctx context.Context, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// up probes the backend once.
+func (c *coldStart) up(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if c.cfg.HealthPath == "" {
+		port := c.target.Port()
+		if port == "" {
+			port = "80"
+			if c.target.Scheme == "https" {
+				port = "443"
+			}
+		}
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(c.target.Hostname(), port))
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	health := *c.target
+	health.Path, health.RawPath, health.RawQuery = c.cfg.HealthPath, "", ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, health.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 500
+}
+
+// wait blocks until ready is closed, r is canceled or the hold time is up.
+func (c *coldStart) wait(r *http.Request, ready chan struct{}) error {
+	timer := time.NewTimer(time.Duration(c.cfg.HoldMs) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-r.Context().Done():
+		return r.Context().Err()
+	case <-timer.C:
+		return fmt.Errorf("backend %s did not come up in time", c.target.Host)
+	}
+}
+
+// coldStartTransport holds requests for a starting backend and sends
+// those refused by a down backend again once it is up.
+type coldStartTransport struct {
+	next  http.RoundTripper
+	state *coldStart
+}
+
+func (t *coldStartTransport) CloseIdleConnections() {
+	if c, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+func (t *coldStartTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if ready := t.state.starting(); ready != nil {
+		if err := t.state.wait(r, ready); err != nil {
+			if r.Body != nil {
+				r.Body.Close()
+			}
+			return nil, err
+		}
+	}
+	r, replayable := replayableRequest(r)
+	resp, err := t.next.RoundTrip(r)
+	var op *net.OpError
+	if err == nil || !replayable || !errors.As(err, &op) || op.Op != "dial" {
+		return resp, err
+	}
+	if err := t.state.wait(r, t.state.wake()); err != nil {
+		return nil, err
+	}
+	if r.GetBody != nil {
+		if r.Body, err = r.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.next.RoundTrip(r)
+}
+
+// replayableRequest returns r with a body that can be read again, and
+// whether that was possible.
+func replayableRequest(r *http.Request) (*http.Request, bool) {
+	if r.Body == nil || r.Body == http.NoBody || r.GetBody != nil {
+		return r, true
+	}
+	if r.ContentLength <= 0 || r.ContentLength > maxReplayBody {
+		return r, false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxReplayBody+1))
+	r.Body.Close()
+	r = r.Clone(r.Context())
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.Body, _ = r.GetBody()
+	if err != nil {
+		// Let the transport report the broken body.
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+		return r, false
+	}
+	return r, true
+}
+
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
+
+// suspendCheck is how often idle backends are looked for.
+const suspendCheck = 15 * time.Second
+
+// SuspendIdle suspends the backends of services that have been idle for
+// their ColdStartConfig.SuspendAfterMs, until ctx is done.
+func (ph *RuntimeMux) SuspendIdle(ctx context.Context) {
+	ticker := time.NewTicker(suspendCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			ph.suspendIdle(now)
+		}
+	}
+}
+
+func (ph *RuntimeMux) suspendIdle(now time.Time) {
+	ph.RLock()
+	defer ph.RUnlock()
+	for path, service := range ph.proxyServers {
+		if service != nil && service.coldStart != nil {
+			go service.coldStart.suspendIfIdle(now, ph.load[path].inFlight.Load())
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/lifecycle"
+)
+
+// freeAddr returns a local address nothing listens on.
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestColdStartWakesBackend(t *testing.T) {
+	addr := freeAddr(t)
+	var wakes atomic.Int32
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wakes.Add(1) > 1 {
+			return
+		}
+		// Start the backend a little later, as a scaled-down one would.
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				w.Write([]byte("woke:" + string(body)))
+			}))
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			backend.Listener = ln
+			backend.Start()
+			t.Cleanup(backend.Close)
+		}()
+	}))
+	defer webhook.Close()
+
+	mux := NewRuntimeMux()
+	service, _ := NewService("demo", "/demo/", "http://"+addr)
+	if err := service.EnableColdStart(ColdStartConfig{HoldMs: 5000, WakeURL: "ftp://x"}); err == nil {
+		t.Error("Expected a non-HTTP wake URL to be rejected")
+	}
+	if err := service.EnableColdStart(ColdStartConfig{HoldMs: 5000, WakeURL: webhook.URL}); err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(service)
+
+	results := make(chan string, 2)
+	for _, body := range []string{"a", "b"} {
+		go func() {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/demo/", strings.NewReader(body))
+			mux.ServeHTTP(w, r)
+			results <- w.Body.String()
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if got := <-results; got != "woke:a" && got != "woke:b" {
+			t.Errorf("Expected the held request to reach the woken backend, got %q", got)
+		}
+	}
+	if n := wakes.Load(); n != 1 {
+		t.Errorf("Expected one wake call, got %d", n)
+	}
+}
+
+func TestColdStartGivesUp(t *testing.T) {
+	mux := NewRuntimeMux()
+	service, _ := NewService("demo", "/demo/", "http://"+freeAddr(t))
+	if err := service.EnableColdStart(ColdStartConfig{HoldMs: 200, Wake: "k8s:demo"}); err == nil {
+		t.Error("Expected an unknown wake kind to be rejected")
+	}
+	service.EnableColdStart(ColdStartConfig{HoldMs: 200})
+	mux.AddProxy(service)
+
+	start := time.Now()
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/demo/", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 once the hold time is up, got %d", w.Code)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond || d > 2*time.Second {
+		t.Errorf("Expected the request to be held for about 200ms, took %s", d)
+	}
+
+	disabled := *service
+	disabled.EnableColdStart(ColdStartConfig{})
+	if disabled.ColdStart != nil || service.ColdStart == nil {
+		t.Error("Disabling cold start on a copy must not change the original")
+	}
+}
+
+// fakeController runs a backend on a fixed address, as a container would.
+type fakeController struct {
+	addr    string
+	mu      sync.Mutex
+	backend *httptest.Server
+	starts  int
+	stops   int
+}
+
+func (f *fakeController) Start(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.starts++
+	if f.backend != nil {
+		return nil
+	}
+	ln, err := net.Listen("tcp", f.addr)
+	if err != nil {
+		return err
+	}
+	f.backend = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("up"))
+	}))
+	f.backend.Listener = ln
+	f.backend.Start()
+	return nil
+}
+
+func (f *fakeController) Stop(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stops++
+	if f.backend != nil {
+		f.backend.Close()
+		f.backend = nil
+	}
+	return nil
+}
+
+func TestIdleSuspend(t *testing.T) {
+	fake := &fakeController{addr: freeAddr(t)}
+	fake.Start(context.Background())
+	defer fake.Stop(context.Background())
+
+	mux := NewRuntimeMux()
+	service, _ := NewService("demo", "/demo/", "http://"+fake.addr)
+	if err := service.EnableColdStart(ColdStartConfig{HoldMs: 5000, SuspendAfterMs: 50, WakeURL: "http://hooks.example"}); err == nil {
+		t.Error("Expected suspending without a way to stop the backend to be rejected")
+	}
+	lifecycle.Register("fake", func(string) lifecycle.Controller { return fake })
+	if err := service.EnableColdStart(ColdStartConfig{HoldMs: 5000, SuspendAfterMs: 50, Wake: "fake:demo"}); err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(service)
+
+	get := func() string {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/demo/", nil))
+		return w.Body.String()
+	}
+	if got := get(); got != "up" {
+		t.Fatalf("Unexpected response %q", got)
+	}
+	mux.suspendIdle(time.Now())
+	time.Sleep(10 * time.Millisecond)
+	if state := mux.Load()[0].State; state != StateAwake {
+		t.Errorf("Expected a recently used backend to stay awake, got %s", state)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	mux.suspendIdle(time.Now())
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		fake.mu.Lock()
+		stops := fake.stops
+		fake.mu.Unlock()
+		if stops == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the idle backend to be stopped")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if state := mux.Load()[0].State; state != StateSleeping {
+		t.Errorf("Expected the service to be sleeping, got %s", state)
+	}
+
+	if got := get(); got != "up" {
+		t.Errorf("Expected the request to wake the backend, got %q", got)
+	}
+	if fake.starts != 2 || mux.Load()[0].State != StateAwake {
+		t.Errorf("Expected one wake-up and an awake service, got %d starts, %s", fake.starts, mux.Load()[0].State)
+	}
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxCompatBody is the largest request body of unknown length buffered to
+// send it with a Content-Length to an HTTP/1.0 backend.
+const maxCompatBody = 10 << 20
+
+// CompatConfig adapts requests to legacy backends that break on what Go
+// sends by default.
+type CompatConfig struct {
+	// HeaderCase lists header names sent to the backend spelled exactly
+	// so, such as SOAPAction or X-API-KEY, instead of Go's canonical form
+	// (Soapaction, X-Api-Key). Requests then use HTTP/1.x, as HTTP/2 lower
+	// cases every name.
+	HeaderCase []string `json:"header_case,omitempty"`
+	// HTTP10 sends requests as HTTP/1.0, each on a connection of its own:
+	// bodies always have a Content-Length, never chunked encoding, and the
+	// response is read until the backend closes the connection.
+	HTTP10 bool `json:"http10,omitempty"`
+}
+
+// EnableCompat applies cfg to upstream requests; an empty cfg turns
+// compatibility mode off. Go's server canonicalizes the header names it
+// receives, so the casing clients used cannot be passed on; HeaderCase
+// lists the spellings the backend expects instead.
+func (s *Service) EnableCompat(cfg CompatConfig) error {
+	seen := make(map[string]bool, len(cfg.HeaderCase))
+	for _, name := range cfg.HeaderCase {
+		if name == "" || strings.ContainsAny(name, " :\r\n\t") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		if canonical == "Host" {
+			return errors.New("the casing of Host cannot be changed")
+		}
+		if seen[canonical] {
+			return fmt.Errorf("header %s is listed twice", name)
+		}
+		seen[canonical] = true
+	}
+	if cfg.HTTP10 && s.ConnectionAffinity {
+		return errors.New("HTTP/1.0 closes every connection, so it cannot be combined with connection affinity")
+	}
+	if cfg.HTTP10 && s.Sticky != nil {
+		return errors.New("HTTP/1.0 compatibility cannot be combined with sticky sessions")
+	}
+	if len(cfg.HeaderCase) == 0 && !cfg.HTTP10 {
+		s.Compat = nil
+	} else {
+		s.Compat = &cfg
+	}
+	s.resetTransport()
+	return nil
+}
+
+// compatTransport respells header names before passing requests on.
+type compatTransport struct {
+	next http.RoundTripper
+	// names maps canonical header names to the spelling sent.
+	names map[string]string
+}
+
+func newCompatTransport(cfg *CompatConfig, next http.RoundTripper) *compatTransport {
+	names := make(map[string]string, len(cfg.HeaderCase))
+	for _, name := range cfg.HeaderCase {
+		names[textproto.CanonicalMIMEHeaderKey(name)] = name
+	}
+	return &compatTransport{next: next, names: names}
+}
+
+func (t *compatTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if len(t.names) > 0 {
+		r = r.Clone(r.Context())
+		for canonical, name := range t.names {
+			if v, ok := r.Header[canonical]; ok {
+				delete(r.Header, canonical)
+				r.Header[name] = v
+			}
+		}
+	}
+	return t.next.RoundTrip(r)
+}
+
+func (t *compatTransport) CloseIdleConnections() {
+	if c, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// http10Transport sends requests as HTTP/1.0. http.Transport always speaks
+// HTTP/1.1, so it writes requests itself, dialing as base would.
+type http10Transport struct {
+	base *http.Transport
+}
+
+func (t *http10Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Scheme != "http" && r.URL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported protocol scheme %q", r.URL.Scheme)
+	}
+	body := r.Body
+	length := r.ContentLength
+	if body == http.NoBody {
+		body = nil
+	}
+	if body != nil && length < 0 {
+		buf, err := io.ReadAll(io.LimitReader(body, maxCompatBody+1))
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %v", err)
+		}
+		if len(buf) > maxCompatBody {
+			return nil, fmt.Errorf("request body of unknown length exceeds %d bytes", maxCompatBody)
+		}
+		body, length = io.NopCloser(bytes.NewReader(buf)), int64(len(buf))
+	}
+	if body != nil {
+		defer body.Close()
+	}
+
+	conn, err := t.dial(r.Context(), r.URL)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(r.Context(), func() { conn.Close() })
+	fail := func(err error) (*http.Response, error) {
+		stop()
+		conn.Close()
+		if ctxErr := r.Context().Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "%s %s HTTP/1.0\r\n", r.Method, r.URL.RequestURI())
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	fmt.Fprintf(w, "Host: %s\r\n", host)
+	header := r.Header.Clone()
+	for _, name := range []string{"Host", "Connection", "Transfer-Encoding", "Content-Length", "Te", "Trailer"} {
+		header.Del(name)
+	}
+	if ua, ok := header["User-Agent"]; ok && (len(ua) == 0 || ua[0] == "") {
+		delete(header, "User-Agent")
+	}
+	if body != nil || methodHasBody(r.Method) {
+		header["Content-Length"] = []string{strconv.FormatInt(max(length, 0), 10)}
+	}
+	if err := header.Write(w); err != nil {
+		return fail(err)
+	}
+	w.WriteString("\r\n")
+	if body != nil {
+		if _, err := io.CopyN(w, body, length); err != nil {
+			return fail(fmt.Errorf("failed to send request body: %v", err))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), r)
+	if err != nil {
+		return fail(fmt.Errorf("failed to read HTTP/1.0 response: %v", err))
+	}
+	resp.Body = &http10Body{ReadCloser: resp.Body, conn: conn, stop: stop}
+	return resp, nil
+}
+
+func methodHasBody(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
+}
+
+func (t *http10Transport) dial(ctx context.Context, u *url.URL) (net.Conn, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	dial := t.base.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil || u.Scheme != "https" {
+		return conn, err
+	}
+	cfg := &tls.Config{}
+	if t.base.TLSClientConfig != nil {
+		cfg = t.base.TLSClientConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (t *http10Transport) CloseIdleConnections() {}
+
+// http10Body closes the connection of an HTTP/1.0 response with its body.
+type http10Body struct {
+	io.ReadCloser
+	conn net.Conn
+	stop func() bool
+}
+
+func (b *http10Body) Close() error {
+	b.stop()
+	b.ReadCloser.Close()
+	return b.conn.Close()
+}
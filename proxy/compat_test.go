@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// rawBackend answers every connection with an HTTP/1.0 response and sends
+// the request exactly as received on the channel.
+func rawBackend(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	requests := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			var head strings.Builder
+			length := 0
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break
+				}
+				head.WriteString(line)
+				if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+					length, _ = strconv.Atoi(strings.TrimSpace(value))
+				}
+				if line == "\r\n" {
+					break
+				}
+			}
+			body := make([]byte, length)
+			io.ReadFull(r, body)
+			requests <- head.String() + string(body)
+			conn.Write([]byte("HTTP/1.0 200 OK\r\nContent-Type: text/plain\r\n\r\nlegacy"))
+			conn.Close()
+		}
+	}()
+	return "http://" + ln.Addr().String(), requests
+}
+
+func TestCompatHeaderCase(t *testing.T) {
+	url, requests := rawBackend(t)
+	service, _ := NewService("soap", "/soap/", url)
+	if err := service.EnableCompat(CompatConfig{HeaderCase: []string{"SOAPAction", "X-API-KEY"}}); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/soap/", strings.NewReader("<Envelope/>"))
+	req.Header.Set("Soapaction", "urn:Get")
+	req.Header.Set("X-Api-Key", "secret")
+	w := httptest.NewRecorder()
+	service.ServeHTTP(w, req)
+	if w.Body.String() != "legacy" {
+		t.Fatalf("Unexpected response: %d %q", w.Code, w.Body.String())
+	}
+	got := <-requests
+	if !strings.Contains(got, "\r\nSOAPAction: urn:Get\r\n") || !strings.Contains(got, "\r\nX-API-KEY: secret\r\n") {
+		t.Errorf("Expected headers with their configured casing, got %q", got)
+	}
+	if !strings.HasSuffix(strings.SplitN(got, "\r\n", 2)[0], "HTTP/1.1") {
+		t.Errorf("Expected an HTTP/1.1 request, got %q", got)
+	}
+}
+
+func TestCompatHTTP10(t *testing.T) {
+	url, requests := rawBackend(t)
+	service, _ := NewService("legacy", "/legacy/", url)
+	if err := service.EnableCompat(CompatConfig{HTTP10: true, HeaderCase: []string{"X-API-KEY"}}); err != nil {
+		t.Fatal(err)
+	}
+	// A body of unknown length would be chunked by http.Transport.
+	req := httptest.NewRequest("POST", "/legacy/orders?id=1", io.MultiReader(strings.NewReader("hello "), strings.NewReader("world")))
+	req.ContentLength = -1
+	req.Header.Set("X-Api-Key", "secret")
+	w := httptest.NewRecorder()
+	service.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "legacy" {
+		t.Fatalf("Unexpected response: %d %q", w.Code, w.Body.String())
+	}
+	got := <-requests
+	if !strings.HasPrefix(got, "POST /legacy/orders?id=1 HTTP/1.0\r\n") {
+		t.Errorf("Expected an HTTP/1.0 request line, got %q", got)
+	}
+	if !strings.Contains(got, "\r\nContent-Length: 11\r\n") || strings.Contains(got, "chunked") || !strings.HasSuffix(got, "\r\n\r\nhello world") {
+		t.Errorf("Expected the body with a Content-Length, got %q", got)
+	}
+	if !strings.Contains(got, "\r\nX-API-KEY: secret\r\n") {
+		t.Errorf("Expected the configured header casing, got %q", got)
+	}
+
+	if err := service.EnableCompat(CompatConfig{}); err != nil || service.Compat != nil {
+		t.Errorf("Expected an empty config to turn compatibility mode off, got %v", err)
+	}
+	service.EnableConnectionAffinity()
+	if err := service.EnableCompat(CompatConfig{HTTP10: true}); err == nil {
+		t.Error("Expected HTTP/1.0 to be refused with connection affinity")
+	}
+}
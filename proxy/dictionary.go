@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultDictionarySize caps the responses kept as compression
+// dictionaries when DictionaryConfig.MaxSize is 0.
+const DefaultDictionarySize = 1 << 20
+
+// maxDictionaryBytes caps the memory of all kept dictionaries.
+const maxDictionaryBytes = 64 << 20
+
+// dczMagic starts every dictionary-compressed Zstandard response, followed
+// by the SHA-256 of the dictionary (RFC 9842).
+var dczMagic = []byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}
+
+// DictionaryConfig enables Compression Dictionary Transport for static
+// assets. Responses for paths matching Match are offered to browsers as
+// dictionaries; a browser holding one sends its hash with its next request
+// for a matching path, and the new version is sent as a Zstandard delta
+// against it ("dcz"), typically a fraction of the full size.
+type DictionaryConfig struct {
+	// Match is a path pattern in terms of the service's routes, where *
+	// matches any characters, e.g. "/app/assets/main.*.js".
+	Match string `json:"match"`
+	// MaxSize is the largest response kept as a dictionary, in bytes; 0
+	// means DefaultDictionarySize.
+	MaxSize int64 `json:"max_size,omitempty"`
+}
+
+// EnableCompressionDictionary serves the assets matching cfg.Match with
+// compression dictionaries.
+func (s *Service) EnableCompressionDictionary(cfg DictionaryConfig) error {
+	if !strings.HasPrefix(cfg.Match, s.Path) {
+		return fmt.Errorf("dictionary match %q must start with the service path %s", cfg.Match, s.Path)
+	}
+	if cfg.MaxSize < 0 {
+		return fmt.Errorf("dictionary max_size must not be negative")
+	}
+	s.CompressionDictionary = &cfg
+	return nil
+}
+
+// matchWildcard reports whether s matches pattern, where * matches any run
+// of characters.
+func matchWildcard(pattern, s string) bool {
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, pattern[:star]) {
+		return false
+	}
+	rest := pattern[star+1:]
+	for i := star; i <= len(s); i++ {
+		if matchWildcard(rest, s[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// dictionaryStore keeps recent dictionary responses by hash, dropping the
+// oldest once maxDictionaryBytes is reached.
+type dictionaryStore struct {
+	mu    sync.Mutex
+	dicts map[[32]byte][]byte
+	order [][32]byte
+	size  int
+}
+
+func newDictionaryStore() *dictionaryStore {
+	return &dictionaryStore{dicts: make(map[[32]byte][]byte)}
+}
+
+func (d *dictionaryStore) get(hash [32]byte) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dicts[hash]
+}
+
+func (d *dictionaryStore) add(content []byte) {
+	hash := sha256.Sum256(content)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.dicts[hash]; ok {
+		return
+	}
+	d.dicts[hash] = content
+	d.order = append(d.order, hash)
+	d.size += len(content)
+	for d.size > maxDictionaryBytes && len(d.order) > 1 {
+		oldest := d.order[0]
+		d.order = d.order[1:]
+		d.size -= len(d.dicts[oldest])
+		delete(d.dicts, oldest)
+	}
+}
+
+// availableDictionary parses the Available-Dictionary header, a structured
+// field byte sequence holding a SHA-256 hash.
+func availableDictionary(r *http.Request) ([32]byte, bool) {
+	var hash [32]byte
+	v := strings.TrimSpace(r.Header.Get("Available-Dictionary"))
+	if len(v) < 2 || v[0] != ':' || v[len(v)-1] != ':' {
+		return hash, false
+	}
+	b, err := base64.StdEncoding.DecodeString(v[1 : len(v)-1])
+	if err != nil || len(b) != len(hash) {
+		return hash, false
+	}
+	copy(hash[:], b)
+	return hash, true
+}
+
+// acceptsEncoding reports whether the Accept-Encoding of r allows coding.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(part, ";")
+			if !strings.EqualFold(strings.TrimSpace(name), coding) {
+				continue
+			}
+			q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+			if f, err := strconv.ParseFloat(q, 64); found && err == nil && f == 0 {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// withDictionary sets up compression dictionary transport for a request of
+// the service: matching responses are offered as dictionaries and kept, and
+// compressed against the client's dictionary when it has one we know. The
+// returned func must be called once the response is complete.
+func (ph *RuntimeMux) withDictionary(service *Service, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	cfg := service.CompressionDictionary
+	if cfg == nil || !matchWildcard(cfg.Match, r.URL.Path) || r.Method != http.MethodGet {
+		return w, r, func() {}
+	}
+	maxSize := cfg.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultDictionarySize
+	}
+	// Browsers resolve the pattern against the URL they requested, which
+	// includes any namespace prefix stripped before routing.
+	external := r.URL.Path
+	if u, err := r.URL.Parse(r.RequestURI); err == nil && r.RequestURI != "" {
+		external = u.Path
+	}
+	prefix := strings.TrimSuffix(external, r.URL.Path)
+
+	dw := &dictionaryWriter{
+		ResponseWriter: w,
+		store:          ph.dictionaries,
+		match:          prefix + cfg.Match,
+		maxSize:        maxSize,
+	}
+	if hash, ok := availableDictionary(r); ok && acceptsEncoding(r, "dcz") {
+		if dict := ph.dictionaries.get(hash); dict != nil {
+			dw.dict, dw.hash = dict, hash
+			// Without an Accept-Encoding the transport negotiates its own
+			// compression with the backend and hands over the plain body.
+			r = r.Clone(r.Context())
+			r.Header.Del("Accept-Encoding")
+		}
+	}
+	if r.Header.Get("Available-Dictionary") != "" {
+		if dw.dict == nil {
+			r = r.Clone(r.Context())
+		}
+		r.Header.Del("Available-Dictionary")
+		r.Header.Del("Dictionary-ID")
+	}
+	return dw, r, dw.finish
+}
+
+// dictionaryWriter keeps a copy of a successful response to use as a
+// dictionary and, if dict is set, compresses the response against dict.
+type dictionaryWriter struct {
+	http.ResponseWriter
+	store   *dictionaryStore
+	match   string
+	maxSize int64
+	dict    []byte
+	hash    [32]byte
+
+	wroteHeader bool
+	keep        bool
+	gzipped     bool
+	body        bytes.Buffer
+	enc         *zstd.Encoder
+}
+
+func (w *dictionaryWriter) WriteHeader(code int) {
+	if w.wroteHeader || code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding, Available-Dictionary")
+	encoding := h.Get("Content-Encoding")
+	if code == http.StatusOK && (encoding == "" || encoding == "gzip" && w.dict == nil) {
+		w.keep, w.gzipped = true, encoding == "gzip"
+		h.Set("Use-As-Dictionary", fmt.Sprintf("match=%q", w.match))
+		if w.dict != nil {
+			enc, err := zstd.NewWriter(w.ResponseWriter, zstd.WithEncoderDictRaw(0, w.dict), zstd.WithWindowSize(8<<20))
+			if err == nil {
+				w.enc = enc
+				h.Set("Content-Encoding", "dcz")
+				h.Del("Content-Length")
+				h.Del("ETag")
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+	if w.enc != nil {
+		w.ResponseWriter.Write(dczMagic)
+		w.ResponseWriter.Write(w.hash[:])
+	}
+}
+
+func (w *dictionaryWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.keep {
+		if int64(w.body.Len()+len(p)) <= w.maxSize {
+			w.body.Write(p)
+		} else {
+			w.keep = false
+			w.body = bytes.Buffer{}
+		}
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *dictionaryWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *dictionaryWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish completes the compressed stream and keeps the response as a
+// dictionary if it was complete. Dictionaries are the decoded body, so a
+// gzipped response is decompressed first.
+func (w *dictionaryWriter) finish() {
+	if w.enc != nil {
+		w.enc.Close()
+	}
+	if !w.keep || w.body.Len() == 0 {
+		return
+	}
+	dict := w.body.Bytes()
+	if w.gzipped {
+		zr, err := gzip.NewReader(&w.body)
+		if err != nil {
+			return
+		}
+		if dict, err = io.ReadAll(io.LimitReader(zr, w.maxSize+1)); err != nil || int64(len(dict)) > w.maxSize {
+			return
+		}
+	}
+	w.store.add(dict)
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressionDictionary(t *testing.T) {
+	versions := map[string]string{
+		"/app/assets/main.v1.js": strings.Repeat("function render(items) { return items.map(draw); }\n", 50),
+		"/app/assets/main.v2.js": strings.Repeat("function render(items) { return items.map(draw); }\n", 50) + "render([]);\n",
+	}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := versions[r.URL.Path]
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			zw.Write([]byte(body))
+			zw.Close()
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer backend.Close()
+
+	runtimeMux := NewRuntimeMux()
+	service, _ := NewService("app", "/app/", backend.URL)
+	if err := service.EnableCompressionDictionary(DictionaryConfig{Match: "/other/*.js"}); err == nil {
+		t.Error("Expected a pattern outside the service path to be rejected")
+	}
+	service.EnableCompressionDictionary(DictionaryConfig{Match: "/app/assets/main.*.js"})
+	runtimeMux.AddProxy(service)
+	mux := http.NewServeMux()
+	mux.Handle(runtimeMux.MountNamespace("/projects", NamespaceOptions{}))
+
+	// The first visit gets a gzipped response, kept decoded as a dictionary.
+	r := httptest.NewRequest("GET", "/projects/app/assets/main.v1.js", nil)
+	r.Header.Set("Accept-Encoding", "gzip, dcz")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if got := w.Header().Get("Use-As-Dictionary"); got != `match="/projects/app/assets/main.*.js"` {
+		t.Fatalf("Unexpected Use-As-Dictionary %q", got)
+	}
+
+	hash := sha256.Sum256([]byte(versions["/app/assets/main.v1.js"]))
+	r = httptest.NewRequest("GET", "/projects/app/assets/main.v2.js", nil)
+	r.Header.Set("Accept-Encoding", "gzip, dcz")
+	r.Header.Set("Available-Dictionary", ":"+base64.StdEncoding.EncodeToString(hash[:])+":")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "dcz" {
+		t.Fatalf("Expected a dcz response, got %q", w.Header().Get("Content-Encoding"))
+	}
+	body := w.Body.Bytes()
+	if !bytes.HasPrefix(body, append(dczMagic, hash[:]...)) {
+		t.Fatal("Expected the dcz header with the dictionary hash")
+	}
+	dec, _ := zstd.NewReader(nil, zstd.WithDecoderDictRaw(0, []byte(versions["/app/assets/main.v1.js"])))
+	plain, err := dec.DecodeAll(body[len(dczMagic)+len(hash):], nil)
+	if err != nil || string(plain) != versions["/app/assets/main.v2.js"] {
+		t.Fatalf("Failed to decode the dcz response: %v", err)
+	}
+	if len(body) > len(plain)/10 {
+		t.Errorf("Expected the delta to be much smaller than %d bytes, got %d", len(plain), len(body))
+	}
+
+	// An unknown dictionary gets the regular response.
+	r.Header.Set("Available-Dictionary", ":"+base64.StdEncoding.EncodeToString(make([]byte, 32))+":")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected the backend's encoding for an unknown dictionary, got %q", w.Header().Get("Content-Encoding"))
+	}
+}
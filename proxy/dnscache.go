@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// DefaultDNSNegativeTTL is how long a failed upstream host name lookup is
+// remembered by default.
+const DefaultDNSNegativeTTL = 5 * time.Second
+
+// UpstreamDNS remembers the failed host name lookups of every service's
+// upstream connections.
+var UpstreamDNS = NewDNSCache(DefaultDNSNegativeTTL)
+
+// DNSCache caches upstream host names that failed to resolve, so while a
+// backend's name does not resolve its requests fail at once with 503 and a
+// Retry-After instead of each waiting for, and loading, the resolver. Once
+// the TTL has passed, one request retries the lookup while the others keep
+// failing; the host recovers as soon as it resolves.
+type DNSCache struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*dnsFailure
+	hits  atomic.Int64
+}
+
+type dnsFailure struct {
+	err      *net.DNSError
+	since    time.Time
+	until    time.Time
+	retrying bool
+	hits     int64
+}
+
+// DNSFailureError is an upstream host name that failed to resolve, either
+// just now or, if Cached, within the TTL before.
+type DNSFailureError struct {
+	Err    *net.DNSError
+	Until  time.Time
+	Cached bool
+}
+
+func (e *DNSFailureError) Error() string {
+	if e.Cached {
+		return e.Err.Error() + " (cached)"
+	}
+	return e.Err.Error()
+}
+
+func (e *DNSFailureError) Unwrap() error { return e.Err }
+
+// NewDNSCache returns a cache remembering failed lookups for ttl; a zero
+// ttl remembers none.
+func NewDNSCache(ttl time.Duration) *DNSCache {
+	return &DNSCache{ttl: ttl, hosts: make(map[string]*dnsFailure)}
+}
+
+// dnsDial wraps an upstream transport's dial function with UpstreamDNS.
+func dnsDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return UpstreamDNS.dial(ctx, dial, network, addr)
+	}
+}
+
+func (c *DNSCache) dial(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || c.ttl <= 0 || net.ParseIP(host) != nil {
+		return dial(ctx, network, addr)
+	}
+	host = strings.ToLower(host)
+	if err := c.check(host); err != nil {
+		return nil, err
+	}
+	conn, err := dial(ctx, network, addr)
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr) && ctx.Err() == nil:
+		return nil, c.fail(host, dnsErr)
+	case errors.As(err, &dnsErr):
+		// The request gave up on the lookup, so another one retries it.
+		c.mu.Lock()
+		if f := c.hosts[host]; f != nil {
+			f.retrying = false
+		}
+		c.mu.Unlock()
+	default:
+		// Any other outcome means the name resolved.
+		c.recover(host)
+	}
+	return conn, err
+}
+
+// check returns the cached failure of host, unless it has expired and the
+// caller is the one to retry the lookup.
+func (c *DNSCache) check(host string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := c.hosts[host]
+	if f == nil {
+		return nil
+	}
+	if !time.Now().Before(f.until) && !f.retrying {
+		f.retrying = true
+		return nil
+	}
+	f.hits++
+	c.hits.Add(1)
+	return &DNSFailureError{Err: f.err, Until: f.until, Cached: true}
+}
+
+func (c *DNSCache) fail(host string, err *net.DNSError) error {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := c.hosts[host]
+	if f == nil {
+		f = &dnsFailure{since: now}
+		c.hosts[host] = f
+	}
+	f.err = err
+	f.until = now.Add(c.ttl)
+	f.retrying = false
+	return &DNSFailureError{Err: err, Until: f.until}
+}
+
+func (c *DNSCache) recover(host string) {
+	c.mu.Lock()
+	f := c.hosts[host]
+	delete(c.hosts, host)
+	c.mu.Unlock()
+	if f != nil {
+		log.Printf("Upstream host %s resolves again after failing since %s", host, f.since.Format(time.RFC3339))
+	}
+}
+
+// DNSStats are the upstream host names failing to resolve.
+type DNSStats struct {
+	TTLMs int64 `json:"ttl_ms"`
+	// Hits counts requests failed from the cache instead of a lookup.
+	Hits  int64         `json:"hits"`
+	Hosts []DNSHostStat `json:"hosts"`
+}
+
+// DNSHostStat is an upstream host name failing to resolve.
+type DNSHostStat struct {
+	Host  string    `json:"host"`
+	Error string    `json:"error"`
+	Since time.Time `json:"since"`
+	// Until is when the lookup is next retried.
+	Until time.Time `json:"until"`
+	Hits  int64     `json:"hits"`
+}
+
+// Stats returns the failing host names, sorted.
+func (c *DNSCache) Stats() DNSStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := DNSStats{TTLMs: c.ttl.Milliseconds(), Hits: c.hits.Load(), Hosts: []DNSHostStat{}}
+	for host, f := range c.hosts {
+		s.Hosts = append(s.Hosts, DNSHostStat{Host: host, Error: f.err.Error(), Since: f.since, Until: f.until, Hits: f.hits})
+	}
+	sort.Slice(s.Hosts, func(i, j int) bool { return s.Hosts[i].Host < s.Hosts[j].Host })
+	return s
+}
+
+// AdminHandler serves the failing upstream host names:
+//
+//	GET /          DNSStats as JSON
+//	GET /metrics   the same in Prometheus text format
+//	DELETE /       forget the failures, retrying every lookup
+func (c *DNSCache) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, c.Stats())
+	})
+	mux.HandleFunc("DELETE /{$}", func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		c.hosts = make(map[string]*dnsFailure)
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		s := c.Stats()
+		var b strings.Builder
+		fmt.Fprintf(&b, "# HELP proxy_upstream_dns_negative_cache_hits_total Upstream requests failed from the negative DNS cache.\n# TYPE proxy_upstream_dns_negative_cache_hits_total counter\n")
+		fmt.Fprintf(&b, "proxy_upstream_dns_negative_cache_hits_total %d\n", s.Hits)
+		fmt.Fprintf(&b, "# HELP proxy_upstream_dns_failing_hosts Upstream host names failing to resolve.\n# TYPE proxy_upstream_dns_failing_hosts gauge\n")
+		fmt.Fprintf(&b, "proxy_upstream_dns_failing_hosts %d\n", len(s.Hosts))
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(b.String()))
+	})
+	return mux
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDNSCache(t *testing.T) {
+	lookups := 0
+	resolves := false
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		lookups++
+		if !resolves {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: &net.DNSError{Err: "no such host", Name: "backend.internal", IsNotFound: true}}
+		}
+		return nil, errors.New("connection refused")
+	}
+	c := NewDNSCache(time.Hour)
+	ctx := context.Background()
+
+	_, err := c.dial(ctx, dial, "tcp", "backend.internal:80")
+	var dnsErr *DNSFailureError
+	if !errors.As(err, &dnsErr) || dnsErr.Cached {
+		t.Fatalf("Expected a fresh DNS failure, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = c.dial(ctx, dial, "tcp", "Backend.Internal:80")
+		if !errors.As(err, &dnsErr) || !dnsErr.Cached {
+			t.Fatalf("Expected a cached DNS failure, got %v", err)
+		}
+	}
+	if lookups != 1 || c.Stats().Hits != 3 || len(c.Stats().Hosts) != 1 {
+		t.Errorf("Expected 1 lookup and 3 hits, got %d lookups and %+v", lookups, c.Stats())
+	}
+
+	// Once the failure expires, one request retries the lookup and the
+	// host recovers when the name resolves.
+	c.hosts["backend.internal"].until = time.Now()
+	resolves = true
+	if _, err = c.dial(ctx, dial, "tcp", "backend.internal:80"); errors.As(err, &dnsErr) {
+		t.Fatalf("Expected the lookup to be retried, got %v", err)
+	}
+	if lookups != 2 || len(c.Stats().Hosts) != 0 {
+		t.Errorf("Expected the host to recover, got %d lookups and %+v", lookups, c.Stats())
+	}
+
+	rec := httptest.NewRecorder()
+	proxyErrorHandler("api")(rec, httptest.NewRequest("GET", "/", nil), &DNSFailureError{
+		Err: &net.DNSError{Err: "no such host", Name: "backend.internal"}, Until: time.Now().Add(3 * time.Second), Cached: true,
+	})
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "3" {
+		t.Errorf("Expected 503 with Retry-After 3, got %d and %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
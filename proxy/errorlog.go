@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultErrorLogWindow is how long identical upstream errors are folded
+// into one log line by default.
+const DefaultErrorLogWindow = time.Minute
+
+// UpstreamErrors logs the errors of every service's upstream calls.
+var UpstreamErrors = NewErrorLog(DefaultErrorLogWindow)
+
+// ErrorLog logs upstream errors without flooding the log when a backend
+// goes down. The first error of a service and class is logged at once;
+// further ones within the window are counted and logged as one summary
+// line, with the times of the first and last, when the window ends.
+type ErrorLog struct {
+	window time.Duration
+	printf func(format string, v ...any)
+
+	mu     sync.Mutex
+	groups map[errorKey]*errorGroup
+}
+
+type errorKey struct {
+	service string
+	class   string
+}
+
+type errorGroup struct {
+	first, last time.Time
+	// repeated counts the errors after the one logged.
+	repeated int
+	lastErr  string
+}
+
+// NewErrorLog returns a log folding identical errors within window; a zero
+// window logs every error.
+func NewErrorLog(window time.Duration) *ErrorLog {
+	return &ErrorLog{window: window, printf: log.Printf, groups: make(map[errorKey]*errorGroup)}
+}
+
+// Log records an error of an upstream call of service.
+func (l *ErrorLog) Log(service string, err error) {
+	class := errorClass(err)
+	if l.window <= 0 {
+		l.printf("http: proxy error: service=%s class=%s: %v", service, class, err)
+		return
+	}
+	key := errorKey{service, class}
+	now := time.Now()
+	l.mu.Lock()
+	if g := l.groups[key]; g != nil {
+		g.repeated++
+		g.last = now
+		g.lastErr = err.Error()
+		l.mu.Unlock()
+		return
+	}
+	l.groups[key] = &errorGroup{first: now, last: now}
+	l.mu.Unlock()
+	l.printf("http: proxy error: service=%s class=%s: %v", service, class, err)
+	time.AfterFunc(l.window, func() { l.flush(key) })
+}
+
+// flush ends the window of key, logging the errors counted in it.
+func (l *ErrorLog) flush(key errorKey) {
+	l.mu.Lock()
+	g := l.groups[key]
+	delete(l.groups, key)
+	l.mu.Unlock()
+	if g == nil || g.repeated == 0 {
+		return
+	}
+	l.printf("http: proxy error: service=%s class=%s: %d more between %s and %s, last: %s",
+		key.service, key.class, g.repeated, g.first.Format(time.RFC3339), g.last.Format(time.RFC3339), g.lastErr)
+}
+
+// errorClass names the kind of an upstream error, so errors differing only
+// in details such as addresses or ports are folded together.
+func errorClass(err error) string {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var alert tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE):
+		return "connection_reset"
+	case errors.As(err, &certErr) || errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) ||
+		errors.As(err, &alert) || errors.As(err, &recordErr) || strings.Contains(err.Error(), "tls: "):
+		return "tls"
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return "other"
+}
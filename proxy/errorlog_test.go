@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestErrorLogFoldsRepeatedErrors(t *testing.T) {
+	var mu sync.Mutex
+	var lines []string
+	l := NewErrorLog(50 * time.Millisecond)
+	l.printf = func(format string, v ...any) {
+		mu.Lock()
+		lines = append(lines, fmt.Sprintf(format, v...))
+		mu.Unlock()
+	}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	for i := 0; i < 5; i++ {
+		l.Log("api", refused)
+	}
+	l.Log("api", context.DeadlineExceeded)
+	l.Log("blog", refused)
+	time.Sleep(150 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(lines) != 4 {
+		t.Fatalf("Expected 3 first errors and 1 summary, got %q", lines)
+	}
+	var summary string
+	for _, line := range lines {
+		if strings.Contains(line, "more between") {
+			summary = line
+		}
+	}
+	if !strings.Contains(summary, "service=api class=connection_refused: 4 more") {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, "connection_refused"},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), "connection_reset"},
+		{&net.DNSError{Err: "no such host", Name: "api.internal"}, "dns"},
+		{fmt.Errorf("proxy: %w", context.DeadlineExceeded), "timeout"},
+		{context.Canceled, "canceled"},
+		{errors.New("tls: failed to verify certificate"), "tls"},
+		{errors.New("something else"), "other"},
+	}
+	for _, tt := range tests {
+		if got := errorClass(tt.err); got != tt.want {
+			t.Errorf("errorClass(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
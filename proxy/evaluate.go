@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// EvalRequest describes a request to evaluate without sending it.
+type EvalRequest struct {
+	Method string `json:"method,omitempty"`
+	Host   string `json:"host"`
+	// Path is the request path as the client sends it, with any query.
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Evaluation is what the proxy would do with an EvalRequest.
+type Evaluation struct {
+	// Service and Route are empty if no service matches; such requests
+	// reach the proxy's own pages.
+	Service string `json:"service,omitempty"`
+	Route   string `json:"route,omitempty"`
+	// MatchedBy is "host" or "path".
+	MatchedBy string `json:"matched_by,omitempty"`
+	// Middleware is the global chain the request passes, outermost first,
+	// and ServiceMiddleware the service's own, such as access logging.
+	Middleware        []string `json:"middleware"`
+	ServiceMiddleware []string `json:"service_middleware,omitempty"`
+	Region            string   `json:"region,omitempty"`
+	// Override is the developer whose override cookie reroutes the request.
+	Override string `json:"override,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+	// Error is why the request would be refused before reaching an
+	// upstream, e.g. a variable of a templated URL missing.
+	Error string `json:"error,omitempty"`
+}
+
+// Evaluate reports which service, middleware and upstream URL the
+// described request would get, for services mounted at prefix.
+func (ph *RuntimeMux) Evaluate(req EvalRequest, prefix string) (Evaluation, error) {
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+	if req.Host == "" || strings.ContainsAny(req.Host, "/ ") {
+		return Evaluation{}, fmt.Errorf("invalid host %q", req.Host)
+	}
+	if !strings.HasPrefix(req.Path, "/") {
+		return Evaluation{}, fmt.Errorf("path must start with /")
+	}
+	r, err := http.NewRequest(req.Method, "http://"+req.Host+req.Path, nil)
+	if err != nil {
+		return Evaluation{}, fmt.Errorf("invalid request: %v", err)
+	}
+	for name, value := range req.Headers {
+		r.Header.Set(name, value)
+	}
+
+	service, path, byHost := ph.route(r, prefix)
+	ph.RLock()
+	c := ph.chain
+	ph.RUnlock()
+	eval := Evaluation{Middleware: []string{}}
+	if c != nil {
+		eval.Middleware = c.Effective(service)
+	}
+	if service == nil {
+		return eval, nil
+	}
+	eval.Service, eval.Route, eval.MatchedBy = service.Name, service.Path, "path"
+	if byHost {
+		eval.MatchedBy = "host"
+	}
+	for _, m := range service.middlewares {
+		eval.ServiceMiddleware = append(eval.ServiceMiddleware, m.name)
+	}
+
+	out := r.Clone(context.Background())
+	out.URL.Path, out.URL.RawPath = path, ""
+	if u, developer := ph.overrideTarget(service, r); u != nil {
+		eval.Override = developer
+		out = out.WithContext(context.WithValue(out.Context(), targetKey{}, u))
+	} else if rr := service.region(r, ph.countryHeader()); rr != nil && service.backend == nil {
+		eval.Region = rr.Name
+		u, _ := parseHTTPURL(rr.URL)
+		out = out.WithContext(context.WithValue(out.Context(), targetKey{}, u))
+	} else if u, err := service.templateTarget(r); err != nil {
+		eval.Error = err.Error()
+		return eval, nil
+	} else if u != nil {
+		out = out.WithContext(context.WithValue(out.Context(), targetKey{}, u))
+	}
+	service.Director(out)
+	eval.Upstream = out.URL.String()
+	return eval, nil
+}
+
+// EvaluateHandler serves POST requests with an EvalRequest, answering with
+// its Evaluation.
+func (ph *RuntimeMux) EvaluateHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EvalRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+			return
+		}
+		eval, err := ph.Evaluate(req, prefix)
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, eval)
+	})
+}
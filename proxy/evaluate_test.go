@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	mux := NewRuntimeMux()
+	chain := mux.NewChain("/projects")
+	chain.Use("security-headers", func(next http.Handler) http.Handler { return next })
+	chain.Use("auth", func(next http.Handler) http.Handler { return next })
+
+	shop, _ := NewService("shop", "/shop/", "http://shop.internal:8080/app")
+	shop.SetRegions([]RegionRoute{{Name: "eu", URL: "http://eu.shop.internal", Countries: []string{"EU"}}})
+	shop.SetMiddleware([]string{"auth"})
+	shop.Use("stamp", func(next http.Handler) http.Handler { return next })
+	api, _ := NewService("api", "/api/", "http://api.internal")
+	api.SetHosts([]string{"api.example.com"})
+	mux.AddProxy(shop)
+	mux.AddProxy(api)
+
+	for _, tc := range []struct {
+		req  EvalRequest
+		want Evaluation
+	}{
+		{
+			EvalRequest{Host: "example.com", Path: "/projects/shop/cart?id=1"},
+			Evaluation{Service: "shop", Route: "/shop/", MatchedBy: "path", Middleware: []string{"auth"}, ServiceMiddleware: []string{"stamp"}, Upstream: "http://shop.internal:8080/app/shop/cart?id=1"},
+		},
+		{
+			EvalRequest{Host: "example.com", Path: "/projects/shop/", Headers: map[string]string{"CF-IPCountry": "de"}},
+			Evaluation{Service: "shop", Route: "/shop/", MatchedBy: "path", Middleware: []string{"auth"}, ServiceMiddleware: []string{"stamp"}, Region: "eu", Upstream: "http://eu.shop.internal/shop/"},
+		},
+		{
+			EvalRequest{Host: "API.example.com:443", Path: "/v1/users"},
+			Evaluation{Service: "api", Route: "/api/", MatchedBy: "host", Middleware: []string{"security-headers", "auth"}, Upstream: "http://api.internal/v1/users"},
+		},
+		{
+			EvalRequest{Host: "example.com", Path: "/about"},
+			Evaluation{Middleware: []string{"security-headers", "auth"}},
+		},
+	} {
+		got, err := mux.Evaluate(tc.req, "/projects")
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(tc.want)
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("%s%s: got %s, want %s", tc.req.Host, tc.req.Path, gotJSON, wantJSON)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	mux.EvaluateHandler("/projects").ServeHTTP(w, httptest.NewRequest("POST", "/evaluate", strings.NewReader(`{"host": "example.com", "path": "no-slash"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a relative path, got %d", w.Code)
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// RouteGraph is the path requests take through the proxy, from the
+// listeners to the backends.
+type RouteGraph struct {
+	Listeners []string     `json:"listeners"`
+	Routes    []GraphRoute `json:"routes"`
+}
+
+// GraphRoute is one service in a RouteGraph.
+type GraphRoute struct {
+	Service string `json:"service"`
+	// Hosts are the host names routed to the service, or "*" for a service
+	// reached by path on any host not routed elsewhere.
+	Hosts []string `json:"hosts"`
+	// Path is the path the service is reached at, below the namespace
+	// prefix for services routed by path.
+	Path              string   `json:"path"`
+	Middleware        []string `json:"middleware"`
+	ServiceMiddleware []string `json:"service_middleware,omitempty"`
+	// Upstreams are the service URL followed by any region backends.
+	Upstreams []string `json:"upstreams"`
+}
+
+// Graph returns the routing graph of the services mounted at prefix and
+// served on listeners.
+func (ph *RuntimeMux) Graph(prefix string, listeners []string) RouteGraph {
+	ph.RLock()
+	c := ph.chain
+	services := make([]*Service, 0, len(ph.proxyServers))
+	for _, service := range ph.proxyServers {
+		if service != nil {
+			services = append(services, service)
+		}
+	}
+	ph.RUnlock()
+	sort.Slice(services, func(i, j int) bool { return services[i].Path < services[j].Path })
+
+	g := RouteGraph{Listeners: append([]string{}, listeners...), Routes: []GraphRoute{}}
+	for _, service := range services {
+		route := GraphRoute{
+			Service:    service.Name,
+			Hosts:      []string{"*"},
+			Path:       strings.TrimSuffix(prefix, "/") + service.Path,
+			Middleware: []string{},
+			Upstreams:  []string{service.Url},
+		}
+		if len(service.Hosts) > 0 {
+			route.Hosts, route.Path = append([]string{}, service.Hosts...), "/"
+		}
+		if c != nil {
+			route.Middleware = c.Effective(service)
+		}
+		for _, m := range service.middlewares {
+			route.ServiceMiddleware = append(route.ServiceMiddleware, m.name)
+		}
+		for _, rr := range service.Regions {
+			route.Upstreams = append(route.Upstreams, rr.URL)
+		}
+		g.Routes = append(g.Routes, route)
+	}
+	return g
+}
+
+// DOT renders the graph in Graphviz DOT format.
+func (g RouteGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph routes {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	node := func(kind, name string) string {
+		return strconv.Quote(kind + ":" + name)
+	}
+	// Hosts and upstreams may be shared by several routes, so each line
+	// is written once.
+	written := make(map[string]bool)
+	line := func(format string, args ...any) {
+		if l := fmt.Sprintf("\t"+format+";\n", args...); !written[l] {
+			written[l] = true
+			b.WriteString(l)
+		}
+	}
+	edge := func(from, to string) {
+		line("%s -> %s", from, to)
+	}
+	for _, l := range g.Listeners {
+		line("%s [label=%s, shape=ellipse]", node("listener", l), strconv.Quote(l))
+	}
+	for _, r := range g.Routes {
+		route := node("route", r.Service)
+		chain := append(append([]string{}, r.Middleware...), r.ServiceMiddleware...)
+		label := r.Service + "\n" + r.Path
+		if len(chain) > 0 {
+			label += "\n" + strings.Join(chain, " → ")
+		}
+		line("%s [label=%s]", route, strconv.Quote(label))
+		for _, h := range r.Hosts {
+			host := node("host", h)
+			line("%s [label=%s, shape=diamond]", host, strconv.Quote(h))
+			for _, l := range g.Listeners {
+				edge(node("listener", l), host)
+			}
+			edge(host, route)
+		}
+		for _, u := range r.Upstreams {
+			upstream := node("upstream", u)
+			line("%s [label=%s, shape=cylinder]", upstream, strconv.Quote(u))
+			edge(route, upstream)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// GraphHandler exports the routing graph:
+//
+//	GET /            RouteGraph as JSON
+//	GET /?format=dot the graph in Graphviz DOT format
+func (ph *RuntimeMux) GraphHandler(prefix string, listeners []string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		g := ph.Graph(prefix, listeners)
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+			admin.WriteJSON(w, http.StatusOK, g)
+		case "dot":
+			w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+			w.Write([]byte(g.DOT()))
+		default:
+			admin.WriteError(w, http.StatusBadRequest, fmt.Errorf("unsupported format %q, expected json or dot", r.URL.Query().Get("format")))
+		}
+	})
+	return mux
+}
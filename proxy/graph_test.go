@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGraph(t *testing.T) {
+	mux := NewRuntimeMux()
+	mux.NewChain("/projects").Use("auth", func(next http.Handler) http.Handler { return next })
+	shop, _ := NewService("shop", "/shop/", "http://shop.internal")
+	shop.SetRegions([]RegionRoute{{Name: "eu", URL: "http://eu.shop.internal", Countries: []string{"DE"}}})
+	api, _ := NewService("api", "/api/", "http://api.internal")
+	api.SetHosts([]string{"api.example.com"})
+	mux.AddProxy(shop)
+	mux.AddProxy(api)
+
+	w := httptest.NewRecorder()
+	mux.GraphHandler("/projects", []string{":443"}).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	var g RouteGraph
+	json.Unmarshal(w.Body.Bytes(), &g)
+	if len(g.Routes) != 2 || g.Routes[0].Service != "api" || g.Routes[0].Path != "/" || g.Routes[0].Hosts[0] != "api.example.com" ||
+		g.Routes[1].Path != "/projects/shop/" || g.Routes[1].Hosts[0] != "*" || len(g.Routes[1].Upstreams) != 2 || g.Routes[1].Middleware[0] != "auth" {
+		t.Errorf("Unexpected graph %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	mux.GraphHandler("/projects", []string{":443"}).ServeHTTP(w, httptest.NewRequest("GET", "/?format=dot", nil))
+	dot := w.Body.String()
+	for _, want := range []string{
+		`"listener::443" -> "host:api.example.com";`,
+		`"host:*" -> "route:shop";`,
+		`"route:shop" -> "upstream:http://eu.shop.internal";`,
+		`"route:shop" [label="shop\n/projects/shop/\nauth"];`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("Expected %s in:\n%s", want, dot)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxTruncatedHeaderBytes is the most response header bytes read from a
+// backend whose oversized headers are truncated rather than rejected.
+const maxTruncatedHeaderBytes = 1 << 20
+
+// HeaderLimitConfig bounds the response headers a backend may send to
+// clients.
+type HeaderLimitConfig struct {
+	// MaxBytes is the largest total size of the header fields, counted as
+	// "Name: value\r\n" lines; 0 means no limit.
+	MaxBytes int `json:"max_bytes,omitempty"`
+	// MaxCount is the largest number of header fields; 0 means no limit.
+	MaxCount int `json:"max_count,omitempty"`
+	// Truncate drops the largest header fields until the response fits,
+	// instead of answering 502 Bad Gateway.
+	Truncate bool `json:"truncate,omitempty"`
+}
+
+// keptHeaders are never dropped when truncating, as the response cannot be
+// read correctly without them.
+var keptHeaders = map[string]bool{
+	"Content-Type":      true,
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Content-Range":     true,
+	"Transfer-Encoding": true,
+	"Trailer":           true,
+	"Location":          true,
+	"Vary":              true,
+}
+
+// SetResponseHeaderLimits bounds the size and number of the headers of the
+// service's responses. Oversized responses are replaced by 502 Bad Gateway,
+// and in that case headers above MaxBytes are not even read; with Truncate
+// the largest header fields are dropped instead. A nil cfg removes the
+// limits.
+func (s *Service) SetResponseHeaderLimits(cfg *HeaderLimitConfig) error {
+	if cfg == nil {
+		s.ResponseHeaderLimits = nil
+		s.middlewares = s.without("header-limits")
+		s.resetTransport()
+		return nil
+	}
+	if cfg.MaxBytes < 0 || cfg.MaxCount < 0 {
+		return errors.New("response header limits must not be negative")
+	}
+	if cfg.MaxBytes == 0 && cfg.MaxCount == 0 {
+		return errors.New("response header limits need max_bytes or max_count")
+	}
+	limits := *cfg
+	s.ResponseHeaderLimits = &limits
+	s.resetTransport()
+	alerts := &headerLimitAlerts{service: s.Name}
+	s.Use("header-limits", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&limitWriter{ResponseWriter: w, limits: limits, alerts: alerts}, r)
+		})
+	})
+	return nil
+}
+
+// maxResponseHeaderBytes is the limit of the service's transport on the
+// response headers it reads; 0 leaves the transport's default.
+func (s *Service) maxResponseHeaderBytes() int64 {
+	switch l := s.ResponseHeaderLimits; {
+	case l == nil || l.MaxBytes == 0:
+		return 0
+	case l.Truncate:
+		return max(maxTruncatedHeaderBytes, int64(l.MaxBytes))
+	default:
+		return int64(l.MaxBytes)
+	}
+}
+
+// headerLimitAlerts logs oversized responses of a service at most once a
+// minute.
+type headerLimitAlerts struct {
+	service string
+
+	mu      sync.Mutex
+	count   uint64
+	lastLog time.Time
+}
+
+func (a *headerLimitAlerts) alert(count, size int, action string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.count++
+	if now := time.Now(); now.Sub(a.lastLog) >= time.Minute {
+		a.lastLog = now
+		log.Printf("Backend of %s sent %d response headers (%d bytes), above its limits; %s (%d such responses so far)",
+			a.service, count, size, action, a.count)
+	}
+}
+
+// limitWriter checks the response headers copied from the backend when
+// they are written.
+type limitWriter struct {
+	http.ResponseWriter
+	limits   HeaderLimitConfig
+	alerts   *headerLimitAlerts
+	wrote    bool
+	rejected bool
+}
+
+func (w *limitWriter) over(count, size int) bool {
+	return w.limits.MaxCount > 0 && count > w.limits.MaxCount || w.limits.MaxBytes > 0 && size > w.limits.MaxBytes
+}
+
+func (w *limitWriter) WriteHeader(code int) {
+	if w.wrote || code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wrote = true
+	h := w.Header()
+	count, size := headerSize(h)
+	if !w.over(count, size) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.limits.Truncate {
+		w.alerts.alert(count, size, "dropped the largest")
+		w.truncate(h, count, size)
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.alerts.alert(count, size, "answered 502")
+	w.rejected = true
+	for name := range h {
+		delete(h, name)
+	}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	w.ResponseWriter.WriteHeader(http.StatusBadGateway)
+	w.ResponseWriter.Write([]byte("Bad Gateway\n"))
+}
+
+// truncate drops the largest header fields until h is within the limits.
+func (w *limitWriter) truncate(h http.Header, count, size int) {
+	type field struct {
+		name string
+		size int
+	}
+	var fields []field
+	for name, values := range h {
+		if keptHeaders[name] {
+			continue
+		}
+		n := 0
+		for _, v := range values {
+			n += len(name) + len(v) + len(": \r\n")
+		}
+		fields = append(fields, field{name, n})
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		if fields[i].size != fields[j].size {
+			return fields[i].size > fields[j].size
+		}
+		return fields[i].name < fields[j].name
+	})
+	for _, f := range fields {
+		if !w.over(count, size) {
+			return
+		}
+		count -= len(h[f.name])
+		size -= f.size
+		delete(h, f.name)
+	}
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.rejected {
+		// The backend's body is discarded rather than failing the copy,
+		// which would abort the connection and lose the 502.
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *limitWriter) Flush() {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *limitWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseHeaderLimits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		for i := 0; i < 20; i++ {
+			w.Header().Set(fmt.Sprintf("X-Small-%d", i), "x")
+		}
+		if r.URL.Query().Has("big") {
+			w.Header().Set("X-Big", strings.Repeat("b", 4000))
+		}
+		w.Write([]byte("body"))
+	}))
+	defer backend.Close()
+
+	get := func(service *Service, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		service.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	service, _ := NewService("api", "/api/", backend.URL)
+	if err := service.SetResponseHeaderLimits(&HeaderLimitConfig{MaxCount: -1}); err == nil {
+		t.Error("Expected a negative limit to be rejected")
+	}
+	if err := service.SetResponseHeaderLimits(&HeaderLimitConfig{MaxBytes: 2048, MaxCount: 30}); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(service, "/api/"); w.Code != http.StatusOK || w.Body.String() != "body" {
+		t.Errorf("Expected headers within the limits to pass, got %d %q", w.Code, w.Body.String())
+	}
+	if w := get(service, "/api/?big"); w.Code != http.StatusBadGateway || w.Header().Get("X-Small-1") != "" {
+		t.Errorf("Expected oversized headers to be rejected, got %d %v", w.Code, w.Header())
+	}
+
+	counted, _ := NewService("api", "/api/", backend.URL)
+	counted.SetResponseHeaderLimits(&HeaderLimitConfig{MaxCount: 10})
+	if w := get(counted, "/api/"); w.Code != http.StatusBadGateway || strings.Contains(w.Body.String(), "body") {
+		t.Errorf("Expected too many headers to be rejected, got %d %q", w.Code, w.Body.String())
+	}
+
+	truncated, _ := NewService("api", "/api/", backend.URL)
+	truncated.SetResponseHeaderLimits(&HeaderLimitConfig{MaxBytes: 2048, MaxCount: 10, Truncate: true})
+	w := get(truncated, "/api/?big")
+	if w.Code != http.StatusOK || w.Body.String() != "body" || w.Header().Get("X-Big") != "" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected the largest headers to be dropped, got %d %v", w.Code, w.Header())
+	}
+	if count, size := headerSize(w.Header()); count > 10 || size > 2048 {
+		t.Errorf("Expected truncated headers within the limits, got %d fields of %d bytes", count, size)
+	}
+
+	truncated.SetResponseHeaderLimits(nil)
+	if w := get(truncated, "/api/?big"); w.Header().Get("X-Big") == "" {
+		t.Error("Expected removing the limits to pass every header")
+	}
+}
@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// HeaderThresholds flag requests whose header count, header size or URL
+// length is unusual for a proxy to see, which is often an attack (header
+// stuffing, oversized cookies) or a client bug. A zero threshold disables
+// the check.
+type HeaderThresholds struct {
+	MaxCount     int
+	MaxBytes     int
+	MaxURLLength int
+	// OnAnomaly, if set, is called for every anomalous request, e.g. to
+	// alert. Alerts are also logged, at most once per AlertInterval per
+	// service and measure.
+	OnAnomaly     func(HeaderAnomaly)
+	AlertInterval time.Duration
+}
+
+// HeaderAnomaly is a request that exceeded a threshold.
+type HeaderAnomaly struct {
+	Service    string `json:"service"`
+	Measure    string `json:"measure"` // header_count, header_bytes or url_length
+	Value      int    `json:"value"`
+	Threshold  int    `json:"threshold"`
+	RemoteAddr string `json:"remote_addr"`
+}
+
+// histogramBuckets covers values up to 2^20; larger ones go in the last
+// bucket.
+const histogramBuckets = 21
+
+// histogram counts values in power-of-two buckets.
+type histogram struct {
+	buckets [histogramBuckets]uint64
+	count   uint64
+	max     int
+}
+
+func (h *histogram) observe(v int) {
+	i := 0
+	if v > 1 {
+		i = min(bits.Len(uint(v-1)), histogramBuckets-1)
+	}
+	h.buckets[i]++
+	h.count++
+	h.max = max(h.max, v)
+}
+
+// quantile returns the upper bound of the bucket holding quantile q.
+func (h *histogram) quantile(q float64) int {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.count))
+	var seen uint64
+	for i, n := range h.buckets {
+		seen += n
+		if seen > rank {
+			return min(1<<i, h.max)
+		}
+	}
+	return h.max
+}
+
+// Distribution summarizes a histogram. Percentiles are bucket upper bounds.
+type Distribution struct {
+	P50     int      `json:"p50"`
+	P99     int      `json:"p99"`
+	Max     int      `json:"max"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// Bucket counts the values at most Le (and above the previous bucket).
+type Bucket struct {
+	Le    int    `json:"le"`
+	Count uint64 `json:"count"`
+}
+
+func (h *histogram) distribution() Distribution {
+	d := Distribution{P50: h.quantile(0.5), P99: h.quantile(0.99), Max: h.max, Buckets: []Bucket{}}
+	for i, n := range h.buckets {
+		if n > 0 {
+			d.Buckets = append(d.Buckets, Bucket{1 << i, n})
+		}
+	}
+	return d
+}
+
+// headerStats tracks the request header shape of a route.
+type headerStats struct {
+	mu        sync.Mutex
+	count     histogram
+	bytes     histogram
+	urlLength histogram
+	anomalies map[string]uint64
+	lastAlert map[string]time.Time
+}
+
+func newHeaderStats() *headerStats {
+	return &headerStats{anomalies: make(map[string]uint64), lastAlert: make(map[string]time.Time)}
+}
+
+// headerSize returns the number of header lines of h and their size as
+// sent on the wire.
+func headerSize(h http.Header) (count, size int) {
+	for name, values := range h {
+		for _, v := range values {
+			count++
+			size += len(name) + len(v) + len(": \r\n")
+		}
+	}
+	return count, size
+}
+
+// observe records the shape of r and reports anomalies.
+func (hs *headerStats) observe(service string, r *http.Request, t *HeaderThresholds) {
+	count, size := headerSize(r.Header)
+	urlLength := len(r.RequestURI)
+	if urlLength == 0 {
+		urlLength = len(r.URL.String())
+	}
+
+	hs.mu.Lock()
+	hs.count.observe(count)
+	hs.bytes.observe(size)
+	hs.urlLength.observe(urlLength)
+	if t == nil {
+		hs.mu.Unlock()
+		return
+	}
+	var found []HeaderAnomaly
+	for _, m := range []struct {
+		measure          string
+		value, threshold int
+	}{
+		{"header_count", count, t.MaxCount},
+		{"header_bytes", size, t.MaxBytes},
+		{"url_length", urlLength, t.MaxURLLength},
+	} {
+		if m.threshold <= 0 || m.value <= m.threshold {
+			continue
+		}
+		hs.anomalies[m.measure]++
+		a := HeaderAnomaly{service, m.measure, m.value, m.threshold, r.RemoteAddr}
+		found = append(found, a)
+		interval := t.AlertInterval
+		if interval == 0 {
+			interval = time.Minute
+		}
+		if now := time.Now(); now.Sub(hs.lastAlert[m.measure]) >= interval {
+			hs.lastAlert[m.measure] = now
+			log.Printf("ALERT: service %s: request from %s has %s %d, above %d (%d such requests so far)",
+				service, r.RemoteAddr, m.measure, m.value, m.threshold, hs.anomalies[m.measure])
+		}
+	}
+	hs.mu.Unlock()
+
+	if t.OnAnomaly != nil {
+		for _, a := range found {
+			t.OnAnomaly(a)
+		}
+	}
+}
+
+// HeaderStats is the request shape seen by one service.
+type HeaderStats struct {
+	Service     string            `json:"service"`
+	Path        string            `json:"path"`
+	Requests    uint64            `json:"requests"`
+	HeaderCount Distribution      `json:"header_count"`
+	HeaderBytes Distribution      `json:"header_bytes"`
+	URLLength   Distribution      `json:"url_length"`
+	Anomalies   map[string]uint64 `json:"anomalies"`
+}
+
+// HeaderStats returns the request shape of every service since it was
+// first added.
+func (ph *RuntimeMux) HeaderStats() []HeaderStats {
+	ph.RLock()
+	defer ph.RUnlock()
+
+	var all []HeaderStats
+	for path, service := range ph.proxyServers {
+		if service == nil {
+			continue
+		}
+		hs := ph.headers[path]
+		hs.mu.Lock()
+		s := HeaderStats{
+			Service:     service.Name,
+			Path:        path,
+			Requests:    hs.count.count,
+			HeaderCount: hs.count.distribution(),
+			HeaderBytes: hs.bytes.distribution(),
+			URLLength:   hs.urlLength.distribution(),
+			Anomalies:   make(map[string]uint64),
+		}
+		for m, n := range hs.anomalies {
+			s.Anomalies[m] = n
+		}
+		hs.mu.Unlock()
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Path < all[j].Path })
+	return all
+}
+
+// HeaderStatsHandler serves request shape statistics:
+//
+//	GET /            every service
+//	GET /{service}   one service
+func (ph *RuntimeMux) HeaderStatsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, ph.HeaderStats())
+	})
+	mux.HandleFunc("GET /{service}", func(w http.ResponseWriter, r *http.Request) {
+		for _, s := range ph.HeaderStats() {
+			if s.Service == r.PathValue("service") {
+				admin.WriteJSON(w, http.StatusOK, s)
+				return
+			}
+		}
+		admin.WriteError(w, http.StatusNotFound, fmt.Errorf("no service named %s", r.PathValue("service")))
+	})
+	return mux
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	var h histogram
+	for _, v := range []int{0, 1, 2, 3, 4, 5, 100} {
+		h.observe(v)
+	}
+	d := h.distribution()
+	if d.P50 != 4 || d.P99 != 100 || d.Max != 100 {
+		t.Errorf("Unexpected distribution: %+v", d)
+	}
+	want := []Bucket{{1, 2}, {2, 1}, {4, 2}, {8, 1}, {128, 1}}
+	if len(d.Buckets) != len(want) {
+		t.Fatalf("Unexpected buckets: %+v", d.Buckets)
+	}
+	for i := range want {
+		if d.Buckets[i] != want[i] {
+			t.Errorf("Bucket %d: expected %+v, got %+v", i, want[i], d.Buckets[i])
+		}
+	}
+}
+
+func TestHeaderAnomalies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	var anomalies []HeaderAnomaly
+	mux := NewRuntimeMux()
+	mux.HeaderAlerts = &HeaderThresholds{
+		MaxCount:     5,
+		MaxURLLength: 100,
+		OnAnomaly:    func(a HeaderAnomaly) { anomalies = append(anomalies, a) },
+	}
+	service, _ := NewService("api", "/api/", backend.URL)
+	mux.AddProxy(service)
+
+	mux.GetMux().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/", nil))
+	stuffed := httptest.NewRequest("GET", "/api/?q="+strings.Repeat("a", 200), nil)
+	for _, name := range []string{"A", "B", "C", "D", "E", "F"} {
+		stuffed.Header.Set("X-"+name, "1")
+	}
+	mux.GetMux().ServeHTTP(httptest.NewRecorder(), stuffed)
+
+	if len(anomalies) != 2 || anomalies[0].Measure != "header_count" || anomalies[0].Value != 6 || anomalies[1].Measure != "url_length" {
+		t.Errorf("Unexpected anomalies: %+v", anomalies)
+	}
+
+	w := httptest.NewRecorder()
+	mux.HeaderStatsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/api", nil))
+	var stats HeaderStats
+	json.Unmarshal(w.Body.Bytes(), &stats)
+	if stats.Requests != 2 || stats.HeaderCount.Max != 6 || stats.Anomalies["header_count"] != 1 || stats.Anomalies["header_bytes"] != 0 {
+		t.Errorf("Unexpected stats: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"github.com/kirtansoni/reverse-proxy-go/events"
+)
+
+// maxRevisions is how many route tables are kept for diffs and rollback.
+const maxRevisions = 20
+
+// Revision is a route table as it was after a change. Services are never
+// modified once added (updates install a copy), so a revision can hold them
+// directly and rolling back reuses the exact handlers.
+type Revision struct {
+	ID       int                 `json:"id"`
+	Time     time.Time           `json:"time"`
+	Reason   string              `json:"reason"`
+	Services map[string]*Service `json:"services"`
+}
+
+// Change is the difference in one route between two revisions.
+type Change struct {
+	Path   string          `json:"path"`
+	Op     string          `json:"op"` // added, removed or changed
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// record appends the current route table to the history. Callers must hold
+// ph's lock.
+func (ph *RuntimeMux) record(reason string) {
+	services := make(map[string]*Service)
+	for path, service := range ph.proxyServers {
+		if service != nil {
+			services[path] = service
+		}
+	}
+	ph.nextRevision++
+	ph.history = append(ph.history, Revision{
+		ID:       ph.nextRevision,
+		Time:     time.Now(),
+		Reason:   reason,
+		Services: services,
+	})
+	if n := len(ph.history); n > 1 {
+		ph.logChanges(ph.history[n-2], ph.history[n-1])
+		ph.publishChanges(ph.history[n-2], ph.history[n-1])
+	}
+	if len(ph.history) > maxRevisions {
+		ph.history = ph.history[len(ph.history)-maxRevisions:]
+	}
+}
+
+// publishChanges sends an event for each service changed between two
+// revisions, followed by ConfigApplied.
+func (ph *RuntimeMux) publishChanges(prev, cur Revision) {
+	types := map[string]events.Type{"added": events.ServiceAdded, "changed": events.ServiceChanged, "removed": events.ServiceRemoved}
+	for _, c := range diffServices(prev.Services, cur.Services) {
+		service := cur.Services[c.Path]
+		if service == nil {
+			service = prev.Services[c.Path]
+		}
+		ph.Events.Publish(events.Event{Type: types[c.Op], Time: cur.Time, Subject: service.Name, Data: c})
+	}
+	ph.Events.Publish(events.Event{Type: events.ConfigApplied, Time: cur.Time, Data: map[string]any{"revision": cur.ID, "reason": cur.Reason}})
+}
+
+// Revisions returns the kept route tables, oldest first.
+func (ph *RuntimeMux) Revisions() []Revision {
+	ph.RLock()
+	defer ph.RUnlock()
+	return append([]Revision(nil), ph.history...)
+}
+
+// revision returns the revision with the given ID; 0 means the current one
+// and negative IDs count back from it. Callers must hold ph's lock.
+func (ph *RuntimeMux) revision(id int) (Revision, error) {
+	if id <= 0 {
+		i := len(ph.history) - 1 + id
+		if i < 0 {
+			return Revision{}, errors.New("not enough history")
+		}
+		return ph.history[i], nil
+	}
+	for _, rev := range ph.history {
+		if rev.ID == id {
+			return rev, nil
+		}
+	}
+	return Revision{}, fmt.Errorf("revision %d is not in the history", id)
+}
+
+// Diff returns the route changes needed to go from one revision to another.
+func (ph *RuntimeMux) Diff(from, to int) ([]Change, error) {
+	ph.RLock()
+	defer ph.RUnlock()
+
+	a, err := ph.revision(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ph.revision(to)
+	if err != nil {
+		return nil, err
+	}
+	return diffServices(a.Services, b.Services), nil
+}
+
+func diffServices(a, b map[string]*Service) []Change {
+	var changes []Change
+	for path, before := range a {
+		beforeJSON, _ := before.Json()
+		after, ok := b[path]
+		if !ok {
+			changes = append(changes, Change{Path: path, Op: "removed", Before: beforeJSON})
+			continue
+		}
+		afterJSON, _ := after.Json()
+		if !bytes.Equal(beforeJSON, afterJSON) {
+			changes = append(changes, Change{Path: path, Op: "changed", Before: beforeJSON, After: afterJSON})
+		}
+	}
+	for path, after := range b {
+		if _, ok := a[path]; !ok {
+			afterJSON, _ := after.Json()
+			changes = append(changes, Change{Path: path, Op: "added", After: afterJSON})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// Rollback restores the route table of a revision (see revision for how id
+// is interpreted), recording the result as a new revision. The restored
+// routes are not verified as a canary.
+func (ph *RuntimeMux) Rollback(id int) (Revision, error) {
+	ph.Lock()
+	defer ph.Unlock()
+
+	ph.stopCanary()
+	return ph.rollback(id, "rollback")
+}
+
+// rollback restores a revision. Callers must hold ph's lock.
+func (ph *RuntimeMux) rollback(id int, reason string) (Revision, error) {
+	target, err := ph.revision(id)
+	if err != nil {
+		return Revision{}, err
+	}
+	for path := range ph.proxyServers {
+		if _, ok := target.Services[path]; !ok {
+			ph.retire(path)
+		}
+	}
+	for _, service := range target.Services {
+		ph.install(service)
+	}
+	ph.changed(fmt.Sprintf("%s to %d", reason, target.ID))
+	return ph.history[len(ph.history)-1], nil
+}
+
+// AdminHandler serves the route table history:
+//
+//	GET  /                  kept revisions
+//	GET  /diff?from=&to=    changes between two revisions (default: previous to current)
+//	POST /rollback?to=      restore a revision (default: the previous one)
+//	GET  /canary            the change being verified, with its error rate
+//	POST /canary/commit     accept the change being verified now
+//	GET  /changelog/{name}  changes made to one service
+//	GET  /routes            live and registered routes (RouteStats)
+func (ph *RuntimeMux) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	ph.canaryHandlers(mux)
+	ph.changelogHandlers(mux)
+	ph.reloadHandlers(mux)
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, ph.Revisions())
+	})
+	mux.HandleFunc("GET /routes", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, ph.Routes())
+	})
+	mux.HandleFunc("GET /diff", func(w http.ResponseWriter, r *http.Request) {
+		from, err1 := revisionParam(r, "from", -1)
+		to, err2 := revisionParam(r, "to", 0)
+		if err := errors.Join(err1, err2); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		changes, err := ph.Diff(from, to)
+		if err != nil {
+			admin.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, changes)
+	})
+	mux.HandleFunc("POST /rollback", func(w http.ResponseWriter, r *http.Request) {
+		to, err := revisionParam(r, "to", -1)
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		rev, err := ph.Rollback(to)
+		if err != nil {
+			admin.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, rev)
+	})
+	return mux
+}
+
+func revisionParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s revision %q", name, v)
+	}
+	return id, nil
+}
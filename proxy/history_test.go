@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHistoryDiffAndRollback(t *testing.T) {
+	v1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("v1")) }))
+	defer v1.Close()
+	v2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("v2")) }))
+	defer v2.Close()
+
+	mux := NewRuntimeMux()
+	api, _ := NewService("api", "/api/", v1.URL)
+	mux.AddProxy(api)
+	docs, _ := NewService("docs", "/docs/", v1.URL)
+	mux.AddProxy(docs)
+	updated, _ := NewService("api", "/api/", v2.URL)
+	mux.AddProxy(updated)
+	mux.removeHandler(docs)
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		mux.GetMux().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Body.String()
+	}
+	if got := get("/api/"); got != "v2" {
+		t.Fatalf("Expected v2, got %s", got)
+	}
+
+	changes, err := mux.Diff(3, 0)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if len(changes) != 2 || changes[0].Path != "/api/" || changes[0].Op != "changed" ||
+		changes[1].Path != "/docs/" || changes[1].Op != "removed" {
+		t.Errorf("Unexpected changes: %+v", changes)
+	}
+
+	w := httptest.NewRecorder()
+	mux.AdminHandler().ServeHTTP(w, httptest.NewRequest("POST", "/rollback?to=3", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Rollback failed: %d %s", w.Code, w.Body.String())
+	}
+	if got := get("/api/"); got != "v1" {
+		t.Errorf("Expected v1 after rollback, got %s", got)
+	}
+	if got := get("/docs/"); got != "v1" {
+		t.Errorf("Expected removed route to be restored, got %s", got)
+	}
+
+	// Rolling back again undoes the rollback.
+	mux.Rollback(-1)
+	if got := get("/api/"); got != "v2" {
+		t.Errorf("Expected v2 after undoing the rollback, got %s", got)
+	}
+
+	w = httptest.NewRecorder()
+	mux.AdminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/diff", nil))
+	var diff []Change
+	json.Unmarshal(w.Body.Bytes(), &diff)
+	if len(diff) != 2 {
+		t.Errorf("Expected default diff against the previous revision, got %s", w.Body.String())
+	}
+
+	if _, err := mux.Rollback(99); err == nil {
+		t.Error("Expected error for unknown revision")
+	}
+}
+
+func TestRouteChangeEvents(t *testing.T) {
+	mux := NewRuntimeMux()
+	sub := mux.Events.Subscribe(0)
+	defer sub.Close()
+
+	service, _ := NewService("blog", "/blog/", "http://127.0.0.1:9")
+	mux.AddProxy(service)
+	mux.removeHandler(service)
+
+	var got []string
+	for len(got) < 4 {
+		e := <-sub.C
+		got = append(got, string(e.Type)+" "+e.Subject)
+	}
+	if s := strings.Join(got, ", "); s != "service.added blog, config.applied , service.removed blog, config.applied " {
+		t.Errorf("Unexpected events: %s", s)
+	}
+}
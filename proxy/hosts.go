@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// normalizeHost lowercases a host name and strips any port and trailing dot,
+// so request hosts and SNI names compare equal to configured ones.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// SetHosts routes every request for the given host names to the service,
+// whatever its path. Requests are forwarded with their path unchanged.
+func (s *Service) SetHosts(hosts []string) error {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, h := range hosts {
+		host := normalizeHost(strings.TrimSpace(h))
+		if host == "" || strings.ContainsAny(host, "/ ") {
+			return fmt.Errorf("invalid host %q", h)
+		}
+		if !seen[host] {
+			seen[host] = true
+			normalized = append(normalized, host)
+		}
+	}
+	sort.Strings(normalized)
+	s.Hosts = normalized
+	return nil
+}
+
+// hostConflict returns an error if a host of service is claimed by a
+// service at another path in services.
+func hostConflict(service *Service, services map[string]*Service) error {
+	for path, other := range services {
+		if other == nil || path == service.Path {
+			continue
+		}
+		for _, h := range service.Hosts {
+			for _, o := range other.Hosts {
+				if h == o {
+					return fmt.Errorf("host %s is already routed to %s", h, path)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// indexHosts rebuilds the host routing table after the route table changed.
+// Callers must hold ph's lock.
+func (ph *RuntimeMux) indexHosts() {
+	ph.hosts = make(map[string]string)
+	for path, service := range ph.proxyServers {
+		if service == nil {
+			continue
+		}
+		for _, h := range service.Hosts {
+			ph.hosts[h] = path
+		}
+	}
+}
+
+// ServesHost reports whether requests for host are routed by host name.
+func (ph *RuntimeMux) ServesHost(host string) bool {
+	ph.RLock()
+	defer ph.RUnlock()
+	_, ok := ph.hosts[normalizeHost(host)]
+	return ok
+}
+
+// ServeHTTP routes requests for a service's hosts to that service and all
+// others by path.
+func (ph *RuntimeMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ph.RLock()
+	path, ok := ph.hosts[normalizeHost(r.Host)]
+	route := ph.routes[path]
+	ph.RUnlock()
+	if ok {
+		route.ServeHTTP(w, r)
+		return
+	}
+	ph.mux.ServeHTTP(w, r)
+}
@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const maxIdempotentBody = 1 << 20
+
+// IdempotencyCache replays responses to retried non-idempotent requests that
+// carry the same Idempotency-Key header, so a retried POST never reaches the
+// backend twice. A retry arriving while the first request is still in flight
+// waits for it to finish.
+//
+// Keys are scoped to the caller, identified by its API key or Authorization
+// header and otherwise by its address, so one client cannot replay another's
+// response by reusing its key. A retry must also send the same body.
+type IdempotencyCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]*idempotentEntry
+	lastSweep time.Time
+}
+
+type idempotentEntry struct {
+	done        chan struct{}
+	fingerprint string
+	expires     time.Time
+
+	status int
+	header http.Header
+	body   []byte
+	// replayable is false if the response was an error or too large to keep.
+	replayable bool
+}
+
+func NewIdempotencyCache(ttl time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{
+		ttl:     ttl,
+		entries: make(map[string]*idempotentEntry),
+	}
+}
+
+func (c *IdempotencyCache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead ||
+			r.Method == http.MethodOptions || r.Method == http.MethodPut || r.Method == http.MethodDelete {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			http.Error(w, "request body too large for an Idempotency-Key", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256(body)
+		fingerprint := r.Method + " " + r.Host + r.URL.Path + " " + hex.EncodeToString(sum[:])
+		key = callerOf(r) + " " + key
+		entry, owner := c.claim(key, fingerprint)
+		if owner {
+			c.execute(w, r, next, key, entry)
+			return
+		}
+
+		if entry.fingerprint != fingerprint {
+			http.Error(w, "Idempotency-Key reused for a different request", http.StatusUnprocessableEntity)
+			return
+		}
+		select {
+		case <-entry.done:
+		case <-r.Context().Done():
+			return
+		}
+		if !entry.replayable {
+			http.Error(w, "original request with this Idempotency-Key failed", http.StatusConflict)
+			return
+		}
+		for k, v := range entry.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(entry.status)
+		w.Write(entry.body)
+	})
+}
+
+// callerOf identifies the client of r: a hash of the credentials it
+// presents, or its address.
+func callerOf(r *http.Request) string {
+	credentials := r.Header.Get("X-API-Key")
+	if credentials == "" {
+		credentials = r.Header.Get("Authorization")
+	}
+	if credentials != "" {
+		sum := sha256.Sum256([]byte(credentials))
+		return hex.EncodeToString(sum[:])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host
+}
+
+// claim returns the entry for key, creating it if needed. owner reports
+// whether the caller created it and must execute the request.
+func (c *IdempotencyCache) claim(key, fingerprint string) (*idempotentEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.sweep(now)
+	if entry, ok := c.entries[key]; ok && now.Before(entry.expires) {
+		return entry, false
+	}
+	entry := &idempotentEntry{
+		done:        make(chan struct{}),
+		fingerprint: fingerprint,
+		expires:     now.Add(c.ttl),
+	}
+	c.entries[key] = entry
+	return entry, true
+}
+
+func (c *IdempotencyCache) execute(w http.ResponseWriter, r *http.Request, next http.Handler, key string, entry *idempotentEntry) {
+	rec := &teeRecorder{ResponseWriter: w, status: http.StatusOK}
+	// returned stays false if next panics, e.g. with http.ErrAbortHandler
+	// part way through the body, leaving a truncated response.
+	returned := false
+	defer func() {
+		entry.status = rec.status
+		entry.header = w.Header().Clone()
+		entry.body = rec.buf.Bytes()
+		entry.replayable = returned && !rec.overflow && rec.status < 500
+		close(entry.done)
+
+		// Failed requests may be retried for real.
+		if !entry.replayable {
+			c.mu.Lock()
+			if c.entries[key] == entry {
+				delete(c.entries, key)
+			}
+			c.mu.Unlock()
+		}
+	}()
+	next.ServeHTTP(rec, r)
+	returned = true
+}
+
+// sweep drops expired entries at most once a minute. Callers must hold c.mu.
+func (c *IdempotencyCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	c.lastSweep = now
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// teeRecorder passes a response through while keeping a copy of it.
+type teeRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buf         bytes.Buffer
+	overflow    bool
+}
+
+func (t *teeRecorder) WriteHeader(status int) {
+	if !t.wroteHeader {
+		t.status = status
+		t.wroteHeader = true
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *teeRecorder) Write(p []byte) (int, error) {
+	t.wroteHeader = true
+	if !t.overflow {
+		if t.buf.Len()+len(p) > maxIdempotentBody {
+			t.overflow = true
+			t.buf.Reset()
+		} else {
+			t.buf.Write(p)
+		}
+	}
+	return t.ResponseWriter.Write(p)
+}
+
+func (t *teeRecorder) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (t *teeRecorder) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyReplay(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+	handler := NewIdempotencyCache(time.Minute).Middleware(backend)
+
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, 3)
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			r := httptest.NewRequest("POST", "/orders", nil)
+			r.Header.Set("Idempotency-Key", "abc")
+			handler.ServeHTTP(w, r)
+		}(recorders[i])
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("Expected backend to be called once, got %d", calls.Load())
+	}
+	for _, w := range recorders {
+		if w.Code != http.StatusCreated || w.Body.String() != "created" {
+			t.Errorf("Unexpected response: %d %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestIdempotencyKeyReuse(t *testing.T) {
+	handler := NewIdempotencyCache(time.Minute).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest("POST", "/orders", nil)
+	r.Header.Set("Idempotency-Key", "abc")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	r = httptest.NewRequest("POST", "/payments", nil)
+	r.Header.Set("Idempotency-Key", "abc")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422, got %d", w.Code)
+	}
+}
+
+func TestIdempotencyFailedRequestRetries(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewIdempotencyCache(time.Minute).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("POST", "/orders", nil)
+		r.Header.Set("Idempotency-Key", "abc")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected failed request to be retried, got %d calls", calls.Load())
+	}
+}
+
+func TestIdempotencyScopedToCaller(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewIdempotencyCache(time.Minute).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Set-Cookie", "session="+r.Header.Get("Authorization"))
+	}))
+
+	for _, auth := range []string{"Bearer alice", "Bearer mallory"} {
+		r := httptest.NewRequest("POST", "/orders", strings.NewReader("{}"))
+		r.Header.Set("Idempotency-Key", "abc")
+		r.Header.Set("Authorization", auth)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Header().Get("Idempotent-Replayed") != "" || w.Header().Get("Set-Cookie") != "session="+auth {
+			t.Errorf("%s: got another caller's response: %v", auth, w.Header())
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected each caller to reach the backend, got %d calls", calls.Load())
+	}
+
+	r := httptest.NewRequest("POST", "/orders", strings.NewReader("{\"amount\": 2}"))
+	r.Header.Set("Idempotency-Key", "abc")
+	r.Header.Set("Authorization", "Bearer alice")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a different body to be rejected with 422, got %d", w.Code)
+	}
+}
+
+func TestIdempotencyAbortedResponseNotReplayed(t *testing.T) {
+	var calls atomic.Int32
+	handler := NewIdempotencyCache(time.Minute).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		if calls.Add(1) == 1 {
+			panic(http.ErrAbortHandler)
+		}
+	}))
+
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest("POST", "/orders", nil)
+		r.Header.Set("Idempotency-Key", "abc")
+		func() {
+			defer func() { recover() }()
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+		}()
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected the aborted request to be retried, got %d calls", calls.Load())
+	}
+}
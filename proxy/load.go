@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"github.com/kirtansoni/reverse-proxy-go/websocket"
+)
+
+const (
+	// loadWindow is the period latency percentiles and rates cover.
+	loadWindow = time.Minute
+	// maxLatencySamples bounds the samples kept per service.
+	maxLatencySamples = 4096
+)
+
+// loadStats tracks how busy a route is.
+type loadStats struct {
+	inFlight atomic.Int64
+	total    atomic.Int64
+
+	mu      sync.Mutex
+	samples []latencySample // ring buffer
+	next    int
+
+	// The adaptive timeout last learned, see TimeoutConfig.
+	timeoutMu  sync.Mutex
+	learned    time.Duration
+	learnedCfg TimeoutConfig
+	learnedAt  time.Time
+}
+
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// serveTracked serves a request to a route, recording its load.
+func (ph *RuntimeMux) serveTracked(stats *loadStats, service *Service, w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	stats.inFlight.Add(1)
+	stats.total.Add(1)
+	// Uptime counts 5xx answers; WebSockets would skew its latencies.
+	var sw *statusRecorder
+	if ph.Uptime != nil && !websocket.IsUpgrade(r) {
+		sw = &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		w = sw
+	}
+	defer func() {
+		stats.inFlight.Add(-1)
+		stats.observe(time.Now(), time.Since(start))
+		if sw != nil {
+			ph.Uptime.observe(service.Name, start, time.Since(start), sw.status >= 500)
+		}
+	}()
+	r, cancel := stats.withTimeout(service, r)
+	defer cancel()
+	ph.serveCounted(service, w, r)
+}
+
+func (l *loadStats) observe(at time.Time, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < maxLatencySamples {
+		l.samples = append(l.samples, latencySample{at, latency})
+		return
+	}
+	l.samples[l.next] = latencySample{at, latency}
+	l.next = (l.next + 1) % maxLatencySamples
+}
+
+// recent returns the latencies observed within loadWindow, sorted, and the
+// period they span.
+func (l *loadStats) recent(now time.Time) ([]time.Duration, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var latencies []time.Duration
+	oldest := now
+	for _, s := range l.samples {
+		if now.Sub(s.at) <= loadWindow {
+			latencies = append(latencies, s.latency)
+			if s.at.Before(oldest) {
+				oldest = s.at
+			}
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	// With a full buffer the samples may cover less than the window.
+	span := loadWindow
+	if len(l.samples) == maxLatencySamples {
+		span = now.Sub(oldest)
+	}
+	return latencies, span
+}
+
+// Load is the saturation of one service, for autoscalers.
+type Load struct {
+	Service string `json:"service"`
+	Path    string `json:"path"`
+	// InFlight is the number of requests being served.
+	InFlight int64 `json:"in_flight"`
+	// MaxConcurrency is the declared capacity of the backend, 0 if unknown.
+	MaxConcurrency int `json:"max_concurrency"`
+	// QueueDepth is the number of requests in flight beyond MaxConcurrency,
+	// which the backend has to queue.
+	QueueDepth int64 `json:"queue_depth"`
+	// Utilization is InFlight / MaxConcurrency.
+	Utilization       float64 `json:"utilization"`
+	P99LatencyMs      float64 `json:"p99_latency_ms"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	// Requests is the number of requests served since the proxy started.
+	Requests int64 `json:"requests"`
+	// TimeoutMs is the upstream timeout learned for a service with an
+	// adaptive timeout.
+	TimeoutMs int64 `json:"timeout_ms,omitempty"`
+	// State is StateAwake, StateStarting or StateSleeping for a service
+	// with cold start holding.
+	State string `json:"state,omitempty"`
+}
+
+// Load returns the saturation of every service.
+func (ph *RuntimeMux) Load() []Load {
+	ph.RLock()
+	defer ph.RUnlock()
+
+	now := time.Now()
+	var loads []Load
+	for path, service := range ph.proxyServers {
+		if service == nil {
+			continue
+		}
+		stats := ph.load[path]
+		l := Load{
+			Service:        service.Name,
+			Path:           path,
+			InFlight:       stats.inFlight.Load(),
+			Requests:       stats.total.Load(),
+			MaxConcurrency: service.MaxConcurrency,
+		}
+		if l.MaxConcurrency > 0 {
+			l.QueueDepth = max(0, l.InFlight-int64(l.MaxConcurrency))
+			l.Utilization = float64(l.InFlight) / float64(l.MaxConcurrency)
+		}
+		if cfg := service.AdaptiveTimeout; cfg != nil {
+			l.TimeoutMs = stats.timeout(*cfg, now).Milliseconds()
+		}
+		if service.coldStart != nil {
+			l.State = service.coldStart.state()
+		}
+		latencies, span := stats.recent(now)
+		if n := len(latencies); n > 0 {
+			l.P99LatencyMs = float64(latencies[(n*99-1)/100]) / float64(time.Millisecond)
+			if span > 0 {
+				l.RequestsPerSecond = float64(n) / span.Seconds()
+			}
+		}
+		loads = append(loads, l)
+	}
+	sort.Slice(loads, func(i, j int) bool { return loads[i].Path < loads[j].Path })
+	return loads
+}
+
+// externalMetric is an item of a Kubernetes ExternalMetricValueList.
+type externalMetric struct {
+	MetricName   string            `json:"metricName"`
+	MetricLabels map[string]string `json:"metricLabels"`
+	Timestamp    time.Time         `json:"timestamp"`
+	Value        string            `json:"value"`
+}
+
+// quantity formats v as a Kubernetes quantity in milli-units.
+func quantity(v float64) string {
+	return fmt.Sprintf("%dm", int64(v*1000))
+}
+
+// LoadHandler serves saturation signals for autoscalers:
+//
+//	GET /            every service as a Kubernetes external metrics list
+//	                 (external.metrics.k8s.io/v1beta1 ExternalMetricValueList)
+//	GET /{service}   one service as a flat JSON object, for the KEDA
+//	                 metrics-api scaler (e.g. valueLocation: p99_latency_ms)
+func (ph *RuntimeMux) LoadHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now().UTC().Truncate(time.Second)
+		items := []externalMetric{}
+		for _, l := range ph.Load() {
+			labels := map[string]string{"service": l.Service, "path": l.Path}
+			for name, v := range map[string]float64{
+				"proxy_in_flight":           float64(l.InFlight),
+				"proxy_queue_depth":         float64(l.QueueDepth),
+				"proxy_utilization":         l.Utilization,
+				"proxy_p99_latency_ms":      l.P99LatencyMs,
+				"proxy_requests_per_second": l.RequestsPerSecond,
+			} {
+				items = append(items, externalMetric{name, labels, now, quantity(v)})
+			}
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			if items[i].MetricName != items[j].MetricName {
+				return items[i].MetricName < items[j].MetricName
+			}
+			return items[i].MetricLabels["path"] < items[j].MetricLabels["path"]
+		})
+		admin.WriteJSON(w, http.StatusOK, map[string]any{
+			"kind":       "ExternalMetricValueList",
+			"apiVersion": "external.metrics.k8s.io/v1beta1",
+			"metadata":   map[string]any{},
+			"items":      items,
+		})
+	})
+	mux.HandleFunc("GET /{service}", func(w http.ResponseWriter, r *http.Request) {
+		for _, l := range ph.Load() {
+			if l.Service == r.PathValue("service") {
+				admin.WriteJSON(w, http.StatusOK, l)
+				return
+			}
+		}
+		admin.WriteError(w, http.StatusNotFound, fmt.Errorf("no service named %s", r.PathValue("service")))
+	})
+	return mux
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLoadSignals(t *testing.T) {
+	release := make(chan struct{})
+	var arrived sync.WaitGroup
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("block") {
+			arrived.Done()
+			<-release
+		}
+	}))
+	defer backend.Close()
+
+	mux := NewRuntimeMux()
+	service, _ := NewService("api", "/api/", backend.URL)
+	service.MaxConcurrency = 2
+	mux.AddProxy(service)
+
+	for i := 0; i < 5; i++ {
+		mux.GetMux().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/", nil))
+	}
+	var done sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		arrived.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			mux.GetMux().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/?block", nil))
+		}()
+	}
+	arrived.Wait()
+
+	w := httptest.NewRecorder()
+	mux.LoadHandler().ServeHTTP(w, httptest.NewRequest("GET", "/api", nil))
+	var load Load
+	json.Unmarshal(w.Body.Bytes(), &load)
+	if load.InFlight != 3 || load.QueueDepth != 1 || load.Utilization != 1.5 || load.P99LatencyMs <= 0 {
+		t.Errorf("Unexpected load: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	mux.LoadHandler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(w.Body.String(), `"kind":"ExternalMetricValueList"`) ||
+		!strings.Contains(w.Body.String(), `{"metricName":"proxy_queue_depth","metricLabels":{"path":"/api/","service":"api"}`) ||
+		!strings.Contains(w.Body.String(), `"value":"1500m"`) {
+		t.Errorf("Unexpected external metrics: %s", w.Body.String())
+	}
+
+	close(release)
+	done.Wait()
+	if load := mux.Load(); load[0].InFlight != 0 {
+		t.Errorf("Expected no requests in flight, got %d", load[0].InFlight)
+	}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	netproxy "golang.org/x/net/proxy"
+)
+
+// DefaultMeshRoutes are the addresses of a Tailscale network: its IPv4 and
+// IPv6 ranges and MagicDNS names.
+const DefaultMeshRoutes = "100.64.0.0/10,fd7a:115c:a1e0::/48,.ts.net"
+
+// UpstreamMesh, if set, carries the upstream connections of every service
+// to mesh addresses.
+var UpstreamMesh *Mesh
+
+// Mesh dials upstream hosts on a private WireGuard network through the
+// SOCKS5 server of a userspace node, such as tailscaled with
+// -tun=userspace-networking -socks5-server or wireproxy, so backends on
+// machines without a public address are reached without port forwarding.
+// Host names are resolved by the node, so mesh DNS names work too.
+type Mesh struct {
+	dialer   netproxy.ContextDialer
+	prefixes []netip.Prefix
+	suffixes []string
+}
+
+// NewMesh returns a mesh dialing through the SOCKS5 server at socksURL,
+// e.g. socks5://127.0.0.1:1055, for the hosts of routes: a comma-separated
+// list of networks in CIDR notation and domain suffixes starting with a
+// dot.
+func NewMesh(socksURL, routes string) (*Mesh, error) {
+	u, err := url.Parse(socksURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mesh proxy: %v", err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("invalid mesh proxy %q, expected socks5://host:port", socksURL)
+	}
+	d, err := netproxy.FromURL(u, netproxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mesh proxy: %v", err)
+	}
+	m := &Mesh{dialer: d.(netproxy.ContextDialer)}
+	for _, route := range strings.Split(routes, ",") {
+		route = strings.ToLower(strings.TrimSpace(route))
+		switch {
+		case route == "":
+		case strings.HasPrefix(route, "."):
+			m.suffixes = append(m.suffixes, route)
+		default:
+			prefix, err := netip.ParsePrefix(route)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mesh route %q, expected a CIDR or a .domain suffix", route)
+			}
+			m.prefixes = append(m.prefixes, prefix.Masked())
+		}
+	}
+	if len(m.prefixes)+len(m.suffixes) == 0 {
+		return nil, fmt.Errorf("no mesh routes")
+	}
+	return m, nil
+}
+
+// Routes reports whether connections to host go through the mesh.
+func (m *Mesh) Routes(host string) bool {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.Unmap()
+		for _, prefix := range m.prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, suffix := range m.suffixes {
+		if strings.HasSuffix(host, suffix) || host == suffix[1:] {
+			return true
+		}
+	}
+	return false
+}
+
+// meshDial wraps an upstream transport's dial function with UpstreamMesh.
+func meshDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		m := UpstreamMesh
+		if m == nil {
+			return dial(ctx, network, addr)
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil || !m.Routes(host) {
+			return dial(ctx, network, addr)
+		}
+		conn, err := m.dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("mesh dial %s: %w", addr, err)
+		}
+		return conn, nil
+	}
+}
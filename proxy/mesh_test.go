@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// fakeSOCKS5 serves SOCKS5 CONNECT without authentication, sending every
+// connection to backend and recording the requested targets.
+func fakeSOCKS5(t *testing.T, backend string) (addr string, targets chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	targets = make(chan string, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 262)
+				// Greeting: version, methods; answer no authentication.
+				if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+					return
+				}
+				io.ReadFull(conn, buf[:buf[1]])
+				conn.Write([]byte{5, 0})
+				// Request: version, CONNECT, reserved, a domain name target.
+				if _, err := io.ReadFull(conn, buf[:5]); err != nil || buf[3] != 3 {
+					return
+				}
+				n := int(buf[4])
+				io.ReadFull(conn, buf[:n+2])
+				targets <- net.JoinHostPort(string(buf[:n]), strconv.Itoa(int(binary.BigEndian.Uint16(buf[n:n+2]))))
+				up, err := net.Dial("tcp", backend)
+				if err != nil {
+					return
+				}
+				defer up.Close()
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(up, conn)
+				io.Copy(conn, up)
+			}()
+		}
+	}()
+	return ln.Addr().String(), targets
+}
+
+func TestMeshDialsRoutedHosts(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("home"))
+	}))
+	defer backend.Close()
+	socks, targets := fakeSOCKS5(t, backend.Listener.Addr().String())
+
+	mesh, err := NewMesh("socks5://"+socks, DefaultMeshRoutes+",.home.arpa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[string]bool{
+		"100.101.102.103": true, "fd7a:115c:a1e0::1": true, "nas.tail1234.ts.net": true, "NAS.home.arpa.": true,
+		"10.0.0.1": false, "example.com": false, "nots.net": false,
+	} {
+		if got := mesh.Routes(host); got != want {
+			t.Errorf("Expected Routes(%q) = %v, got %v", host, want, got)
+		}
+	}
+
+	UpstreamMesh = mesh
+	defer func() { UpstreamMesh = nil }()
+	s, err := NewService("home", "/", "http://nas.tail1234.ts.net:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "home" {
+		t.Fatalf("Expected the backend through the mesh, got %d %q", rec.Code, rec.Body.String())
+	}
+	if got := <-targets; got != "nas.tail1234.ts.net:8080" {
+		t.Errorf("Expected the mesh to resolve the name, got target %s", got)
+	}
+
+	if _, err := NewMesh("http://127.0.0.1:1055", DefaultMeshRoutes); err == nil {
+		t.Error("Expected a non-SOCKS5 mesh proxy to be rejected")
+	}
+	if _, err := NewMesh("socks5://127.0.0.1:1055", "10.0.0.0/33"); err == nil {
+		t.Error("Expected an invalid route to be rejected")
+	}
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// NamespaceOptions configures MountNamespace.
+type NamespaceOptions struct {
+	// Index lists the mounted services at the namespace root, as HTML or,
+	// when asked for, JSON. A service routed at "/" takes precedence.
+	Index bool
+	// Middleware wraps the services, the first one outermost.
+	Middleware []func(http.Handler) http.Handler
+}
+
+// IndexEntry is a service listed in a namespace index.
+type IndexEntry struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><title>{{.Prefix}}</title></head>
+<body><ul>
+{{range .Entries}}<li><a href="{{.URL}}">{{.Name}}</a></li>
+{{end}}</ul></body></html>
+`))
+
+// MountNamespace serves the services under prefix, e.g. "/projects" so that
+// "/projects/api/x" reaches the service at "/api/" with path "/api/x". It
+// returns the pattern and handler to register on a ServeMux:
+//
+//	mux.Handle(runtimeMux.MountNamespace("/projects", opts))
+//
+// The prefix may be given with or without slashes; requests for the bare
+// prefix are redirected to it with a trailing slash.
+func (ph *RuntimeMux) MountNamespace(prefix string, opts NamespaceOptions) (string, http.Handler) {
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		prefix = ""
+	}
+
+	var routes http.Handler = ph
+	for i := len(opts.Middleware) - 1; i >= 0; i-- {
+		routes = opts.Middleware[i](routes)
+	}
+	routes = http.StripPrefix(prefix, routes)
+
+	return prefix + "/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case prefix:
+			u := *r.URL
+			u.Path = prefix + "/"
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		case prefix + "/":
+			if opts.Index && !ph.ServesPath("/") {
+				ph.serveIndex(w, r, prefix)
+				return
+			}
+		}
+		routes.ServeHTTP(w, r)
+	})
+}
+
+// ServesPath reports whether a service is routed at path.
+func (ph *RuntimeMux) ServesPath(path string) bool {
+	ph.RLock()
+	defer ph.RUnlock()
+	return ph.proxyServers[path] != nil
+}
+
+func (ph *RuntimeMux) serveIndex(w http.ResponseWriter, r *http.Request, prefix string) {
+	entries := []IndexEntry{}
+	for _, service := range ph.State().Services {
+		entries = append(entries, IndexEntry{service.Name, prefix + service.Path})
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		admin.WriteJSON(w, http.StatusOK, entries)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTemplate.Execute(w, map[string]any{"Prefix": prefix, "Entries": entries})
+}
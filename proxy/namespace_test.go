@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMountNamespace(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + " " + r.Header.Get("X-Wrapped")))
+	}))
+	defer backend.Close()
+
+	ph := NewRuntimeMux()
+	service, _ := NewService("API <v2>", "/api/", backend.URL)
+	ph.AddProxy(service)
+
+	wrap := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("X-Wrapped", "yes")
+			next.ServeHTTP(w, r)
+		})
+	}
+	mux := http.NewServeMux()
+	pattern, handler := ph.MountNamespace("projects/", NamespaceOptions{Index: true, Middleware: []func(http.Handler) http.Handler{wrap}})
+	if pattern != "/projects/" {
+		t.Fatalf("Unexpected pattern %s", pattern)
+	}
+	mux.Handle(pattern, handler)
+
+	get := func(target, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("Accept", accept)
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("/projects/api/x", ""); w.Body.String() != "/api/x yes" {
+		t.Errorf("Expected the prefix stripped and middleware applied, got %q", w.Body.String())
+	}
+	if w := get("/projects?a=1", ""); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/projects/?a=1" {
+		t.Errorf("Expected a redirect to the slashed prefix, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if w := get("/projects/", ""); !strings.Contains(w.Body.String(), `<a href="/projects/api/">API &lt;v2&gt;</a>`) {
+		t.Errorf("Unexpected HTML index: %s", w.Body.String())
+	}
+	if w := get("/projects/", "application/json"); !strings.Contains(w.Body.String(), `"url":"/projects/api/"`) {
+		t.Errorf("Unexpected JSON index: %s", w.Body.String())
+	}
+}
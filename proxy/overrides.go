@@ -0,0 +1,345 @@
+package proxy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+const (
+	// OverrideCookie carries a developer's signed routing overrides.
+	OverrideCookie = "proxy_override"
+	// OverrideHeader is set on responses served by an override, naming
+	// the developer.
+	OverrideHeader = "X-Proxy-Override"
+	// OverridePath sets the cookie from ?token=... and redirects to
+	// ?next=... (or /); ?clear=1 removes it.
+	OverridePath = "/.proxy/override"
+	// MaxOverrideTTL caps how long an override token is valid.
+	MaxOverrideTTL = 7 * 24 * time.Hour
+)
+
+var errInvalidOverride = errors.New("invalid override token")
+
+// Override routes a developer's requests for some services to other
+// backends, such as a tunnel to their machine or a staging server, while
+// everyone else keeps hitting the services' own.
+type Override struct {
+	Developer string `json:"developer"`
+	// Routes maps service names to the URL of the backend to use instead.
+	Routes  map[string]string `json:"routes"`
+	Issued  time.Time         `json:"issued"`
+	Expires time.Time         `json:"expires"`
+}
+
+// Overrides issues and verifies override tokens, HMAC-signed payloads
+// carried in OverrideCookie.
+type Overrides struct {
+	key []byte
+
+	mu sync.Mutex
+	// revoked holds, per developer, the time before which their tokens
+	// are no longer accepted.
+	revoked map[string]time.Time
+	// revokedFile keeps revoked across restarts, if set.
+	revokedFile string
+}
+
+func NewOverrides(key []byte) (*Overrides, error) {
+	if len(key) < 32 {
+		return nil, errors.New("override key must be at least 32 bytes")
+	}
+	return &Overrides{key: key, revoked: make(map[string]time.Time)}, nil
+}
+
+// LoadOverrides reads the signing key from keyFile, creating the file with
+// a random key if it does not exist. Revocations are kept next to it, in
+// keyFile with a .revoked suffix.
+func LoadOverrides(keyFile string) (*Overrides, error) {
+	key, err := os.ReadFile(keyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate override key: %v", err)
+		}
+		if err := os.WriteFile(keyFile, key, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write override key: %v", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read override key: %v", err)
+	}
+	o, err := NewOverrides(key)
+	if err != nil {
+		return nil, err
+	}
+	o.revokedFile = keyFile + ".revoked"
+	data, err := os.ReadFile(o.revokedFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read override revocations: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &o.revoked); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", o.revokedFile, err)
+		}
+	}
+	return o, nil
+}
+
+// Issue signs an override for developer valid for ttl.
+func (o *Overrides) Issue(developer string, routes map[string]string, ttl time.Duration) (string, Override, error) {
+	if developer == "" {
+		return "", Override{}, errors.New("developer is required")
+	}
+	if len(routes) == 0 {
+		return "", Override{}, errors.New("at least one route is required")
+	}
+	if ttl <= 0 || ttl > MaxOverrideTTL {
+		return "", Override{}, fmt.Errorf("ttl must be positive and at most %s", MaxOverrideTTL)
+	}
+	for service, target := range routes {
+		if _, err := parseHTTPURL(target); err != nil {
+			return "", Override{}, fmt.Errorf("route for %s: %v", service, err)
+		}
+	}
+	now := time.Now()
+	ov := Override{Developer: developer, Routes: routes, Issued: now, Expires: now.Add(ttl)}
+	payload, err := json.Marshal(ov)
+	if err != nil {
+		return "", Override{}, err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + o.sign(payload), ov, nil
+}
+
+func (o *Overrides) sign(payload []byte) string {
+	mac := hmac.New(sha256.New, o.key)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns the override of a token that is correctly signed,
+// unexpired and not revoked.
+func (o *Overrides) Verify(token string) (*Override, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errInvalidOverride
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(o.sign(payload))) {
+		return nil, errInvalidOverride
+	}
+	var ov Override
+	if err := json.Unmarshal(payload, &ov); err != nil {
+		return nil, errInvalidOverride
+	}
+	if time.Now().After(ov.Expires) {
+		return nil, errors.New("override token expired")
+	}
+	o.mu.Lock()
+	revoked := o.revoked[ov.Developer]
+	o.mu.Unlock()
+	if ov.Issued.Before(revoked) {
+		return nil, errors.New("override token revoked")
+	}
+	return &ov, nil
+}
+
+// Revoke invalidates every token issued to developer so far, saving the
+// revocation if the overrides were loaded from a key file.
+func (o *Overrides) Revoke(developer string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	o.revoked[developer] = now
+	// Tokens issued before MaxOverrideTTL ago have expired anyway.
+	for d, t := range o.revoked {
+		if t.Before(now.Add(-MaxOverrideTTL)) {
+			delete(o.revoked, d)
+		}
+	}
+	if o.revokedFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(o.revoked)
+	if err != nil {
+		return err
+	}
+	tmp := o.revokedFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save override revocations: %v", err)
+	}
+	if err := os.Rename(tmp, o.revokedFile); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save override revocations: %v", err)
+	}
+	return nil
+}
+
+func parseHTTPURL(target string) (*url.URL, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an http or https URL", target)
+	}
+	return u, nil
+}
+
+// Middleware serves OverridePath, where developers open the link returned
+// when a token is issued to get the cookie set in their browser.
+func (o *Overrides) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != OverridePath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cookie := &http.Cookie{Name: OverrideCookie, Path: "/", HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode}
+		if r.URL.Query().Get("clear") != "" {
+			cookie.MaxAge = -1
+		} else {
+			token := r.URL.Query().Get("token")
+			ov, err := o.Verify(token)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			cookie.Value, cookie.Expires = token, ov.Expires
+		}
+		http.SetCookie(w, cookie)
+		// Only local redirects, so the path cannot be used as an open
+		// redirector.
+		dest := r.URL.Query().Get("next")
+		if !strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "//") || strings.HasPrefix(dest, "/\\") {
+			dest = "/"
+		}
+		http.Redirect(w, r, dest, http.StatusFound)
+	})
+}
+
+// targetKey carries the URL a request is sent to instead of the service
+// URL, for overrides and region routes.
+type targetKey struct{}
+
+// withOverride returns r carrying the override target for service if the
+// request has a valid override cookie routing it elsewhere. The cookie is
+// never passed to backends.
+func (ph *RuntimeMux) withOverride(service *Service, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if _, err := r.Cookie(OverrideCookie); err != nil {
+		return r, false
+	}
+	u, developer := ph.overrideTarget(service, r)
+	cookies := r.Cookies()
+	r = r.Clone(r.Context())
+	r.Header.Del("Cookie")
+	for _, other := range cookies {
+		if other.Name != OverrideCookie {
+			r.AddCookie(other)
+		}
+	}
+	if u == nil {
+		return r, false
+	}
+	w.Header().Set(OverrideHeader, developer)
+	return withOverridden(r.WithContext(context.WithValue(r.Context(), targetKey{}, u))), true
+}
+
+// overrideTarget returns where the override cookie of r routes service,
+// and the developer it was issued to, or nil if it stays put.
+func (ph *RuntimeMux) overrideTarget(service *Service, r *http.Request) (*url.URL, string) {
+	c, err := r.Cookie(OverrideCookie)
+	if err != nil || ph.Overrides == nil || service.backend != nil {
+		return nil, ""
+	}
+	ov, err := ph.Overrides.Verify(c.Value)
+	if err != nil {
+		return nil, ""
+	}
+	target, ok := ov.Routes[service.Name]
+	if !ok {
+		return nil, ""
+	}
+	u, err := parseHTTPURL(target)
+	if err != nil {
+		return nil, ""
+	}
+	return u, ov.Developer
+}
+
+// targetDirector wraps the director of a service's reverse proxy to send
+// requests carrying a targetKey there instead.
+func targetDirector(director func(*http.Request)) func(*http.Request) {
+	return func(r *http.Request) {
+		if u, ok := r.Context().Value(targetKey{}).(*url.URL); ok {
+			httputil.NewSingleHostReverseProxy(u).Director(r)
+			return
+		}
+		director(r)
+	}
+}
+
+// AdminHandler serves override management:
+//
+//	POST   /              {"developer": "...", "routes": {"<service>": "http://localhost:8080"}, "ttl": "8h"}
+//	DELETE /{developer}   revoke every token issued to a developer
+func (o *Overrides) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /{$}", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Developer string            `json:"developer"`
+			Routes    map[string]string `json:"routes"`
+			TTL       string            `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		ttl := 8 * time.Hour
+		if req.TTL != "" {
+			var err error
+			if ttl, err = time.ParseDuration(req.TTL); err != nil {
+				admin.WriteError(w, http.StatusBadRequest, errors.New("invalid ttl"))
+				return
+			}
+		}
+		token, ov, err := o.Issue(req.Developer, req.Routes, ttl)
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusCreated, IssuedOverride{
+			Override:   ov,
+			Token:      token,
+			ActivateAt: OverridePath + "?token=" + url.QueryEscape(token),
+		})
+	})
+	mux.HandleFunc("DELETE /{developer}", func(w http.ResponseWriter, r *http.Request) {
+		if err := o.Revoke(r.PathValue("developer")); err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// IssuedOverride is an override with its token. Developers send the token
+// in OverrideCookie, or open ActivateAt on a proxied host to have it set.
+type IssuedOverride struct {
+	Override
+	Token      string `json:"token"`
+	ActivateAt string `json:"activate_at"`
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/proxy/proxytest"
+)
+
+func TestOverrides(t *testing.T) {
+	production := proxytest.NewFakeBackend(t, "production")
+	laptop := proxytest.NewFakeBackend(t, "laptop")
+
+	overrides, err := NewOverrides(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := NewRuntimeMux()
+	mux.Overrides = overrides
+	service, _ := NewService("shop", "/shop/", production.URL)
+	mux.AddProxy(service)
+
+	if _, _, err := overrides.Issue("sam", map[string]string{"shop": "ftp://laptop"}, time.Hour); err == nil {
+		t.Error("Expected a non-HTTP target to be rejected")
+	}
+	token, _, err := overrides.Issue("sam", map[string]string{"shop": laptop.URL}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(cookie string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/shop/cart", nil)
+		r.Header.Set("Cookie", "session=1; "+OverrideCookie+"="+cookie)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get(token)
+	if w.Body.String() != "laptop" || w.Header().Get(OverrideHeader) != "sam" {
+		t.Fatalf("Expected the override backend, got %q (override %q)", w.Body, w.Header().Get(OverrideHeader))
+	}
+	last, _ := laptop.LastRequest()
+	if last.URI != "/shop/cart" || strings.Contains(last.Header.Get("Cookie"), OverrideCookie) || last.Header.Get("Cookie") != "session=1" {
+		t.Errorf("Unexpected request at the override backend: %s, Cookie %q", last.URI, last.Header.Get("Cookie"))
+	}
+
+	if w := get(token[:len(token)-2] + "xx"); w.Body.String() != "production" {
+		t.Errorf("Expected a tampered token to be ignored, got %q", w.Body)
+	}
+	last, _ = production.LastRequest()
+	if strings.Contains(last.Header.Get("Cookie"), OverrideCookie) {
+		t.Error("The override cookie must not reach backends")
+	}
+
+	overrides.Revoke("sam")
+	if w := get(token); w.Body.String() != "production" {
+		t.Errorf("Expected a revoked token to be ignored, got %q", w.Body)
+	}
+}
+
+func TestOverrideRevocationsPersist(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "override.key")
+	overrides, err := LoadOverrides(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := map[string]string{"shop": "http://localhost:9000"}
+	revoked, _, _ := overrides.Issue("sam", routes, time.Hour)
+	kept, _, _ := overrides.Issue("alex", routes, time.Hour)
+	if err := overrides.Revoke("sam"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadOverrides(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Verify(revoked); err == nil {
+		t.Error("Expected the revocation to survive a reload")
+	}
+	if _, err := reloaded.Verify(kept); err != nil {
+		t.Errorf("Expected other developers' tokens to stay valid, got %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	token, _, _ := reloaded.Issue("sam", routes, time.Hour)
+	if _, err := reloaded.Verify(token); err != nil {
+		t.Errorf("Expected tokens issued after the revocation to be valid, got %v", err)
+	}
+}
+
+func TestOverrideActivation(t *testing.T) {
+	overrides, _ := NewOverrides(bytes.Repeat([]byte("k"), 32))
+	token, _, _ := overrides.Issue("sam", map[string]string{"shop": "http://localhost:9000"}, time.Hour)
+	h := overrides.Middleware(http.NotFoundHandler())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", OverridePath+"?token="+url.QueryEscape(token)+"&next=//evil.example", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/" {
+		t.Errorf("Expected a redirect to /, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].Value != token || !c[0].HttpOnly {
+		t.Errorf("Expected the override cookie to be set, got %v", c)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", OverridePath+"?token=bogus", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid token to be rejected, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", OverridePath+"?clear=1", nil))
+	if c := w.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+		t.Errorf("Expected the cookie to be cleared, got %v", c)
+	}
+}
@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProbeTimeout bounds the probes of a validated state change.
+const DefaultProbeTimeout = 5 * time.Second
+
+// Probe is the outcome of checking that an upstream answers, step by step.
+// Steps the request never reached are nil; DNS is nil for IP addresses and
+// TLS for plain HTTP.
+type Probe struct {
+	Service    string     `json:"service"`
+	URL        string     `json:"url"`
+	OK         bool       `json:"ok"`
+	DNS        *ProbeStep `json:"dns,omitempty"`
+	Connect    *ProbeStep `json:"connect,omitempty"`
+	TLS        *ProbeStep `json:"tls,omitempty"`
+	Status     int        `json:"status,omitempty"`
+	DurationMs float64    `json:"duration_ms"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// ProbeStep is one step of a Probe.
+type ProbeStep struct {
+	DurationMs float64 `json:"duration_ms"`
+	// Detail is the resolved addresses, the address connected to or the
+	// negotiated TLS version and certificate.
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ProbeUpstream sends a GET request to rawURL on a connection of its own,
+// recording how far it got before ctx ends. Any answer below 500 passes.
+func ProbeUpstream(ctx context.Context, service, rawURL string) Probe {
+	p := Probe{Service: service, URL: rawURL}
+	start := time.Now()
+
+	var mu sync.Mutex
+	var dnsStart, connectStart, tlsStart time.Time
+	step := func(started time.Time, detail string, err error) *ProbeStep {
+		s := &ProbeStep{DurationMs: ms(time.Since(started)), Detail: detail}
+		if err != nil {
+			s.Error = err.Error()
+		}
+		return s
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			dnsStart = time.Now()
+			mu.Unlock()
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			addrs := make([]string, len(info.Addrs))
+			for i, a := range info.Addrs {
+				addrs[i] = a.String()
+			}
+			mu.Lock()
+			p.DNS = step(dnsStart, strings.Join(addrs, ", "), info.Err)
+			mu.Unlock()
+		},
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			connectStart = time.Now()
+			mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mu.Lock()
+			// With several addresses, the last attempt is the one that
+			// counts.
+			p.Connect = step(connectStart, addr, err)
+			mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			tlsStart = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			detail := ""
+			if err == nil {
+				detail = tls.VersionName(state.Version)
+				if len(state.PeerCertificates) > 0 {
+					detail += ", " + state.PeerCertificates[0].Subject.CommonName
+				}
+			}
+			mu.Lock()
+			p.TLS = step(tlsStart, detail, err)
+			mu.Unlock()
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, rawURL, nil)
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment, DisableKeepAlives: true}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Do(req)
+	// Dials abandoned when ctx ends may still report back.
+	mu.Lock()
+	defer mu.Unlock()
+	p.DurationMs = ms(time.Since(start))
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	res.Body.Close()
+	p.Status = res.StatusCode
+	if res.StatusCode >= 500 {
+		p.Error = fmt.Sprintf("backend answered %s", res.Status)
+		return p
+	}
+	p.OK = true
+	return p
+}
+
+// Validate probes, concurrently and within ctx, every upstream that state
+// would newly route to: the URLs and region URLs of services it adds, or
+// changes to point elsewhere.
+func (ph *RuntimeMux) Validate(ctx context.Context, state State) []Probe {
+	type target struct{ service, url string }
+	var targets []target
+	ph.RLock()
+	for _, cfg := range state.Services {
+		if cfg == nil {
+			continue
+		}
+		served := make(map[string]bool)
+		if current := ph.proxyServers[cfg.Path]; current != nil {
+			served[current.Url] = true
+			for _, rr := range current.Regions {
+				served[rr.URL] = true
+			}
+		}
+		urls := []string{cfg.Url}
+		for _, rr := range cfg.Regions {
+			urls = append(urls, rr.URL)
+		}
+		for _, u := range urls {
+			// A templated URL has no single backend to probe.
+			if !served[u] && !isUpstreamTemplate(u) {
+				served[u] = true
+				targets = append(targets, target{cfg.Name, u})
+			}
+		}
+	}
+	ph.RUnlock()
+
+	probes := make([]Probe, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probes[i] = ProbeUpstream(ctx, t.service, t.url)
+		}()
+	}
+	wg.Wait()
+	sort.SliceStable(probes, func(i, j int) bool { return probes[i].Service < probes[j].Service })
+	return probes
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidatedState(t *testing.T) {
+	untrusted := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	untrusted.Config.ErrorLog = log.New(io.Discard, "", 0)
+	untrusted.StartTLS()
+	defer untrusted.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	down := httptest.NewServer(nil)
+	down.Close()
+
+	mux := NewRuntimeMux()
+	put := func(url string) (int, map[string]json.RawMessage, []Probe) {
+		doc := fmt.Sprintf(`{"services": [{"name": "api", "path": "/api/", "url": %q}]}`, url)
+		w := httptest.NewRecorder()
+		mux.StateHandler().ServeHTTP(w, httptest.NewRequest("PUT", "/?validate=1&timeout=2s", strings.NewReader(doc)))
+		var body map[string]json.RawMessage
+		var probes []Probe
+		json.Unmarshal(w.Body.Bytes(), &body)
+		json.Unmarshal(body["probes"], &probes)
+		return w.Code, body, probes
+	}
+
+	// The test server's certificate is not trusted, so the TLS step fails.
+	code, _, probes := put(untrusted.URL)
+	if code != http.StatusUnprocessableEntity || len(probes) != 1 || probes[0].TLS == nil || probes[0].TLS.Error == "" || probes[0].Connect.Error != "" {
+		t.Fatalf("Expected a failed TLS step, got %d %+v", code, probes)
+	}
+
+	code, _, probes = put(failing.URL)
+	if code != http.StatusUnprocessableEntity || len(probes) != 1 || probes[0].Status != http.StatusServiceUnavailable || probes[0].OK {
+		t.Fatalf("Expected a 503 to fail the probe, got %d %+v", code, probes)
+	}
+	code, body, probes := put(down.URL)
+	if code != http.StatusUnprocessableEntity || len(probes) != 1 || probes[0].Connect == nil || probes[0].Connect.Error == "" || len(body["error"]) == 0 {
+		t.Fatalf("Expected a failed connect step, got %d %+v", code, probes)
+	}
+	if len(mux.State().Services) != 0 {
+		t.Fatal("Expected nothing to be applied after a failed probe")
+	}
+
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ok.Close()
+	code, body, probes = put(ok.URL)
+	if code != http.StatusOK || len(probes) != 1 || !probes[0].OK || probes[0].Status != http.StatusNotFound || len(body["revision"]) == 0 {
+		t.Fatalf("Expected any answer below 500 to pass, got %d %+v", code, probes)
+	}
+	// An upstream already routed to is not probed again.
+	if _, _, probes = put(ok.URL); len(probes) != 0 {
+		t.Errorf("Expected no probes for an unchanged upstream, got %+v", probes)
+	}
+}
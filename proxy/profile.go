@@ -0,0 +1,308 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// DefaultProfileRecent is how many sampled requests Profiler keeps.
+const DefaultProfileRecent = 50
+
+// Profiler times every middleware and the upstream of a sample of
+// requests, to find which middleware adds latency. Each stage is charged
+// its own time only: a middleware's time excludes the middleware and
+// backend it calls. "routing" is the proxy's own work between the global
+// chain and the service, and "upstream" the reverse proxy, from sending the
+// request to streaming the last byte of the response.
+type Profiler struct {
+	rate atomic.Uint64
+
+	mu       sync.Mutex
+	services map[string]*serviceProfile
+	recent   []RequestProfile
+	next     int
+}
+
+type serviceProfile struct {
+	requests int64
+	total    time.Duration
+	order    []string
+	stages   map[string]*stageProfile
+}
+
+type stageProfile struct {
+	count int64
+	total time.Duration
+	max   time.Duration
+}
+
+// ProfileStage is the time spent in one stage.
+type ProfileStage struct {
+	Name string `json:"name"`
+	// Count is the sampled requests passing through the stage; it is
+	// left out for a single request.
+	Count  int64   `json:"count,omitempty"`
+	MeanMs float64 `json:"mean_ms"`
+	MaxMs  float64 `json:"max_ms,omitempty"`
+	// Share is the stage's part of the requests' time.
+	Share float64 `json:"share"`
+}
+
+// ServiceProfile breaks down the sampled requests of a service.
+type ServiceProfile struct {
+	// Service is empty for requests not routed to a service.
+	Service  string         `json:"service"`
+	Requests int64          `json:"requests"`
+	MeanMs   float64        `json:"mean_ms"`
+	Stages   []ProfileStage `json:"stages"`
+}
+
+// RequestProfile breaks down one sampled request.
+type RequestProfile struct {
+	At      time.Time      `json:"at"`
+	Service string         `json:"service"`
+	Method  string         `json:"method"`
+	Path    string         `json:"path"`
+	TotalMs float64        `json:"total_ms"`
+	Stages  []ProfileStage `json:"stages"`
+}
+
+// ProfileReport is what the Profiler has measured.
+type ProfileReport struct {
+	SampleRate float64          `json:"sample_rate"`
+	Services   []ServiceProfile `json:"services"`
+	// Recent are the last sampled requests, newest first.
+	Recent []RequestProfile `json:"recent"`
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{services: make(map[string]*serviceProfile)}
+}
+
+// SampleRate returns the fraction of requests profiled.
+func (p *Profiler) SampleRate() float64 {
+	return math.Float64frombits(p.rate.Load())
+}
+
+// SetSampleRate profiles the fraction rate of requests, from 0 (none) to 1
+// (all).
+func (p *Profiler) SetSampleRate(rate float64) error {
+	if !(rate >= 0 && rate <= 1) {
+		return errors.New("sample rate must be between 0 and 1")
+	}
+	p.rate.Store(math.Float64bits(rate))
+	return nil
+}
+
+// Reset forgets the measurements.
+func (p *Profiler) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.services = make(map[string]*serviceProfile)
+	p.recent = nil
+	p.next = 0
+}
+
+type profileKey struct{}
+
+// requestProfile is the stages a sampled request has been through.
+type requestProfile struct {
+	mu     sync.Mutex
+	start  time.Time
+	frames []profileFrame
+	order  []string
+	self   map[string]time.Duration
+}
+
+type profileFrame struct {
+	name  string
+	start time.Time
+	inner time.Duration
+}
+
+func (rp *requestProfile) enter(name string) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if _, ok := rp.self[name]; !ok {
+		rp.order = append(rp.order, name)
+		rp.self[name] = 0
+	}
+	rp.frames = append(rp.frames, profileFrame{name: name, start: time.Now()})
+}
+
+func (rp *requestProfile) exit() {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if len(rp.frames) == 0 {
+		return
+	}
+	f := rp.frames[len(rp.frames)-1]
+	rp.frames = rp.frames[:len(rp.frames)-1]
+	elapsed := time.Since(f.start)
+	if len(rp.frames) > 0 {
+		rp.frames[len(rp.frames)-1].inner += elapsed
+	}
+	rp.self[f.name] += elapsed - f.inner
+}
+
+// profiled times h as the stage name of sampled requests.
+func profiled(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rp, ok := r.Context().Value(profileKey{}).(*requestProfile)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rp.enter(name)
+		defer rp.exit()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serve runs h, profiling the request as one of service's if it is
+// sampled and not already being profiled.
+func (p *Profiler) serve(service func() string, h http.Handler, w http.ResponseWriter, r *http.Request) {
+	if p == nil {
+		h.ServeHTTP(w, r)
+		return
+	}
+	rate := p.SampleRate()
+	if _, profiling := r.Context().Value(profileKey{}).(*requestProfile); profiling || rate == 0 || rand.Float64() >= rate {
+		h.ServeHTTP(w, r)
+		return
+	}
+	rp := &requestProfile{start: time.Now(), self: make(map[string]time.Duration)}
+	method, path := r.Method, r.URL.Path
+	h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), profileKey{}, rp)))
+	total := time.Since(rp.start)
+	p.record(service(), method, path, total, rp)
+}
+
+func (p *Profiler) record(service, method, path string, total time.Duration, rp *requestProfile) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	req := RequestProfile{At: rp.start, Service: service, Method: method, Path: path, TotalMs: durationMs(total), Stages: []ProfileStage{}}
+	for _, name := range rp.order {
+		req.Stages = append(req.Stages, ProfileStage{Name: name, MeanMs: durationMs(rp.self[name]), Share: stageShare(rp.self[name], total)})
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sp := p.services[service]
+	if sp == nil {
+		sp = &serviceProfile{stages: make(map[string]*stageProfile)}
+		p.services[service] = sp
+	}
+	sp.requests++
+	sp.total += total
+	for _, name := range rp.order {
+		s := sp.stages[name]
+		if s == nil {
+			s = &stageProfile{}
+			sp.stages[name] = s
+			sp.order = append(sp.order, name)
+		}
+		d := rp.self[name]
+		s.count++
+		s.total += d
+		s.max = max(s.max, d)
+	}
+	if len(p.recent) < DefaultProfileRecent {
+		p.recent = append(p.recent, req)
+	} else {
+		p.recent[p.next] = req
+	}
+	p.next = (p.next + 1) % DefaultProfileRecent
+}
+
+func durationMs(d time.Duration) float64 {
+	return math.Round(float64(d.Microseconds())) / 1000
+}
+
+func stageShare(d, total time.Duration) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return math.Round(float64(d)/float64(total)*1000) / 1000
+}
+
+// Report returns the breakdown of every service, sorted by name.
+func (p *Profiler) Report() ProfileReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	report := ProfileReport{SampleRate: p.SampleRate(), Services: []ServiceProfile{}, Recent: []RequestProfile{}}
+	for service, sp := range p.services {
+		s := ServiceProfile{Service: service, Requests: sp.requests, MeanMs: durationMs(sp.total / time.Duration(sp.requests)), Stages: []ProfileStage{}}
+		for _, name := range sp.order {
+			st := sp.stages[name]
+			s.Stages = append(s.Stages, ProfileStage{
+				Name:   name,
+				Count:  st.count,
+				MeanMs: durationMs(st.total / time.Duration(st.count)),
+				MaxMs:  durationMs(st.max),
+				Share:  stageShare(st.total, sp.total),
+			})
+		}
+		report.Services = append(report.Services, s)
+	}
+	sort.Slice(report.Services, func(i, j int) bool { return report.Services[i].Service < report.Services[j].Service })
+	for i := range p.recent {
+		report.Recent = append(report.Recent, p.recent[(p.next-1-i+2*len(p.recent))%len(p.recent)])
+	}
+	return report
+}
+
+// AdminHandler serves the profiler:
+//
+//	GET /          ProfileReport
+//	POST /?rate=   profile the fraction rate of requests, e.g. 0.01
+//	DELETE /       stop profiling and forget the measurements
+func (p *Profiler) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, p.Report())
+	})
+	mux.HandleFunc("POST /{$}", func(w http.ResponseWriter, r *http.Request) {
+		rate, err := strconv.ParseFloat(r.URL.Query().Get("rate"), 64)
+		if err == nil {
+			err = p.SetSampleRate(rate)
+		}
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, errors.New("rate must be a number between 0 and 1"))
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, p.Report())
+	})
+	mux.HandleFunc("DELETE /{$}", func(w http.ResponseWriter, r *http.Request) {
+		p.SetSampleRate(0)
+		p.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// profile returns the route handler h of path, profiling sampled requests
+// the global chain has not.
+func (ph *RuntimeMux) profile(path string, h http.Handler) http.Handler {
+	h = profiled("routing", h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ph.Profiler.serve(func() string {
+			ph.RLock()
+			defer ph.RUnlock()
+			if service := ph.proxyServers[path]; service != nil {
+				return service.Name
+			}
+			return ""
+		}, h, w, r)
+	})
+}
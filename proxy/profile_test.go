@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProfiler(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	mux := NewRuntimeMux()
+	chain := mux.NewChain("")
+	chain.Use("slow", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(20 * time.Millisecond)
+			next.ServeHTTP(w, r)
+		})
+	})
+	handler := chain.Then(mux)
+	service, _ := NewService("app", "/app/", backend.URL)
+	service.Use("fast", func(next http.Handler) http.Handler { return next })
+	mux.AddProxy(service)
+
+	get := func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/app/", nil))
+	}
+	get()
+	if report := mux.Profiler.Report(); len(report.Services) != 0 {
+		t.Fatalf("Expected nothing profiled while sampling is off, got %+v", report)
+	}
+
+	admin := mux.Profiler.AdminHandler()
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("POST", "/?rate=2", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid rate to be rejected, got %d", w.Code)
+	}
+	mux.Profiler.SetSampleRate(1)
+	get()
+	get()
+
+	report := mux.Profiler.Report()
+	if len(report.Services) != 1 || report.Services[0].Service != "app" || report.Services[0].Requests != 2 || len(report.Recent) != 2 {
+		t.Fatalf("Expected 2 profiled requests of app, got %+v", report)
+	}
+	stages := map[string]ProfileStage{}
+	var order []string
+	for _, s := range report.Services[0].Stages {
+		stages[s.Name] = s
+		order = append(order, s.Name)
+	}
+	if len(order) != 4 || order[0] != "slow" || order[3] != "upstream" {
+		t.Fatalf("Expected slow, routing, fast and upstream, got %v", order)
+	}
+	// Each stage is charged its own time only.
+	if s := stages["slow"]; s.MeanMs < 20 || s.MeanMs > 30 || s.Count != 2 {
+		t.Errorf("Expected about 20ms in slow, got %+v", s)
+	}
+	if s := stages["upstream"]; s.MeanMs < 10 {
+		t.Errorf("Expected at least 10ms upstream, got %+v", s)
+	}
+	if s := stages["fast"]; s.MeanMs > 5 {
+		t.Errorf("Expected almost no time in fast, got %+v", s)
+	}
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("DELETE", "/", nil))
+	if report := mux.Profiler.Report(); report.SampleRate != 0 || len(report.Services) != 0 || len(report.Recent) != 0 {
+		t.Errorf("Expected profiling stopped and reset, got %+v", report)
+	}
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// DefaultProvisionTimeout bounds provisioning, most of which is spent
+// waiting for the certificate.
+const DefaultProvisionTimeout = 90 * time.Second
+
+// ProvisionRequest describes a project to onboard under a host name of its
+// own.
+type ProvisionRequest struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	URL  string `json:"url"`
+	// Path is the service's path; "/<name>" by default.
+	Path string `json:"path,omitempty"`
+}
+
+// Provisioning is how far onboarding a host got.
+type Provisioning struct {
+	Service *Service `json:"service"`
+	// Probe checks that the backend answers.
+	Probe Probe `json:"probe"`
+	// Certificate is nil when the proxy does not manage certificates.
+	Certificate *CertificateStatus `json:"certificate,omitempty"`
+	// Ready is set once the backend answers and the certificate is issued.
+	Ready bool `json:"ready"`
+}
+
+// CertificateStatus is the outcome of obtaining a host's certificate.
+type CertificateStatus struct {
+	Ready    bool       `json:"ready"`
+	NotAfter *time.Time `json:"not_after,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Issuer obtains a certificate for host and returns when it expires.
+type Issuer func(ctx context.Context, host string) (time.Time, error)
+
+// Provision routes req.Host to a new service, which also lets the host
+// policy accept it, then probes the backend and, with issue, obtains the
+// host's certificate. The route stays in place when the probe or issuance
+// fails, as they may only need DNS to catch up; provisioning the same
+// request again reports the current status.
+func (ph *RuntimeMux) Provision(ctx context.Context, req ProvisionRequest, issue Issuer) (Provisioning, error) {
+	if req.Path == "" {
+		req.Path = "/" + req.Name
+	}
+	service, err := NewServiceFromConfig(&Service{Name: req.Name, Path: req.Path, Url: req.URL, Hosts: []string{req.Host}})
+	if err != nil {
+		return Provisioning{}, err
+	}
+	if len(service.Hosts) != 1 {
+		return Provisioning{}, errors.New("host is required")
+	}
+	host := service.Hosts[0]
+
+	existing, err := ph.AddProxyIfAbsent(service)
+	switch {
+	case err != nil:
+		return Provisioning{}, err
+	case existing == nil:
+	case existing.Name == service.Name && existing.Url == service.Url && reflect.DeepEqual(existing.Hosts, service.Hosts):
+		service = existing
+	default:
+		return Provisioning{}, &ConflictError{fmt.Sprintf("path %s is already routed to service %s", service.Path, existing.Name)}
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, DefaultProbeTimeout)
+	p := Provisioning{Service: service, Probe: ProbeUpstream(probeCtx, service.Name, service.Url)}
+	cancel()
+	if issue != nil {
+		p.Certificate = &CertificateStatus{}
+		notAfter, err := issue(ctx, host)
+		if err != nil {
+			p.Certificate.Error = err.Error()
+		} else {
+			p.Certificate.Ready = true
+			p.Certificate.NotAfter = &notAfter
+		}
+	}
+	p.Ready = p.Probe.OK && (p.Certificate == nil || p.Certificate.Ready)
+	return p, nil
+}
+
+// ConflictError is a request clashing with another service or with a
+// concurrent change of the route table.
+type ConflictError struct{ msg string }
+
+func (e *ConflictError) Error() string { return e.msg }
+
+// ProvisionHandler onboards hosts; see Provision. issue is nil when the
+// proxy does not manage certificates.
+//
+//	POST /?timeout=90s   {"name", "host", "url", "path"}; 200 when ready,
+//	                     202 when routed but the backend or certificate is
+//	                     not ready yet
+func (ph *RuntimeMux) ProvisionHandler(issue Issuer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /{$}", func(w http.ResponseWriter, r *http.Request) {
+		var req ProvisionRequest
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid provisioning request: %v", err))
+			return
+		}
+		timeout := DefaultProvisionTimeout
+		if v := r.URL.Query().Get("timeout"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				admin.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout %q", v))
+				return
+			}
+			timeout = d
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		p, err := ph.Provision(ctx, req, issue)
+		var conflict *ConflictError
+		if errors.As(err, &conflict) {
+			admin.WriteError(w, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			admin.WriteError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		status := http.StatusOK
+		if !p.Ready {
+			status = http.StatusAccepted
+		}
+		admin.WriteJSON(w, status, p)
+	})
+	return mux
+}
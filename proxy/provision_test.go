@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProvisionHandler(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	issued := 0
+	pending := true
+	notAfter := time.Now().Add(90 * 24 * time.Hour).UTC().Truncate(time.Second)
+	issue := func(ctx context.Context, host string) (time.Time, error) {
+		if host != "app.example.com" {
+			t.Errorf("Expected a certificate for app.example.com, got %s", host)
+		}
+		issued++
+		if pending {
+			return time.Time{}, errors.New("certificate for app.example.com is not issued yet")
+		}
+		return notAfter, nil
+	}
+
+	mux := NewRuntimeMux()
+	handler := mux.ProvisionHandler(issue)
+	provision := func(body string) (int, Provisioning) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/?timeout=5s", strings.NewReader(body)))
+		var p Provisioning
+		json.Unmarshal(w.Body.Bytes(), &p)
+		return w.Code, p
+	}
+	req := `{"name": "app", "host": "App.Example.com", "url": "` + backend.URL + `"}`
+
+	code, p := provision(req)
+	if code != http.StatusAccepted || p.Ready || !p.Probe.OK || p.Certificate == nil || p.Certificate.Error == "" {
+		t.Fatalf("Expected 202 while the certificate is pending, got %d: %+v", code, p)
+	}
+	if s := mux.proxyServers["/app"]; s == nil || len(s.Hosts) != 1 || s.Hosts[0] != "app.example.com" {
+		t.Fatalf("Expected the host routed at /app, got %+v", s)
+	}
+
+	pending = false
+	code, p = provision(req)
+	if code != http.StatusOK || !p.Ready || !p.Certificate.Ready || !p.Certificate.NotAfter.Equal(notAfter) {
+		t.Fatalf("Expected 200 once issued, got %d: %+v", code, p)
+	}
+	if issued != 2 || len(mux.proxyServers) != 1 {
+		t.Errorf("Expected one service and two issuance attempts, got %d services and %d attempts", len(mux.proxyServers), issued)
+	}
+
+	if code, _ := provision(`{"name": "other", "path": "/app", "host": "other.example.com", "url": "` + backend.URL + `"}`); code != http.StatusConflict {
+		t.Errorf("Expected 409 for another service at /app, got %d", code)
+	}
+	if code, _ := provision(`{"name": "app", "url": "` + backend.URL + `", "extra": 1}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown field, got %d", code)
+	}
+	if code, _ := provision(`{"name": "nohost", "url": "` + backend.URL + `"}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 without a host, got %d", code)
+	}
+}
+
+func TestProvisionConcurrentConflict(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	mux := NewRuntimeMux()
+	const n = 8
+	errs := make(chan error, n)
+	for i := range n {
+		go func() {
+			req := ProvisionRequest{Name: fmt.Sprintf("app%d", i), Path: "/app", Host: fmt.Sprintf("app%d.example.com", i), URL: backend.URL}
+			_, err := mux.Provision(context.Background(), req, nil)
+			errs <- err
+		}()
+	}
+	added := 0
+	for range n {
+		var conflict *ConflictError
+		switch err := <-errs; {
+		case err == nil:
+			added++
+		case !errors.As(err, &conflict):
+			t.Errorf("Expected a ConflictError, got %v", err)
+		}
+	}
+	if added != 1 {
+		t.Errorf("Expected exactly one service to win /app, got %d", added)
+	}
+}
@@ -2,15 +2,26 @@ package proxy
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/accesslog"
+	"github.com/kirtansoni/reverse-proxy-go/events"
+	"github.com/kirtansoni/reverse-proxy-go/graphql"
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+	"github.com/kirtansoni/reverse-proxy-go/websocket"
 )
 
 type Service struct{
@@ -18,19 +29,171 @@ type Service struct{
 	*httputil.ReverseProxy `json:"-"`
 	Path string `json:"path"`
 	Url string	`json:"url"`
+	Descriptors string `json:"descriptors,omitempty"`
+	GraphQL *graphql.Config `json:"graphql,omitempty"`
+	Logging *accesslog.Config `json:"logging,omitempty"`
+	// MaxConcurrency is the number of concurrent requests the backend is
+	// sized for, used to report utilization.
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// AdaptiveTimeout, if set, bounds upstream calls by a timeout learned
+	// from the service's latency.
+	AdaptiveTimeout *TimeoutConfig `json:"adaptive_timeout,omitempty"`
+	WebSocket *websocket.Config `json:"websocket,omitempty"`
+	// TLSSessionCacheSize is the number of TLS sessions to the backend kept
+	// for resumption; 0 means DefaultTLSSessionCacheSize.
+	TLSSessionCacheSize int `json:"tls_session_cache_size,omitempty"`
+	// SourceAddr is the local IP address or interface upstream connections
+	// leave from; see SetSourceAddr.
+	SourceAddr string `json:"source_addr,omitempty"`
+	// ConnectionAffinity pins each downstream connection to an upstream
+	// connection of its own, for NTLM and Negotiate authentication.
+	ConnectionAffinity bool `json:"connection_affinity,omitempty"`
+	// Compat adapts requests to legacy backends; see EnableCompat.
+	Compat *CompatConfig `json:"compat,omitempty"`
+	// DebugBackend adds BackendHeader to responses to trusted clients; see
+	// RuntimeMux.DebugTrusted.
+	DebugBackend bool `json:"debug_backend,omitempty"`
+	// ServerTiming adds proxy and upstream durations to responses; see
+	// EnableServerTiming.
+	ServerTiming bool `json:"server_timing,omitempty"`
+	// Sticky pins client sessions to one backend address; see EnableSticky.
+	Sticky *StickyConfig `json:"sticky,omitempty"`
+	// Annotations are operator notes, such as AnnotationOwner.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Hosts are host names routed to the service regardless of path.
+	Hosts []string `json:"hosts,omitempty"`
+	// ResponseHeaders are header templates added to every response; see
+	// SetResponseHeader.
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	// ResponseHeaderLimits bound the headers the backend may send; see
+	// SetResponseHeaderLimits.
+	ResponseHeaderLimits *HeaderLimitConfig `json:"response_header_limits,omitempty"`
+	CompressionDictionary *DictionaryConfig `json:"compression_dictionary,omitempty"`
+	ColdStart *ColdStartConfig `json:"cold_start,omitempty"`
+	Watermark *WatermarkConfig `json:"watermark,omitempty"`
+	// Cache keeps responses to GET requests; see EnableCache.
+	Cache *CacheConfig `json:"cache,omitempty"`
+	// Checks are synthetic checks run against the backend; see RunChecks.
+	Checks []CheckConfig `json:"checks,omitempty"`
+	// Regions send some clients to region-specific backends; see SetRegions.
+	Regions []RegionRoute `json:"regions,omitempty"`
+	UpstreamAuth *UpstreamAuthConfig `json:"upstream_auth,omitempty"`
+	// UpstreamHosts are the hosts a templated Url may resolve to; see
+	// SetUpstreamHosts.
+	UpstreamHosts []string `json:"upstream_hosts,omitempty"`
+	// Middleware is the global middleware the service runs, in order; nil
+	// means the whole chain. See SetMiddleware.
+	Middleware []string `json:"middleware,omitempty"`
+
+	// backend replaces the reverse proxy when set, e.g. for transcoding.
+	backend     http.Handler
+	coldStart   *coldStart
+	cache       *responseCache
+	middlewares []namedMiddleware
+	handler     http.Handler
+}
+
+type namedMiddleware struct {
+	name string
+	wrap func(http.Handler) http.Handler
 }
 
+// NewService returns a service proxying to Url, which may be a template
+// of request variables such as http://{header.X-Tenant}.internal:8080; see
+// SetUpstreamHosts.
 func NewService(name string, Path string,Url string) (*Service, error){
-	ServiceURL, err :=  url.Parse(Url)
+	parse := url.Parse
+	if isUpstreamTemplate(Url) {
+		parse = parseUpstreamTemplate
+	}
+	ServiceURL, err :=  parse(Url)
 	if err != nil {
 		return nil, errors.New("Service URL invalid" + err.Error())
 	}
-	return &Service{
+	rp := httputil.NewSingleHostReverseProxy(ServiceURL)
+	rp.Transport = newTransport(0)
+	rp.ErrorHandler = proxyErrorHandler(name)
+	rp.Director = targetDirector(rp.Director)
+	s := &Service{
 		Name:name,
 		Path: Path,
 		Url: Url,
-		ReverseProxy: httputil.NewSingleHostReverseProxy(ServiceURL),
-	}, nil
+		ReverseProxy: rp,
+	}
+	s.SetUpstreamHosts(nil)
+	return s, nil
+}
+
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.handler != nil {
+		s.handler.ServeHTTP(w, r)
+		return
+	}
+	s.ReverseProxy.ServeHTTP(w, r)
+}
+
+// Use adds mw in front of the service's backend, replacing any middleware
+// previously added under the same name. Services are shared with in-flight
+// requests once added, so configure them (or a copy) before AddProxy.
+func (s *Service) Use(name string, mw func(http.Handler) http.Handler) {
+	s.middlewares = append(s.without(name), namedMiddleware{name, mw})
+	s.rebuild()
+}
+
+// useOutermost is like Use but runs mw before every other middleware.
+func (s *Service) useOutermost(name string, mw func(http.Handler) http.Handler) {
+	s.middlewares = append([]namedMiddleware{{name, mw}}, s.without(name)...)
+	s.rebuild()
+}
+
+// without returns a copy of the middlewares minus the one called name.
+func (s *Service) without(name string) []namedMiddleware {
+	middlewares := make([]namedMiddleware, 0, len(s.middlewares)+1)
+	for _, m := range s.middlewares {
+		if m.name != name {
+			middlewares = append(middlewares, m)
+		}
+	}
+	return middlewares
+}
+
+// rebuild composes the handler; the first middleware added runs outermost.
+func (s *Service) rebuild() {
+	var h http.Handler = s.ReverseProxy
+	if s.backend != nil {
+		h = s.backend
+	}
+	h = profiled("upstream", h)
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		h = profiled(s.middlewares[i].name, s.middlewares[i].wrap(h))
+	}
+	s.handler = h
+}
+
+// EnableGraphQL enforces query limits and resolves persisted queries for a
+// GraphQL backend.
+func (s *Service) EnableGraphQL(cfg graphql.Config) error {
+	guard, err := graphql.NewGuard(cfg)
+	if err != nil {
+		return err
+	}
+	s.GraphQL = &cfg
+	s.Use("graphql", guard.Middleware)
+	return nil
+}
+
+// EnableLogging logs the service's requests, including those rejected by its
+// other middlewares.
+func (s *Service) EnableLogging(cfg accesslog.Config) {
+	s.Logging = &cfg
+	s.useOutermost("log", accesslog.New(s.Name, cfg, nil).Middleware)
+}
+
+// EnableWebSocketLimits caps the service's concurrent WebSocket connections
+// and closes idle ones. The limits are enforced per path by the RuntimeMux,
+// so connections upgraded before a change count against the new cap.
+func (s *Service) EnableWebSocketLimits(cfg websocket.Config) {
+	s.WebSocket = &cfg
 }
 
 func (s *Service) Json()([]byte,error){
@@ -41,7 +204,73 @@ type RuntimeMux struct{
 	sync.RWMutex
 	mux          *http.ServeMux
 	proxyServers map[string]*Service
+	load         map[string]*loadStats
+	sockets      map[string]*websocket.Guard
+	headers      map[string]*headerStats
+	dictionaries *dictionaryStore
+	// routes holds the handler of each path, and hosts the path each
+	// host name is routed to.
+	routes       map[string]http.Handler
+	hosts        map[string]string
+	// mux hands every request to table, the ServeMux of the live routes,
+	// which is rebuilt when routes are removed; retired are the removed
+	// paths it answers with FallbackHandler.
+	table        atomic.Pointer[http.ServeMux]
+	retired      map[string]bool
+	handlers     atomic.Int64
+	rebuilds     atomic.Int64
 	FallbackHandler http.HandlerFunc
+
+	// Store, if set, is where the route table is saved under StateKey
+	// (DefaultStateKey if empty) after every change; see Restore and Follow.
+	Store    storage.Store
+	StateKey string
+
+	// WebSocketGrace is how long WebSocket clients of a removed service get
+	// to answer the close frame before their connections are cut.
+	WebSocketGrace time.Duration
+
+	// Canary, if set, makes route changes provisional; see CanaryConfig.
+	Canary *CanaryConfig
+	// HeaderAlerts, if set, flags requests with unusual headers or URLs.
+	HeaderAlerts *HeaderThresholds
+	// Uptime, if set, rolls up the availability and latency of every
+	// service for the status page.
+	Uptime *UptimeHistory
+	// CheckWebhook, if set, receives failures and recoveries of synthetic
+	// checks.
+	CheckWebhook string
+	// CheckHistorySize is how many runs of each check are kept for
+	// CheckHistories; 0 means DefaultCheckHistory.
+	CheckHistorySize int
+	// Overrides, if set, lets developers holding a signed cookie route
+	// their own requests for a service to another backend.
+	Overrides *Overrides
+	// CountryHeader is the request header holding the client's country for
+	// region routes, set by a CDN or GeoIP-aware load balancer in front of
+	// the proxy; empty means DefaultCountryHeader.
+	CountryHeader string
+	// Events receives route table changes and check failures and
+	// recoveries. NewRuntimeMux creates one; set it before use to share a
+	// bus with other subsystems.
+	Events *events.Bus
+	// Profiler times the middleware and upstream of a sample of requests.
+	// NewRuntimeMux creates one with sampling off.
+	Profiler *Profiler
+	// DebugTrusted are the client networks shown debugging headers such as
+	// BackendHeader; empty means loopback only.
+	DebugTrusted []netip.Prefix
+
+	// history holds the last route tables, newest last.
+	history      []Revision
+	changelog    map[string][]ServiceChange
+	nextRevision int
+	canary       atomic.Pointer[canary]
+	checks       checks
+	chain        *Chain
+	reloads      reloads
+	// saved is the route table last written to Store.
+	saved        []byte
 }
 
 func (ph *RuntimeMux )GetMux() *http.ServeMux{
@@ -49,42 +278,174 @@ func (ph *RuntimeMux )GetMux() *http.ServeMux{
 }
 
 func NewRuntimeMux() *RuntimeMux{
-	return &RuntimeMux{
+	ph := &RuntimeMux{
 		mux: http.NewServeMux(),
 		proxyServers: make(map[string]*Service),
+		load: make(map[string]*loadStats),
+		sockets: make(map[string]*websocket.Guard),
+		headers: make(map[string]*headerStats),
+		dictionaries: newDictionaryStore(),
+		Events: events.NewBus(),
+		Profiler: NewProfiler(),
+		changelog: make(map[string][]ServiceChange),
+		routes: make(map[string]http.Handler),
+		hosts: make(map[string]string),
+		retired: make(map[string]bool),
+		checks: checks{states: make(map[string]*checkState)},
+		WebSocketGrace: 5 * time.Second,
 		FallbackHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Write([]byte("fallback: Path not found" + r.URL.Path))
 		}),
 	}
+	ph.table.Store(http.NewServeMux())
+	ph.mux.HandleFunc("/", ph.dispatch)
+	ph.record("initial")
+	return ph
 }
 
 func (ph *RuntimeMux) AddProxy(Service *Service) error{
 	ph.Lock()
 	defer ph.Unlock()
 
+	return ph.add(Service)
+}
+
+// AddProxyIfAbsent adds Service unless its path is already routed, in which
+// case it adds nothing and returns the service there. The check and the
+// addition happen under one lock, so concurrent callers never replace each
+// other's service.
+func (ph *RuntimeMux) AddProxyIfAbsent(Service *Service) (*Service, error){
+	ph.Lock()
+	defer ph.Unlock()
+
+	if existing, ok := ph.proxyServers[Service.Path]; ok {
+		return existing, nil
+	}
+	return nil, ph.add(Service)
+}
+
+// add routes Service.Path to Service unless its hosts or middleware chain
+// conflict. Callers must hold ph's lock.
+func (ph *RuntimeMux) add(Service *Service) error{
+	if err := hostConflict(Service, ph.proxyServers); err != nil {
+		return err
+	}
+	if err := ph.chain.check(Service); err != nil {
+		return err
+	}
+	ph.install(Service)
+	ph.changed("set " + Service.Path)
+	ph.watch()
+	return nil
+}
+
+// install routes Service.Path to Service. Callers must hold ph's lock.
+func (ph *RuntimeMux) install(Service *Service) {
 	_ , exists := ph.proxyServers[Service.Path]
 	ph.proxyServers[Service.Path] = Service
 	path := Service.Path
 	if !exists{
-		ph.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		stats := &loadStats{}
+		ph.load[path] = stats
+		sockets := websocket.NewGuard(websocket.Config{})
+		ph.sockets[path] = sockets
+		headers := newHeaderStats()
+		ph.headers[path] = headers
+		route := ph.newRouteHandler(ph.profile(path, sockets.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ph.RLock()
 			service,exists := ph.proxyServers[path]
 			ph.RUnlock()
 			if exists && service!=nil {
-				service.ServeHTTP(w, r)
+				headers.observe(service.Name, r, ph.HeaderAlerts)
+				w, r := service.withResponseHeaders(w, r)
+				w, r = ph.withBackendHeader(service, w, r)
+				// Overridden requests stay out of the service's load,
+				// canary and dictionary statistics.
+				r, overridden := ph.withOverride(service, w, r)
+				if overridden {
+					service.ServeHTTP(w, r)
+					return
+				}
+				r = ph.withRegion(service, w, r)
+				w, r, finish := ph.withDictionary(service, w, r)
+				w, r, finishWatermark := service.withWatermark(w, r)
+				ph.serveTracked(stats, service, w, r)
+				finishWatermark()
+				finish()
 				} else{
 					ph.FallbackHandler.ServeHTTP(w,r)
 				}
-			})
+			}))))
+		ph.routes[path] = route
+		if ph.retired[path] {
+			delete(ph.retired, path)
+			ph.rebuildTable()
+		} else {
+			ph.table.Load().Handle(path, route)
+		}
 
 		}
-	return nil
+	var cfg websocket.Config
+	if Service.WebSocket != nil {
+		cfg = *Service.WebSocket
+	}
+	ph.sockets[path].SetConfig(cfg)
+}
+
+// retire unroutes path, closing its WebSockets within WebSocketGrace and
+// its idle upstream connections, and drops its handler from the route
+// table. Callers must hold ph's lock.
+func (ph *RuntimeMux) retire(path string) {
+	service := ph.proxyServers[path]
+	if service == nil {
+		return
+	}
+	sockets := ph.sockets[path]
+	delete(ph.proxyServers, path)
+	delete(ph.routes, path)
+	delete(ph.load, path)
+	delete(ph.sockets, path)
+	delete(ph.headers, path)
+	ph.retired[path] = true
+	ph.rebuildTable()
+	service.closeIdleConnections()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), ph.WebSocketGrace)
+		defer cancel()
+		sockets.Shutdown(ctx, "service removed")
+	}()
+}
+
+// CloseWebSockets sends close frames to the WebSocket clients of every
+// route and waits for them to close, cutting those still open when ctx is
+// done. http.Server.Shutdown does not cover these hijacked connections.
+func (ph *RuntimeMux) CloseWebSockets(ctx context.Context) error {
+	ph.RLock()
+	guards := make([]*websocket.Guard, 0, len(ph.sockets))
+	for _, g := range ph.sockets {
+		guards = append(guards, g)
+	}
+	ph.RUnlock()
+
+	errs := make(chan error, len(guards))
+	for _, g := range guards {
+		go func() { errs <- g.Shutdown(ctx, "server shutting down") }()
+	}
+	var err error
+	for range guards {
+		if e := <-errs; e != nil {
+			err = e
+		}
+	}
+	return err
 }
 
 func (ph *RuntimeMux) removeHandler(Service *Service){
 	ph.Lock()
 	defer ph.Unlock()
-	ph.proxyServers[Service.Path] = nil
+	ph.retire(Service.Path)
+	ph.changed("remove " + Service.Path)
+	ph.watch()
 }
 
 //no tests for this
@@ -97,6 +458,12 @@ func (ph *RuntimeMux) PrintPaths(){
 		if service != nil{
 			json,_:= service.Json()
 			fmt.Println(string(json))
+			if service.pastDecommission(time.Now()) {
+				fmt.Printf("  ! %s is past its decommission date %s\n", service.Name, service.Annotations[AnnotationDecommission])
+			}
+			if service.coldStart != nil && service.coldStart.state() == StateSleeping {
+				fmt.Printf("  z %s is sleeping until its next request\n", service.Name)
+			}
 		}
 	}
 	fmt.Println("======================================")
@@ -104,7 +471,7 @@ func (ph *RuntimeMux) PrintPaths(){
 
 func (ph *RuntimeMux) CLI() {
 	fmt.Println("Proxy Management CLI")
-	fmt.Println("Available commands: add, remove, list, exit")
+	fmt.Println("Available commands: add, graphql, log, capacity, timeout, sessions, source, affinity, compat, debug, timing, sticky, websocket, hosts, upstreams, annotate, header, headerlimit, dictionary, coldstart, suspend, watermark, cache, purge, check, region, middleware, credentials, remove, list, changelog, rollback, exit")
 
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
@@ -121,8 +488,8 @@ func (ph *RuntimeMux) CLI() {
 
 		switch args[0] {
 		case "add":
-			if len(args) != 4 {
-				fmt.Println("Usage: add <name> <path> <url>")
+			if len(args) != 4 && len(args) != 5 {
+				fmt.Println("Usage: add <name> <path> <url> [grpc-descriptor-set]")
 				continue
 			}
 			service, err := NewService(args[1], args[2], args[3])
@@ -130,12 +497,897 @@ func (ph *RuntimeMux) CLI() {
 				fmt.Printf("Error creating service: %v\n", err)
 				continue
 			}
+			if len(args) == 5 {
+				if err := service.EnableTranscoding(args[4]); err != nil {
+					fmt.Printf("Error enabling transcoding: %v\n", err)
+					continue
+				}
+			}
 			if err := ph.AddProxy(service); err != nil {
 				fmt.Printf("Error adding proxy: %v\n", err)
 				continue
 			}
 			fmt.Printf("Added service %s at path %s\n", args[1], args[2])
 
+		case "graphql":
+			if len(args) != 4 {
+				fmt.Println("Usage: graphql <path> <max-depth> <max-complexity>")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			depth, err1 := strconv.Atoi(args[2])
+			complexity, err2 := strconv.Atoi(args[3])
+			if err1 != nil || err2 != nil {
+				fmt.Println("Limits must be integers")
+				continue
+			}
+			// Configure a copy so in-flight requests never see a half
+			// configured service.
+			updated := *service
+			if err := updated.EnableGraphQL(graphql.Config{MaxDepth: depth, MaxComplexity: complexity}); err != nil {
+				fmt.Printf("Error enabling GraphQL: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error enabling GraphQL: %v\n", err)
+				continue
+			}
+			fmt.Printf("GraphQL limits set for %s\n", args[1])
+
+		case "log":
+			if len(args) != 3 && len(args) != 4 {
+				fmt.Println("Usage: log <path> <sample-rate> [header,...]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			rate, err := strconv.Atoi(args[2])
+			if err != nil || rate < 0 {
+				fmt.Println("Sample rate must be a non-negative integer")
+				continue
+			}
+			cfg := accesslog.Config{SampleRate: rate}
+			if len(args) == 4 {
+				cfg.Headers = strings.Split(args[3], ",")
+			}
+			updated := *service
+			updated.EnableLogging(cfg)
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error enabling access logging: %v\n", err)
+				continue
+			}
+			fmt.Printf("Access logging enabled for %s\n", args[1])
+
+		case "capacity":
+			if len(args) != 3 {
+				fmt.Println("Usage: capacity <path> <max-concurrency>")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 0 {
+				fmt.Println("Capacity must be a non-negative integer")
+				continue
+			}
+			updated := *service
+			updated.MaxConcurrency = n
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting capacity: %v\n", err)
+				continue
+			}
+			fmt.Printf("Capacity of %s set to %d\n", args[1], n)
+
+		case "timeout":
+			if len(args) != 4 && len(args) != 5 {
+				fmt.Println("Usage: timeout <path> <min> <max> [multiplier]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			lo, err1 := time.ParseDuration(args[2])
+			hi, err2 := time.ParseDuration(args[3])
+			if err := errors.Join(err1, err2); err != nil || hi > 0 && lo > hi {
+				fmt.Println("Timeouts must be durations with min <= max (max 0 for no bound)")
+				continue
+			}
+			cfg := TimeoutConfig{MinMs: lo.Milliseconds(), MaxMs: hi.Milliseconds()}
+			if len(args) == 5 {
+				if cfg.Multiplier, err1 = strconv.ParseFloat(args[4], 64); err1 != nil || cfg.Multiplier <= 0 {
+					fmt.Println("Multiplier must be a positive number")
+					continue
+				}
+			}
+			updated := *service
+			updated.AdaptiveTimeout = &cfg
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error enabling adaptive timeout: %v\n", err)
+				continue
+			}
+			fmt.Printf("Adaptive timeout enabled for %s\n", args[1])
+
+		case "sessions":
+			if len(args) != 3 {
+				fmt.Println("Usage: sessions <path> <tls-session-cache-size>")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 0 {
+				fmt.Println("Cache size must be a non-negative integer")
+				continue
+			}
+			updated := *service
+			updated.SetTLSSessionCacheSize(n)
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting TLS session cache: %v\n", err)
+				continue
+			}
+			fmt.Printf("TLS session cache of %s set to %d\n", args[1], n)
+
+		case "source":
+			if len(args) != 3 {
+				fmt.Println("Usage: source <path> <ip|interface|off>")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			source := args[2]
+			if source == "off" {
+				source = ""
+			}
+			updated := *service
+			if err := updated.SetSourceAddr(source); err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting source address: %v\n", err)
+				continue
+			}
+			if source == "" {
+				fmt.Printf("Upstream connections of %s leave from the default address\n", args[1])
+			} else {
+				fmt.Printf("Upstream connections of %s leave from %s\n", args[1], source)
+			}
+
+		case "affinity":
+			if len(args) != 2 {
+				fmt.Println("Usage: affinity <path>")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			updated := *service
+			updated.EnableConnectionAffinity()
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error enabling connection affinity: %v\n", err)
+				continue
+			}
+			fmt.Printf("Connection affinity enabled for %s\n", args[1])
+
+		case "compat":
+			if len(args) != 3 && len(args) != 4 || args[2] != "http1.0" && args[2] != "http1.1" && args[2] != "off" {
+				fmt.Println("Usage: compat <path> <http1.0|http1.1|off> [Header-Name,...]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			var cfg CompatConfig
+			if args[2] != "off" {
+				cfg.HTTP10 = args[2] == "http1.0"
+				if len(args) == 4 {
+					cfg.HeaderCase = strings.Split(args[3], ",")
+				}
+			}
+			updated := *service
+			if err := updated.EnableCompat(cfg); err != nil {
+				fmt.Printf("Error setting compatibility mode: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting compatibility mode: %v\n", err)
+				continue
+			}
+			if updated.Compat == nil {
+				fmt.Printf("Compatibility mode disabled for %s\n", args[1])
+			} else {
+				fmt.Printf("Compatibility mode enabled for %s\n", args[1])
+			}
+
+		case "debug":
+			if len(args) != 3 || args[2] != "on" && args[2] != "off" {
+				fmt.Println("Usage: debug <path> <on|off>")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			updated := *service
+			updated.DebugBackend = args[2] == "on"
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting debug headers: %v\n", err)
+				continue
+			}
+			if updated.DebugBackend {
+				fmt.Printf("Backend header enabled for %s\n", args[1])
+			} else {
+				fmt.Printf("Backend header disabled for %s\n", args[1])
+			}
+
+		case "timing":
+			if len(args) != 3 || args[2] != "on" && args[2] != "off" {
+				fmt.Println("Usage: timing <path> <on|off>")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			updated := *service
+			updated.EnableServerTiming(args[2] == "on")
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting timing headers: %v\n", err)
+				continue
+			}
+			if updated.ServerTiming {
+				fmt.Printf("Server-Timing enabled for %s\n", args[1])
+			} else {
+				fmt.Printf("Server-Timing disabled for %s\n", args[1])
+			}
+
+		case "sticky":
+			if len(args) != 3 && len(args) != 4 {
+				fmt.Println("Usage: sticky <path> <ttl|off> [cookie]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			updated := *service
+			if args[2] == "off" {
+				updated.DisableSticky()
+				if err := ph.AddProxy(&updated); err != nil {
+					fmt.Printf("Error setting sticky sessions: %v\n", err)
+					continue
+				}
+				fmt.Printf("Sticky sessions disabled for %s\n", args[1])
+				continue
+			}
+			ttl, err := time.ParseDuration(args[2])
+			if err != nil || ttl <= 0 {
+				fmt.Println("TTL must be a positive duration, e.g. 30m")
+				continue
+			}
+			cfg := StickyConfig{TTLMs: ttl.Milliseconds()}
+			if len(args) == 4 {
+				cfg.Cookie = args[3]
+			}
+			if err := updated.EnableSticky(cfg); err != nil {
+				fmt.Printf("Failed to enable sticky sessions: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting sticky sessions: %v\n", err)
+				continue
+			}
+			fmt.Printf("Sessions of %s pinned to a backend for %s after their last request\n", args[1], ttl)
+
+		case "websocket":
+			if len(args) != 4 && len(args) != 5 {
+				fmt.Println("Usage: websocket <path> <max-conns> <idle-timeout> [ping-interval]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n < 0 {
+				fmt.Println("Max connections must be a non-negative integer")
+				continue
+			}
+			idle, err := time.ParseDuration(args[3])
+			if err != nil {
+				fmt.Printf("Invalid idle timeout: %v\n", err)
+				continue
+			}
+			cfg := websocket.Config{MaxConns: n, IdleTimeout: websocket.Duration(idle)}
+			if len(args) == 5 {
+				ping, err := time.ParseDuration(args[4])
+				if err != nil {
+					fmt.Printf("Invalid ping interval: %v\n", err)
+					continue
+				}
+				cfg.PingInterval = websocket.Duration(ping)
+			}
+			updated := *service
+			updated.EnableWebSocketLimits(cfg)
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting WebSocket limits: %v\n", err)
+				continue
+			}
+			fmt.Printf("WebSocket limits set for %s\n", args[1])
+
+		case "hosts":
+			if len(args) != 2 && len(args) != 3 {
+				fmt.Println("Usage: hosts <path> [host,...]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			var hosts []string
+			if len(args) == 3 {
+				hosts = strings.Split(args[2], ",")
+			}
+			updated := *service
+			if err := updated.SetHosts(hosts); err != nil {
+				fmt.Printf("Error setting hosts: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting hosts: %v\n", err)
+				continue
+			}
+			fmt.Printf("Hosts of %s set to %v\n", args[1], updated.Hosts)
+
+		case "upstreams":
+			if len(args) != 2 && len(args) != 3 {
+				fmt.Println("Usage: upstreams <path> [host,*.suffix,...]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			var hosts []string
+			if len(args) == 3 {
+				hosts = strings.Split(args[2], ",")
+			}
+			updated := *service
+			if err := updated.SetUpstreamHosts(hosts); err != nil {
+				fmt.Printf("Error setting upstream hosts: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting upstream hosts: %v\n", err)
+				continue
+			}
+			fmt.Printf("Upstream hosts of %s set to %v\n", args[1], updated.UpstreamHosts)
+
+		case "annotate":
+			if len(args) < 3 {
+				fmt.Println("Usage: annotate <path> <key> [value...]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			updated := *service
+			if err := updated.SetAnnotation(args[2], strings.Join(args[3:], " ")); err != nil {
+				fmt.Printf("Error annotating: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error annotating: %v\n", err)
+				continue
+			}
+			fmt.Printf("Annotations of %s: %v\n", args[1], updated.Annotations)
+
+		case "header":
+			if len(args) < 3 {
+				fmt.Println("Usage: header <path> <name> [template...]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			updated := *service
+			if err := updated.SetResponseHeader(args[2], strings.Join(args[3:], " ")); err != nil {
+				fmt.Printf("Error setting header: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting header: %v\n", err)
+				continue
+			}
+			fmt.Printf("Response headers of %s: %v\n", args[1], updated.ResponseHeaders)
+
+		case "headerlimit":
+			if len(args) != 3 && len(args) != 4 && len(args) != 5 || len(args) == 5 && args[4] != "truncate" {
+				fmt.Println("Usage: headerlimit <path> <max-bytes|off> [max-count] [truncate]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			var cfg *HeaderLimitConfig
+			if args[2] != "off" {
+				cfg = &HeaderLimitConfig{Truncate: len(args) == 5}
+				var err error
+				if cfg.MaxBytes, err = strconv.Atoi(args[2]); err != nil {
+					fmt.Println("Max bytes must be an integer")
+					continue
+				}
+				if len(args) >= 4 {
+					if cfg.MaxCount, err = strconv.Atoi(args[3]); err != nil {
+						fmt.Println("Max count must be an integer")
+						continue
+					}
+				}
+			}
+			updated := *service
+			if err := updated.SetResponseHeaderLimits(cfg); err != nil {
+				fmt.Printf("Error setting header limits: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting header limits: %v\n", err)
+				continue
+			}
+			if cfg == nil {
+				fmt.Printf("Response header limits removed from %s\n", args[1])
+			} else {
+				fmt.Printf("Response headers of %s limited to %d bytes and %d fields (0 is no limit)\n", args[1], cfg.MaxBytes, cfg.MaxCount)
+			}
+
+		case "dictionary":
+			if len(args) != 3 && len(args) != 4 {
+				fmt.Println("Usage: dictionary <path> <match> [max-size]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			cfg := DictionaryConfig{Match: args[2]}
+			if len(args) == 4 {
+				n, err := strconv.ParseInt(args[3], 10, 64)
+				if err != nil {
+					fmt.Println("Max size must be an integer")
+					continue
+				}
+				cfg.MaxSize = n
+			}
+			updated := *service
+			if err := updated.EnableCompressionDictionary(cfg); err != nil {
+				fmt.Printf("Error enabling compression dictionaries: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error enabling compression dictionaries: %v\n", err)
+				continue
+			}
+			fmt.Printf("Compression dictionaries enabled for %s\n", args[2])
+
+		case "coldstart":
+			if len(args) < 3 || len(args) > 5 {
+				fmt.Println("Usage: coldstart <path> <hold> [wake-url|docker:<container>|systemd:<unit>] [health-path]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			hold, err := time.ParseDuration(args[2])
+			if err != nil {
+				fmt.Println("Hold must be a duration, 0 to disable")
+				continue
+			}
+			cfg := ColdStartConfig{HoldMs: hold.Milliseconds()}
+			if len(args) > 3 {
+				if strings.HasPrefix(args[3], "http://") || strings.HasPrefix(args[3], "https://") {
+					cfg.WakeURL = args[3]
+				} else {
+					cfg.Wake = args[3]
+				}
+			}
+			if len(args) > 4 {
+				cfg.HealthPath = args[4]
+			}
+			updated := *service
+			if err := updated.EnableColdStart(cfg); err != nil {
+				fmt.Printf("Error setting cold start: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting cold start: %v\n", err)
+				continue
+			}
+			if cfg.HoldMs == 0 {
+				fmt.Printf("Cold start holding disabled for %s\n", args[1])
+			} else {
+				fmt.Printf("Requests to %s are held for up to %s while its backend starts\n", args[1], hold)
+			}
+
+		case "suspend":
+			if len(args) != 3 && len(args) != 4 {
+				fmt.Println("Usage: suspend <path> <idle> [stop-url]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			if service.ColdStart == nil {
+				fmt.Println("Enable cold start holding with coldstart first, so requests can wake the backend")
+				continue
+			}
+			idle, err := time.ParseDuration(args[2])
+			if err != nil {
+				fmt.Println("Idle time must be a duration, 0 to disable")
+				continue
+			}
+			cfg := *service.ColdStart
+			cfg.SuspendAfterMs = idle.Milliseconds()
+			if len(args) == 4 {
+				cfg.StopURL = args[3]
+			}
+			updated := *service
+			if err := updated.EnableColdStart(cfg); err != nil {
+				fmt.Printf("Error setting suspension: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting suspension: %v\n", err)
+				continue
+			}
+			if idle == 0 {
+				fmt.Printf("Suspension disabled for %s\n", args[1])
+			} else {
+				fmt.Printf("Backend of %s is suspended after %s without requests\n", args[1], idle)
+			}
+
+		case "watermark":
+			if len(args) != 3 && len(args) != 4 || len(args) == 4 && args[3] != "header" {
+				fmt.Println("Usage: watermark <path> <label|off> [header]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			cfg := WatermarkConfig{Label: args[2], Banner: len(args) == 3}
+			if cfg.Label == "off" {
+				cfg.Label = ""
+			}
+			updated := *service
+			if err := updated.EnableWatermark(cfg); err != nil {
+				fmt.Printf("Error setting watermark: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting watermark: %v\n", err)
+				continue
+			}
+			if cfg.Label == "" {
+				fmt.Printf("Watermark removed from %s\n", args[1])
+			} else {
+				fmt.Printf("Responses of %s are marked as %s\n", args[1], cfg.Label)
+			}
+
+		case "cache":
+			if len(args) < 3 {
+				fmt.Println("Usage: cache <path> <ttl|off> [header:<name>|cookie:<name>|query:<name>|ignore:<name> ...]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			var cfg CacheConfig
+			if args[2] != "off" {
+				ttl, err := time.ParseDuration(args[2])
+				if err != nil || ttl < time.Millisecond {
+					fmt.Println("TTL must be a duration such as 30s or 5m")
+					continue
+				}
+				cfg.TTLMs = ttl.Milliseconds()
+				if cfg.Key, err = cacheKeyArgs(args[3:]); err != nil {
+					fmt.Printf("Error setting cache: %v\n", err)
+					continue
+				}
+			}
+			updated := *service
+			if err := updated.EnableCache(cfg); err != nil {
+				fmt.Printf("Error setting cache: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting cache: %v\n", err)
+				continue
+			}
+			if updated.Cache == nil {
+				fmt.Printf("Cache disabled for %s\n", args[1])
+			} else {
+				fmt.Printf("Responses of %s are cached for %s\n", args[1], args[2])
+			}
+
+		case "purge":
+			if len(args) < 2 {
+				fmt.Println("Usage: purge <service|*> [key:<key>|prefix:<path> ...]")
+				continue
+			}
+			var req PurgeRequest
+			if args[1] != "*" {
+				req.Service = args[1]
+			}
+			valid := true
+			for _, arg := range args[2:] {
+				kind, value, _ := strings.Cut(arg, ":")
+				switch {
+				case kind == "key" && value != "":
+					req.Keys = append(req.Keys, value)
+				case kind == "prefix" && value != "":
+					req.Prefix = value
+				default:
+					fmt.Printf("Invalid purge selector %q\n", arg)
+					valid = false
+				}
+			}
+			if !valid {
+				continue
+			}
+			res, err := ph.Purge(req)
+			if err != nil {
+				fmt.Printf("Error purging cache: %v\n", err)
+				continue
+			}
+			fmt.Printf("Purged %d cached responses\n", res.Purged)
+
+		case "check":
+			if len(args) < 4 {
+				fmt.Println("Usage: check <path> <name> <url|off> [status] [interval] [body...]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			var checks []CheckConfig
+			for _, c := range service.Checks {
+				if c.Name != args[2] {
+					checks = append(checks, c)
+				}
+			}
+			if args[3] != "off" {
+				c := CheckConfig{Name: args[2], URL: args[3]}
+				var err error
+				if len(args) > 4 {
+					if c.Status, err = strconv.Atoi(args[4]); err != nil {
+						fmt.Println("Status must be an integer")
+						continue
+					}
+				}
+				if len(args) > 5 {
+					interval, err := time.ParseDuration(args[5])
+					if err != nil {
+						fmt.Println("Interval must be a duration")
+						continue
+					}
+					c.IntervalMs = interval.Milliseconds()
+				}
+				c.Body = strings.Join(args[min(len(args), 6):], " ")
+				checks = append(checks, c)
+			}
+			updated := *service
+			if err := updated.SetChecks(checks); err != nil {
+				fmt.Printf("Error setting check: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting check: %v\n", err)
+				continue
+			}
+			fmt.Printf("Checks of %s updated\n", args[1])
+
+		case "region":
+			if len(args) < 4 || args[3] != "off" && len(args) != 5 {
+				fmt.Println("Usage: region <path> <name> <url|off> [country:XX,lang:xx,...]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			var regions []RegionRoute
+			for _, rr := range service.Regions {
+				if rr.Name != args[2] {
+					regions = append(regions, rr)
+				}
+			}
+			if args[3] != "off" {
+				rr := RegionRoute{Name: args[2], URL: args[3]}
+				valid := true
+				for _, match := range strings.Split(args[4], ",") {
+					if country, ok := strings.CutPrefix(match, "country:"); ok {
+						rr.Countries = append(rr.Countries, country)
+					} else if lang, ok := strings.CutPrefix(match, "lang:"); ok {
+						rr.Languages = append(rr.Languages, lang)
+					} else {
+						fmt.Printf("Invalid match %q, expected country:XX or lang:xx\n", match)
+						valid = false
+					}
+				}
+				if !valid {
+					continue
+				}
+				regions = append(regions, rr)
+			}
+			updated := *service
+			if err := updated.SetRegions(regions); err != nil {
+				fmt.Printf("Error setting region: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting region: %v\n", err)
+				continue
+			}
+			fmt.Printf("Regions of %s updated\n", args[1])
+
+		case "middleware":
+			if len(args) != 3 {
+				fmt.Println("Usage: middleware <path> <name,name,...|default|none>")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			var names []string
+			if args[2] != "default" {
+				names = strings.Split(args[2], ",")
+			}
+			updated := *service
+			if err := updated.SetMiddleware(names); err != nil {
+				fmt.Printf("Error setting middleware: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting middleware: %v\n", err)
+				continue
+			}
+			fmt.Printf("Middleware of %s updated\n", args[1])
+
+		case "credentials":
+			if len(args) < 3 || args[2] != "off" && len(args) != 4 && len(args) != 5 {
+				fmt.Println("Usage: credentials <path> <bearer|basic|off> [secret] [refresh]")
+				continue
+			}
+			ph.RLock()
+			service := ph.proxyServers[args[1]]
+			ph.RUnlock()
+			if service == nil {
+				fmt.Printf("No service found at path %s\n", args[1])
+				continue
+			}
+			var cfg *UpstreamAuthConfig
+			if args[2] != "off" {
+				cfg = &UpstreamAuthConfig{Type: args[2], Secret: args[3]}
+				if len(args) == 5 {
+					refresh, err := time.ParseDuration(args[4])
+					if err != nil {
+						fmt.Printf("Invalid refresh interval: %v\n", err)
+						continue
+					}
+					cfg.RefreshMs = refresh.Milliseconds()
+				}
+			}
+			updated := *service
+			if err := updated.EnableUpstreamAuth(cfg); err != nil {
+				fmt.Printf("Error setting credentials: %v\n", err)
+				continue
+			}
+			if err := ph.AddProxy(&updated); err != nil {
+				fmt.Printf("Error setting credentials: %v\n", err)
+				continue
+			}
+			if cfg == nil {
+				fmt.Printf("Upstream credentials removed from %s\n", args[1])
+			} else {
+				fmt.Printf("Requests to %s now carry %s credentials from %s\n", args[1], cfg.Type, cfg.Secret)
+			}
+
 		case "remove":
 			if len(args) != 2 {
 				fmt.Println("Usage: remove <path>")
@@ -151,11 +1403,40 @@ func (ph *RuntimeMux) CLI() {
 		case "list":
 			ph.PrintPaths()
 
+		case "changelog":
+			if len(args) != 2 {
+				fmt.Println("Usage: changelog <name>")
+				continue
+			}
+			for _, c := range ph.Changelog(args[1]) {
+				fmt.Printf("%d %s %s %s (%s)\n", c.Revision, c.Time.Format(time.RFC3339), c.Op, c.Path, c.Reason)
+			}
+
+		case "rollback":
+			if len(args) > 2 {
+				fmt.Println("Usage: rollback [revision]")
+				continue
+			}
+			id := -1
+			if len(args) == 2 {
+				var err error
+				if id, err = strconv.Atoi(args[1]); err != nil {
+					fmt.Println("Revision must be an integer")
+					continue
+				}
+			}
+			rev, err := ph.Rollback(id)
+			if err != nil {
+				fmt.Printf("Error rolling back: %v\n", err)
+				continue
+			}
+			fmt.Printf("Restored %d services (%s)\n", len(rev.Services), rev.Reason)
+
 		case "exit":
 			return
 
 		default:
-			fmt.Println("Unknown command. Available commands: add, remove, list, exit")
+			fmt.Println("Unknown command. Available commands: add, graphql, log, capacity, timeout, sessions, source, affinity, compat, debug, timing, sticky, websocket, hosts, upstreams, annotate, header, headerlimit, dictionary, coldstart, suspend, watermark, cache, purge, check, region, middleware, credentials, remove, list, changelog, rollback, exit")
 		}
 	}
 }
\ No newline at end of file
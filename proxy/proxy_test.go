@@ -1,11 +1,16 @@
 package proxy
 
 import (
+	"bufio"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/websocket"
 )
 
 func TestNewRuntimeMux(t *testing.T) {
@@ -185,4 +190,83 @@ func TestRuntimeMuxRace(t *testing.T) {
 	}()
 	
 	wg.Wait()
-}
\ No newline at end of file
+}
+func TestServiceUseReplacesByName(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Tag")))
+	}))
+	defer backend.Close()
+
+	tag := func(value string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.Header.Add("X-Tag", value)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	service, _ := NewService("test", "/test/", backend.URL)
+	service.Use("tag", tag("first"))
+	copied := *service
+	copied.Use("tag", tag("second"))
+
+	for s, want := range map[*Service]string{service: "first", &copied: "second"} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/test/", nil))
+		if w.Body.String() != want {
+			t.Errorf("Expected %s, got %s", want, w.Body.String())
+		}
+	}
+}
+
+func upgradeThrough(t *testing.T, server *httptest.Server, path string) (net.Conn, *bufio.Reader, int) {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("Failed to read upgrade response: %v", err)
+	}
+	return conn, br, res.StatusCode
+}
+
+func TestRemoveClosesWebSockets(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, _ := http.NewResponseController(w).Hijack()
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		brw.Flush()
+		io.Copy(io.Discard, brw)
+	}))
+	defer backend.Close()
+
+	mux := NewRuntimeMux()
+	service, _ := NewService("ws", "/ws", backend.URL)
+	service.EnableWebSocketLimits(websocket.Config{MaxConns: 1})
+	mux.AddProxy(service)
+	front := httptest.NewServer(mux.GetMux())
+	defer front.Close()
+
+	conn, br, status := upgradeThrough(t, front, "/ws")
+	defer conn.Close()
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected upgrade, got %d", status)
+	}
+	if _, _, status := upgradeThrough(t, front, "/ws"); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected the cap to refuse a second upgrade, got %d", status)
+	}
+
+	mux.removeHandler(service)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame := make([]byte, 4)
+	if _, err := io.ReadFull(br, frame); err != nil {
+		t.Fatalf("Expected a close frame: %v", err)
+	}
+	if frame[0] != 0x88 || int(frame[2])<<8|int(frame[3]) != websocket.CloseGoingAway {
+		t.Errorf("Unexpected frame % x", frame)
+	}
+}
@@ -0,0 +1,100 @@
+// Package proxytest provides fake backends for tests of code routing
+// requests through the proxy package.
+package proxytest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// BackendHeader is set on the default responses of a FakeBackend to its
+// name, so tests can tell which backend answered.
+const BackendHeader = "X-Backend"
+
+// Request is a request received by a FakeBackend.
+type Request struct {
+	Method string
+	Host   string
+	// URI is the request URI as sent by the proxy, with any query.
+	URI    string
+	Header http.Header
+	Body   []byte
+}
+
+// FakeBackend is an HTTP server recording the requests it receives. Unless
+// Handle is called it answers 200 with its name as the body.
+type FakeBackend struct {
+	*httptest.Server
+	Name string
+
+	mu       sync.Mutex
+	requests []Request
+	handler  http.Handler
+}
+
+// NewFakeBackend starts a FakeBackend, closed when the test ends.
+func NewFakeBackend(t testing.TB, name string) *FakeBackend {
+	b := &FakeBackend{Name: name}
+	b.Server = httptest.NewServer(http.HandlerFunc(b.serve))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func (b *FakeBackend) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	b.mu.Lock()
+	b.requests = append(b.requests, Request{
+		Method: r.Method,
+		Host:   r.Host,
+		URI:    r.RequestURI,
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+	handler := b.handler
+	b.mu.Unlock()
+
+	if handler != nil {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set(BackendHeader, b.Name)
+	io.WriteString(w, b.Name)
+}
+
+// Handle makes h answer the requests from now on. Requests are still
+// recorded.
+func (b *FakeBackend) Handle(h http.Handler) {
+	b.mu.Lock()
+	b.handler = h
+	b.mu.Unlock()
+}
+
+// Requests returns the requests received so far, oldest first.
+func (b *FakeBackend) Requests() []Request {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Request(nil), b.requests...)
+}
+
+// LastRequest returns the most recent request, reporting false if there
+// was none.
+func (b *FakeBackend) LastRequest() (Request, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.requests) == 0 {
+		return Request{}, false
+	}
+	return b.requests[len(b.requests)-1], true
+}
+
+// Reset forgets the requests received so far.
+func (b *FakeBackend) Reset() {
+	b.mu.Lock()
+	b.requests = nil
+	b.mu.Unlock()
+}
@@ -0,0 +1,41 @@
+package proxytest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/proxy"
+	"github.com/kirtansoni/reverse-proxy-go/proxy/proxytest"
+)
+
+func TestFakeBackend(t *testing.T) {
+	backend := proxytest.NewFakeBackend(t, "api")
+	mux := proxy.NewRuntimeMux()
+	service, _ := proxy.NewService("api", "/api/", backend.URL)
+	mux.AddProxy(service)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/items?page=2", strings.NewReader("hello")))
+	if w.Body.String() != "api" || w.Header().Get(proxytest.BackendHeader) != "api" {
+		t.Errorf("Unexpected response %q from %q", w.Body.String(), w.Header().Get(proxytest.BackendHeader))
+	}
+	req, ok := backend.LastRequest()
+	if !ok || req.Method != "POST" || req.URI != "/api/items?page=2" || string(req.Body) != "hello" {
+		t.Errorf("Unexpected recorded request: %+v", req)
+	}
+
+	backend.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/", nil))
+	if w.Code != http.StatusTeapot || len(backend.Requests()) != 2 {
+		t.Errorf("Expected the custom handler to answer and the request to be recorded, got %d", w.Code)
+	}
+	backend.Reset()
+	if _, ok := backend.LastRequest(); ok {
+		t.Error("Expected no requests after Reset")
+	}
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"github.com/kirtansoni/reverse-proxy-go/secrets"
+)
+
+// PurgeRequest selects cached responses to drop. Keys and Prefix may be
+// combined, dropping responses matching either; with neither, every
+// response of Service is dropped.
+type PurgeRequest struct {
+	// Service limits the purge to the service of that name; empty means
+	// every cached service.
+	Service string `json:"service,omitempty"`
+	// Keys are surrogate keys; see SurrogateKeyHeader.
+	Keys []string `json:"keys,omitempty"`
+	// Prefix matches the escaped path of cached requests, e.g. /app/users/.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// PurgeResult reports what a purge dropped.
+type PurgeResult struct {
+	Purged int `json:"purged"`
+}
+
+// Purge drops the cached responses req selects.
+func (ph *RuntimeMux) Purge(req PurgeRequest) (PurgeResult, error) {
+	if req.Service == "" && len(req.Keys) == 0 && req.Prefix == "" {
+		return PurgeResult{}, errors.New("purge needs a service, keys or a prefix")
+	}
+	if req.Prefix != "" && !strings.HasPrefix(req.Prefix, "/") {
+		return PurgeResult{}, fmt.Errorf("purge prefix %q must start with /", req.Prefix)
+	}
+	ph.RLock()
+	var caches []*responseCache
+	found := false
+	for _, service := range ph.proxyServers {
+		if service == nil || req.Service != "" && service.Name != req.Service {
+			continue
+		}
+		found = true
+		if service.cache != nil && !slices.Contains(caches, service.cache) {
+			caches = append(caches, service.cache)
+		}
+	}
+	ph.RUnlock()
+	if req.Service != "" && !found {
+		return PurgeResult{}, fmt.Errorf("no service named %s", req.Service)
+	}
+
+	all := len(req.Keys) == 0 && req.Prefix == ""
+	match := func(key string, e *cacheEntry) bool {
+		if all {
+			return true
+		}
+		if req.Prefix != "" {
+			if i := strings.IndexByte(key, '/'); i >= 0 && strings.HasPrefix(key[i:], req.Prefix) {
+				return true
+			}
+		}
+		for _, k := range e.keys {
+			if slices.Contains(req.Keys, k) {
+				return true
+			}
+		}
+		return false
+	}
+	var res PurgeResult
+	for _, c := range caches {
+		res.Purged += c.purge(match)
+	}
+	return res, nil
+}
+
+// PurgeHandler serves POST / with a PurgeRequest as the body, answering
+// with a PurgeResult. A non-nil token makes it a webhook for backends,
+// which must send the token as a bearer Authorization header.
+func (ph *RuntimeMux) PurgeHandler(token *secrets.Cache) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /{$}", func(w http.ResponseWriter, r *http.Request) {
+		if token != nil {
+			want, err := token.Get(r.Context())
+			if err != nil {
+				log.Printf("Cache purge token unavailable: %v", err)
+				admin.WriteError(w, http.StatusServiceUnavailable, errors.New("purge token unavailable"))
+				return
+			}
+			got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+				admin.WriteError(w, http.StatusUnauthorized, errors.New("invalid purge token"))
+				return
+			}
+		}
+		var req PurgeRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid purge request: %v", err))
+			return
+		}
+		res, err := ph.Purge(req)
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Printf("Purged %d cached responses (service=%q keys=%q prefix=%q)", res.Purged, req.Service, req.Keys, req.Prefix)
+		admin.WriteJSON(w, http.StatusOK, res)
+	})
+	return mux
+}
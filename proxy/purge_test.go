@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/secrets"
+)
+
+func TestPurge(t *testing.T) {
+	calls := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if strings.HasPrefix(r.URL.Path, "/app/users/") {
+			w.Header().Set(SurrogateKeyHeader, "users "+strings.TrimPrefix(r.URL.Path, "/app/users/"))
+		}
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer backend.Close()
+
+	mux := NewRuntimeMux()
+	service, _ := NewService("app", "/app/", backend.URL)
+	if err := service.EnableCache(CacheConfig{TTLMs: 60000}); err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(service)
+	proxy := httptest.NewServer(service)
+	defer proxy.Close()
+
+	get := func(path string) string {
+		res, err := http.Get(proxy.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.Header.Get(SurrogateKeyHeader) != "" {
+			t.Errorf("Surrogate keys of %s reached the client", path)
+		}
+		return res.Header.Get(CacheHeader)
+	}
+	warm := func() {
+		for _, path := range []string{"/app/users/1", "/app/users/2", "/app/docs/a", "/app/docs/b"} {
+			get(path)
+		}
+	}
+	warm()
+	if calls != 4 || get("/app/users/1") != "HIT" {
+		t.Fatalf("Expected the responses to be cached, got %d calls", calls)
+	}
+
+	for _, tc := range []struct {
+		req    PurgeRequest
+		purged int
+	}{
+		{PurgeRequest{Keys: []string{"2"}}, 1},
+		{PurgeRequest{Keys: []string{"users"}}, 2},
+		{PurgeRequest{Prefix: "/app/docs/"}, 2},
+		{PurgeRequest{Service: "app", Keys: []string{"1"}, Prefix: "/app/docs/a"}, 2},
+		{PurgeRequest{Service: "app"}, 4},
+	} {
+		warm()
+		mux.Purge(PurgeRequest{Service: "app"})
+		warm()
+		res, err := mux.Purge(tc.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Purged != tc.purged {
+			t.Errorf("Purge %+v: expected %d purged, got %d", tc.req, tc.purged, res.Purged)
+		}
+	}
+	if get("/app/users/1") != "MISS" {
+		t.Error("Expected a miss after purging the service")
+	}
+	if _, err := mux.Purge(PurgeRequest{}); err == nil {
+		t.Error("Expected an empty purge to be rejected")
+	}
+	if _, err := mux.Purge(PurgeRequest{Service: "missing"}); err == nil {
+		t.Error("Expected an unknown service to be rejected")
+	}
+
+	token := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(token, []byte("s3cret\n"), 0o600)
+	source, _ := secrets.Parse("file:" + token)
+	h := mux.PurgeHandler(secrets.NewCache(source, 0))
+	for _, tc := range []struct {
+		auth   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{"keys": ["users"]}`))
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("Authorization %q: expected %d, got %d", tc.auth, tc.status, w.Code)
+		}
+		if w.Code == http.StatusOK {
+			var res PurgeResult
+			json.Unmarshal(w.Body.Bytes(), &res)
+			if res.Purged != 1 {
+				t.Errorf("Expected the webhook to purge 1 response, got %s", w.Body.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RegionCookie set to a region name, or "default", routes the requests
+	// of a tester's browser to that region whatever its language or
+	// country.
+	RegionCookie = "proxy_region"
+	// RegionHeader tells clients which region served a request.
+	RegionHeader = "X-Proxy-Region"
+	// DefaultCountryHeader is the header CDNs such as Cloudflare put the
+	// client's country in.
+	DefaultCountryHeader = "CF-IPCountry"
+)
+
+// euCountries are the members of the European Union, matched by "EU".
+var euCountries = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "HR": true, "CY": true, "CZ": true, "DK": true,
+	"EE": true, "FI": true, "FR": true, "DE": true, "GR": true, "HU": true, "IE": true,
+	"IT": true, "LV": true, "LT": true, "LU": true, "MT": true, "NL": true, "PL": true,
+	"PT": true, "RO": true, "SK": true, "SI": true, "ES": true, "SE": true,
+}
+
+// RegionRoute sends requests from some countries, or preferring some
+// languages, to a region-specific backend instead of the service URL.
+type RegionRoute struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Countries are ISO 3166-1 alpha-2 codes such as "DE", or "EU" for
+	// every member of the European Union.
+	Countries []string `json:"countries,omitempty"`
+	// Languages are primary language subtags such as "de".
+	Languages []string `json:"languages,omitempty"`
+}
+
+func (rr RegionRoute) hasCountry(country string) bool {
+	for _, c := range rr.Countries {
+		if c == country || c == "EU" && euCountries[country] {
+			return true
+		}
+	}
+	return false
+}
+
+func (rr RegionRoute) hasLanguage(lang string) bool {
+	for _, l := range rr.Languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRegions replaces the service's region routes. Requests matching none
+// of them go to the service URL.
+func (s *Service) SetRegions(routes []RegionRoute) error {
+	names := make(map[string]bool, len(routes))
+	normalized := make([]RegionRoute, 0, len(routes))
+	for _, rr := range routes {
+		if rr.Name == "" || rr.Name == "default" || strings.ContainsAny(rr.Name, "/ ;,\"") {
+			return fmt.Errorf("invalid region name %q", rr.Name)
+		}
+		if names[rr.Name] {
+			return fmt.Errorf("duplicate region %s", rr.Name)
+		}
+		names[rr.Name] = true
+		if _, err := parseHTTPURL(rr.URL); err != nil {
+			return fmt.Errorf("region %s: %v", rr.Name, err)
+		}
+		if len(rr.Countries) == 0 && len(rr.Languages) == 0 {
+			return fmt.Errorf("region %s: at least one country or language is required", rr.Name)
+		}
+		countries := make([]string, len(rr.Countries))
+		for i, c := range rr.Countries {
+			countries[i] = strings.ToUpper(c)
+			if len(c) != 2 {
+				return fmt.Errorf("region %s: invalid country %q, expected a two-letter code", rr.Name, c)
+			}
+		}
+		languages := make([]string, len(rr.Languages))
+		for i, l := range rr.Languages {
+			languages[i] = strings.ToLower(l)
+			if l == "" || strings.Contains(l, "-") {
+				return fmt.Errorf("region %s: invalid language %q, expected a primary tag such as de", rr.Name, l)
+			}
+		}
+		rr.Countries, rr.Languages = countries, languages
+		normalized = append(normalized, rr)
+	}
+	if len(normalized) == 0 {
+		normalized = nil
+	}
+	s.Regions = normalized
+	return nil
+}
+
+// region picks the region route for r: the one named by RegionCookie, else
+// the first listing the client's country, else the one for the language
+// the client prefers most. It returns nil for the service URL.
+func (s *Service) region(r *http.Request, countryHeader string) *RegionRoute {
+	if c, err := r.Cookie(RegionCookie); err == nil {
+		for i := range s.Regions {
+			if s.Regions[i].Name == c.Value {
+				return &s.Regions[i]
+			}
+		}
+		if c.Value == "default" {
+			return nil
+		}
+	}
+	if country := strings.ToUpper(strings.TrimSpace(r.Header.Get(countryHeader))); country != "" {
+		for i := range s.Regions {
+			if s.Regions[i].hasCountry(country) {
+				return &s.Regions[i]
+			}
+		}
+	}
+	for _, lang := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+		for i := range s.Regions {
+			if s.Regions[i].hasLanguage(lang) {
+				return &s.Regions[i]
+			}
+		}
+	}
+	return nil
+}
+
+// acceptedLanguages returns the primary subtags of an Accept-Language
+// header, most preferred first, leaving out refused ones (q=0).
+func acceptedLanguages(header string) []string {
+	type accepted struct {
+		lang string
+		q    float64
+	}
+	var langs []accepted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if primary == "" || primary == "*" || q <= 0 {
+			continue
+		}
+		langs = append(langs, accepted{primary, q})
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	primaries := make([]string, len(langs))
+	for i, l := range langs {
+		primaries[i] = l.lang
+	}
+	return primaries
+}
+
+// withRegion returns r carrying the backend of its region, if the service
+// has region routes, and marks the response as varying with what chose it.
+func (ph *RuntimeMux) withRegion(service *Service, w http.ResponseWriter, r *http.Request) *http.Request {
+	if len(service.Regions) == 0 || service.backend != nil {
+		return r
+	}
+	countryHeader := ph.countryHeader()
+	w.Header().Add("Vary", "Accept-Language, "+countryHeader+", Cookie")
+	rr := service.region(r, countryHeader)
+	if rr == nil {
+		w.Header().Set(RegionHeader, "default")
+		return r
+	}
+	w.Header().Set(RegionHeader, rr.Name)
+	u, _ := url.Parse(rr.URL)
+	return r.WithContext(context.WithValue(r.Context(), targetKey{}, u))
+}
+
+// countryHeader returns the request header holding the client's country.
+func (ph *RuntimeMux) countryHeader() string {
+	if ph.CountryHeader == "" {
+		return DefaultCountryHeader
+	}
+	return ph.CountryHeader
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/proxy/proxytest"
+)
+
+func TestAcceptedLanguages(t *testing.T) {
+	got := acceptedLanguages("fr-CH, fr;q=0.9, en;q=0.8, de;q=0.95, *;q=0.5, it;q=0")
+	if want := []string{"fr", "de", "fr", "en"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v", got, want)
+	}
+}
+
+func TestRegionRouting(t *testing.T) {
+	us := proxytest.NewFakeBackend(t, "us")
+	eu := proxytest.NewFakeBackend(t, "eu")
+	jp := proxytest.NewFakeBackend(t, "jp")
+	mux := NewRuntimeMux()
+	service, _ := NewService("shop", "/shop/", us.URL)
+	if err := service.SetRegions([]RegionRoute{{Name: "eu", URL: eu.URL}}); err == nil {
+		t.Error("Expected a region without countries or languages to be rejected")
+	}
+	if err := service.SetRegions([]RegionRoute{
+		{Name: "eu", URL: eu.URL, Countries: []string{"eu", "CH"}},
+		{Name: "jp", URL: jp.URL, Languages: []string{"JA"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(service)
+
+	for _, tc := range []struct {
+		country, lang, cookie, want string
+	}{
+		{"", "", "", "us"},
+		{"DE", "", "", "eu"},
+		{"ch", "ja", "", "eu"},
+		{"US", "en-US, ja;q=0.5", "", "jp"},
+		{"US", "en-US, fr;q=0.5", "", "us"},
+		{"DE", "", "jp", "jp"},
+		{"DE", "", "default", "us"},
+	} {
+		r := httptest.NewRequest("GET", "/shop/", nil)
+		if tc.country != "" {
+			r.Header.Set(DefaultCountryHeader, tc.country)
+		}
+		if tc.lang != "" {
+			r.Header.Set("Accept-Language", tc.lang)
+		}
+		if tc.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: RegionCookie, Value: tc.cookie})
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		if w.Body.String() != tc.want {
+			t.Errorf("%+v: served by %q", tc, w.Body.String())
+		}
+		if region := w.Header().Get(RegionHeader); region != strings.Replace(tc.want, "us", "default", 1) {
+			t.Errorf("%+v: unexpected region header %q", tc, region)
+		}
+		if !strings.Contains(w.Header().Get("Vary"), "Accept-Language") {
+			t.Errorf("%+v: expected the response to vary with the language", tc)
+		}
+	}
+}
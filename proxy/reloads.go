@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"github.com/kirtansoni/reverse-proxy-go/events"
+)
+
+// maxReloads is how many reload outcomes are kept.
+const maxReloads = 50
+
+// Sources of a reload.
+const (
+	ReloadAPI     = "api"
+	ReloadRestore = "restore"
+	ReloadStore   = "store"
+)
+
+// Reload is the outcome of reconciling the route table to a State.
+type Reload struct {
+	Time time.Time `json:"time"`
+	// Source is what asked for the reload: ReloadAPI, ReloadRestore or
+	// ReloadStore.
+	Source     string  `json:"source"`
+	Added      int     `json:"added"`
+	Changed    int     `json:"changed"`
+	Removed    int     `json:"removed"`
+	DurationMs float64 `json:"duration_ms"`
+	// Revision is the revision created, 0 when nothing changed.
+	Revision int `json:"revision,omitempty"`
+	// Error is why the State was rejected, e.g. a service failing
+	// validation; nothing is applied then.
+	Error string `json:"error,omitempty"`
+}
+
+// reloads counts the reloads of a RuntimeMux for its metrics.
+type reloads struct {
+	mu     sync.Mutex
+	recent []Reload
+	// results counts reloads by result: applied, unchanged or failed.
+	results map[string]int64
+	// routes counts routes by op: added, changed or removed.
+	routes   map[string]int64
+	duration time.Duration
+}
+
+// reconcile is Reconcile recording the outcome of applying, as asked for by
+// source. A state found stale against base is not recorded, as it is
+// retried.
+func (ph *RuntimeMux) reconcile(state State, dryRun bool, source string, base int) (Plan, error) {
+	start := time.Now()
+	plan, err := ph.apply(state, dryRun, base)
+	if dryRun || err == errStaleState {
+		return plan, err
+	}
+	rel := Reload{Time: start, Source: source, Revision: plan.Revision, DurationMs: float64(time.Since(start).Microseconds()) / 1000}
+	for _, c := range plan.Changes {
+		switch c.Op {
+		case "added":
+			rel.Added++
+		case "changed":
+			rel.Changed++
+		case "removed":
+			rel.Removed++
+		}
+	}
+	if err != nil {
+		rel.Error = err.Error()
+		log.Printf("Config reload from %s rejected after %.1fms: %v", source, rel.DurationMs, err)
+	} else {
+		log.Printf("Config reload from %s: %d added, %d changed, %d removed in %.1fms", source, rel.Added, rel.Changed, rel.Removed, rel.DurationMs)
+	}
+	ph.reloads.record(rel)
+	ph.Events.Publish(events.Event{Type: events.ConfigReloaded, Time: start, Subject: source, Data: rel})
+	return plan, err
+}
+
+func (r *reloads) record(rel Reload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.results == nil {
+		r.results = make(map[string]int64)
+		r.routes = make(map[string]int64)
+	}
+	r.recent = append(r.recent, rel)
+	if len(r.recent) > maxReloads {
+		r.recent = r.recent[len(r.recent)-maxReloads:]
+	}
+	switch {
+	case rel.Error != "":
+		r.results["failed"]++
+	case rel.Revision == 0:
+		r.results["unchanged"]++
+	default:
+		r.results["applied"]++
+	}
+	r.routes["added"] += int64(rel.Added)
+	r.routes["changed"] += int64(rel.Changed)
+	r.routes["removed"] += int64(rel.Removed)
+	r.duration += time.Duration(rel.DurationMs * float64(time.Millisecond))
+}
+
+// Reloads returns the last reloads, oldest first.
+func (ph *RuntimeMux) Reloads() []Reload {
+	ph.reloads.mu.Lock()
+	defer ph.reloads.mu.Unlock()
+	return append([]Reload{}, ph.reloads.recent...)
+}
+
+// reloadHandlers adds the reload endpoints to the history handler.
+func (ph *RuntimeMux) reloadHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /reloads", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, ph.Reloads())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		rl := &ph.reloads
+		rl.mu.Lock()
+		var last Reload
+		if len(rl.recent) > 0 {
+			last = rl.recent[len(rl.recent)-1]
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "# HELP proxy_config_reloads_total Route table reloads by result.\n# TYPE proxy_config_reloads_total counter\n")
+		var count int64
+		for _, result := range []string{"applied", "unchanged", "failed"} {
+			fmt.Fprintf(&b, "proxy_config_reloads_total{result=%q} %d\n", result, rl.results[result])
+			count += rl.results[result]
+		}
+		fmt.Fprintf(&b, "# HELP proxy_config_reload_routes_total Routes added, changed or removed by reloads.\n# TYPE proxy_config_reload_routes_total counter\n")
+		for _, op := range []string{"added", "changed", "removed"} {
+			fmt.Fprintf(&b, "proxy_config_reload_routes_total{op=%q} %d\n", op, rl.routes[op])
+		}
+		fmt.Fprintf(&b, "# HELP proxy_config_reload_duration_seconds Time taken to validate and apply route tables.\n# TYPE proxy_config_reload_duration_seconds summary\n")
+		fmt.Fprintf(&b, "proxy_config_reload_duration_seconds_sum %g\n", rl.duration.Seconds())
+		fmt.Fprintf(&b, "proxy_config_reload_duration_seconds_count %d\n", count)
+		rl.mu.Unlock()
+		if !last.Time.IsZero() {
+			success := 1
+			if last.Error != "" {
+				success = 0
+			}
+			fmt.Fprintf(&b, "# HELP proxy_config_last_reload_success Whether the last reload was applied.\n# TYPE proxy_config_last_reload_success gauge\n")
+			fmt.Fprintf(&b, "proxy_config_last_reload_success %d\n", success)
+			fmt.Fprintf(&b, "# HELP proxy_config_last_reload_timestamp_seconds When the last reload happened.\n# TYPE proxy_config_last_reload_timestamp_seconds gauge\n")
+			fmt.Fprintf(&b, "proxy_config_last_reload_timestamp_seconds %d\n", last.Time.Unix())
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(b.String()))
+	})
+}
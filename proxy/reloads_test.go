@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/events"
+)
+
+func TestReloads(t *testing.T) {
+	mux := NewRuntimeMux()
+	sub := mux.Events.Subscribe(0, events.ConfigReloaded)
+	defer sub.Close()
+
+	api := &Service{Name: "api", Path: "/api/", Url: "http://127.0.0.1:9001"}
+	docs := &Service{Name: "docs", Path: "/docs/", Url: "http://127.0.0.1:9002"}
+	if _, err := mux.Reconcile(State{Services: []*Service{api, docs}}, false); err != nil {
+		t.Fatal(err)
+	}
+	changed := &Service{Name: "api", Path: "/api/", Url: "http://127.0.0.1:9003"}
+	if _, err := mux.Reconcile(State{Services: []*Service{changed}}, false); err != nil {
+		t.Fatal(err)
+	}
+	mux.Reconcile(State{Services: []*Service{changed}}, false)
+	mux.Reconcile(State{Services: []*Service{changed}}, true)
+	invalid := &Service{Name: "bad", Path: "/bad/", Url: "http://127.0.0.1:9004", Cache: &CacheConfig{TTLMs: -1}}
+	if _, err := mux.Reconcile(State{Services: []*Service{invalid}}, false); err == nil {
+		t.Fatal("Expected an invalid service to be rejected")
+	}
+
+	reloads := mux.Reloads()
+	if len(reloads) != 4 {
+		t.Fatalf("Expected 4 reloads besides the dry run, got %+v", reloads)
+	}
+	if r := reloads[0]; r.Added != 2 || r.Revision == 0 || r.Source != ReloadAPI || r.Error != "" {
+		t.Errorf("Unexpected first reload: %+v", r)
+	}
+	if r := reloads[1]; r.Changed != 1 || r.Removed != 1 || r.Added != 0 {
+		t.Errorf("Unexpected second reload: %+v", r)
+	}
+	if r := reloads[2]; r.Revision != 0 || r.Changed != 0 {
+		t.Errorf("Expected an unchanged reload, got %+v", r)
+	}
+	if r := reloads[3]; !strings.Contains(r.Error, "service bad") {
+		t.Errorf("Expected the failing service in the error, got %+v", r)
+	}
+	if len(sub.C) != 4 {
+		t.Errorf("Expected 4 reload events, got %d", len(sub.C))
+	}
+
+	w := httptest.NewRecorder()
+	mux.AdminHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`proxy_config_reloads_total{result="applied"} 2`,
+		`proxy_config_reloads_total{result="unchanged"} 1`,
+		`proxy_config_reloads_total{result="failed"} 1`,
+		`proxy_config_reload_routes_total{op="added"} 2`,
+		"proxy_config_reload_duration_seconds_count 4\n",
+		"proxy_config_last_reload_success 0\n",
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("Expected %q in metrics:\n%s", want, w.Body.String())
+		}
+	}
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", w.Code)
+	}
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+type connKey struct{}
+
+// RequestTracker keeps a table of in-flight requests so that a stuck or
+// abusive one can be cancelled, or its connection closed, from the admin API.
+type RequestTracker struct {
+	mu     sync.Mutex
+	nextID uint64
+	active map[uint64]*activeRequest
+}
+
+type activeRequest struct {
+	ActiveRequest
+	cancel context.CancelFunc
+	conn   net.Conn
+}
+
+// ActiveRequest describes an in-flight request.
+type ActiveRequest struct {
+	ID         uint64    `json:"id"`
+	Method     string    `json:"method"`
+	Host       string    `json:"host"`
+	Path       string    `json:"path"`
+	RemoteAddr string    `json:"remote_addr"`
+	Proto      string    `json:"proto"`
+	Start      time.Time `json:"start"`
+	Duration   string    `json:"duration"`
+}
+
+func NewRequestTracker() *RequestTracker {
+	return &RequestTracker{active: make(map[uint64]*activeRequest)}
+}
+
+// ConnContext records the downstream connection of each request. It is meant
+// to be installed as http.Server.ConnContext.
+func (t *RequestTracker) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+func (t *RequestTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		req := &activeRequest{
+			ActiveRequest: ActiveRequest{
+				Method:     r.Method,
+				Host:       r.Host,
+				Path:       r.URL.Path,
+				RemoteAddr: r.RemoteAddr,
+				Proto:      r.Proto,
+				Start:      time.Now(),
+			},
+			cancel: cancel,
+		}
+		req.conn, _ = r.Context().Value(connKey{}).(net.Conn)
+
+		t.mu.Lock()
+		t.nextID++
+		req.ID = t.nextID
+		t.active[req.ID] = req
+		t.mu.Unlock()
+
+		defer func() {
+			t.mu.Lock()
+			delete(t.active, req.ID)
+			t.mu.Unlock()
+		}()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Requests returns the in-flight requests, oldest first.
+func (t *RequestTracker) Requests() []ActiveRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	list := make([]ActiveRequest, 0, len(t.active))
+	for _, req := range t.active {
+		snap := req.ActiveRequest
+		snap.Duration = now.Sub(snap.Start).Round(time.Millisecond).String()
+		list = append(list, snap)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Served returns the number of requests seen so far, including in-flight
+// ones.
+func (t *RequestTracker) Served() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return int64(t.nextID)
+}
+
+// Cancel cancels the context of a request, which aborts its upstream call.
+// It reports whether the request was found.
+func (t *RequestTracker) Cancel(id uint64) bool {
+	t.mu.Lock()
+	req, ok := t.active[id]
+	t.mu.Unlock()
+	if ok {
+		req.cancel()
+	}
+	return ok
+}
+
+// CloseConn closes the downstream connection of a request. With HTTP/2 this
+// also ends every other request on the connection.
+func (t *RequestTracker) CloseConn(id uint64) error {
+	t.mu.Lock()
+	req, ok := t.active[id]
+	t.mu.Unlock()
+	if !ok {
+		return errNoRequest
+	}
+	if req.conn == nil {
+		return errors.New("connection of request is not tracked")
+	}
+	req.cancel()
+	return req.conn.Close()
+}
+
+var errNoRequest = errors.New("no such request")
+
+func (t *RequestTracker) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, t.Requests())
+	})
+	mux.HandleFunc("DELETE /{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !t.Cancel(id) {
+			admin.WriteError(w, http.StatusNotFound, errNoRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /{id}/conn", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		switch err := t.CloseConn(id); {
+		case errors.Is(err, errNoRequest):
+			admin.WriteError(w, http.StatusNotFound, err)
+		case err != nil && !errors.Is(err, net.ErrClosed):
+			admin.WriteError(w, http.StatusInternalServerError, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	return mux
+}
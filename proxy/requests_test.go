@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestTracker(t *testing.T) {
+	tracker := NewRequestTracker()
+	started := make(chan struct{}, 2)
+	server := httptest.NewUnstartedServer(tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+	})))
+	server.Config.ConnContext = tracker.ConnContext
+	server.Start()
+	defer server.Close()
+
+	admin := tracker.AdminHandler()
+	errs := make(chan error, 2)
+	for _, path := range []string{"/cancel", "/close"} {
+		go func() {
+			client := &http.Client{Transport: &http.Transport{}}
+			res, err := client.Get(server.URL + path)
+			if err == nil {
+				res.Body.Close()
+			}
+			errs <- err
+		}()
+		<-started
+	}
+
+	requests := tracker.Requests()
+	if len(requests) != 2 || requests[0].Path != "/cancel" || requests[1].Path != "/close" {
+		t.Fatalf("Unexpected requests: %+v", requests)
+	}
+
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("DELETE", "/1", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected cancel to succeed, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("DELETE", "/2/conn", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected close to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-errs:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Request was not terminated")
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(tracker.Requests()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(tracker.Requests()); n != 0 {
+		t.Errorf("Expected no requests left, got %d", n)
+	}
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("DELETE", "/1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for finished request, got %d", w.Code)
+	}
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// AnnotationVersion is the annotation read by the {version} variable of
+// response header templates.
+const AnnotationVersion = "version"
+
+// RequestIDHeader carries the request ID used by {request_id}. Requests
+// without one get a generated ID, which is also passed to the backend.
+const RequestIDHeader = "X-Request-Id"
+
+// responseHeaderVars are the variables of response header templates.
+var responseHeaderVars = map[string]bool{
+	"request_id": true,
+	"service":    true,
+	"path":       true,
+	"upstream":   true,
+	"version":    true,
+}
+
+// SetResponseHeader adds a header to the service's responses, or removes it
+// if template is empty. The template may refer to {request_id}, {service},
+// {path}, {upstream} (the backend host) and {version} (the AnnotationVersion
+// annotation), e.g. "projects{path}@{version}". The header replaces any sent
+// by the backend.
+func (s *Service) SetResponseHeader(name, template string) error {
+	if name == "" || strings.ContainsAny(name, " :\r\n") {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if _, err := expandHeader(template, nil); err != nil {
+		return err
+	}
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	headers := make(map[string]string, len(s.ResponseHeaders)+1)
+	for k, v := range s.ResponseHeaders {
+		headers[k] = v
+	}
+	if template == "" {
+		delete(headers, name)
+	} else {
+		headers[name] = template
+	}
+	if len(headers) == 0 {
+		headers = nil
+	}
+	s.ResponseHeaders = headers
+	return nil
+}
+
+// expandHeader replaces the {variables} of template with their values. With
+// nil vars it only checks the template.
+func expandHeader(template string, vars map[string]string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable in %q", template)
+		}
+		name := template[start+1 : start+end]
+		if !responseHeaderVars[name] {
+			return "", fmt.Errorf("unknown variable {%s}", name)
+		}
+		b.WriteString(template[:start])
+		b.WriteString(vars[name])
+		template = template[start+end+1:]
+	}
+	b.WriteString(template)
+	if strings.ContainsAny(b.String(), "\r\n") {
+		return "", fmt.Errorf("header value must not contain line breaks")
+	}
+	return b.String(), nil
+}
+
+// withResponseHeaders returns w set up to add the service's response
+// headers, and r carrying a request ID if the headers need one.
+func (s *Service) withResponseHeaders(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	if len(s.ResponseHeaders) == 0 {
+		return w, r
+	}
+	upstream := s.Url
+	if u, err := url.Parse(s.Url); err == nil {
+		upstream = u.Host
+	}
+	vars := map[string]string{
+		"service":  s.Name,
+		"path":     strings.TrimSuffix(s.Path, "/"),
+		"upstream": upstream,
+		"version":  s.Annotations[AnnotationVersion],
+	}
+	for _, template := range s.ResponseHeaders {
+		if strings.Contains(template, "{request_id}") {
+			id := r.Header.Get(RequestIDHeader)
+			if id == "" {
+				id = newRequestID()
+				r = r.Clone(r.Context())
+				r.Header.Set(RequestIDHeader, id)
+			}
+			vars["request_id"] = id
+			break
+		}
+	}
+
+	headers := make(map[string]string, len(s.ResponseHeaders))
+	for name, template := range s.ResponseHeaders {
+		// Templates were checked when set.
+		headers[name], _ = expandHeader(template, vars)
+	}
+	return &headerWriter{ResponseWriter: w, headers: headers}, r
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// headerWriter sets headers just before the response header is written, so
+// they replace those copied from the backend.
+type headerWriter struct {
+	http.ResponseWriter
+	headers map[string]string
+	wrote   bool
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	if !w.wrote && code >= 200 {
+		w.wrote = true
+		for name, value := range w.headers {
+			w.Header().Set(name, value)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *headerWriter) Flush() {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/proxy/proxytest"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	backend := proxytest.NewFakeBackend(t, "wordsweave")
+	backend.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Served-By", "backend")
+	}))
+
+	mux := NewRuntimeMux()
+	service, _ := NewService("wordsweave", "/wordsweave/", backend.URL)
+	if err := service.SetResponseHeader("X-Served-By", "{nope}"); err == nil {
+		t.Error("Expected an unknown variable to be rejected")
+	}
+	service.SetAnnotation(AnnotationVersion, "v2")
+	service.SetResponseHeader("x-served-by", "projects{path}@{version}")
+	service.SetResponseHeader("X-Trace", "{request_id}")
+	mux.AddProxy(service)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/wordsweave/", nil))
+	if got := w.Header().Values("X-Served-By"); len(got) != 1 || got[0] != "projects/wordsweave@v2" {
+		t.Errorf("Unexpected X-Served-By: %v", got)
+	}
+	last, _ := backend.LastRequest()
+	if id, gotID := w.Header().Get("X-Trace"), last.Header.Get(RequestIDHeader); id == "" || id != gotID {
+		t.Errorf("Expected the generated request ID %q to reach the backend, got %q", id, gotID)
+	}
+
+	r := httptest.NewRequest("GET", "/wordsweave/", nil)
+	r.Header.Set(RequestIDHeader, "abc")
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if id := w.Header().Get("X-Trace"); id != "abc" {
+		t.Errorf("Expected the client's request ID, got %q", id)
+	}
+
+	updated := *service
+	updated.SetResponseHeader("X-Trace", "")
+	if len(updated.ResponseHeaders) != 1 || len(service.ResponseHeaders) != 2 {
+		t.Error("Removing a header from a copy must not change the original")
+	}
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"runtime"
+	"sort"
+)
+
+// RouteStats shows whether removed routes are being freed. Handlers
+// should settle at Live once the garbage collector has run; a Handlers
+// count that keeps growing with route churn is a leak.
+type RouteStats struct {
+	// Live is the number of paths routed to a service.
+	Live int `json:"live"`
+	// Registered is the number of patterns in the route table: the live
+	// routes plus removed paths answered by FallbackHandler.
+	Registered int `json:"registered"`
+	// Retired are the removed paths answered by FallbackHandler.
+	Retired []string `json:"retired"`
+	// Handlers is the number of route handlers created and not yet
+	// garbage collected.
+	Handlers int64 `json:"handlers"`
+	// Rebuilds is the number of times the route table was rebuilt to drop
+	// removed routes.
+	Rebuilds int64 `json:"rebuilds"`
+}
+
+// routeHandler is the handler of one path. It is only reachable from the
+// route table, so it is freed together with the stats, WebSocket guard and
+// service it holds once its path is removed and the table rebuilt.
+type routeHandler struct {
+	http.Handler
+}
+
+// newRouteHandler wraps h, counting it in RouteStats.Handlers until it is
+// garbage collected.
+func (ph *RuntimeMux) newRouteHandler(h http.Handler) *routeHandler {
+	route := &routeHandler{h}
+	ph.handlers.Add(1)
+	runtime.SetFinalizer(route, func(*routeHandler) { ph.handlers.Add(-1) })
+	return route
+}
+
+// dispatch serves a request from the current route table.
+func (ph *RuntimeMux) dispatch(w http.ResponseWriter, r *http.Request) {
+	ph.table.Load().ServeHTTP(w, r)
+}
+
+// rebuildTable replaces the route table with one holding only the live
+// routes, since a ServeMux cannot unregister a pattern. Removed paths keep
+// answering with FallbackHandler, which holds nothing of their service.
+// Callers must hold ph's lock.
+func (ph *RuntimeMux) rebuildTable() {
+	table := http.NewServeMux()
+	for path, route := range ph.routes {
+		table.Handle(path, route)
+	}
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ph.FallbackHandler.ServeHTTP(w, r)
+	})
+	for path := range ph.retired {
+		table.Handle(path, fallback)
+	}
+	ph.table.Store(table)
+	ph.rebuilds.Add(1)
+}
+
+// Routes reports the live and registered routes.
+func (ph *RuntimeMux) Routes() RouteStats {
+	ph.RLock()
+	defer ph.RUnlock()
+	stats := RouteStats{
+		Live:       len(ph.routes),
+		Registered: len(ph.routes) + len(ph.retired),
+		Retired:    []string{},
+		Handlers:   ph.handlers.Load(),
+		Rebuilds:   ph.rebuilds.Load(),
+	}
+	for path := range ph.retired {
+		stats.Retired = append(stats.Retired, path)
+	}
+	sort.Strings(stats.Retired)
+	return stats
+}
+
+// closeIdleConnections closes the pooled upstream connections of the
+// service; requests in flight are not affected.
+func (s *Service) closeIdleConnections() {
+	if s.ReverseProxy == nil {
+		return
+	}
+	if c, ok := s.ReverseProxy.Transport.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
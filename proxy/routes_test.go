@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRemovedRoutesAreFreed(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }))
+	defer backend.Close()
+
+	mux := NewRuntimeMux()
+	mux.WebSocketGrace = 0
+	keep, _ := NewService("keep", "/keep/", backend.URL)
+	mux.AddProxy(keep)
+	for i := 0; i < 50; i++ {
+		service, _ := NewService(fmt.Sprintf("churn%d", i), fmt.Sprintf("/churn%d/", i), backend.URL)
+		mux.AddProxy(service)
+		mux.GetMux().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", service.Path, nil))
+		mux.removeHandler(service)
+	}
+	// A removed path can be routed again.
+	again, _ := NewService("again", "/churn0/", backend.URL)
+	mux.AddProxy(again)
+
+	stats := mux.Routes()
+	if stats.Live != 2 || stats.Registered != 51 || len(stats.Retired) != 49 {
+		t.Errorf("Unexpected route stats %+v", stats)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mux.Routes().Handlers != 2 && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if handlers := mux.Routes().Handlers; handlers != 2 {
+		t.Errorf("Expected removed route handlers to be collected, %d remain", handlers)
+	}
+
+	for path, want := range map[string]string{"/keep/": "ok", "/churn0/": "ok", "/churn1/": "fallback: Path not found/churn1/"} {
+		w := httptest.NewRecorder()
+		mux.GetMux().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Body.String() != want {
+			t.Errorf("%s: expected %q, got %q", path, want, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	mux.GetMux().ServeHTTP(w, httptest.NewRequest("GET", "/never/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a path never routed, got %d", w.Code)
+	}
+}
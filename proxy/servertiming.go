@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// EnableServerTiming adds a Server-Timing header to the service's responses
+// splitting their time into proxy overhead and time spent waiting for the
+// backend, so browser developer tools show where time goes. Timings the
+// backend sends itself are kept.
+func (s *Service) EnableServerTiming(on bool) {
+	s.ServerTiming = on
+	if !on {
+		s.middlewares = s.without("server-timing")
+		s.rebuild()
+		return
+	}
+	s.useOutermost("server-timing", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tw := &timingWriter{ResponseWriter: w, start: time.Now()}
+			trace := &httptrace.ClientTrace{
+				GetConn: func(string) {
+					tw.mu.Lock()
+					if tw.upstreamStart.IsZero() {
+						tw.upstreamStart = time.Now()
+					}
+					tw.mu.Unlock()
+				},
+				GotFirstResponseByte: func() {
+					tw.mu.Lock()
+					tw.firstByte = time.Now()
+					tw.mu.Unlock()
+				},
+			}
+			next.ServeHTTP(tw, r.WithContext(httptrace.WithClientTrace(r.Context(), trace)))
+		})
+	})
+}
+
+// timingWriter adds the Server-Timing header when the response header is
+// written.
+type timingWriter struct {
+	http.ResponseWriter
+	start time.Time
+
+	mu                       sync.Mutex
+	upstreamStart, firstByte time.Time
+	wroteHeader              bool
+}
+
+func (tw *timingWriter) WriteHeader(code int) {
+	if !tw.wroteHeader && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		tw.wroteHeader = true
+		tw.Header().Add("Server-Timing", tw.value(time.Now()))
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timingWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timingWriter) Flush() {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(tw.ResponseWriter).Flush()
+}
+
+func (tw *timingWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// value returns the timings up to now: proxy and, if the response came from
+// the backend, upstream, from asking for a connection to its first byte.
+func (tw *timingWriter) value(now time.Time) string {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	total := now.Sub(tw.start)
+	if tw.upstreamStart.IsZero() || tw.firstByte.IsZero() {
+		return fmt.Sprintf("proxy;dur=%s;desc=\"Proxy\"", timingDur(total))
+	}
+	upstream := tw.firstByte.Sub(tw.upstreamStart)
+	return fmt.Sprintf("proxy;dur=%s;desc=\"Proxy\", upstream;dur=%s;desc=\"Upstream\"", timingDur(total-upstream), timingDur(upstream))
+}
+
+func timingDur(d time.Duration) string {
+	return fmt.Sprintf("%.1f", float64(d.Microseconds())/1000)
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerTiming(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Server-Timing", "db;dur=12")
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	mux := NewRuntimeMux()
+	service, err := NewServiceFromConfig(&Service{Name: "app", Path: "/app/", Url: backend.URL, ServerTiming: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(service)
+
+	get := func() []string {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/app/", nil))
+		return w.Result().Header.Values("Server-Timing")
+	}
+	timings := get()
+	if len(timings) != 2 || timings[0] != "db;dur=12" {
+		t.Fatalf("Expected the backend timing and the proxy's, got %q", timings)
+	}
+	if !strings.HasPrefix(timings[1], "proxy;dur=") || !strings.Contains(timings[1], ", upstream;dur=") {
+		t.Errorf("Expected proxy and upstream durations, got %q", timings[1])
+	}
+	if strings.Contains(timings[1], "upstream;dur=0.") {
+		t.Errorf("Expected the upstream duration to include the backend's delay, got %q", timings[1])
+	}
+
+	updated := *service
+	updated.EnableServerTiming(false)
+	mux.AddProxy(&updated)
+	if timings := get(); len(timings) != 1 {
+		t.Errorf("Expected only the backend timing once disabled, got %q", timings)
+	}
+}
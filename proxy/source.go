@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+)
+
+// interfaceName is what SourceAddr may be when it is not an IP address.
+var interfaceName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,14}$`)
+
+// SetSourceAddr makes the service's upstream connections leave from a
+// local IP address, or from the first address of a network interface such
+// as eth1, so on hosts with several networks traffic to the backend takes
+// the right one; empty restores the system's choice. Pooled connections
+// are not carried over.
+func (s *Service) SetSourceAddr(source string) error {
+	if source != "" && net.ParseIP(source) == nil && !interfaceName.MatchString(source) {
+		return fmt.Errorf("invalid source %q, expected an IP address or interface name", source)
+	}
+	s.SourceAddr = source
+	s.resetTransport()
+	return nil
+}
+
+// sourceDial dials from source, as SetSourceAddr describes.
+func sourceDial(source string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ip := net.ParseIP(source)
+		if ip == nil {
+			var err error
+			if ip, err = interfaceIP(source); err != nil {
+				return nil, err
+			}
+		}
+		// Only backend addresses of the source's IP version are dialed.
+		d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, LocalAddr: &net.TCPAddr{IP: ip}}
+		return d.DialContext(ctx, network, addr)
+	}
+}
+
+// interfaceIP returns the first IPv4 address of the interface called name,
+// or else its first IPv6 address, looked up on every dial since addresses
+// may change.
+func interfaceIP(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("source interface %s: %v", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("source interface %s: %v", name, err)
+	}
+	var v6 net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip4 := ipnet.IP.To4(); ip4 != nil {
+			return ip4, nil
+		}
+		if v6 == nil {
+			v6 = ipnet.IP
+		}
+	}
+	if v6 == nil {
+		return nil, fmt.Errorf("source interface %s has no address", name)
+	}
+	return v6, nil
+}
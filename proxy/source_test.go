@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSourceAddr(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		w.Write([]byte(host))
+	}))
+	defer backend.Close()
+
+	mux := NewRuntimeMux()
+	get := func() string {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/app/", nil))
+		return w.Body.String()
+	}
+	service, err := NewServiceFromConfig(&Service{Name: "app", Path: "/app/", Url: backend.URL, SourceAddr: "127.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(service)
+	if got := get(); got != "127.0.0.2" {
+		t.Errorf("Expected the connection from 127.0.0.2, got %q", got)
+	}
+
+	updated := *service
+	if err := updated.SetSourceAddr("lo"); err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(&updated)
+	if got := get(); got != "127.0.0.1" {
+		t.Errorf("Expected the connection from the loopback interface, got %q", got)
+	}
+
+	if err := updated.SetSourceAddr("eth0; rm"); err == nil {
+		t.Error("Expected an invalid source to be rejected")
+	}
+}
@@ -0,0 +1,401 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+)
+
+// DefaultStateKey is the key the route table is saved under.
+const DefaultStateKey = "routes.json"
+
+// State is the declarative form of the route table.
+type State struct {
+	Services []*Service `json:"services"`
+}
+
+// Plan is the outcome of reconciling to a State.
+type Plan struct {
+	DryRun  bool     `json:"dry_run"`
+	Changes []Change `json:"changes"`
+	// Revision is the revision created by applying the plan, 0 when nothing
+	// was applied.
+	Revision int `json:"revision,omitempty"`
+	// Probes are the upstream probes of a validated change; see Validate.
+	Probes []Probe `json:"probes,omitempty"`
+}
+
+// NewServiceFromConfig builds a service from its configuration fields, as
+// found in a State or a Revision.
+func NewServiceFromConfig(cfg *Service) (*Service, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("service name is required")
+	}
+	if !strings.HasPrefix(cfg.Path, "/") {
+		return nil, fmt.Errorf("service %s: path must start with /", cfg.Name)
+	}
+	s, err := NewService(cfg.Name, cfg.Path, cfg.Url)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+	}
+	s.MaxConcurrency = cfg.MaxConcurrency
+	if cfg.TLSSessionCacheSize < 0 {
+		return nil, fmt.Errorf("service %s: tls_session_cache_size must not be negative", cfg.Name)
+	}
+	if cfg.TLSSessionCacheSize > 0 {
+		s.SetTLSSessionCacheSize(cfg.TLSSessionCacheSize)
+	}
+	if cfg.SourceAddr != "" {
+		if err := s.SetSourceAddr(cfg.SourceAddr); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	if cfg.ConnectionAffinity {
+		s.EnableConnectionAffinity()
+	}
+	if cfg.Compat != nil {
+		if err := s.EnableCompat(*cfg.Compat); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	s.DebugBackend = cfg.DebugBackend
+	if cfg.ServerTiming {
+		s.EnableServerTiming(true)
+	}
+	if cfg.Sticky != nil {
+		if err := s.EnableSticky(*cfg.Sticky); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	if cfg.AdaptiveTimeout != nil {
+		if t := cfg.AdaptiveTimeout; t.MinMs < 0 || t.MaxMs > 0 && t.MinMs > t.MaxMs {
+			return nil, fmt.Errorf("service %s: adaptive timeout min_ms exceeds max_ms", cfg.Name)
+		}
+		timeout := *cfg.AdaptiveTimeout
+		s.AdaptiveTimeout = &timeout
+	}
+	if cfg.Descriptors != "" {
+		if err := s.EnableTranscoding(cfg.Descriptors); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	if cfg.GraphQL != nil {
+		if err := s.EnableGraphQL(*cfg.GraphQL); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	if cfg.Logging != nil {
+		s.EnableLogging(*cfg.Logging)
+	}
+	if cfg.WebSocket != nil {
+		s.EnableWebSocketLimits(*cfg.WebSocket)
+	}
+	for k, v := range cfg.Annotations {
+		if err := s.SetAnnotation(k, v); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	if err := s.SetHosts(cfg.Hosts); err != nil {
+		return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+	}
+	if cfg.CompressionDictionary != nil {
+		if err := s.EnableCompressionDictionary(*cfg.CompressionDictionary); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	if cfg.ColdStart != nil {
+		if err := s.EnableColdStart(*cfg.ColdStart); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	if cfg.Watermark != nil {
+		if err := s.EnableWatermark(*cfg.Watermark); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	if cfg.Cache != nil {
+		if err := s.EnableCache(*cfg.Cache); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	if err := s.SetChecks(cfg.Checks); err != nil {
+		return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+	}
+	if isUpstreamTemplate(cfg.Url) && len(cfg.UpstreamHosts) == 0 {
+		return nil, fmt.Errorf("service %s: a templated url needs upstream_hosts", cfg.Name)
+	}
+	if err := s.SetUpstreamHosts(cfg.UpstreamHosts); err != nil {
+		return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+	}
+	if err := s.SetRegions(cfg.Regions); err != nil {
+		return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+	}
+	if err := s.SetMiddleware(cfg.Middleware); err != nil {
+		return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+	}
+	if cfg.UpstreamAuth != nil {
+		if err := s.EnableUpstreamAuth(cfg.UpstreamAuth); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	if cfg.ResponseHeaderLimits != nil {
+		if err := s.SetResponseHeaderLimits(cfg.ResponseHeaderLimits); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	for name, template := range cfg.ResponseHeaders {
+		if err := s.SetResponseHeader(name, template); err != nil {
+			return nil, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+	}
+	return s, nil
+}
+
+// State returns the current route table in declarative form.
+func (ph *RuntimeMux) State() State {
+	ph.RLock()
+	defer ph.RUnlock()
+	return ph.state()
+}
+
+// state is State for callers holding ph's lock.
+func (ph *RuntimeMux) state() State {
+	state := State{Services: []*Service{}}
+	for _, service := range ph.proxyServers {
+		if service != nil {
+			state.Services = append(state.Services, service)
+		}
+	}
+	sort.Slice(state.Services, func(i, j int) bool { return state.Services[i].Path < state.Services[j].Path })
+	return state
+}
+
+// Reconcile makes the route table match state, adding, replacing and
+// removing services as needed. Services whose configuration is unchanged
+// keep running untouched. With dryRun the changes are only computed.
+// Applying is recorded as a Reload from ReloadAPI.
+func (ph *RuntimeMux) Reconcile(state State, dryRun bool) (Plan, error) {
+	return ph.reconcile(state, dryRun, ReloadAPI, anyRevision)
+}
+
+// anyRevision lets apply change any route table.
+const anyRevision = -1
+
+// errStaleState is returned by apply when the route table is no longer at
+// the revision the state was derived from.
+var errStaleState = errors.New("the route table changed concurrently")
+
+// apply is Reconcile without recording the reload. Unless base is
+// anyRevision, nothing changes if the latest revision is no longer base.
+func (ph *RuntimeMux) apply(state State, dryRun bool, base int) (Plan, error) {
+	desired := make(map[string]*Service)
+	for _, cfg := range state.Services {
+		if cfg == nil {
+			return Plan{}, errors.New("null service")
+		}
+		if _, dup := desired[cfg.Path]; dup {
+			return Plan{}, fmt.Errorf("path %s is declared twice", cfg.Path)
+		}
+		// Building validates the service, including its descriptor set and
+		// manifest, even on a dry run.
+		service, err := NewServiceFromConfig(cfg)
+		if err != nil {
+			return Plan{}, err
+		}
+		if err := hostConflict(service, desired); err != nil {
+			return Plan{}, err
+		}
+		ph.RLock()
+		c := ph.chain
+		ph.RUnlock()
+		if err := c.check(service); err != nil {
+			return Plan{}, fmt.Errorf("service %s: %v", cfg.Name, err)
+		}
+		desired[cfg.Path] = service
+	}
+
+	ph.Lock()
+	defer ph.Unlock()
+	if base != anyRevision && base != ph.nextRevision {
+		return Plan{}, errStaleState
+	}
+
+	current := make(map[string]*Service)
+	for path, service := range ph.proxyServers {
+		if service != nil {
+			current[path] = service
+		}
+	}
+	plan := Plan{DryRun: dryRun, Changes: diffServices(current, desired)}
+	if dryRun || len(plan.Changes) == 0 {
+		return plan, nil
+	}
+
+	for _, change := range plan.Changes {
+		if change.Op == "removed" {
+			ph.retire(change.Path)
+		} else {
+			ph.install(desired[change.Path])
+		}
+	}
+	ph.changed("apply state")
+	ph.watch()
+	plan.Revision = ph.history[len(ph.history)-1].ID
+	return plan, nil
+}
+
+// changed records a change to the route table, updating the host routes
+// and the state file. Callers must hold ph's lock.
+func (ph *RuntimeMux) changed(reason string) {
+	ph.indexHosts()
+	ph.record(reason)
+	if err := ph.save(); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// save writes the route table to Store. Callers must hold ph's lock.
+func (ph *RuntimeMux) save() error {
+	if ph.Store == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(ph.state(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ph.Store.Put(context.Background(), ph.stateKey(), data); err != nil {
+		return fmt.Errorf("failed to save routes: %v", err)
+	}
+	ph.saved = data
+	return nil
+}
+
+func (ph *RuntimeMux) stateKey() string {
+	if ph.StateKey == "" {
+		return DefaultStateKey
+	}
+	return ph.StateKey
+}
+
+// Restore reconciles the route table to the one saved in Store, reporting
+// false if there is none yet.
+func (ph *RuntimeMux) Restore() (bool, error) {
+	if ph.Store == nil {
+		return false, nil
+	}
+	data, err := ph.Store.Get(context.Background(), ph.stateKey())
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read routes: %v", err)
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return false, fmt.Errorf("failed to parse routes: %v", err)
+	}
+	if _, err := ph.reconcile(state, false, ReloadRestore, anyRevision); err != nil {
+		return false, fmt.Errorf("failed to restore routes: %v", err)
+	}
+	return true, nil
+}
+
+// Follow applies the route tables other instances sharing Store save to it,
+// until ctx is done. A deleted route table leaves the routes as they are.
+func (ph *RuntimeMux) Follow(ctx context.Context) {
+	if ph.Store == nil {
+		return
+	}
+	for data := range ph.Store.Watch(ctx, ph.stateKey()) {
+		ph.RLock()
+		own := bytes.Equal(data, ph.saved)
+		ph.RUnlock()
+		if data == nil || own {
+			continue
+		}
+		var state State
+		if err := json.Unmarshal(data, &state); err != nil {
+			log.Printf("Ignoring routes from the store: failed to parse them: %v", err)
+			continue
+		}
+		if _, err := ph.reconcile(state, false, ReloadStore, anyRevision); err != nil {
+			log.Printf("Ignoring routes from the store: %v", err)
+		}
+	}
+}
+
+// StateHandler serves the declarative route table:
+//
+//	GET /             the current State
+//	PUT /?dry_run=1   reconcile to the State in the body; with dry_run only
+//	                  the plan is returned
+//	PUT /?validate=1&timeout=5s
+//	                  probe new upstreams first, changing nothing unless all
+//	                  of them answer
+func (ph *RuntimeMux) StateHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			admin.WriteJSON(w, http.StatusOK, ph.State())
+		case http.MethodPut:
+			var state State
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&state); err != nil {
+				admin.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid state document: %v", err))
+				return
+			}
+			var probes []Probe
+			if v := r.URL.Query().Get("validate"); v == "1" || v == "true" {
+				timeout := DefaultProbeTimeout
+				if v := r.URL.Query().Get("timeout"); v != "" {
+					d, err := time.ParseDuration(v)
+					if err != nil || d <= 0 {
+						admin.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout %q", v))
+						return
+					}
+					timeout = d
+				}
+				// An invalid document is rejected before any backend is
+				// contacted.
+				if _, err := ph.Reconcile(state, true); err != nil {
+					admin.WriteError(w, http.StatusUnprocessableEntity, err)
+					return
+				}
+				ctx, cancel := context.WithTimeout(r.Context(), timeout)
+				probes = ph.Validate(ctx, state)
+				cancel()
+				for _, p := range probes {
+					if !p.OK {
+						admin.WriteJSON(w, http.StatusUnprocessableEntity, map[string]any{
+							"error":  fmt.Sprintf("upstream %s of service %s failed its probe: %s", p.URL, p.Service, p.Error),
+							"probes": probes,
+						})
+						return
+					}
+				}
+			}
+			dryRun := r.URL.Query().Get("dry_run")
+			plan, err := ph.Reconcile(state, dryRun == "1" || dryRun == "true")
+			if err != nil {
+				admin.WriteError(w, http.StatusUnprocessableEntity, err)
+				return
+			}
+			plan.Probes = probes
+			admin.WriteJSON(w, http.StatusOK, plan)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			admin.WriteError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+	})
+}
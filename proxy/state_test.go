@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+)
+
+func TestReconcile(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }))
+	defer backend.Close()
+
+	mux := NewRuntimeMux()
+	keep, _ := NewService("keep", "/keep/", backend.URL)
+	mux.AddProxy(keep)
+	old, _ := NewService("old", "/old/", backend.URL)
+	mux.AddProxy(old)
+	change, _ := NewService("change", "/change/", backend.URL)
+	mux.AddProxy(change)
+
+	doc := `{"services": [
+		{"name": "keep", "path": "/keep/", "url": "` + backend.URL + `"},
+		{"name": "change", "path": "/change/", "url": "` + backend.URL + `", "max_concurrency": 5},
+		{"name": "new", "path": "/new/", "url": "` + backend.URL + `", "graphql": {"max_depth": 3}}
+	]}`
+	put := func(query string) (int, Plan) {
+		w := httptest.NewRecorder()
+		mux.StateHandler().ServeHTTP(w, httptest.NewRequest("PUT", "/"+query, strings.NewReader(doc)))
+		var plan Plan
+		json.Unmarshal(w.Body.Bytes(), &plan)
+		return w.Code, plan
+	}
+
+	code, plan := put("?dry_run=1")
+	if code != http.StatusOK || !plan.DryRun || plan.Revision != 0 {
+		t.Fatalf("Unexpected dry run: %d %+v", code, plan)
+	}
+	var ops []string
+	for _, c := range plan.Changes {
+		ops = append(ops, c.Op+" "+c.Path)
+	}
+	if got := strings.Join(ops, ", "); got != "changed /change/, added /new/, removed /old/" {
+		t.Errorf("Unexpected plan: %s", got)
+	}
+	if len(mux.State().Services) != 3 || mux.State().Services[2].Path != "/old/" {
+		t.Fatal("Dry run must not change the routes")
+	}
+
+	code, plan = put("")
+	if code != http.StatusOK || plan.Revision == 0 {
+		t.Fatalf("Unexpected apply: %d %+v", code, plan)
+	}
+	state := mux.State()
+	if len(state.Services) != 3 || state.Services[0].MaxConcurrency != 5 || state.Services[2].GraphQL == nil {
+		t.Errorf("Unexpected state after apply: %+v", state.Services)
+	}
+	if state.Services[1] != keep {
+		t.Error("Expected unchanged service to be kept as is")
+	}
+
+	// Applying the same document again is a no-op.
+	if _, plan = put(""); len(plan.Changes) != 0 || plan.Revision != 0 {
+		t.Errorf("Expected no changes, got %+v", plan)
+	}
+
+	w := httptest.NewRecorder()
+	mux.StateHandler().ServeHTTP(w, httptest.NewRequest("PUT", "/", strings.NewReader(`{"services": [{"name": "x", "path": "x", "url": "http://a"}]}`)))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected invalid service to be rejected, got %d", w.Code)
+	}
+}
+
+func TestStateFileRestoresHosts(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.URL.Path)) }))
+	defer backend.Close()
+	store := storage.NewDir(t.TempDir())
+
+	mux := NewRuntimeMux()
+	mux.Store = store
+	if restored, err := mux.Restore(); restored || err != nil {
+		t.Fatalf("Expected nothing to restore, got %v %v", restored, err)
+	}
+	service, _ := NewService("app", "/app/", backend.URL)
+	if err := service.SetHosts([]string{"App.Example.com.", "app.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(service)
+
+	restarted := NewRuntimeMux()
+	restarted.Store = store
+	if restored, err := restarted.Restore(); !restored || err != nil {
+		t.Fatalf("Expected routes to be restored, got %v %v", restored, err)
+	}
+	w := httptest.NewRecorder()
+	restarted.ServeHTTP(w, httptest.NewRequest("GET", "http://app.example.com:443/some/page", nil))
+	if w.Body.String() != "/some/page" {
+		t.Errorf("Expected the host to be routed with its path, got %q", w.Body.String())
+	}
+	if !restarted.ServesHost("APP.example.com") || restarted.ServesHost("other.example.com") {
+		t.Error("Unexpected host routes")
+	}
+
+	other, _ := NewService("other", "/other/", backend.URL)
+	other.SetHosts([]string{"app.example.com"})
+	if err := restarted.AddProxy(other); err == nil {
+		t.Error("Expected a host claimed by another route to be rejected")
+	}
+}
+
+func TestFollowSharedStore(t *testing.T) {
+	store := storage.NewMemory()
+	a, b := NewRuntimeMux(), NewRuntimeMux()
+	a.Store, b.Store = store, store
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Follow(ctx)
+	go b.Follow(ctx)
+	time.Sleep(10 * time.Millisecond)
+
+	service, _ := NewService("app", "/app/", "http://127.0.0.1:9")
+	a.AddProxy(service)
+	deadline := time.Now().Add(time.Second)
+	for len(b.State().Services) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s := b.State().Services; len(s) != 1 || s[0].Name != "app" {
+		t.Fatalf("Expected the route added by the other instance, got %+v", s)
+	}
+	// Saving the applied table again must not bounce back to a.
+	time.Sleep(20 * time.Millisecond)
+	if len(a.Revisions()) != len(b.Revisions()) {
+		t.Errorf("Expected the change to be applied once, got %d and %d revisions", len(a.Revisions()), len(b.Revisions()))
+	}
+}
@@ -0,0 +1,544 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+)
+
+const (
+	// DefaultStickyCookie carries a client's session ID when StickyConfig
+	// names no cookie.
+	DefaultStickyCookie = "proxy_sticky"
+	// DefaultStickyTTL is how long an idle session stays pinned when
+	// StickyConfig sets no TTL.
+	DefaultStickyTTL = 30 * time.Minute
+	// stickyResolveInterval is how often the backend addresses of a sticky
+	// service are looked up again.
+	stickyResolveInterval = 10 * time.Second
+	// stickySweepInterval is how often expired sessions are dropped.
+	stickySweepInterval = time.Minute
+)
+
+// StickySessions holds the backend every sticky session is pinned to.
+var StickySessions = NewStickyTable()
+
+// StickyConfig pins each client session to one of the addresses the
+// service's host name resolves to, for replicas behind one DNS name that
+// keep session state in memory.
+type StickyConfig struct {
+	// Cookie carries the session ID; empty means DefaultStickyCookie.
+	Cookie string `json:"cookie,omitempty"`
+	// TTLMs is how long a session stays pinned after its last request; 0
+	// means DefaultStickyTTL.
+	TTLMs int64 `json:"ttl_ms,omitempty"`
+}
+
+func (c StickyConfig) cookie() string {
+	if c.Cookie == "" {
+		return DefaultStickyCookie
+	}
+	return c.Cookie
+}
+
+func (c StickyConfig) ttl() time.Duration {
+	if c.TTLMs <= 0 {
+		return DefaultStickyTTL
+	}
+	return time.Duration(c.TTLMs) * time.Millisecond
+}
+
+// EnableSticky pins the sessions of the service's clients to a backend
+// address, identified by a cookie the proxy sets on their first response.
+// A session whose address no longer resolves is moved to another one.
+func (s *Service) EnableSticky(cfg StickyConfig) error {
+	switch {
+	case isUpstreamTemplate(s.Url):
+		return errors.New("sticky sessions need a fixed upstream url")
+	case s.ConnectionAffinity:
+		return errors.New("connection affinity already pins requests, so it cannot be combined with sticky sessions")
+	case s.Compat != nil && s.Compat.HTTP10:
+		return errors.New("HTTP/1.0 compatibility cannot be combined with sticky sessions")
+	case cfg.TTLMs < 0:
+		return errors.New("sticky ttl_ms must not be negative")
+	case cfg.Cookie != "" && !validCookieName(cfg.Cookie):
+		return fmt.Errorf("invalid sticky cookie name %q", cfg.Cookie)
+	}
+	s.Sticky = &cfg
+	s.resetTransport()
+	cookie := cfg.cookie()
+	s.Use("sticky", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var id string
+			if c, err := r.Cookie(cookie); err == nil && validStickyID(c.Value) {
+				id = c.Value
+			} else {
+				id = newStickyID()
+				http.SetCookie(w, &http.Cookie{
+					Name:     cookie,
+					Value:    id,
+					Path:     "/",
+					HttpOnly: true,
+					Secure:   r.TLS != nil,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), stickyKey{}, id)))
+		})
+	})
+	return nil
+}
+
+// DisableSticky stops pinning sessions. Existing assignments expire on
+// their own.
+func (s *Service) DisableSticky() {
+	s.Sticky = nil
+	s.middlewares = s.without("sticky")
+	s.resetTransport()
+}
+
+func validCookieName(name string) bool {
+	return (&http.Cookie{Name: name, Value: "x"}).Valid() == nil
+}
+
+type stickyKey struct{}
+
+func newStickyID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validStickyID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// stickyTransport sends the requests of each session through a transport
+// dialing only the session's backend address, so pooled connections to
+// other replicas are never reused for it.
+type stickyTransport struct {
+	service string
+	// host is the host and port of the service URL; requests sent
+	// elsewhere, e.g. by an override, are not pinned.
+	host string
+	port string
+	ttl  time.Duration
+	base *http.Transport
+
+	mu       sync.Mutex
+	pinned   map[string]*http.Transport
+	addrs    []string
+	resolved time.Time
+	next     int
+}
+
+func newStickyTransport(s *Service, base *http.Transport) *stickyTransport {
+	st := &stickyTransport{service: s.Name, ttl: s.Sticky.ttl(), base: base, pinned: make(map[string]*http.Transport)}
+	if u, err := url.Parse(s.Url); err == nil {
+		st.host = u.Host
+		st.port = u.Port()
+		if st.port == "" {
+			st.port = "80"
+			if u.Scheme == "https" {
+				st.port = "443"
+			}
+		}
+	}
+	return st
+}
+
+func (st *stickyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	id, ok := r.Context().Value(stickyKey{}).(string)
+	if !ok || r.URL.Host != st.host {
+		return st.base.RoundTrip(r)
+	}
+	addrs, err := st.resolve(r.Context(), r.URL.Hostname())
+	if err != nil {
+		// The shared transport reports the lookup failure.
+		return st.base.RoundTrip(r)
+	}
+	addr := StickySessions.pin(r.Context(), st.service, id, st.ttl, addrs, st.pick)
+	return st.transport(addr).RoundTrip(r)
+}
+
+// resolve returns the backend addresses of host, looked up at most every
+// stickyResolveInterval.
+func (st *stickyTransport) resolve(ctx context.Context, host string) ([]string, error) {
+	st.mu.Lock()
+	if st.addrs != nil && time.Since(st.resolved) < stickyResolveInterval {
+		addrs := st.addrs
+		st.mu.Unlock()
+		return addrs, nil
+	}
+	st.mu.Unlock()
+
+	var ips []string
+	if ip := net.ParseIP(host); ip != nil || UpstreamMesh != nil && UpstreamMesh.Routes(host) {
+		// Mesh hosts are resolved by the mesh.
+		ips = []string{host}
+	} else {
+		var err error
+		if ips, err = net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, st.port)
+	}
+	sort.Strings(addrs)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.addrs, st.resolved = addrs, time.Now()
+	for addr, t := range st.pinned {
+		if !slices.Contains(addrs, addr) {
+			t.CloseIdleConnections()
+			delete(st.pinned, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// pick returns the address for a new session, taking turns.
+func (st *stickyTransport) pick(addrs []string) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.next++
+	return addrs[st.next%len(addrs)]
+}
+
+func (st *stickyTransport) transport(addr string) *http.Transport {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	t := st.pinned[addr]
+	if t == nil {
+		t = st.base.Clone()
+		dial := st.base.DialContext
+		t.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		}
+		st.pinned[addr] = t
+	}
+	return t
+}
+
+func (st *stickyTransport) CloseIdleConnections() {
+	st.base.CloseIdleConnections()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, t := range st.pinned {
+		t.CloseIdleConnections()
+	}
+}
+
+// StickyTable holds session assignments with their expiry. With a Store,
+// assignments are also kept there, so they survive restarts and are shared
+// by proxies using the same store.
+type StickyTable struct {
+	Store storage.Store
+
+	mu        sync.Mutex
+	sessions  map[string]*stickySession
+	services  map[string]*stickyCounters
+	lastSweep time.Time
+	sweeping  atomic.Bool
+}
+
+// StickySession is the backend address a session is pinned to.
+type StickySession struct {
+	Service string    `json:"service,omitempty"`
+	Session string    `json:"session,omitempty"`
+	Addr    string    `json:"addr"`
+	Expires time.Time `json:"expires"`
+}
+
+type stickySession struct {
+	StickySession
+	// saved is when the assignment was last written to the store.
+	saved time.Time
+}
+
+type stickyCounters struct {
+	hits, misses, rebalances atomic.Int64
+}
+
+func NewStickyTable() *StickyTable {
+	return &StickyTable{sessions: make(map[string]*stickySession), services: make(map[string]*stickyCounters)}
+}
+
+func stickyStoreKey(service, id string) string {
+	return url.PathEscape(service) + "/" + id
+}
+
+// pin returns the address session id of service is pinned to, pinning it
+// to pick(addrs) if it is new or its address is no longer in addrs.
+func (t *StickyTable) pin(ctx context.Context, service, id string, ttl time.Duration, addrs []string, pick func([]string) string) string {
+	now := time.Now()
+	key := stickyStoreKey(service, id)
+	session := t.lookup(ctx, key, now)
+
+	t.mu.Lock()
+	counters := t.counters(service)
+	switch {
+	case session != nil && slices.Contains(addrs, session.Addr):
+		counters.hits.Add(1)
+	case session != nil:
+		counters.rebalances.Add(1)
+		previous := session.Addr
+		session = &stickySession{StickySession: StickySession{Service: service, Session: id, Addr: pick(addrs)}}
+		log.Printf("Sticky session of %s moved from %s to %s", service, previous, session.Addr)
+	default:
+		counters.misses.Add(1)
+		session = &stickySession{StickySession: StickySession{Service: service, Session: id, Addr: pick(addrs)}}
+	}
+	session.Expires = now.Add(ttl)
+	t.sessions[key] = session
+	// Refreshing the expiry in the store on every request would write
+	// on every request; a quarter of the TTL late is close enough.
+	save := t.Store != nil && now.Sub(session.saved) >= ttl/4
+	if save {
+		session.saved = now
+	}
+	stored := session.StickySession
+	t.mu.Unlock()
+
+	if save {
+		data, _ := json.Marshal(stored)
+		if err := t.Store.Put(ctx, key, data); err != nil {
+			log.Printf("Failed to save sticky session of %s: %v", service, err)
+		}
+	}
+	t.sweep(now)
+	return stored.Addr
+}
+
+// lookup returns the unexpired session stored under key, from memory or
+// else from the store.
+func (t *StickyTable) lookup(ctx context.Context, key string, now time.Time) *stickySession {
+	t.mu.Lock()
+	session := t.sessions[key]
+	store := t.Store
+	t.mu.Unlock()
+	if session != nil && now.Before(session.Expires) {
+		return session
+	}
+	if store == nil {
+		return nil
+	}
+	data, err := store.Get(ctx, key)
+	if err != nil {
+		return nil
+	}
+	var stored StickySession
+	if json.Unmarshal(data, &stored) != nil || !now.Before(stored.Expires) {
+		return nil
+	}
+	return &stickySession{StickySession: stored, saved: now}
+}
+
+// counters returns the counters of service. Callers must hold t.mu.
+func (t *StickyTable) counters(service string) *stickyCounters {
+	c := t.services[service]
+	if c == nil {
+		c = &stickyCounters{}
+		t.services[service] = c
+	}
+	return c
+}
+
+// sweep drops expired sessions every stickySweepInterval, from the store
+// in the background.
+func (t *StickyTable) sweep(now time.Time) {
+	t.mu.Lock()
+	if now.Sub(t.lastSweep) < stickySweepInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.lastSweep = now
+	for key, s := range t.sessions {
+		if !now.Before(s.Expires) {
+			delete(t.sessions, key)
+		}
+	}
+	store := t.Store
+	t.mu.Unlock()
+	if store == nil || !t.sweeping.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer t.sweeping.Store(false)
+		t.flushStore(context.Background(), "", func(s StickySession) bool { return !now.Before(s.Expires) })
+	}()
+}
+
+// flushStore deletes the stored sessions under prefix that match.
+func (t *StickyTable) flushStore(ctx context.Context, prefix string, match func(StickySession) bool) error {
+	entries, err := t.Store.List(ctx, prefix)
+	if err != nil {
+		return fmt.Errorf("failed to list sticky sessions: %v", err)
+	}
+	for _, e := range entries {
+		data, err := t.Store.Get(ctx, e.Key)
+		if err != nil {
+			continue
+		}
+		var s StickySession
+		if json.Unmarshal(data, &s) == nil && !match(s) {
+			continue
+		}
+		if err := t.Store.Delete(ctx, e.Key); err != nil {
+			return fmt.Errorf("failed to delete sticky session: %v", err)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the unexpired session id of service.
+func (t *StickyTable) Lookup(ctx context.Context, service, id string) (StickySession, bool) {
+	session := t.lookup(ctx, stickyStoreKey(service, id), time.Now())
+	if session == nil {
+		return StickySession{}, false
+	}
+	return session.StickySession, true
+}
+
+// Flush forgets session id of service, or every session of service if id
+// is empty, so their next requests are pinned afresh.
+func (t *StickyTable) Flush(ctx context.Context, service, id string) error {
+	prefix := url.PathEscape(service) + "/"
+	t.mu.Lock()
+	for key := range t.sessions {
+		if id == "" && strings.HasPrefix(key, prefix) || key == prefix+id {
+			delete(t.sessions, key)
+		}
+	}
+	store := t.Store
+	t.mu.Unlock()
+	if store == nil {
+		return nil
+	}
+	if id != "" {
+		if err := store.Delete(ctx, prefix+id); err != nil {
+			return fmt.Errorf("failed to delete sticky session: %v", err)
+		}
+		return nil
+	}
+	return t.flushStore(ctx, prefix, func(StickySession) bool { return true })
+}
+
+// StickyServiceStats counts the sticky sessions of a service. Sessions
+// counts those this proxy has seen and not expired.
+type StickyServiceStats struct {
+	Service  string `json:"service"`
+	Sessions int    `json:"sessions"`
+	// Hits are requests sent to the backend their session was pinned to,
+	// Misses requests of new sessions, and Rebalances sessions moved
+	// because their backend no longer resolved.
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	Rebalances int64   `json:"rebalances"`
+	HitRate    float64 `json:"hit_rate"`
+}
+
+// Stats returns the counts of every service with sticky sessions, sorted
+// by name.
+func (t *StickyTable) Stats() []StickyServiceStats {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sessions := make(map[string]int)
+	for _, s := range t.sessions {
+		if now.Before(s.Expires) {
+			sessions[s.Service]++
+		}
+	}
+	stats := []StickyServiceStats{}
+	for service, c := range t.services {
+		s := StickyServiceStats{
+			Service:    service,
+			Sessions:   sessions[service],
+			Hits:       c.hits.Load(),
+			Misses:     c.misses.Load(),
+			Rebalances: c.rebalances.Load(),
+		}
+		if total := s.Hits + s.Misses + s.Rebalances; total > 0 {
+			s.HitRate = float64(s.Hits) / float64(total)
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Service < stats[j].Service })
+	return stats
+}
+
+// AdminHandler serves the sticky session table:
+//
+//	GET /                         StickyServiceStats of every service as JSON
+//	GET /metrics                  the same in Prometheus text format
+//	GET /{service}/{session}      the backend the session is pinned to
+//	DELETE /{service}/{session}   forget the session
+//	DELETE /{service}             forget every session of the service
+func (t *StickyTable) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, t.Stats())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		stats := t.Stats()
+		for _, m := range []struct {
+			name, kind, help string
+			value            func(StickyServiceStats) int64
+		}{
+			{"proxy_sticky_sessions", "gauge", "Sticky sessions pinned to a backend.", func(s StickyServiceStats) int64 { return int64(s.Sessions) }},
+			{"proxy_sticky_hits_total", "counter", "Requests sent to the backend their session was pinned to.", func(s StickyServiceStats) int64 { return s.Hits }},
+			{"proxy_sticky_misses_total", "counter", "Requests of sessions pinned for the first time.", func(s StickyServiceStats) int64 { return s.Misses }},
+			{"proxy_sticky_rebalances_total", "counter", "Sessions moved because their backend no longer resolved.", func(s StickyServiceStats) int64 { return s.Rebalances }},
+		} {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+			for _, s := range stats {
+				fmt.Fprintf(&b, "%s{service=%q} %d\n", m.name, s.Service, m.value(s))
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(b.String()))
+	})
+	mux.HandleFunc("GET /{service}/{session}", func(w http.ResponseWriter, r *http.Request) {
+		session, ok := t.Lookup(r.Context(), r.PathValue("service"), r.PathValue("session"))
+		if !ok {
+			admin.WriteError(w, http.StatusNotFound, errors.New("no such session"))
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, session)
+	})
+	flush := func(w http.ResponseWriter, r *http.Request) {
+		if err := t.Flush(r.Context(), r.PathValue("service"), r.PathValue("session")); err != nil {
+			admin.WriteError(w, http.StatusBadGateway, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+	mux.HandleFunc("DELETE /{service}/{session}", flush)
+	mux.HandleFunc("DELETE /{service}", flush)
+	return mux
+}
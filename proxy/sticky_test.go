@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+)
+
+func TestStickySessions(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }))
+	defer backend.Close()
+
+	store := storage.NewMemory()
+	defer func(table *StickyTable) { StickySessions = table }(StickySessions)
+	StickySessions = NewStickyTable()
+	StickySessions.Store = store
+
+	mux := NewRuntimeMux()
+	service, err := NewServiceFromConfig(&Service{Name: "carts", Path: "/carts/", Url: backend.URL, Sticky: &StickyConfig{TTLMs: 60000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(service)
+
+	get := func(cookie *http.Cookie) *http.Response {
+		r := httptest.NewRequest("GET", "/carts/", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Result()
+	}
+	cookies := get(nil).Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DefaultStickyCookie {
+		t.Fatalf("Expected a session cookie, got %v", cookies)
+	}
+	session := cookies[0]
+	if res := get(session); len(res.Cookies()) != 0 || res.StatusCode != http.StatusOK {
+		t.Errorf("Expected the session to be kept, got %d %v", res.StatusCode, res.Cookies())
+	}
+
+	admin := StickySessions.AdminHandler()
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/carts/"+session.Value, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), backend.Listener.Addr().String()) {
+		t.Errorf("Expected the session pinned to the backend, got %d %s", w.Code, w.Body)
+	}
+	// Another proxy sharing the store sees the assignment.
+	if s, ok := (&StickyTable{Store: store, sessions: map[string]*stickySession{}}).Lookup(context.Background(), "carts", session.Value); !ok || s.Addr != backend.Listener.Addr().String() {
+		t.Errorf("Expected the session in the store, got %+v %v", s, ok)
+	}
+
+	// A replica gone from DNS moves the session.
+	StickySessions.mu.Lock()
+	StickySessions.sessions[stickyStoreKey("carts", session.Value)].Addr = "192.0.2.1:80"
+	StickySessions.mu.Unlock()
+	if res := get(session); res.StatusCode != http.StatusOK {
+		t.Errorf("Expected the moved session to reach the backend, got %d", res.StatusCode)
+	}
+	stats := StickySessions.Stats()
+	if len(stats) != 1 || stats[0].Sessions != 1 || stats[0].Hits != 1 || stats[0].Misses != 1 || stats[0].Rebalances != 1 {
+		t.Errorf("Expected 1 session with a hit, miss and rebalance, got %+v", stats)
+	}
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `proxy_sticky_rebalances_total{service="carts"} 1`) {
+		t.Errorf("Expected rebalance metrics, got %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("DELETE", "/carts", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected the sessions flushed, got %d", w.Code)
+	}
+	if _, ok := StickySessions.Lookup(context.Background(), "carts", session.Value); ok {
+		t.Error("Expected the session forgotten")
+	}
+	if entries, _ := store.List(context.Background(), ""); len(entries) != 0 {
+		t.Errorf("Expected the store emptied, got %v", entries)
+	}
+
+	if _, err := NewServiceFromConfig(&Service{Name: "t", Path: "/t/", Url: "http://{header.X-T}.internal", UpstreamHosts: []string{"*.internal"}, Sticky: &StickyConfig{}}); err == nil {
+		t.Error("Expected sticky sessions on a templated url to be rejected")
+	}
+}
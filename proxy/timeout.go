@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/websocket"
+)
+
+// TimeoutConfig sets a service's upstream timeout from its own latency: the
+// p99 of the last minute times Multiplier, kept within [MinMs, MaxMs].
+// Until enough requests have been seen the timeout is MaxMs. A MaxMs of 0
+// sets no upper bound, and no timeout until there is enough to learn from.
+type TimeoutConfig struct {
+	Multiplier float64 `json:"multiplier,omitempty"`
+	MinMs      int64   `json:"min_ms"`
+	MaxMs      int64   `json:"max_ms"`
+}
+
+const (
+	// defaultTimeoutMultiplier applies when TimeoutConfig.Multiplier is 0.
+	defaultTimeoutMultiplier = 3
+	// minTimeoutSamples is how many requests are needed to learn from.
+	minTimeoutSamples = 20
+	// timeoutRefresh is how often the learned timeout is recomputed.
+	timeoutRefresh = time.Second
+)
+
+// learn computes the timeout for a service from the latencies it has seen.
+func (cfg TimeoutConfig) learn(latencies []time.Duration) time.Duration {
+	lo := time.Duration(cfg.MinMs) * time.Millisecond
+	hi := time.Duration(cfg.MaxMs) * time.Millisecond
+	if len(latencies) < minTimeoutSamples {
+		return hi
+	}
+	mult := cfg.Multiplier
+	if mult <= 0 {
+		mult = defaultTimeoutMultiplier
+	}
+	p99 := latencies[(len(latencies)*99-1)/100]
+	d := max(time.Duration(float64(p99)*mult), lo)
+	if hi > 0 {
+		d = min(d, hi)
+	}
+	return d
+}
+
+// timeout returns the learned timeout for a service, recomputing it at most
+// every timeoutRefresh.
+func (l *loadStats) timeout(cfg TimeoutConfig, now time.Time) time.Duration {
+	l.timeoutMu.Lock()
+	defer l.timeoutMu.Unlock()
+	if l.learnedCfg != cfg || now.Sub(l.learnedAt) >= timeoutRefresh {
+		latencies, _ := l.recent(now)
+		l.learned = cfg.learn(latencies)
+		l.learnedCfg = cfg
+		l.learnedAt = now
+	}
+	return l.learned
+}
+
+// withTimeout applies the service's adaptive timeout to r, if it has one.
+// WebSocket upgrades are exempt, as the timeout would end the connection.
+func (l *loadStats) withTimeout(service *Service, r *http.Request) (*http.Request, context.CancelFunc) {
+	if service.AdaptiveTimeout == nil || websocket.IsUpgrade(r) {
+		return r, func() {}
+	}
+	d := l.timeout(*service.AdaptiveTimeout, time.Now())
+	if d <= 0 {
+		return r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), d)
+	return r.WithContext(ctx), cancel
+}
+
+// proxyErrorHandler returns the reverse proxy's default error handler for
+// service, except that errors go to UpstreamErrors, upstream timeouts are
+// reported as such, and backends whose name does not resolve are reported
+// as unavailable until their lookup is retried.
+func proxyErrorHandler(service string) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		var dnsErr *DNSFailureError
+		if errors.As(err, &dnsErr) {
+			if !dnsErr.Cached {
+				UpstreamErrors.Log(service, err)
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(time.Until(dnsErr.Until).Seconds())))))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		UpstreamErrors.Log(service, err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLearnTimeout(t *testing.T) {
+	cfg := TimeoutConfig{MinMs: 100, MaxMs: 2000}
+	latencies := make([]time.Duration, 100)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * time.Millisecond
+	}
+
+	if d := cfg.learn(latencies[:10]); d != 2*time.Second {
+		t.Errorf("Expected the maximum before enough samples, got %v", d)
+	}
+	if d := cfg.learn(latencies); d != 297*time.Millisecond {
+		t.Errorf("Expected p99 x 3, got %v", d)
+	}
+	if d := cfg.learn(latencies[:50]); d != 150*time.Millisecond {
+		t.Errorf("Expected p99 x 3 of the first 50, got %v", d)
+	}
+	cfg.Multiplier = 100
+	if d := cfg.learn(latencies); d != 2*time.Second {
+		t.Errorf("Expected the maximum to bound the timeout, got %v", d)
+	}
+	cfg = TimeoutConfig{MinMs: 500}
+	if d := cfg.learn(latencies); d != 500*time.Millisecond {
+		t.Errorf("Expected the minimum to bound the timeout, got %v", d)
+	}
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("slow") {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer backend.Close()
+
+	mux := NewRuntimeMux()
+	service, _ := NewService("api", "/api/", backend.URL)
+	service.AdaptiveTimeout = &TimeoutConfig{MinMs: 10, MaxMs: 50}
+	mux.AddProxy(service)
+
+	w := httptest.NewRecorder()
+	mux.GetMux().ServeHTTP(w, httptest.NewRequest("GET", "/api/?slow", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected a gateway timeout, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	mux.LoadHandler().ServeHTTP(w, httptest.NewRequest("GET", "/api", nil))
+	var load Load
+	json.Unmarshal(w.Body.Bytes(), &load)
+	if load.TimeoutMs != 50 {
+		t.Errorf("Expected the learned timeout in the load report, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/accesslog"
+)
+
+// trailerBackend streams two chunks, the second only once release is
+// closed, and ends with a declared and an undeclared trailer, like gRPC.
+func trailerBackend(t *testing.T, release chan struct{}) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("TE") != "trailers" {
+			t.Errorf("Expected TE: trailers to reach the backend, got %q", r.Header.Get("TE"))
+		}
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		w.Write([]byte("first\n"))
+		http.NewResponseController(w).Flush()
+		if release != nil {
+			<-release
+		}
+		w.Write([]byte("second\n"))
+		w.Header().Set("X-Checksum", "abc123")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestTrailersAndChunkedPassThrough(t *testing.T) {
+	release := make(chan struct{})
+	backend := trailerBackend(t, release)
+
+	mux := NewRuntimeMux()
+	mux.Uptime = NewUptimeHistory()
+	service, _ := NewService("grpc", "/grpc/", backend.URL)
+	service.SetResponseHeader("X-Served-By", "{service}")
+	service.EnableWatermark(WatermarkConfig{Label: "staging", Banner: true})
+	service.Use("log", accesslog.New("grpc", accesslog.Config{}, log.New(io.Discard, "", 0)).Middleware)
+	service.AdaptiveTimeout = &TimeoutConfig{MinMs: 1000, MaxMs: 5000}
+	mux.AddProxy(service)
+	cache := NewIdempotencyCache(0)
+	proxy := httptest.NewServer(cache.Middleware(mux))
+	defer proxy.Close()
+
+	req, _ := http.NewRequest("GET", proxy.URL+"/grpc/call?type=application/grpc", nil)
+	req.Header.Set("TE", "trailers")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("Expected a chunked response, got %v with length %d", resp.TransferEncoding, resp.ContentLength)
+	}
+
+	// The first chunk arrives while the backend is still streaming.
+	body := bufio.NewReader(resp.Body)
+	if line, err := body.ReadString('\n'); err != nil || line != "first\n" {
+		t.Fatalf("Expected the first chunk before the rest, got %q, %v", line, err)
+	}
+	close(release)
+	rest, _ := io.ReadAll(body)
+	if string(rest) != "second\n" {
+		t.Errorf("Unexpected rest of body %q", rest)
+	}
+	if resp.Trailer.Get("X-Checksum") != "abc123" || resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("Expected both trailers, got %v", resp.Trailer)
+	}
+	if resp.Header.Get("X-Checksum") != "" || resp.Header.Get("X-Served-By") != "grpc" {
+		t.Errorf("Unexpected headers %v", resp.Header)
+	}
+
+	// A watermarked page with trailers is streamed rather than rewritten.
+	req, _ = http.NewRequest("GET", proxy.URL+"/grpc/page?type=text/html", nil)
+	req.Header.Set("TE", "trailers")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(page) != "first\nsecond\n" || resp.Trailer.Get("X-Checksum") != "abc123" {
+		t.Errorf("Expected the page and its trailers unchanged, got %q and %v", page, resp.Trailer)
+	}
+}
+
+func TestIdempotentReplayKeepsTrailers(t *testing.T) {
+	backend := trailerBackend(t, nil)
+	mux := NewRuntimeMux()
+	service, _ := NewService("grpc", "/grpc/", backend.URL)
+	mux.AddProxy(service)
+	proxy := httptest.NewServer(NewIdempotencyCache(60e9).Middleware(mux))
+	defer proxy.Close()
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", proxy.URL+"/grpc/call?type=application/grpc", strings.NewReader("{}"))
+		req.Header.Set("Idempotency-Key", "k1")
+		req.Header.Set("TE", "trailers")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "first\nsecond\n" || resp.Trailer.Get("X-Checksum") != "abc123" || resp.Trailer.Get("Grpc-Status") != "0" {
+			t.Errorf("Request %d: unexpected body %q or trailers %v", i, body, resp.Trailer)
+		}
+		if i == 1 && (resp.Header.Get("Idempotent-Replayed") != "true" || resp.Header.Get("X-Checksum") != "") {
+			t.Errorf("Expected a replay with trailers kept out of the header, got %v", resp.Header)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// maxTransactionAttempts is how often a transaction is tried again when
+// the route table changes while it is being applied.
+const maxTransactionAttempts = 3
+
+// Operation is one change of a Transaction.
+type Operation struct {
+	// Op is "add", "update" or "remove".
+	Op string `json:"op"`
+	// Service is the full configuration of the service to add, or to
+	// replace the service of the same name with, e.g. to move it to
+	// another path.
+	Service *Service `json:"service,omitempty"`
+	// Name is the service to remove.
+	Name string `json:"name,omitempty"`
+}
+
+// Transaction is a batch of operations applied together.
+type Transaction struct {
+	Operations []Operation `json:"operations"`
+}
+
+// Transact applies the operations of tx to the route table as one change:
+// either all of them take effect, as a single revision, or, if any fails
+// or the resulting route table is invalid, none does. Operations apply in
+// order, so services may trade paths. With dryRun the changes are only
+// computed.
+func (ph *RuntimeMux) Transact(tx Transaction, dryRun bool) (Plan, error) {
+	if len(tx.Operations) == 0 {
+		return Plan{}, errors.New("no operations")
+	}
+	for attempt := 0; ; attempt++ {
+		ph.RLock()
+		state, base := ph.state(), ph.nextRevision
+		ph.RUnlock()
+		services, err := tx.applyTo(state.Services)
+		if err != nil {
+			return Plan{}, err
+		}
+		plan, err := ph.reconcile(State{Services: services}, dryRun, ReloadAPI, base)
+		if err != errStaleState {
+			return plan, err
+		}
+		if attempt+1 == maxTransactionAttempts {
+			return Plan{}, &ConflictError{"the route table kept changing while applying the transaction"}
+		}
+	}
+}
+
+// applyTo returns services with the operations of tx applied. services is
+// not modified.
+func (tx Transaction) applyTo(services []*Service) ([]*Service, error) {
+	services = append([]*Service(nil), services...)
+	find := func(name string) (int, error) {
+		found := -1
+		for i, s := range services {
+			if s.Name != name {
+				continue
+			}
+			if found >= 0 {
+				return 0, fmt.Errorf("more than one service is named %s", name)
+			}
+			found = i
+		}
+		if found < 0 {
+			return 0, fmt.Errorf("no service named %s", name)
+		}
+		return found, nil
+	}
+	for i, op := range tx.Operations {
+		var err error
+		switch op.Op {
+		case "add":
+			if op.Service == nil {
+				err = errors.New("service is required")
+				break
+			}
+			if _, ferr := find(op.Service.Name); ferr == nil {
+				err = fmt.Errorf("service %s already exists", op.Service.Name)
+				break
+			}
+			services = append(services, op.Service)
+		case "update":
+			if op.Service == nil {
+				err = errors.New("service is required")
+				break
+			}
+			var j int
+			if j, err = find(op.Service.Name); err == nil {
+				services[j] = op.Service
+			}
+		case "remove":
+			var j int
+			if j, err = find(op.Name); err == nil {
+				services = append(services[:j:j], services[j+1:]...)
+			}
+		default:
+			err = fmt.Errorf("unknown op %q, expected add, update or remove", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %v", i, err)
+		}
+	}
+	return services, nil
+}
+
+// TransactionHandler applies transactions; see Transact.
+//
+//	POST /?dry_run=1   apply the Transaction in the body; with dry_run only
+//	                   the plan is returned
+func (ph *RuntimeMux) TransactionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tx Transaction
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&tx); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid transaction: %v", err))
+			return
+		}
+		dryRun := r.URL.Query().Get("dry_run")
+		plan, err := ph.Transact(tx, dryRun == "1" || dryRun == "true")
+		var conflict *ConflictError
+		switch {
+		case errors.As(err, &conflict):
+			admin.WriteError(w, http.StatusConflict, err)
+		case err != nil:
+			admin.WriteError(w, http.StatusUnprocessableEntity, err)
+		default:
+			admin.WriteJSON(w, http.StatusOK, plan)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	blue := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("blue")) }))
+	defer blue.Close()
+	green := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("green")) }))
+	defer green.Close()
+
+	mux := NewRuntimeMux()
+	live, _ := NewService("live", "/app/", blue.URL)
+	mux.AddProxy(live)
+	staged, _ := NewService("staged", "/staging/", green.URL)
+	mux.AddProxy(staged)
+	old, _ := NewService("old", "/old/", blue.URL)
+	mux.AddProxy(old)
+	revisions := len(mux.Revisions())
+
+	post := func(query, doc string) (int, Plan) {
+		w := httptest.NewRecorder()
+		mux.TransactionHandler().ServeHTTP(w, httptest.NewRequest("POST", "/"+query, strings.NewReader(doc)))
+		var plan Plan
+		json.Unmarshal(w.Body.Bytes(), &plan)
+		return w.Code, plan
+	}
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Body.String()
+	}
+
+	// Failing operations and an invalid result change nothing.
+	for _, doc := range []string{
+		`{"operations": [{"op": "remove", "name": "old"}, {"op": "remove", "name": "missing"}]}`,
+		`{"operations": [{"op": "remove", "name": "old"}, {"op": "add", "service": {"name": "x", "path": "/app/", "url": "` + blue.URL + `"}}]}`,
+		`{"operations": [{"op": "rename", "name": "old"}]}`,
+	} {
+		if code, _ := post("", doc); code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422 for %s, got %d", doc, code)
+		}
+	}
+	if len(mux.Revisions()) != revisions || get("/old/") != "blue" {
+		t.Fatal("Expected failed transactions to leave the route table alone")
+	}
+
+	// Swapping two paths needs both updates applied together.
+	swap := `{"operations": [
+		{"op": "update", "service": {"name": "live", "path": "/staging/", "url": "` + blue.URL + `"}},
+		{"op": "update", "service": {"name": "staged", "path": "/app/", "url": "` + green.URL + `"}},
+		{"op": "remove", "name": "old"}
+	]}`
+	code, plan := post("?dry_run=1", swap)
+	if code != http.StatusOK || !plan.DryRun || len(plan.Changes) != 3 || get("/app/") != "blue" {
+		t.Fatalf("Expected a dry run of 3 changes, got %d %+v", code, plan)
+	}
+	code, plan = post("", swap)
+	if code != http.StatusOK || plan.Revision == 0 {
+		t.Fatalf("Expected the swap to apply, got %d %+v", code, plan)
+	}
+	if get("/app/") != "green" || get("/staging/") != "blue" || strings.Contains(get("/old/"), "blue") {
+		t.Errorf("Expected the paths swapped and old removed, got %q %q %q", get("/app/"), get("/staging/"), get("/old/"))
+	}
+	if got := len(mux.Revisions()); got != revisions+1 {
+		t.Errorf("Expected one revision for the transaction, got %d new", got-revisions)
+	}
+}
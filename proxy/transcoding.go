@@ -0,0 +1,24 @@
+//go:build !notranscode
+
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/kirtansoni/reverse-proxy-go/transcode"
+)
+
+// EnableTranscoding turns the service into a REST/JSON front for the gRPC
+// server at its URL, using the google.api.http annotations of a compiled
+// descriptor set. Routes are matched after stripping the service path.
+func (s *Service) EnableTranscoding(descriptorSet string) error {
+	t, err := transcode.New(descriptorSet, s.Url)
+	if err != nil {
+		return err
+	}
+	s.Descriptors = descriptorSet
+	s.backend = http.StripPrefix(strings.TrimSuffix(s.Path, "/"), t)
+	s.rebuild()
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build notranscode
+
+package proxy
+
+import "fmt"
+
+// EnableTranscoding fails in builds made with -tags notranscode, which leave
+// out gRPC transcoding and its protobuf dependency.
+func (s *Service) EnableTranscoding(descriptorSet string) error {
+	return fmt.Errorf("gRPC transcoding is not included in this build (built with -tags notranscode)")
+}
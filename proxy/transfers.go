@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+var errTransferAborted = errors.New("transfer aborted")
+
+// TransferTracker records the progress of responses larger than a threshold
+// so that stalled downloads can be found and aborted through the admin API.
+type TransferTracker struct {
+	threshold int64
+
+	mu     sync.Mutex
+	nextID uint64
+	active map[uint64]*transfer
+}
+
+type transfer struct {
+	id            uint64
+	method        string
+	host          string
+	path          string
+	remoteAddr    string
+	start         time.Time
+	contentLength int64
+	cancel        context.CancelFunc
+
+	bytes     atomic.Int64
+	lastWrite atomic.Int64 // unix nanoseconds
+	aborted   atomic.Bool
+}
+
+// Transfer is a snapshot of a tracked response.
+type Transfer struct {
+	ID            uint64    `json:"id"`
+	Method        string    `json:"method"`
+	Host          string    `json:"host"`
+	Path          string    `json:"path"`
+	RemoteAddr    string    `json:"remote_addr"`
+	Start         time.Time `json:"start"`
+	Bytes         int64     `json:"bytes"`
+	ContentLength int64     `json:"content_length,omitempty"`
+	// Progress is the fraction sent, when the length is known.
+	Progress   float64 `json:"progress,omitempty"`
+	Throughput float64 `json:"bytes_per_second"`
+	Idle       string  `json:"idle"`
+}
+
+func NewTransferTracker(threshold int64) *TransferTracker {
+	return &TransferTracker{
+		threshold: threshold,
+		active:    make(map[uint64]*transfer),
+	}
+}
+
+func (t *TransferTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		tr := &transfer{
+			method:        r.Method,
+			host:          r.Host,
+			path:          r.URL.Path,
+			remoteAddr:    r.RemoteAddr,
+			start:         time.Now(),
+			contentLength: -1,
+			cancel:        cancel,
+		}
+		tw := &transferWriter{ResponseWriter: w, tracker: t, transfer: tr}
+		defer t.remove(tr)
+		next.ServeHTTP(tw, r.WithContext(ctx))
+	})
+}
+
+// Transfers returns the tracked responses, oldest first.
+func (t *TransferTracker) Transfers() []Transfer {
+	t.mu.Lock()
+	active := make([]*transfer, 0, len(t.active))
+	for _, tr := range t.active {
+		active = append(active, tr)
+	}
+	t.mu.Unlock()
+
+	now := time.Now()
+	list := make([]Transfer, 0, len(active))
+	for _, tr := range active {
+		sent := tr.bytes.Load()
+		snap := Transfer{
+			ID:         tr.id,
+			Method:     tr.method,
+			Host:       tr.host,
+			Path:       tr.path,
+			RemoteAddr: tr.remoteAddr,
+			Start:      tr.start,
+			Bytes:      sent,
+			Idle:       now.Sub(time.Unix(0, tr.lastWrite.Load())).Round(time.Millisecond).String(),
+		}
+		if elapsed := now.Sub(tr.start).Seconds(); elapsed > 0 {
+			snap.Throughput = float64(sent) / elapsed
+		}
+		if tr.contentLength > 0 {
+			snap.ContentLength = tr.contentLength
+			snap.Progress = float64(sent) / float64(tr.contentLength)
+		}
+		list = append(list, snap)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Abort cancels the request behind a tracked transfer and fails its further
+// writes, which closes the client connection. It reports whether the
+// transfer was found.
+func (t *TransferTracker) Abort(id uint64) bool {
+	t.mu.Lock()
+	tr, ok := t.active[id]
+	t.mu.Unlock()
+	if !ok {
+		return false
+	}
+	tr.aborted.Store(true)
+	tr.cancel()
+	return true
+}
+
+func (t *TransferTracker) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, t.Transfers())
+	})
+	mux.HandleFunc("DELETE /{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !t.Abort(id) {
+			admin.WriteError(w, http.StatusNotFound, errors.New("no such transfer"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func (t *TransferTracker) add(tr *transfer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextID++
+	tr.id = t.nextID
+	t.active[tr.id] = tr
+}
+
+func (t *TransferTracker) remove(tr *transfer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tr.id != 0 {
+		delete(t.active, tr.id)
+	}
+}
+
+// transferWriter counts the bytes of a response and registers it with the
+// tracker once it is known to exceed the threshold.
+type transferWriter struct {
+	http.ResponseWriter
+	tracker     *TransferTracker
+	transfer    *transfer
+	registered  bool
+	wroteHeader bool
+}
+
+func (w *transferWriter) WriteHeader(status int) {
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+	n, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64)
+	if err == nil && !w.registered {
+		w.transfer.contentLength = n
+		if n >= w.tracker.threshold {
+			w.register()
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *transferWriter) Write(p []byte) (int, error) {
+	if w.transfer.aborted.Load() {
+		return 0, errTransferAborted
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	total := w.transfer.bytes.Add(int64(n))
+	w.transfer.lastWrite.Store(time.Now().UnixNano())
+	if total >= w.tracker.threshold {
+		w.register()
+	}
+	return n, err
+}
+
+func (w *transferWriter) register() {
+	if w.registered {
+		return
+	}
+	w.registered = true
+	if w.transfer.lastWrite.Load() == 0 {
+		w.transfer.lastWrite.Store(time.Now().UnixNano())
+	}
+	w.tracker.add(w.transfer)
+}
+
+func (w *transferWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// hijack the connection for upgrades.
+func (w *transferWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
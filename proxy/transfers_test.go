@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransferTracking(t *testing.T) {
+	tracker := NewTransferTracker(1024)
+	sent := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error, 1)
+
+	handler := tracker.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/small" {
+			w.Write([]byte("ok"))
+			return
+		}
+		w.Header().Set("Content-Length", "4096")
+		w.Write(make([]byte, 2048))
+		close(sent)
+		<-release
+		_, err := w.Write(make([]byte, 2048))
+		done <- err
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/small", nil))
+	if n := len(tracker.Transfers()); n != 0 {
+		t.Fatalf("Expected small responses to be untracked, got %d", n)
+	}
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/large", nil))
+	<-sent
+
+	transfers := tracker.Transfers()
+	if len(transfers) != 1 {
+		t.Fatalf("Expected 1 transfer, got %d", len(transfers))
+	}
+	tr := transfers[0]
+	if tr.Path != "/large" || tr.Bytes != 2048 || tr.Progress != 0.5 {
+		t.Errorf("Unexpected transfer snapshot: %+v", tr)
+	}
+
+	admin := tracker.AdminHandler()
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(w.Body.String(), `"bytes":2048`) {
+		t.Errorf("Expected transfer in admin listing, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("DELETE", "/1", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected abort to succeed, got %d", w.Code)
+	}
+	close(release)
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected writes to fail after abort")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Handler did not finish")
+	}
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest("DELETE", "/1", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected finished transfer to be gone, got %d", w.Code)
+	}
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultTLSSessionCacheSize is the number of TLS sessions kept per service
+// when TLSSessionCacheSize is not set.
+const DefaultTLSSessionCacheSize = 64
+
+// newTransport returns an upstream transport with its own TLS session
+// cache, so resumption to one backend is not affected by churn on another.
+func newTransport(sessionCacheSize int) *http.Transport {
+	if sessionCacheSize <= 0 {
+		sessionCacheSize = DefaultTLSSessionCacheSize
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(sessionCacheSize)}
+	// Mesh hosts are resolved by the mesh, so they skip the DNS cache.
+	t.DialContext = meshDial(dnsDial(t.DialContext))
+	return t
+}
+
+// SetTLSSessionCacheSize gives the service a new upstream transport keeping
+// up to n TLS sessions; 0 restores the default size. Pooled connections and
+// cached sessions of the old transport are not carried over.
+func (s *Service) SetTLSSessionCacheSize(n int) {
+	s.TLSSessionCacheSize = n
+	s.resetTransport()
+}
+
+// EnableConnectionAffinity pins all requests of a downstream connection to
+// one upstream connection of their own, as backends using NTLM or Negotiate
+// authentication expect: they authenticate the TCP connection rather than
+// each request. It needs RequestTracker.ConnContext installed on the server;
+// requests without a known downstream connection use the shared pool.
+func (s *Service) EnableConnectionAffinity() {
+	s.ConnectionAffinity = true
+	s.resetTransport()
+}
+
+// resetTransport gives the service a new upstream transport built from its
+// configuration, closing the idle connections of the old one, which nothing
+// would reuse.
+func (s *Service) resetTransport() {
+	old := s.ReverseProxy.Transport
+	t := newTransport(s.TLSSessionCacheSize)
+	if s.SourceAddr != "" {
+		t.DialContext = meshDial(dnsDial(sourceDial(s.SourceAddr)))
+	}
+	if n := s.maxResponseHeaderBytes(); n > 0 {
+		t.MaxResponseHeaderBytes = n
+	}
+	rp := *s.ReverseProxy
+	rp.Transport = t
+	if s.Compat != nil {
+		// Header casing only survives HTTP/1.x.
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if s.Sticky != nil && !s.ConnectionAffinity {
+		rp.Transport = newStickyTransport(s, t)
+	}
+	if s.ConnectionAffinity {
+		rp.Transport = newAffinityTransport(t)
+	}
+	if s.Compat != nil {
+		next := rp.Transport
+		if s.Compat.HTTP10 {
+			next = &http10Transport{base: t}
+		}
+		rp.Transport = newCompatTransport(s.Compat, next)
+	}
+	if s.coldStart != nil {
+		rp.Transport = &coldStartTransport{next: rp.Transport, state: s.coldStart}
+	}
+	s.ReverseProxy = &rp
+	s.rebuild()
+	if c, ok := old.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// affinityIdle is how long a pinned upstream connection is kept after its
+// downstream connection's last request.
+const affinityIdle = 90 * time.Second
+
+// affinityTransport gives each downstream connection a transport holding
+// at most one HTTP/1.1 connection to the backend.
+type affinityTransport struct {
+	shared *http.Transport
+
+	mu        sync.Mutex
+	pinned    map[net.Conn]*pinnedTransport
+	lastSweep time.Time
+}
+
+type pinnedTransport struct {
+	*http.Transport
+	lastUsed time.Time
+}
+
+func newAffinityTransport(shared *http.Transport) *affinityTransport {
+	return &affinityTransport{shared: shared, pinned: make(map[net.Conn]*pinnedTransport)}
+}
+
+func (a *affinityTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	downstream, ok := r.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return a.shared.RoundTrip(r)
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	a.sweep(now)
+	p := a.pinned[downstream]
+	if p == nil {
+		t := a.shared.Clone()
+		t.MaxConnsPerHost = 1
+		t.MaxIdleConnsPerHost = 1
+		// Connection-based authentication needs HTTP/1.1.
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		p = &pinnedTransport{Transport: t}
+		a.pinned[downstream] = p
+	}
+	p.lastUsed = now
+	a.mu.Unlock()
+
+	return p.RoundTrip(r)
+}
+
+func (a *affinityTransport) CloseIdleConnections() {
+	a.shared.CloseIdleConnections()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, p := range a.pinned {
+		p.CloseIdleConnections()
+	}
+}
+
+// sweep closes the pinned connections of downstream connections that have
+// been quiet for affinityIdle. Callers must hold a.mu.
+func (a *affinityTransport) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < affinityIdle/2 {
+		return
+	}
+	a.lastSweep = now
+	for conn, p := range a.pinned {
+		if now.Sub(p.lastUsed) >= affinityIdle {
+			p.CloseIdleConnections()
+			delete(a.pinned, conn)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTLSSessionCachePerService(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strconv.FormatBool(r.TLS.DidResume)))
+	}))
+	defer backend.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(backend.Certificate())
+
+	newService := func(name string) *Service {
+		s, _ := NewService(name, "/"+name+"/", backend.URL)
+		s.SetTLSSessionCacheSize(1)
+		s.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+		return s
+	}
+	resumed := func(s *Service) string {
+		s.Transport.(*http.Transport).CloseIdleConnections()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Body.String()
+	}
+
+	a, b := newService("a"), newService("b")
+	if got := resumed(a); got != "false" {
+		t.Fatalf("Expected a full handshake first, got %q", got)
+	}
+	if got := resumed(a); got != "true" {
+		t.Errorf("Expected the session to be resumed, got %q", got)
+	}
+	if got := resumed(b); got != "false" {
+		t.Errorf("Expected services not to share sessions, got %q", got)
+	}
+	if got := resumed(a); got != "true" {
+		t.Errorf("Expected another service's sessions not to evict ours, got %q", got)
+	}
+}
+
+func TestConnectionAffinity(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	}))
+	defer backend.Close()
+
+	service, _ := NewService("ntlm", "/ntlm/", backend.URL)
+	service.EnableConnectionAffinity()
+	front := httptest.NewUnstartedServer(service)
+	front.Config.ConnContext = NewRequestTracker().ConnContext
+	front.Start()
+	defer front.Close()
+
+	upstreams := func(client *http.Client) map[string]bool {
+		seen := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			res, err := client.Get(front.URL)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			seen[string(body)] = true
+		}
+		return seen
+	}
+	a := upstreams(&http.Client{Transport: &http.Transport{}})
+	b := upstreams(&http.Client{Transport: &http.Transport{}})
+	if len(a) != 1 || len(b) != 1 {
+		t.Fatalf("Expected each downstream connection to use one upstream connection, got %v and %v", a, b)
+	}
+	for addr := range a {
+		if b[addr] {
+			t.Error("Expected downstream connections not to share an upstream connection")
+		}
+	}
+}
+
+func TestResetTransportClosesIdleConnections(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backend.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	service, _ := NewService("app", "/app/", backend.URL)
+	service.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	service.SetTLSSessionCacheSize(1)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("Expected the idle connection of the old transport to be closed")
+	}
+}
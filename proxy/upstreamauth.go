@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/secrets"
+)
+
+// defaultCredentialRefresh is how often upstream credentials are read
+// again when UpstreamAuthConfig sets no interval.
+const defaultCredentialRefresh = 5 * time.Minute
+
+// UpstreamAuthConfig authenticates the proxy to a service's backend with a
+// credential kept outside the route table and read again periodically, so
+// rotating it needs no restart.
+type UpstreamAuthConfig struct {
+	// Type is "bearer", or "basic" with a secret of the form user:password.
+	Type string `json:"type"`
+	// Secret references the credential; see secrets.Parse.
+	Secret    string `json:"secret"`
+	RefreshMs int64  `json:"refresh_ms,omitempty"`
+}
+
+// overriddenKey marks requests a developer override sends to another
+// backend, which must not receive the service's credentials.
+type overriddenKey struct{}
+
+// EnableUpstreamAuth sends the credential cfg references as the
+// Authorization header of every upstream request, replacing the client's.
+// A nil cfg turns it off.
+func (s *Service) EnableUpstreamAuth(cfg *UpstreamAuthConfig) error {
+	if cfg == nil {
+		s.UpstreamAuth = nil
+		s.middlewares = s.without("upstream-auth")
+		s.rebuild()
+		return nil
+	}
+	if cfg.Type != "bearer" && cfg.Type != "basic" {
+		return fmt.Errorf("upstream auth type must be bearer or basic, got %q", cfg.Type)
+	}
+	if cfg.RefreshMs < 0 {
+		return errors.New("upstream auth refresh_ms must not be negative")
+	}
+	source, err := secrets.Parse(cfg.Secret)
+	if err != nil {
+		return fmt.Errorf("upstream auth: %v", err)
+	}
+	refresh := defaultCredentialRefresh
+	if cfg.RefreshMs > 0 {
+		refresh = time.Duration(cfg.RefreshMs) * time.Millisecond
+	}
+	cache := secrets.NewCache(source, refresh)
+	name, typ := s.Name, cfg.Type
+	s.UpstreamAuth = cfg
+	s.Use("upstream-auth", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Context().Value(overriddenKey{}) != nil {
+				r = r.Clone(r.Context())
+				r.Header.Del("Authorization")
+				next.ServeHTTP(w, r)
+				return
+			}
+			secret, err := cache.Get(r.Context())
+			if err != nil {
+				log.Printf("Upstream credentials of %s unavailable: %v", name, err)
+				http.Error(w, "Bad Gateway", http.StatusBadGateway)
+				return
+			}
+			value, err := authorization(typ, secret)
+			if err != nil {
+				log.Printf("Upstream credentials of %s invalid: %v", name, err)
+				http.Error(w, "Bad Gateway", http.StatusBadGateway)
+				return
+			}
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", value)
+			next.ServeHTTP(&rejectionWriter{ResponseWriter: w, rejected: cache.Invalidate}, r)
+		})
+	})
+	return nil
+}
+
+// authorization returns the Authorization header value for a secret.
+func authorization(typ, secret string) (string, error) {
+	if typ == "bearer" {
+		return "Bearer " + secret, nil
+	}
+	if !strings.Contains(secret, ":") {
+		return "", errors.New("basic auth secret must be user:password")
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(secret)), nil
+}
+
+// rejectionWriter calls rejected when the backend answers 401, so a
+// credential rotated before its refresh is read again.
+type rejectionWriter struct {
+	http.ResponseWriter
+	rejected func()
+}
+
+func (w *rejectionWriter) WriteHeader(status int) {
+	if status == http.StatusUnauthorized {
+		w.rejected()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *rejectionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *rejectionWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// withOverridden marks r as sent to a developer's backend.
+func withOverridden(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), overriddenKey{}, true))
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpstreamAuth(t *testing.T) {
+	var got []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Authorization"))
+	}))
+	defer backend.Close()
+	secret := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(secret, []byte("s3cret\n"), 0600)
+
+	mux := NewRuntimeMux()
+	service, _ := NewService("api", "/api/", backend.URL)
+	if err := service.EnableUpstreamAuth(&UpstreamAuthConfig{Type: "bearer", Secret: "file:" + secret}); err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(service)
+
+	r := httptest.NewRequest("GET", "/api/", nil)
+	r.Header.Set("Authorization", "Bearer client-key")
+	mux.ServeHTTP(httptest.NewRecorder(), r)
+	if len(got) != 1 || got[0] != "Bearer s3cret" {
+		t.Errorf("Expected the backend to get the proxy's credential, got %q", got)
+	}
+
+	if err := service.EnableUpstreamAuth(&UpstreamAuthConfig{Type: "digest", Secret: "file:" + secret}); err == nil {
+		t.Error("Expected an unsupported type to be rejected")
+	}
+	if err := service.EnableUpstreamAuth(&UpstreamAuthConfig{Type: "basic", Secret: "vault:api"}); err == nil {
+		t.Error("Expected an unsupported secret reference to be rejected")
+	}
+
+	// Without a readable credential, nothing is sent unauthenticated.
+	missing, _ := NewService("missing", "/missing/", backend.URL)
+	missing.EnableUpstreamAuth(&UpstreamAuthConfig{Type: "basic", Secret: "file:" + secret + ".missing"})
+	mux.AddProxy(missing)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/missing/", nil))
+	if w.Code != http.StatusBadGateway || len(got) != 1 {
+		t.Errorf("Expected 502 without reaching the backend, got %d", w.Code)
+	}
+}
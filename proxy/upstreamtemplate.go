@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// templateValue is what a variable of an upstream URL template may resolve
+// to: a single DNS label, so a request can pick a tenant's backend but not
+// point the URL at another host, port or path.
+var templateValue = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// upstreamTemplate is a service URL with request variables, such as
+// http://{header.X-Tenant}.internal:8080, resolved for each request.
+type upstreamTemplate struct {
+	raw string
+	// hosts are the hosts a resolved URL may name: exact names, or
+	// *.suffix patterns matching any name below suffix.
+	hosts []string
+}
+
+func isUpstreamTemplate(u string) bool {
+	return strings.Contains(u, "{")
+}
+
+// parseUpstreamTemplate checks the variables of raw, which may be
+// {header.<name>}, {query.<name>} and {cookie.<name>}, and returns the URL
+// with each variable set to a placeholder label.
+func parseUpstreamTemplate(raw string) (*url.URL, error) {
+	placeholder, err := expandUpstream(raw, func(source, name string) (string, error) { return "x", nil })
+	if err != nil {
+		return nil, err
+	}
+	return parseHTTPURL(placeholder)
+}
+
+// expandUpstream replaces the variables of template with the values of
+// lookup, which must be valid labels.
+func expandUpstream(template string, lookup func(source, name string) (string, error)) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable in %q", template)
+		}
+		variable := template[start+1 : start+end]
+		source, name, _ := strings.Cut(variable, ".")
+		if source != "header" && source != "query" && source != "cookie" || name == "" {
+			return "", fmt.Errorf("unknown variable {%s}, expected {header.<name>}, {query.<name>} or {cookie.<name>}", variable)
+		}
+		value, err := lookup(source, name)
+		if err != nil {
+			return "", err
+		}
+		value = strings.ToLower(value)
+		if !templateValue.MatchString(value) {
+			return "", fmt.Errorf("%s %s must be a DNS label of letters, digits and hyphens, got %q", source, name, value)
+		}
+		b.WriteString(template[:start])
+		b.WriteString(value)
+		template = template[start+end+1:]
+	}
+	b.WriteString(template)
+	return b.String(), nil
+}
+
+// errTemplateHost is a resolved upstream URL naming a host outside the
+// service's UpstreamHosts.
+var errTemplateHost = errors.New("upstream host not allowed")
+
+// resolve returns the upstream URL of r.
+func (t *upstreamTemplate) resolve(r *http.Request) (*url.URL, error) {
+	resolved, err := expandUpstream(t.raw, func(source, name string) (string, error) {
+		var value string
+		switch source {
+		case "header":
+			value = r.Header.Get(name)
+		case "query":
+			value = r.URL.Query().Get(name)
+		case "cookie":
+			if c, err := r.Cookie(name); err == nil {
+				value = c.Value
+			}
+		}
+		if value == "" {
+			return "", fmt.Errorf("missing %s %s", source, name)
+		}
+		return value, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	u, err := parseHTTPURL(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if !t.allows(u.Hostname()) {
+		return nil, fmt.Errorf("%w: %s", errTemplateHost, u.Hostname())
+	}
+	return u, nil
+}
+
+func (t *upstreamTemplate) allows(host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range t.hosts {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// SetUpstreamHosts sets the hosts the templated URL of the service may
+// resolve to, as exact names or *.suffix patterns, e.g. *.internal. Until
+// some are set, requests to a templated service are refused.
+func (s *Service) SetUpstreamHosts(hosts []string) error {
+	if !isUpstreamTemplate(s.Url) {
+		if len(hosts) > 0 {
+			return errors.New("upstream hosts need a templated url")
+		}
+		return nil
+	}
+	normalized := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		name := strings.TrimPrefix(h, "*.")
+		if name == "" || strings.ContainsAny(name, "*/:@ ") {
+			return fmt.Errorf("invalid upstream host %q, expected a host name or *.suffix", h)
+		}
+		normalized = append(normalized, h)
+	}
+	if len(normalized) == 0 {
+		normalized = nil
+	}
+	s.UpstreamHosts = normalized
+	t := &upstreamTemplate{raw: s.Url, hosts: normalized}
+	s.useOutermost("upstream-template", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Overrides and region routes pick their own backend.
+			if _, ok := r.Context().Value(targetKey{}).(*url.URL); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			u, err := t.resolve(r)
+			switch {
+			case errors.Is(err, errTemplateHost):
+				http.Error(w, err.Error(), http.StatusForbidden)
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), targetKey{}, u)))
+			}
+		})
+	})
+	return nil
+}
+
+// templateTarget returns the upstream URL r resolves to if the service URL
+// is a template.
+func (s *Service) templateTarget(r *http.Request) (*url.URL, error) {
+	if !isUpstreamTemplate(s.Url) {
+		return nil, nil
+	}
+	return (&upstreamTemplate{raw: s.Url, hosts: s.UpstreamHosts}).resolve(r)
+}
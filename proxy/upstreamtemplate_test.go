@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpstreamTemplate(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + " " + r.URL.Path))
+	}))
+	defer backend.Close()
+	_, port, _ := net.SplitHostPort(backend.Listener.Addr().String())
+
+	mux := NewRuntimeMux()
+	if _, err := NewServiceFromConfig(&Service{Name: "tenants", Path: "/t/", Url: "http://{header.X-Tenant}.localhost:" + port}); err == nil {
+		t.Error("Expected a templated url without upstream hosts to be rejected")
+	}
+	for _, u := range []string{"http://{host}.localhost", "http://{header.X-Tenant.localhost"} {
+		if _, err := NewService("bad", "/bad/", u); err == nil {
+			t.Errorf("Expected %s to be rejected", u)
+		}
+	}
+	service, err := NewServiceFromConfig(&Service{
+		Name: "tenants", Path: "/t/", Url: "http://127.0.0.1:" + port + "/{header.X-Tenant}/{query.v}",
+		UpstreamHosts: []string{"127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(service)
+
+	get := func(tenant, query string) (int, string) {
+		r := httptest.NewRequest("GET", "/t/items"+query, nil)
+		if tenant != "" {
+			r.Header.Set("X-Tenant", tenant)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+	if code, body := get("Acme", "?v=v2"); code != http.StatusOK || !strings.Contains(body, " /acme/v2/") {
+		t.Errorf("Expected the acme backend, got %d %q", code, body)
+	}
+	for _, tc := range []struct {
+		tenant, query string
+		code          int
+	}{
+		{"", "?v=v2", http.StatusBadRequest},
+		{"acme", "", http.StatusBadRequest},
+		{"evil.com#", "?v=v2", http.StatusBadRequest},
+		{"a.b", "?v=v2", http.StatusBadRequest},
+		{"acme", "?v=..%2F", http.StatusBadRequest},
+	} {
+		if code, body := get(tc.tenant, tc.query); code != tc.code {
+			t.Errorf("Expected %d for tenant %q and %s, got %d %q", tc.code, tc.tenant, tc.query, code, body)
+		}
+	}
+
+	eval, err := mux.Evaluate(EvalRequest{Host: "example.com", Path: "/t/items?v=v1", Headers: map[string]string{"X-Tenant": "acme"}}, "")
+	if err != nil || !strings.HasPrefix(eval.Upstream, "http://127.0.0.1:"+port+"/acme/v1/") {
+		t.Errorf("Expected the evaluation to resolve the template, got %+v %v", eval, err)
+	}
+
+	// Only allowed hosts are dialed.
+	sharded, err := NewServiceFromConfig(&Service{
+		Name: "sharded", Path: "/s/", Url: "http://{header.X-Tenant}.internal", UpstreamHosts: []string{"acme.internal"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux.AddProxy(sharded)
+	r := httptest.NewRequest("GET", "/s/", nil)
+	r.Header.Set("X-Tenant", "globex")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a host outside the allowlist, got %d", w.Code)
+	}
+	eval, err = mux.Evaluate(EvalRequest{Host: "example.com", Path: "/s/", Headers: map[string]string{"X-Tenant": "globex"}}, "")
+	if err != nil || eval.Error == "" || eval.Upstream != "" {
+		t.Errorf("Expected the evaluation to report the refusal, got %+v %v", eval, err)
+	}
+}
@@ -0,0 +1,325 @@
+package proxy
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+const (
+	uptimeHours = 7 * 24
+	uptimeDays  = 90
+)
+
+// latencyBounds are the upper bounds of the latency buckets of an
+// UptimeBucket; a last bucket counts slower requests.
+var latencyBounds = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond,
+	5 * time.Second, 10 * time.Second,
+}
+
+// UptimeBucket rolls up a service's traffic and synthetic checks over an
+// hour or a day.
+type UptimeBucket struct {
+	Start         time.Time `json:"start"`
+	Requests      int64     `json:"requests"`
+	Errors        int64     `json:"errors"`
+	CheckRuns     int64     `json:"check_runs,omitempty"`
+	CheckFailures int64     `json:"check_failures,omitempty"`
+	// Latency counts requests by latency, per latencyBounds.
+	Latency []int64 `json:"latency"`
+}
+
+// Availability is the share of passing checks or, for a service without
+// checks, of requests not answered with a 5xx. It is false without data.
+func (b *UptimeBucket) Availability() (float64, bool) {
+	switch {
+	case b.CheckRuns > 0:
+		return 1 - float64(b.CheckFailures)/float64(b.CheckRuns), true
+	case b.Requests > 0:
+		return 1 - float64(b.Errors)/float64(b.Requests), true
+	}
+	return 0, false
+}
+
+// LatencyP95 is the upper bound of the latency bucket holding the 95th
+// percentile, or 0 without requests.
+func (b *UptimeBucket) LatencyP95() time.Duration {
+	var total int64
+	for _, n := range b.Latency {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	rank, seen := (total*95+99)/100, int64(0)
+	for i, n := range b.Latency {
+		if seen += n; seen >= rank {
+			if i < len(latencyBounds) {
+				return latencyBounds[i]
+			}
+			break
+		}
+	}
+	return 2 * latencyBounds[len(latencyBounds)-1]
+}
+
+func (b *UptimeBucket) add(o *UptimeBucket) {
+	b.Requests += o.Requests
+	b.Errors += o.Errors
+	b.CheckRuns += o.CheckRuns
+	b.CheckFailures += o.CheckFailures
+	for i, n := range o.Latency {
+		b.Latency[i] += n
+	}
+}
+
+// ServiceUptime is the history of a service, oldest bucket first.
+type ServiceUptime struct {
+	Hourly []UptimeBucket `json:"hourly"`
+	Daily  []UptimeBucket `json:"daily"`
+}
+
+// bucket returns the bucket starting at start, appending it if it is new
+// and dropping those beyond keep.
+func bucket(buckets *[]UptimeBucket, start time.Time, keep int) *UptimeBucket {
+	b := *buckets
+	if n := len(b); n > 0 && b[n-1].Start.Equal(start) {
+		return &b[n-1]
+	}
+	b = append(b, UptimeBucket{Start: start, Latency: make([]int64, len(latencyBounds)+1)})
+	if len(b) > keep {
+		b = b[len(b)-keep:]
+	}
+	*buckets = b
+	return &b[len(b)-1]
+}
+
+// UptimeHistory keeps hourly rollups of every service for a week and daily
+// ones for 90 days. It is kept across restarts with checkpoint.Register.
+type UptimeHistory struct {
+	mu       sync.Mutex
+	services map[string]*ServiceUptime
+}
+
+func NewUptimeHistory() *UptimeHistory {
+	return &UptimeHistory{services: make(map[string]*ServiceUptime)}
+}
+
+// record applies f to the current hourly and daily buckets of service.
+func (u *UptimeHistory) record(service string, at time.Time, f func(*UptimeBucket)) {
+	at = at.UTC()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	s := u.services[service]
+	if s == nil {
+		s = &ServiceUptime{}
+		u.services[service] = s
+	}
+	f(bucket(&s.Hourly, at.Truncate(time.Hour), uptimeHours))
+	f(bucket(&s.Daily, time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC), uptimeDays))
+}
+
+func (u *UptimeHistory) observe(service string, at time.Time, latency time.Duration, failed bool) {
+	i := sort.Search(len(latencyBounds), func(i int) bool { return latency <= latencyBounds[i] })
+	u.record(service, at, func(b *UptimeBucket) {
+		b.Requests++
+		b.Latency[i]++
+		if failed {
+			b.Errors++
+		}
+	})
+}
+
+func (u *UptimeHistory) observeCheck(res CheckResult) {
+	u.record(res.Service, res.CheckedAt, func(b *UptimeBucket) {
+		b.CheckRuns++
+		if !res.OK {
+			b.CheckFailures++
+		}
+	})
+}
+
+// Snapshot returns a copy of the history of every service.
+func (u *UptimeHistory) Snapshot() map[string]ServiceUptime {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	snap := make(map[string]ServiceUptime, len(u.services))
+	for name, s := range u.services {
+		c := ServiceUptime{Hourly: make([]UptimeBucket, len(s.Hourly)), Daily: make([]UptimeBucket, len(s.Daily))}
+		for i, b := range s.Hourly {
+			c.Hourly[i] = b
+			c.Hourly[i].Latency = append([]int64(nil), b.Latency...)
+		}
+		for i, b := range s.Daily {
+			c.Daily[i] = b
+			c.Daily[i].Latency = append([]int64(nil), b.Latency...)
+		}
+		snap[name] = c
+	}
+	return snap
+}
+
+// Restore merges a snapshot into the history, as on start.
+func (u *UptimeHistory) Restore(snap map[string]ServiceUptime) {
+	for name, s := range snap {
+		for _, b := range s.Hourly {
+			u.restore(name, b, true)
+		}
+		for _, b := range s.Daily {
+			u.restore(name, b, false)
+		}
+	}
+}
+
+func (u *UptimeHistory) restore(service string, saved UptimeBucket, hourly bool) {
+	if len(saved.Latency) != len(latencyBounds)+1 {
+		saved.Latency = make([]int64, len(latencyBounds)+1)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	s := u.services[service]
+	if s == nil {
+		s = &ServiceUptime{}
+		u.services[service] = s
+	}
+	if hourly {
+		bucket(&s.Hourly, saved.Start, uptimeHours).add(&saved)
+	} else {
+		bucket(&s.Daily, saved.Start, uptimeDays).add(&saved)
+	}
+}
+
+// UptimeSummary is a service's history as shown on the status page.
+type UptimeSummary struct {
+	Service string `json:"service"`
+	// Uptime24h and Uptime30d are percentages, -1 without data.
+	Uptime24h float64 `json:"uptime_24h"`
+	Uptime30d float64 `json:"uptime_30d"`
+	P95Ms24h  int64   `json:"p95_ms_24h"`
+	Days      []Day   `json:"days"`
+}
+
+// Day is a day on the status page; Uptime is -1 without data.
+type Day struct {
+	Date   string  `json:"date"`
+	Uptime float64 `json:"uptime"`
+	P95Ms  int64   `json:"p95_ms"`
+}
+
+// Summaries returns the status page data of the given services, the last
+// 90 days each.
+func (u *UptimeHistory) Summaries(services []string, now time.Time) []UptimeSummary {
+	snap := u.Snapshot()
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	summaries := make([]UptimeSummary, 0, len(services))
+	for _, name := range services {
+		s := snap[name]
+		sum := UptimeSummary{Service: name}
+		day := UptimeBucket{Latency: make([]int64, len(latencyBounds)+1)}
+		for _, b := range s.Hourly {
+			if now.Sub(b.Start) < 24*time.Hour {
+				day.add(&b)
+			}
+		}
+		sum.Uptime24h = percent(&day)
+		sum.P95Ms24h = day.LatencyP95().Milliseconds()
+
+		month := UptimeBucket{Latency: make([]int64, len(latencyBounds)+1)}
+		byDate := make(map[time.Time]*UptimeBucket, len(s.Daily))
+		for i := range s.Daily {
+			b := &s.Daily[i]
+			byDate[b.Start] = b
+			if today.Sub(b.Start) < 30*24*time.Hour {
+				month.add(b)
+			}
+		}
+		sum.Uptime30d = percent(&month)
+		for i := uptimeDays - 1; i >= 0; i-- {
+			date := today.AddDate(0, 0, -i)
+			d := Day{Date: date.Format("2006-01-02"), Uptime: -1}
+			if b := byDate[date]; b != nil {
+				d.Uptime = percent(b)
+				d.P95Ms = b.LatencyP95().Milliseconds()
+			}
+			sum.Days = append(sum.Days, d)
+		}
+		summaries = append(summaries, sum)
+	}
+	return summaries
+}
+
+func percent(b *UptimeBucket) float64 {
+	a, ok := b.Availability()
+	if !ok {
+		return -1
+	}
+	return float64(int64(a*10000)) / 100
+}
+
+// serviceNames returns the names of the routed services, sorted.
+func (ph *RuntimeMux) serviceNames() []string {
+	ph.RLock()
+	defer ph.RUnlock()
+	var names []string
+	for _, service := range ph.proxyServers {
+		if service != nil && service.Annotations[AnnotationStatusPage] != "hidden" {
+			names = append(names, service.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+var statusPage = template.Must(template.New("status").Funcs(template.FuncMap{
+	"color": func(uptime float64) string {
+		switch {
+		case uptime < 0:
+			return "#d1d5db"
+		case uptime >= 99.9:
+			return "#16a34a"
+		case uptime >= 99:
+			return "#ca8a04"
+		}
+		return "#dc2626"
+	},
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width">
+<title>Status</title>
+<style>
+body{font:14px/1.5 system-ui,sans-serif;max-width:760px;margin:2em auto;padding:0 1em;color:#111}
+.svc{margin:1.5em 0}.bars{display:flex;gap:1px;height:28px}.bars span{flex:1;border-radius:1px}
+.meta{color:#555;font-size:12px}
+</style></head><body>
+<h1>Status</h1>
+{{range .}}<div class="svc">
+<strong>{{.Service}}</strong>
+<span class="meta">{{if ge .Uptime30d 0.0}}{{printf "%.2f" .Uptime30d}}% uptime (30 days){{else}}no data yet{{end}}{{if .P95Ms24h}}, p95 {{.P95Ms24h}} ms (24 hours){{end}}</span>
+<div class="bars">{{range .Days}}<span style="background:{{color .Uptime}}" title="{{.Date}}{{if ge .Uptime 0.0}}: {{printf "%.2f" .Uptime}}%{{end}}"></span>{{end}}</div>
+</div>{{end}}
+<p class="meta">Last 90 days, UTC.</p>
+</body></html>
+`))
+
+// StatusPage serves the public status page: uptime history of every
+// service as HTML, or as JSON with Accept: application/json.
+func (ph *RuntimeMux) StatusPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		summaries := ph.Uptime.Summaries(ph.serviceNames(), time.Now())
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			admin.WriteJSON(w, http.StatusOK, summaries)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		statusPage.Execute(w, summaries)
+	})
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/proxy/proxytest"
+)
+
+func TestUptimeRollups(t *testing.T) {
+	u := NewUptimeHistory()
+	now := time.Date(2026, 3, 10, 12, 30, 0, 0, time.UTC)
+	for i := 0; i < 99; i++ {
+		u.observe("blog", now, 20*time.Millisecond, false)
+	}
+	u.observe("blog", now, 3*time.Second, true)
+	u.observe("blog", now.AddDate(0, 0, -1), 20*time.Millisecond, false)
+	u.observeCheck(CheckResult{Service: "shop", CheckedAt: now, OK: true})
+	u.observeCheck(CheckResult{Service: "shop", CheckedAt: now, OK: false})
+
+	// A restart restores the history and keeps adding to it.
+	restored := NewUptimeHistory()
+	data, _ := json.Marshal(u.Snapshot())
+	var snap map[string]ServiceUptime
+	json.Unmarshal(data, &snap)
+	restored.Restore(snap)
+	restored.observe("blog", now, 20*time.Millisecond, false)
+
+	sums := restored.Summaries([]string{"blog", "shop", "new"}, now)
+	blog, shop, fresh := sums[0], sums[1], sums[2]
+	if blog.Uptime24h != 99.00 || blog.P95Ms24h != 25 {
+		t.Errorf("Unexpected blog 24h uptime %v and p95 %d", blog.Uptime24h, blog.P95Ms24h)
+	}
+	if len(blog.Days) != 90 || blog.Days[89].Date != "2026-03-10" || blog.Days[88].Uptime != 100 || blog.Days[87].Uptime != -1 {
+		t.Errorf("Unexpected days: %+v", blog.Days[87:])
+	}
+	if shop.Uptime30d != 50 {
+		t.Errorf("Expected checks to decide shop's uptime, got %v", shop.Uptime30d)
+	}
+	if fresh.Uptime30d != -1 || fresh.Days[89].Uptime != -1 {
+		t.Errorf("Expected no data for a new service, got %+v", fresh)
+	}
+}
+
+func TestStatusPage(t *testing.T) {
+	backend := proxytest.NewFakeBackend(t, "blog")
+	mux := NewRuntimeMux()
+	mux.Uptime = NewUptimeHistory()
+	blog, _ := NewService("blog", "/blog/", backend.URL)
+	secret, _ := NewService("secret", "/secret/", backend.URL)
+	secret.SetAnnotation(AnnotationStatusPage, "hidden")
+	mux.AddProxy(blog)
+	mux.AddProxy(secret)
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/blog/", nil))
+
+	w := httptest.NewRecorder()
+	mux.StatusPage().ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	page := w.Body.String()
+	if !strings.Contains(page, "<strong>blog</strong>") || !strings.Contains(page, "100.00% uptime") || strings.Contains(page, "secret") {
+		t.Errorf("Unexpected status page:\n%s", page)
+	}
+
+	r := httptest.NewRequest("GET", "/status", nil)
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	mux.StatusPage().ServeHTTP(w, r)
+	var sums []UptimeSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &sums); err != nil || len(sums) != 1 || sums[0].Uptime24h != 100 {
+		t.Errorf("Unexpected JSON %s: %v", w.Body, err)
+	}
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// EnvironmentHeader names the environment of a watermarked service on each
+// of its responses.
+const EnvironmentHeader = "X-Environment"
+
+// maxWatermarkedPage caps the HTML pages buffered to add the banner to;
+// larger pages are passed through with the header only.
+const maxWatermarkedPage = 4 << 20
+
+// WatermarkConfig marks a service's responses as coming from a non-production
+// environment, so mounted staging or preview apps are never mistaken for
+// production.
+type WatermarkConfig struct {
+	// Label names the environment, e.g. "staging".
+	Label string `json:"label"`
+	// Banner adds a strip reading "<label> environment" to HTML pages, in
+	// addition to the EnvironmentHeader header.
+	Banner bool `json:"banner,omitempty"`
+}
+
+// EnableWatermark marks the service's responses; an empty label turns it
+// off.
+func (s *Service) EnableWatermark(cfg WatermarkConfig) error {
+	if cfg.Label == "" {
+		s.Watermark = nil
+		return nil
+	}
+	if len(cfg.Label) > 64 || !validHeaderValue(cfg.Label) {
+		return errors.New("watermark label must be a short printable string")
+	}
+	s.Watermark = &cfg
+	return nil
+}
+
+func validHeaderValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if v[i] < ' ' || v[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// withWatermark marks the response to r. The returned func must be called
+// once the response is complete.
+func (s *Service) withWatermark(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	cfg := s.Watermark
+	if cfg == nil {
+		return w, r, func() {}
+	}
+	w.Header().Set(EnvironmentHeader, cfg.Label)
+	if !cfg.Banner || r.Method != http.MethodGet {
+		return w, r, func() {}
+	}
+	// The banner is added to the plain page, so the backend must not
+	// compress it.
+	r = r.Clone(r.Context())
+	r.Header.Del("Accept-Encoding")
+	ww := &watermarkWriter{ResponseWriter: w, banner: banner(cfg.Label)}
+	return ww, r, ww.finish
+}
+
+func banner(label string) []byte {
+	return []byte(fmt.Sprintf(`<div role="note" style="position:fixed;left:0;right:0;bottom:0;z-index:2147483647;padding:2px 0;background:#b45309;color:#fff;font:bold 12px/1.4 sans-serif;text-align:center;pointer-events:none">%s environment</div>`, html.EscapeString(label)))
+}
+
+// watermarkWriter buffers a successful HTML page to add the banner before
+// its closing body tag, and passes anything else through.
+type watermarkWriter struct {
+	http.ResponseWriter
+	banner []byte
+
+	wroteHeader bool
+	status      int
+	buffering   bool
+	body        bytes.Buffer
+}
+
+func (w *watermarkWriter) WriteHeader(code int) {
+	if w.wroteHeader || code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+	// Pages announcing trailers are streamed as they are: a rewritten page
+	// is sent with a Content-Length, which leaves no room for trailers.
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if code == http.StatusOK && mediaType == "text/html" && w.Header().Get("Content-Encoding") == "" && w.Header().Get("Trailer") == "" {
+		w.buffering, w.status = true, code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *watermarkWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.buffering {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.body.Len()+len(p) <= maxWatermarkedPage {
+		return w.body.Write(p)
+	}
+	// Too large to rewrite: send what we have unchanged.
+	w.buffering = false
+	w.ResponseWriter.WriteHeader(w.status)
+	if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+		return 0, err
+	}
+	w.body = bytes.Buffer{}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush is a no-op while the page is buffered.
+func (w *watermarkWriter) Flush() {
+	if w.buffering {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *watermarkWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish sends a buffered page with the banner.
+func (w *watermarkWriter) finish() {
+	if !w.buffering {
+		return
+	}
+	page := w.body.Bytes()
+	at := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if at < 0 {
+		at = len(page)
+	}
+	out := make([]byte, 0, len(page)+len(w.banner))
+	out = append(append(append(out, page[:at]...), w.banner...), page[at:]...)
+	w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+	w.Header().Del("ETag")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(out)
+}
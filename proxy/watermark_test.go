@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/proxy/proxytest"
+)
+
+func TestWatermark(t *testing.T) {
+	backend := proxytest.NewFakeBackend(t, "preview")
+	backend.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/preview/data" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><BODY><h1>Hi</h1>"))
+		w.Write([]byte("</BODY></html>"))
+	}))
+
+	mux := NewRuntimeMux()
+	service, _ := NewService("preview", "/preview/", backend.URL)
+	if err := service.EnableWatermark(WatermarkConfig{Label: "bad\nlabel"}); err == nil {
+		t.Error("Expected a label with a newline to be rejected")
+	}
+	service.EnableWatermark(WatermarkConfig{Label: "<staging>", Banner: true})
+	mux.AddProxy(service)
+
+	r := httptest.NewRequest("GET", "/preview/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	page := w.Body.String()
+	if w.Header().Get(EnvironmentHeader) != "<staging>" {
+		t.Errorf("Expected the environment header, got %q", w.Header().Get(EnvironmentHeader))
+	}
+	if !strings.Contains(page, "&lt;staging&gt; environment</div></BODY>") {
+		t.Errorf("Expected the escaped banner before </BODY>, got %s", page)
+	}
+	if n, _ := strconv.Atoi(w.Header().Get("Content-Length")); n != len(page) {
+		t.Errorf("Content-Length %d does not match the page's %d bytes", n, len(page))
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/preview/data", nil))
+	if w.Body.String() != `{"ok":true}` || w.Header().Get(EnvironmentHeader) != "<staging>" {
+		t.Errorf("Expected other content unchanged with the header, got %q", w.Body)
+	}
+
+	off := *service
+	off.EnableWatermark(WatermarkConfig{})
+	mux.AddProxy(&off)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/preview/", nil))
+	if strings.Contains(w.Body.String(), "environment") || w.Header().Get(EnvironmentHeader) != "" {
+		t.Error("Expected no watermark once removed")
+	}
+}
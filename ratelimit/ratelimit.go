@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limiter is a set of token buckets keyed by an arbitrary string (client IP,
+// API key identity, ...). Every bucket refills at rate tokens per second up
+// to burst tokens.
+type Limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[string]*bucket
+
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func New(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket for key, reporting whether one was
+// available.
+func (l *Limiter) Allow(key string) bool {
+	return l.Take(key).Allowed
+}
+
+// Status is the state of a bucket after a request, for RateLimit headers.
+type Status struct {
+	Allowed bool
+	// Limit is the burst size and Remaining the whole tokens left.
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again and RetryAfter the
+	// time until the next token, zero if one is available.
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Take is like Allow but also returns the state of the bucket.
+func (l *Limiter) Take(key string) Status {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, l.rate, l.burst)
+
+	s := Status{Limit: l.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		s.Allowed = true
+	}
+	s.Remaining = int(b.tokens)
+	if l.rate > 0 {
+		s.Reset = time.Duration((float64(l.burst) - b.tokens) / l.rate * float64(time.Second))
+		if b.tokens < 1 {
+			s.RetryAfter = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		}
+	}
+	return s
+}
+
+// SetHeaders sets the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers of the IETF draft, in whole seconds rounded up,
+// and Retry-After on limited requests. Headers already set by a limiter
+// with fewer remaining requests are kept, so clients see the tightest one.
+func (s Status) SetHeaders(h http.Header) {
+	if prev, err := strconv.Atoi(h.Get("RateLimit-Remaining")); err == nil && prev < s.Remaining {
+		return
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(s.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(s.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(s.Reset)))
+	if !s.Allowed {
+		h.Set("Retry-After", strconv.Itoa(max(1, seconds(s.RetryAfter))))
+	}
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func (b *bucket) refill(now time.Time, rate float64, burst int) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+}
+
+// prune drops buckets that have refilled completely, since they are
+// indistinguishable from new ones. Callers must hold l.mu.
+func (l *Limiter) prune(now time.Time) {
+	if l.rate <= 0 || now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+
+	full := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Bucket is the saved state of a token bucket.
+type Bucket struct {
+	Tokens float64   `json:"tokens"`
+	Last   time.Time `json:"last"`
+}
+
+// Snapshot returns the buckets that are not full; missing keys have a full
+// bucket.
+func (l *Limiter) Snapshot() map[string]Bucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	buckets := make(map[string]Bucket, len(l.buckets))
+	for key, b := range l.buckets {
+		current := *b
+		current.refill(now, l.rate, l.burst)
+		if current.tokens < float64(l.burst) {
+			buckets[key] = Bucket{Tokens: b.tokens, Last: b.last}
+		}
+	}
+	return buckets
+}
+
+// Restore adds saved buckets, replacing current ones with the same key.
+// They refill for the time since they were saved, and are capped at the
+// current burst in case it was lowered.
+func (l *Limiter) Restore(buckets map[string]Bucket) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, saved := range buckets {
+		b := &bucket{tokens: saved.Tokens, last: saved.Last}
+		if b.tokens > float64(l.burst) {
+			b.tokens = float64(l.burst)
+		}
+		l.buckets[key] = b
+	}
+}
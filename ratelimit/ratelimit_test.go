@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := New(1, 2)
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("a") || !l.Allow("a") {
+		t.Fatal("Expected burst to be allowed")
+	}
+	if l.Allow("a") {
+		t.Error("Expected request beyond burst to be limited")
+	}
+	if !l.Allow("b") {
+		t.Error("Expected separate key to have its own bucket")
+	}
+
+	now = now.Add(time.Second)
+	if !l.Allow("a") {
+		t.Error("Expected bucket to refill after one second")
+	}
+	if l.Allow("a") {
+		t.Error("Expected only one token to have refilled")
+	}
+}
+
+func TestLimiterPrune(t *testing.T) {
+	now := time.Now()
+	l := New(10, 1)
+	l.now = func() time.Time { return now }
+
+	l.Allow("a")
+	now = now.Add(2 * time.Minute)
+	l.Allow("b")
+
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("Expected refilled bucket to be pruned")
+	}
+}
+
+func TestLimiterSnapshotRestore(t *testing.T) {
+	now := time.Now()
+	l := New(1, 2)
+	l.now = func() time.Time { return now }
+	l.Allow("a")
+	l.Allow("a")
+	l.Allow("b")
+	l.Allow("b")
+	now = now.Add(5 * time.Second)
+	l.Allow("c")
+
+	saved := l.Snapshot()
+	if len(saved) != 1 || saved["c"].Tokens != 1 {
+		t.Fatalf("Expected only the bucket of c to be saved, got %v", saved)
+	}
+
+	restarted := New(1, 2)
+	restarted.now = func() time.Time { return now }
+	restarted.Restore(saved)
+	if !restarted.Allow("c") || restarted.Allow("c") {
+		t.Error("Expected the restored bucket to keep its single token")
+	}
+}
+
+func TestStatusHeaders(t *testing.T) {
+	now := time.Now()
+	l := New(0.5, 3)
+	l.now = func() time.Time { return now }
+
+	h := http.Header{}
+	l.Take("a").SetHeaders(h)
+	if h.Get("RateLimit-Limit") != "3" || h.Get("RateLimit-Remaining") != "2" || h.Get("RateLimit-Reset") != "2" || h.Get("Retry-After") != "" {
+		t.Errorf("Unexpected headers after the first request: %v", h)
+	}
+
+	l.Take("a")
+	l.Take("a")
+	limited := l.Take("a")
+	h = http.Header{}
+	limited.SetHeaders(h)
+	if limited.Allowed || h.Get("RateLimit-Remaining") != "0" || h.Get("RateLimit-Reset") != "6" || h.Get("Retry-After") != "2" {
+		t.Errorf("Unexpected headers when limited: %v", h)
+	}
+
+	// A looser limiter does not replace the headers of a tighter one.
+	New(10, 100).Take("a").SetHeaders(h)
+	if h.Get("RateLimit-Limit") != "3" {
+		t.Errorf("Expected the tighter limit to be kept, got %v", h)
+	}
+}
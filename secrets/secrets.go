@@ -0,0 +1,207 @@
+// Package secrets reads credentials from files, environment variables or an
+// HTTP secrets endpoint and caches them for a refresh interval, so a rotated
+// secret is picked up without a restart.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSecretSize caps the secrets read from files and endpoints.
+const maxSecretSize = 64 << 10
+
+// minRefetch is how soon a secret is fetched again after a failed refresh
+// or after being invalidated.
+const minRefetch = 10 * time.Second
+
+// Source fetches the current value of a secret.
+type Source interface {
+	Fetch(ctx context.Context) (string, error)
+}
+
+// Parse returns the source a reference names:
+//
+//	file:/run/secrets/token          the file's contents
+//	env:SHOP_TOKEN                   an environment variable
+//	http://127.0.0.1:8200/v1/x#a.b   the response body of a GET, or the
+//	                                 string at a dotted path of its JSON
+//
+// Surrounding whitespace is trimmed. HTTP endpoints are meant to be local
+// agents, such as Vault Agent or a cloud metadata service, and are sent no
+// credentials of their own.
+func Parse(ref string) (Source, error) {
+	switch {
+	case strings.HasPrefix(ref, "file:"):
+		path := strings.TrimPrefix(ref, "file:")
+		if path == "" {
+			return nil, errors.New("file secret needs a path")
+		}
+		return fileSource(path), nil
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		if name == "" {
+			return nil, errors.New("env secret needs a variable name")
+		}
+		return envSource(name), nil
+	case strings.HasPrefix(ref, "http://"), strings.HasPrefix(ref, "https://"):
+		u, err := url.Parse(ref)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid secret URL %q", ref)
+		}
+		field := u.Fragment
+		u.Fragment = ""
+		return httpSource{url: u.String(), field: field}, nil
+	}
+	return nil, fmt.Errorf("unsupported secret %q, expected file:, env:, http:// or https://", ref)
+}
+
+type fileSource string
+
+func (f fileSource) Fetch(context.Context) (string, error) {
+	file, err := os.Open(string(f))
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %v", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxSecretSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %v", err)
+	}
+	return nonEmpty(string(data))
+}
+
+type envSource string
+
+func (e envSource) Fetch(context.Context) (string, error) {
+	return nonEmpty(os.Getenv(string(e)))
+}
+
+type httpSource struct {
+	url   string
+	field string
+}
+
+func (h httpSource) Fetch(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create secret request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch secret: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch secret: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSecretSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch secret: %v", err)
+	}
+	if h.field == "" {
+		return nonEmpty(string(data))
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "", fmt.Errorf("failed to parse secret: %v", err)
+	}
+	for _, key := range strings.Split(h.field, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("secret has no field %s", h.field)
+		}
+		v = obj[key]
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("secret field %s is not a string", h.field)
+	}
+	return nonEmpty(s)
+}
+
+func nonEmpty(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", errors.New("secret is empty")
+	}
+	return s, nil
+}
+
+// Cache keeps the value of a source, fetching it again once it is older
+// than the refresh interval.
+type Cache struct {
+	source  Source
+	refresh time.Duration
+
+	mu         sync.Mutex
+	value      string
+	fetched    time.Time
+	refreshing bool
+	now        func() time.Time
+}
+
+// NewCache returns a cache of source refreshed every refresh.
+func NewCache(source Source, refresh time.Duration) *Cache {
+	return &Cache{source: source, refresh: refresh, now: time.Now}
+}
+
+// Get returns the secret. The first call fetches it; later ones return the
+// cached value and, once it is stale, refresh it in the background, keeping
+// the old value if the refresh fails.
+func (c *Cache) Get(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if c.value == "" {
+		c.mu.Unlock()
+		value, err := c.source.Fetch(ctx)
+		if err != nil {
+			return "", err
+		}
+		c.mu.Lock()
+		c.value, c.fetched = value, c.now()
+		c.mu.Unlock()
+		return value, nil
+	}
+	value := c.value
+	if c.now().Sub(c.fetched) >= c.refresh && !c.refreshing {
+		c.refreshing = true
+		go c.update()
+	}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// Invalidate marks the cached value stale, e.g. after the backend rejected
+// it, so the next Get refreshes it, unless it was fetched within minRefetch.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.now().Sub(c.fetched) >= minRefetch {
+		c.fetched = time.Time{}
+	}
+}
+
+func (c *Cache) update() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	value, err := c.source.Fetch(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	if err != nil {
+		log.Printf("Keeping the previous secret: %v", err)
+		c.fetched = c.now().Add(min(minRefetch, c.refresh) - c.refresh)
+		return
+	}
+	c.value, c.fetched = value, c.now()
+}
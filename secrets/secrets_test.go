@@ -0,0 +1,84 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(path, []byte("from-file\n"), 0600)
+	t.Setenv("PROXY_TEST_SECRET", "from-env")
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"token": "from-agent"}}`))
+	}))
+	defer agent.Close()
+
+	for ref, want := range map[string]string{
+		"file:" + path:                    "from-file",
+		"env:PROXY_TEST_SECRET":           "from-env",
+		agent.URL + "/v1/shop#data.token": "from-agent",
+	} {
+		source, err := Parse(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := source.Fetch(context.Background()); err != nil || got != want {
+			t.Errorf("%s: got %q, %v; want %q", ref, got, err, want)
+		}
+	}
+	if _, err := Parse("vault:shop"); err == nil {
+		t.Error("Expected an unsupported reference to be rejected")
+	}
+	unset, _ := Parse("env:PROXY_TEST_UNSET")
+	if _, err := unset.Fetch(context.Background()); err == nil {
+		t.Error("Expected an empty secret to fail")
+	}
+}
+
+type countingSource struct {
+	value string
+	calls chan struct{}
+}
+
+func (c *countingSource) Fetch(context.Context) (string, error) {
+	c.calls <- struct{}{}
+	return c.value, nil
+}
+
+func TestCacheRefresh(t *testing.T) {
+	source := &countingSource{value: "v1", calls: make(chan struct{}, 10)}
+	now := time.Now()
+	c := NewCache(source, time.Minute)
+	c.now = func() time.Time { return now }
+
+	if v, _ := c.Get(context.Background()); v != "v1" {
+		t.Fatalf("Expected v1, got %q", v)
+	}
+	<-source.calls
+	c.Get(context.Background())
+	if len(source.calls) != 0 {
+		t.Error("Expected a fresh secret to be served from the cache")
+	}
+
+	// A stale secret is served while it is refreshed.
+	source.value = "v2"
+	now = now.Add(time.Minute)
+	if v, _ := c.Get(context.Background()); v != "v1" {
+		t.Errorf("Expected the stale secret while refreshing, got %q", v)
+	}
+	<-source.calls
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if v, _ := c.Get(context.Background()); v == "v2" {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("Expected the refreshed secret")
+}
@@ -0,0 +1,269 @@
+// Package shortlinks is a built-in redirect service mapping short codes to
+// URLs, such as vanity links for the portfolio, kept in a storage.Store so
+// no extra backend is needed.
+package shortlinks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+)
+
+var (
+	codePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	errInvalid  = errors.New("invalid link")
+	errNotFound = errors.New("no such link")
+)
+
+// Link redirects requests for Code to Target.
+type Link struct {
+	Code string `json:"code"`
+	// Target is an absolute http(s) URL or a path on the same host.
+	Target string `json:"target"`
+	// Permanent redirects with 301 Moved Permanently instead of 302 Found,
+	// letting browsers cache the redirect.
+	Permanent bool      `json:"permanent,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// Links serves and manages the short links.
+type Links struct {
+	mu    sync.RWMutex
+	store storage.Store
+	key   string
+	links map[string]Link
+}
+
+// New returns the links saved in store under key. A nil store keeps them in
+// memory only.
+func New(store storage.Store, key string) (*Links, error) {
+	l := &Links{store: store, key: key, links: make(map[string]Link)}
+	if store == nil {
+		return l, nil
+	}
+	data, err := store.Get(context.Background(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read short links: %v", err)
+	}
+	if l.links, err = parseLinks(data); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func parseLinks(data []byte) (map[string]Link, error) {
+	var list []Link
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse short links: %v", err)
+	}
+	links := make(map[string]Link, len(list))
+	for _, link := range list {
+		links[link.Code] = link
+	}
+	return links, nil
+}
+
+// Follow picks up the links other instances sharing the store save to it,
+// until ctx is done.
+func (l *Links) Follow(ctx context.Context) {
+	if l.store == nil {
+		return
+	}
+	for data := range l.store.Watch(ctx, l.key) {
+		if data == nil {
+			continue
+		}
+		links, err := parseLinks(data)
+		if err != nil {
+			log.Printf("Ignoring short links from the store: %v", err)
+			continue
+		}
+		l.mu.Lock()
+		l.links = links
+		l.mu.Unlock()
+	}
+}
+
+// List returns the links ordered by code.
+func (l *Links) List() []Link {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	links := make([]Link, 0, len(l.links))
+	for _, link := range l.links {
+		links = append(links, link)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Code < links[j].Code })
+	return links
+}
+
+// Get returns the link with the given code.
+func (l *Links) Get(code string) (Link, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	link, ok := l.links[code]
+	return link, ok
+}
+
+// Set adds or replaces a link, keeping the creation time of the one it
+// replaces.
+func (l *Links) Set(link Link) (Link, error) {
+	if !codePattern.MatchString(link.Code) {
+		return Link{}, fmt.Errorf("%w: code must be 1-64 letters, digits, - or _", errInvalid)
+	}
+	if err := checkTarget(link.Target); err != nil {
+		return Link{}, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	link.Created = time.Now().UTC().Truncate(time.Second)
+	prev, existed := l.links[link.Code]
+	if existed {
+		link.Created = prev.Created
+	}
+	l.links[link.Code] = link
+	if err := l.save(); err != nil {
+		if existed {
+			l.links[link.Code] = prev
+		} else {
+			delete(l.links, link.Code)
+		}
+		return Link{}, err
+	}
+	return link, nil
+}
+
+func checkTarget(target string) error {
+	if strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+		return nil
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%w: target must be an http(s) URL or a path", errInvalid)
+	}
+	return nil
+}
+
+// Delete removes a link, reporting whether it existed.
+func (l *Links) Delete(code string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	link, ok := l.links[code]
+	if !ok {
+		return false, nil
+	}
+	delete(l.links, code)
+	if err := l.save(); err != nil {
+		l.links[code] = link
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes the links to the store. Callers must hold l.mu.
+func (l *Links) save() error {
+	if l.store == nil {
+		return nil
+	}
+	links := make([]Link, 0, len(l.links))
+	for _, link := range l.links {
+		links = append(links, link)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Code < links[j].Code })
+	data, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := l.store.Put(context.Background(), l.key, data); err != nil {
+		return fmt.Errorf("failed to save short links: %v", err)
+	}
+	return nil
+}
+
+// ServeHTTP redirects GET and HEAD requests for /{code} to the link's
+// target.
+func (l *Links) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	link, ok := l.Get(strings.TrimPrefix(r.URL.Path, "/"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	status := http.StatusFound
+	if link.Permanent {
+		status = http.StatusMovedPermanently
+	}
+	http.Redirect(w, r, link.Target, status)
+}
+
+// AdminHandler manages the links:
+//
+//	GET    /          every link
+//	GET    /{code}    one link
+//	PUT    /{code}    {"target": "...", "permanent": false}; adds or replaces it
+//	DELETE /{code}    remove it
+func (l *Links) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, l.List())
+	})
+	mux.HandleFunc("GET /{code}", func(w http.ResponseWriter, r *http.Request) {
+		link, ok := l.Get(r.PathValue("code"))
+		if !ok {
+			admin.WriteError(w, http.StatusNotFound, errNotFound)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, link)
+	})
+	mux.HandleFunc("PUT /{code}", func(w http.ResponseWriter, r *http.Request) {
+		var link Link
+		if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		link.Code = r.PathValue("code")
+		link, err := l.Set(link)
+		if err != nil {
+			admin.WriteError(w, errorStatus(err), err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, link)
+	})
+	mux.HandleFunc("DELETE /{code}", func(w http.ResponseWriter, r *http.Request) {
+		found, err := l.Delete(r.PathValue("code"))
+		switch {
+		case err != nil:
+			admin.WriteError(w, errorStatus(err), err)
+		case !found:
+			admin.WriteError(w, http.StatusNotFound, errNotFound)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	return mux
+}
+
+func errorStatus(err error) int {
+	if errors.Is(err, errInvalid) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
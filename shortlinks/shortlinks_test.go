@@ -0,0 +1,65 @@
+package shortlinks
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+)
+
+func TestLinks(t *testing.T) {
+	store := storage.NewMemory()
+	links, err := New(store, "links.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := links.AdminHandler()
+	put := func(code, body string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("PUT", "/"+code, strings.NewReader(body)))
+		return w.Code
+	}
+	if code := put("cv", `{"target": "https://example.com/cv.pdf", "permanent": true}`); code != http.StatusOK {
+		t.Fatalf("Expected the link to be added, got %d", code)
+	}
+	put("blog", `{"target": "/projects/blog/"}`)
+	for code, body := range map[string]string{"cv": `{"target": "javascript:alert(1)"}`, "bad%20code": `{"target": "/"}`, "x": `{"target": "//evil.example"}`} {
+		if status := put(code, body); status != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", code, status)
+		}
+	}
+
+	for path, want := range map[string]struct {
+		status   int
+		location string
+	}{
+		"/cv":      {http.StatusMovedPermanently, "https://example.com/cv.pdf"},
+		"/blog":    {http.StatusFound, "/projects/blog/"},
+		"/missing": {http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		links.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != want.status || w.Header().Get("Location") != want.location {
+			t.Errorf("%s: expected %d to %q, got %d to %q", path, want.status, want.location, w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	reloaded, err := New(store, "links.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := reloaded.List(); len(list) != 2 || list[0].Code != "blog" || list[1].Created.IsZero() {
+		t.Errorf("Expected the links to be saved, got %+v", list)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("DELETE", "/cv", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected the link to be deleted, got %d", w.Code)
+	}
+	if _, ok := links.Get("cv"); ok {
+		t.Error("Expected the link to be gone")
+	}
+}
@@ -0,0 +1,100 @@
+// Package shutdown summarizes a run of the proxy when it stops, so that an
+// unexpected restart leaves a record of what it cut short.
+package shutdown
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Conns counts the open connections of the servers it is installed in.
+type Conns struct {
+	open atomic.Int64
+}
+
+// Track returns a http.Server.ConnState hook counting connections before
+// calling next, which may be nil. A hijacked connection, such as a
+// WebSocket, is no longer counted.
+func (c *Conns) Track(next func(net.Conn, http.ConnState)) func(net.Conn, http.ConnState) {
+	return func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			c.open.Add(1)
+		case http.StateClosed, http.StateHijacked:
+			c.open.Add(-1)
+		}
+		if next != nil {
+			next(conn, state)
+		}
+	}
+}
+
+// Open returns the number of open connections.
+func (c *Conns) Open() int64 {
+	return c.open.Load()
+}
+
+// Report is the summary of a run.
+type Report struct {
+	// Reason is what stopped the proxy, e.g. a signal or a server error.
+	Reason  string    `json:"reason"`
+	Started time.Time `json:"started"`
+	Stopped time.Time `json:"stopped"`
+	Uptime  string    `json:"uptime"`
+	// Requests is the number of requests received; InFlight of those were
+	// still being served when shutdown began.
+	Requests int64 `json:"requests"`
+	InFlight int   `json:"in_flight"`
+	// Connections were open when shutdown began. Drained of them closed
+	// gracefully and Aborted were cut when the shutdown timeout expired.
+	Connections int64 `json:"connections"`
+	Drained     int64 `json:"drained"`
+	Aborted     int64 `json:"aborted"`
+	// Services holds the requests served by each service.
+	Services map[string]int64 `json:"services"`
+}
+
+// String formats the report as a single log line.
+func (r Report) String() string {
+	names := make([]string, 0, len(r.Services))
+	for name := range r.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	services := make([]string, len(names))
+	for i, name := range names {
+		services[i] = fmt.Sprintf("%s=%d", name, r.Services[name])
+	}
+	return fmt.Sprintf("reason=%q uptime=%s requests=%d in_flight=%d connections=%d drained=%d aborted=%d services=[%s]",
+		r.Reason, r.Uptime, r.Requests, r.InFlight, r.Connections, r.Drained, r.Aborted, strings.Join(services, " "))
+}
+
+// Post sends the report as JSON to webhook.
+func (r Report) Post(ctx context.Context, webhook string) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode shutdown report: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post shutdown report: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to post shutdown report: webhook returned %s", resp.Status)
+	}
+	return nil
+}
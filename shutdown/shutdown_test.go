@@ -0,0 +1,56 @@
+package shutdown
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConnsAndReport(t *testing.T) {
+	var conns Conns
+	var seen []http.ConnState
+	track := conns.Track(func(_ net.Conn, state http.ConnState) { seen = append(seen, state) })
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	track(a, http.StateNew)
+	track(b, http.StateNew)
+	track(a, http.StateActive)
+	track(b, http.StateHijacked)
+	if conns.Open() != 1 || len(seen) != 4 {
+		t.Errorf("Expected 1 open connection and every state passed on, got %d and %v", conns.Open(), seen)
+	}
+
+	started := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	report := Report{
+		Reason:      "signal: terminated",
+		Started:     started,
+		Stopped:     started.Add(time.Hour),
+		Uptime:      time.Hour.String(),
+		Requests:    42,
+		Connections: 3,
+		Drained:     2,
+		Aborted:     1,
+		Services:    map[string]int64{"shop": 30, "blog": 10},
+	}
+	want := `reason="signal: terminated" uptime=1h0m0s requests=42 in_flight=0 connections=3 drained=2 aborted=1 services=[blog=10 shop=30]`
+	if got := report.String(); got != want {
+		t.Errorf("Unexpected log line:\n%s\nwant\n%s", got, want)
+	}
+
+	var posted Report
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&posted)
+	}))
+	defer webhook.Close()
+	if err := report.Post(context.Background(), webhook.URL); err != nil {
+		t.Fatal(err)
+	}
+	if posted.Requests != 42 || posted.Services["shop"] != 30 || !posted.Started.Equal(started) {
+		t.Errorf("Unexpected posted report %+v", posted)
+	}
+}
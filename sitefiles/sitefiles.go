@@ -0,0 +1,210 @@
+// Package sitefiles serves files that belong to a host rather than to any
+// one backend, such as robots.txt, security.txt, the favicon and other
+// /.well-known/ paths, in front of the services routed on that host.
+package sitefiles
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kirtansoni/reverse-proxy-go/hostcheck"
+)
+
+// Paths of common managed files. Any path under WellKnownPrefix can be
+// managed too.
+const (
+	RobotsPath         = "/robots.txt"
+	FaviconPath        = "/favicon.ico"
+	SecurityTxtPath    = "/.well-known/security.txt"
+	ChangePasswordPath = "/.well-known/change-password"
+	WellKnownPrefix    = "/.well-known/"
+)
+
+// wellKnownTypes are the content types of well-known files without an
+// extension to tell them by.
+var wellKnownTypes = map[string]string{
+	"/.well-known/apple-app-site-association": "application/json",
+}
+
+func managedPath(path string) bool {
+	return path == RobotsPath || path == FaviconPath || strings.HasPrefix(path, WellKnownPrefix) && len(path) > len(WellKnownPrefix)
+}
+
+// maxMergedBody caps the backend file read when merging.
+const maxMergedBody = 64 << 10
+
+// File is what is served at a path: Content, the contents of the file at
+// Path, or a redirect.
+type File struct {
+	Content string `json:"content,omitempty"`
+	// Path is a file read at load time, e.g. for a binary favicon.
+	Path string `json:"path,omitempty"`
+	// Redirect sends clients to this URL with 302 Found, as for
+	// /.well-known/change-password.
+	Redirect string `json:"redirect,omitempty"`
+	// ContentType defaults to one derived from the path's extension.
+	ContentType string `json:"content_type,omitempty"`
+	// Merge appends Content to the backend's file, if it has one, instead
+	// of replacing it.
+	Merge bool `json:"merge,omitempty"`
+}
+
+// load reads Path into Content and checks the file makes sense for path.
+func (f *File) load(path string) error {
+	set := 0
+	for _, v := range []string{f.Content, f.Path, f.Redirect} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("%s: exactly one of content, path and redirect is required", path)
+	}
+	if f.Merge && f.Content == "" {
+		return fmt.Errorf("%s: only content can be merged", path)
+	}
+	if f.Path != "" {
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		f.Content, f.Path = string(data), ""
+	}
+	if f.ContentType == "" {
+		f.ContentType = wellKnownTypes[path]
+	}
+	if f.ContentType == "" {
+		f.ContentType = mime.TypeByExtension(filepath.Ext(path))
+	}
+	if f.ContentType == "" {
+		f.ContentType = "text/plain; charset=utf-8"
+	}
+	return nil
+}
+
+// Config maps host names to their files by path. The host "*" applies to
+// hosts that have no entry for a path.
+type Config map[string]map[string]File
+
+// Handler serves the files of a Config.
+type Handler struct {
+	files Config
+}
+
+func New(cfg Config) (*Handler, error) {
+	h := &Handler{files: make(Config)}
+	for host, files := range cfg {
+		if host != "*" {
+			host = hostcheck.Normalize(host)
+		}
+		loaded := make(map[string]File, len(files))
+		for path, f := range files {
+			if !managedPath(path) {
+				return nil, fmt.Errorf("host %s: %s is not a managed path", host, path)
+			}
+			if err := f.load(path); err != nil {
+				return nil, fmt.Errorf("host %s: %v", host, err)
+			}
+			loaded[path] = f
+		}
+		h.files[host] = loaded
+	}
+	return h, nil
+}
+
+// Load reads a Config from a JSON file.
+func Load(path string) (*Handler, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read site files: %v", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse site files: %v", err)
+	}
+	return New(cfg)
+}
+
+func (h *Handler) lookup(host, path string) (File, bool) {
+	if f, ok := h.files[hostcheck.Normalize(host)][path]; ok {
+		return f, true
+	}
+	f, ok := h.files["*"][path]
+	return f, ok
+}
+
+// Middleware serves the managed files and passes everything else to next,
+// which also provides the backend's file when merging.
+func (h *Handler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := h.lookup(r.Host, r.URL.Path)
+		if !ok || r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if f.Redirect != "" {
+			http.Redirect(w, r, f.Redirect, http.StatusFound)
+			return
+		}
+		content := f.Content
+		if f.Merge {
+			if backend := fetch(next, r); len(backend) > 0 {
+				content = string(bytes.TrimRight(backend, "\n")) + "\n\n" + content
+			}
+		}
+		w.Header().Set("Content-Type", f.ContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == http.MethodGet {
+			w.Write([]byte(content))
+		}
+	})
+}
+
+// fetch returns the body of a successful plain text response from next.
+func fetch(next http.Handler, r *http.Request) []byte {
+	r = r.Clone(r.Context())
+	r.Method = http.MethodGet
+	r.Header.Del("Accept-Encoding")
+	r.Header.Del("Range")
+	bw := &bufferWriter{header: make(http.Header)}
+	next.ServeHTTP(bw, r)
+	if bw.status != http.StatusOK || bw.header.Get("Content-Encoding") != "" || bw.overflow {
+		return nil
+	}
+	return bw.body.Bytes()
+}
+
+// bufferWriter collects a response in memory.
+type bufferWriter struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *bufferWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.body.Len()+len(p) > maxMergedBody {
+		w.overflow = true
+	} else {
+		w.body.Write(p)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,91 @@
+package sitefiles
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	h, err := New(Config{
+		"Shop.Example.com": {
+			RobotsPath:      {Content: "Disallow: /cart", Merge: true},
+			SecurityTxtPath: {Content: "Contact: mailto:security@example.com"},
+		},
+		"*": {RobotsPath: {Content: "User-agent: *\nDisallow: /"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	if _, err := New(Config{"*": {"/index.html": {}}}); err == nil {
+		t.Error("Expected an unmanaged path to be rejected")
+	}
+
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend " + r.URL.Path + "\n"))
+	})
+	handler := h.Middleware(backend)
+	get := func(host, path string) string {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	if got := get("shop.example.com:443", RobotsPath); got != "backend /robots.txt\n\nDisallow: /cart" {
+		t.Errorf("Expected merged robots.txt, got %q", got)
+	}
+	if got := get("shop.example.com", SecurityTxtPath); got != "Contact: mailto:security@example.com" {
+		t.Errorf("Expected overridden security.txt, got %q", got)
+	}
+	if got := get("other.example.com", RobotsPath); got != "User-agent: *\nDisallow: /" {
+		t.Errorf("Expected the default robots.txt, got %q", got)
+	}
+	if got := get("other.example.com", SecurityTxtPath); got != "backend /.well-known/security.txt\n" {
+		t.Errorf("Expected unmanaged files to reach the backend, got %q", got)
+	}
+}
+
+func TestWellKnownFiles(t *testing.T) {
+	icon := filepath.Join(t.TempDir(), "favicon.ico")
+	os.WriteFile(icon, []byte{0, 0, 1, 0}, 0600)
+	h, err := New(Config{"*": {
+		FaviconPath:        {Path: icon},
+		ChangePasswordPath: {Redirect: "https://accounts.example.com/password"},
+		"/.well-known/apple-app-site-association": {Content: `{"applinks": {}}`},
+		"/.well-known/assetlinks.json":            {Content: `[]`},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	for _, cfg := range []Config{
+		{"*": {"/.well-known/": {Content: "x"}}},
+		{"*": {FaviconPath: {Content: "x", Redirect: "/x"}}},
+		{"*": {ChangePasswordPath: {Redirect: "/x", Merge: true}}},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("Expected %v to be rejected", cfg)
+		}
+	}
+
+	handler := h.Middleware(http.NotFoundHandler())
+	for path, want := range map[string]string{
+		FaviconPath: "image/vnd.microsoft.icon",
+		"/.well-known/apple-app-site-association": "application/json",
+		"/.well-known/assetlinks.json":            "application/json",
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != want {
+			t.Errorf("%s: expected 200 with %s, got %d with %s", path, want, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", ChangePasswordPath, nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://accounts.example.com/password" {
+		t.Errorf("Expected a redirect for change-password, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,490 @@
+package ssl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"github.com/kirtansoni/reverse-proxy-go/events"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// RetryPolicy spaces out certificate requests for a domain after failures.
+// autocert itself retries on every handshake, which quickly runs into the
+// CA's rate limits when something is wrong.
+type RetryPolicy struct {
+	// Initial is the wait after the first failure; it doubles with every
+	// further failure up to Max.
+	Initial time.Duration
+	Max     time.Duration
+	// AlertAfter is the number of consecutive failures that raises an alert.
+	AlertAfter int
+	// OnAlert, if set, is called for every alert, e.g. to page someone.
+	OnAlert func(ACMEStats)
+}
+
+// DefaultRetryPolicy is used by NewACMEMonitor when no policy is given.
+var DefaultRetryPolicy = RetryPolicy{Initial: time.Minute, Max: time.Hour, AlertAfter: 3}
+
+// ACMEStats are the certificate operations for one domain.
+type ACMEStats struct {
+	Domain string `json:"domain"`
+	// Obtained and Renewed count certificates stored after issuance.
+	Obtained int `json:"obtained"`
+	Renewed  int `json:"renewed"`
+	// Challenges counts challenge requests from the CA (http-01 and
+	// tls-alpn-01).
+	Challenges          int        `json:"challenges"`
+	Failures            int        `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastFailure         *time.Time `json:"last_failure,omitempty"`
+	LastIssued          *time.Time `json:"last_issued,omitempty"`
+	NotAfter            *time.Time `json:"not_after,omitempty"`
+	// RetryAfter is when requests for the domain are allowed again.
+	RetryAfter *time.Time `json:"retry_after,omitempty"`
+	// Paused is set while issuance for the domain is paused.
+	Paused *Pause `json:"paused,omitempty"`
+}
+
+// ACMEMonitor instruments an autocert.Manager and applies a RetryPolicy to
+// it. Create it before the manager is used, as it wraps its Cache and
+// HostPolicy.
+type ACMEMonitor struct {
+	// Events, if set, receives CertIssued and CertRenewed events.
+	Events *events.Bus
+	// Metrics, if set, measures certificate lookups.
+	Metrics *Metrics
+
+	policy RetryPolicy
+
+	mu sync.Mutex
+	// m is replaced by Renew, dropping the certificates it holds in memory.
+	m       *autocert.Manager
+	domains map[string]*ACMEStats
+	// renewing are the domains whose cached certificate Renew deleted.
+	renewing map[string]bool
+	// paused are the paused domains when the manager has no cache.
+	paused map[string]Pause
+	// served is the certificate last served for each domain.
+	served map[string]*tls.Certificate
+	now    func() time.Time
+}
+
+func NewACMEMonitor(m *autocert.Manager, policy *RetryPolicy) *ACMEMonitor {
+	am := &ACMEMonitor{
+		m:        m,
+		policy:   DefaultRetryPolicy,
+		domains:  make(map[string]*ACMEStats),
+		renewing: make(map[string]bool),
+		paused:   make(map[string]Pause),
+		served:   make(map[string]*tls.Certificate),
+		now:      time.Now,
+	}
+	if policy != nil {
+		am.policy = *policy
+	}
+	if m.Cache != nil {
+		m.Cache = &monitoredCache{Cache: m.Cache, am: am}
+	}
+	if m.Client == nil {
+		m.Client = &acme.Client{DirectoryURL: autocert.DefaultACMEDirectory}
+	}
+	httpClient := http.Client{}
+	if m.Client.HTTPClient != nil {
+		httpClient = *m.Client.HTTPClient
+	}
+	next := httpClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	httpClient.Transport = &pausingTransport{next: next, am: am}
+	m.Client.HTTPClient = &httpClient
+
+	hostPolicy := m.HostPolicy
+	m.HostPolicy = func(ctx context.Context, host string) error {
+		if hostPolicy != nil {
+			if err := hostPolicy(ctx, host); err != nil {
+				return &policyError{err}
+			}
+		}
+		if am.isPaused(ctx, host) {
+			return &policyError{errPaused{host}}
+		}
+		return nil
+	}
+	return am
+}
+
+// policyError marks a host refused by the host policy, which is not an
+// ACME failure.
+type policyError struct{ err error }
+
+func (e *policyError) Error() string { return e.err.Error() }
+func (e *policyError) Unwrap() error { return e.err }
+
+func (am *ACMEMonitor) manager() *autocert.Manager {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	return am.m
+}
+
+// stats returns the entry for domain. Callers must hold am.mu.
+func (am *ACMEMonitor) stats(domain string) *ACMEStats {
+	s := am.domains[domain]
+	if s == nil {
+		s = &ACMEStats{Domain: domain}
+		am.domains[domain] = s
+	}
+	return s
+}
+
+// TLSConfig is autocert.Manager.TLSConfig with certificates obtained
+// through the monitor.
+func (am *ACMEMonitor) TLSConfig() *tls.Config {
+	cfg := am.manager().TLSConfig()
+	cfg.GetCertificate = am.GetCertificate
+	return cfg
+}
+
+// GetCertificate gets a certificate from the manager, unless the domain is
+// waiting out a retry delay.
+func (am *ACMEMonitor) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	challenge := len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+	if am.Metrics == nil || challenge {
+		return am.getCertificate(hello, challenge)
+	}
+	start := time.Now()
+	cert, err := am.getCertificate(hello, challenge)
+	am.observeServed(strings.TrimSuffix(strings.ToLower(hello.ServerName), "."), cert, time.Since(start), err)
+	return cert, err
+}
+
+func (am *ACMEMonitor) getCertificate(hello *tls.ClientHelloInfo, challenge bool) (*tls.Certificate, error) {
+	domain := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if domain == "" {
+		return am.manager().GetCertificate(hello)
+	}
+
+	am.mu.Lock()
+	m := am.m
+	if challenge {
+		am.stats(domain).Challenges++
+	} else if s := am.domains[domain]; s != nil && s.RetryAfter != nil && am.now().Before(*s.RetryAfter) {
+		err := fmt.Errorf("certificate for %s is not available until %s after %d failures: %s",
+			domain, s.RetryAfter.Format(time.RFC3339), s.ConsecutiveFailures, s.LastError)
+		am.mu.Unlock()
+		return nil, err
+	}
+	am.mu.Unlock()
+
+	cert, err := m.GetCertificate(hello)
+	var pe *policyError
+	if challenge || errors.As(err, &pe) {
+		return cert, err
+	}
+	if err != nil {
+		am.failed(domain, err)
+		return nil, err
+	}
+	am.mu.Lock()
+	if s := am.domains[domain]; s != nil {
+		s.ConsecutiveFailures = 0
+		s.RetryAfter = nil
+	}
+	am.mu.Unlock()
+	return cert, nil
+}
+
+// failed records a failure to get a certificate and schedules the retry.
+func (am *ACMEMonitor) failed(domain string, err error) {
+	am.mu.Lock()
+	now := am.now()
+	s := am.stats(domain)
+	s.Failures++
+	s.ConsecutiveFailures++
+	s.LastError = err.Error()
+	s.LastFailure = &now
+	wait := am.policy.Initial
+	for i := 1; i < s.ConsecutiveFailures && wait < am.policy.Max; i++ {
+		wait *= 2
+	}
+	wait = min(wait, am.policy.Max)
+	if wait > 0 {
+		retry := now.Add(wait)
+		s.RetryAfter = &retry
+	}
+	alert := am.policy.AlertAfter > 0 && s.ConsecutiveFailures >= am.policy.AlertAfter
+	snapshot := *s
+	am.mu.Unlock()
+
+	if alert {
+		log.Printf("ALERT: %d consecutive failures getting a certificate for %s, retrying in %v: %v",
+			snapshot.ConsecutiveFailures, domain, wait, err)
+		if am.policy.OnAlert != nil {
+			am.policy.OnAlert(snapshot)
+		}
+	}
+}
+
+// HTTPHandler is autocert.Manager.HTTPHandler, counting http-01 challenges.
+func (am *ACMEMonitor) HTTPHandler(fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
+			domain := r.Host
+			if i := strings.LastIndex(domain, ":"); i > 0 && !strings.HasSuffix(domain, "]") {
+				domain = domain[:i]
+			}
+			am.mu.Lock()
+			am.stats(strings.ToLower(domain)).Challenges++
+			am.mu.Unlock()
+		}
+		am.manager().HTTPHandler(fallback).ServeHTTP(w, r)
+	})
+}
+
+// Renew forces a new certificate for domain, e.g. when its key may be
+// compromised: the cached certificate is deleted and a new one is issued
+// right away, with a new key. Since autocert keeps certificates in memory
+// with no way to drop one, the manager is replaced by a copy without any;
+// other domains are reloaded from the cache on their next handshake.
+func (am *ACMEMonitor) Renew(ctx context.Context, domain string) (ACMEStats, error) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return ACMEStats{}, fmt.Errorf("domain is required")
+	}
+	m := am.manager()
+	if err := m.HostPolicy(ctx, domain); err != nil {
+		return ACMEStats{}, err
+	}
+	if m.Cache == nil {
+		return ACMEStats{}, fmt.Errorf("certificates of %s are not cached", domain)
+	}
+
+	am.mu.Lock()
+	am.renewing[domain] = true
+	am.mu.Unlock()
+	defer func() {
+		am.mu.Lock()
+		delete(am.renewing, domain)
+		am.mu.Unlock()
+	}()
+	for _, key := range []string{domain, domain + "+rsa"} {
+		if err := m.Cache.Delete(ctx, key); err != nil {
+			return ACMEStats{}, fmt.Errorf("failed to delete cached certificate %s: %v", key, err)
+		}
+	}
+	fresh := &autocert.Manager{
+		Prompt:                 m.Prompt,
+		Cache:                  m.Cache,
+		HostPolicy:             m.HostPolicy,
+		RenewBefore:            m.RenewBefore,
+		Client:                 m.Client,
+		Email:                  m.Email,
+		ExtraExtensions:        m.ExtraExtensions,
+		ExternalAccountBinding: m.ExternalAccountBinding,
+	}
+	am.mu.Lock()
+	am.m = fresh
+	am.mu.Unlock()
+
+	cert, err := fresh.GetCertificate(ecdsaHello(domain))
+	if err != nil {
+		am.failed(domain, err)
+		return ACMEStats{}, fmt.Errorf("failed to renew certificate for %s: %v", domain, err)
+	}
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	s := am.stats(domain)
+	if cert.Leaf != nil {
+		s.NotAfter = &cert.Leaf.NotAfter
+	}
+	return *s, nil
+}
+
+// ecdsaHello is a handshake from a client supporting ECDSA, for which
+// autocert issues its default certificate.
+func ecdsaHello(domain string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:        domain,
+		CipherSuites:      []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedVersions: []uint16{tls.VersionTLS13, tls.VersionTLS12},
+	}
+}
+
+// Obtain gets a certificate for domain as its first handshake would: from
+// memory or the cache, or else by issuing one, subject to the host policy
+// and retry delays. Issuance goes on in the background if ctx ends first.
+func (am *ACMEMonitor) Obtain(ctx context.Context, domain string) (ACMEStats, error) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return ACMEStats{}, fmt.Errorf("domain is required")
+	}
+	type result struct {
+		cert *tls.Certificate
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		cert, err := am.GetCertificate(ecdsaHello(domain))
+		done <- result{cert, err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		return ACMEStats{}, fmt.Errorf("certificate for %s is not issued yet: %v", domain, ctx.Err())
+	}
+	if res.err != nil {
+		return ACMEStats{}, fmt.Errorf("failed to obtain certificate for %s: %v", domain, res.err)
+	}
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	s := am.stats(domain)
+	if res.cert.Leaf != nil {
+		s.NotAfter = &res.cert.Leaf.NotAfter
+	}
+	return *s, nil
+}
+
+// Stats returns the operations of every domain seen or paused.
+func (am *ACMEMonitor) Stats() []ACMEStats {
+	return am.statsWithPauses(context.Background())
+}
+
+func (am *ACMEMonitor) statsWithPauses(ctx context.Context) []ACMEStats {
+	pauses, err := am.pauses(ctx)
+	if err != nil {
+		log.Printf("Failed to list paused certificate domains: %v", err)
+	}
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	stats := make([]ACMEStats, 0, len(am.domains))
+	for _, s := range am.domains {
+		stats = append(stats, *s)
+	}
+	for i := range stats {
+		if p, ok := pauses[stats[i].Domain]; ok {
+			stats[i].Paused = &p
+			delete(pauses, stats[i].Domain)
+		}
+	}
+	for domain, p := range pauses {
+		stats = append(stats, ACMEStats{Domain: domain, Paused: &p})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Domain < stats[j].Domain })
+	return stats
+}
+
+// AdminHandler serves the ACME statistics:
+//
+//	GET  /                per-domain issuance, challenge and failure counts
+//	POST /renew?domain=   force a new certificate for domain
+//	POST /pause?domain=[&reason=]
+//	                      stop issuance and renewal for domain
+//	POST /resume?domain=  allow them again
+func (am *ACMEMonitor) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, am.statsWithPauses(r.Context()))
+	})
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := am.Pause(r.Context(), r.URL.Query().Get("domain"), r.URL.Query().Get("reason"))
+		if err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, stats)
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := am.Resume(r.Context(), r.URL.Query().Get("domain"))
+		if err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, stats)
+	})
+	mux.HandleFunc("POST /renew", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := am.Renew(r.Context(), r.URL.Query().Get("domain"))
+		if err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, stats)
+	})
+	return mux
+}
+
+// monitoredCache counts the certificates autocert stores, which it does
+// after every successful issuance or renewal.
+type monitoredCache struct {
+	autocert.Cache
+	am *ACMEMonitor
+}
+
+func (c *monitoredCache) Put(ctx context.Context, key string, data []byte) error {
+	domain, ok := cachedCertDomain(key)
+	if !ok {
+		return c.Cache.Put(ctx, key, data)
+	}
+	_, err := c.Cache.Get(ctx, key)
+	c.am.mu.Lock()
+	renewal := err == nil || c.am.renewing[domain]
+	c.am.mu.Unlock()
+
+	if err := c.Cache.Put(ctx, key, data); err != nil {
+		return err
+	}
+	c.am.mu.Lock()
+	now := c.am.now()
+	s := c.am.stats(domain)
+	event := events.Event{Type: events.CertIssued, Time: now, Subject: domain}
+	if renewal {
+		s.Renewed++
+		event.Type = events.CertRenewed
+	} else {
+		s.Obtained++
+	}
+	s.LastIssued = &now
+	s.ConsecutiveFailures = 0
+	s.RetryAfter = nil
+	if notAfter, ok := leafNotAfter(data); ok {
+		s.NotAfter = &notAfter
+		event.Data = map[string]any{"not_after": notAfter}
+	}
+	c.am.mu.Unlock()
+	c.am.Events.Publish(event)
+	return nil
+}
+
+// leafNotAfter finds the expiry of the first certificate in autocert's
+// cached key-and-chain PEM.
+func leafNotAfter(data []byte) (time.Time, bool) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return time.Time{}, false
+		}
+		if block.Type == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return time.Time{}, false
+			}
+			return cert.NotAfter, true
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package ssl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestACMERetryBackoff(t *testing.T) {
+	var calls atomic.Int32
+	unreachable := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		return nil, errors.New("CA unreachable")
+	})
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist("example.com"),
+		Cache:      autocert.DirCache(t.TempDir()),
+		Client:     &acme.Client{DirectoryURL: "https://ca.test/directory", HTTPClient: &http.Client{Transport: unreachable}},
+	}
+	var alerts []ACMEStats
+	am := NewACMEMonitor(m, &RetryPolicy{
+		Initial:    time.Minute,
+		Max:        3 * time.Minute,
+		AlertAfter: 2,
+		OnAlert:    func(s ACMEStats) { alerts = append(alerts, s) },
+	})
+	now := time.Now()
+	am.now = func() time.Time { return now }
+	hello := &tls.ClientHelloInfo{ServerName: "example.com"}
+
+	if _, err := am.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.com"}); err == nil {
+		t.Fatal("Expected the host policy to refuse other.com")
+	}
+	if len(am.Stats()) != 0 {
+		t.Errorf("Expected refused hosts not to count as failures: %+v", am.Stats())
+	}
+
+	if _, err := am.GetCertificate(hello); err == nil {
+		t.Fatal("Expected the CA to fail")
+	}
+	seen := calls.Load()
+	if _, err := am.GetCertificate(hello); err == nil || calls.Load() != seen {
+		t.Errorf("Expected the retry delay to hold back the CA request: %v", err)
+	}
+
+	// Delays double up to the maximum.
+	for i, wait := range []time.Duration{2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		now = now.Add(time.Hour)
+		am.GetCertificate(hello)
+		s := am.Stats()[0]
+		if s.ConsecutiveFailures != i+2 || !s.RetryAfter.Equal(now.Add(wait)) {
+			t.Errorf("After %d failures: expected retry in %v, got %+v", i+2, wait, s)
+		}
+	}
+	if len(alerts) != 3 || alerts[0].ConsecutiveFailures != 2 {
+		t.Errorf("Unexpected alerts: %+v", alerts)
+	}
+}
+
+func TestACMECacheCountsIssuance(t *testing.T) {
+	m := &autocert.Manager{Cache: autocert.DirCache(t.TempDir())}
+	am := NewACMEMonitor(m, nil)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	chain, _ := selfSigningIssuer{}.Issue(context.Background(), "example.com", key)
+	ctx := context.Background()
+	m.Cache.Put(ctx, "acme_account+key", []byte("key"))
+	m.Cache.Put(ctx, "example.com", chain)
+	m.Cache.Put(ctx, "example.com", chain)
+
+	stats := am.Stats()
+	if len(stats) != 1 || stats[0].Obtained != 1 || stats[0].Renewed != 1 || stats[0].NotAfter == nil {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestACMERenewDropsCachedCertificate(t *testing.T) {
+	unreachable := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("CA unreachable")
+	})
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist("example.com"),
+		Cache:      autocert.DirCache(t.TempDir()),
+		Client:     &acme.Client{DirectoryURL: "https://ca.test/directory", HTTPClient: &http.Client{Transport: unreachable}},
+	}
+	am := NewACMEMonitor(m, nil)
+	ctx := context.Background()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	chain, _ := selfSigningIssuer{}.Issue(ctx, "example.com", key)
+	m.Cache.Put(ctx, "example.com", chain)
+
+	if _, err := am.Renew(ctx, "other.com"); err == nil {
+		t.Error("Expected the host policy to refuse other.com")
+	}
+	if _, err := am.Renew(ctx, "example.com"); err == nil {
+		t.Fatal("Expected the CA to fail")
+	}
+	if _, err := m.Cache.Get(ctx, "example.com"); err != autocert.ErrCacheMiss {
+		t.Errorf("Expected the cached certificate to be deleted, got %v", err)
+	}
+	if am.manager() == m {
+		t.Error("Expected the manager to be replaced")
+	}
+	if s := am.Stats(); len(s) != 1 || s[0].Failures != 1 {
+		t.Errorf("Expected the failure to be recorded: %+v", s)
+	}
+}
+
+func TestACMEPause(t *testing.T) {
+	var calls atomic.Int32
+	unreachable := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		return nil, errors.New("CA unreachable")
+	})
+	cache := autocert.DirCache(t.TempDir())
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist("example.com"),
+		Cache:      cache,
+		Client:     &acme.Client{DirectoryURL: "https://ca.test/directory", HTTPClient: &http.Client{Transport: unreachable}},
+	}
+	am := NewACMEMonitor(m, nil)
+	ctx := context.Background()
+	hello := &tls.ClientHelloInfo{ServerName: "example.com"}
+
+	if _, err := am.Pause(ctx, "Example.com.", "moving DNS"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := am.GetCertificate(hello); err == nil || calls.Load() != 0 {
+		t.Errorf("Expected issuance to be held back without asking the CA: %v", err)
+	}
+	if s := am.Stats(); len(s) != 1 || s[0].Failures != 0 || s[0].Paused == nil || s[0].Paused.Reason != "moving DNS" {
+		t.Errorf("Expected the pause to be listed without failures: %+v", s)
+	}
+
+	// Background renewals only show up as orders.
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"identifiers":[{"type":"dns","value":"example.com"}]}`))
+	order := strings.NewReader(`{"protected":"","payload":"` + payload + `","signature":""}`)
+	if _, err := m.Client.HTTPClient.Post("https://ca.test/new-order", "application/jose+json", order); err == nil || !strings.Contains(err.Error(), "paused") || calls.Load() != 0 {
+		t.Errorf("Expected the order to be refused: %v", err)
+	}
+
+	restarted := NewACMEMonitor(&autocert.Manager{Cache: cache}, nil)
+	if s := restarted.Stats(); len(s) != 1 || s[0].Paused == nil {
+		t.Errorf("Expected the pause to be saved in the cache: %+v", s)
+	}
+
+	if _, err := am.Resume(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := am.Resume(ctx, "example.com"); err == nil {
+		t.Error("Expected resuming twice to fail")
+	}
+	if _, err := am.GetCertificate(hello); err == nil || calls.Load() == 0 {
+		t.Errorf("Expected the CA to be asked after resuming: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package ssl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// CertInfo describes a loaded certificate.
+type CertInfo struct {
+	Domain   string    `json:"domain"`
+	DNSNames []string  `json:"dns_names"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// AdminHandler serves the certificate management endpoints:
+//
+//	GET    /                 list loaded certificates
+//	POST   /renew?domain=    reload the certificate files of domain
+//	POST   /rollkey          install the pair in a JSON body of
+//	                         {"cert": "<pem>", "key": "<pem>"}
+//	GET    /debug            recorded certificate selection decisions
+//	POST   /debug?size=      enable decision recording
+//	DELETE /debug            disable decision recording
+func (cm *CertManager) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, cm.list())
+	})
+
+	mux.HandleFunc("POST /renew", func(w http.ResponseWriter, r *http.Request) {
+		domain := r.URL.Query().Get("domain")
+		if err := cm.Renew(domain); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrUnknownDomain) {
+				status = http.StatusNotFound
+			}
+			admin.WriteError(w, status, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, cm.list())
+	})
+
+	mux.HandleFunc("POST /rollkey", func(w http.ResponseWriter, r *http.Request) {
+		var pair struct {
+			Cert string `json:"cert"`
+			Key  string `json:"key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&pair); err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		if pair.Cert == "" || pair.Key == "" {
+			admin.WriteError(w, http.StatusBadRequest, errors.New("a certificate and key are required"))
+			return
+		}
+		if err := cm.Install([]byte(pair.Cert), []byte(pair.Key)); err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, cm.list())
+	})
+
+	mux.HandleFunc("GET /debug", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, map[string]any{
+			"enabled":   cm.debug.Load() != nil,
+			"decisions": cm.Decisions(),
+		})
+	})
+
+	mux.HandleFunc("POST /debug", func(w http.ResponseWriter, r *http.Request) {
+		size := 0
+		if s := r.URL.Query().Get("size"); s != "" {
+			var err error
+			if size, err = strconv.Atoi(s); err != nil || size < 0 {
+				admin.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid size %q", s))
+				return
+			}
+		}
+		cm.EnableDebug(size)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("DELETE /debug", func(w http.ResponseWriter, r *http.Request) {
+		cm.DisableDebug()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
+
+func (cm *CertManager) list() []CertInfo {
+	cm.RLock()
+	defer cm.RUnlock()
+
+	infos := make([]CertInfo, 0, len(cm.certs))
+	for domain, cert := range cm.certs {
+		info := CertInfo{Domain: domain}
+		if cert.Leaf != nil {
+			info.DNSNames = cert.Leaf.DNSNames
+			info.NotAfter = cert.Leaf.NotAfter
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Domain < infos[j].Domain })
+	return infos
+}
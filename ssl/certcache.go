@@ -0,0 +1,179 @@
+package ssl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// CertCache is an autocert.Cache kept in a storage.Store that reports its
+// usage, prunes certificates of domains no longer served and caps how many
+// domains get certificates, for on-demand TLS where any routed host gets one.
+type CertCache struct {
+	Store storage.Store
+	// MaxHosts caps the domains with cached certificates; 0 means no cap.
+	MaxHosts int
+}
+
+// CachedDomain is a domain with a certificate in the cache.
+type CachedDomain struct {
+	Domain   string     `json:"domain"`
+	Bytes    int64      `json:"bytes"`
+	Modified time.Time  `json:"modified"`
+	NotAfter *time.Time `json:"not_after,omitempty"`
+}
+
+// CacheUsage is the disk usage of the cache.
+type CacheUsage struct {
+	Files    int            `json:"files"`
+	Bytes    int64          `json:"bytes"`
+	MaxHosts int            `json:"max_hosts,omitempty"`
+	Domains  []CachedDomain `json:"domains"`
+}
+
+func (c *CertCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.Store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+func (c *CertCache) Put(ctx context.Context, key string, data []byte) error {
+	return c.Store.Put(ctx, key, data)
+}
+
+func (c *CertCache) Delete(ctx context.Context, key string) error {
+	return c.Store.Delete(ctx, key)
+}
+
+// certDomain returns the domain of a cache key holding a certificate: the
+// domain itself, or with a "+rsa" suffix for RSA ones. Other keys (the
+// account key, challenge tokens) have a different suffix.
+func cachedCertDomain(key string) (string, bool) {
+	domain := strings.TrimSuffix(key, "+rsa")
+	return domain, !strings.Contains(domain, "+")
+}
+
+// Usage returns the files in the cache and the domains they hold
+// certificates for.
+func (c *CertCache) Usage() (CacheUsage, error) {
+	usage := CacheUsage{MaxHosts: c.MaxHosts, Domains: []CachedDomain{}}
+	entries, err := c.Store.List(context.Background(), "")
+	if err != nil {
+		return usage, fmt.Errorf("failed to read certificate cache: %v", err)
+	}
+	domains := make(map[string]*CachedDomain)
+	for _, e := range entries {
+		usage.Files++
+		usage.Bytes += e.Size
+		domain, ok := cachedCertDomain(e.Key)
+		if !ok {
+			continue
+		}
+		d := domains[domain]
+		if d == nil {
+			d = &CachedDomain{Domain: domain}
+			domains[domain] = d
+		}
+		d.Bytes += e.Size
+		if e.Modified.After(d.Modified) {
+			d.Modified = e.Modified
+		}
+		if d.NotAfter == nil {
+			if data, err := c.Store.Get(context.Background(), e.Key); err == nil {
+				if notAfter, ok := leafNotAfter(data); ok {
+					d.NotAfter = &notAfter
+				}
+			}
+		}
+	}
+	for _, d := range domains {
+		usage.Domains = append(usage.Domains, *d)
+	}
+	sort.Slice(usage.Domains, func(i, j int) bool { return usage.Domains[i].Domain < usage.Domains[j].Domain })
+	return usage, nil
+}
+
+// Prune deletes the certificates of domains keep refuses, such as hosts no
+// longer routed, and returns those domains. With dryRun nothing is deleted.
+func (c *CertCache) Prune(ctx context.Context, keep autocert.HostPolicy, dryRun bool) ([]string, error) {
+	usage, err := c.Usage()
+	if err != nil {
+		return nil, err
+	}
+	pruned := []string{}
+	for _, d := range usage.Domains {
+		if keep(ctx, d.Domain) == nil {
+			continue
+		}
+		pruned = append(pruned, d.Domain)
+		if dryRun {
+			continue
+		}
+		for _, key := range []string{d.Domain, d.Domain + "+rsa"} {
+			if err := c.Delete(ctx, key); err != nil {
+				return pruned, fmt.Errorf("failed to delete cached certificate %s: %v", key, err)
+			}
+		}
+	}
+	return pruned, nil
+}
+
+// HostPolicy refuses certificates for new domains once MaxHosts domains
+// have one, and otherwise defers to next.
+func (c *CertCache) HostPolicy(next autocert.HostPolicy) autocert.HostPolicy {
+	return func(ctx context.Context, host string) error {
+		if err := next(ctx, host); err != nil {
+			return err
+		}
+		if c.MaxHosts <= 0 {
+			return nil
+		}
+		if _, err := c.Get(ctx, host); err == nil {
+			return nil
+		}
+		usage, err := c.Usage()
+		if err != nil {
+			return err
+		}
+		if len(usage.Domains) >= c.MaxHosts {
+			return fmt.Errorf("certificate cache is full: %d of %d hosts have certificates", len(usage.Domains), c.MaxHosts)
+		}
+		return nil
+	}
+}
+
+// AdminHandler serves certificate cache management:
+//
+//	GET  /                    disk usage and the domains with certificates
+//	POST /prune[?dry_run=1]   delete certificates of domains no longer served
+func (c *CertCache) AdminHandler(keep autocert.HostPolicy) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		usage, err := c.Usage()
+		if err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, usage)
+	})
+	mux.HandleFunc("POST /prune", func(w http.ResponseWriter, r *http.Request) {
+		dryRun := r.URL.Query().Get("dry_run") != ""
+		pruned, err := c.Prune(r.Context(), keep, dryRun)
+		if err != nil {
+			admin.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		admin.WriteJSON(w, http.StatusOK, map[string]any{"pruned": pruned, "dry_run": dryRun})
+	})
+	return mux
+}
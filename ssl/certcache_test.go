@@ -0,0 +1,60 @@
+package ssl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestCertCache(t *testing.T) {
+	ctx := context.Background()
+	cache := &CertCache{Store: storage.NewDir(t.TempDir()), MaxHosts: 2}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	for _, domain := range []string{"a.example.com", "old.example.com"} {
+		chain, _ := selfSigningIssuer{}.Issue(ctx, domain, key)
+		cache.Put(ctx, domain, chain)
+	}
+	cache.Put(ctx, "old.example.com+rsa", []byte("rsa"))
+	cache.Put(ctx, "acme_account+key", []byte("account"))
+
+	usage, err := cache.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Files != 4 || len(usage.Domains) != 2 || usage.Domains[0].NotAfter == nil || usage.Domains[1].Bytes <= 3 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+
+	served := autocert.HostWhitelist("a.example.com", "b.example.com", "c.example.com")
+	policy := cache.HostPolicy(served)
+	if err := policy(ctx, "a.example.com"); err != nil {
+		t.Errorf("Expected a cached host to be allowed: %v", err)
+	}
+	if err := policy(ctx, "b.example.com"); err == nil {
+		t.Error("Expected a new host to be refused once the cache is full")
+	}
+
+	if pruned, _ := cache.Prune(ctx, served, true); len(pruned) != 1 || pruned[0] != "old.example.com" {
+		t.Errorf("Unexpected dry run: %v", pruned)
+	}
+	if _, err := cache.Get(ctx, "old.example.com"); err != nil {
+		t.Error("Expected a dry run to keep the certificate")
+	}
+	cache.Prune(ctx, served, false)
+	for _, key := range []string{"old.example.com", "old.example.com+rsa"} {
+		if _, err := cache.Get(ctx, key); err != autocert.ErrCacheMiss {
+			t.Errorf("Expected %s to be pruned, got %v", key, err)
+		}
+	}
+	if _, err := cache.Get(ctx, "acme_account+key"); err != nil {
+		t.Error("Expected the account key to be kept")
+	}
+	if err := policy(ctx, "b.example.com"); err != nil {
+		t.Errorf("Expected room for a new host after pruning: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package ssl
+
+import (
+	"crypto/tls"
+	"log"
+	"sync"
+	"time"
+)
+
+// Reasons reported for a certificate selection.
+const (
+	ReasonExact    = "exact"
+	ReasonWildcard = "wildcard"
+	ReasonFallback = "fallback"
+	ReasonNone     = "none"
+)
+
+// Decision records which certificate GetCertificate chose for a handshake.
+type Decision struct {
+	Time       time.Time `json:"time"`
+	ServerName string    `json:"server_name"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Domain     string    `json:"domain,omitempty"`
+	Reason     string    `json:"reason"`
+}
+
+// maxDebugDecisions caps the decisions a debug buffer keeps.
+const maxDebugDecisions = 10000
+
+// decisionLog is a fixed size ring buffer of the most recent decisions.
+type decisionLog struct {
+	sync.Mutex
+	entries []Decision
+	next    int
+	full    bool
+}
+
+func newDecisionLog(size int) *decisionLog {
+	if size <= 0 {
+		size = 256
+	}
+	size = min(size, maxDebugDecisions)
+	return &decisionLog{entries: make([]Decision, size)}
+}
+
+func (l *decisionLog) record(hello *tls.ClientHelloInfo, domain, reason string) {
+	d := Decision{
+		Time:       time.Now(),
+		ServerName: hello.ServerName,
+		Domain:     domain,
+		Reason:     reason,
+	}
+	if hello.Conn != nil {
+		d.RemoteAddr = hello.Conn.RemoteAddr().String()
+	}
+	log.Printf("tls debug: sni=%q cert=%q reason=%s remote=%s", d.ServerName, d.Domain, d.Reason, d.RemoteAddr)
+
+	l.Lock()
+	defer l.Unlock()
+	l.entries[l.next] = d
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// snapshot returns the recorded decisions, oldest first.
+func (l *decisionLog) snapshot() []Decision {
+	l.Lock()
+	defer l.Unlock()
+
+	if !l.full {
+		return append([]Decision(nil), l.entries[:l.next]...)
+	}
+	return append(append([]Decision(nil), l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+// EnableDebug starts recording the last size certificate decisions, at most
+// 10000, logging each one. Calling it again resets the buffer.
+func (cm *CertManager) EnableDebug(size int) {
+	cm.debug.Store(newDecisionLog(size))
+}
+
+func (cm *CertManager) DisableDebug() {
+	cm.debug.Store(nil)
+}
+
+// Decisions returns the recorded decisions, or nil if debug mode is off.
+func (cm *CertManager) Decisions() []Decision {
+	if debug := cm.debug.Load(); debug != nil {
+		return debug.snapshot()
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package ssl
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/ssl/ssltest"
+)
+
+func TestDecisionLog(t *testing.T) {
+	domain := "example.com"
+	certFile, keyFile := ssltest.TempCert(t, domain)
+
+	cm, err := NewCertManager(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to create CertManager: %v", err)
+	}
+
+	cm.GetCertificate(&tls.ClientHelloInfo{ServerName: domain})
+	if cm.Decisions() != nil {
+		t.Error("Expected no decisions while debug mode is off")
+	}
+
+	cm.EnableDebug(2)
+	cm.GetCertificate(&tls.ClientHelloInfo{ServerName: domain})
+	cm.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.com"})
+	cm.GetCertificate(&tls.ClientHelloInfo{ServerName: domain})
+
+	decisions := cm.Decisions()
+	if len(decisions) != 2 {
+		t.Fatalf("Expected 2 decisions, got %d", len(decisions))
+	}
+	if decisions[0].ServerName != "other.com" || decisions[0].Reason != ReasonFallback {
+		t.Errorf("Unexpected oldest decision: %+v", decisions[0])
+	}
+	if decisions[1].Reason != ReasonExact || decisions[1].Domain != domain {
+		t.Errorf("Unexpected newest decision: %+v", decisions[1])
+	}
+
+	cm.DisableDebug()
+	if cm.Decisions() != nil {
+		t.Error("Expected no decisions after disabling debug mode")
+	}
+}
+
+func TestDebugSize(t *testing.T) {
+	certFile, keyFile := ssltest.TempCert(t, "example.com")
+	cm, err := NewCertManager(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to create CertManager: %v", err)
+	}
+	handler := cm.AdminHandler()
+
+	for size, status := range map[string]int{"abc": http.StatusBadRequest, "-1": http.StatusBadRequest, "2000000000": http.StatusNoContent} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "/debug?size="+size, nil))
+		if w.Code != status {
+			t.Errorf("size=%s: expected %d, got %d", size, status, w.Code)
+		}
+	}
+	if n := len(cm.debug.Load().entries); n != maxDebugDecisions {
+		t.Errorf("Expected the buffer to be capped at %d, got %d", maxDebugDecisions, n)
+	}
+}
@@ -0,0 +1,516 @@
+package ssl
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+	"github.com/kirtansoni/reverse-proxy-go/events"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DNSProvider publishes the TXT records that prove control of a domain in
+// the ACME DNS-01 challenge.
+type DNSProvider interface {
+	// Present creates a TXT record named fqdn, such as
+	// _acme-challenge.example.com, holding value.
+	Present(ctx context.Context, fqdn, value string) error
+	// CleanUp removes the record again.
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// DNSRecordChange is the JSON body WebhookProvider posts.
+type DNSRecordChange struct {
+	// Action is "create" or "delete".
+	Action string `json:"action"`
+	FQDN   string `json:"fqdn"`
+	Value  string `json:"value"`
+}
+
+// WebhookProvider is a DNSProvider for DNS hosts without a built-in one: it
+// POSTs each record change to URL as a DNSRecordChange, and the script
+// behind it makes the change through the host's API. A 2xx answer means the
+// change is made.
+type WebhookProvider struct {
+	URL string
+	// Client sends the requests; nil means http.DefaultClient.
+	Client *http.Client
+}
+
+func (p *WebhookProvider) Present(ctx context.Context, fqdn, value string) error {
+	return p.post(ctx, DNSRecordChange{Action: "create", FQDN: fqdn, Value: value})
+}
+
+func (p *WebhookProvider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.post(ctx, DNSRecordChange{Action: "delete", FQDN: fqdn, Value: value})
+}
+
+func (p *WebhookProvider) post(ctx context.Context, change DNSRecordChange) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to %s TXT record %s: %v", change.Action, change.FQDN, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to %s TXT record %s: webhook returned %s", change.Action, change.FQDN, resp.Status)
+	}
+	return nil
+}
+
+const (
+	// dns01RenewBefore is how long before expiry DNS-01 certificates are
+	// renewed when RenewBefore is not set, as autocert does.
+	dns01RenewBefore = 30 * 24 * time.Hour
+	// dns01CheckInterval is how often certificates are checked for renewal.
+	dns01CheckInterval = 12 * time.Hour
+	// dns01Propagation caps the wait for a challenge record to be visible
+	// before the CA is asked to check it.
+	dns01Propagation = 2 * time.Minute
+	// accountKeyName is where autocert keeps its ACME account key, shared
+	// so both use the same account.
+	accountKeyName = "acme_account+key"
+)
+
+// DNS01Stats is the certificate of a DNS-01 domain.
+type DNS01Stats struct {
+	Domain      string     `json:"domain"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	LastIssued  *time.Time `json:"last_issued,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+}
+
+// DNS01Issuer obtains and renews certificates through DNS-01 challenges,
+// for names the CA cannot reach over HTTP or TLS: wildcards, and hosts on
+// private networks. Certificates are kept in Cache next to autocert's,
+// under the domain with a "+dns01" suffix.
+type DNS01Issuer struct {
+	Provider DNSProvider
+	Cache    autocert.Cache
+	// Client talks to the CA; its Key, if not set, is autocert's account
+	// key from Cache, created if missing.
+	Client *acme.Client
+	Email  string
+	// RenewBefore is how long before expiry certificates are renewed; 0
+	// means 30 days.
+	RenewBefore time.Duration
+	// Events, if set, receives CertIssued and CertRenewed events.
+	Events *events.Bus
+
+	domains   []string
+	lookupTXT func(ctx context.Context, name string) ([]string, error)
+	wait      time.Duration
+
+	mu         sync.Mutex
+	registered bool
+	certs      map[string]*tls.Certificate
+	stats      map[string]*DNS01Stats
+}
+
+// NewDNS01Issuer returns an issuer for domains, each a host name or a
+// wildcard such as *.example.com.
+func NewDNS01Issuer(domains []string, provider DNSProvider, cache autocert.Cache) (*DNS01Issuer, error) {
+	d := &DNS01Issuer{
+		Provider:  provider,
+		Cache:     cache,
+		lookupTXT: net.DefaultResolver.LookupTXT,
+		wait:      dns01Propagation,
+		certs:     make(map[string]*tls.Certificate),
+		stats:     make(map[string]*DNS01Stats),
+	}
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		name := strings.TrimPrefix(domain, "*.")
+		if name == "" || strings.Contains(name, "*") || !strings.Contains(name, ".") || strings.ContainsAny(name, "/: ") {
+			return nil, fmt.Errorf("invalid DNS-01 domain %q, expected a host name or *.example.com", domain)
+		}
+		if !slices.Contains(d.domains, domain) {
+			d.domains = append(d.domains, domain)
+			d.stats[domain] = &DNS01Stats{Domain: domain}
+		}
+	}
+	if len(d.domains) == 0 {
+		return nil, errors.New("no DNS-01 domains")
+	}
+	return d, nil
+}
+
+// covering returns the domain whose certificate covers name: name itself,
+// or a wildcard one label above it.
+func (d *DNS01Issuer) covering(name string) (string, bool) {
+	if slices.Contains(d.domains, name) {
+		return name, true
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok && slices.Contains(d.domains, "*."+parent) {
+		return "*." + parent, true
+	}
+	return "", false
+}
+
+// GetCertificate wraps next, serving the certificates of the issuer's
+// domains and leaving every other name, and names whose certificate is not
+// issued yet, to next.
+func (d *DNS01Issuer) GetCertificate(next func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+		challenge := len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto
+		if domain, ok := d.covering(name); ok && !challenge {
+			d.mu.Lock()
+			cert := d.certs[domain]
+			d.mu.Unlock()
+			if cert != nil {
+				return cert, nil
+			}
+		}
+		return next(hello)
+	}
+}
+
+// Run loads the cached certificates and obtains or renews every one that
+// is missing or due, then checks again every 12 hours until ctx is done.
+func (d *DNS01Issuer) Run(ctx context.Context) {
+	for _, domain := range d.domains {
+		if cert := d.load(ctx, domain); cert != nil {
+			d.store(domain, cert, false)
+		}
+	}
+	for {
+		for _, domain := range d.domains {
+			if _, err := d.ensure(ctx, domain, false); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to obtain DNS-01 certificate for %s: %v", domain, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dns01CheckInterval):
+		}
+	}
+}
+
+// ensure returns the certificate of domain, issuing a new one if force is
+// set or the one held is missing or due for renewal.
+func (d *DNS01Issuer) ensure(ctx context.Context, domain string, force bool) (*tls.Certificate, error) {
+	d.mu.Lock()
+	cert := d.certs[domain]
+	d.mu.Unlock()
+	if cert == nil {
+		if cert = d.load(ctx, domain); cert != nil {
+			d.store(domain, cert, false)
+		}
+	}
+	renewBefore := d.RenewBefore
+	if renewBefore <= 0 {
+		renewBefore = dns01RenewBefore
+	}
+	if cert != nil && !force && time.Until(cert.Leaf.NotAfter) > renewBefore {
+		return cert, nil
+	}
+	renewal := cert != nil
+	fresh, err := d.obtain(ctx, domain)
+	if err != nil {
+		now := time.Now()
+		d.mu.Lock()
+		d.stats[domain].LastError = err.Error()
+		d.stats[domain].LastFailure = &now
+		d.mu.Unlock()
+		return nil, err
+	}
+	d.store(domain, fresh, true)
+	event := events.Event{Type: events.CertIssued, Time: time.Now(), Subject: domain, Data: map[string]any{"not_after": fresh.Leaf.NotAfter, "challenge": "dns-01"}}
+	if renewal {
+		event.Type = events.CertRenewed
+	}
+	d.Events.Publish(event)
+	log.Printf("Obtained DNS-01 certificate for %s, valid until %s", domain, fresh.Leaf.NotAfter.Format(time.RFC3339))
+	return fresh, nil
+}
+
+func (d *DNS01Issuer) store(domain string, cert *tls.Certificate, issued bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.certs[domain] = cert
+	s := d.stats[domain]
+	s.NotAfter = &cert.Leaf.NotAfter
+	if issued {
+		now := time.Now()
+		s.LastIssued = &now
+		s.LastError = ""
+		s.LastFailure = nil
+	}
+}
+
+// load returns the cached certificate of domain, if any.
+func (d *DNS01Issuer) load(ctx context.Context, domain string) *tls.Certificate {
+	data, err := d.Cache.Get(ctx, domain+"+dns01")
+	if err != nil {
+		return nil
+	}
+	var keyPEM, certPEM []byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			certPEM = append(certPEM, pem.EncodeToMemory(block)...)
+		} else {
+			keyPEM = pem.EncodeToMemory(block)
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		log.Printf("Ignoring invalid cached DNS-01 certificate for %s: %v", domain, err)
+		return nil
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil
+		}
+	}
+	return &cert
+}
+
+// obtain issues a certificate for domain and caches it.
+func (d *DNS01Issuer) obtain(ctx context.Context, domain string) (*tls.Certificate, error) {
+	if err := d.register(ctx); err != nil {
+		return nil, err
+	}
+	order, err := d.Client.AuthorizeOrder(ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %v", err)
+	}
+	for _, u := range order.AuthzURLs {
+		if err := d.authorize(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = d.Client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("failed to wait for order: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{domain}}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := d.Client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize order: %v", err)
+	}
+
+	var buf bytes.Buffer
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert := &tls.Certificate{PrivateKey: key}
+	for _, der := range chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		cert.Certificate = append(cert.Certificate, der)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("CA returned no certificate")
+	}
+	if cert.Leaf, err = x509.ParseCertificate(chain[0]); err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	if err := d.Cache.Put(ctx, domain+"+dns01", buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to cache certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// authorize completes the DNS-01 challenge of the authorization at u.
+func (d *DNS01Issuer) authorize(ctx context.Context, u string) error {
+	z, err := d.Client.GetAuthorization(ctx, u)
+	if err != nil {
+		return fmt.Errorf("failed to get authorization: %v", err)
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("CA offers no dns-01 challenge for %s", z.Identifier.Value)
+	}
+	value, err := d.Client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	// Wildcard authorizations are for the name below the "*.".
+	fqdn := "_acme-challenge." + strings.TrimPrefix(z.Identifier.Value, "*.")
+	if err := d.Provider.Present(ctx, fqdn, value); err != nil {
+		return err
+	}
+	defer func() {
+		if err := d.Provider.CleanUp(context.WithoutCancel(ctx), fqdn, value); err != nil {
+			log.Printf("Failed to remove DNS-01 challenge record %s: %v", fqdn, err)
+		}
+	}()
+	d.propagated(ctx, fqdn, value)
+	if _, err := d.Client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("failed to accept challenge: %v", err)
+	}
+	if _, err := d.Client.WaitAuthorization(ctx, z.URI); err != nil {
+		return fmt.Errorf("failed to authorize %s: %v", z.Identifier.Value, err)
+	}
+	return nil
+}
+
+// propagated waits until the local resolver sees value at fqdn, or gives
+// up after d.wait and lets the CA check anyway.
+func (d *DNS01Issuer) propagated(ctx context.Context, fqdn, value string) {
+	deadline := time.Now().Add(d.wait)
+	for {
+		if records, err := d.lookupTXT(ctx, fqdn); err == nil && slices.Contains(records, value) {
+			return
+		}
+		if !time.Now().Before(deadline) {
+			log.Printf("DNS-01 challenge record %s is not visible yet after %v, asking the CA anyway", fqdn, d.wait)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(min(2*time.Second, d.wait)):
+		}
+	}
+}
+
+// register sets up the ACME account on first use.
+func (d *DNS01Issuer) register(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.registered {
+		return nil
+	}
+	if d.Client == nil {
+		d.Client = &acme.Client{DirectoryURL: autocert.DefaultACMEDirectory}
+	}
+	if d.Client.Key == nil {
+		key, err := d.accountKey(ctx)
+		if err != nil {
+			return err
+		}
+		d.Client.Key = key
+	}
+	account := &acme.Account{}
+	if d.Email != "" {
+		account.Contact = []string{"mailto:" + d.Email}
+	}
+	if _, err := d.Client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return fmt.Errorf("failed to register ACME account: %v", err)
+	}
+	d.registered = true
+	return nil
+}
+
+// accountKey returns autocert's account key, creating it if missing.
+func (d *DNS01Issuer) accountKey(ctx context.Context) (crypto.Signer, error) {
+	data, err := d.Cache.Get(ctx, accountKeyName)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "EC PRIVATE KEY" {
+			return nil, errors.New("invalid cached ACME account key")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, fmt.Errorf("failed to read ACME account key: %v", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Cache.Put(ctx, accountKeyName, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, fmt.Errorf("failed to save ACME account key: %v", err)
+	}
+	return key, nil
+}
+
+// Stats returns the certificates of every domain, sorted.
+func (d *DNS01Issuer) Stats() []DNS01Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := make([]DNS01Stats, 0, len(d.stats))
+	for _, s := range d.stats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Domain < stats[j].Domain })
+	return stats
+}
+
+// AdminHandler serves the DNS-01 certificates:
+//
+//	GET  /                the certificate of every domain
+//	POST /renew?domain=   issue a new certificate for domain now
+func (d *DNS01Issuer) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, d.Stats())
+	})
+	mux.HandleFunc("POST /renew", func(w http.ResponseWriter, r *http.Request) {
+		domain := strings.ToLower(r.URL.Query().Get("domain"))
+		if !slices.Contains(d.domains, domain) {
+			admin.WriteError(w, http.StatusNotFound, fmt.Errorf("%q is not a DNS-01 domain", domain))
+			return
+		}
+		if _, err := d.ensure(r.Context(), domain, true); err != nil {
+			admin.WriteError(w, http.StatusBadGateway, err)
+			return
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		admin.WriteJSON(w, http.StatusOK, *d.stats[domain])
+	})
+	return mux
+}
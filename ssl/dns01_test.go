@@ -0,0 +1,114 @@
+package ssl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/storage"
+	"golang.org/x/crypto/acme"
+)
+
+func cachedDNS01Cert(t *testing.T, domain string, notAfter time.Time) []byte {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := x509.Certificate{SerialNumber: big.NewInt(1), DNSNames: []string{domain}, NotBefore: time.Now().Add(-time.Hour), NotAfter: notAfter}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+}
+
+func TestWebhookProvider(t *testing.T) {
+	var changes []DNSRecordChange
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var c DNSRecordChange
+		json.NewDecoder(r.Body).Decode(&c)
+		changes = append(changes, c)
+		if c.Value == "refused" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer hook.Close()
+
+	p := &WebhookProvider{URL: hook.URL}
+	ctx := context.Background()
+	if err := p.Present(ctx, "_acme-challenge.example.com", "token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CleanUp(ctx, "_acme-challenge.example.com", "token"); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0] != (DNSRecordChange{"create", "_acme-challenge.example.com", "token"}) || changes[1].Action != "delete" {
+		t.Errorf("Expected a create and a delete, got %+v", changes)
+	}
+	if err := p.Present(ctx, "_acme-challenge.example.com", "refused"); err == nil {
+		t.Error("Expected an error for a refused change")
+	}
+}
+
+func TestDNS01Issuer(t *testing.T) {
+	if _, err := NewDNS01Issuer([]string{"*.*.example.com"}, nil, nil); err == nil {
+		t.Error("Expected a nested wildcard to be rejected")
+	}
+	cache := &CertCache{Store: storage.NewMemory()}
+	ctx := context.Background()
+	cache.Put(ctx, "*.example.com+dns01", cachedDNS01Cert(t, "*.example.com", time.Now().Add(60*24*time.Hour)))
+	cache.Put(ctx, "internal.example.net+dns01", cachedDNS01Cert(t, "internal.example.net", time.Now().Add(24*time.Hour)))
+
+	d, err := NewDNS01Issuer([]string{"*.example.com", "Internal.example.net."}, &WebhookProvider{URL: "http://127.0.0.1:1"}, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := roundTripFunc(func(r *http.Request) (*http.Response, error) { return nil, errors.New("CA unreachable") })
+	d.Client = &acme.Client{DirectoryURL: "https://ca.test/directory", HTTPClient: &http.Client{Transport: unreachable}}
+
+	if _, err := d.ensure(ctx, "*.example.com", false); err != nil {
+		t.Fatalf("Expected the cached certificate to be used, got %v", err)
+	}
+	// The second certificate is due for renewal, which fails.
+	if _, err := d.ensure(ctx, "internal.example.net", false); err == nil {
+		t.Error("Expected the renewal to fail")
+	}
+	stats := d.Stats()
+	if len(stats) != 2 || stats[0].NotAfter == nil || stats[1].LastError == "" || stats[1].NotAfter == nil {
+		t.Errorf("Expected both certificates and the renewal failure, got %+v", stats)
+	}
+
+	fallback := func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return nil, errors.New("fallback") }
+	get := d.GetCertificate(fallback)
+	for name, dns01 := range map[string]bool{"app.example.com": true, "internal.example.net": true, "example.com": false, "a.b.example.com": false} {
+		cert, err := get(&tls.ClientHelloInfo{ServerName: name})
+		if dns01 && (err != nil || cert == nil) || !dns01 && err == nil {
+			t.Errorf("Expected %s served by DNS-01 to be %v, got %v %v", name, dns01, cert != nil, err)
+		}
+	}
+}
+
+func TestDNS01Propagation(t *testing.T) {
+	lookups := 0
+	d := &DNS01Issuer{wait: time.Second, lookupTXT: func(ctx context.Context, name string) ([]string, error) {
+		lookups++
+		if lookups < 2 {
+			return nil, errors.New("no such host")
+		}
+		return []string{"other", "value"}, nil
+	}}
+	start := time.Now()
+	d.propagated(context.Background(), "_acme-challenge.example.com", "value")
+	if lookups != 2 || time.Since(start) > 2*time.Second {
+		t.Errorf("Expected to wait for the record, got %d lookups in %v", lookups, time.Since(start))
+	}
+}
@@ -0,0 +1,211 @@
+package ssl
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// Reasons a TLS handshake failed.
+const (
+	ReasonUnknownSNI       = "unknown_sni"
+	ReasonNoCertificate    = "certificate_unavailable"
+	ReasonProtocolMismatch = "protocol_mismatch"
+	ReasonClientCert       = "client_cert"
+	// ReasonCertRejected is a client refusing the proxy's certificate.
+	ReasonCertRejected = "certificate_rejected"
+	ReasonNotTLS       = "not_tls"
+	ReasonAborted      = "aborted"
+	ReasonOther        = "other"
+)
+
+// handshakeReasons classifies handshake errors by their text, which is all
+// http.Server reports; the first match wins.
+var handshakeReasons = []struct {
+	reason string
+	texts  []string
+}{
+	{ReasonCertRejected, []string{"remote error: tls: bad certificate", "remote error: tls: unknown certificate", "remote error: tls: certificate"}},
+	{ReasonUnknownSNI, []string{"not configured in HostWhitelist", "missing server name", "no certificate found for", "server name component count invalid", "preflight failed for", "refused server name"}},
+	{ReasonNoCertificate, []string{"is not available until", "acme/autocert:", "acme:"}},
+	{ReasonProtocolMismatch, []string{"unsupported versions", "no cipher suite supported", "no application protocol", "no mutually supported", "unsupported protocol version", "protocol version not supported", "no ECDHE curve"}},
+	{ReasonClientCert, []string{"client didn't provide a certificate", "failed to verify certificate", "client certificate", "certificate required"}},
+	{ReasonNotTLS, []string{"HTTP request to an HTTPS server", "first record does not look like a TLS handshake", "oversized record", "unsupported SSLv2 handshake"}},
+	{ReasonAborted, []string{"EOF", "connection reset by peer", "i/o timeout", "broken pipe", "use of closed network connection"}},
+}
+
+func classifyHandshake(err string) string {
+	for _, r := range handshakeReasons {
+		for _, text := range r.texts {
+			if strings.Contains(err, text) {
+				return r.reason
+			}
+		}
+	}
+	return ReasonOther
+}
+
+// maxHandshakeClients caps the IPs tracked; the least recently seen one is
+// forgotten first.
+const maxHandshakeClients = 1024
+
+// HandshakeClient is the handshake failures of one IP.
+type HandshakeClient struct {
+	IP        string           `json:"ip"`
+	Failures  int64            `json:"failures"`
+	Reasons   map[string]int64 `json:"reasons"`
+	LastError string           `json:"last_error"`
+	LastSeen  time.Time        `json:"last_seen"`
+}
+
+// HandshakeReport is the handshake failures since start or the last reset.
+type HandshakeReport struct {
+	Failures int64            `json:"failures"`
+	Reasons  map[string]int64 `json:"reasons"`
+	// Clients are the IPs with failures, most first.
+	Clients []HandshakeClient `json:"clients"`
+}
+
+// HandshakeErrors counts failed TLS handshakes by reason and source IP.
+// http.Server only logs them, so it is fed through ErrorLog.
+type HandshakeErrors struct {
+	mu      sync.Mutex
+	total   int64
+	reasons map[string]int64
+	clients map[string]*HandshakeClient
+	now     func() time.Time
+}
+
+func NewHandshakeErrors() *HandshakeErrors {
+	return &HandshakeErrors{
+		reasons: make(map[string]int64),
+		clients: make(map[string]*HandshakeClient),
+		now:     time.Now,
+	}
+}
+
+const handshakeErrorPrefix = "http: TLS handshake error from "
+
+// ErrorLog returns a logger for http.Server.ErrorLog that records handshake
+// errors and writes every message to out, as the default logger would.
+func (h *HandshakeErrors) ErrorLog(out io.Writer) *log.Logger {
+	return log.New(errorLogWriter{h, out}, "", log.LstdFlags)
+}
+
+type errorLogWriter struct {
+	h   *HandshakeErrors
+	out io.Writer
+}
+
+func (w errorLogWriter) Write(p []byte) (int, error) {
+	// Log before counting, so a failure seen in the counts is in the log too.
+	n, writeErr := w.out.Write(p)
+	if i := bytes.Index(p, []byte(handshakeErrorPrefix)); i >= 0 {
+		// "<addr>: <error>"
+		addr, err, ok := strings.Cut(string(bytes.TrimSpace(p[i+len(handshakeErrorPrefix):])), ": ")
+		if ok {
+			w.h.Record(addr, err)
+		}
+	}
+	return n, writeErr
+}
+
+// Record counts a handshake from the client at addr that failed with err.
+func (h *HandshakeErrors) Record(addr, err string) {
+	ip := addr
+	if host, _, splitErr := net.SplitHostPort(addr); splitErr == nil {
+		ip = host
+	}
+	reason := classifyHandshake(err)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.total++
+	h.reasons[reason]++
+	c := h.clients[ip]
+	if c == nil {
+		if len(h.clients) >= maxHandshakeClients {
+			h.forgetOldest()
+		}
+		c = &HandshakeClient{IP: ip, Reasons: make(map[string]int64)}
+		h.clients[ip] = c
+	}
+	c.Failures++
+	c.Reasons[reason]++
+	c.LastError = err
+	c.LastSeen = h.now()
+}
+
+// forgetOldest drops the least recently seen IP. Callers must hold h.mu.
+func (h *HandshakeErrors) forgetOldest() {
+	var oldest *HandshakeClient
+	for _, c := range h.clients {
+		if oldest == nil || c.LastSeen.Before(oldest.LastSeen) {
+			oldest = c
+		}
+	}
+	delete(h.clients, oldest.IP)
+}
+
+// Report returns the failures counted so far.
+func (h *HandshakeErrors) Report() HandshakeReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	report := HandshakeReport{
+		Failures: h.total,
+		Reasons:  make(map[string]int64, len(h.reasons)),
+		Clients:  make([]HandshakeClient, 0, len(h.clients)),
+	}
+	for reason, n := range h.reasons {
+		report.Reasons[reason] = n
+	}
+	for _, c := range h.clients {
+		copied := *c
+		copied.Reasons = make(map[string]int64, len(c.Reasons))
+		for reason, n := range c.Reasons {
+			copied.Reasons[reason] = n
+		}
+		report.Clients = append(report.Clients, copied)
+	}
+	sort.Slice(report.Clients, func(i, j int) bool {
+		a, b := report.Clients[i], report.Clients[j]
+		if a.Failures != b.Failures {
+			return a.Failures > b.Failures
+		}
+		return a.IP < b.IP
+	})
+	return report
+}
+
+// Reset forgets all failures.
+func (h *HandshakeErrors) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.total = 0
+	h.reasons = make(map[string]int64)
+	h.clients = make(map[string]*HandshakeClient)
+}
+
+// AdminHandler serves the handshake failures:
+//
+//	GET    /   failures by reason and by source IP, most first
+//	DELETE /   reset the counts
+func (h *HandshakeErrors) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, h.Report())
+	})
+	mux.HandleFunc("DELETE /{$}", func(w http.ResponseWriter, r *http.Request) {
+		h.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
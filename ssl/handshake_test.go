@@ -0,0 +1,112 @@
+package ssl
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClassifyHandshake(t *testing.T) {
+	for err, want := range map[string]string{
+		`acme/autocert: host "evil.test" not configured in HostWhitelist`: ReasonUnknownSNI,
+		"no certificate found for evil.test":                              ReasonUnknownSNI,
+		"tls: client offered only unsupported versions: [301]":            ReasonProtocolMismatch,
+		"tls: no cipher suite supported by both client and server":        ReasonProtocolMismatch,
+		"tls: client didn't provide a certificate":                        ReasonClientCert,
+		"remote error: tls: bad certificate":                              ReasonCertRejected,
+		"tls: first record does not look like a TLS handshake":            ReasonNotTLS,
+		"EOF": ReasonAborted,
+		"certificate for a.test is not available until 2026-01-01T00:00:00Z after 3 failures: x": ReasonNoCertificate,
+		"something new": ReasonOther,
+	} {
+		if got := classifyHandshake(err); got != want {
+			t.Errorf("%q: got %s, want %s", err, got, want)
+		}
+	}
+}
+
+// lockedBuffer is a bytes.Buffer the server's goroutines can log to while
+// the test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestHandshakeErrorLog(t *testing.T) {
+	h := NewHandshakeErrors()
+	var out lockedBuffer
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.Config.ErrorLog = h.ErrorLog(&out)
+	srv.TLS = &tls.Config{MinVersion: tls.VersionTLS13}
+	srv.StartTLS()
+	defer srv.Close()
+
+	// A plain HTTP request and a client limited to TLS 1.2.
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	conn.Read(make([]byte, 512))
+	conn.Close()
+	tlsConn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	if err == nil {
+		tlsConn.Close()
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Report().Failures < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	report := h.Report()
+	if report.Reasons[ReasonNotTLS] != 1 || report.Reasons[ReasonProtocolMismatch] != 1 {
+		t.Errorf("Unexpected reasons: %+v", report.Reasons)
+	}
+	if len(report.Clients) != 1 || report.Clients[0].IP != "127.0.0.1" || report.Clients[0].Failures != 2 {
+		t.Errorf("Unexpected clients: %+v", report.Clients)
+	}
+	if !strings.Contains(out.String(), "TLS handshake error") {
+		t.Errorf("Expected the errors to still be logged, got %q", out.String())
+	}
+
+	h.Reset()
+	if h.Report().Failures != 0 {
+		t.Error("Expected the counts to be reset")
+	}
+}
+
+func TestHandshakeClientsCapped(t *testing.T) {
+	h := NewHandshakeErrors()
+	now := time.Now()
+	h.now = func() time.Time { now = now.Add(time.Second); return now }
+	for i := 0; i <= maxHandshakeClients; i++ {
+		h.Record(net.JoinHostPort(net.IPv4(10, 0, byte(i>>8), byte(i)).String(), "443"), "EOF")
+	}
+	report := h.Report()
+	if len(report.Clients) != maxHandshakeClients || report.Failures != maxHandshakeClients+1 {
+		t.Errorf("Expected %d clients, got %d", maxHandshakeClients, len(report.Clients))
+	}
+	for _, c := range report.Clients {
+		if c.IP == "10.0.0.0" {
+			t.Error("Expected the least recently seen IP to be forgotten")
+		}
+	}
+}
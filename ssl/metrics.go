@@ -0,0 +1,195 @@
+package ssl
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/admin"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the GetCertificate
+// latency histogram. Lookups in memory take microseconds; the upper buckets
+// are for certificates read from a cache or issued during the handshake.
+var latencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30}
+
+// Metrics measures the certificate lookups of TLS handshakes. Set it as the
+// Metrics of a CertManager or ACMEMonitor before serving.
+type Metrics struct {
+	// Certificates lists the certificates whose expiry is reported.
+	Certificates func() []CertInfo
+	// SNI, if set, is the policy whose refused handshakes are reported.
+	SNI *SNIPolicy
+
+	mu      sync.Mutex
+	buckets []int64
+	sum     time.Duration
+	hits    int64
+	misses  int64
+	errors  int64
+	now     func() time.Time
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{buckets: make([]int64, len(latencyBuckets)), now: time.Now}
+}
+
+// observe records a lookup that took d. A hit found the certificate in
+// memory; a miss fell back to another certificate, or had to load or
+// obtain one.
+func (m *Metrics) observe(d time.Duration, hit bool, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, le := range latencyBuckets {
+		if d.Seconds() <= le {
+			m.buckets[i]++
+		}
+	}
+	m.sum += d
+	switch {
+	case err != nil:
+		m.errors++
+	case hit:
+		m.hits++
+	default:
+		m.misses++
+	}
+}
+
+// TLSStats are the certificate lookups and certificates of the proxy.
+type TLSStats struct {
+	Lookups int64 `json:"lookups"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Errors  int64 `json:"errors"`
+	// MeanLatencyMs is the mean time GetCertificate took.
+	MeanLatencyMs float64      `json:"mean_latency_ms"`
+	Certificates  []CertExpiry `json:"certificates"`
+	// Refused counts the handshakes refused by the SNI policy by reason.
+	Refused map[string]int64 `json:"refused,omitempty"`
+}
+
+// CertExpiry is how long a certificate remains valid.
+type CertExpiry struct {
+	Domain   string    `json:"domain"`
+	NotAfter time.Time `json:"not_after"`
+	DaysLeft float64   `json:"days_left"`
+}
+
+// Stats returns the lookups so far and the certificates, soonest to expire
+// first.
+func (m *Metrics) Stats() TLSStats {
+	m.mu.Lock()
+	s := TLSStats{Hits: m.hits, Misses: m.misses, Errors: m.errors, Certificates: []CertExpiry{}}
+	s.Lookups = s.Hits + s.Misses + s.Errors
+	if s.Lookups > 0 {
+		s.MeanLatencyMs = float64(m.sum.Microseconds()) / 1000 / float64(s.Lookups)
+	}
+	m.mu.Unlock()
+	if m.SNI != nil {
+		s.Refused = m.SNI.Refused()
+	}
+	if m.Certificates == nil {
+		return s
+	}
+	now := m.now()
+	for _, c := range m.Certificates() {
+		s.Certificates = append(s.Certificates, CertExpiry{
+			Domain:   c.Domain,
+			NotAfter: c.NotAfter,
+			DaysLeft: c.NotAfter.Sub(now).Hours() / 24,
+		})
+	}
+	sort.Slice(s.Certificates, func(i, j int) bool { return s.Certificates[i].NotAfter.Before(s.Certificates[j].NotAfter) })
+	return s
+}
+
+// AdminHandler serves the TLS metrics:
+//
+//	GET /          lookups and certificate expiry as JSON
+//	GET /metrics   the same in Prometheus text format
+func (m *Metrics) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, http.StatusOK, m.Stats())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		s := m.Stats()
+		var b strings.Builder
+		fmt.Fprintf(&b, "# HELP proxy_tls_certificates Certificates the proxy serves.\n# TYPE proxy_tls_certificates gauge\n")
+		fmt.Fprintf(&b, "proxy_tls_certificates %d\n", len(s.Certificates))
+		fmt.Fprintf(&b, "# HELP proxy_tls_certificate_expiry_days Days until a certificate expires.\n# TYPE proxy_tls_certificate_expiry_days gauge\n")
+		for _, c := range s.Certificates {
+			fmt.Fprintf(&b, "proxy_tls_certificate_expiry_days{domain=%q} %.3f\n", c.Domain, c.DaysLeft)
+		}
+		fmt.Fprintf(&b, "# HELP proxy_tls_sni_lookups_total Certificate lookups by result.\n# TYPE proxy_tls_sni_lookups_total counter\n")
+		fmt.Fprintf(&b, "proxy_tls_sni_lookups_total{result=\"hit\"} %d\n", s.Hits)
+		fmt.Fprintf(&b, "proxy_tls_sni_lookups_total{result=\"miss\"} %d\n", s.Misses)
+		fmt.Fprintf(&b, "proxy_tls_sni_lookups_total{result=\"error\"} %d\n", s.Errors)
+		if s.Refused != nil {
+			fmt.Fprintf(&b, "# HELP proxy_tls_handshakes_refused_total Handshakes refused for their server name.\n# TYPE proxy_tls_handshakes_refused_total counter\n")
+			for _, reason := range []string{RefusedMissing, RefusedIP, RefusedUnknown} {
+				fmt.Fprintf(&b, "proxy_tls_handshakes_refused_total{reason=%q} %d\n", reason, s.Refused[reason])
+			}
+		}
+
+		m.mu.Lock()
+		buckets := append([]int64(nil), m.buckets...)
+		sum := m.sum
+		m.mu.Unlock()
+		fmt.Fprintf(&b, "# HELP proxy_tls_get_certificate_duration_seconds Time taken to select a certificate for a handshake.\n# TYPE proxy_tls_get_certificate_duration_seconds histogram\n")
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(&b, "proxy_tls_get_certificate_duration_seconds_bucket{le=\"%g\"} %d\n", le, buckets[i])
+		}
+		fmt.Fprintf(&b, "proxy_tls_get_certificate_duration_seconds_bucket{le=\"+Inf\"} %d\n", s.Lookups)
+		fmt.Fprintf(&b, "proxy_tls_get_certificate_duration_seconds_sum %g\n", sum.Seconds())
+		fmt.Fprintf(&b, "proxy_tls_get_certificate_duration_seconds_count %d\n", s.Lookups)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(b.String()))
+	})
+	return mux
+}
+
+// Certificates lists the loaded certificates.
+func (cm *CertManager) Certificates() []CertInfo {
+	return cm.list()
+}
+
+// Certificates lists the certificates served since the monitor started,
+// and those issued or renewed since without being served yet.
+func (am *ACMEMonitor) Certificates() []CertInfo {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	infos := make([]CertInfo, 0, len(am.served))
+	for domain, cert := range am.served {
+		if cert.Leaf != nil {
+			infos = append(infos, CertInfo{Domain: domain, DNSNames: cert.Leaf.DNSNames, NotAfter: cert.Leaf.NotAfter})
+		}
+	}
+	for domain, s := range am.domains {
+		if _, ok := am.served[domain]; !ok && s.NotAfter != nil {
+			infos = append(infos, CertInfo{Domain: domain, NotAfter: *s.NotAfter})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Domain < infos[j].Domain })
+	return infos
+}
+
+// observeServed records the certificate served for domain; serving the
+// one served before is a hit.
+func (am *ACMEMonitor) observeServed(domain string, cert *tls.Certificate, d time.Duration, err error) {
+	am.mu.Lock()
+	hit := cert != nil && am.served[domain] == cert
+	if cert != nil && domain != "" {
+		am.served[domain] = cert
+	}
+	am.mu.Unlock()
+	am.Metrics.observe(d, hit, err)
+}
@@ -0,0 +1,51 @@
+package ssl
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/ssl/ssltest"
+)
+
+func TestMetrics(t *testing.T) {
+	certFile, keyFile := ssltest.TempCert(t, "example.com")
+	cm, err := NewCertManager(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := NewMetrics()
+	cm.Metrics = metrics
+	metrics.Certificates = cm.Certificates
+
+	for _, name := range []string{"example.com", "example.com", "unknown.com"} {
+		cm.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	}
+	cm.SetDefault("")
+	cm.GetCertificate(&tls.ClientHelloInfo{ServerName: "unknown.com"})
+
+	s := metrics.Stats()
+	if s.Lookups != 4 || s.Hits != 2 || s.Misses != 1 || s.Errors != 1 {
+		t.Errorf("Unexpected lookups: %+v", s)
+	}
+	if len(s.Certificates) != 1 || s.Certificates[0].Domain != "example.com" || s.Certificates[0].DaysLeft <= 0 {
+		t.Errorf("Unexpected certificates: %+v", s.Certificates)
+	}
+
+	rec := httptest.NewRecorder()
+	metrics.AdminHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{
+		"proxy_tls_certificates 1\n",
+		`proxy_tls_certificate_expiry_days{domain="example.com"} `,
+		`proxy_tls_sni_lookups_total{result="hit"} 2`,
+		`proxy_tls_get_certificate_duration_seconds_bucket{le="+Inf"} 4`,
+		"proxy_tls_get_certificate_duration_seconds_count 4\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected %q in metrics:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package ssl
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// pausedKey is where the paused domains are kept in the manager's cache,
+// so pauses survive restarts and apply to every instance sharing it. The
+// "+" keeps it from being taken for a domain's certificate.
+const pausedKey = "acme+paused"
+
+// Pause holds back certificate issuance for a domain.
+type Pause struct {
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// errPaused is returned for certificate requests of a paused domain.
+type errPaused struct{ domain string }
+
+func (e errPaused) Error() string {
+	return fmt.Sprintf("certificate issuance for %s is paused", e.domain)
+}
+
+// pauses returns the paused domains, from the cache when there is one.
+func (am *ACMEMonitor) pauses(ctx context.Context) (map[string]Pause, error) {
+	cache := am.manager().Cache
+	if cache == nil {
+		am.mu.Lock()
+		defer am.mu.Unlock()
+		pauses := make(map[string]Pause, len(am.paused))
+		for domain, p := range am.paused {
+			pauses[domain] = p
+		}
+		return pauses, nil
+	}
+	data, err := cache.Get(ctx, pausedKey)
+	if errors.Is(err, autocert.ErrCacheMiss) {
+		return map[string]Pause{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read paused domains: %v", err)
+	}
+	pauses := make(map[string]Pause)
+	if err := json.Unmarshal(data, &pauses); err != nil {
+		return nil, fmt.Errorf("failed to parse paused domains: %v", err)
+	}
+	return pauses, nil
+}
+
+func (am *ACMEMonitor) savePauses(ctx context.Context, pauses map[string]Pause) error {
+	cache := am.manager().Cache
+	if cache == nil {
+		am.mu.Lock()
+		am.paused = pauses
+		am.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(pauses)
+	if err != nil {
+		return err
+	}
+	if err := cache.Put(ctx, pausedKey, data); err != nil {
+		return fmt.Errorf("failed to save paused domains: %v", err)
+	}
+	return nil
+}
+
+// isPaused reports whether issuance for domain is paused. Failing to read
+// the pauses counts as paused, as issuing against the operator's wish costs
+// more than a delayed certificate.
+func (am *ACMEMonitor) isPaused(ctx context.Context, domain string) bool {
+	pauses, err := am.pauses(ctx)
+	if err != nil {
+		log.Printf("Holding back certificate issuance for %s: %v", domain, err)
+		return true
+	}
+	_, ok := pauses[domain]
+	return ok
+}
+
+// Pause stops certificate issuance and renewal for domain, e.g. while its
+// DNS moves elsewhere, so that failing challenges don't use up the CA's
+// rate limits. A cached certificate is still served until it expires.
+func (am *ACMEMonitor) Pause(ctx context.Context, domain, reason string) (ACMEStats, error) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return ACMEStats{}, fmt.Errorf("domain is required")
+	}
+	pauses, err := am.pauses(ctx)
+	if err != nil {
+		return ACMEStats{}, err
+	}
+	pauses[domain] = Pause{Since: am.now().UTC().Truncate(time.Second), Reason: reason}
+	if err := am.savePauses(ctx, pauses); err != nil {
+		return ACMEStats{}, err
+	}
+	return am.domainStats(ctx, domain), nil
+}
+
+// Resume lets certificates for domain be issued again, right away rather
+// than after the retry delay of earlier failures.
+func (am *ACMEMonitor) Resume(ctx context.Context, domain string) (ACMEStats, error) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	pauses, err := am.pauses(ctx)
+	if err != nil {
+		return ACMEStats{}, err
+	}
+	if _, ok := pauses[domain]; !ok {
+		return ACMEStats{}, fmt.Errorf("certificate issuance for %s is not paused", domain)
+	}
+	delete(pauses, domain)
+	if err := am.savePauses(ctx, pauses); err != nil {
+		return ACMEStats{}, err
+	}
+	am.mu.Lock()
+	if s := am.domains[domain]; s != nil {
+		s.ConsecutiveFailures = 0
+		s.RetryAfter = nil
+	}
+	am.mu.Unlock()
+	return am.domainStats(ctx, domain), nil
+}
+
+func (am *ACMEMonitor) domainStats(ctx context.Context, domain string) ACMEStats {
+	for _, s := range am.statsWithPauses(ctx) {
+		if s.Domain == domain {
+			return s
+		}
+	}
+	return ACMEStats{Domain: domain}
+}
+
+// pausingTransport refuses the ACME orders of paused domains. autocert
+// renews certificates in the background without consulting the host
+// policy, so orders are the one place every issuance passes through.
+type pausingTransport struct {
+	next http.RoundTripper
+	am   *ACMEMonitor
+}
+
+func (t *pausingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != http.MethodPost || r.Body == nil {
+		return t.next.RoundTrip(r)
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	for _, domain := range orderedDomains(body) {
+		if t.am.isPaused(r.Context(), domain) {
+			return nil, errPaused{domain}
+		}
+	}
+	return t.next.RoundTrip(r)
+}
+
+// orderedDomains returns the identifiers of a JWS-signed new order request.
+func orderedDomains(body []byte) []string {
+	var jws struct {
+		Payload string `json:"payload"`
+	}
+	if json.Unmarshal(body, &jws) != nil || jws.Payload == "" {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return nil
+	}
+	var order struct {
+		Identifiers []struct {
+			Value string `json:"value"`
+		} `json:"identifiers"`
+	}
+	if json.Unmarshal(payload, &order) != nil {
+		return nil
+	}
+	domains := make([]string, len(order.Identifiers))
+	for i, id := range order.Identifiers {
+		domains[i] = strings.ToLower(id.Value)
+	}
+	return domains
+}
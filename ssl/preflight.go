@@ -0,0 +1,427 @@
+package ssl
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const typeCAA dnsmessage.Type = 257
+
+// CAA is a single Certification Authority Authorization record.
+type CAA struct {
+	Flag  uint8
+	Tag   string
+	Value string
+}
+
+// PreflightError describes why a domain is not ready for certificate issuance.
+type PreflightError struct {
+	Domain string
+	Reason string
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("preflight failed for %s: %s", e.Domain, e.Reason)
+}
+
+// Preflight verifies that a domain resolves to this host and that its CAA
+// records allow the configured CA before any ACME issuance is attempted.
+type Preflight struct {
+	// CAIdentifier is the issuer domain the CA uses in CAA records,
+	// e.g. "letsencrypt.org".
+	CAIdentifier string
+	// HostIPs are the addresses considered to belong to this host. When
+	// empty, records are compared with the addresses of the local
+	// interfaces, which miss the public address of a host behind NAT, so a
+	// mismatch is only logged.
+	HostIPs []net.IP
+
+	LookupIP  func(ctx context.Context, host string) ([]net.IP, error)
+	LookupCAA func(ctx context.Context, domain string) ([]CAA, error)
+}
+
+func NewPreflight(caIdentifier string, hostIPs []net.IP) *Preflight {
+	return &Preflight{
+		CAIdentifier: caIdentifier,
+		HostIPs:      hostIPs,
+		LookupIP: func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		},
+		LookupCAA: LookupCAA,
+	}
+}
+
+// Check returns a *PreflightError if the domain is not ready for issuance.
+func (p *Preflight) Check(ctx context.Context, domain string) error {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	if err := p.checkAddresses(ctx, domain); err != nil {
+		return err
+	}
+	return p.checkCAA(ctx, domain)
+}
+
+// HostPolicy wraps an autocert host policy so that hosts accepted by next
+// must also pass the preflight checks.
+func (p *Preflight) HostPolicy(next autocert.HostPolicy) autocert.HostPolicy {
+	return func(ctx context.Context, host string) error {
+		if next != nil {
+			if err := next(ctx, host); err != nil {
+				return err
+			}
+		}
+		return p.Check(ctx, host)
+	}
+}
+
+func (p *Preflight) checkAddresses(ctx context.Context, domain string) error {
+	ips, err := p.LookupIP(ctx, domain)
+	if err != nil {
+		return &PreflightError{domain, fmt.Sprintf("A/AAAA lookup failed: %v", err)}
+	}
+	if len(ips) == 0 {
+		return &PreflightError{domain, "no A/AAAA records found; point the domain at this host"}
+	}
+
+	hostIPs := p.HostIPs
+	if len(hostIPs) == 0 {
+		hostIPs, err = localIPs()
+		if err != nil {
+			log.Printf("Preflight for %s: failed to list local addresses: %v", domain, err)
+			return nil
+		}
+	}
+
+	var foreign []string
+	for _, ip := range ips {
+		if !containsIP(hostIPs, ip) {
+			foreign = append(foreign, ip.String())
+		}
+	}
+	if len(foreign) > 0 && len(p.HostIPs) == 0 {
+		log.Printf("Preflight for %s: records %s are not local addresses; pass the public address with -public-ip to enforce this check",
+			domain, strings.Join(foreign, ", "))
+		return nil
+	}
+	if len(foreign) > 0 {
+		return &PreflightError{domain, fmt.Sprintf(
+			"records %s do not point to this host; update DNS or pass the public address with -public-ip",
+			strings.Join(foreign, ", "))}
+	}
+	return nil
+}
+
+func (p *Preflight) checkCAA(ctx context.Context, domain string) error {
+	if p.CAIdentifier == "" {
+		return nil
+	}
+
+	// RFC 8659: the relevant record set is the first non-empty one found
+	// while climbing from the domain towards the root. For a wildcard that
+	// climb starts at the domain below the "*.".
+	var records []CAA
+	var owner string
+	for name := strings.TrimPrefix(domain, "*."); name != ""; name = parentDomain(name) {
+		rs, err := p.LookupCAA(ctx, name)
+		if err != nil {
+			return &PreflightError{domain, fmt.Sprintf("CAA lookup for %s failed: %v", name, err)}
+		}
+		if len(rs) > 0 {
+			records, owner = rs, name
+			break
+		}
+	}
+
+	if !caaPermits(records, p.CAIdentifier, strings.HasPrefix(domain, "*.")) {
+		return &PreflightError{domain, fmt.Sprintf(
+			"CAA records on %s do not permit %s; add `%s. CAA 0 issue \"%s\"`",
+			owner, p.CAIdentifier, owner, p.CAIdentifier)}
+	}
+	return nil
+}
+
+func caaPermits(records []CAA, ca string, wildcard bool) bool {
+	var issue, issuewild []CAA
+	for _, r := range records {
+		switch strings.ToLower(r.Tag) {
+		case "issue":
+			issue = append(issue, r)
+		case "issuewild":
+			issuewild = append(issuewild, r)
+		default:
+			// Unknown critical tags forbid issuance.
+			if r.Flag&128 != 0 {
+				return false
+			}
+		}
+	}
+
+	relevant := issue
+	if wildcard && len(issuewild) > 0 {
+		relevant = issuewild
+	}
+	if len(relevant) == 0 {
+		return true
+	}
+	for _, r := range relevant {
+		issuer, _, _ := strings.Cut(r.Value, ";")
+		if strings.EqualFold(strings.TrimSpace(issuer), ca) {
+			return true
+		}
+	}
+	return false
+}
+
+func parentDomain(name string) string {
+	_, parent, found := strings.Cut(name, ".")
+	if !found {
+		return ""
+	}
+	return parent
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func localIPs() ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	return ips, nil
+}
+
+var (
+	errTruncated     = errors.New("truncated DNS reply")
+	errReplyMismatch = errors.New("DNS reply does not match the query")
+)
+
+// LookupCAA queries the system's configured nameservers, in order, for the
+// CAA records of domain, until one answers. The standard library resolver
+// does not support CAA.
+func LookupCAA(ctx context.Context, domain string) ([]CAA, error) {
+	return lookupCAA(ctx, systemNameservers(), domain)
+}
+
+func lookupCAA(ctx context.Context, servers []string, domain string) ([]CAA, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(domain, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	question := dnsmessage.Question{Name: name, Type: typeCAA, Class: dnsmessage.ClassINET}
+
+	var lastErr error
+	for _, server := range servers {
+		records, err := exchangeCAA(ctx, server, question)
+		if err == nil {
+			return records, nil
+		}
+		lastErr = fmt.Errorf("%s: %v", server, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// exchangeCAA asks server for the CAA records of question over UDP, and
+// over TCP if the UDP reply is truncated. Each attempt has a new random
+// query ID, and replies whose ID or question differ are ignored.
+func exchangeCAA(ctx context.Context, server string, question dnsmessage.Question) ([]CAA, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	for _, network := range []string{"udp", "tcp"} {
+		var idBytes [2]byte
+		if _, err := rand.Read(idBytes[:]); err != nil {
+			return nil, err
+		}
+		id := binary.BigEndian.Uint16(idBytes[:])
+
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+		b.EnableCompression()
+		if err := b.StartQuestions(); err != nil {
+			return nil, err
+		}
+		if err := b.Question(question); err != nil {
+			return nil, err
+		}
+		query, err := b.Finish()
+		if err != nil {
+			return nil, err
+		}
+
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, server)
+		if err != nil {
+			return nil, err
+		}
+		deadline, _ := ctx.Deadline()
+		conn.SetDeadline(deadline)
+		var records []CAA
+		if network == "udp" {
+			records, err = exchangeUDP(conn, query, id, question)
+		} else {
+			records, err = exchangeTCP(conn, query, id, question)
+		}
+		conn.Close()
+		if !errors.Is(err, errTruncated) {
+			return records, err
+		}
+	}
+	return nil, errTruncated
+}
+
+func exchangeUDP(conn net.Conn, query []byte, id uint16, question dnsmessage.Question) ([]CAA, error) {
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		records, err := parseCAAResponse(buf[:n], id, question)
+		if errors.Is(err, errReplyMismatch) {
+			// Possibly spoofed, or a late reply to an earlier query.
+			continue
+		}
+		return records, err
+	}
+}
+
+func exchangeTCP(conn net.Conn, query []byte, id uint16, question dnsmessage.Question) ([]CAA, error) {
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	return parseCAAResponse(reply, id, question)
+}
+
+// parseCAAResponse returns the CAA records of a reply to the query with id
+// and question, errReplyMismatch if it answers another query, or
+// errTruncated if the records did not fit.
+func parseCAAResponse(msg []byte, id uint16, question dnsmessage.Question) ([]CAA, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return nil, err
+	}
+	if !h.Response || h.ID != id {
+		return nil, errReplyMismatch
+	}
+	q, err := p.Question()
+	if err != nil || q.Type != question.Type || q.Class != question.Class || !strings.EqualFold(q.Name.String(), question.Name.String()) {
+		return nil, errReplyMismatch
+	}
+	if h.Truncated {
+		return nil, errTruncated
+	}
+	if h.RCode == dnsmessage.RCodeNameError {
+		return nil, nil
+	}
+	if h.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("dns error: %v", h.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+
+	var records []CAA
+	for {
+		rh, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rh.Type != typeCAA {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		res, err := p.UnknownResource()
+		if err != nil {
+			return nil, err
+		}
+		record, err := decodeCAA(res.Data)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func decodeCAA(data []byte) (CAA, error) {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return CAA{}, errors.New("malformed CAA record")
+	}
+	tagLen := int(data[1])
+	return CAA{
+		Flag:  data[0],
+		Tag:   string(data[2 : 2+tagLen]),
+		Value: string(data[2+tagLen:]),
+	}, nil
+}
+
+// systemNameservers returns the nameservers of /etc/resolv.conf, or the
+// local one if it lists none.
+func systemNameservers() []string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return []string{"127.0.0.1:53"}
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	if len(servers) == 0 {
+		return []string{"127.0.0.1:53"}
+	}
+	return servers
+}
@@ -0,0 +1,244 @@
+package ssl
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func fakePreflight(ips map[string][]net.IP, caa map[string][]CAA) *Preflight {
+	return &Preflight{
+		CAIdentifier: "letsencrypt.org",
+		HostIPs:      []net.IP{net.ParseIP("203.0.113.10")},
+		LookupIP: func(ctx context.Context, host string) ([]net.IP, error) {
+			return ips[host], nil
+		},
+		LookupCAA: func(ctx context.Context, domain string) ([]CAA, error) {
+			return caa[domain], nil
+		},
+	}
+}
+
+func TestPreflightCheck(t *testing.T) {
+	ours := []net.IP{net.ParseIP("203.0.113.10")}
+
+	tests := []struct {
+		name        string
+		ips         map[string][]net.IP
+		caa         map[string][]CAA
+		domain      string
+		unknown     bool
+		expectError bool
+	}{
+		{
+			name:   "Points here, no CAA",
+			ips:    map[string][]net.IP{"example.com": ours},
+			domain: "example.com",
+		},
+		{
+			name:        "No records",
+			ips:         map[string][]net.IP{},
+			domain:      "example.com",
+			expectError: true,
+		},
+		{
+			name:        "Points elsewhere",
+			ips:         map[string][]net.IP{"example.com": {net.ParseIP("198.51.100.1")}},
+			domain:      "example.com",
+			expectError: true,
+		},
+		{
+			name:    "Points elsewhere, host addresses unknown",
+			ips:     map[string][]net.IP{"example.com": {net.ParseIP("198.51.100.1")}},
+			domain:  "example.com",
+			unknown: true,
+		},
+		{
+			name:        "No records, host addresses unknown",
+			ips:         map[string][]net.IP{},
+			domain:      "example.com",
+			unknown:     true,
+			expectError: true,
+		},
+		{
+			name:        "CAA forbids CA, host addresses unknown",
+			ips:         map[string][]net.IP{"example.com": {net.ParseIP("198.51.100.1")}},
+			caa:         map[string][]CAA{"example.com": {{Tag: "issue", Value: "pki.goog"}}},
+			domain:      "example.com",
+			unknown:     true,
+			expectError: true,
+		},
+		{
+			name:   "CAA on parent permits CA",
+			ips:    map[string][]net.IP{"www.example.com": ours},
+			caa:    map[string][]CAA{"example.com": {{Tag: "issue", Value: "letsencrypt.org"}}},
+			domain: "www.example.com",
+		},
+		{
+			name:        "CAA forbids CA",
+			ips:         map[string][]net.IP{"example.com": ours},
+			caa:         map[string][]CAA{"example.com": {{Tag: "issue", Value: "pki.goog; cansignhttpexchanges=yes"}}},
+			domain:      "example.com",
+			expectError: true,
+		},
+		{
+			name: "Wildcard climbs from its base domain",
+			ips:  map[string][]net.IP{"*.example.com": ours},
+			caa: map[string][]CAA{
+				"*.example.com": {{Tag: "issue", Value: "pki.goog"}},
+				"example.com":   {{Tag: "issuewild", Value: "letsencrypt.org"}},
+			},
+			domain: "*.example.com",
+		},
+		{
+			name:        "Unknown critical tag",
+			ips:         map[string][]net.IP{"example.com": ours},
+			caa:         map[string][]CAA{"example.com": {{Flag: 128, Tag: "future", Value: "x"}}},
+			domain:      "example.com",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pf := fakePreflight(tt.ips, tt.caa)
+			if tt.unknown {
+				pf.HostIPs = nil
+			}
+			err := pf.Check(context.Background(), tt.domain)
+			if tt.expectError {
+				var pe *PreflightError
+				if !errors.As(err, &pe) {
+					t.Errorf("Expected PreflightError but got %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeCAA(t *testing.T) {
+	record, err := decodeCAA(append([]byte{0, 5}, "issueletsencrypt.org"...))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if record.Tag != "issue" || record.Value != "letsencrypt.org" {
+		t.Errorf("Unexpected record: %+v", record)
+	}
+
+	if _, err := decodeCAA([]byte{0, 9, 'a'}); err == nil {
+		t.Error("Expected error for truncated record")
+	}
+}
+
+// fakeNameserver answers DNS queries over UDP and TCP on the same port with
+// the replies of answer, sent in order.
+func fakeNameserver(t *testing.T, answer func(query dnsmessage.Message, tcp bool) []dnsmessage.Message) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if query.Unpack(buf[:n]) != nil {
+				continue
+			}
+			for _, reply := range answer(query, false) {
+				msg, _ := reply.Pack()
+				pc.WriteTo(msg, addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			io.ReadFull(conn, length[:])
+			buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+			io.ReadFull(conn, buf)
+			var query dnsmessage.Message
+			if query.Unpack(buf) == nil {
+				for _, reply := range answer(query, true) {
+					msg, _ := reply.Pack()
+					conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(msg))))
+					conn.Write(msg)
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestLookupCAA(t *testing.T) {
+	failing := fakeNameserver(t, func(query dnsmessage.Message, tcp bool) []dnsmessage.Message {
+		return []dnsmessage.Message{{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: dnsmessage.RCodeServerFailure},
+			Questions: query.Questions,
+		}}
+	})
+
+	var udpQueries, tcpQueries atomic.Int32
+	working := fakeNameserver(t, func(query dnsmessage.Message, tcp bool) []dnsmessage.Message {
+		reply := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true},
+			Questions: query.Questions,
+		}
+		if !tcp {
+			udpQueries.Add(1)
+			// A spoofed reply, a reply to another question, then the
+			// real one, which does not fit.
+			spoofed := reply
+			spoofed.ID++
+			other := reply
+			other.Questions = []dnsmessage.Question{{Name: dnsmessage.MustNewName("evil.test."), Type: typeCAA, Class: dnsmessage.ClassINET}}
+			truncated := reply
+			truncated.Truncated = true
+			return []dnsmessage.Message{spoofed, other, truncated}
+		}
+		tcpQueries.Add(1)
+		reply.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: query.Questions[0].Name, Type: typeCAA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.UnknownResource{Type: typeCAA, Data: append([]byte{0, 5}, "issueletsencrypt.org"...)},
+		}}
+		return []dnsmessage.Message{reply}
+	})
+
+	records, err := lookupCAA(context.Background(), []string{failing, working}, "example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(records) != 1 || records[0].Tag != "issue" || records[0].Value != "letsencrypt.org" {
+		t.Errorf("Unexpected records: %+v", records)
+	}
+	if udpQueries.Load() != 1 || tcpQueries.Load() != 1 {
+		t.Errorf("Expected one UDP and one TCP query, got %d and %d", udpQueries.Load(), tcpQueries.Load())
+	}
+
+	if _, err := lookupCAA(context.Background(), []string{failing}, "example.com"); err == nil {
+		t.Error("Expected an error when no nameserver answers")
+	}
+}
@@ -0,0 +1,102 @@
+package ssl
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrUnknownDomain is returned for a domain the CertManager holds no
+// certificate for.
+var ErrUnknownDomain = errors.New("no certificate loaded for domain")
+
+// Reload re-reads the certificate and key files from disk, e.g. after an
+// external tool renewed them.
+func (cm *CertManager) Reload() error {
+	if err := cm.loadCertificate(); err != nil {
+		return err
+	}
+
+	cm.RLock()
+	pairs := append([][2]string(nil), cm.extraPairs...)
+	cm.RUnlock()
+	for _, pair := range pairs {
+		if err := cm.loadPair(pair[0], pair[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Renew re-reads the certificate and key files domain was loaded from, e.g.
+// after an external tool renewed them.
+func (cm *CertManager) Renew(domain string) error {
+	cm.RLock()
+	source, ok := cm.sources[domain]
+	cm.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownDomain, domain)
+	}
+	return cm.loadPair(source[0], source[1])
+}
+
+// Install validates a PEM encoded certificate and key, writes them over the
+// files the certificate for their domain was loaded from, or the primary
+// pair for a new domain, and starts serving them.
+func (cm *CertManager) Install(certPEM, keyPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("invalid key pair: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	cm.RLock()
+	source, ok := cm.sources[certDomain(leaf)]
+	cm.RUnlock()
+	if !ok {
+		source = [2]string{cm.certFile, cm.keyFile}
+	}
+	if err := persist(source, certPEM, keyPEM); err != nil {
+		return err
+	}
+	return cm.storeCertificate(&cert, source)
+}
+
+// persist writes both files of source via temporary files so a crash never
+// leaves a certificate paired with the wrong key on disk.
+func persist(source [2]string, certPEM, keyPEM []byte) error {
+	if err := writeFileAtomic(source[1], keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write key: %v", err)
+	}
+	if err := writeFileAtomic(source[0], certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %v", err)
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
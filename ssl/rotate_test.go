@@ -0,0 +1,208 @@
+package ssl
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kirtansoni/reverse-proxy-go/ssl/ssltest"
+)
+
+// selfSigningIssuer signs certificates for a domain with the key it is
+// given.
+type selfSigningIssuer struct{}
+
+func (selfSigningIssuer) Issue(ctx context.Context, domain string, key crypto.Signer) ([]byte, error) {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour * 24),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+func currentCert(t *testing.T, cm *CertManager, domain string) *tls.Certificate {
+	cert, err := cm.GetCertificate(&tls.ClientHelloInfo{ServerName: domain})
+	if err != nil {
+		t.Fatalf("Failed to get certificate: %v", err)
+	}
+	return cert
+}
+
+// selfSignedPair returns a PEM certificate for domain, signed with a new
+// key, and that key.
+func selfSignedPair(t *testing.T, domain string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err = selfSigningIssuer{}.Issue(context.Background(), domain, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestInstall(t *testing.T) {
+	domain := "example.com"
+	certFile, keyFile := ssltest.TempCert(t, domain)
+
+	cm, err := NewCertManager(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to create CertManager: %v", err)
+	}
+
+	before := currentCert(t, cm, domain)
+	if err := cm.Install(selfSignedPair(t, domain)); err != nil {
+		t.Fatalf("Unexpected error installing pair: %v", err)
+	}
+	after := currentCert(t, cm, domain)
+	if before == after {
+		t.Error("Expected certificate to be swapped")
+	}
+
+	// The new pair must have been persisted to the configured files.
+	if err := cm.Reload(); err != nil {
+		t.Fatalf("Failed to reload installed pair: %v", err)
+	}
+	if string(currentCert(t, cm, domain).Certificate[0]) != string(after.Certificate[0]) {
+		t.Error("Installed certificate was not written to disk")
+	}
+}
+
+func TestInstallPersistsToSourcePair(t *testing.T) {
+	certFile, keyFile := ssltest.TempCert(t, "example.com")
+	extraCert, extraKey := ssltest.TempCert(t, "other.test")
+
+	cm, err := NewCertManager(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to create CertManager: %v", err)
+	}
+	if err := cm.AddCertificate(extraCert, extraKey); err != nil {
+		t.Fatalf("Failed to add certificate: %v", err)
+	}
+	primary, _ := os.ReadFile(certFile)
+
+	if err := cm.Install(selfSignedPair(t, "other.test")); err != nil {
+		t.Fatalf("Unexpected error installing pair: %v", err)
+	}
+	if data, _ := os.ReadFile(certFile); string(data) != string(primary) {
+		t.Error("Installing an extra pair overwrote the primary certificate")
+	}
+	installed, err := tls.LoadX509KeyPair(extraCert, extraKey)
+	if err != nil {
+		t.Fatalf("Failed to load installed pair: %v", err)
+	}
+	if string(installed.Certificate[0]) != string(currentCert(t, cm, "other.test").Certificate[0]) {
+		t.Error("Installed certificate was not written to its own files")
+	}
+}
+
+func TestRenewReloads(t *testing.T) {
+	domain := "example.com"
+	certFile, keyFile := ssltest.TempCert(t, domain)
+
+	cm, err := NewCertManager(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to create CertManager: %v", err)
+	}
+
+	// Replace the files on disk, as an external renewal would.
+	replace := func(domains ...string) {
+		newCert, newKey := ssltest.TempCert(t, domains...)
+		for src, dst := range map[string]string{newCert: certFile, newKey: keyFile} {
+			data, _ := os.ReadFile(src)
+			os.WriteFile(dst, data, 0600)
+		}
+	}
+	replace(domain)
+
+	before := currentCert(t, cm, domain)
+	if err := cm.Renew(domain); err != nil {
+		t.Fatalf("Unexpected error renewing: %v", err)
+	}
+	if before == currentCert(t, cm, domain) {
+		t.Error("Expected certificate to be reloaded")
+	}
+
+	if err := cm.Renew("nope.example"); !errors.Is(err, ErrUnknownDomain) {
+		t.Errorf("Expected ErrUnknownDomain, got %v", err)
+	}
+
+	// A renewal naming the certificate differently replaces the old entry.
+	replace("www.example.com", domain)
+	if err := cm.Renew(domain); err != nil {
+		t.Fatalf("Unexpected error renewing: %v", err)
+	}
+	if infos := cm.list(); len(infos) != 1 || infos[0].Domain != "www.example.com" {
+		t.Errorf("Expected only the renamed certificate, got %+v", infos)
+	}
+	if _, domain, _ := cm.selectCertificate("unknown.test"); domain != "www.example.com" {
+		t.Errorf("Expected the default to follow the renamed certificate, got %s", domain)
+	}
+}
+
+func TestInstallRejectsMismatchedPair(t *testing.T) {
+	certFile, keyFile := ssltest.TempCert(t, "example.com")
+	_, otherKey := ssltest.TempCert(t, "example.com")
+
+	cm, err := NewCertManager(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to create CertManager: %v", err)
+	}
+
+	certPEM, _ := os.ReadFile(certFile)
+	keyPEM, _ := os.ReadFile(otherKey)
+	if err := cm.Install(certPEM, keyPEM); err == nil {
+		t.Error("Expected error installing mismatched pair")
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	certFile, keyFile := ssltest.TempCert(t, "example.com")
+
+	cm, err := NewCertManager(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to create CertManager: %v", err)
+	}
+	handler := cm.AdminHandler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/rollkey", strings.NewReader("{}")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a pair, got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/renew?domain=nope.example", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown domain, got %v", w.Code)
+	}
+}
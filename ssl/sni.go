@@ -0,0 +1,81 @@
+package ssl
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Reasons an SNIPolicy refuses a handshake.
+const (
+	RefusedMissing = "missing_sni"
+	RefusedIP      = "ip_sni"
+	RefusedUnknown = "unknown_sni"
+)
+
+// sniCheckTimeout bounds Allow, which runs during the handshake.
+const sniCheckTimeout = 2 * time.Second
+
+// SNIPolicy refuses TLS handshakes before a certificate is looked up, so
+// scanners visiting the bare IP or guessing host names never reach the HTTP
+// stack or trigger certificate issuance.
+type SNIPolicy struct {
+	// Unknown also refuses server names Allow rejects; otherwise only
+	// handshakes without a server name, or with an IP address as one, are.
+	Unknown bool
+	// Allow reports whether a server name is one the proxy serves.
+	Allow func(ctx context.Context, name string) error
+
+	missing, ip, unknown atomic.Int64
+}
+
+// Config returns cfg refusing handshakes by the policy.
+func (p *SNIPolicy) Config(cfg *tls.Config) *tls.Config {
+	out := cfg.Clone()
+	next := cfg.GetConfigForClient
+	out.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if err := p.check(hello); err != nil {
+			return nil, err
+		}
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+	return out
+}
+
+func (p *SNIPolicy) check(hello *tls.ClientHelloInfo) error {
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if name == "" {
+		p.missing.Add(1)
+		return fmt.Errorf("refused handshake: missing server name")
+	}
+	if net.ParseIP(name) != nil {
+		p.ip.Add(1)
+		return fmt.Errorf("refused handshake: refused server name %s, an IP address", name)
+	}
+	if !p.Unknown || p.Allow == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(hello.Context(), sniCheckTimeout)
+	defer cancel()
+	if err := p.Allow(ctx, name); err != nil {
+		p.unknown.Add(1)
+		return fmt.Errorf("refused handshake: refused server name %s: %v", name, err)
+	}
+	return nil
+}
+
+// Refused counts the refused handshakes by reason.
+func (p *SNIPolicy) Refused() map[string]int64 {
+	return map[string]int64{
+		RefusedMissing: p.missing.Load(),
+		RefusedIP:      p.ip.Load(),
+		RefusedUnknown: p.unknown.Load(),
+	}
+}
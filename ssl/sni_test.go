@@ -0,0 +1,55 @@
+package ssl
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/kirtansoni/reverse-proxy-go/ssl/ssltest"
+)
+
+func TestSNIPolicy(t *testing.T) {
+	certFile, keyFile := ssltest.TempCert(t, "example.com")
+	cm, err := NewCertManager(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &SNIPolicy{Unknown: true, Allow: func(ctx context.Context, name string) error {
+		if name != "example.com" {
+			return errors.New("not served")
+		}
+		return nil
+	}}
+	cfg := policy.Config(cm.GetTLSConfig())
+
+	handshake := func(serverName string) error {
+		client, server := net.Pipe()
+		defer client.Close()
+		go func() {
+			defer server.Close()
+			tls.Server(server, cfg).Handshake()
+		}()
+		return tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+	}
+	if err := handshake("example.com"); err != nil {
+		t.Fatalf("Expected a served name to be accepted, got %v", err)
+	}
+	for _, name := range []string{"", "other.example.com"} {
+		if err := handshake(name); err == nil {
+			t.Errorf("Expected the handshake for %q to be refused", name)
+		}
+	}
+	// Go clients send no server name for IP addresses; others send it.
+	if err := policy.check(&tls.ClientHelloInfo{ServerName: "203.0.113.7"}); err == nil {
+		t.Error("Expected an IP address as server name to be refused")
+	}
+	refused := policy.Refused()
+	if refused[RefusedMissing] != 1 || refused[RefusedIP] != 1 || refused[RefusedUnknown] != 1 {
+		t.Errorf("Unexpected refusals: %v", refused)
+	}
+	if got := classifyHandshake("refused handshake: refused server name other.example.com: not served"); got != ReasonUnknownSNI {
+		t.Errorf("Expected refusals to classify as %s, got %s", ReasonUnknownSNI, got)
+	}
+}
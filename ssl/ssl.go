@@ -4,14 +4,45 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type CertManager struct {
 	sync.RWMutex
 	certs    map[string]*tls.Certificate
+	// order lists the domains of certs as first loaded, the primary pair
+	// first; when certificates share a name, the earliest is served.
+	order    []string
 	certFile string
 	keyFile  string
+
+	// exact and wildcard index every DNS name of the loaded certificates;
+	// wildcard is keyed by the suffix after "*.".
+	exact    map[string]indexEntry
+	wildcard map[string]indexEntry
+
+	// defaultDomain names the certificate served when no name matches. When
+	// empty, such handshakes fail.
+	defaultDomain string
+	extraPairs    [][2]string
+	// sources holds the certificate and key files each domain was loaded
+	// from, which rotation writes back to.
+	sources map[string][2]string
+
+	// Metrics, if set, measures certificate lookups; names matching no
+	// certificate count as misses.
+	Metrics *Metrics
+
+	debug atomic.Pointer[decisionLog]
+}
+
+type indexEntry struct {
+	domain string
+	cert   *tls.Certificate
 }
 
 func NewCertManager(certFile, keyFile string) (*CertManager, error) {
@@ -23,6 +54,9 @@ func NewCertManager(certFile, keyFile string) (*CertManager, error) {
 	if err := cm.loadCertificate(); err != nil {
 		return nil, fmt.Errorf("failed to load initial certificate: %v", err)
 	}
+	for domain := range cm.certs {
+		cm.defaultDomain = domain
+	}
 	return cm, nil
 }
 
@@ -41,46 +75,152 @@ func (cm* CertManager) GetTLSConfig() *tls.Config{
 }
 
 func (cm *CertManager) GetCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	start := time.Now()
+	cert, domain, reason := cm.selectCertificate(clientHello.ServerName)
+	var err error
+	if cert == nil {
+		err = fmt.Errorf("no certificate found for %s", clientHello.ServerName)
+	}
+	cm.Metrics.observe(time.Since(start), reason == ReasonExact || reason == ReasonWildcard, err)
+	if debug := cm.debug.Load(); debug != nil {
+		debug.record(clientHello, domain, reason)
+	}
+	return cert, err
+}
+
+func (cm *CertManager) selectCertificate(serverName string) (*tls.Certificate, string, string) {
 	cm.RLock()
 	defer cm.RUnlock()
 
-	if cert, ok := cm.certs[clientHello.ServerName]; ok {
-		return cert, nil
+	name := strings.TrimSuffix(strings.ToLower(serverName), ".")
+	if e, ok := cm.exact[name]; ok {
+		return e.cert, e.domain, ReasonExact
+	}
+	if _, suffix, found := strings.Cut(name, "."); found {
+		if e, ok := cm.wildcard[suffix]; ok {
+			return e.cert, e.domain, ReasonWildcard
+		}
 	}
 
-	for _, cert := range cm.certs {
-		return cert, nil
+	if cert, ok := cm.certs[cm.defaultDomain]; ok {
+		return cert, cm.defaultDomain, ReasonFallback
 	}
 
-	return nil, fmt.Errorf("no certificate found for %s", clientHello.ServerName)
+	return nil, "", ReasonNone
+}
+
+// AddCertificate loads an additional certificate and key pair. It is
+// reloaded together with the primary pair, and rotating its domain rewrites
+// these files.
+func (cm *CertManager) AddCertificate(certFile, keyFile string) error {
+	if err := cm.loadPair(certFile, keyFile); err != nil {
+		return err
+	}
+	cm.Lock()
+	cm.extraPairs = append(cm.extraPairs, [2]string{certFile, keyFile})
+	cm.Unlock()
+	return nil
+}
+
+// SetDefault selects the certificate served to clients whose ServerName
+// matches no loaded certificate. An empty domain makes those handshakes fail.
+func (cm *CertManager) SetDefault(domain string) error {
+	cm.Lock()
+	defer cm.Unlock()
+
+	if _, ok := cm.certs[domain]; domain != "" && !ok {
+		return fmt.Errorf("no certificate loaded for default domain %s", domain)
+	}
+	cm.defaultDomain = domain
+	return nil
 }
 
 func (cm *CertManager) loadCertificate() error {
-	cert, err := tls.LoadX509KeyPair(cm.certFile, cm.keyFile)
+	return cm.loadPair(cm.certFile, cm.keyFile)
+}
+
+func (cm *CertManager) loadPair(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
 		return fmt.Errorf("failed to load certificate: %v", err)
 	}
+	return cm.storeCertificate(&cert, [2]string{certFile, keyFile})
+}
 
+// storeCertificate swaps cert, read from or written to the files of source,
+// in for its domain. A certificate previously read from source for another
+// domain is dropped, as the files no longer hold it. Handshakes that already
+// picked the previous certificate keep using it.
+func (cm *CertManager) storeCertificate(cert *tls.Certificate, source [2]string) error {
+	if len(cert.Certificate) == 0 {
+		return nil
+	}
+
+	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	cert.Leaf = x509Cert
+
+	domain := certDomain(x509Cert)
 	cm.Lock()
 	defer cm.Unlock()
-
-	if len(cert.Certificate) > 0 {
-		x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
-		if err != nil {
-			return fmt.Errorf("failed to parse certificate: %v", err)
+	for i, old := range cm.order {
+		if old == domain || cm.sources[old] != source {
+			continue
 		}
-		
-		var domain string
-		if len(x509Cert.DNSNames) > 0 {
-			domain = x509Cert.DNSNames[0]
+		delete(cm.certs, old)
+		delete(cm.sources, old)
+		if _, ok := cm.certs[domain]; ok {
+			cm.order = slices.Delete(cm.order, i, i+1)
 		} else {
-			domain = x509Cert.Subject.CommonName
+			cm.order[i] = domain
 		}
-		
-		cm.certs[domain] = &cert
+		if cm.defaultDomain == old {
+			cm.defaultDomain = domain
+		}
+		break
 	}
-
+	if !slices.Contains(cm.order, domain) {
+		cm.order = append(cm.order, domain)
+	}
+	cm.certs[domain] = cert
+	if cm.sources == nil {
+		cm.sources = make(map[string][2]string)
+	}
+	cm.sources[domain] = source
+	cm.reindex()
 	return nil
 }
 
+// reindex rebuilds the name indexes from cm.certs. Callers must hold the
+// write lock.
+func (cm *CertManager) reindex() {
+	cm.exact = make(map[string]indexEntry)
+	cm.wildcard = make(map[string]indexEntry)
 
+	for _, domain := range cm.order {
+		cert := cm.certs[domain]
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			index := cm.exact
+			if suffix, ok := strings.CutPrefix(name, "*."); ok {
+				index, name = cm.wildcard, suffix
+			}
+			if _, taken := index[name]; !taken {
+				index[name] = indexEntry{domain, cert}
+			}
+		}
+	}
+}
+
+func certDomain(x509Cert *x509.Certificate) string {
+	if len(x509Cert.DNSNames) > 0 {
+		return x509Cert.DNSNames[0]
+	}
+	return x509Cert.Subject.CommonName
+}
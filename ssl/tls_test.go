@@ -1,86 +1,16 @@
 package ssl
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
-	"math/big"
-	"os"
-	"path/filepath"
 	"sync"
 	"testing"
-	"time"
-)
-
-// Helper function to create test certificates
-func createTestCert(t *testing.T, domain string) (certFile, keyFile string) {
-	// Generate private key
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		t.Fatalf("Failed to generate private key: %v", err)
-	}
-
-	// Create certificate template
-	template := x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		Subject: pkix.Name{
-			CommonName: domain,
-		},
-		DNSNames:              []string{domain},
-		NotBefore:            time.Now(),
-		NotAfter:             time.Now().Add(time.Hour * 24),
-		KeyUsage:             x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:          []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
-	}
-
-	// Create certificate
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
-	if err != nil {
-		t.Fatalf("Failed to create certificate: %v", err)
-	}
 
-	// Create temporary directory for test certificates
-	tmpDir, err := os.MkdirTemp("", "tls_test")
-	if err != nil {
-		t.Fatalf("Failed to create temp directory: %v", err)
-	}
-
-	// Write certificate to file
-	certFile = filepath.Join(tmpDir, "cert.pem")
-	certOut, err := os.Create(certFile)
-	if err != nil {
-		t.Fatalf("Failed to create cert.pem: %v", err)
-	}
-	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
-		t.Fatalf("Failed to write cert.pem: %v", err)
-	}
-	certOut.Close()
-
-	// Write private key to file
-	keyFile = filepath.Join(tmpDir, "key.pem")
-	keyOut, err := os.Create(keyFile)
-	if err != nil {
-		t.Fatalf("Failed to create key.pem: %v", err)
-	}
-	if err := pem.Encode(keyOut, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-	}); err != nil {
-		t.Fatalf("Failed to write key.pem: %v", err)
-	}
-	keyOut.Close()
-
-	return certFile, keyFile
-}
+	"github.com/kirtansoni/reverse-proxy-go/ssl/ssltest"
+)
 
 func TestNewCertManager(t *testing.T) {
 	// Create test certificate
-	certFile, keyFile := createTestCert(t, "example.com")
-	defer os.RemoveAll(filepath.Dir(certFile))
+	certFile, keyFile := ssltest.TempCert(t, "example.com")
 
 	tests := []struct {
 		name        string
@@ -129,8 +59,7 @@ func TestNewCertManager(t *testing.T) {
 
 func TestGetCertificate(t *testing.T) {
 	domain := "example.com"
-	certFile, keyFile := createTestCert(t, domain)
-	defer os.RemoveAll(filepath.Dir(certFile))
+	certFile, keyFile := ssltest.TempCert(t, domain)
 
 	cm, err := NewCertManager(certFile, keyFile)
 	if err != nil {
@@ -183,8 +112,7 @@ func TestGetCertificate(t *testing.T) {
 
 func TestConcurrentAccess(t *testing.T) {
 	domain := "example.com"
-	certFile, keyFile := createTestCert(t, domain)
-	defer os.RemoveAll(filepath.Dir(certFile))
+	certFile, keyFile := ssltest.TempCert(t, domain)
 
 	cm, err := NewCertManager(certFile, keyFile)
 	if err != nil {
@@ -238,8 +166,7 @@ func TestConcurrentAccess(t *testing.T) {
 
 func TestRaceConditions(t *testing.T) {
 	domain := "example.com"
-	certFile, keyFile := createTestCert(t, domain)
-	defer os.RemoveAll(filepath.Dir(certFile))
+	certFile, keyFile := ssltest.TempCert(t, domain)
 
 	cm, err := NewCertManager(certFile, keyFile)
 	if err != nil {
@@ -279,8 +206,7 @@ func TestRaceConditions(t *testing.T) {
 
 func TestLoadCertificate(t *testing.T) {
 	domain := "example.com"
-	certFile, keyFile := createTestCert(t, domain)
-	defer os.RemoveAll(filepath.Dir(certFile))
+	certFile, keyFile := ssltest.TempCert(t, domain)
 
 	cm := &CertManager{
 		certs:    make(map[string]*tls.Certificate),
@@ -309,4 +235,102 @@ func TestLoadCertificate(t *testing.T) {
 	if err := cm.loadCertificate(); err == nil {
 		t.Error("Expected error loading invalid certificate but got nil")
 	}
-}
\ No newline at end of file
+}
+func TestWildcardMatching(t *testing.T) {
+	certFile, keyFile := ssltest.TempCert(t, "*.example.com")
+
+	cm, err := NewCertManager(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to create CertManager: %v", err)
+	}
+
+	tests := []struct {
+		serverName string
+		reason     string
+	}{
+		{"foo.example.com", ReasonWildcard},
+		{"FOO.Example.com.", ReasonWildcard},
+		{"a.b.example.com", ReasonFallback},
+		{"example.com", ReasonFallback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			cert, domain, reason := cm.selectCertificate(tt.serverName)
+			if cert == nil {
+				t.Fatal("Expected certificate but got nil")
+			}
+			if reason != tt.reason {
+				t.Errorf("Expected reason %s, got %s", tt.reason, reason)
+			}
+			if domain != "*.example.com" {
+				t.Errorf("Unexpected domain %s", domain)
+			}
+		})
+	}
+}
+
+func TestDefaultCertificate(t *testing.T) {
+	certFile, keyFile := ssltest.TempCert(t, "a.com")
+	otherCert, otherKey := ssltest.TempCert(t, "b.com")
+
+	cm, err := NewCertManager(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to create CertManager: %v", err)
+	}
+	if err := cm.AddCertificate(otherCert, otherKey); err != nil {
+		t.Fatalf("Failed to add certificate: %v", err)
+	}
+
+	unknown := &tls.ClientHelloInfo{ServerName: "unknown.com"}
+	for i := 0; i < 10; i++ {
+		if _, domain, _ := cm.selectCertificate(unknown.ServerName); domain != "a.com" {
+			t.Fatalf("Expected primary certificate as default, got %s", domain)
+		}
+	}
+
+	if err := cm.SetDefault("b.com"); err != nil {
+		t.Fatalf("Unexpected error setting default: %v", err)
+	}
+	if _, domain, _ := cm.selectCertificate(unknown.ServerName); domain != "b.com" {
+		t.Errorf("Expected b.com as default, got %s", domain)
+	}
+
+	if err := cm.SetDefault("missing.com"); err == nil {
+		t.Error("Expected error for unloaded default domain")
+	}
+
+	if err := cm.SetDefault(""); err != nil {
+		t.Fatalf("Unexpected error clearing default: %v", err)
+	}
+	if _, err := cm.GetCertificate(unknown); err == nil {
+		t.Error("Expected handshake failure for unknown ServerName")
+	}
+	if _, err := cm.GetCertificate(&tls.ClientHelloInfo{ServerName: "b.com"}); err != nil {
+		t.Errorf("Unexpected error for known ServerName: %v", err)
+	}
+}
+
+func TestOverlappingCertificates(t *testing.T) {
+	certFile, keyFile := ssltest.TempCert(t, "a.com", "shared.com", "*.wild.com")
+	otherCert, otherKey := ssltest.TempCert(t, "b.com", "shared.com", "*.wild.com")
+
+	for i := 0; i < 20; i++ {
+		cm, err := NewCertManager(certFile, keyFile)
+		if err != nil {
+			t.Fatalf("Failed to create CertManager: %v", err)
+		}
+		if err := cm.AddCertificate(otherCert, otherKey); err != nil {
+			t.Fatalf("Failed to add certificate: %v", err)
+		}
+		// Reloading b.com must not let it take over the shared names.
+		if err := cm.Reload(); err != nil {
+			t.Fatalf("Failed to reload: %v", err)
+		}
+		for name, want := range map[string]string{"shared.com": "a.com", "x.wild.com": "a.com", "b.com": "b.com"} {
+			if _, domain, _ := cm.selectCertificate(name); domain != want {
+				t.Fatalf("%s: expected the certificate of %s, got %s", name, want, domain)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package static
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ImmutableCacheControl is sent with fingerprinted assets, whose contents
+// never change under the same name.
+const ImmutableCacheControl = "public, max-age=31536000, immutable"
+
+// assetRef matches the src and href attributes of HTML pages.
+var assetRef = regexp.MustCompile(`(?i)\b(src|href)="([^"?#]*)([^"]*)"`)
+
+// Fingerprinted returns a handler serving fsys like New that also serves
+// every asset under a name carrying a hash of its contents, such as
+// style.3f2a9c1b20.css, with far-future caching. The src and href
+// attributes of HTML pages are rewritten to those names, so pages can be
+// revalidated on every load while the assets they reference stay cached
+// until they change. fsys is read once, which suits an embed.FS, and must
+// be served at the root path.
+func Fingerprinted(fsys fs.FS) (*Handler, error) {
+	h := &Handler{
+		fsys:         fsys,
+		fingerprints: make(map[string]string),
+		pages:        make(map[string][]byte),
+		etags:        make(map[string]string),
+	}
+	assets := make(map[string]string)
+	var pages []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])[:10]
+		h.etags[name] = strconv.Quote(hash)
+		switch ext := path.Ext(name); {
+		case ext == ".html":
+			pages = append(pages, name)
+		case ext == ".br" || ext == ".gz":
+		default:
+			fingerprinted := strings.TrimSuffix(name, ext) + "." + hash + ext
+			assets[name] = fingerprinted
+			h.fingerprints[fingerprinted] = name
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint static files: %v", err)
+	}
+
+	for _, name := range pages {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fingerprint static files: %v", err)
+		}
+		dir := path.Dir(name)
+		page := assetRef.ReplaceAllFunc(data, func(attr []byte) []byte {
+			m := assetRef.FindSubmatch(attr)
+			ref := string(m[2])
+			if ref == "" || strings.Contains(ref, ":") || strings.HasPrefix(ref, "//") {
+				return attr
+			}
+			target := path.Join(dir, ref)
+			if strings.HasPrefix(ref, "/") {
+				target = strings.TrimPrefix(path.Clean(ref), "/")
+			}
+			fingerprinted, ok := assets[target]
+			if !ok {
+				return attr
+			}
+			return []byte(fmt.Sprintf(`%s="/%s%s"`, m[1], fingerprinted, m[3]))
+		})
+		h.pages[name] = page
+		sum := sha256.Sum256(page)
+		h.etags[name] = strconv.Quote(hex.EncodeToString(sum[:])[:10])
+	}
+	return h, nil
+}
@@ -0,0 +1,182 @@
+// Package static serves files, preferring precompressed .br and .gz
+// siblings when the client accepts those encodings, so assets compressed at
+// build time are never compressed again per request.
+package static
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// encodings are the precompressed siblings looked for, best first.
+var encodings = []struct {
+	name, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Handler serves the files of a file system. Directories serve their
+// index.html; there are no listings.
+type Handler struct {
+	fsys fs.FS
+
+	// Set by Fingerprinted: the original name of every fingerprinted name,
+	// the rewritten HTML pages and content-hash ETags.
+	fingerprints map[string]string
+	pages        map[string][]byte
+	etags        map[string]string
+}
+
+// New returns a handler serving fsys, such as os.DirFS(dir) or an embed.FS.
+func New(fsys fs.FS) *Handler {
+	return &Handler{fsys: fsys}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	if original, ok := h.fingerprints[name]; ok {
+		name = original
+		w.Header().Set("Cache-Control", ImmutableCacheControl)
+	}
+	info, err := fs.Stat(h.fsys, name)
+	if err == nil && info.IsDir() {
+		name = path.Join(name, "index.html")
+		info, err = fs.Stat(h.fsys, name)
+	}
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		if contentType, err = h.sniff(name); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	if page, ok := h.pages[name]; ok {
+		// Pages reference the current fingerprints, so they are revalidated
+		// on every load.
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", h.etags[name])
+		http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(page))
+		return
+	}
+
+	served, encoding := name, ""
+	accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+	for _, enc := range encodings {
+		sibling, err := fs.Stat(h.fsys, name+enc.ext)
+		if err != nil || sibling.IsDir() {
+			continue
+		}
+		// The response depends on Accept-Encoding as soon as a sibling
+		// exists, whichever representation this client gets.
+		w.Header().Set("Vary", "Accept-Encoding")
+		if encoding == "" && accepted(enc.name) {
+			served, encoding, info = name+enc.ext, enc.name, sibling
+		}
+	}
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	// Each representation gets its own ETag, so a cache validating the
+	// gzip variant never gets a 304 for the brotli one.
+	if tag, ok := h.etags[served]; ok {
+		w.Header().Set("ETag", tag)
+	} else {
+		w.Header().Set("ETag", etag(info, encoding))
+	}
+
+	f, err := h.fsys.Open(served)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	// The *os.File of an os.DirFS is passed on as is, so http.ServeContent
+	// copies it with the ReadFrom of the response, which uses sendfile where
+	// the connection allows it. The proxy only serves this handler over TLS,
+	// and its listeners wrap accepted connections for idle tracking, so
+	// there is no zero-copy path worth adding here.
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// sniff detects the content type of a file without a known extension from
+// its uncompressed contents.
+func (h *Handler) sniff(name string) (string, error) {
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+func etag(info fs.FileInfo, encoding string) string {
+	tag := fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+	if encoding != "" {
+		tag += "-" + encoding
+	}
+	return strconv.Quote(tag)
+}
+
+// acceptedEncodings returns whether an Accept-Encoding header accepts an
+// encoding: listed, or covered by *, with a q-value above zero.
+func acceptedEncodings(header string) func(string) bool {
+	q := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if weight, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		q[coding] = weight
+	}
+	return func(encoding string) bool {
+		if weight, ok := q[encoding]; ok {
+			return weight > 0
+		}
+		return q["*"] > 0
+	}
+}
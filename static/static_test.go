@@ -0,0 +1,118 @@
+package static
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestPrecompressedSiblings(t *testing.T) {
+	modified := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"app.js":          {Data: []byte("console.log('plain')"), ModTime: modified},
+		"app.js.br":       {Data: []byte("brotli"), ModTime: modified},
+		"app.js.gz":       {Data: []byte("gzipped"), ModTime: modified},
+		"style.css":       {Data: []byte("body{}"), ModTime: modified},
+		"docs/index.html": {Data: []byte("<!DOCTYPE html><title>docs</title>"), ModTime: modified},
+		"LICENSE":         {Data: []byte("plain text license"), ModTime: modified},
+		"LICENSE.gz":      {Data: []byte("gzipped license"), ModTime: modified},
+	}
+	h := New(fsys)
+
+	serve := func(path, acceptEncoding string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, tc := range []struct {
+		path, accept, body, encoding, vary string
+	}{
+		{"/app.js", "gzip, deflate, br", "brotli", "br", "Accept-Encoding"},
+		{"/app.js", "gzip, br;q=0", "gzipped", "gzip", "Accept-Encoding"},
+		{"/app.js", "", "console.log('plain')", "", "Accept-Encoding"},
+		{"/app.js", "*", "brotli", "br", "Accept-Encoding"},
+		{"/style.css", "br", "body{}", "", ""},
+		{"/docs/", "br", "<!DOCTYPE html><title>docs</title>", "", ""},
+		{"/LICENSE", "gzip", "gzipped license", "gzip", "Accept-Encoding"},
+	} {
+		w := serve(tc.path, tc.accept)
+		if w.Code != http.StatusOK || w.Body.String() != tc.body || w.Header().Get("Content-Encoding") != tc.encoding || w.Header().Get("Vary") != tc.vary {
+			t.Errorf("%s with %q: got %d %q, encoding %q, vary %q", tc.path, tc.accept, w.Code, w.Body, w.Header().Get("Content-Encoding"), w.Header().Get("Vary"))
+		}
+	}
+
+	// Content types come from the uncompressed file.
+	if ct := serve("/app.js", "br").Header().Get("Content-Type"); ct != "text/javascript; charset=utf-8" {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+	if ct := serve("/LICENSE", "gzip").Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Expected the license to be sniffed as text, got %q", ct)
+	}
+
+	// Every representation has its own ETag.
+	br, gz := serve("/app.js", "br").Header().Get("ETag"), serve("/app.js", "gzip").Header().Get("ETag")
+	if br == gz || br == "" {
+		t.Errorf("Expected distinct ETags, got %s and %s", br, gz)
+	}
+	if w := serve("/app.js", "br", "If-None-Match", br); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", w.Code)
+	}
+	if w := serve("/app.js", "gzip", "If-None-Match", br); w.Code != http.StatusOK {
+		t.Errorf("Expected the brotli ETag not to validate the gzip variant, got %d", w.Code)
+	}
+
+	if w := serve("/missing.js", "br"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+}
+
+func TestFingerprinted(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":      {Data: []byte(`<link href="css/site.css" rel="stylesheet"><script src="/app.js?v=1"></script><a href="https://example.com/app.js">x</a>`)},
+		"css/site.css":    {Data: []byte("body{}")},
+		"css/site.css.br": {Data: []byte("brotli")},
+		"app.js":          {Data: []byte("console.log(1)")},
+	}
+	h, err := Fingerprinted(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	page := serve("/", "")
+	m := regexp.MustCompile(`href="/(css/site\.[0-9a-f]{10}\.css)".*src="/(app\.[0-9a-f]{10}\.js)\?v=1".*href="https://example.com/app.js"`).FindStringSubmatch(page.Body.String())
+	if m == nil || page.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("Expected the asset references to be fingerprinted, got %q", page.Body.String())
+	}
+
+	css := serve("/"+m[1], "br")
+	if css.Code != http.StatusOK || css.Body.String() != "brotli" || css.Header().Get("Cache-Control") != ImmutableCacheControl {
+		t.Errorf("Expected the fingerprinted stylesheet to be cached, got %d %q %v", css.Code, css.Body, css.Header())
+	}
+	if plain := serve("/"+m[1], ""); plain.Body.String() != "body{}" || plain.Header().Get("ETag") == css.Header().Get("ETag") {
+		t.Errorf("Expected each encoding to have its own ETag, got %v", plain.Header())
+	}
+	if js := serve("/app.js", ""); js.Body.String() != "console.log(1)" || js.Header().Get("Cache-Control") != "" {
+		t.Errorf("Expected the original name to be served without far-future caching, got %v", js.Header())
+	}
+	if w := serve("/app.0000000000.js", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a stale fingerprint to be missing, got %d", w.Code)
+	}
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket is the bucket holding all keys of a Bolt store.
+var boltBucket = []byte("proxy")
+
+// Bolt stores keys in a single BoltDB file. Every Put is a transaction
+// synced to disk, and the file is locked, so only one process can open it:
+// that process sees every change, and Watch needs no polling.
+type Bolt struct {
+	db       *bolt.DB
+	watchers watchers
+}
+
+// NewBolt opens the BoltDB file at path, creating it if needed. It fails if
+// another process holds the file for more than a second.
+func NewBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	return &Bolt{db: db}, nil
+}
+
+// Close releases the file.
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+func (b *Bolt) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		// v is only valid during the transaction.
+		value = append([]byte{}, v...)
+		return nil
+	})
+	return value, err
+}
+
+func (b *Bolt) Put(ctx context.Context, key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), append([]byte{}, value...))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %v", key, err)
+	}
+	b.watchers.notify(key)
+	return nil
+}
+
+func (b *Bolt) Delete(ctx context.Context, key string) error {
+	var found bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		if found = bucket.Get([]byte(key)) != nil; !found {
+			return nil
+		}
+		return bucket.Delete([]byte(key))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %v", key, err)
+	}
+	if found {
+		b.watchers.notify(key)
+	}
+	return nil
+}
+
+// List returns the keys starting with prefix. Bolt keeps keys sorted, and
+// does not track when they were modified.
+func (b *Bolt) List(ctx context.Context, prefix string) ([]Entry, error) {
+	entries := []Entry{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			entries = append(entries, Entry{Key: string(k), Size: int64(len(v))})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (b *Bolt) Watch(ctx context.Context, key string) <-chan []byte {
+	return b.watchers.watch(ctx, key, b.Get)
+}
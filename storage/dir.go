@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Dir stores each key in a file of its own below a directory, written
+// atomically with a rename, so a crash never leaves a half-written value.
+type Dir struct {
+	path string
+	// PollInterval is how often Watch checks a file; 0 means
+	// DefaultPollInterval.
+	PollInterval time.Duration
+}
+
+// NewDir returns a store keeping its files below dir, created on the first
+// Put.
+func NewDir(dir string) *Dir {
+	return &Dir{path: dir}
+}
+
+// file returns the file holding key, refusing keys that would leave the
+// directory.
+func (d *Dir) file(key string) (string, error) {
+	if key == "" || path.IsAbs(key) || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(d.path, filepath.FromSlash(key)), nil
+}
+
+func (d *Dir) Get(ctx context.Context, key string) ([]byte, error) {
+	name, err := d.file(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (d *Dir) Put(ctx context.Context, key string, value []byte) error {
+	name, err := d.file(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return fmt.Errorf("failed to save %s: %v", key, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %v", key, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save %s: %v", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save %s: %v", key, err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("failed to save %s: %v", key, err)
+	}
+	return nil
+}
+
+func (d *Dir) Delete(ctx context.Context, key string) error {
+	name, err := d.file(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (d *Dir) List(ctx context.Context, prefix string) ([]Entry, error) {
+	entries := []Entry{}
+	err := filepath.WalkDir(d.path, func(name string, e fs.DirEntry, err error) error {
+		if err != nil {
+			if name == d.path && errors.Is(err, os.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if !e.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(d.path, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil {
+			// Removed since the directory was read.
+			return nil
+		}
+		entries = append(entries, Entry{Key: key, Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", d.path, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+func (d *Dir) Watch(ctx context.Context, key string) <-chan []byte {
+	return poll(ctx, d.Get, key, d.PollInterval)
+}
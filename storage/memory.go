@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is a Store that lives as long as the process, for tests and
+// single-instance deployments that need nothing to survive a restart.
+type Memory struct {
+	mu       sync.Mutex
+	values   map[string]entry
+	watchers watchers
+}
+
+type entry struct {
+	value    []byte
+	modified time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{values: make(map[string]entry)}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, e.value...), nil
+}
+
+func (m *Memory) Put(ctx context.Context, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = entry{value: append([]byte{}, value...), modified: time.Now()}
+	m.watchers.notify(key)
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.values[key]; ok {
+		delete(m.values, key)
+		m.watchers.notify(key)
+	}
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := []Entry{}
+	for key, e := range m.values {
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, Entry{Key: key, Size: int64(len(e.value)), Modified: e.modified})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+func (m *Memory) Watch(ctx context.Context, key string) <-chan []byte {
+	return m.watchers.watch(ctx, key, m.Get)
+}
+
+// watchers implements Watch for stores that see every change, because they
+// are the only writer: Put and Delete call notify, and each watcher then
+// reads the key again.
+type watchers struct {
+	mu    sync.Mutex
+	wakes map[string][]chan struct{}
+}
+
+// notify wakes the watchers of key.
+func (ws *watchers) notify(key string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for _, w := range ws.wakes[key] {
+		select {
+		case w <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (ws *watchers) watch(ctx context.Context, key string, get func(context.Context, string) ([]byte, error)) <-chan []byte {
+	wake := make(chan struct{}, 1)
+	ws.mu.Lock()
+	if ws.wakes == nil {
+		ws.wakes = make(map[string][]chan struct{})
+	}
+	ws.wakes[key] = append(ws.wakes[key], wake)
+	ws.mu.Unlock()
+
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		defer func() {
+			ws.mu.Lock()
+			defer ws.mu.Unlock()
+			wakes := ws.wakes[key]
+			for i, w := range wakes {
+				if w == wake {
+					ws.wakes[key] = append(wakes[:i:i], wakes[i+1:]...)
+					break
+				}
+			}
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-wake:
+			}
+			value, _ := get(ctx, key)
+			select {
+			case ch <- value:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisTimeout bounds Redis commands whose context has no deadline.
+const redisTimeout = 5 * time.Second
+
+// Redis is a Store kept in Redis, so several proxy instances can share
+// their route table and bans. It speaks the Redis protocol over a single
+// connection, reconnecting after errors.
+type Redis struct {
+	addr     string
+	tls      bool
+	username string
+	password string
+	db       int
+	prefix   string
+	// PollInterval is how often Watch checks a key; 0 means
+	// DefaultPollInterval. Keyspace notifications are off by default in
+	// Redis, so Watch does not rely on them.
+	PollInterval time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewRedis returns a store for a redis:// or rediss:// URL, e.g.
+// redis://:secret@10.0.0.5:6379/2?prefix=proxy:. No connection is made
+// until the first command.
+func NewRedis(u *url.URL) (*Redis, error) {
+	s := &Redis{addr: u.Host, tls: u.Scheme == "rediss", prefix: u.Query().Get("prefix")}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+		s.db = n
+	}
+	return s, nil
+}
+
+func (s *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := s.do(ctx, "GET", s.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected Redis reply %v", v)
+	}
+	return data, nil
+}
+
+func (s *Redis) Put(ctx context.Context, key string, value []byte) error {
+	_, err := s.do(ctx, "SET", s.prefix+key, string(value))
+	return err
+}
+
+func (s *Redis) Delete(ctx context.Context, key string) error {
+	_, err := s.do(ctx, "DEL", s.prefix+key)
+	return err
+}
+
+func (s *Redis) List(ctx context.Context, prefix string) ([]Entry, error) {
+	pattern := globEscaper.Replace(s.prefix+prefix) + "*"
+	entries := []Entry{}
+	cursor := "0"
+	for {
+		v, err := s.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", "100")
+		if err != nil {
+			return nil, err
+		}
+		reply, ok := v.([]any)
+		if !ok || len(reply) != 2 {
+			return nil, fmt.Errorf("unexpected Redis reply %v", v)
+		}
+		next, _ := reply[0].([]byte)
+		keys, _ := reply[1].([]any)
+		for _, k := range keys {
+			key, _ := k.([]byte)
+			size, err := s.do(ctx, "STRLEN", string(key))
+			if err != nil {
+				return nil, err
+			}
+			n, _ := size.(int64)
+			entries = append(entries, Entry{Key: strings.TrimPrefix(string(key), s.prefix), Size: n})
+		}
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			break
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+func (s *Redis) Watch(ctx context.Context, key string) <-chan []byte {
+	return poll(ctx, s.Get, key, s.PollInterval)
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// do sends a command and returns its reply: nil, []byte, int64, string for
+// status replies, or []any for arrays.
+func (s *Redis) do(ctx context.Context, args ...string) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	v, err := s.roundTrip(ctx, args)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		// The connection is in an unknown state.
+		s.conn.Close()
+		s.conn = nil
+	}
+	return v, err
+}
+
+// connect dials the server and authenticates. Callers must hold s.mu.
+func (s *Redis) connect(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if s.tls {
+		host, _, _ := net.SplitHostPort(s.addr)
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: host}}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+	s.conn, s.r = conn, bufio.NewReader(conn)
+	var setup [][]string
+	if s.password != "" {
+		if s.username != "" {
+			setup = append(setup, []string{"AUTH", s.username, s.password})
+		} else {
+			setup = append(setup, []string{"AUTH", s.password})
+		}
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	for _, args := range setup {
+		if _, err := s.roundTrip(ctx, args); err != nil {
+			conn.Close()
+			s.conn = nil
+			return fmt.Errorf("failed to connect to Redis: %v", err)
+		}
+	}
+	return nil
+}
+
+// roundTrip writes a command and reads its reply. Callers must hold s.mu.
+func (s *Redis) roundTrip(ctx context.Context, args []string) (any, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	s.conn.SetDeadline(deadline)
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(s.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(s.r)
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		// An error reply inside the array is returned only once the rest of
+		// the array is read, so the next reply starts on a fresh line.
+		items := make([]any, n)
+		var replyErr error
+		for i := range items {
+			var rerr redisError
+			if items[i], err = readReply(r); errors.As(err, &rerr) {
+				if replyErr == nil {
+					replyErr = err
+				}
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if replyErr != nil {
+			return nil, replyErr
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
@@ -0,0 +1,147 @@
+// Package storage is the key-value store behind the proxy's persistent
+// state: the route table, IP bans, checkpointed counters and certificates.
+// Subsystems only see a Store, so the same code runs against a directory or
+// BoltDB file on a single host or Redis shared by several instances.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Get for missing keys.
+var ErrNotFound = errors.New("storage: key not found")
+
+// DefaultPollInterval is how often Watch checks a key in stores without
+// change notifications.
+const DefaultPollInterval = 2 * time.Second
+
+// Store is a key-value store. Keys are slash-separated paths such as
+// "routes.json" or "certs/example.com".
+type Store interface {
+	// Get returns the value of key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Put sets key to value, atomically: readers see the old or the new
+	// value, never a mix.
+	Put(ctx context.Context, key string, value []byte) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns the keys starting with prefix, sorted.
+	List(ctx context.Context, prefix string) ([]Entry, error)
+	// Watch sends the value of key each time it changes, or nil when it is
+	// deleted, until ctx is done. Changes made through the same Store are
+	// reported too.
+	Watch(ctx context.Context, key string) <-chan []byte
+}
+
+// Entry is a key returned by List.
+type Entry struct {
+	Key  string
+	Size int64
+	// Modified is zero for stores that do not track it.
+	Modified time.Time
+}
+
+// Open returns the store described by spec: a directory path or
+// file:<path>, bolt:<path> for a BoltDB file,
+// redis://[:password@]host[:port][/db][?prefix=p] (rediss:// for TLS), or
+// memory: for one that lives as long as the process.
+func Open(spec string) (Store, error) {
+	switch {
+	case spec == "memory:":
+		return NewMemory(), nil
+	case strings.HasPrefix(spec, "redis://"), strings.HasPrefix(spec, "rediss://"):
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid store %q: %v", spec, err)
+		}
+		return NewRedis(u)
+	case strings.HasPrefix(spec, "bolt:"):
+		return NewBolt(strings.TrimPrefix(spec, "bolt:"))
+	case strings.HasPrefix(spec, "file:"):
+		return NewDir(strings.TrimPrefix(spec, "file:")), nil
+	case strings.Contains(spec, "://"):
+		return nil, fmt.Errorf("unsupported store %q, expected a directory, bolt:, redis:// or memory:", spec)
+	case spec == "":
+		return nil, errors.New("store is required")
+	}
+	return NewDir(spec), nil
+}
+
+// WithPrefix returns a view of store whose keys are prefixed with prefix,
+// e.g. "certs/", so subsystems sharing a store never see each other's keys.
+func WithPrefix(store Store, prefix string) Store {
+	return &prefixed{store: store, prefix: prefix}
+}
+
+type prefixed struct {
+	store  Store
+	prefix string
+}
+
+func (p *prefixed) Get(ctx context.Context, key string) ([]byte, error) {
+	return p.store.Get(ctx, p.prefix+key)
+}
+
+func (p *prefixed) Put(ctx context.Context, key string, value []byte) error {
+	return p.store.Put(ctx, p.prefix+key, value)
+}
+
+func (p *prefixed) Delete(ctx context.Context, key string) error {
+	return p.store.Delete(ctx, p.prefix+key)
+}
+
+func (p *prefixed) List(ctx context.Context, prefix string) ([]Entry, error) {
+	entries, err := p.store.List(ctx, p.prefix+prefix)
+	for i := range entries {
+		entries[i].Key = strings.TrimPrefix(entries[i].Key, p.prefix)
+	}
+	return entries, err
+}
+
+func (p *prefixed) Watch(ctx context.Context, key string) <-chan []byte {
+	return p.store.Watch(ctx, p.prefix+key)
+}
+
+// poll implements Watch by reading key every interval. Read errors other
+// than ErrNotFound are skipped, so an unreachable store reports nothing
+// rather than a deletion.
+func poll(ctx context.Context, get func(context.Context, string) ([]byte, error), key string, interval time.Duration) <-chan []byte {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	ch := make(chan []byte)
+	last, err := get(ctx, key)
+	known := err == nil || errors.Is(err, ErrNotFound)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			value, err := get(ctx, key)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if known && bytes.Equal(value, last) && (value == nil) == (last == nil) {
+				continue
+			}
+			last, known = value, true
+			select {
+			case ch <- value:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
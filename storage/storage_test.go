@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis serves the commands Redis uses from a map.
+func fakeRedis(t *testing.T, password string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	values := make(map[string]string)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				authed := password == ""
+				for {
+					v, err := readReply(r)
+					if err != nil {
+						return
+					}
+					var args []string
+					for _, a := range v.([]any) {
+						args = append(args, string(a.([]byte)))
+					}
+					mu.Lock()
+					var reply string
+					switch {
+					case args[0] == "AUTH":
+						if authed = args[len(args)-1] == password; authed {
+							reply = "+OK\r\n"
+						} else {
+							reply = "-WRONGPASS invalid username-password pair\r\n"
+						}
+					case !authed:
+						reply = "-NOAUTH Authentication required.\r\n"
+					case args[0] == "SELECT":
+						reply = "+OK\r\n"
+					case args[0] == "GET":
+						if v, ok := values[args[1]]; ok {
+							reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+						} else {
+							reply = "$-1\r\n"
+						}
+					case args[0] == "SET":
+						values[args[1]] = args[2]
+						reply = "+OK\r\n"
+					case args[0] == "DEL":
+						delete(values, args[1])
+						reply = ":1\r\n"
+					case args[0] == "STRLEN":
+						reply = fmt.Sprintf(":%d\r\n", len(values[args[1]]))
+					case args[0] == "SCAN":
+						// Redis only ever asks for an escaped prefix and *.
+						prefix := strings.NewReplacer(`\\`, `\`, `\*`, `*`, `\?`, `?`, `\[`, `[`, `\]`, `]`).Replace(strings.TrimSuffix(args[3], "*"))
+						var keys []string
+						for k := range values {
+							if strings.HasPrefix(k, prefix) {
+								keys = append(keys, fmt.Sprintf("$%d\r\n%s\r\n", len(k), k))
+							}
+						}
+						reply = fmt.Sprintf("*2\r\n$1\r\n0\r\n*%d\r\n%s", len(keys), strings.Join(keys, ""))
+					default:
+						reply = "-ERR unknown command\r\n"
+					}
+					mu.Unlock()
+					conn.Write([]byte(reply))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestStores(t *testing.T) {
+	ctx := context.Background()
+	addr := fakeRedis(t, "secret")
+	redis, err := Open("redis://:secret@" + addr + "/1?prefix=proxy:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redis.(*Redis).PollInterval = 10 * time.Millisecond
+	dir := NewDir(t.TempDir())
+	dir.PollInterval = 10 * time.Millisecond
+	bolt, err := Open("bolt:" + filepath.Join(t.TempDir(), "proxy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.(*Bolt).Close()
+
+	for name, store := range map[string]Store{"dir": dir, "bolt": bolt, "memory": NewMemory(), "redis": redis, "prefixed": WithPrefix(NewMemory(), "certs/")} {
+		if _, err := store.Get(ctx, "routes.json"); err != ErrNotFound {
+			t.Errorf("%s: expected ErrNotFound, got %v", name, err)
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		changes := store.Watch(watchCtx, "routes.json")
+
+		for _, kv := range [][2]string{{"routes.json", `{"services": []}`}, {"certs/a.example.com", "cert"}, {"bans.json", "[]"}} {
+			if err := store.Put(ctx, kv[0], []byte(kv[1])); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		if v, err := store.Get(ctx, "routes.json"); err != nil || string(v) != `{"services": []}` {
+			t.Errorf("%s: unexpected value %q, %v", name, v, err)
+		}
+		select {
+		case v := <-changes:
+			if string(v) != `{"services": []}` {
+				t.Errorf("%s: unexpected change %q", name, v)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: expected the change to be watched", name)
+		}
+
+		entries, err := store.List(ctx, "certs/")
+		if err != nil || len(entries) != 1 || entries[0].Key != "certs/a.example.com" || entries[0].Size != 4 {
+			t.Errorf("%s: unexpected entries %+v, %v", name, entries, err)
+		}
+		if all, _ := store.List(ctx, ""); len(all) != 3 || all[0].Key != "bans.json" {
+			t.Errorf("%s: unexpected entries %+v", name, all)
+		}
+
+		if err := store.Delete(ctx, "routes.json"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete(ctx, "routes.json"); err != nil {
+			t.Errorf("%s: expected deleting a missing key to succeed, got %v", name, err)
+		}
+		select {
+		case v := <-changes:
+			if v != nil {
+				t.Errorf("%s: expected nil for a deletion, got %q", name, v)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: expected the deletion to be watched", name)
+		}
+		cancel()
+	}
+
+	if err := dir.Put(ctx, "../outside", nil); err == nil {
+		t.Error("Expected a key leaving the directory to be rejected")
+	}
+	wrong, _ := NewRedis(&url.URL{Scheme: "redis", Host: addr, User: url.UserPassword("", "wrong")})
+	if _, err := wrong.Get(ctx, "routes.json"); err == nil || err == ErrNotFound {
+		t.Errorf("Expected a failed login to be reported, got %v", err)
+	}
+	if _, err := Open("etcd://10.0.0.5:2379"); err == nil {
+		t.Error("Expected an unsupported store to be rejected")
+	}
+}
+
+func TestReadReplyArrayError(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*3\r\n$1\r\na\r\n-ERR first\r\n-ERR second\r\n+OK\r\n"))
+	if _, err := readReply(r); err == nil || err.Error() != "redis: ERR first" {
+		t.Errorf("Expected the first error in the array, got %v", err)
+	}
+	if v, err := readReply(r); v != "OK" || err != nil {
+		t.Errorf("Expected the next reply after the array, got %v, %v", v, err)
+	}
+}
@@ -0,0 +1,68 @@
+package sxg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// CBOR major types used by signed exchanges (RFC 7049).
+const (
+	cborBytes = 2
+	cborText  = 3
+	cborArray = 4
+	cborMap   = 5
+)
+
+// cborHead encodes the initial bytes of a CBOR item.
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+	}
+}
+
+func cborByteString(b []byte) []byte {
+	return append(cborHead(cborBytes, uint64(len(b))), b...)
+}
+
+func cborTextString(s string) []byte {
+	return append(cborHead(cborText, uint64(len(s))), s...)
+}
+
+func cborArrayOf(items ...[]byte) []byte {
+	out := cborHead(cborArray, uint64(len(items)))
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+// cborMapOf encodes a map of encoded keys to encoded values in canonical
+// order: shorter keys first, then bytewise.
+func cborMapOf(entries map[string][]byte) []byte {
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return bytes.Compare([]byte(keys[i]), []byte(keys[j])) < 0
+	})
+	out := cborHead(cborMap, uint64(len(keys)))
+	for _, k := range keys {
+		out = append(out, k...)
+		out = append(out, entries[k]...)
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+package sxg
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+)
+
+// recordSize is the mi-sha256-03 record size of signed payloads.
+const recordSize = 16 << 10
+
+// miEncode encodes body with the mi-sha256-03 content encoding (Merkle
+// Integrity, draft-thomson-http-mice-03) in records of rs bytes, returning
+// the encoded body and its Digest header value.
+func miEncode(body []byte, rs int) ([]byte, string) {
+	n := (len(body) + rs - 1) / rs
+	if n == 0 {
+		n = 1
+	}
+	record := func(i int) []byte {
+		return body[i*rs : min((i+1)*rs, len(body))]
+	}
+	proofs := make([][]byte, n)
+	last := sha256.Sum256(append(append([]byte{}, record(n-1)...), 0))
+	proofs[n-1] = last[:]
+	for i := n - 2; i >= 0; i-- {
+		h := sha256.New()
+		h.Write(record(i))
+		h.Write(proofs[i+1])
+		h.Write([]byte{1})
+		proofs[i] = h.Sum(nil)
+	}
+
+	out := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(body)+(n-1)*sha256.Size), uint64(rs))
+	for i := 0; i < n; i++ {
+		out = append(out, record(i)...)
+		if i+1 < n {
+			out = append(out, proofs[i+1]...)
+		}
+	}
+	return out, "mi-sha256-03=" + base64.StdEncoding.EncodeToString(proofs[0])
+}
@@ -0,0 +1,172 @@
+package sxg
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// DefaultMaxBody is the largest response signed when Signer.MaxBody is unset.
+const DefaultMaxBody = 8 << 20
+
+// Responses carrying these fields are never signed, since the exchange may
+// be served to other users from a cache.
+var statefulFields = []string{
+	"Authentication-Control", "Authentication-Info", "Clear-Site-Data",
+	"Optional-WWW-Authenticate", "Proxy-Authenticate", "Proxy-Authentication-Info",
+	"Public-Key-Pins", "Sec-WebSocket-Accept", "Set-Cookie", "Set-Cookie2",
+	"SetProfile", "Strict-Transport-Security", "WWW-Authenticate",
+}
+
+var hopFields = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// Accepts reports whether the request asks for a signed exchange.
+func Accepts(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, media := range strings.Split(accept, ",") {
+			params := strings.Split(media, ";")
+			if strings.TrimSpace(strings.ToLower(params[0])) != "application/signed-exchange" {
+				continue
+			}
+			for _, p := range params[1:] {
+				if strings.TrimSpace(p) == "v=b3" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (s *Signer) selected(r *http.Request) bool {
+	if len(s.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range s.Paths {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware answers GET requests for the selected paths that accept
+// signed exchanges with a signed copy of next's response. Responses that
+// are not a cacheable 200, carry stateful fields or exceed MaxBody are
+// passed through unsigned.
+func (s *Signer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !s.selected(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		if !Accepts(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		inner := r.Clone(r.Context())
+		// The payload is signed uncompressed and carried mi-sha256-03 encoded.
+		inner.Header.Del("Accept-Encoding")
+		limit := s.MaxBody
+		if limit <= 0 {
+			limit = DefaultMaxBody
+		}
+		rec := &recorder{w: w, header: make(http.Header), limit: limit}
+		next.ServeHTTP(rec, inner)
+		if rec.passed {
+			return
+		}
+		if !rec.signable() {
+			rec.pass()
+			return
+		}
+		header := rec.header.Clone()
+		for _, name := range hopFields {
+			header.Del(name)
+		}
+		header.Del("Vary")
+		sxg, err := s.Sign("https://"+r.Host+r.URL.RequestURI(), header, rec.body.Bytes())
+		if err != nil {
+			log.Printf("Failed to sign exchange for %s: %v", r.URL.Path, err)
+			rec.pass()
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if cc := rec.header.Get("Cache-Control"); cc != "" {
+			w.Header().Set("Cache-Control", cc)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(sxg)
+	})
+}
+
+// recorder buffers a response until it is signed, switching to writing it
+// through unsigned once it outgrows the limit.
+type recorder struct {
+	w      http.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+	limit  int
+	passed bool
+}
+
+func (rec *recorder) Header() http.Header {
+	if rec.passed {
+		return rec.w.Header()
+	}
+	return rec.header
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.passed {
+		return rec.w.Write(b)
+	}
+	if rec.status != http.StatusOK || rec.body.Len()+len(b) > rec.limit {
+		rec.pass()
+		return rec.w.Write(b)
+	}
+	return rec.body.Write(b)
+}
+
+// pass writes the buffered response through unsigned.
+func (rec *recorder) pass() {
+	if rec.passed {
+		return
+	}
+	rec.passed = true
+	for name, values := range rec.header {
+		rec.w.Header()[name] = values
+	}
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.w.WriteHeader(rec.status)
+	rec.w.Write(rec.body.Bytes())
+	rec.body = bytes.Buffer{}
+}
+
+func (rec *recorder) signable() bool {
+	if rec.status != 0 && rec.status != http.StatusOK || rec.header.Get("Content-Encoding") != "" {
+		return false
+	}
+	for _, name := range statefulFields {
+		if rec.header.Get(name) != "" {
+			return false
+		}
+	}
+	cc := strings.ToLower(rec.header.Get("Cache-Control"))
+	return !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private")
+}
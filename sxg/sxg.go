@@ -0,0 +1,270 @@
+// Package sxg signs selected responses as Signed Exchanges
+// (application/signed-exchange;v=b3) so that supporting crawlers and CDNs
+// can prefetch and serve them from their caches while browsers still
+// attribute the content to this origin.
+package sxg
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// ContentType is the media type of signed exchanges.
+	ContentType = "application/signed-exchange;v=b3"
+	// CertChainType is the media type of the certificate chain at CertURL.
+	CertChainType = "application/cert-chain+cbor"
+	// MaxExpiry is the longest signature lifetime clients accept.
+	MaxExpiry = 7 * 24 * time.Hour
+	// DefaultExpiry is the signature lifetime when Signer.Expiry is unset.
+	DefaultExpiry = 24 * time.Hour
+)
+
+// canSignHTTPExchanges marks certificates allowed to sign exchanges.
+var canSignHTTPExchanges = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 1, 22}
+
+// Signer signs exchanges with a certificate carrying the CanSignHttpExchanges
+// extension and an ECDSA P-256 key.
+type Signer struct {
+	// Certs is the certificate chain, leaf first.
+	Certs []*x509.Certificate
+	Key   *ecdsa.PrivateKey
+	// CertURL is the https URL where CertChainHandler is served.
+	CertURL string
+	// ValidityURL is an https URL on the origin of the signed exchanges.
+	ValidityURL string
+	// Expiry is how long signatures stay valid, at most MaxExpiry.
+	Expiry time.Duration
+	// Paths are the URL path prefixes Middleware signs; empty signs all.
+	Paths []string
+	// MaxBody is the largest response Middleware signs.
+	MaxBody int
+
+	mu   sync.RWMutex
+	ocsp []byte
+}
+
+// Load reads the certificate chain and key from PEM files and checks that
+// they can sign exchanges.
+func Load(certFile, keyFile string) (*Signer, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %v", err)
+	}
+	certs := make([]*x509.Certificate, len(pair.Certificate))
+	for i, der := range pair.Certificate {
+		if certs[i], err = x509.ParseCertificate(der); err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %v", err)
+		}
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("signed exchanges need an ECDSA P-256 key")
+	}
+	return New(certs, key)
+}
+
+// New returns a signer for the chain certs, leaf first.
+func New(certs []*x509.Certificate, key *ecdsa.PrivateKey) (*Signer, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate")
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New("signed exchanges need an ECDSA P-256 key")
+	}
+	if !key.PublicKey.Equal(certs[0].PublicKey) {
+		return nil, errors.New("key does not match the certificate")
+	}
+	if !hasExtension(certs[0], canSignHTTPExchanges) {
+		return nil, errors.New("certificate lacks the CanSignHttpExchanges extension")
+	}
+	return &Signer{Certs: certs, Key: key}, nil
+}
+
+func hasExtension(cert *x509.Certificate, id asn1.ObjectIdentifier) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetOCSP sets the DER OCSP response for the leaf included in the chain.
+func (s *Signer) SetOCSP(der []byte) {
+	s.mu.Lock()
+	s.ocsp = der
+	s.mu.Unlock()
+}
+
+// RefreshOCSP fetches a fresh OCSP response for the leaf from its issuer's
+// responder. Clients reject chains without one.
+func (s *Signer) RefreshOCSP(ctx context.Context) error {
+	if len(s.Certs) < 2 || len(s.Certs[0].OCSPServer) == 0 {
+		return errors.New("the chain has no issuer or OCSP responder")
+	}
+	req, err := ocsp.CreateRequest(s.Certs[0], s.Certs[1], nil)
+	if err != nil {
+		return fmt.Errorf("failed to create OCSP request: %v", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.Certs[0].OCSPServer[0], bytes.NewReader(req))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to fetch OCSP response: %v", err)
+	}
+	defer resp.Body.Close()
+	der, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return fmt.Errorf("failed to fetch OCSP response: %v", err)
+	}
+	parsed, err := ocsp.ParseResponseForCert(der, s.Certs[0], s.Certs[1])
+	if err != nil {
+		return fmt.Errorf("failed to parse OCSP response: %v", err)
+	}
+	if parsed.Status != ocsp.Good {
+		return fmt.Errorf("certificate OCSP status is %d", parsed.Status)
+	}
+	s.SetOCSP(der)
+	return nil
+}
+
+// RunOCSP refreshes the OCSP response every interval until ctx is done.
+func (s *Signer) RunOCSP(ctx context.Context, interval time.Duration) {
+	for {
+		if err := s.RefreshOCSP(ctx); err != nil {
+			log.Printf("Failed to refresh the signed exchange OCSP response: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// CertChain returns the application/cert-chain+cbor encoding of the chain.
+func (s *Signer) CertChain() []byte {
+	s.mu.RLock()
+	staple := s.ocsp
+	s.mu.RUnlock()
+	items := [][]byte{cborTextString("\U0001F4DC\u26D3")}
+	for i, cert := range s.Certs {
+		entry := map[string][]byte{string(cborTextString("cert")): cborByteString(cert.Raw)}
+		if i == 0 {
+			entry[string(cborTextString("ocsp"))] = cborByteString(staple)
+		}
+		items = append(items, cborMapOf(entry))
+	}
+	return cborArrayOf(items...)
+}
+
+// CertChainHandler serves the chain at CertURL.
+func (s *Signer) CertChainHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", CertChainType)
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Write(s.CertChain())
+	})
+}
+
+func (s *Signer) certSHA256() []byte {
+	sum := sha256.Sum256(s.Certs[0].Raw)
+	return sum[:]
+}
+
+// Sign returns the signed exchange of a 200 response to a GET of
+// requestURL. header holds the response fields to sign; it must not carry
+// stateful fields such as Set-Cookie.
+func (s *Signer) Sign(requestURL string, header http.Header, body []byte) ([]byte, error) {
+	if !strings.HasPrefix(requestURL, "https://") {
+		return nil, errors.New("only https URLs can be signed")
+	}
+	if len(requestURL) > 8191 {
+		return nil, errors.New("URL too long to sign")
+	}
+	payload, digest := miEncode(body, recordSize)
+	fields := map[string][]byte{
+		string(cborByteString([]byte(":status"))):          cborByteString([]byte("200")),
+		string(cborByteString([]byte("content-encoding"))): cborByteString([]byte("mi-sha256-03")),
+		string(cborByteString([]byte("digest"))):           cborByteString([]byte(digest)),
+	}
+	for name, values := range header {
+		name = strings.ToLower(name)
+		if name == "content-encoding" || name == "digest" || name == "content-length" {
+			continue
+		}
+		fields[string(cborByteString([]byte(name)))] = cborByteString([]byte(strings.Join(values, ",")))
+	}
+	headers := cborMapOf(fields)
+
+	expiry := s.Expiry
+	if expiry <= 0 {
+		expiry = DefaultExpiry
+	}
+	expiry = min(expiry, MaxExpiry)
+	date := time.Now().Add(-time.Minute).Unix()
+	expires := date + int64(expiry/time.Second)
+	certSHA := s.certSHA256()
+
+	digestSum := sha256.Sum256(signedMessage(certSHA, s.ValidityURL, date, expires, requestURL, headers))
+	sig, err := ecdsa.SignASN1(rand.Reader, s.Key, digestSum[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign exchange: %v", err)
+	}
+	signature := fmt.Sprintf(`sig1;sig=*%s*;integrity="digest/mi-sha256-03";cert-url=%q;cert-sha256=*%s*;validity-url=%q;date=%d;expires=%d`,
+		base64.StdEncoding.EncodeToString(sig), s.CertURL, base64.StdEncoding.EncodeToString(certSHA), s.ValidityURL, date, expires)
+
+	var out bytes.Buffer
+	out.WriteString("sxg1-b3\x00")
+	out.Write(binary.BigEndian.AppendUint16(nil, uint16(len(requestURL))))
+	out.WriteString(requestURL)
+	out.Write(uint24(len(signature)))
+	out.Write(uint24(len(headers)))
+	out.WriteString(signature)
+	out.Write(headers)
+	out.Write(payload)
+	return out.Bytes(), nil
+}
+
+// signedMessage is the message covered by an exchange's signature.
+func signedMessage(certSHA []byte, validityURL string, date, expires int64, requestURL string, headers []byte) []byte {
+	msg := bytes.Repeat([]byte{0x20}, 64)
+	msg = append(msg, "HTTP Exchange 1 b3\x00"...)
+	msg = append(msg, byte(len(certSHA)))
+	msg = append(msg, certSHA...)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(len(validityURL)))
+	msg = append(msg, validityURL...)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(date))
+	msg = binary.BigEndian.AppendUint64(msg, uint64(expires))
+	msg = binary.BigEndian.AppendUint64(msg, uint64(len(requestURL)))
+	msg = append(msg, requestURL...)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(len(headers)))
+	return append(msg, headers...)
+}
+
+func uint24(n int) []byte {
+	return []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+}
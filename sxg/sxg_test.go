@@ -0,0 +1,146 @@
+package sxg
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMIEncode(t *testing.T) {
+	body := []byte("When I grow up, I want to be a watermelon")
+	for rs, want := range map[int]string{
+		16: "mi-sha256-03=IVa9shfs0nyKEhHqtB3WVNANJ2Njm5KjQLjRtnbkYJ4=",
+		41: "mi-sha256-03=dcRDgR2GM35DluAV13PzgnG6+pvQwPywfFvAu1UeFrs=",
+	} {
+		encoded, digest := miEncode(body, rs)
+		if digest != want {
+			t.Errorf("rs=%d: expected %s, got %s", rs, want, digest)
+		}
+		if records := (len(body) + rs - 1) / rs; len(encoded) != 8+len(body)+(records-1)*sha256.Size {
+			t.Errorf("rs=%d: unexpected encoded length %d", rs, len(encoded))
+		}
+	}
+}
+
+func testSigner(t *testing.T) *Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "example.com"},
+		DNSNames:        []string{"example.com"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(90 * 24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: canSignHTTPExchanges, Value: []byte{5, 0}}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	signer, err := New([]*x509.Certificate{cert}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.CertURL = "https://example.com/.well-known/sxg-cert"
+	signer.ValidityURL = "https://example.com/.well-known/sxg-validity"
+	return signer
+}
+
+func TestSign(t *testing.T) {
+	signer := testSigner(t)
+	body := bytes.Repeat([]byte("hello "), 5000)
+	sxg, err := signer.Sign("https://example.com/index.html", http.Header{"Content-Type": {"text/html"}}, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(sxg, []byte("sxg1-b3\x00")) {
+		t.Fatalf("Unexpected magic %q", sxg[:8])
+	}
+	rest := sxg[8:]
+	urlLen := int(binary.BigEndian.Uint16(rest))
+	requestURL := string(rest[2 : 2+urlLen])
+	rest = rest[2+urlLen:]
+	sigLen := int(rest[0])<<16 | int(rest[1])<<8 | int(rest[2])
+	headerLen := int(rest[3])<<16 | int(rest[4])<<8 | int(rest[5])
+	signature := string(rest[6 : 6+sigLen])
+	headers := rest[6+sigLen : 6+sigLen+headerLen]
+	payload := rest[6+sigLen+headerLen:]
+
+	m := regexp.MustCompile(`^sig1;sig=\*([^*]+)\*;integrity="digest/mi-sha256-03";cert-url="([^"]+)";cert-sha256=\*([^*]+)\*;validity-url="([^"]+)";date=(\d+);expires=(\d+)$`).FindStringSubmatch(signature)
+	if m == nil {
+		t.Fatalf("Unexpected signature %q", signature)
+	}
+	sig, _ := base64.StdEncoding.DecodeString(m[1])
+	certSHA, _ := base64.StdEncoding.DecodeString(m[3])
+	date, _ := strconv.ParseInt(m[5], 10, 64)
+	expires, _ := strconv.ParseInt(m[6], 10, 64)
+	if m[2] != signer.CertURL || !bytes.Equal(certSHA, signer.certSHA256()) || expires-date != int64(DefaultExpiry/time.Second) {
+		t.Errorf("Unexpected signature parameters %q", signature)
+	}
+	msg := sha256.Sum256(signedMessage(certSHA, m[4], date, expires, requestURL, headers))
+	if !ecdsa.VerifyASN1(&signer.Key.PublicKey, msg[:], sig) {
+		t.Error("Expected the signature to verify")
+	}
+
+	encoded, digest := miEncode(body, recordSize)
+	if !bytes.Equal(payload, encoded) || !bytes.Contains(headers, []byte(digest)) || !bytes.Contains(headers, []byte("text/html")) {
+		t.Error("Expected the payload and its digest to be signed")
+	}
+
+	chain := signer.CertChain()
+	if !bytes.HasPrefix(chain, []byte("\x82\x67\U0001F4DC⛓")) || !bytes.Contains(chain, signer.Certs[0].Raw) {
+		t.Errorf("Unexpected certificate chain % x", chain[:16])
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	signer := testSigner(t)
+	signer.Paths = []string{"/static/"}
+	handler := signer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/static/cookie.css" {
+			w.Header().Set("Set-Cookie", "a=b")
+		}
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte("body{}"))
+	}))
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "https://example.com"+path, nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	const sxgAccept = "application/signed-exchange;v=b3;q=0.9,*/*;q=0.8"
+
+	w := get("/static/site.css", sxgAccept)
+	if w.Header().Get("Content-Type") != ContentType || !bytes.HasPrefix(w.Body.Bytes(), []byte("sxg1-b3")) || w.Header().Get("Vary") != "Accept" {
+		t.Errorf("Expected a signed exchange, got %v %q", w.Header(), w.Body.String())
+	}
+	for _, tc := range []struct{ path, accept string }{
+		{"/static/site.css", "text/css"},
+		{"/other.css", sxgAccept},
+		{"/static/cookie.css", sxgAccept},
+	} {
+		w := get(tc.path, tc.accept)
+		if w.Header().Get("Content-Type") != "text/css" || w.Body.String() != "body{}" {
+			t.Errorf("%s (%s): expected the response unsigned, got %v %q", tc.path, tc.accept, w.Header(), w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const maxResponseMessage = 16 << 20
+
+// newGRPCClient returns an HTTP/2 client, speaking h2c for http:// targets.
+func newGRPCClient(target *url.URL) *http.Client {
+	// Each transcoder has its own session cache, like proxied services.
+	tr := &http2.Transport{TLSClientConfig: &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(0)}}
+	if target.Scheme == "http" {
+		tr.AllowHTTP = true
+		tr.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return &http.Client{Transport: tr}
+}
+
+// invoke performs a unary gRPC call, decoding the reply into resp. It
+// returns the gRPC status code and message; err is set only for transport
+// failures.
+func (t *Transcoder) invoke(r *http.Request, md protoreflect.MethodDescriptor, req, resp proto.Message) (int, string, error) {
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		return 0, "", err
+	}
+	frame := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	endpoint := *t.target
+	endpoint.Path = "/" + string(md.Parent().FullName()) + "/" + string(md.Name())
+	out, err := http.NewRequestWithContext(r.Context(), http.MethodPost, endpoint.String(), bytes.NewReader(frame))
+	if err != nil {
+		return 0, "", err
+	}
+	out.Header.Set("Content-Type", "application/grpc")
+	out.Header.Set("TE", "trailers")
+	copyMetadata(r.Header, out.Header)
+
+	res, err := t.client.Do(out)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, "", fmt.Errorf("gRPC server returned HTTP %d", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseMessage+5))
+	if err != nil {
+		return 0, "", err
+	}
+
+	// Trailers-only responses carry the status in the headers.
+	status := res.Trailer.Get("Grpc-Status")
+	message := res.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = res.Header.Get("Grpc-Status")
+		message = res.Header.Get("Grpc-Message")
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return 0, "", fmt.Errorf("missing grpc-status")
+	}
+	if code != 0 {
+		message, _ = url.PathUnescape(message)
+		return code, message, nil
+	}
+
+	if len(body) < 5 {
+		return 0, "", fmt.Errorf("truncated gRPC response")
+	}
+	if body[0] != 0 {
+		return 0, "", fmt.Errorf("compressed gRPC responses are not supported")
+	}
+	length := binary.BigEndian.Uint32(body[1:5])
+	if int(length) != len(body)-5 {
+		return 0, "", fmt.Errorf("unexpected gRPC response length")
+	}
+	if err := proto.Unmarshal(body[5:], resp); err != nil {
+		return 0, "", err
+	}
+	return 0, "", nil
+}
+
+// copyMetadata forwards the caller's credentials and Grpc-Metadata-* headers
+// as gRPC metadata.
+func copyMetadata(from, to http.Header) {
+	if auth := from.Get("Authorization"); auth != "" {
+		to.Set("Authorization", auth)
+	}
+	for k, v := range from {
+		if name, ok := strings.CutPrefix(k, "Grpc-Metadata-"); ok {
+			to[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+}
+
+// httpStatus maps gRPC status codes to HTTP statuses.
+func httpStatus(code int) int {
+	switch code {
+	case 1:
+		return 499
+	case 3, 9, 11:
+		return http.StatusBadRequest
+	case 4:
+		return http.StatusGatewayTimeout
+	case 5:
+		return http.StatusNotFound
+	case 6, 10:
+		return http.StatusConflict
+	case 7:
+		return http.StatusForbidden
+	case 8:
+		return http.StatusTooManyRequests
+	case 12:
+		return http.StatusNotImplemented
+	case 14:
+		return http.StatusServiceUnavailable
+	case 16:
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,116 @@
+package transcode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pathTemplate is a parsed google.api.http path template such as
+// "/v1/{name=shelves/*/books/*}:publish".
+type pathTemplate struct {
+	segments []segment
+	verb     string
+}
+
+type segment struct {
+	literal  string // matched literally unless wildcard is set
+	wildcard string // "*" (one segment) or "**" (the rest of the path)
+	variable string // field path captured by this segment, if any
+}
+
+func parseTemplate(tmpl string) (*pathTemplate, error) {
+	if !strings.HasPrefix(tmpl, "/") {
+		return nil, fmt.Errorf("template %q must start with /", tmpl)
+	}
+	t := &pathTemplate{}
+	rest := tmpl[1:]
+
+	// A verb follows the last ':' that is outside any variable.
+	if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.Contains(rest[i:], "}") {
+		rest, t.verb = rest[:i], rest[i+1:]
+	}
+
+	for rest != "" {
+		if strings.HasPrefix(rest, "{") {
+			end := strings.Index(rest, "}")
+			if end < 0 {
+				return nil, fmt.Errorf("template %q has unterminated variable", tmpl)
+			}
+			name, pattern, found := strings.Cut(rest[1:end], "=")
+			if !found {
+				pattern = "*"
+			}
+			for _, p := range strings.Split(pattern, "/") {
+				t.segments = append(t.segments, newSegment(p, name))
+			}
+			rest = strings.TrimPrefix(rest[end+1:], "/")
+			continue
+		}
+
+		part, next, _ := strings.Cut(rest, "/")
+		t.segments = append(t.segments, newSegment(part, ""))
+		rest = next
+	}
+
+	for i, s := range t.segments {
+		if s.wildcard == "**" && i != len(t.segments)-1 {
+			return nil, fmt.Errorf("template %q: ** must be the last segment", tmpl)
+		}
+	}
+	return t, nil
+}
+
+func newSegment(part, variable string) segment {
+	if part == "*" || part == "**" {
+		return segment{wildcard: part, variable: variable}
+	}
+	return segment{literal: part, variable: variable}
+}
+
+// match returns the captured variables if path matches the template.
+func (t *pathTemplate) match(path string) (map[string]string, bool) {
+	path = strings.TrimPrefix(path, "/")
+	if t.verb != "" {
+		var verb string
+		i := strings.LastIndex(path, ":")
+		if i < 0 {
+			return nil, false
+		}
+		path, verb = path[:i], path[i+1:]
+		if verb != t.verb {
+			return nil, false
+		}
+	}
+
+	parts := strings.Split(path, "/")
+	captured := make(map[string][]string)
+	i := 0
+	for _, s := range t.segments {
+		if s.wildcard == "**" {
+			if s.variable != "" {
+				captured[s.variable] = append(captured[s.variable], parts[i:]...)
+			}
+			i = len(parts)
+			break
+		}
+		if i >= len(parts) || parts[i] == "" {
+			return nil, false
+		}
+		if s.wildcard == "" && parts[i] != s.literal {
+			return nil, false
+		}
+		if s.variable != "" {
+			captured[s.variable] = append(captured[s.variable], parts[i])
+		}
+		i++
+	}
+	if i != len(parts) {
+		return nil, false
+	}
+
+	vars := make(map[string]string, len(captured))
+	for name, values := range captured {
+		vars[name] = strings.Join(values, "/")
+	}
+	return vars, true
+}
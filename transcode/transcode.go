@@ -0,0 +1,402 @@
+package transcode
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// httpRuleField is the extension number of google.api.http on MethodOptions.
+const httpRuleField = 72295728
+
+const maxRequestBody = 4 << 20
+
+// Transcoder translates REST/JSON requests into unary gRPC calls using the
+// google.api.http annotations of a compiled descriptor set
+// (protoc --include_imports --descriptor_set_out=...).
+type Transcoder struct {
+	routes []*route
+	target *url.URL
+	client *http.Client
+}
+
+type route struct {
+	httpMethod   string
+	template     *pathTemplate
+	body         string
+	responseBody string
+	method       protoreflect.MethodDescriptor
+}
+
+type httpRule struct {
+	method       string
+	pattern      string
+	body         string
+	responseBody string
+	additional   []httpRule
+}
+
+// New loads the descriptor set at path and prepares routes that call the
+// gRPC server at target ("http://" for h2c, "https://" for TLS).
+func New(descriptorSetPath, target string) (*Transcoder, error) {
+	data, err := os.ReadFile(descriptorSetPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set: %v", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set: %v", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %v", err)
+	}
+
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gRPC target: %v", err)
+	}
+
+	t := &Transcoder{target: targetURL, client: newGRPCClient(targetURL)}
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		services := fd.Services()
+		for i := 0; i < services.Len() && err == nil; i++ {
+			methods := services.Get(i).Methods()
+			for j := 0; j < methods.Len() && err == nil; j++ {
+				err = t.addMethod(methods.Get(j))
+			}
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(t.routes) == 0 {
+		return nil, fmt.Errorf("descriptor set has no google.api.http annotated methods")
+	}
+	return t, nil
+}
+
+func (t *Transcoder) addMethod(md protoreflect.MethodDescriptor) error {
+	opts, ok := md.Options().(*descriptorpb.MethodOptions)
+	if !ok || opts == nil || md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil
+	}
+
+	raw := opts.ProtoReflect().GetUnknown()
+	for len(raw) > 0 {
+		num, typ, n := protowire.ConsumeTag(raw)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		raw = raw[n:]
+		n = protowire.ConsumeFieldValue(num, typ, raw)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if num == httpRuleField && typ == protowire.BytesType {
+			value, _ := protowire.ConsumeBytes(raw)
+			rule, err := parseHTTPRule(value)
+			if err != nil {
+				return fmt.Errorf("%s: invalid google.api.http option: %v", md.FullName(), err)
+			}
+			for _, r := range append([]httpRule{rule}, rule.additional...) {
+				if err := t.addRoute(md, r); err != nil {
+					return fmt.Errorf("%s: %v", md.FullName(), err)
+				}
+			}
+		}
+		raw = raw[n:]
+	}
+	return nil
+}
+
+func (t *Transcoder) addRoute(md protoreflect.MethodDescriptor, rule httpRule) error {
+	if rule.method == "" {
+		return nil
+	}
+	tmpl, err := parseTemplate(rule.pattern)
+	if err != nil {
+		return err
+	}
+	t.routes = append(t.routes, &route{
+		httpMethod:   rule.method,
+		template:     tmpl,
+		body:         rule.body,
+		responseBody: rule.responseBody,
+		method:       md,
+	})
+	return nil
+}
+
+func parseHTTPRule(b []byte) (httpRule, error) {
+	var rule httpRule
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return rule, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return rule, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return rule, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch num {
+		case 2:
+			rule.method, rule.pattern = http.MethodGet, string(v)
+		case 3:
+			rule.method, rule.pattern = http.MethodPut, string(v)
+		case 4:
+			rule.method, rule.pattern = http.MethodPost, string(v)
+		case 5:
+			rule.method, rule.pattern = http.MethodDelete, string(v)
+		case 6:
+			rule.method, rule.pattern = http.MethodPatch, string(v)
+		case 7:
+			rule.body = string(v)
+		case 8:
+			kind, path, err := parseCustomPattern(v)
+			if err != nil {
+				return rule, err
+			}
+			rule.method, rule.pattern = strings.ToUpper(kind), path
+		case 11:
+			additional, err := parseHTTPRule(v)
+			if err != nil {
+				return rule, err
+			}
+			rule.additional = append(rule.additional, additional)
+		case 12:
+			rule.responseBody = string(v)
+		}
+	}
+	return rule, nil
+}
+
+// parseCustomPattern decodes a CustomHttpPattern{kind = 1, path = 2}.
+func parseCustomPattern(b []byte) (kind, path string, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ == protowire.BytesType && (num == 1 || num == 2) {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return "", "", protowire.ParseError(n)
+			}
+			if num == 1 {
+				kind = string(v)
+			} else {
+				path = string(v)
+			}
+			b = b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return kind, path, nil
+}
+
+// ServeHTTP transcodes requests matching an annotated method. Requests that
+// match no route get a 404.
+func (t *Transcoder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt, vars := t.match(r)
+	if rt == nil {
+		writeError(w, http.StatusNotFound, 5, "no gRPC method bound to "+r.Method+" "+r.URL.Path)
+		return
+	}
+
+	req := dynamicpb.NewMessage(rt.method.Input())
+	if err := rt.decodeRequest(r, req, vars); err != nil {
+		writeError(w, http.StatusBadRequest, 3, err.Error())
+		return
+	}
+
+	resp := dynamicpb.NewMessage(rt.method.Output())
+	code, message, err := t.invoke(r, rt.method, req, resp)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, 14, err.Error())
+		return
+	}
+	if code != 0 {
+		writeError(w, httpStatus(code), code, message)
+		return
+	}
+
+	var out proto.Message = resp
+	if rt.responseBody != "" {
+		fd := resp.Descriptor().Fields().ByName(protoreflect.Name(rt.responseBody))
+		if fd == nil || fd.Message() == nil {
+			writeError(w, http.StatusInternalServerError, 13, "invalid response_body "+rt.responseBody)
+			return
+		}
+		out = resp.Get(fd).Message().Interface()
+	}
+	data, err := protojson.Marshal(out)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, 13, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (t *Transcoder) match(r *http.Request) (*route, map[string]string) {
+	for _, rt := range t.routes {
+		if rt.httpMethod != r.Method {
+			continue
+		}
+		if vars, ok := rt.template.match(r.URL.Path); ok {
+			return rt, vars
+		}
+	}
+	return nil, nil
+}
+
+// decodeRequest fills msg from the body, then path variables, then query
+// parameters not already bound.
+func (rt *route) decodeRequest(r *http.Request, msg *dynamicpb.Message, vars map[string]string) error {
+	if rt.body != "" {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody))
+		if err != nil {
+			return err
+		}
+		if rt.body != "*" {
+			fd := msg.Descriptor().Fields().ByName(protoreflect.Name(rt.body))
+			if fd == nil {
+				return fmt.Errorf("unknown body field %s", rt.body)
+			}
+			wrapped, _ := json.Marshal(map[string]json.RawMessage{fd.JSONName(): data})
+			data = wrapped
+		}
+		if len(data) > 0 {
+			if err := protojson.Unmarshal(data, msg); err != nil {
+				return fmt.Errorf("invalid JSON body: %v", err)
+			}
+		}
+	}
+
+	for field, value := range vars {
+		if err := setField(msg, field, value); err != nil {
+			return err
+		}
+	}
+
+	if rt.body == "*" {
+		return nil
+	}
+	for field, values := range r.URL.Query() {
+		if _, bound := vars[field]; bound || field == rt.body {
+			continue
+		}
+		for _, value := range values {
+			if err := setField(msg, field, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setField assigns a string value to the (possibly nested, dot separated)
+// field path of msg.
+func setField(msg protoreflect.Message, path, value string) error {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		fd := msg.Descriptor().Fields().ByName(protoreflect.Name(part))
+		if fd == nil || fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("unknown field %s", path)
+		}
+		msg = msg.Mutable(fd).Message()
+	}
+
+	fd := msg.Descriptor().Fields().ByName(protoreflect.Name(parts[len(parts)-1]))
+	if fd == nil {
+		fd = msg.Descriptor().Fields().ByJSONName(parts[len(parts)-1])
+	}
+	if fd == nil || fd.IsMap() {
+		return fmt.Errorf("unknown field %s", path)
+	}
+	v, err := parseScalar(fd, value)
+	if err != nil {
+		return fmt.Errorf("field %s: %v", path, err)
+	}
+	if fd.IsList() {
+		msg.Mutable(fd).List().Append(v)
+	} else {
+		msg.Set(fd, v)
+	}
+	return nil
+}
+
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(s)), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), err
+	}
+	return protoreflect.Value{}, fmt.Errorf("cannot be set from a string")
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"code": code, "message": message})
+}
@@ -0,0 +1,198 @@
+package transcode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func httpOption(field protowire.Number, pattern, body string) *descriptorpb.MethodOptions {
+	rule := protowire.AppendTag(nil, field, protowire.BytesType)
+	rule = protowire.AppendString(rule, pattern)
+	if body != "" {
+		rule = protowire.AppendTag(rule, 7, protowire.BytesType)
+		rule = protowire.AppendString(rule, body)
+	}
+	raw := protowire.AppendTag(nil, httpRuleField, protowire.BytesType)
+	raw = protowire.AppendBytes(raw, rule)
+
+	opts := &descriptorpb.MethodOptions{}
+	opts.ProtoReflect().SetUnknown(raw)
+	return opts
+}
+
+func scalarField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Type:     typ.Enum(),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+}
+
+func greeterFile() *descriptorpb.FileDescriptorProto {
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("greeter.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("HelloRequest"), Field: []*descriptorpb.FieldDescriptorProto{
+				scalarField("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				scalarField("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+			}},
+			{Name: proto.String("HelloReply"), Field: []*descriptorpb.FieldDescriptorProto{
+				scalarField("message", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Greeter"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name:       proto.String("SayHello"),
+					InputType:  proto.String(".test.HelloRequest"),
+					OutputType: proto.String(".test.HelloReply"),
+					Options:    httpOption(2, "/v1/greeters/{name}", ""),
+				},
+				{
+					Name:       proto.String("Create"),
+					InputType:  proto.String(".test.HelloRequest"),
+					OutputType: proto.String(".test.HelloReply"),
+					Options:    httpOption(4, "/v1/greeters:create", "*"),
+				},
+			},
+		}},
+	}
+}
+
+func writeDescriptorSet(t *testing.T) string {
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{greeterFile()}})
+	if err != nil {
+		t.Fatalf("Failed to marshal descriptor set: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "greeter.pb")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write descriptor set: %v", err)
+	}
+	return path
+}
+
+// fakeGreeter is a minimal gRPC server over h2c.
+func fakeGreeter(t *testing.T) *httptest.Server {
+	fd, err := protodesc.NewFile(greeterFile(), nil)
+	if err != nil {
+		t.Fatalf("Failed to build descriptor: %v", err)
+	}
+	request := fd.Messages().ByName("HelloRequest")
+	reply := fd.Messages().ByName("HelloReply")
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		in := dynamicpb.NewMessage(request)
+		proto.Unmarshal(body[5:], in)
+		name := in.Get(request.Fields().ByName("name")).String()
+		count := in.Get(request.Fields().ByName("count")).Int()
+
+		w.Header().Set("Content-Type", "application/grpc")
+		if name == "missing" {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "greeter%20not%20found")
+			return
+		}
+
+		out := dynamicpb.NewMessage(reply)
+		out.Set(reply.Fields().ByName("message"), protoreflect.ValueOfString(
+			fmt.Sprintf("%s %s x%d", r.URL.Path, name, count)))
+		payload, _ := proto.Marshal(out)
+		frame := make([]byte, 5)
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+		w.Write(append(frame, payload...))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+	return httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+}
+
+func TestTranscoder(t *testing.T) {
+	backend := fakeGreeter(t)
+	defer backend.Close()
+
+	tr, err := New(writeDescriptorSet(t), backend.URL)
+	if err != nil {
+		t.Fatalf("Failed to create transcoder: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{"Path and query binding", "GET", "/v1/greeters/bob?count=3", "", 200, `/test.Greeter/SayHello bob x3`},
+		{"Body binding", "POST", "/v1/greeters:create", `{"name":"amy","count":2}`, 200, `/test.Greeter/Create amy x2`},
+		{"gRPC error status", "GET", "/v1/greeters/missing", "", 404, `greeter not found`},
+		{"Invalid query value", "GET", "/v1/greeters/bob?count=many", "", 400, `count`},
+		{"Unbound path", "GET", "/v2/other", "", 404, `no gRPC method`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tr.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("Expected body containing %q, got %s", tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPathTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		match    bool
+		vars     map[string]string
+	}{
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books/2", true, map[string]string{"name": "shelves/1/books/2"}},
+		{"/v1/shelves/{shelf}", "/v1/shelves/1/books", false, nil},
+		{"/v1/files/{path=**}", "/v1/files/a/b/c", true, map[string]string{"path": "a/b/c"}},
+		{"/v1/{name}:publish", "/v1/book:publish", true, map[string]string{"name": "book"}},
+		{"/v1/{name}:publish", "/v1/book", false, nil},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := parseTemplate(tt.template)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", tt.template, err)
+		}
+		vars, ok := tmpl.match(tt.path)
+		if ok != tt.match {
+			t.Errorf("%s against %s: expected match=%v", tt.template, tt.path, tt.match)
+			continue
+		}
+		for k, v := range tt.vars {
+			if vars[k] != v {
+				t.Errorf("%s against %s: expected %s=%s, got %s", tt.template, tt.path, k, v, vars[k])
+			}
+		}
+	}
+}
@@ -0,0 +1,423 @@
+// Package websocket limits and supervises proxied WebSocket connections. It
+// does not terminate WebSockets: the reverse proxy still copies bytes
+// between client and backend, and this package follows the frame stream
+// from the backend closely enough to inject its own control frames.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Duration is a time.Duration written as a string such as "90s" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config limits the WebSockets of a service.
+type Config struct {
+	// MaxConns caps concurrent upgraded connections; 0 means no cap.
+	MaxConns int `json:"max_conns,omitempty"`
+	// IdleTimeout closes connections with no traffic in either direction
+	// for this long. Pings sent by the proxy do not count as traffic, but
+	// the client's pongs do, so with PingInterval set only connections
+	// whose client has gone away time out.
+	IdleTimeout Duration `json:"idle_timeout,omitempty"`
+	// PingInterval sends a ping to the client this often.
+	PingInterval Duration `json:"ping_interval,omitempty"`
+}
+
+// Close codes from RFC 6455.
+const (
+	CloseGoingAway = 1001
+)
+
+// closeWait is how long the client gets to answer a close frame before the
+// connection is cut.
+const closeWait = time.Second
+
+// Guard enforces a Config on the WebSocket upgrades passing through its
+// middleware.
+type Guard struct {
+	mu    sync.Mutex
+	cfg   Config
+	conns map[*Conn]struct{}
+	// slots counts upgrades in progress and open connections against
+	// MaxConns.
+	slots int
+}
+
+func NewGuard(cfg Config) *Guard {
+	return &Guard{cfg: cfg, conns: make(map[*Conn]struct{})}
+}
+
+// IsUpgrade reports whether r asks to switch to the WebSocket protocol.
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *Guard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !g.acquire() {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "too many WebSocket connections", http.StatusServiceUnavailable)
+			return
+		}
+		hw := &hijackWriter{ResponseWriter: w, guard: g}
+		next.ServeHTTP(hw, r)
+		if hw.conn == nil {
+			// The backend refused the upgrade.
+			g.release()
+		}
+	})
+}
+
+// SetConfig changes the limits. Open connections beyond a lowered MaxConns
+// are kept; the new timeouts apply to connections upgraded from now on.
+func (g *Guard) SetConfig(cfg Config) {
+	g.mu.Lock()
+	g.cfg = cfg
+	g.mu.Unlock()
+}
+
+// Shutdown sends a going-away close frame to every open connection and waits
+// for them to close, which normally happens once the client's close frame
+// has reached the backend. Connections still open when ctx is done are cut,
+// and its error is returned. A connection whose backend is in the middle of
+// a frame gets its close frame as soon as the frame is complete.
+func (g *Guard) Shutdown(ctx context.Context, reason string) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		g.mu.Lock()
+		conns := make([]*Conn, 0, len(g.conns))
+		for c := range g.conns {
+			conns = append(conns, c)
+		}
+		g.mu.Unlock()
+		if len(conns) == 0 {
+			return nil
+		}
+		for _, c := range conns {
+			c.sendClose(CloseGoingAway, reason)
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, c := range conns {
+				c.Close()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Count returns the number of open WebSocket connections.
+func (g *Guard) Count() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.conns)
+}
+
+func (g *Guard) acquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.cfg.MaxConns > 0 && g.slots >= g.cfg.MaxConns {
+		return false
+	}
+	g.slots++
+	return true
+}
+
+func (g *Guard) release() {
+	g.mu.Lock()
+	g.slots--
+	g.mu.Unlock()
+}
+
+func (g *Guard) track(c *Conn) {
+	g.mu.Lock()
+	g.conns[c] = struct{}{}
+	cfg := g.cfg
+	g.mu.Unlock()
+	if cfg.IdleTimeout > 0 || cfg.PingInterval > 0 {
+		go c.supervise(time.Duration(cfg.IdleTimeout), time.Duration(cfg.PingInterval))
+	}
+}
+
+func (g *Guard) untrack(c *Conn) {
+	g.mu.Lock()
+	delete(g.conns, c)
+	g.slots--
+	g.mu.Unlock()
+}
+
+// hijackWriter hands out a supervised connection when the reverse proxy
+// takes over the client connection for the upgrade.
+type hijackWriter struct {
+	http.ResponseWriter
+	guard *Guard
+	conn  *Conn
+}
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = newConn(conn, w.guard)
+	w.guard.track(w.conn)
+	return w.conn, brw, nil
+}
+
+func (w *hijackWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *hijackWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Conn is the client side of a proxied WebSocket.
+type Conn struct {
+	net.Conn
+	guard *Guard
+	done  chan struct{}
+
+	mu           sync.Mutex
+	frames       frameTracker
+	lastActivity time.Time
+	// closing is set once a close frame has been sent or the connection is
+	// about to be cut.
+	closing bool
+	closed  bool
+}
+
+func newConn(conn net.Conn, g *Guard) *Conn {
+	return &Conn{Conn: conn, guard: g, done: make(chan struct{}), lastActivity: time.Now()}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.lastActivity = time.Now()
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// Write forwards backend data to the client. Once a close frame has been
+// sent the data is dropped, as the client must not receive frames after it.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return len(p), nil
+	}
+	n, err := c.Conn.Write(p)
+	c.frames.advance(p[:n])
+	c.lastActivity = time.Now()
+	return n, err
+}
+
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.closing = true
+	close(c.done)
+	c.mu.Unlock()
+
+	c.guard.untrack(c)
+	return c.Conn.Close()
+}
+
+// writeControl writes a control frame if the backend is not in the middle
+// of a frame, reporting whether it did.
+func (c *Conn) writeControl(frame []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeControlLocked(frame)
+}
+
+func (c *Conn) writeControlLocked(frame []byte) bool {
+	if c.closing || !c.frames.atBoundary() {
+		return false
+	}
+	c.Conn.SetWriteDeadline(time.Now().Add(closeWait))
+	_, err := c.Conn.Write(frame)
+	c.Conn.SetWriteDeadline(time.Time{})
+	return err == nil
+}
+
+// sendClose sends a close frame if the backend is between frames, reporting
+// whether it did. Data from the backend is dropped from then on.
+func (c *Conn) sendClose(code int, reason string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.writeControlLocked(closeFrame(code, reason)) {
+		return false
+	}
+	c.closing = true
+	return true
+}
+
+// CloseWithFrame sends a close frame and cuts the connection once the client
+// has had a moment to see it. If the backend is mid-frame the connection is
+// cut without a close frame.
+func (c *Conn) CloseWithFrame(code int, reason string) {
+	if !c.sendClose(code, reason) {
+		c.Close()
+		return
+	}
+	time.AfterFunc(closeWait, func() { c.Close() })
+}
+
+// supervise pings the client and enforces the idle timeout until the
+// connection closes.
+func (c *Conn) supervise(idle, ping time.Duration) {
+	tick := ping
+	if idle > 0 && (tick == 0 || idle/2 < tick) {
+		tick = idle / 2
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	lastPing := time.Now()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			last := c.lastActivity
+			c.mu.Unlock()
+			if idle > 0 && now.Sub(last) >= idle {
+				c.CloseWithFrame(CloseGoingAway, "idle timeout")
+				return
+			}
+			if ping > 0 && now.Sub(lastPing) >= ping {
+				// A ping skipped because the backend is mid-frame is retried
+				// on the next tick.
+				if c.writeControl([]byte{0x89, 0x00}) {
+					lastPing = now
+				}
+			}
+		}
+	}
+}
+
+func closeFrame(code int, reason string) []byte {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	frame := []byte{0x88, byte(2 + len(reason)), byte(code >> 8), byte(code)}
+	return append(frame, reason...)
+}
+
+// frameTracker follows frame boundaries in a stream of WebSocket frames.
+type frameTracker struct {
+	header    []byte
+	remaining uint64
+}
+
+func (f *frameTracker) atBoundary() bool {
+	return f.remaining == 0 && len(f.header) == 0
+}
+
+func (f *frameTracker) advance(p []byte) {
+	for len(p) > 0 {
+		if f.remaining > 0 {
+			n := uint64(len(p))
+			if n > f.remaining {
+				n = f.remaining
+			}
+			p = p[n:]
+			f.remaining -= n
+			continue
+		}
+		f.header = append(f.header, p[0])
+		p = p[1:]
+		if need := headerLen(f.header); len(f.header) == need {
+			f.remaining = payloadLen(f.header)
+			f.header = f.header[:0]
+		}
+	}
+}
+
+// headerLen returns the length of the frame header starting with h, or 2
+// until the length byte is known.
+func headerLen(h []byte) int {
+	if len(h) < 2 {
+		return 2
+	}
+	n := 2
+	switch h[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if h[1]&0x80 != 0 {
+		n += 4
+	}
+	return n
+}
+
+func payloadLen(h []byte) uint64 {
+	switch l := h[1] & 0x7f; l {
+	case 126:
+		return uint64(h[2])<<8 | uint64(h[3])
+	case 127:
+		var n uint64
+		for _, b := range h[2:10] {
+			n = n<<8 | uint64(b)
+		}
+		return n
+	default:
+		return uint64(l)
+	}
+}
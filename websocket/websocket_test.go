@@ -0,0 +1,184 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoBackend completes the upgrade and echoes everything it reads.
+func echoBackend(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		brw.Flush()
+		io.Copy(conn, brw)
+	}))
+}
+
+func proxyWith(t *testing.T, cfg Config) (*httptest.Server, *Guard) {
+	backend := echoBackend(t)
+	t.Cleanup(backend.Close)
+	target, _ := url.Parse(backend.URL)
+	guard := NewGuard(cfg)
+	front := httptest.NewServer(guard.Middleware(httputil.NewSingleHostReverseProxy(target)))
+	t.Cleanup(front.Close)
+	return front, guard
+}
+
+// dial performs an upgrade through the proxy, returning the connection and
+// the response status.
+func dial(t *testing.T, server *httptest.Server) (net.Conn, *bufio.Reader, int) {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("Failed to read upgrade response: %v", err)
+	}
+	return conn, br, res.StatusCode
+}
+
+func TestMaxConns(t *testing.T) {
+	server, guard := proxyWith(t, Config{MaxConns: 1})
+
+	conn, _, status := dial(t, server)
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected upgrade, got %d", status)
+	}
+	if _, _, status := dial(t, server); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected second upgrade to be refused, got %d", status)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for guard.Count() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, _, status := dial(t, server); status != http.StatusSwitchingProtocols {
+		t.Errorf("Expected upgrade after the first connection closed, got %d", status)
+	}
+}
+
+func TestIdleTimeoutSendsCloseFrame(t *testing.T) {
+	server, _ := proxyWith(t, Config{IdleTimeout: Duration(100 * time.Millisecond)})
+	conn, br, _ := dial(t, server)
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame := make([]byte, 4)
+	if _, err := io.ReadFull(br, frame); err != nil {
+		t.Fatalf("Expected a close frame: %v", err)
+	}
+	if frame[0] != 0x88 || int(frame[2])<<8|int(frame[3]) != CloseGoingAway {
+		t.Errorf("Unexpected frame % x", frame)
+	}
+}
+
+func TestPingsBetweenFrames(t *testing.T) {
+	server, _ := proxyWith(t, Config{PingInterval: Duration(30 * time.Millisecond)})
+	conn, br, _ := dial(t, server)
+	defer conn.Close()
+
+	// A masked client text frame, echoed back unchanged by the backend.
+	payload := strings.Repeat("x", 200)
+	frame := append([]byte{0x81, 0x80 | 126, 0, 200, 0, 0, 0, 0}, payload...)
+	conn.Write(frame)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	sawPing, sawEcho := false, false
+	for !(sawPing && sawEcho) {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(br, header); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		switch header[0] {
+		case 0x89:
+			sawPing = true
+		case 0x81:
+			rest := make([]byte, 2+4+200)
+			if _, err := io.ReadFull(br, rest); err != nil || string(rest[6:]) != payload {
+				t.Fatalf("Echoed frame was corrupted: %v", err)
+			}
+			sawEcho = true
+		default:
+			t.Fatalf("Unexpected frame % x", header)
+		}
+	}
+}
+
+func TestFrameTracker(t *testing.T) {
+	var f frameTracker
+	stream := []byte{0x82, 126, 0x01, 0x00}
+	stream = append(stream, make([]byte, 256)...)
+	stream = append(stream, 0x81, 3, 'a', 'b', 'c')
+
+	for i, b := range stream {
+		f.advance([]byte{b})
+		boundary := i == 259 || i == len(stream)-1
+		if f.atBoundary() != boundary {
+			t.Fatalf("At byte %d: expected boundary=%v", i, boundary)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	server, guard := proxyWith(t, Config{})
+	conn, br, _ := dial(t, server)
+
+	done := make(chan error, 1)
+	go func() { done <- guard.Shutdown(context.Background(), "bye") }()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	frame := make([]byte, 7)
+	if _, err := io.ReadFull(br, frame); err != nil {
+		t.Fatalf("Expected a close frame: %v", err)
+	}
+	if frame[0] != 0x88 || int(frame[2])<<8|int(frame[3]) != CloseGoingAway || string(frame[4:]) != "bye" {
+		t.Errorf("Unexpected frame % x", frame)
+	}
+	conn.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not return after the client closed")
+	}
+}
+
+func TestShutdownCutsAfterGrace(t *testing.T) {
+	server, guard := proxyWith(t, Config{})
+	conn, br, _ := dial(t, server)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := guard.Shutdown(ctx, "bye"); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data, _ := io.ReadAll(br)
+	if len(data) == 0 || data[0] != 0x88 {
+		t.Errorf("Expected a close frame before the cut, got % x", data)
+	}
+}